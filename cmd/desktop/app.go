@@ -42,7 +42,7 @@ type App struct {
 	ctx      context.Context
 	config   *config.Config
 	proxy    *proxy.Proxy
-	storage  *storage.SQLiteStorage
+	storage  storage.Storage
 	ctxMutex sync.RWMutex
 	trayIcon []byte
 
@@ -52,6 +52,9 @@ type App struct {
 	settings *service.SettingsService
 	webdav   *service.WebDAVService
 	backup   *service.BackupService
+	schedule *service.BackupScheduler
+	sync     *service.StatsSyncService
+	reports  *service.StatsReportService
 	archive  *service.ArchiveService
 	update   *service.UpdateService
 	terminal *service.TerminalService
@@ -122,6 +125,9 @@ func (a *App) startup(ctx context.Context) {
 
 	statsAdapter := storage.NewStatsStorageAdapter(sqliteStorage)
 	a.proxy = proxy.New(cfg, statsAdapter, deviceID)
+	a.proxy.SetResponseCache(service.NewResponseCacheAdapter(sqliteStorage))
+	a.proxy.SetMessageBatches(service.NewMessageBatchAdapter(sqliteStorage))
+	a.proxy.SetResponseStore(service.NewResponseStoreAdapter(sqliteStorage))
 
 	a.proxy.SetOnEndpointSuccess(func(endpointName string) {
 		runtime.EventsEmit(ctx, "endpoint:success", endpointName)
@@ -129,12 +135,23 @@ func (a *App) startup(ctx context.Context) {
 
 	// Initialize services
 	version := a.GetVersion()
-	a.stats = service.NewStatsService(a.proxy, a.config)
+	a.stats = service.NewStatsService(a.proxy, a.config, a.storage)
 	a.endpoint = service.NewEndpointService(a.config, a.proxy, a.storage)
+	a.proxy.SetModelLister(a.endpoint)
 	a.settings = service.NewSettingsService(a.config, a.storage)
 	a.webdav = service.NewWebDAVService(a.config, a.storage, version)
-	a.backup = service.NewBackupService(a.config, a.storage, version, a.webdav)
-	a.archive = service.NewArchiveService(a.storage)
+	a.backup = service.NewBackupService(a.config, a.storage, version)
+	a.schedule = service.NewBackupScheduler(a.backup)
+	a.schedule.SetOnStatus(func(status service.BackupRunStatus) {
+		runtime.EventsEmit(ctx, "backup:status", status)
+	})
+	a.schedule.Start()
+	a.sync = service.NewStatsSyncService(a.backup)
+	a.sync.Start()
+	a.reports = service.NewStatsReportService(a.backup)
+	a.reports.Start()
+	a.archive = service.NewArchiveService(a.config, a.storage)
+	a.archive.Start()
 	a.update = service.NewUpdateService(a.config, a.storage, version)
 	a.terminal = service.NewTerminalService(a.config, a.storage)
 
@@ -154,6 +171,18 @@ func (a *App) startup(ctx context.Context) {
 
 // shutdown is called when the app is closing
 func (a *App) shutdown(ctx context.Context) {
+	if a.schedule != nil {
+		a.schedule.Stop()
+	}
+	if a.sync != nil {
+		a.sync.Stop()
+	}
+	if a.reports != nil {
+		a.reports.Stop()
+	}
+	if a.archive != nil {
+		a.archive.Stop()
+	}
 	if a.proxy != nil {
 		a.proxy.Stop()
 	}
@@ -365,6 +394,29 @@ func (a *App) GetStatsTrend() string     { return a.stats.GetStatsTrend() }
 func (a *App) GetStatsTrendByPeriod(period string) string {
 	return a.stats.GetStatsTrendByPeriod(period)
 }
+func (a *App) GetStatsUsage(period, granularity, groupBy string) string {
+	return a.stats.GetStatsUsage(period, granularity, groupBy)
+}
+
+// ExportStats asks for a file and writes the stats selected by the JSON export options to it
+func (a *App) ExportStats(optionsJSON string) string {
+	var opts service.StatsExportOptions
+	if err := json.Unmarshal([]byte(optionsJSON), &opts); err != nil || opts.Normalize() != nil {
+		return `{"error":"stats_export_invalid_options"}`
+	}
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "Export Stats",
+		DefaultFilename: opts.Filename(),
+	})
+	if err != nil {
+		logger.Error("Failed to open save dialog: %v", err)
+		return `{"error":"stats_export_dialog_failed"}`
+	}
+	if path == "" {
+		return `{"cancelled":true}`
+	}
+	return a.stats.ExportStats(optionsJSON, path)
+}
 
 // ========== Endpoint Bindings ==========
 
@@ -375,6 +427,18 @@ func (a *App) RemoveEndpoint(index int) error { return a.endpoint.RemoveEndpoint
 func (a *App) UpdateEndpoint(index int, name, apiUrl, apiKey, transformer, model, remark string) error {
 	return a.endpoint.UpdateEndpoint(index, name, apiUrl, apiKey, transformer, model, remark)
 }
+func (a *App) UpdateEndpointAzure(index int, resource, deployment, apiVersion string) error {
+	return a.endpoint.UpdateEndpointAzure(index, resource, deployment, apiVersion)
+}
+func (a *App) UpdateEndpointRewriteRules(index int, rulesJSON string) error {
+	return a.endpoint.UpdateEndpointRewriteRules(index, rulesJSON)
+}
+func (a *App) UpdateEndpointCapabilities(index int, capsJSON string) error {
+	return a.endpoint.UpdateEndpointCapabilities(index, capsJSON)
+}
+func (a *App) DetectEndpointCapabilities(index int) string {
+	return a.endpoint.DetectEndpointCapabilities(index)
+}
 func (a *App) ToggleEndpoint(index int, enabled bool) error {
 	return a.endpoint.ToggleEndpoint(index, enabled)
 }
@@ -421,6 +485,23 @@ func (a *App) SetProxyURL(proxyURL string) error { return a.settings.SetProxyURL
 func (a *App) SaveSettings(settingsJSON string) error {
 	return a.settings.SaveSettings(settingsJSON)
 }
+func (a *App) GetContextGuard() string { return a.settings.GetContextGuard() }
+func (a *App) SetContextGuard(guardJSON string) error {
+	return a.settings.SetContextGuard(guardJSON)
+}
+func (a *App) GetResponseCache() string { return a.settings.GetResponseCache() }
+func (a *App) SetResponseCache(cacheJSON string) error {
+	return a.settings.SetResponseCache(cacheJSON)
+}
+func (a *App) ClearResponseCache() error { return a.settings.ClearResponseCache() }
+func (a *App) GetToolRepair() string     { return a.settings.GetToolRepair() }
+func (a *App) SetToolRepair(repairJSON string) error {
+	return a.settings.SetToolRepair(repairJSON)
+}
+func (a *App) GetServerTools() string { return a.settings.GetServerTools() }
+func (a *App) SetServerTools(toolsJSON string) error {
+	return a.settings.SetServerTools(toolsJSON)
+}
 
 // ========== WebDAV Bindings ==========
 
@@ -453,6 +534,9 @@ func (a *App) UpdateLocalBackupDir(dir string) error { return a.backup.UpdateLoc
 func (a *App) UpdateS3BackupConfig(endpoint, region, bucket, prefix, accessKey, secretKey, sessionToken string, useSSL, forcePathStyle bool) error {
 	return a.backup.UpdateS3BackupConfig(endpoint, region, bucket, prefix, accessKey, secretKey, sessionToken, useSSL, forcePathStyle)
 }
+func (a *App) UpdateSFTPBackupConfig(host string, port int, username, password, privateKey, passphrase, dir, hostKey string) error {
+	return a.backup.UpdateSFTPBackupConfig(host, port, username, password, privateKey, passphrase, dir, hostKey)
+}
 func (a *App) ListBackups(provider string) string { return a.backup.ListBackups(provider) }
 func (a *App) DeleteBackups(provider string, filenames []string) error {
 	return a.backup.DeleteBackups(provider, filenames)
@@ -460,6 +544,9 @@ func (a *App) DeleteBackups(provider string, filenames []string) error {
 func (a *App) BackupToProvider(provider, filename string) error {
 	return a.backup.BackupToProvider(provider, filename)
 }
+func (a *App) BackupDeltaToProvider(provider, filename string) error {
+	return a.backup.BackupDeltaToProvider(provider, filename)
+}
 func (a *App) DetectBackupConflict(provider, filename string) string {
 	return a.backup.DetectBackupConflict(provider, filename)
 }
@@ -471,17 +558,45 @@ func (a *App) RestoreFromProvider(provider, filename, choice string) error {
 func (a *App) TestS3Connection(endpoint, region, bucket, prefix, accessKey, secretKey, sessionToken string, useSSL, forcePathStyle bool) string {
 	return a.backup.TestS3Connection(endpoint, region, bucket, prefix, accessKey, secretKey, sessionToken, useSSL, forcePathStyle)
 }
+func (a *App) TestSFTPConnection(host string, port int, username, password, privateKey, passphrase, dir, hostKey string) string {
+	return a.backup.TestSFTPConnection(host, port, username, password, privateKey, passphrase, dir, hostKey)
+}
+func (a *App) GetBackupSchedules() string { return a.schedule.GetSchedules() }
+func (a *App) SetBackupSchedules(schedulesJSON string) error {
+	return a.schedule.SetSchedules(schedulesJSON)
+}
+func (a *App) GetBackupScheduleStatus() string          { return a.schedule.GetStatus() }
+func (a *App) RunScheduledBackup(provider string) error { return a.schedule.RunNow(provider) }
 
-// ========== Archive Bindings ==========
+// ========== Stats Sync Bindings ==========
 
-func (a *App) ListArchives() string                { return a.archive.ListArchives() }
-func (a *App) GetArchiveData(month string) string  { return a.archive.GetArchiveData(month) }
-func (a *App) GetArchiveTrend(month string) string { return a.archive.GetArchiveTrend(month) }
-func (a *App) DeleteArchive(month string) string   { return a.archive.DeleteArchive(month) }
-func (a *App) GenerateMockArchives(monthsCount int) string {
-	return a.archive.GenerateMockArchives(monthsCount)
+func (a *App) GetStatsSync() string                 { return a.sync.GetConfig() }
+func (a *App) SetStatsSync(configJSON string) error { return a.sync.SetConfig(configJSON) }
+func (a *App) GetStatsSyncStatus() string           { return a.sync.GetStatus() }
+func (a *App) SyncStatsNow() error                  { return a.sync.SyncNow() }
+func (a *App) GetStatsDevices() string              { return a.sync.GetDevices() }
+func (a *App) GetDeviceStats(deviceID, startDate, endDate string) string {
+	return a.sync.GetDeviceStats(deviceID, startDate, endDate)
 }
 
+// ========== Stats Report Bindings ==========
+
+func (a *App) GetStatsReport() string                 { return a.reports.GetConfig() }
+func (a *App) SetStatsReport(configJSON string) error { return a.reports.SetConfig(configJSON) }
+func (a *App) GetStatsReportStatus() string           { return a.reports.GetStatus() }
+func (a *App) RunStatsReport(month string) error      { return a.reports.RunNow(month) }
+
+// ========== Archive Bindings ==========
+
+func (a *App) ListArchives() string                    { return a.archive.ListArchives() }
+func (a *App) GetArchiveData(month string) string      { return a.archive.GetArchiveData(month) }
+func (a *App) GetArchiveTrend(month string) string     { return a.archive.GetArchiveTrend(month) }
+func (a *App) DeleteArchive(month string) string       { return a.archive.DeleteArchive(month) }
+func (a *App) GetStatsArchive() string                 { return a.archive.GetConfig() }
+func (a *App) SetStatsArchive(configJSON string) error { return a.archive.SetConfig(configJSON) }
+func (a *App) GetStatsArchiveStatus() string           { return a.archive.GetStatus() }
+func (a *App) RunStatsArchive() error                  { return a.archive.RunNow() }
+
 // ========== Update Bindings ==========
 
 func (a *App) CheckForUpdates() string   { return a.update.CheckForUpdates() }
@@ -63,6 +63,15 @@ export default {
         modelHelpOpenAI: 'Required: Specify the OpenAI model to use',
         modelHelpOpenAI2: 'Required: Specify the OpenAI model (Responses API)',
         modelHelpGemini: 'Required: Specify the Gemini model to use',
+        modelHelpAzure: 'Required: Specify the Azure OpenAI model or deployment name',
+        modelHelpOllama: 'Required: Specify the local model (API Key is optional)',
+        azureDeployment: 'Azure Deployment',
+        azureDeploymentHelp: 'Optional: Deployment name, defaults to the model',
+        azureApiVersion: 'Azure API Version',
+        azureApiVersionHelp: 'Optional: e.g., 2024-10-21',
+        rewriteRules: 'Rewrite Rules',
+        rewriteRulesHelp: 'Optional: JSON array of ordered rules (set_header, remove_header, set, delete, clamp, prefix, replace)',
+        rewriteRulesInvalid: 'Rewrite rules must be a JSON array',
         remark: 'Remark',
         remarkHelp: 'Optional: Add a remark for this endpoint',
         cancel: 'Cancel',
@@ -299,8 +308,12 @@ export default {
         remote: 'Remote',
         endpointHas: 'endpoint has conflicting configurations',
         endpointsHave: 'endpoints have conflicting configurations',
-        useRemoteDesc: 'Remote configuration will overwrite local conflicting endpoints',
-        keepLocalDesc: 'Keep local configuration, only add new endpoints from remote',
+        useRemoteDesc: 'Remote values win the listed conflicts; non-conflicting changes from both sides are merged automatically',
+        keepLocalDesc: 'Local values win the listed conflicts; non-conflicting changes from both sides are merged automatically',
+        name: 'Name',
+        deleted: 'Deleted',
+        deletedValue: 'Deleted',
+        modifiedValue: 'Modified',
         // Error messages
         errors: {
             webdav_not_configured: 'WebDAV not configured',
@@ -365,6 +378,24 @@ export default {
             requiredFields: 'Please fill in required fields',
             saveFirst: 'Please save configuration first'
         },
+        sftp: {
+            title: 'SFTP Backup',
+            host: 'Host',
+            hostPlaceholder: 'e.g. backup.example.com',
+            port: 'Port',
+            username: 'Username',
+            password: 'Password',
+            privateKey: 'Private Key',
+            authHelp: 'Enter a password or a PEM private key; the key is tried first when both are set',
+            passphrase: 'Key Passphrase',
+            dir: 'Remote Directory',
+            dirPlaceholder: 'e.g. backups/ccNexus',
+            hostKey: 'Host Key Fingerprint',
+            hostKeyHelp: 'Get it with ssh-keygen -lf; leave empty to pin the key seen on the first backup',
+            testConnection: 'Test Connection',
+            requiredFields: 'Please fill in host, username, remote directory, and a password or private key',
+            saveFirst: 'Please save configuration first'
+        },
         errors: {
             backup_provider_invalid: 'Invalid backup provider',
             backup_local_dir_not_set: 'Local backup directory not set',
@@ -372,10 +403,16 @@ export default {
             backup_s3_not_configured: 'S3 not configured',
             backup_s3_endpoint_invalid: 'Invalid S3 endpoint (use host:port or https://host:port)',
             backup_s3_endpoint_path_not_supported: 'S3 endpoint must not contain path, please put it into Prefix',
+            backup_sftp_not_configured: 'SFTP not configured',
+            backup_sftp_port_invalid: 'Invalid SFTP port',
             s3_client_failed: 'Failed to create S3 client',
             create_backup_dir_failed: 'Failed to create backup directory',
             backup_write_failed: 'Failed to write backup file',
             backup_file_not_found: 'Backup file not found',
+            backup_checksum_mismatch: 'Backup is corrupted (checksum mismatch)',
+            backup_schema_incompatible: 'Backup was made by a newer version of ccNexus',
+            backup_archive_invalid: 'Invalid backup archive',
+            backup_base_missing: 'The full backup this incremental backup builds on is missing',
             filename_required: 'Filename is required',
             delete_backup_failed: 'Failed to delete backup',
             create_db_backup_failed: 'Failed to create database backup',
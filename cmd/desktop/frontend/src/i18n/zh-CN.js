@@ -63,6 +63,15 @@ export default {
         modelHelpOpenAI: '必填：指定要使用的 OpenAI 模型',
         modelHelpOpenAI2: '必填：指定 OpenAI 模型（Responses API）',
         modelHelpGemini: '必填：指定要使用的 Gemini 模型',
+        modelHelpAzure: '必填：指定 Azure OpenAI 模型或部署名称',
+        modelHelpOllama: '必填：指定本地模型（API Key 可选）',
+        azureDeployment: 'Azure 部署名称',
+        azureDeploymentHelp: '可选：部署名称，默认与模型相同',
+        azureApiVersion: 'Azure API 版本',
+        azureApiVersionHelp: '可选：例如 2024-10-21',
+        rewriteRules: '改写规则',
+        rewriteRulesHelp: '可选：按顺序执行的 JSON 规则数组（set_header、remove_header、set、delete、clamp、prefix、replace）',
+        rewriteRulesInvalid: '改写规则必须是 JSON 数组',
         remark: '备注',
         remarkHelp: '可选：为此端点添加备注说明',
         cancel: '取消',
@@ -298,8 +307,12 @@ export default {
         remote: '远程',
         endpointHas: '个端点存在冲突配置',
         endpointsHave: '个端点存在冲突配置',
-        useRemoteDesc: '远程配置将覆盖本地冲突的端点配置',
-        keepLocalDesc: '保留本地配置，仅从远程添加新端点',
+        useRemoteDesc: '列出的冲突使用远程值，双方不冲突的修改会自动合并',
+        keepLocalDesc: '列出的冲突保留本地值，双方不冲突的修改会自动合并',
+        name: '名称',
+        deleted: '删除',
+        deletedValue: '已删除',
+        modifiedValue: '已修改',
         inputFilename: '输入文件名',
         // 错误消息
         errors: {
@@ -365,6 +378,24 @@ export default {
             requiredFields: '请输入必填信息',
             saveFirst: '请先保存配置'
         },
+        sftp: {
+            title: 'SFTP备份',
+            host: '主机',
+            hostPlaceholder: '如：backup.example.com',
+            port: '端口',
+            username: '用户名',
+            password: '密码',
+            privateKey: '私钥',
+            authHelp: '填写密码或 PEM 格式的私钥，两者都填时先尝试私钥',
+            passphrase: '私钥密码',
+            dir: '远程目录',
+            dirPlaceholder: '如：backups/ccNexus',
+            hostKey: '主机密钥指纹',
+            hostKeyHelp: '可通过 ssh-keygen -lf 获取；留空则在首次备份时固定服务器的密钥',
+            testConnection: '测试连接',
+            requiredFields: '请填写主机、用户名、远程目录，以及密码或私钥',
+            saveFirst: '请先保存配置'
+        },
         errors: {
             backup_provider_invalid: '备份类型不合法',
             backup_local_dir_not_set: '未设置本地备份目录',
@@ -372,10 +403,16 @@ export default {
             backup_s3_not_configured: 'S3 未配置',
             backup_s3_endpoint_invalid: 'S3 Endpoint 无效（请填写 host:port 或 https://host:port）',
             backup_s3_endpoint_path_not_supported: 'S3 Endpoint 不支持包含路径，请将路径部分填写到 Prefix',
+            backup_sftp_not_configured: 'SFTP 未配置',
+            backup_sftp_port_invalid: 'SFTP 端口无效',
             s3_client_failed: '创建 S3 客户端失败',
             create_backup_dir_failed: '创建备份目录失败',
             backup_write_failed: '写入备份文件失败',
             backup_file_not_found: '备份文件不存在',
+            backup_checksum_mismatch: '备份已损坏（校验和不匹配）',
+            backup_schema_incompatible: '备份由更新版本的 ccNexus 创建，无法恢复',
+            backup_archive_invalid: '备份归档无效',
+            backup_base_missing: '增量备份依赖的完整备份不存在',
             filename_required: '文件名不能为空',
             delete_backup_failed: '删除备份失败',
             create_db_backup_failed: '创建数据库备份失败',
@@ -40,6 +40,14 @@ export async function updateEndpoint(index, name, url, key, transformer, model,
     await window.go.main.App.UpdateEndpoint(index, name, url, key, transformer, model, remark || '');
 }
 
+export async function updateEndpointAzure(index, deployment, apiVersion) {
+    await window.go.main.App.UpdateEndpointAzure(index, '', deployment || '', apiVersion || '');
+}
+
+export async function updateEndpointRewriteRules(index, rulesJSON) {
+    await window.go.main.App.UpdateEndpointRewriteRules(index, rulesJSON || '');
+}
+
 export async function removeEndpoint(index) {
     await window.go.main.App.RemoveEndpoint(index);
 }
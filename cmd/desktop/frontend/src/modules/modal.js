@@ -1,6 +1,6 @@
 import { t } from '../i18n/index.js';
 import { escapeHtml } from '../utils/format.js';
-import { addEndpoint, updateEndpoint, removeEndpoint, testEndpoint, testEndpointLight, updatePort } from './config.js';
+import { addEndpoint, updateEndpoint, updateEndpointAzure, updateEndpointRewriteRules, removeEndpoint, testEndpoint, testEndpointLight, updatePort } from './config.js';
 import { setTestState, clearTestState, saveEndpointTestStatus } from './endpoints.js';
 
 let currentEditIndex = -1;
@@ -106,6 +106,9 @@ export function showAddEndpointModal() {
     document.getElementById('endpointTransformer').value = 'claude';
     document.getElementById('endpointModel').value = '';
     document.getElementById('endpointRemark').value = '';
+    document.getElementById('endpointAzureDeployment').value = '';
+    document.getElementById('endpointAzureApiVersion').value = '';
+    document.getElementById('endpointRewriteRules').value = '';
     handleTransformerChange();
     document.getElementById('endpointModal').classList.add('active');
 }
@@ -125,6 +128,9 @@ export async function editEndpoint(index) {
     document.getElementById('endpointTransformer').value = ep.transformer || 'claude';
     document.getElementById('endpointModel').value = ep.model || '';
     document.getElementById('endpointRemark').value = ep.remark || '';
+    document.getElementById('endpointAzureDeployment').value = ep.azureDeployment || '';
+    document.getElementById('endpointAzureApiVersion').value = ep.azureApiVersion || '';
+    document.getElementById('endpointRewriteRules').value = ep.rewriteRules ? JSON.stringify(ep.rewriteRules, null, 2) : '';
 
     handleTransformerChange();
     document.getElementById('endpointModal').classList.add('active');
@@ -137,8 +143,11 @@ export async function saveEndpoint() {
     const transformer = document.getElementById('endpointTransformer').value;
     const model = document.getElementById('endpointModel').value.trim();
     const remark = document.getElementById('endpointRemark').value.trim();
+    const azureDeployment = document.getElementById('endpointAzureDeployment').value.trim();
+    const azureApiVersion = document.getElementById('endpointAzureApiVersion').value.trim();
+    const rewriteRules = document.getElementById('endpointRewriteRules').value.trim();
 
-    if (!name || !url || !key) {
+    if (!name || !url || (!key && transformer !== 'ollama')) {
         showError(t('modal.requiredFields'));
         return;
     }
@@ -148,6 +157,15 @@ export async function saveEndpoint() {
         return;
     }
 
+    if (rewriteRules) {
+        try {
+            if (!Array.isArray(JSON.parse(rewriteRules))) throw new Error('not an array');
+        } catch (e) {
+            showError(t('modal.rewriteRulesInvalid'));
+            return;
+        }
+    }
+
     // Check for duplicate endpoint name
     const configStr = await window.go.main.App.GetConfig();
     const config = JSON.parse(configStr);
@@ -161,11 +179,19 @@ export async function saveEndpoint() {
     }
 
     try {
+        let index = currentEditIndex;
         if (currentEditIndex === -1) {
             await addEndpoint(name, url, key, transformer, model, remark);
+            index = config.endpoints.length;
         } else {
             await updateEndpoint(currentEditIndex, name, url, key, transformer, model, remark);
         }
+        if (transformer.startsWith('azure')) {
+            await updateEndpointAzure(index, azureDeployment, azureApiVersion);
+        }
+        if (rewriteRules || currentEditIndex !== -1) {
+            await updateEndpointRewriteRules(index, rewriteRules);
+        }
 
         closeModal();
         window.loadConfig();
@@ -222,7 +248,17 @@ export function handleTransformerChange() {
         modelRequired.style.display = 'inline';
         modelInput.placeholder = 'e.g., gemini-pro';
         modelHelpText.textContent = t('modal.modelHelpGemini');
+    } else if (transformer === 'azure' || transformer === 'azure2') {
+        modelRequired.style.display = 'inline';
+        modelInput.placeholder = 'e.g., gpt-4o';
+        modelHelpText.textContent = t('modal.modelHelpAzure');
+    } else if (transformer === 'ollama') {
+        modelRequired.style.display = 'inline';
+        modelInput.placeholder = 'e.g., qwen3:8b';
+        modelHelpText.textContent = t('modal.modelHelpOllama');
     }
+
+    document.getElementById('azureFieldGroup').style.display = transformer.startsWith('azure') ? 'block' : 'none';
 }
 
 // Store fetched models for filtering
@@ -243,7 +279,7 @@ export async function fetchModels() {
         showNotification(t('modal.fetchModelsNoUrl'), 'error');
         return;
     }
-    if (!apiKey) {
+    if (!apiKey && transformer !== 'ollama') {
         showNotification(t('modal.fetchModelsNoKey'), 'error');
         return;
     }
@@ -335,6 +335,9 @@ export function initUI() {
                             <option value="openai">OpenAI</option>
                             <option value="openai2">OpenAI2 (Responses API)</option>
                             <option value="gemini">Gemini</option>
+                            <option value="azure">Azure OpenAI</option>
+                            <option value="azure2">Azure OpenAI (Responses API)</option>
+                            <option value="ollama">Ollama</option>
                         </select>
                         <p style="color: #666; font-size: 12px; margin-top: 5px;">
                             ${t('modal.transformerHelp')}
@@ -360,10 +363,21 @@ export function initUI() {
                             ${t('modal.modelHelp')}
                         </p>
                     </div>
+                    <div class="form-group" id="azureFieldGroup" style="display: none;">
+                        <label>${t('modal.azureDeployment')}</label>
+                        <input type="text" id="endpointAzureDeployment" placeholder="${t('modal.azureDeploymentHelp')}">
+                        <label style="margin-top: 10px;">${t('modal.azureApiVersion')}</label>
+                        <input type="text" id="endpointAzureApiVersion" placeholder="${t('modal.azureApiVersionHelp')}">
+                    </div>
                     <div class="form-group">
                         <label>${t('modal.remark')}</label>
                         <input type="text" id="endpointRemark" placeholder="${t('modal.remarkHelp')}">
                     </div>
+                    <div class="form-group">
+                        <label>${t('modal.rewriteRules')}</label>
+                        <textarea id="endpointRewriteRules" rows="4" style="width: 100%; font-family: monospace; font-size: 12px;" placeholder='[{"action": "clamp", "path": "$.max_tokens", "max": 8192}]'></textarea>
+                        <p style="color: #666; font-size: 12px; margin-top: 5px;">${t('modal.rewriteRulesHelp')}</p>
+                    </div>
                 </div>
                 <div class="modal-footer">
                     <button class="btn btn-secondary" onclick="window.closeModal()">${t('modal.cancel')}</button>
@@ -7,6 +7,8 @@ export function AddProjectDir(arg1:string):Promise<void>;
 
 export function ApplyUpdate(arg1:string):Promise<string>;
 
+export function BackupDeltaToProvider(arg1:string,arg2:string):Promise<void>;
+
 export function BackupToProvider(arg1:string,arg2:string):Promise<void>;
 
 export function BackupToWebDAV(arg1:string):Promise<void>;
@@ -17,6 +19,8 @@ export function CheckForUpdates():Promise<string>;
 
 export function ClearLogs():Promise<void>;
 
+export function ClearResponseCache():Promise<void>;
+
 export function DeleteArchive(arg1:string):Promise<string>;
 
 export function DeleteBackups(arg1:string,arg2:Array<string>):Promise<void>;
@@ -29,20 +33,22 @@ export function DeleteWebDAVBackups(arg1:Array<string>):Promise<void>;
 
 export function DetectBackupConflict(arg1:string,arg2:string):Promise<string>;
 
+export function DetectEndpointCapabilities(arg1:number):Promise<string>;
+
 export function DetectTerminals():Promise<string>;
 
 export function DetectWebDAVConflict(arg1:string):Promise<string>;
 
 export function DownloadUpdate(arg1:string,arg2:string):Promise<void>;
 
+export function ExportStats(arg1:string):Promise<string>;
+
 export function FetchBroadcast(arg1:string):Promise<string>;
 
 export function FetchImageAsBase64(arg1:string):Promise<string>;
 
 export function FetchModels(arg1:string,arg2:string,arg3:string):Promise<string>;
 
-export function GenerateMockArchives(arg1:number):Promise<string>;
-
 export function GetArchiveData(arg1:string):Promise<string>;
 
 export function GetArchiveTrend(arg1:string):Promise<string>;
@@ -51,6 +57,10 @@ export function GetAutoDarkTheme():Promise<string>;
 
 export function GetAutoLightTheme():Promise<string>;
 
+export function GetBackupScheduleStatus():Promise<string>;
+
+export function GetBackupSchedules():Promise<string>;
+
 export function GetChangelog(arg1:string):Promise<string>;
 
 export function GetCodexSessionData(arg1:string):Promise<string>;
@@ -59,8 +69,12 @@ export function GetCodexSessions(arg1:string):Promise<string>;
 
 export function GetConfig():Promise<string>;
 
+export function GetContextGuard():Promise<string>;
+
 export function GetCurrentEndpoint():Promise<string>;
 
+export function GetDeviceStats(arg1:string,arg2:string,arg3:string):Promise<string>;
+
 export function GetDownloadProgress():Promise<string>;
 
 export function GetLanguage():Promise<string>;
@@ -73,20 +87,40 @@ export function GetLogsByLevel(arg1:number):Promise<string>;
 
 export function GetProxyURL():Promise<string>;
 
+export function GetResponseCache():Promise<string>;
+
+export function GetServerTools():Promise<string>;
+
 export function GetSessionData(arg1:string,arg2:string):Promise<string>;
 
 export function GetSessions(arg1:string):Promise<string>;
 
 export function GetStats():Promise<string>;
 
+export function GetStatsArchive():Promise<string>;
+
+export function GetStatsArchiveStatus():Promise<string>;
+
 export function GetStatsDaily():Promise<string>;
 
+export function GetStatsDevices():Promise<string>;
+
 export function GetStatsMonthly():Promise<string>;
 
+export function GetStatsReport():Promise<string>;
+
+export function GetStatsReportStatus():Promise<string>;
+
+export function GetStatsSync():Promise<string>;
+
+export function GetStatsSyncStatus():Promise<string>;
+
 export function GetStatsTrend():Promise<string>;
 
 export function GetStatsTrendByPeriod(arg1:string):Promise<string>;
 
+export function GetStatsUsage(arg1:string,arg2:string,arg3:string):Promise<string>;
+
 export function GetStatsWeekly():Promise<string>;
 
 export function GetStatsYesterday():Promise<string>;
@@ -99,6 +133,8 @@ export function GetTheme():Promise<string>;
 
 export function GetThemeAuto():Promise<boolean>;
 
+export function GetToolRepair():Promise<string>;
+
 export function GetUpdateSettings():Promise<string>;
 
 export function GetVersion():Promise<string>;
@@ -139,6 +175,12 @@ export function RestoreFromProvider(arg1:string,arg2:string,arg3:string):Promise
 
 export function RestoreFromWebDAV(arg1:string,arg2:string):Promise<void>;
 
+export function RunScheduledBackup(arg1:string):Promise<void>;
+
+export function RunStatsArchive():Promise<void>;
+
+export function RunStatsReport(arg1:string):Promise<void>;
+
 export function SaveSettings(arg1:string):Promise<void>;
 
 export function SaveTerminalConfig(arg1:string,arg2:Array<string>):Promise<void>;
@@ -151,18 +193,34 @@ export function SetAutoDarkTheme(arg1:string):Promise<void>;
 
 export function SetAutoLightTheme(arg1:string):Promise<void>;
 
+export function SetBackupSchedules(arg1:string):Promise<void>;
+
 export function SetCloseWindowBehavior(arg1:string):Promise<void>;
 
+export function SetContextGuard(arg1:string):Promise<void>;
+
 export function SetLanguage(arg1:string):Promise<void>;
 
 export function SetLogLevel(arg1:number):Promise<void>;
 
 export function SetProxyURL(arg1:string):Promise<void>;
 
+export function SetResponseCache(arg1:string):Promise<void>;
+
+export function SetServerTools(arg1:string):Promise<void>;
+
+export function SetStatsArchive(arg1:string):Promise<void>;
+
+export function SetStatsReport(arg1:string):Promise<void>;
+
+export function SetStatsSync(arg1:string):Promise<void>;
+
 export function SetTheme(arg1:string):Promise<void>;
 
 export function SetThemeAuto(arg1:boolean):Promise<void>;
 
+export function SetToolRepair(arg1:string):Promise<void>;
+
 export function SetUpdateSettings(arg1:boolean,arg2:number):Promise<void>;
 
 export function ShowWindow():Promise<void>;
@@ -171,6 +229,8 @@ export function SkipVersion(arg1:string):Promise<void>;
 
 export function SwitchToEndpoint(arg1:string):Promise<void>;
 
+export function SyncStatsNow():Promise<void>;
+
 export function TestAllEndpointsZeroCost():Promise<string>;
 
 export function TestEndpoint(arg1:number):Promise<string>;
@@ -179,6 +239,8 @@ export function TestEndpointLight(arg1:number):Promise<string>;
 
 export function TestS3Connection(arg1:string,arg2:string,arg3:string,arg4:string,arg5:string,arg6:string,arg7:string,arg8:boolean,arg9:boolean):Promise<string>;
 
+export function TestSFTPConnection(arg1:string,arg2:number,arg3:string,arg4:string,arg5:string,arg6:string,arg7:string,arg8:string):Promise<string>;
+
 export function TestWebDAVConnection(arg1:string,arg2:string,arg3:string):Promise<string>;
 
 export function ToggleEndpoint(arg1:number,arg2:boolean):Promise<void>;
@@ -189,10 +251,18 @@ export function UpdateConfig(arg1:string):Promise<void>;
 
 export function UpdateEndpoint(arg1:number,arg2:string,arg3:string,arg4:string,arg5:string,arg6:string,arg7:string):Promise<void>;
 
+export function UpdateEndpointAzure(arg1:number,arg2:string,arg3:string,arg4:string):Promise<void>;
+
+export function UpdateEndpointCapabilities(arg1:number,arg2:string):Promise<void>;
+
+export function UpdateEndpointRewriteRules(arg1:number,arg2:string):Promise<void>;
+
 export function UpdateLocalBackupDir(arg1:string):Promise<void>;
 
 export function UpdatePort(arg1:number):Promise<void>;
 
 export function UpdateS3BackupConfig(arg1:string,arg2:string,arg3:string,arg4:string,arg5:string,arg6:string,arg7:string,arg8:boolean,arg9:boolean):Promise<void>;
 
+export function UpdateSFTPBackupConfig(arg1:string,arg2:number,arg3:string,arg4:string,arg5:string,arg6:string,arg7:string,arg8:string):Promise<void>;
+
 export function UpdateWebDAVConfig(arg1:string,arg2:string,arg3:string):Promise<void>;
@@ -14,6 +14,10 @@ export function ApplyUpdate(arg1) {
   return window['go']['main']['App']['ApplyUpdate'](arg1);
 }
 
+export function BackupDeltaToProvider(arg1, arg2) {
+  return window['go']['main']['App']['BackupDeltaToProvider'](arg1, arg2);
+}
+
 export function BackupToProvider(arg1, arg2) {
   return window['go']['main']['App']['BackupToProvider'](arg1, arg2);
 }
@@ -34,6 +38,10 @@ export function ClearLogs() {
   return window['go']['main']['App']['ClearLogs']();
 }
 
+export function ClearResponseCache() {
+  return window['go']['main']['App']['ClearResponseCache']();
+}
+
 export function DeleteArchive(arg1) {
   return window['go']['main']['App']['DeleteArchive'](arg1);
 }
@@ -58,6 +66,10 @@ export function DetectBackupConflict(arg1, arg2) {
   return window['go']['main']['App']['DetectBackupConflict'](arg1, arg2);
 }
 
+export function DetectEndpointCapabilities(arg1) {
+  return window['go']['main']['App']['DetectEndpointCapabilities'](arg1);
+}
+
 export function DetectTerminals() {
   return window['go']['main']['App']['DetectTerminals']();
 }
@@ -70,6 +82,10 @@ export function DownloadUpdate(arg1, arg2) {
   return window['go']['main']['App']['DownloadUpdate'](arg1, arg2);
 }
 
+export function ExportStats(arg1) {
+  return window['go']['main']['App']['ExportStats'](arg1);
+}
+
 export function FetchBroadcast(arg1) {
   return window['go']['main']['App']['FetchBroadcast'](arg1);
 }
@@ -82,10 +98,6 @@ export function FetchModels(arg1, arg2, arg3) {
   return window['go']['main']['App']['FetchModels'](arg1, arg2, arg3);
 }
 
-export function GenerateMockArchives(arg1) {
-  return window['go']['main']['App']['GenerateMockArchives'](arg1);
-}
-
 export function GetArchiveData(arg1) {
   return window['go']['main']['App']['GetArchiveData'](arg1);
 }
@@ -102,6 +114,14 @@ export function GetAutoLightTheme() {
   return window['go']['main']['App']['GetAutoLightTheme']();
 }
 
+export function GetBackupScheduleStatus() {
+  return window['go']['main']['App']['GetBackupScheduleStatus']();
+}
+
+export function GetBackupSchedules() {
+  return window['go']['main']['App']['GetBackupSchedules']();
+}
+
 export function GetChangelog(arg1) {
   return window['go']['main']['App']['GetChangelog'](arg1);
 }
@@ -118,10 +138,18 @@ export function GetConfig() {
   return window['go']['main']['App']['GetConfig']();
 }
 
+export function GetContextGuard() {
+  return window['go']['main']['App']['GetContextGuard']();
+}
+
 export function GetCurrentEndpoint() {
   return window['go']['main']['App']['GetCurrentEndpoint']();
 }
 
+export function GetDeviceStats(arg1, arg2, arg3) {
+  return window['go']['main']['App']['GetDeviceStats'](arg1, arg2, arg3);
+}
+
 export function GetDownloadProgress() {
   return window['go']['main']['App']['GetDownloadProgress']();
 }
@@ -146,6 +174,14 @@ export function GetProxyURL() {
   return window['go']['main']['App']['GetProxyURL']();
 }
 
+export function GetResponseCache() {
+  return window['go']['main']['App']['GetResponseCache']();
+}
+
+export function GetServerTools() {
+  return window['go']['main']['App']['GetServerTools']();
+}
+
 export function GetSessionData(arg1, arg2) {
   return window['go']['main']['App']['GetSessionData'](arg1, arg2);
 }
@@ -158,14 +194,42 @@ export function GetStats() {
   return window['go']['main']['App']['GetStats']();
 }
 
+export function GetStatsArchive() {
+  return window['go']['main']['App']['GetStatsArchive']();
+}
+
+export function GetStatsArchiveStatus() {
+  return window['go']['main']['App']['GetStatsArchiveStatus']();
+}
+
 export function GetStatsDaily() {
   return window['go']['main']['App']['GetStatsDaily']();
 }
 
+export function GetStatsDevices() {
+  return window['go']['main']['App']['GetStatsDevices']();
+}
+
 export function GetStatsMonthly() {
   return window['go']['main']['App']['GetStatsMonthly']();
 }
 
+export function GetStatsReport() {
+  return window['go']['main']['App']['GetStatsReport']();
+}
+
+export function GetStatsReportStatus() {
+  return window['go']['main']['App']['GetStatsReportStatus']();
+}
+
+export function GetStatsSync() {
+  return window['go']['main']['App']['GetStatsSync']();
+}
+
+export function GetStatsSyncStatus() {
+  return window['go']['main']['App']['GetStatsSyncStatus']();
+}
+
 export function GetStatsTrend() {
   return window['go']['main']['App']['GetStatsTrend']();
 }
@@ -174,6 +238,10 @@ export function GetStatsTrendByPeriod(arg1) {
   return window['go']['main']['App']['GetStatsTrendByPeriod'](arg1);
 }
 
+export function GetStatsUsage(arg1, arg2, arg3) {
+  return window['go']['main']['App']['GetStatsUsage'](arg1, arg2, arg3);
+}
+
 export function GetStatsWeekly() {
   return window['go']['main']['App']['GetStatsWeekly']();
 }
@@ -198,6 +266,10 @@ export function GetThemeAuto() {
   return window['go']['main']['App']['GetThemeAuto']();
 }
 
+export function GetToolRepair() {
+  return window['go']['main']['App']['GetToolRepair']();
+}
+
 export function GetUpdateSettings() {
   return window['go']['main']['App']['GetUpdateSettings']();
 }
@@ -278,6 +350,18 @@ export function RestoreFromWebDAV(arg1, arg2) {
   return window['go']['main']['App']['RestoreFromWebDAV'](arg1, arg2);
 }
 
+export function RunScheduledBackup(arg1) {
+  return window['go']['main']['App']['RunScheduledBackup'](arg1);
+}
+
+export function RunStatsArchive() {
+  return window['go']['main']['App']['RunStatsArchive']();
+}
+
+export function RunStatsReport(arg1) {
+  return window['go']['main']['App']['RunStatsReport'](arg1);
+}
+
 export function SaveSettings(arg1) {
   return window['go']['main']['App']['SaveSettings'](arg1);
 }
@@ -302,10 +386,18 @@ export function SetAutoLightTheme(arg1) {
   return window['go']['main']['App']['SetAutoLightTheme'](arg1);
 }
 
+export function SetBackupSchedules(arg1) {
+  return window['go']['main']['App']['SetBackupSchedules'](arg1);
+}
+
 export function SetCloseWindowBehavior(arg1) {
   return window['go']['main']['App']['SetCloseWindowBehavior'](arg1);
 }
 
+export function SetContextGuard(arg1) {
+  return window['go']['main']['App']['SetContextGuard'](arg1);
+}
+
 export function SetLanguage(arg1) {
   return window['go']['main']['App']['SetLanguage'](arg1);
 }
@@ -318,6 +410,26 @@ export function SetProxyURL(arg1) {
   return window['go']['main']['App']['SetProxyURL'](arg1);
 }
 
+export function SetResponseCache(arg1) {
+  return window['go']['main']['App']['SetResponseCache'](arg1);
+}
+
+export function SetServerTools(arg1) {
+  return window['go']['main']['App']['SetServerTools'](arg1);
+}
+
+export function SetStatsArchive(arg1) {
+  return window['go']['main']['App']['SetStatsArchive'](arg1);
+}
+
+export function SetStatsReport(arg1) {
+  return window['go']['main']['App']['SetStatsReport'](arg1);
+}
+
+export function SetStatsSync(arg1) {
+  return window['go']['main']['App']['SetStatsSync'](arg1);
+}
+
 export function SetTheme(arg1) {
   return window['go']['main']['App']['SetTheme'](arg1);
 }
@@ -326,6 +438,10 @@ export function SetThemeAuto(arg1) {
   return window['go']['main']['App']['SetThemeAuto'](arg1);
 }
 
+export function SetToolRepair(arg1) {
+  return window['go']['main']['App']['SetToolRepair'](arg1);
+}
+
 export function SetUpdateSettings(arg1, arg2) {
   return window['go']['main']['App']['SetUpdateSettings'](arg1, arg2);
 }
@@ -342,6 +458,10 @@ export function SwitchToEndpoint(arg1) {
   return window['go']['main']['App']['SwitchToEndpoint'](arg1);
 }
 
+export function SyncStatsNow() {
+  return window['go']['main']['App']['SyncStatsNow']();
+}
+
 export function TestAllEndpointsZeroCost() {
   return window['go']['main']['App']['TestAllEndpointsZeroCost']();
 }
@@ -358,6 +478,10 @@ export function TestS3Connection(arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8,
   return window['go']['main']['App']['TestS3Connection'](arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8, arg9);
 }
 
+export function TestSFTPConnection(arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8) {
+  return window['go']['main']['App']['TestSFTPConnection'](arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8);
+}
+
 export function TestWebDAVConnection(arg1, arg2, arg3) {
   return window['go']['main']['App']['TestWebDAVConnection'](arg1, arg2, arg3);
 }
@@ -378,6 +502,18 @@ export function UpdateEndpoint(arg1, arg2, arg3, arg4, arg5, arg6, arg7) {
   return window['go']['main']['App']['UpdateEndpoint'](arg1, arg2, arg3, arg4, arg5, arg6, arg7);
 }
 
+export function UpdateEndpointAzure(arg1, arg2, arg3, arg4) {
+  return window['go']['main']['App']['UpdateEndpointAzure'](arg1, arg2, arg3, arg4);
+}
+
+export function UpdateEndpointCapabilities(arg1, arg2) {
+  return window['go']['main']['App']['UpdateEndpointCapabilities'](arg1, arg2);
+}
+
+export function UpdateEndpointRewriteRules(arg1, arg2) {
+  return window['go']['main']['App']['UpdateEndpointRewriteRules'](arg1, arg2);
+}
+
 export function UpdateLocalBackupDir(arg1) {
   return window['go']['main']['App']['UpdateLocalBackupDir'](arg1);
 }
@@ -390,6 +526,10 @@ export function UpdateS3BackupConfig(arg1, arg2, arg3, arg4, arg5, arg6, arg7, a
   return window['go']['main']['App']['UpdateS3BackupConfig'](arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8, arg9);
 }
 
+export function UpdateSFTPBackupConfig(arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8) {
+  return window['go']['main']['App']['UpdateSFTPBackupConfig'](arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8);
+}
+
 export function UpdateWebDAVConfig(arg1, arg2, arg3) {
   return window['go']['main']['App']['UpdateWebDAVConfig'](arg1, arg2, arg3);
 }
@@ -2,7 +2,9 @@ package main
 
 import (
     "errors"
+    "fmt"
     "net/http"
+    "net/url"
     "os"
     "os/signal"
     "path/filepath"
@@ -12,9 +14,13 @@ import (
     "github.com/lich0821/ccNexus/internal/config"
     "github.com/lich0821/ccNexus/internal/logger"
     "github.com/lich0821/ccNexus/internal/proxy"
+    "github.com/lich0821/ccNexus/internal/service"
     "github.com/lich0821/ccNexus/internal/storage"
 )
 
+// version is recorded in backup metadata; set it with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
     dataDir := resolveDataDir()
     if err := os.MkdirAll(dataDir, 0755); err != nil {
@@ -22,19 +28,22 @@ func main() {
         os.Exit(1)
     }
 
-    dbPath := os.Getenv("CCNEXUS_DB_PATH")
-    if dbPath == "" {
-        dbPath = filepath.Join(dataDir, "ccnexus.db")
-    }
-
-    sqliteStorage, err := storage.NewSQLiteStorage(dbPath)
+    store, dbLocation, err := openStorage(dataDir)
     if err != nil {
-        logger.Error("Failed to open SQLite storage: %v", err)
+        logger.Error("Failed to open storage: %v", err)
         os.Exit(1)
     }
-    defer sqliteStorage.Close()
+    defer store.Close()
 
-    cfg, err := loadConfig(sqliteStorage)
+    // Data of an earlier SQLite installation can be imported, e.g. when moving to PostgreSQL
+    if importPath := os.Getenv("CCNEXUS_IMPORT_SQLITE"); importPath != "" {
+        if err := importSQLite(store, importPath); err != nil {
+            logger.Error("Failed to import %s: %v", importPath, err)
+            os.Exit(1)
+        }
+    }
+
+    cfg, err := loadConfig(store)
     if err != nil {
         logger.Error("Unable to load configuration: %v", err)
         os.Exit(1)
@@ -48,21 +57,45 @@ func main() {
         os.Exit(1)
     }
 
-    deviceID, err := sqliteStorage.GetOrCreateDeviceID()
+    deviceID, err := store.GetOrCreateDeviceID()
     if err != nil {
         logger.Warn("Failed to get device ID: %v, using default", err)
         deviceID = "default"
     }
 
-    statsAdapter := storage.NewStatsStorageAdapter(sqliteStorage)
+    statsAdapter := storage.NewStatsStorageAdapter(store)
     p := proxy.New(cfg, statsAdapter, deviceID)
+    p.SetResponseCache(service.NewResponseCacheAdapter(store))
+    p.SetMessageBatches(service.NewMessageBatchAdapter(store))
+    p.SetResponseStore(service.NewResponseStoreAdapter(store))
+
+    // Scheduled backups run in the headless server as well
+    backupService := service.NewBackupService(cfg, store, version)
+    backupScheduler := service.NewBackupScheduler(backupService)
+    backupScheduler.Start()
+    defer backupScheduler.Stop()
+
+    // Stats of other devices are pulled (and ours pushed) through the backup providers
+    statsSync := service.NewStatsSyncService(backupService)
+    statsSync.Start()
+    defer statsSync.Stop()
+
+    // The stats of each month are reported to a backup provider when the next one starts
+    statsReport := service.NewStatsReportService(backupService)
+    statsReport.Start()
+    defer statsReport.Stop()
+
+    // Daily stats older than the configured months are rolled up into monthly stats
+    archive := service.NewArchiveService(cfg, store)
+    archive.Start()
+    defer archive.Stop()
 
     // Create HTTP mux
     mux := http.NewServeMux()
 
     // Initialize and register Web UI (optional plugin)
     // If webui package is not available, this will be skipped at compile time
-    if err := registerWebUI(mux, cfg, p, sqliteStorage); err != nil {
+    if err := registerWebUI(mux, cfg, p, store, backupScheduler, statsSync, statsReport, archive); err != nil {
         logger.Warn("Web UI not available: %v", err)
     } else {
         logger.Info("Web UI available at /ui/")
@@ -73,7 +106,7 @@ func main() {
         errCh <- p.StartWithMux(mux)
     }()
 
-    logger.Info("ccNexus headless API listening on :%d (data dir: %s, db: %s)", cfg.GetPort(), dataDir, dbPath)
+    logger.Info("ccNexus headless API listening on :%d (data dir: %s, db: %s)", cfg.GetPort(), dataDir, dbLocation)
 
     sigCh := make(chan os.Signal, 1)
     signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -94,6 +127,37 @@ func main() {
     logger.Info("ccNexus stopped")
 }
 
+// sqliteImportKey records the SQLite database imported through CCNEXUS_IMPORT_SQLITE
+const sqliteImportKey = "sqlite_import"
+
+// importSQLite merges a SQLite database into the storage once. The imported file is recorded by
+// path, size and modification time, so restarts with the variable still set skip it while a
+// replaced file is imported again.
+func importSQLite(store storage.Storage, path string) error {
+    info, err := os.Stat(path)
+    if err != nil {
+        return err
+    }
+    absPath, err := filepath.Abs(path)
+    if err != nil {
+        absPath = path
+    }
+    marker := fmt.Sprintf("%s|%d|%d", absPath, info.Size(), info.ModTime().Unix())
+    if done, err := store.GetConfig(sqliteImportKey); err == nil && done == marker {
+        logger.Info("%s was already imported, skipping; remove CCNEXUS_IMPORT_SQLITE to silence this", path)
+        return nil
+    }
+
+    if err := store.MergeFromBackup(path, storage.MergeStrategyKeepLocal); err != nil {
+        return err
+    }
+    if err := store.SetConfig(sqliteImportKey, marker); err != nil {
+        logger.Warn("Failed to record the import of %s: %v", path, err)
+    }
+    logger.Info("Imported %s", path)
+    return nil
+}
+
 func resolveDataDir() string {
     if dir := os.Getenv("CCNEXUS_DATA_DIR"); dir != "" {
         return dir
@@ -104,8 +168,34 @@ func resolveDataDir() string {
     return "/data"
 }
 
-func loadConfig(sqliteStorage *storage.SQLiteStorage) (*config.Config, error) {
-    adapter := storage.NewConfigStorageAdapter(sqliteStorage)
+// openStorage opens PostgreSQL when CCNEXUS_DB_URL is set and the SQLite file otherwise. It also
+// returns where the data lives, without the password, for logging.
+func openStorage(dataDir string) (storage.Storage, string, error) {
+    if dbURL := os.Getenv("CCNEXUS_DB_URL"); dbURL != "" {
+        store, err := storage.NewPostgresStorage(dbURL)
+        if err != nil {
+            return nil, "", err
+        }
+        location := "postgres"
+        if u, err := url.Parse(dbURL); err == nil && u.Host != "" {
+            location = u.Redacted()
+        }
+        return store, location, nil
+    }
+
+    dbPath := os.Getenv("CCNEXUS_DB_PATH")
+    if dbPath == "" {
+        dbPath = filepath.Join(dataDir, "ccnexus.db")
+    }
+    store, err := storage.NewSQLiteStorage(dbPath)
+    if err != nil {
+        return nil, "", err
+    }
+    return store, dbPath, nil
+}
+
+func loadConfig(store storage.Storage) (*config.Config, error) {
+    adapter := storage.NewConfigStorageAdapter(store)
     cfg, err := config.LoadFromStorage(adapter)
     if err != nil {
         logger.Warn("Failed to load config from storage, using default: %v", err)
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// handleBackupSchedules handles GET and PUT for the scheduled backup configuration
+func (h *Handler) handleBackupSchedules(w http.ResponseWriter, r *http.Request) {
+	if h.backups == nil {
+		WriteError(w, http.StatusServiceUnavailable, "Backup scheduler not available")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		WriteSuccess(w, json.RawMessage(h.backups.GetSchedules()))
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := h.backups.SetSchedules(string(body)); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		WriteSuccess(w, map[string]interface{}{
+			"backupSchedule": json.RawMessage(h.backups.GetSchedules()),
+			"message":        "Backup schedules updated successfully",
+		})
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleBackupStatus returns the last and next run of each scheduled backup
+func (h *Handler) handleBackupStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.backups == nil {
+		WriteError(w, http.StatusServiceUnavailable, "Backup scheduler not available")
+		return
+	}
+
+	WriteSuccess(w, h.backups.Status())
+}
+
+// handleBackupRun runs a provider's scheduled backup immediately
+func (h *Handler) handleBackupRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.backups == nil {
+		WriteError(w, http.StatusServiceUnavailable, "Backup scheduler not available")
+		return
+	}
+
+	var req struct {
+		Provider string `json:"provider"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Provider == "" {
+		WriteError(w, http.StatusBadRequest, "Provider is required")
+		return
+	}
+
+	if err := h.backups.RunNow(req.Provider); err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteSuccess(w, h.backups.Status())
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/lich0821/ccNexus/internal/config"
 	"github.com/lich0821/ccNexus/internal/logger"
 	"github.com/lich0821/ccNexus/internal/storage"
 )
@@ -148,3 +149,173 @@ func (h *Handler) handleConfigLogLevel(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
+
+// handleConfigContextGuard handles GET and PUT for the context guard configuration
+func (h *Handler) handleConfigContextGuard(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		guard := h.config.GetContextGuard()
+		if guard == nil {
+			guard = &config.ContextGuardConfig{ModelLimits: map[string]int{}}
+		}
+		WriteSuccess(w, guard)
+	case http.MethodPut:
+		var guard config.ContextGuardConfig
+		if err := json.NewDecoder(r.Body).Decode(&guard); err != nil {
+			WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := guard.Validate(); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		h.config.UpdateContextGuard(&guard)
+
+		// Save to storage
+		adapter := storage.NewConfigStorageAdapter(h.storage)
+		if err := h.config.SaveToStorage(adapter); err != nil {
+			logger.Error("Failed to save config: %v", err)
+			WriteError(w, http.StatusInternalServerError, "Failed to save configuration")
+			return
+		}
+
+		WriteSuccess(w, map[string]interface{}{
+			"contextGuard": guard,
+			"message":      "Context guard updated successfully",
+		})
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleConfigResponseCache handles GET and PUT for the response cache configuration; DELETE clears the cache
+func (h *Handler) handleConfigResponseCache(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cache := h.config.GetResponseCache()
+		if cache == nil {
+			cache = &config.ResponseCacheConfig{}
+		}
+		WriteSuccess(w, cache)
+	case http.MethodPut:
+		var cache config.ResponseCacheConfig
+		if err := json.NewDecoder(r.Body).Decode(&cache); err != nil {
+			WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := cache.Validate(); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		h.config.UpdateResponseCache(&cache)
+
+		// Save to storage
+		adapter := storage.NewConfigStorageAdapter(h.storage)
+		if err := h.config.SaveToStorage(adapter); err != nil {
+			logger.Error("Failed to save config: %v", err)
+			WriteError(w, http.StatusInternalServerError, "Failed to save configuration")
+			return
+		}
+
+		WriteSuccess(w, map[string]interface{}{
+			"responseCache": cache,
+			"message":       "Response cache updated successfully",
+		})
+	case http.MethodDelete:
+		if err := h.storage.ClearResponseCache(); err != nil {
+			logger.Error("Failed to clear response cache: %v", err)
+			WriteError(w, http.StatusInternalServerError, "Failed to clear response cache")
+			return
+		}
+
+		WriteSuccess(w, map[string]interface{}{
+			"message": "Response cache cleared successfully",
+		})
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleConfigToolRepair handles GET and PUT for the tool repair configuration
+func (h *Handler) handleConfigToolRepair(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		repair := h.config.GetToolRepair()
+		if repair == nil {
+			repair = &config.ToolRepairConfig{}
+		}
+		WriteSuccess(w, repair)
+	case http.MethodPut:
+		var repair config.ToolRepairConfig
+		if err := json.NewDecoder(r.Body).Decode(&repair); err != nil {
+			WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := repair.Validate(); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		h.config.UpdateToolRepair(&repair)
+
+		// Save to storage
+		adapter := storage.NewConfigStorageAdapter(h.storage)
+		if err := h.config.SaveToStorage(adapter); err != nil {
+			logger.Error("Failed to save config: %v", err)
+			WriteError(w, http.StatusInternalServerError, "Failed to save configuration")
+			return
+		}
+
+		WriteSuccess(w, map[string]interface{}{
+			"toolRepair": repair,
+			"message":    "Tool repair updated successfully",
+		})
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleConfigServerTools handles GET and PUT for the server tools configuration
+func (h *Handler) handleConfigServerTools(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		tools := h.config.GetServerTools()
+		if tools == nil {
+			tools = &config.ServerToolsConfig{}
+		}
+		WriteSuccess(w, tools)
+	case http.MethodPut:
+		var tools config.ServerToolsConfig
+		if err := json.NewDecoder(r.Body).Decode(&tools); err != nil {
+			WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := tools.Validate(); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		h.config.UpdateServerTools(&tools)
+
+		// Save to storage
+		adapter := storage.NewConfigStorageAdapter(h.storage)
+		if err := h.config.SaveToStorage(adapter); err != nil {
+			logger.Error("Failed to save config: %v", err)
+			WriteError(w, http.StatusInternalServerError, "Failed to save configuration")
+			return
+		}
+
+		WriteSuccess(w, map[string]interface{}{
+			"serverTools": tools,
+			"message":     "Server tools updated successfully",
+		})
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -2,12 +2,14 @@ package api
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/lich0821/ccNexus/internal/config"
 	"github.com/lich0821/ccNexus/internal/logger"
+	"github.com/lich0821/ccNexus/internal/proxy"
 	"github.com/lich0821/ccNexus/internal/storage"
 )
 
@@ -35,7 +37,7 @@ func (h *Handler) handleEndpointByName(w http.ResponseWriter, r *http.Request) {
 
 	name := parts[0]
 
-	// Handle /test and /toggle sub-paths
+	// Handle /test, /toggle and /capabilities sub-paths
 	if len(parts) > 1 {
 		switch parts[1] {
 		case "test":
@@ -44,6 +46,9 @@ func (h *Handler) handleEndpointByName(w http.ResponseWriter, r *http.Request) {
 		case "toggle":
 			h.toggleEndpoint(w, r, name)
 			return
+		case "capabilities":
+			h.handleEndpointCapabilities(w, r, name)
+			return
 		}
 	}
 
@@ -108,6 +113,13 @@ func (h *Handler) createEndpoint(w http.ResponseWriter, r *http.Request) {
 		Transformer string `json:"transformer"`
 		Model       string `json:"model"`
 		Remark      string `json:"remark"`
+
+		AzureResource   string `json:"azureResource"`
+		AzureDeployment string `json:"azureDeployment"`
+		AzureAPIVersion string `json:"azureApiVersion"`
+
+		RewriteRules json.RawMessage `json:"rewriteRules"`
+		Capabilities json.RawMessage `json:"capabilities"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -116,11 +128,23 @@ func (h *Handler) createEndpoint(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate required fields
-	if req.Name == "" || req.APIUrl == "" || req.APIKey == "" {
+	hasURL := req.APIUrl != "" || (strings.HasPrefix(req.Transformer, "azure") && req.AzureResource != "")
+	if req.Name == "" || !hasURL || (req.APIKey == "" && req.Transformer != "ollama") {
 		WriteError(w, http.StatusBadRequest, "Name, apiUrl, and apiKey are required")
 		return
 	}
 
+	rewriteRules, err := parseRewriteRules(req.RewriteRules)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	capabilities, err := parseCapabilities(req.Capabilities)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// Get current endpoints to determine sort order
 	endpoints, err := h.storage.GetEndpoints()
 	if err != nil {
@@ -147,8 +171,15 @@ func (h *Handler) createEndpoint(w http.ResponseWriter, r *http.Request) {
 		Model:       req.Model,
 		Remark:      req.Remark,
 		SortOrder:   len(endpoints),
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+
+		AzureResource:   req.AzureResource,
+		AzureDeployment: req.AzureDeployment,
+		AzureAPIVersion: req.AzureAPIVersion,
+		RewriteRules:    rewriteRules,
+		Capabilities:    capabilities,
+
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
 	}
 
 	if err := h.storage.SaveEndpoint(endpoint); err != nil {
@@ -176,6 +207,13 @@ func (h *Handler) updateEndpoint(w http.ResponseWriter, r *http.Request, name st
 		Transformer string `json:"transformer"`
 		Model       string `json:"model"`
 		Remark      string `json:"remark"`
+
+		AzureResource   string `json:"azureResource"`
+		AzureDeployment string `json:"azureDeployment"`
+		AzureAPIVersion string `json:"azureApiVersion"`
+
+		RewriteRules json.RawMessage `json:"rewriteRules"`
+		Capabilities json.RawMessage `json:"capabilities"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -204,6 +242,11 @@ func (h *Handler) updateEndpoint(w http.ResponseWriter, r *http.Request, name st
 		return
 	}
 
+	// A capability profile describes one upstream model, so it is dropped when the target changes
+	targetChanged := (req.APIUrl != "" && normalizeAPIUrl(req.APIUrl) != existing.APIUrl) ||
+		(req.Transformer != "" && req.Transformer != existing.Transformer) ||
+		(req.Model != "" && req.Model != existing.Model)
+
 	// Update fields
 	if req.Name != "" {
 		existing.Name = req.Name
@@ -222,6 +265,27 @@ func (h *Handler) updateEndpoint(w http.ResponseWriter, r *http.Request, name st
 		existing.Model = req.Model
 	}
 	existing.Remark = req.Remark
+	existing.AzureResource = req.AzureResource
+	existing.AzureDeployment = req.AzureDeployment
+	existing.AzureAPIVersion = req.AzureAPIVersion
+	if req.RewriteRules != nil {
+		rewriteRules, err := parseRewriteRules(req.RewriteRules)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		existing.RewriteRules = rewriteRules
+	}
+	if req.Capabilities != nil {
+		capabilities, err := parseCapabilities(req.Capabilities)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		existing.Capabilities = capabilities
+	} else if targetChanged {
+		existing.Capabilities = ""
+	}
 	existing.UpdatedAt = time.Now()
 
 	if err := h.storage.UpdateEndpoint(existing); err != nil {
@@ -313,6 +377,84 @@ func (h *Handler) toggleEndpoint(w http.ResponseWriter, r *http.Request, name st
 	})
 }
 
+// handleEndpointCapabilities detects (POST) or sets (PUT) the capability profile of an endpoint
+func (h *Handler) handleEndpointCapabilities(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var capabilities string
+	if r.Method == http.MethodPut {
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if capabilities, err = parseCapabilities(raw); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else {
+		var endpoint config.Endpoint
+		for _, ep := range h.config.GetEndpoints() {
+			if ep.Name == name {
+				endpoint = ep
+			}
+		}
+		if endpoint.Name == "" {
+			WriteError(w, http.StatusNotFound, "Endpoint not found")
+			return
+		}
+		caps, err := proxy.DetectCapabilities(endpoint, h.config)
+		if err != nil {
+			WriteError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		logger.Info("Endpoint capabilities detected: %s [%s]", name, caps.String())
+		capabilities = config.EncodeCapabilities(caps)
+	}
+
+	// The endpoint is read after detecting, so edits made while the probes ran are kept
+	endpoints, err := h.storage.GetEndpoints()
+	if err != nil {
+		logger.Error("Failed to get endpoints: %v", err)
+		WriteError(w, http.StatusInternalServerError, "Failed to get endpoints")
+		return
+	}
+
+	var existing *storage.Endpoint
+	for i := range endpoints {
+		if endpoints[i].Name == name {
+			existing = &endpoints[i]
+			break
+		}
+	}
+
+	if existing == nil {
+		WriteError(w, http.StatusNotFound, "Endpoint not found")
+		return
+	}
+	existing.Capabilities = capabilities
+	existing.UpdatedAt = time.Now()
+
+	if err := h.storage.UpdateEndpoint(existing); err != nil {
+		logger.Error("Failed to update endpoint: %v", err)
+		WriteError(w, http.StatusInternalServerError, "Failed to update endpoint")
+		return
+	}
+
+	// Update proxy config
+	if err := h.reloadConfig(); err != nil {
+		logger.Error("Failed to reload config: %v", err)
+	}
+
+	caps, _ := config.DecodeCapabilities(existing.Capabilities)
+	WriteSuccess(w, map[string]interface{}{
+		"capabilities": caps,
+	})
+}
+
 // handleCurrentEndpoint returns the current active endpoint
 func (h *Handler) handleCurrentEndpoint(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -457,3 +599,38 @@ func maskAPIKey(key string) string {
 func normalizeAPIUrl(apiUrl string) string {
 	return strings.TrimSuffix(apiUrl, "/")
 }
+
+// parseRewriteRules validates a JSON rule array (or a string containing one) and encodes it for storage
+func parseRewriteRules(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var encoded string
+	if err := json.Unmarshal(raw, &encoded); err == nil {
+		raw = json.RawMessage(encoded)
+	}
+	rules, err := config.DecodeRewriteRules(string(raw))
+	if err != nil {
+		return "", err
+	}
+	if err := config.ValidateRewriteRules(rules); err != nil {
+		return "", err
+	}
+	return config.EncodeRewriteRules(rules), nil
+}
+
+// parseCapabilities validates a capability profile object (or a string containing one) and encodes it for storage
+func parseCapabilities(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var encoded string
+	if err := json.Unmarshal(raw, &encoded); err == nil {
+		raw = json.RawMessage(encoded)
+	}
+	caps, err := config.DecodeCapabilities(string(raw))
+	if err != nil {
+		return "", err
+	}
+	return config.EncodeCapabilities(caps), nil
+}
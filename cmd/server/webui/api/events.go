@@ -42,6 +42,9 @@ func (h *Handler) handleEvents(w http.ResponseWriter, r *http.Request) {
 
 	logger.Debug("[SSE] Client connected")
 
+	// Last backup state sent to this client, by provider
+	backupSeen := make(map[string]string)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -79,7 +82,40 @@ func (h *Handler) handleEvents(w http.ResponseWriter, r *http.Request) {
 
 			// Send event
 			fmt.Fprintf(w, "data: %s\n\n", string(data))
+			h.sendBackupEvents(w, backupSeen)
 			flusher.Flush()
 		}
 	}
 }
+
+// sendBackupEvents sends a backup event for each scheduled backup that started or finished
+// since the last call
+func (h *Handler) sendBackupEvents(w http.ResponseWriter, seen map[string]string) {
+	if h.backups == nil {
+		return
+	}
+	for _, status := range h.backups.Status() {
+		state := fmt.Sprintf("%t", status.Running)
+		if status.LastRun != nil {
+			state += status.LastRun.String()
+		}
+		if seen[status.Provider] == state {
+			continue
+		}
+		first := seen[status.Provider] == ""
+		seen[status.Provider] = state
+		if first && !status.Running {
+			continue // Clients fetch the current status from /api/backup/status
+		}
+
+		data, err := json.Marshal(map[string]interface{}{
+			"type":      "backup",
+			"timestamp": time.Now().Unix(),
+			"backup":    status,
+		})
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "data: %s\n\n", string(data))
+	}
+}
@@ -5,22 +5,31 @@ import (
 
 	"github.com/lich0821/ccNexus/internal/config"
 	"github.com/lich0821/ccNexus/internal/proxy"
+	"github.com/lich0821/ccNexus/internal/service"
 	"github.com/lich0821/ccNexus/internal/storage"
 )
 
 // Handler handles API requests
 type Handler struct {
-	config  *config.Config
-	proxy   *proxy.Proxy
-	storage *storage.SQLiteStorage
+	config      *config.Config
+	proxy       *proxy.Proxy
+	storage     storage.Storage
+	backups     *service.BackupScheduler
+	statsSync   *service.StatsSyncService
+	statsReport *service.StatsReportService
+	archive     *service.ArchiveService
 }
 
 // NewHandler creates a new API handler
-func NewHandler(cfg *config.Config, p *proxy.Proxy, s *storage.SQLiteStorage) *Handler {
+func NewHandler(cfg *config.Config, p *proxy.Proxy, s storage.Storage, backups *service.BackupScheduler, statsSync *service.StatsSyncService, statsReport *service.StatsReportService, archive *service.ArchiveService) *Handler {
 	return &Handler{
-		config:  cfg,
-		proxy:   p,
-		storage: s,
+		config:      cfg,
+		proxy:       p,
+		storage:     s,
+		backups:     backups,
+		statsSync:   statsSync,
+		statsReport: statsReport,
+		archive:     archive,
 	}
 }
 
@@ -40,11 +49,37 @@ func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/stats/weekly", h.handleStatsWeekly)
 	mux.HandleFunc("/api/stats/monthly", h.handleStatsMonthly)
 	mux.HandleFunc("/api/stats/trends", h.handleStatsTrends)
+	mux.HandleFunc("/api/stats/devices", h.handleStatsDevices)
+	mux.HandleFunc("/api/stats/export", h.handleStatsExport)
+
+	// Multi-device stats sync
+	mux.HandleFunc("/api/stats/sync", h.handleStatsSync)
+	mux.HandleFunc("/api/stats/sync/status", h.handleStatsSyncStatus)
+	mux.HandleFunc("/api/stats/sync/run", h.handleStatsSyncRun)
+
+	// Monthly stats report
+	mux.HandleFunc("/api/stats/report", h.handleStatsReport)
+	mux.HandleFunc("/api/stats/report/status", h.handleStatsReportStatus)
+	mux.HandleFunc("/api/stats/report/run", h.handleStatsReportRun)
+
+	// Monthly rollups of old stats
+	mux.HandleFunc("/api/stats/archive", h.handleStatsArchive)
+	mux.HandleFunc("/api/stats/archive/status", h.handleStatsArchiveStatus)
+	mux.HandleFunc("/api/stats/archive/run", h.handleStatsArchiveRun)
 
 	// Configuration
 	mux.HandleFunc("/api/config", h.handleConfig)
 	mux.HandleFunc("/api/config/port", h.handleConfigPort)
 	mux.HandleFunc("/api/config/log-level", h.handleConfigLogLevel)
+	mux.HandleFunc("/api/config/context-guard", h.handleConfigContextGuard)
+	mux.HandleFunc("/api/config/response-cache", h.handleConfigResponseCache)
+	mux.HandleFunc("/api/config/tool-repair", h.handleConfigToolRepair)
+	mux.HandleFunc("/api/config/server-tools", h.handleConfigServerTools)
+
+	// Scheduled backups
+	mux.HandleFunc("/api/backup/schedules", h.handleBackupSchedules)
+	mux.HandleFunc("/api/backup/status", h.handleBackupStatus)
+	mux.HandleFunc("/api/backup/run", h.handleBackupRun)
 
 	// Real-time events
 	mux.HandleFunc("/api/events", h.handleEvents)
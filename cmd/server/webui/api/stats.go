@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/lich0821/ccNexus/internal/logger"
+	"github.com/lich0821/ccNexus/internal/service"
 )
 
 // handleStatsSummary returns overall statistics
@@ -20,11 +21,15 @@ func (h *Handler) handleStatsSummary(w http.ResponseWriter, r *http.Request) {
 	totalErrors := 0
 	var totalInputTokens int64 = 0
 	var totalOutputTokens int64 = 0
+	totalCacheHits := 0
+	var totalSavedTokens int64 = 0
 
 	for _, stats := range endpointStats {
 		totalErrors += stats.Errors
 		totalInputTokens += int64(stats.InputTokens)
 		totalOutputTokens += int64(stats.OutputTokens)
+		totalCacheHits += stats.CacheHits
+		totalSavedTokens += int64(stats.SavedTokens)
 	}
 
 	WriteSuccess(w, map[string]interface{}{
@@ -32,6 +37,8 @@ func (h *Handler) handleStatsSummary(w http.ResponseWriter, r *http.Request) {
 		"TotalErrors":       totalErrors,
 		"TotalInputTokens":  totalInputTokens,
 		"TotalOutputTokens": totalOutputTokens,
+		"TotalCacheHits":    totalCacheHits,
+		"TotalSavedTokens":  totalSavedTokens,
 		"Endpoints":         endpointStats,
 	})
 }
@@ -43,19 +50,25 @@ func (h *Handler) handleStatsDaily(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	device := r.URL.Query().Get("device")
 	today := time.Now().Format("2006-01-02")
-	stats, err := h.getStatsForPeriod(today, today)
+	stats, err := h.getStatsForPeriod(today, today, device)
 	if err != nil {
 		logger.Error("Failed to get daily stats: %v", err)
 		WriteError(w, http.StatusInternalServerError, "Failed to get daily stats")
 		return
 	}
 
-	WriteSuccess(w, map[string]interface{}{
+	result := map[string]interface{}{
 		"period": "daily",
 		"date":   today,
+		"device": device,
 		"stats":  stats,
-	})
+	}
+	if !h.addUsageSeries(w, r, result, today, today, device) {
+		return
+	}
+	WriteSuccess(w, result)
 }
 
 // handleStatsWeekly returns this week's statistics
@@ -65,6 +78,7 @@ func (h *Handler) handleStatsWeekly(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	device := r.URL.Query().Get("device")
 	now := time.Now()
 	// Get start of week (Monday)
 	weekday := int(now.Weekday())
@@ -75,19 +89,24 @@ func (h *Handler) handleStatsWeekly(w http.ResponseWriter, r *http.Request) {
 	startDate := startOfWeek.Format("2006-01-02")
 	endDate := now.Format("2006-01-02")
 
-	stats, err := h.getStatsForPeriod(startDate, endDate)
+	stats, err := h.getStatsForPeriod(startDate, endDate, device)
 	if err != nil {
 		logger.Error("Failed to get weekly stats: %v", err)
 		WriteError(w, http.StatusInternalServerError, "Failed to get weekly stats")
 		return
 	}
 
-	WriteSuccess(w, map[string]interface{}{
+	result := map[string]interface{}{
 		"period":    "weekly",
 		"startDate": startDate,
 		"endDate":   endDate,
+		"device":    device,
 		"stats":     stats,
-	})
+	}
+	if !h.addUsageSeries(w, r, result, startDate, endDate, device) {
+		return
+	}
+	WriteSuccess(w, result)
 }
 
 // handleStatsMonthly returns this month's statistics
@@ -97,24 +116,30 @@ func (h *Handler) handleStatsMonthly(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	device := r.URL.Query().Get("device")
 	now := time.Now()
 	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
 	startDate := startOfMonth.Format("2006-01-02")
 	endDate := now.Format("2006-01-02")
 
-	stats, err := h.getStatsForPeriod(startDate, endDate)
+	stats, err := h.getStatsForPeriod(startDate, endDate, device)
 	if err != nil {
 		logger.Error("Failed to get monthly stats: %v", err)
 		WriteError(w, http.StatusInternalServerError, "Failed to get monthly stats")
 		return
 	}
 
-	WriteSuccess(w, map[string]interface{}{
+	result := map[string]interface{}{
 		"period":    "monthly",
 		"startDate": startDate,
 		"endDate":   endDate,
+		"device":    device,
 		"stats":     stats,
-	})
+	}
+	if !h.addUsageSeries(w, r, result, startDate, endDate, device) {
+		return
+	}
+	WriteSuccess(w, result)
 }
 
 // handleStatsTrends returns trend comparison data
@@ -124,12 +149,13 @@ func (h *Handler) handleStatsTrends(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	device := r.URL.Query().Get("device")
 	now := time.Now()
 	today := now.Format("2006-01-02")
 	yesterday := now.AddDate(0, 0, -1).Format("2006-01-02")
 
 	// Get today's stats
-	todayStats, err := h.getStatsForPeriod(today, today)
+	todayStats, err := h.getStatsForPeriod(today, today, device)
 	if err != nil {
 		logger.Error("Failed to get today's stats: %v", err)
 		WriteError(w, http.StatusInternalServerError, "Failed to get trend stats")
@@ -137,7 +163,7 @@ func (h *Handler) handleStatsTrends(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get yesterday's stats
-	yesterdayStats, err := h.getStatsForPeriod(yesterday, yesterday)
+	yesterdayStats, err := h.getStatsForPeriod(yesterday, yesterday, device)
 	if err != nil {
 		logger.Error("Failed to get yesterday's stats: %v", err)
 		WriteError(w, http.StatusInternalServerError, "Failed to get trend stats")
@@ -173,9 +199,36 @@ func (h *Handler) handleStatsTrends(w http.ResponseWriter, r *http.Request) {
 	WriteSuccess(w, trends)
 }
 
-// getStatsForPeriod retrieves statistics for a date range
-func (h *Handler) getStatsForPeriod(startDate, endDate string) (map[string]interface{}, error) {
-	allStats, err := h.storage.GetAllStats()
+// addUsageSeries adds the usage by time bucket to a stats response when the request has a
+// granularity (hour, day) or groupBy (endpoint, clientModel, upstreamModel, clientFormat, device) parameter.
+// It reports false after writing an error.
+func (h *Handler) addUsageSeries(w http.ResponseWriter, r *http.Request, result map[string]interface{}, startDate, endDate, device string) bool {
+	granularity := r.URL.Query().Get("granularity")
+	groupBy := r.URL.Query().Get("groupBy")
+	if granularity == "" && groupBy == "" {
+		return true
+	}
+
+	query, err := service.NewUsageQuery(granularity, groupBy, startDate, endDate, device)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	series, err := h.storage.GetUsageStats(query)
+	if err != nil {
+		logger.Error("Failed to get usage stats: %v", err)
+		WriteError(w, http.StatusInternalServerError, "Failed to get usage stats")
+		return false
+	}
+	result["granularity"] = query.Granularity
+	result["groupBy"] = query.GroupBy
+	result["series"] = series
+	return true
+}
+
+// getStatsForPeriod retrieves statistics for a date range, of one device or (empty device) of all devices
+func (h *Handler) getStatsForPeriod(startDate, endDate, device string) (map[string]interface{}, error) {
+	allStats, err := h.storage.GetAllStatsForDevice(device)
 	if err != nil {
 		return nil, err
 	}
@@ -184,6 +237,8 @@ func (h *Handler) getStatsForPeriod(startDate, endDate string) (map[string]inter
 	totalErrors := 0
 	var totalInputTokens int64 = 0
 	var totalOutputTokens int64 = 0
+	totalCacheHits := 0
+	var totalSavedTokens int64 = 0
 	endpointStats := make(map[string]interface{})
 
 	for endpointName, stats := range allStats {
@@ -191,6 +246,8 @@ func (h *Handler) getStatsForPeriod(startDate, endDate string) (map[string]inter
 		epErrors := 0
 		var epInputTokens int64 = 0
 		var epOutputTokens int64 = 0
+		epCacheHits := 0
+		var epSavedTokens int64 = 0
 
 		for _, stat := range stats {
 			if stat.Date >= startDate && stat.Date <= endDate {
@@ -198,21 +255,27 @@ func (h *Handler) getStatsForPeriod(startDate, endDate string) (map[string]inter
 				epErrors += stat.Errors
 				epInputTokens += int64(stat.InputTokens)
 				epOutputTokens += int64(stat.OutputTokens)
+				epCacheHits += stat.CacheHits
+				epSavedTokens += int64(stat.SavedTokens)
 			}
 		}
 
-		if epRequests > 0 {
+		if epRequests > 0 || epCacheHits > 0 {
 			endpointStats[endpointName] = map[string]interface{}{
 				"requests":     epRequests,
 				"errors":       epErrors,
 				"inputTokens":  epInputTokens,
 				"outputTokens": epOutputTokens,
+				"cacheHits":    epCacheHits,
+				"savedTokens":  epSavedTokens,
 			}
 
 			totalRequests += epRequests
 			totalErrors += epErrors
 			totalInputTokens += epInputTokens
 			totalOutputTokens += epOutputTokens
+			totalCacheHits += epCacheHits
+			totalSavedTokens += epSavedTokens
 		}
 	}
 
@@ -222,6 +285,8 @@ func (h *Handler) getStatsForPeriod(startDate, endDate string) (map[string]inter
 		"totalSuccess":      totalRequests - totalErrors,
 		"totalInputTokens":  totalInputTokens,
 		"totalOutputTokens": totalOutputTokens,
+		"totalCacheHits":    totalCacheHits,
+		"totalSavedTokens":  totalSavedTokens,
 		"endpoints":         endpointStats,
 	}, nil
 }
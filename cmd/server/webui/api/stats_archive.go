@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// handleStatsArchive handles GET and PUT for the monthly rollup configuration
+func (h *Handler) handleStatsArchive(w http.ResponseWriter, r *http.Request) {
+	if h.archive == nil {
+		WriteError(w, http.StatusServiceUnavailable, "Stats archive not available")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		WriteSuccess(w, json.RawMessage(h.archive.GetConfig()))
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := h.archive.SetConfig(string(body)); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		WriteSuccess(w, map[string]interface{}{
+			"statsArchive": json.RawMessage(h.archive.GetConfig()),
+			"message":      "Stats archive updated successfully",
+		})
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleStatsArchiveStatus returns the last and next monthly rollup
+func (h *Handler) handleStatsArchiveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.archive == nil {
+		WriteError(w, http.StatusServiceUnavailable, "Stats archive not available")
+		return
+	}
+
+	WriteSuccess(w, h.archive.Status())
+}
+
+// handleStatsArchiveRun rolls up old months immediately
+func (h *Handler) handleStatsArchiveRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.archive == nil {
+		WriteError(w, http.StatusServiceUnavailable, "Stats archive not available")
+		return
+	}
+
+	if err := h.archive.RunNow(); err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteSuccess(w, h.archive.Status())
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/lich0821/ccNexus/internal/logger"
+	"github.com/lich0821/ccNexus/internal/service"
+)
+
+// handleStatsExport streams the stats of a date range as CSV, JSON Lines or Parquet
+func (h *Handler) handleStatsExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	opts := service.StatsExportOptions{
+		StartDate:   q.Get("startDate"),
+		EndDate:     q.Get("endDate"),
+		Granularity: q.Get("granularity"),
+		Format:      q.Get("format"),
+	}
+	if dims := q.Get("dimensions"); dims != "" {
+		opts.Dimensions = strings.Split(dims, ",")
+	}
+	if err := opts.Normalize(); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ew := &exportWriter{ResponseWriter: w}
+	w.Header().Set("Content-Type", opts.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", opts.Filename()))
+	rows, err := service.ExportStats(h.storage, opts, ew)
+	if err != nil {
+		logger.Error("Failed to export stats: %v", err)
+		if !ew.wrote {
+			w.Header().Del("Content-Disposition")
+			WriteError(w, http.StatusInternalServerError, "Failed to export stats")
+		}
+		return
+	}
+	logger.Debug("Exported %d stats rows as %s", rows, opts.Format)
+}
+
+// exportWriter records whether the export has started, after which errors can no longer be reported
+type exportWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *exportWriter) Write(p []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(p)
+}
+
+// handleStatsReport handles GET and PUT for the monthly stats report configuration
+func (h *Handler) handleStatsReport(w http.ResponseWriter, r *http.Request) {
+	if h.statsReport == nil {
+		WriteError(w, http.StatusServiceUnavailable, "Stats report not available")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		WriteSuccess(w, json.RawMessage(h.statsReport.GetConfig()))
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := h.statsReport.SetConfig(string(body)); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		WriteSuccess(w, map[string]interface{}{
+			"statsReport": json.RawMessage(h.statsReport.GetConfig()),
+			"message":     "Stats report updated successfully",
+		})
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleStatsReportStatus returns the last and next stats report
+func (h *Handler) handleStatsReportStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.statsReport == nil {
+		WriteError(w, http.StatusServiceUnavailable, "Stats report not available")
+		return
+	}
+
+	WriteSuccess(w, h.statsReport.Status())
+}
+
+// handleStatsReportRun writes the report of a month (default: the previous month) immediately
+func (h *Handler) handleStatsReportRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.statsReport == nil {
+		WriteError(w, http.StatusServiceUnavailable, "Stats report not available")
+		return
+	}
+
+	if err := h.statsReport.RunNow(r.URL.Query().Get("month")); err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteSuccess(w, h.statsReport.Status())
+}
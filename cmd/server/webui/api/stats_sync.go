@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/lich0821/ccNexus/internal/logger"
+)
+
+// handleStatsDevices lists the devices with stats and their totals
+func (h *Handler) handleStatsDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.statsSync == nil {
+		WriteError(w, http.StatusServiceUnavailable, "Stats sync not available")
+		return
+	}
+
+	devices, err := h.statsSync.Devices()
+	if err != nil {
+		logger.Error("Failed to list stats devices: %v", err)
+		WriteError(w, http.StatusInternalServerError, "Failed to list devices")
+		return
+	}
+
+	WriteSuccess(w, devices)
+}
+
+// handleStatsSync handles GET and PUT for the stats sync configuration
+func (h *Handler) handleStatsSync(w http.ResponseWriter, r *http.Request) {
+	if h.statsSync == nil {
+		WriteError(w, http.StatusServiceUnavailable, "Stats sync not available")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		WriteSuccess(w, json.RawMessage(h.statsSync.GetConfig()))
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := h.statsSync.SetConfig(string(body)); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		WriteSuccess(w, map[string]interface{}{
+			"statsSync": json.RawMessage(h.statsSync.GetConfig()),
+			"message":   "Stats sync updated successfully",
+		})
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleStatsSyncStatus returns the last and next stats sync
+func (h *Handler) handleStatsSyncStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.statsSync == nil {
+		WriteError(w, http.StatusServiceUnavailable, "Stats sync not available")
+		return
+	}
+
+	WriteSuccess(w, h.statsSync.Status())
+}
+
+// handleStatsSyncRun pushes and pulls stats immediately
+func (h *Handler) handleStatsSyncRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.statsSync == nil {
+		WriteError(w, http.StatusServiceUnavailable, "Stats sync not available")
+		return
+	}
+
+	if err := h.statsSync.SyncNow(); err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteSuccess(w, h.statsSync.Status())
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/lich0821/ccNexus/internal/config"
 	"github.com/lich0821/ccNexus/internal/logger"
 	"github.com/lich0821/ccNexus/internal/storage"
 )
@@ -113,6 +114,48 @@ func (h *Handler) sendTestRequest(endpoint *storage.Endpoint) (string, error) {
 				},
 			},
 		})
+	case "azure", "azure2":
+		azureEp := config.Endpoint{
+			Transformer:     endpoint.Transformer,
+			APIUrl:          endpoint.APIUrl,
+			Model:           endpoint.Model,
+			AzureResource:   endpoint.AzureResource,
+			AzureDeployment: endpoint.AzureDeployment,
+			AzureAPIVersion: endpoint.AzureAPIVersion,
+		}
+		url = fmt.Sprintf("%s%s?api-version=%s", normalizeAPIUrl(azureEp.AzureBaseURL()), azureEp.AzurePath(), azureEp.AzureAPIVersionOrDefault())
+		if endpoint.Transformer == "azure2" {
+			reqBody, err = json.Marshal(map[string]interface{}{
+				"model": azureEp.AzureDeploymentName(),
+				"input": "你是什么模型?",
+			})
+		} else {
+			reqBody, err = json.Marshal(map[string]interface{}{
+				"model": azureEp.AzureDeploymentName(),
+				"messages": []map[string]interface{}{
+					{
+						"role":    "user",
+						"content": "你是什么模型?",
+					},
+				},
+				"max_tokens": 16,
+			})
+		}
+	case "ollama":
+		url = fmt.Sprintf("%s/api/chat", normalizeAPIUrl(endpoint.APIUrl))
+		reqBody, err = json.Marshal(map[string]interface{}{
+			"model":  endpoint.Model,
+			"stream": false,
+			"messages": []map[string]interface{}{
+				{
+					"role":    "user",
+					"content": "你是什么模型?",
+				},
+			},
+			"options": map[string]interface{}{
+				"num_predict": 16,
+			},
+		})
 	default:
 		return "", fmt.Errorf("unsupported transformer: %s", endpoint.Transformer)
 	}
@@ -135,6 +178,12 @@ func (h *Handler) sendTestRequest(endpoint *storage.Endpoint) (string, error) {
 		req.Header.Set("anthropic-version", "2023-06-01")
 	case "openai", "openai2":
 		req.Header.Set("Authorization", "Bearer "+endpoint.APIKey)
+	case "azure", "azure2":
+		req.Header.Set("api-key", endpoint.APIKey)
+	case "ollama":
+		if endpoint.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+endpoint.APIKey)
+		}
 	case "gemini":
 		// Gemini uses API key in URL query parameter
 		q := req.URL.Query()
@@ -177,7 +226,7 @@ func (h *Handler) sendTestRequest(endpoint *storage.Endpoint) (string, error) {
 				}
 			}
 		}
-	case "openai", "openai2":
+	case "openai", "openai2", "azure":
 		if choices, ok := result["choices"].([]interface{}); ok && len(choices) > 0 {
 			if choice, ok := choices[0].(map[string]interface{}); ok {
 				if message, ok := choice["message"].(map[string]interface{}); ok {
@@ -201,6 +250,12 @@ func (h *Handler) sendTestRequest(endpoint *storage.Endpoint) (string, error) {
 				}
 			}
 		}
+	case "ollama":
+		if message, ok := result["message"].(map[string]interface{}); ok {
+			if content, ok := message["content"].(string); ok {
+				return content, nil
+			}
+		}
 	}
 
 	return string(body), nil
@@ -214,9 +269,10 @@ func (h *Handler) handleFetchModels(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		APIUrl      string `json:"apiUrl"`
-		APIKey      string `json:"apiKey"`
-		Transformer string `json:"transformer"`
+		APIUrl        string `json:"apiUrl"`
+		APIKey        string `json:"apiKey"`
+		Transformer   string `json:"transformer"`
+		AzureResource string `json:"azureResource"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -224,7 +280,11 @@ func (h *Handler) handleFetchModels(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	models, err := h.fetchModelsFromProvider(req.APIUrl, req.APIKey, req.Transformer)
+	apiUrl := req.APIUrl
+	if ep := (config.Endpoint{APIUrl: apiUrl, Transformer: req.Transformer, AzureResource: req.AzureResource}); ep.IsAzure() {
+		apiUrl = ep.AzureBaseURL()
+	}
+	models, err := h.fetchModelsFromProvider(apiUrl, req.APIKey, req.Transformer)
 	if err != nil {
 		logger.Error("Failed to fetch models: %v", err)
 		WriteError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to fetch models: %v", err))
@@ -236,15 +296,35 @@ func (h *Handler) handleFetchModels(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// ListModels fetches the models of a configured endpoint; it implements proxy.ModelLister
+func (h *Handler) ListModels(endpoint config.Endpoint) ([]string, error) {
+	transformer := endpoint.Transformer
+	if transformer == "" {
+		transformer = "claude"
+	}
+	apiUrl := endpoint.APIUrl
+	if endpoint.IsAzure() {
+		apiUrl = endpoint.AzureBaseURL()
+	}
+	return h.fetchModelsFromProvider(normalizeAPIUrl(apiUrl), endpoint.APIKey, transformer)
+}
+
 // fetchModelsFromProvider fetches available models from a provider
 func (h *Handler) fetchModelsFromProvider(apiUrl, apiKey, transformer string) ([]string, error) {
 	var url string
 	var authHeader string
+	authHeaderName := "Authorization"
 
 	switch transformer {
 	case "openai", "openai2":
 		url = fmt.Sprintf("%s/v1/models", apiUrl)
 		authHeader = "Bearer " + apiKey
+	case "azure", "azure2":
+		url = fmt.Sprintf("%s/openai/models?api-version=%s", normalizeAPIUrl(apiUrl), config.DefaultAzureAPIVersion)
+		authHeaderName = "api-key"
+		authHeader = apiKey
+	case "ollama":
+		return h.fetchOllamaModels(apiUrl, apiKey)
 	case "claude":
 		// Claude doesn't have a models endpoint, return known models
 		return []string{
@@ -270,7 +350,7 @@ func (h *Handler) fetchModelsFromProvider(apiUrl, apiKey, transformer string) ([
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", authHeader)
+	req.Header.Set(authHeaderName, authHeader)
 
 	client := &http.Client{
 		Timeout: 10 * time.Second,
@@ -304,3 +384,47 @@ func (h *Handler) fetchModelsFromProvider(apiUrl, apiKey, transformer string) ([
 
 	return models, nil
 }
+
+// fetchOllamaModels lists the locally pulled models of an Ollama server
+func (h *Handler) fetchOllamaModels(apiUrl, apiKey string) ([]string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/tags", normalizeAPIUrl(apiUrl)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if apiKey != "" && apiKey != "****" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(result.Models))
+	for _, model := range result.Models {
+		models = append(models, model.Name)
+	}
+
+	return models, nil
+}
@@ -311,7 +311,7 @@ class Endpoints {
                             </div>
                             <div class="form-group">
                                 <label class="form-label">API Key *</label>
-                                <input type="password" class="form-input" name="apiKey" value="${endpoint ? '****' : ''}" placeholder="sk-..." required>
+                                <input type="password" class="form-input" name="apiKey" value="${endpoint ? '****' : ''}" placeholder="sk-...">
                                 ${endpoint ? '<small class="text-muted">Leave as **** to keep existing key</small>' : ''}
                             </div>
                             <div class="form-group">
@@ -322,8 +322,20 @@ class Endpoints {
                                     <option value="openai2" ${endpoint?.transformer === 'openai2' ? 'selected' : ''}>OpenAI Responses</option>
                                     <option value="gemini" ${endpoint?.transformer === 'gemini' ? 'selected' : ''}>Gemini</option>
                                     <option value="deepseek" ${endpoint?.transformer === 'deepseek' ? 'selected' : ''}>DeepSeek</option>
+                                    <option value="azure" ${endpoint?.transformer === 'azure' ? 'selected' : ''}>Azure OpenAI</option>
+                                    <option value="azure2" ${endpoint?.transformer === 'azure2' ? 'selected' : ''}>Azure OpenAI Responses</option>
+                                    <option value="ollama" ${endpoint?.transformer === 'ollama' ? 'selected' : ''}>Ollama</option>
                                 </select>
                             </div>
+                            <div class="form-group">
+                                <label class="form-label">Azure Resource / Deployment / API Version</label>
+                                <div style="display: flex; gap: 8px;">
+                                    <input type="text" class="form-input" name="azureResource" value="${endpoint ? this.escapeHtml(endpoint.azureResource || '') : ''}" placeholder="my-resource" style="flex: 1;">
+                                    <input type="text" class="form-input" name="azureDeployment" value="${endpoint ? this.escapeHtml(endpoint.azureDeployment || '') : ''}" placeholder="gpt-4o" style="flex: 1;">
+                                    <input type="text" class="form-input" name="azureApiVersion" value="${endpoint ? this.escapeHtml(endpoint.azureApiVersion || '') : ''}" placeholder="2024-10-21" style="flex: 1;">
+                                </div>
+                                <small class="text-muted">Only used by Azure transformers; deployment defaults to the model</small>
+                            </div>
                             <div class="form-group">
                                 <label class="form-label">Model</label>
                                 <div style="display: flex; gap: 8px;">
@@ -334,6 +346,11 @@ class Endpoints {
                                 </div>
                                 <small class="text-muted">Click "Fetch Models" to load available models from the API</small>
                             </div>
+                            <div class="form-group">
+                                <label class="form-label">Rewrite Rules</label>
+                                <textarea class="form-textarea" name="rewriteRules" rows="4" style="font-family: monospace;" placeholder='[{"action": "clamp", "path": "$.max_tokens", "max": 8192}]'>${endpoint ? this.escapeHtml(endpoint.rewriteRules || '') : ''}</textarea>
+                                <small class="text-muted">Optional JSON array of ordered rules: set_header, remove_header, set, delete, clamp, prefix, replace</small>
+                            </div>
                             <div class="form-group">
                                 <label class="form-label">Remark</label>
                                 <textarea class="form-textarea" name="remark">${endpoint ? this.escapeHtml(endpoint.remark || '') : ''}</textarea>
@@ -370,8 +387,9 @@ class Endpoints {
         const apiUrl = apiUrlInput.value.trim();
         const apiKey = apiKeyInput.value.trim();
         const transformer = transformerSelect.value;
+        const azureResource = transformer.startsWith('azure') ? document.querySelector('input[name="azureResource"]').value.trim() : '';
 
-        if (!apiUrl || !apiKey || apiKey === '****') {
+        if ((!apiUrl && !azureResource) || ((!apiKey || apiKey === '****') && transformer !== 'ollama')) {
             notifications.error('Please enter API URL and API Key first');
             return;
         }
@@ -380,7 +398,7 @@ class Endpoints {
             fetchBtn.disabled = true;
             fetchBtn.textContent = 'Fetching...';
 
-            const result = await api.fetchModels(apiUrl, apiKey, transformer);
+            const result = await api.fetchModels(apiUrl, apiKey, transformer, azureResource);
 
             if (result.models && result.models.length > 0) {
                 // Show model selection modal
@@ -465,6 +483,10 @@ class Endpoints {
             transformer: formData.get('transformer'),
             model: formData.get('model'),
             remark: formData.get('remark'),
+            azureResource: formData.get('azureResource'),
+            azureDeployment: formData.get('azureDeployment'),
+            azureApiVersion: formData.get('azureApiVersion'),
+            rewriteRules: (formData.get('rewriteRules') || '').trim(),
             enabled: formData.get('enabled') === 'on'
         };
 
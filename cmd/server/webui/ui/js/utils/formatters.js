@@ -42,7 +42,10 @@ export function getTransformerLabel(transformer) {
         'openai': 'OpenAI',
         'openai2': 'OpenAI Responses',
         'gemini': 'Gemini',
-        'deepseek': 'DeepSeek'
+        'deepseek': 'DeepSeek',
+        'azure': 'Azure OpenAI',
+        'azure2': 'Azure OpenAI Responses',
+        'ollama': 'Ollama'
     };
     return labels[transformer] || transformer;
 }
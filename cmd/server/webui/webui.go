@@ -7,6 +7,7 @@ import (
 
 	"github.com/lich0821/ccNexus/internal/config"
 	"github.com/lich0821/ccNexus/internal/proxy"
+	"github.com/lich0821/ccNexus/internal/service"
 	"github.com/lich0821/ccNexus/internal/storage"
 	"github.com/lich0821/ccNexus/cmd/server/webui/api"
 )
@@ -20,9 +21,11 @@ type WebUI struct {
 }
 
 // New creates a new WebUI instance
-func New(cfg *config.Config, p *proxy.Proxy, storage *storage.SQLiteStorage) *WebUI {
+func New(cfg *config.Config, p *proxy.Proxy, storage storage.Storage, backups *service.BackupScheduler, statsSync *service.StatsSyncService, statsReport *service.StatsReportService, archive *service.ArchiveService) *WebUI {
+	apiHandler := api.NewHandler(cfg, p, storage, backups, statsSync, statsReport, archive)
+	p.SetModelLister(apiHandler)
 	return &WebUI{
-		apiHandler: api.NewHandler(cfg, p, storage),
+		apiHandler: apiHandler,
 	}
 }
 
@@ -5,12 +5,13 @@ import (
 
 	"github.com/lich0821/ccNexus/internal/config"
 	"github.com/lich0821/ccNexus/internal/proxy"
+	"github.com/lich0821/ccNexus/internal/service"
 	"github.com/lich0821/ccNexus/internal/storage"
 	"github.com/lich0821/ccNexus/cmd/server/webui"
 )
 
 // registerWebUI registers the Web UI routes
-func registerWebUI(mux *http.ServeMux, cfg *config.Config, p *proxy.Proxy, storage *storage.SQLiteStorage) error {
-	ui := webui.New(cfg, p, storage)
+func registerWebUI(mux *http.ServeMux, cfg *config.Config, p *proxy.Proxy, storage storage.Storage, backups *service.BackupScheduler, statsSync *service.StatsSyncService, statsReport *service.StatsReportService, archive *service.ArchiveService) error {
+	ui := webui.New(cfg, p, storage, backups, statsSync, statsReport, archive)
 	return ui.RegisterRoutes(mux)
 }
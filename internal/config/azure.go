@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultAzureAPIVersion is the api-version used for Azure Chat Completions
+	DefaultAzureAPIVersion = "2024-10-21"
+	// DefaultAzureResponsesAPIVersion is the api-version used for Azure Responses API
+	DefaultAzureResponsesAPIVersion = "2025-04-01-preview"
+)
+
+// IsAzure reports whether the endpoint targets an Azure OpenAI resource
+func (e Endpoint) IsAzure() bool {
+	return e.Transformer == "azure" || e.Transformer == "azure2"
+}
+
+// AzureBaseURL returns the endpoint URL, derived from the resource name when apiUrl is empty
+func (e Endpoint) AzureBaseURL() string {
+	if e.APIUrl != "" {
+		return e.APIUrl
+	}
+	if e.AzureResource != "" {
+		return fmt.Sprintf("https://%s.openai.azure.com", e.AzureResource)
+	}
+	return ""
+}
+
+// AzureDeploymentName returns the deployment name, falling back to the model
+func (e Endpoint) AzureDeploymentName() string {
+	if e.AzureDeployment != "" {
+		return e.AzureDeployment
+	}
+	return e.Model
+}
+
+// AzureAPIVersionOrDefault returns the configured api-version or the default for the API flavour
+func (e Endpoint) AzureAPIVersionOrDefault() string {
+	if e.AzureAPIVersion != "" {
+		return e.AzureAPIVersion
+	}
+	if e.Transformer == "azure2" {
+		return DefaultAzureResponsesAPIVersion
+	}
+	return DefaultAzureAPIVersion
+}
+
+// AzurePath returns the Azure OpenAI request path (without query) for the endpoint
+func (e Endpoint) AzurePath() string {
+	if e.Transformer == "azure2" {
+		return "/openai/responses"
+	}
+	return fmt.Sprintf("/openai/deployments/%s/chat/completions", strings.TrimSpace(e.AzureDeploymentName()))
+}
@@ -0,0 +1,66 @@
+package config
+
+import "testing"
+
+func TestAzureEndpointURLs(t *testing.T) {
+	tests := []struct {
+		name                      string
+		endpoint                  Endpoint
+		baseURL, path, apiVersion string
+	}{
+		{
+			name:       "chat from resource",
+			endpoint:   Endpoint{Transformer: "azure", AzureResource: "my-res", Model: "gpt-4o"},
+			baseURL:    "https://my-res.openai.azure.com",
+			path:       "/openai/deployments/gpt-4o/chat/completions",
+			apiVersion: DefaultAzureAPIVersion,
+		},
+		{
+			name:       "chat with deployment and apiUrl",
+			endpoint:   Endpoint{Transformer: "azure", APIUrl: "https://proxy.example.com", AzureResource: "my-res", AzureDeployment: "prod-4o", Model: "gpt-4o", AzureAPIVersion: "2024-06-01"},
+			baseURL:    "https://proxy.example.com",
+			path:       "/openai/deployments/prod-4o/chat/completions",
+			apiVersion: "2024-06-01",
+		},
+		{
+			name:       "responses",
+			endpoint:   Endpoint{Transformer: "azure2", AzureResource: "my-res", AzureDeployment: "prod-4o"},
+			baseURL:    "https://my-res.openai.azure.com",
+			path:       "/openai/responses",
+			apiVersion: DefaultAzureResponsesAPIVersion,
+		},
+	}
+	for _, tt := range tests {
+		if !tt.endpoint.IsAzure() {
+			t.Errorf("%s: IsAzure() = false", tt.name)
+		}
+		if got := tt.endpoint.AzureBaseURL(); got != tt.baseURL {
+			t.Errorf("%s: AzureBaseURL() = %q, want %q", tt.name, got, tt.baseURL)
+		}
+		if got := tt.endpoint.AzurePath(); got != tt.path {
+			t.Errorf("%s: AzurePath() = %q, want %q", tt.name, got, tt.path)
+		}
+		if got := tt.endpoint.AzureAPIVersionOrDefault(); got != tt.apiVersion {
+			t.Errorf("%s: AzureAPIVersionOrDefault() = %q, want %q", tt.name, got, tt.apiVersion)
+		}
+	}
+}
+
+func TestValidateAzureEndpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint Endpoint
+		wantErr  bool
+	}{
+		{"resource without apiUrl", Endpoint{Name: "a", Transformer: "azure", AzureResource: "my-res", APIKey: "k", Model: "gpt-4o"}, false},
+		{"deployment without model", Endpoint{Name: "a", Transformer: "azure2", AzureResource: "my-res", APIKey: "k", AzureDeployment: "prod"}, false},
+		{"no apiUrl or resource", Endpoint{Name: "a", Transformer: "azure", APIKey: "k", Model: "gpt-4o"}, true},
+		{"no deployment or model", Endpoint{Name: "a", Transformer: "azure", AzureResource: "my-res", APIKey: "k"}, true},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Port: 3000, Endpoints: []Endpoint{tt.endpoint}}
+		if err := cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lich0821/ccNexus/internal/schedule"
+)
+
+// DefaultBackupFilenamePattern names scheduled backups when no pattern is set
+const DefaultBackupFilenamePattern = "ccnexus-auto-%Y%m%d-%H%M%S"
+
+// backupFilenameTokens are the time placeholders of a filename pattern
+var backupFilenameTokens = "YmdHMS%"
+
+// BackupScheduleConfig holds the automatic backups, at most one schedule per provider
+type BackupScheduleConfig struct {
+	Schedules []BackupSchedule `json:"schedules"`
+}
+
+// BackupSchedule runs backups to a provider on an interval or a cron expression
+type BackupSchedule struct {
+	Provider        string          `json:"provider"` // webdav | local | s3 | sftp
+	Enabled         bool            `json:"enabled"`
+	Interval        string          `json:"interval,omitempty"`        // Go duration, e.g. 6h
+	Cron            string          `json:"cron,omitempty"`            // Five-field cron expression in local time, e.g. 0 3 * * *
+	FilenamePattern string          `json:"filenamePattern,omitempty"` // %Y %m %d %H %M %S are replaced by the backup time
+	FullEvery       int             `json:"fullEvery,omitempty"`       // Above 1, only every Nth backup is full and the others are incremental
+	Retention       BackupRetention `json:"retention"`
+}
+
+// BackupRetention decides which scheduled backups are kept; zero keeps them all. A backup
+// is kept when any rule selects it.
+type BackupRetention struct {
+	KeepLast    int `json:"keepLast,omitempty"`    // Newest backups
+	KeepDaily   int `json:"keepDaily,omitempty"`   // Newest backup of each of the last N days with a backup
+	KeepWeekly  int `json:"keepWeekly,omitempty"`  // Newest backup of each of the last N ISO weeks with a backup
+	KeepMonthly int `json:"keepMonthly,omitempty"` // Newest backup of each of the last N months with a backup
+}
+
+// IsSet reports whether any retention rule is configured
+func (r BackupRetention) IsSet() bool {
+	return r.KeepLast > 0 || r.KeepDaily > 0 || r.KeepWeekly > 0 || r.KeepMonthly > 0
+}
+
+// Schedule returns the run times of the schedule
+func (s *BackupSchedule) Schedule() (schedule.Schedule, error) {
+	if strings.TrimSpace(s.Cron) != "" {
+		return schedule.ParseCron(s.Cron)
+	}
+	return schedule.ParseInterval(s.Interval)
+}
+
+// FilenamePatternOrDefault returns the filename pattern, defaulting to DefaultBackupFilenamePattern
+func (s *BackupSchedule) FilenamePatternOrDefault() string {
+	if strings.TrimSpace(s.FilenamePattern) == "" {
+		return DefaultBackupFilenamePattern
+	}
+	return strings.TrimSpace(s.FilenamePattern)
+}
+
+// Validate checks a single schedule
+func (s *BackupSchedule) Validate() error {
+	switch s.Provider {
+	case "webdav", "local", "s3", "sftp":
+	default:
+		return fmt.Errorf("unknown backup provider: %s", s.Provider)
+	}
+	hasInterval, hasCron := strings.TrimSpace(s.Interval) != "", strings.TrimSpace(s.Cron) != ""
+	if hasInterval == hasCron {
+		return fmt.Errorf("%s: set either an interval or a cron expression", s.Provider)
+	}
+	if _, err := s.Schedule(); err != nil {
+		return fmt.Errorf("%s: %w", s.Provider, err)
+	}
+	if err := validateBackupFilenamePattern(s.FilenamePatternOrDefault()); err != nil {
+		return fmt.Errorf("%s: %w", s.Provider, err)
+	}
+	if s.FullEvery < 0 {
+		return fmt.Errorf("%s: fullEvery must not be negative", s.Provider)
+	}
+	r := s.Retention
+	if r.KeepLast < 0 || r.KeepDaily < 0 || r.KeepWeekly < 0 || r.KeepMonthly < 0 {
+		return fmt.Errorf("%s: retention counts must not be negative", s.Provider)
+	}
+	return nil
+}
+
+// validateBackupFilenamePattern requires a plain filename down to the minute, so that
+// scheduled backups get distinct names and their time can be read back for retention
+func validateBackupFilenamePattern(pattern string) error {
+	if strings.ContainsAny(pattern, `/\`) {
+		return fmt.Errorf("filename pattern must not contain a path")
+	}
+	seen := map[byte]bool{}
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' {
+			continue
+		}
+		if i+1 >= len(pattern) || !strings.ContainsRune(backupFilenameTokens, rune(pattern[i+1])) {
+			return fmt.Errorf("filename pattern has an unknown placeholder at position %d", i+1)
+		}
+		if pattern[i+1] != '%' && seen[pattern[i+1]] {
+			return fmt.Errorf("filename pattern repeats %%%c", pattern[i+1])
+		}
+		seen[pattern[i+1]] = true
+		i++
+	}
+	for _, token := range "YmdHM" {
+		if !seen[byte(token)] {
+			return fmt.Errorf("filename pattern must contain %%Y, %%m, %%d, %%H and %%M")
+		}
+	}
+	return nil
+}
+
+// Find returns the schedule of a provider, or nil
+func (c *BackupScheduleConfig) Find(provider string) *BackupSchedule {
+	for i := range c.Schedules {
+		if c.Schedules[i].Provider == provider {
+			return &c.Schedules[i]
+		}
+	}
+	return nil
+}
+
+// Validate checks all schedules
+func (c *BackupScheduleConfig) Validate() error {
+	seen := map[string]bool{}
+	for i := range c.Schedules {
+		s := &c.Schedules[i]
+		if err := s.Validate(); err != nil {
+			return err
+		}
+		if seen[s.Provider] {
+			return fmt.Errorf("duplicate schedule for %s", s.Provider)
+		}
+		seen[s.Provider] = true
+	}
+	return nil
+}
+
+// EncodeBackupSchedule serializes the config for storage, returning an empty string for nil
+func EncodeBackupSchedule(c *BackupScheduleConfig) string {
+	if c == nil {
+		return ""
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// DecodeBackupSchedule parses the config from storage; an empty string yields nil
+func DecodeBackupSchedule(data string) (*BackupScheduleConfig, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var c BackupScheduleConfig
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, fmt.Errorf("invalid backup schedule config: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetBackupSchedule returns the backup schedule configuration (thread-safe)
+func (c *Config) GetBackupSchedule() *BackupScheduleConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.BackupSchedule
+}
+
+// UpdateBackupSchedule updates the backup schedule configuration (thread-safe)
+func (c *Config) UpdateBackupSchedule(schedule *BackupScheduleConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.BackupSchedule = schedule
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Capability names used in endpoint profiles
+const (
+	CapThinking       = "thinking"
+	CapParallelTools  = "parallel_tools"
+	CapImages         = "images"
+	CapToolChoice     = "tool_choice"
+	CapResponseFormat = "response_format"
+	CapSystem         = "system"
+)
+
+// AllCapabilities lists every capability in a stable order
+var AllCapabilities = []string{CapThinking, CapParallelTools, CapImages, CapToolChoice, CapResponseFormat, CapSystem}
+
+// transformerUnsupported lists capabilities a transformer cannot express upstream
+var transformerUnsupported = map[string][]string{
+	"ollama": {CapToolChoice},
+}
+
+// Capabilities is the feature profile of an endpoint.
+// A nil field means unknown, in which case the transformer default applies.
+type Capabilities struct {
+	Thinking       *bool  `json:"thinking,omitempty"`
+	ParallelTools  *bool  `json:"parallelTools,omitempty"`
+	Images         *bool  `json:"images,omitempty"`
+	ToolChoice     *bool  `json:"toolChoice,omitempty"`
+	ResponseFormat *bool  `json:"responseFormat,omitempty"`
+	System         *bool  `json:"system,omitempty"`
+	DetectedAt     string `json:"detectedAt,omitempty"` // Set when the profile was auto-detected
+}
+
+// field returns a pointer to the profile field for a capability
+func (c *Capabilities) field(capability string) **bool {
+	switch capability {
+	case CapThinking:
+		return &c.Thinking
+	case CapParallelTools:
+		return &c.ParallelTools
+	case CapImages:
+		return &c.Images
+	case CapToolChoice:
+		return &c.ToolChoice
+	case CapResponseFormat:
+		return &c.ResponseFormat
+	case CapSystem:
+		return &c.System
+	}
+	return nil
+}
+
+// Get returns the explicit value of a capability, or nil when unknown
+func (c *Capabilities) Get(capability string) *bool {
+	if c == nil {
+		return nil
+	}
+	if f := c.field(capability); f != nil {
+		return *f
+	}
+	return nil
+}
+
+// Set records whether a capability is supported
+func (c *Capabilities) Set(capability string, supported bool) {
+	if f := c.field(capability); f != nil {
+		*f = &supported
+	}
+}
+
+// Supports reports whether the endpoint supports a capability,
+// falling back to the transformer default when the profile does not say
+func (e Endpoint) Supports(capability string) bool {
+	if v := e.Capabilities.Get(capability); v != nil {
+		return *v
+	}
+	return !TransformerLacks(e.Transformer, capability)
+}
+
+// TransformerLacks reports whether a transformer cannot express a capability at all
+func TransformerLacks(transformerName, capability string) bool {
+	for _, c := range transformerUnsupported[transformerName] {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingCapabilities returns the capabilities in features that the endpoint lacks
+func (e Endpoint) MissingCapabilities(features []string) []string {
+	var missing []string
+	for _, f := range features {
+		if !e.Supports(f) {
+			missing = append(missing, f)
+		}
+	}
+	return missing
+}
+
+// String summarizes the explicit profile, e.g. "images=false,thinking=true"
+func (c *Capabilities) String() string {
+	if c == nil {
+		return ""
+	}
+	var parts []string
+	for _, capability := range AllCapabilities {
+		if v := c.Get(capability); v != nil {
+			parts = append(parts, fmt.Sprintf("%s=%t", capability, *v))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// EncodeCapabilities serializes a profile for storage, returning an empty string for no profile
+func EncodeCapabilities(c *Capabilities) string {
+	if c == nil {
+		return ""
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// storedCapabilities encodes the profile for storage, keeping a stored profile that could not be
+// decoded until a new one is set
+func (e Endpoint) storedCapabilities() string {
+	if e.Capabilities == nil && e.rawCapabilities != "" {
+		return e.rawCapabilities
+	}
+	return EncodeCapabilities(e.Capabilities)
+}
+
+// DecodeCapabilities parses a profile from storage
+func DecodeCapabilities(data string) (*Capabilities, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var c Capabilities
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, fmt.Errorf("invalid capabilities: %w", err)
+	}
+	return &c, nil
+}
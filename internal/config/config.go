@@ -1,21 +1,36 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strconv"
 	"sync"
+
+	"github.com/lich0821/ccNexus/internal/logger"
 )
 
 // Endpoint represents a single API endpoint configuration
 type Endpoint struct {
-	Name        string `json:"name"`
-	APIUrl      string `json:"apiUrl"`
-	APIKey      string `json:"apiKey"`
-	Enabled     bool   `json:"enabled"`
-	Transformer string `json:"transformer,omitempty"` // Transformer type: claude, openai, gemini, deepseek
-	Model       string `json:"model,omitempty"`       // Target model name for non-Claude APIs
-	Remark      string `json:"remark,omitempty"`      // Optional remark for the endpoint
+	UID             string `json:"uid,omitempty"` // Stable identity across renames and devices, assigned automatically
+	Name            string `json:"name"`
+	APIUrl          string `json:"apiUrl"`
+	APIKey          string `json:"apiKey"`
+	Enabled         bool   `json:"enabled"`
+	Transformer     string `json:"transformer,omitempty"`     // Transformer type: claude, openai, openai2, gemini, azure, azure2
+	Model           string `json:"model,omitempty"`           // Target model name for non-Claude APIs
+	Remark          string `json:"remark,omitempty"`          // Optional remark for the endpoint
+	AzureResource   string `json:"azureResource,omitempty"`   // Azure OpenAI resource name (used when apiUrl is empty)
+	AzureDeployment string `json:"azureDeployment,omitempty"` // Azure OpenAI deployment name (defaults to model)
+	AzureAPIVersion string `json:"azureApiVersion,omitempty"` // Azure OpenAI api-version query parameter
+
+	RewriteRules []RewriteRule `json:"rewriteRules,omitempty"` // Ordered header/body/response rewrite rules
+	Capabilities *Capabilities `json:"capabilities,omitempty"` // Feature profile, auto-detected or set manually
+
+	// Stored JSON that could not be decoded, written back unchanged until the field is set again
+	rawRewriteRules string
+	rawCapabilities string
 }
 
 // WebDAVConfig represents WebDAV synchronization configuration
@@ -45,11 +60,24 @@ type S3BackupConfig struct {
 	ForcePathStyle bool   `json:"forcePathStyle"`
 }
 
+// SFTPBackupConfig represents SFTP backup configuration; either a password or a private key is required
+type SFTPBackupConfig struct {
+	Host       string `json:"host"`
+	Port       int    `json:"port,omitempty"` // Default 22
+	Username   string `json:"username"`
+	Password   string `json:"password,omitempty"`
+	PrivateKey string `json:"privateKey,omitempty"` // PEM encoded private key
+	Passphrase string `json:"passphrase,omitempty"` // Passphrase of an encrypted private key
+	Dir        string `json:"dir"`                  // Remote directory to store backups
+	HostKey    string `json:"hostKey,omitempty"`    // SHA256 fingerprint of the server key, e.g. SHA256:...; empty pins the key seen first
+}
+
 // BackupConfig represents backup/sync configuration across providers
 type BackupConfig struct {
-	Provider string             `json:"provider"` // webdav | local | s3
+	Provider string             `json:"provider"` // webdav | local | s3 | sftp
 	Local    *LocalBackupConfig `json:"local,omitempty"`
 	S3       *S3BackupConfig    `json:"s3,omitempty"`
+	SFTP     *SFTPBackupConfig  `json:"sftp,omitempty"`
 }
 
 // UpdateConfig represents update configuration
@@ -88,9 +116,18 @@ type Config struct {
 	ClaudeNotificationType    string          `json:"claudeNotificationType"`        // Notification type: toast, dialog, disabled
 	WebDAV                    *WebDAVConfig   `json:"webdav,omitempty"`              // WebDAV synchronization config
 	Backup              *BackupConfig   `json:"backup,omitempty"`              // Backup/sync configuration
+	BackupSchedule      *BackupScheduleConfig `json:"backupSchedule,omitempty"` // Automatic backups
+	StatsSync           *StatsSyncConfig      `json:"statsSync,omitempty"`      // Multi-device stats sync
+	StatsReport         *StatsReportConfig    `json:"statsReport,omitempty"`    // Monthly stats report
+	StatsArchive        *StatsArchiveConfig   `json:"statsArchive,omitempty"`   // Monthly rollups of old daily stats
 	Update              *UpdateConfig   `json:"update,omitempty"`              // Update configuration
 	Terminal            *TerminalConfig `json:"terminal,omitempty"`            // Terminal launcher config
 	Proxy               *ProxyConfig    `json:"proxy,omitempty"`               // HTTP proxy config
+	ContextGuard        *ContextGuardConfig `json:"contextGuard,omitempty"`    // Context window guard
+	ResponseCache       *ResponseCacheConfig `json:"responseCache,omitempty"`  // Response cache
+	ToolRepair          *ToolRepairConfig    `json:"toolRepair,omitempty"`     // Tool call argument repair
+	ServerTools         *ServerToolsConfig   `json:"serverTools,omitempty"`    // Tools executed by the proxy
+	StatsHourlyDays     int                  `json:"statsHourlyDays,omitempty"` // Days hourly usage is kept before downsampling to days, 0 = default
 	mu                  sync.RWMutex
 }
 
@@ -132,10 +169,10 @@ func (c *Config) Validate() error {
 	}
 
 	for i, ep := range c.Endpoints {
-		if ep.APIUrl == "" {
+		if ep.APIUrl == "" && !(ep.IsAzure() && ep.AzureResource != "") {
 			return fmt.Errorf("endpoint %d: apiUrl is required", i+1)
 		}
-		if ep.APIKey == "" {
+		if ep.APIKey == "" && ep.Transformer != "ollama" {
 			return fmt.Errorf("endpoint %d: apiKey is required", i+1)
 		}
 
@@ -145,9 +182,17 @@ func (c *Config) Validate() error {
 		}
 
 		// Non-Claude transformers require model field
-		if ep.Transformer != "claude" && ep.Model == "" {
+		if ep.IsAzure() {
+			if ep.AzureDeploymentName() == "" {
+				return fmt.Errorf("endpoint %d (%s): deployment or model is required for transformer '%s'", i+1, ep.Name, ep.Transformer)
+			}
+		} else if ep.Transformer != "claude" && ep.Model == "" {
 			return fmt.Errorf("endpoint %d (%s): model is required for transformer '%s'", i+1, ep.Name, ep.Transformer)
 		}
+
+		if err := ValidateRewriteRules(ep.RewriteRules); err != nil {
+			return fmt.Errorf("endpoint %d (%s): %w", i+1, ep.Name, err)
+		}
 	}
 
 	return nil
@@ -181,9 +226,35 @@ func (c *Config) GetLogLevel() int {
 func (c *Config) UpdateEndpoints(endpoints []Endpoint) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	for i := range endpoints {
+		if endpoints[i].UID == "" {
+			endpoints[i].UID = NewEndpointUID()
+		}
+	}
 	c.Endpoints = endpoints
 }
 
+// UpdateEndpoint applies fn to the endpoint with the given name (thread-safe), leaving the other
+// endpoints as they are. It reports whether the endpoint exists.
+func (c *Config) UpdateEndpoint(name string, fn func(*Endpoint)) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.Endpoints {
+		if c.Endpoints[i].Name == name {
+			fn(&c.Endpoints[i])
+			return true
+		}
+	}
+	return false
+}
+
+// NewEndpointUID returns a random endpoint identity
+func NewEndpointUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 // UpdatePort updates the port (thread-safe)
 func (c *Config) UpdatePort(port int) {
 	c.mu.Lock()
@@ -198,6 +269,20 @@ func (c *Config) UpdateLogLevel(level int) {
 	c.LogLevel = level
 }
 
+// GetStatsHourlyDays returns how many days hourly usage is kept, 0 for the default (thread-safe)
+func (c *Config) GetStatsHourlyDays() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.StatsHourlyDays
+}
+
+// UpdateStatsHourlyDays updates how many days hourly usage is kept (thread-safe)
+func (c *Config) UpdateStatsHourlyDays(days int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.StatsHourlyDays = days
+}
+
 // GetLanguage returns the configured language (thread-safe)
 func (c *Config) GetLanguage() string {
 	c.mu.RLock()
@@ -410,14 +495,20 @@ type StorageAdapter interface {
 
 // StorageEndpoint represents an endpoint in storage
 type StorageEndpoint struct {
-	Name        string
-	APIUrl      string
-	APIKey      string
-	Enabled     bool
-	Transformer string
-	Model       string
-	Remark      string
-	SortOrder   int
+	UID             string
+	Name            string
+	APIUrl          string
+	APIKey          string
+	Enabled         bool
+	Transformer     string
+	Model           string
+	Remark          string
+	SortOrder       int
+	AzureResource   string
+	AzureDeployment string
+	AzureAPIVersion string
+	RewriteRules    string // JSON-encoded []RewriteRule
+	Capabilities    string // JSON-encoded Capabilities
 }
 
 // LoadFromStorage loads configuration from SQLite storage
@@ -434,13 +525,29 @@ func LoadFromStorage(storage StorageAdapter) (*Config, error) {
 
 	for _, ep := range endpoints {
 		endpoint := Endpoint{
-			Name:        ep.Name,
-			APIUrl:      ep.APIUrl,
-			APIKey:      ep.APIKey,
-			Enabled:     ep.Enabled,
-			Transformer: ep.Transformer,
-			Model:       ep.Model,
-			Remark:      ep.Remark,
+			UID:             ep.UID,
+			Name:            ep.Name,
+			APIUrl:          ep.APIUrl,
+			APIKey:          ep.APIKey,
+			Enabled:         ep.Enabled,
+			Transformer:     ep.Transformer,
+			Model:           ep.Model,
+			Remark:          ep.Remark,
+			AzureResource:   ep.AzureResource,
+			AzureDeployment: ep.AzureDeployment,
+			AzureAPIVersion: ep.AzureAPIVersion,
+		}
+		if rules, err := DecodeRewriteRules(ep.RewriteRules); err == nil {
+			endpoint.RewriteRules = rules
+		} else {
+			logger.Warn("Endpoint %s: ignoring stored rewrite rules: %v", ep.Name, err)
+			endpoint.rawRewriteRules = ep.RewriteRules
+		}
+		if caps, err := DecodeCapabilities(ep.Capabilities); err == nil {
+			endpoint.Capabilities = caps
+		} else {
+			logger.Warn("Endpoint %s: ignoring stored capabilities: %v", ep.Name, err)
+			endpoint.rawCapabilities = ep.Capabilities
 		}
 		if endpoint.Transformer == "" {
 			endpoint.Transformer = "claude"
@@ -468,6 +575,12 @@ func LoadFromStorage(storage StorageAdapter) (*Config, error) {
 		config.Language = lang
 	}
 
+	if daysStr, err := storage.GetConfig("statsHourlyDays"); err == nil && daysStr != "" {
+		if days, err := strconv.Atoi(daysStr); err == nil {
+			config.StatsHourlyDays = days
+		}
+	}
+
 	if widthStr, err := storage.GetConfig("windowWidth"); err == nil && widthStr != "" {
 		if width, err := strconv.Atoi(widthStr); err == nil {
 			config.WindowWidth = width
@@ -575,6 +688,28 @@ func LoadFromStorage(storage StorageAdapter) (*Config, error) {
 			ForcePathStyle: s3ForcePathStyleStr == "true",
 		}
 	}
+	if provider == "sftp" {
+		sftpHost, _ := storage.GetConfig("backup_sftp_host")
+		sftpPortStr, _ := storage.GetConfig("backup_sftp_port")
+		sftpUsername, _ := storage.GetConfig("backup_sftp_username")
+		sftpPassword, _ := storage.GetConfig("backup_sftp_password")
+		sftpPrivateKey, _ := storage.GetConfig("backup_sftp_privateKey")
+		sftpPassphrase, _ := storage.GetConfig("backup_sftp_passphrase")
+		sftpDir, _ := storage.GetConfig("backup_sftp_dir")
+		sftpHostKey, _ := storage.GetConfig("backup_sftp_hostKey")
+		sftpPort, _ := strconv.Atoi(sftpPortStr)
+
+		config.Backup.SFTP = &SFTPBackupConfig{
+			Host:       sftpHost,
+			Port:       sftpPort,
+			Username:   sftpUsername,
+			Password:   sftpPassword,
+			PrivateKey: sftpPrivateKey,
+			Passphrase: sftpPassphrase,
+			Dir:        sftpDir,
+			HostKey:    sftpHostKey,
+		}
+	}
 
 	// Load Update config
 	config.Update = &UpdateConfig{
@@ -616,6 +751,62 @@ func LoadFromStorage(storage StorageAdapter) (*Config, error) {
 		config.Proxy = &ProxyConfig{URL: proxyURL}
 	}
 
+	// Load context guard config
+	if guardStr, err := storage.GetConfig("context_guard"); err == nil && guardStr != "" {
+		if guard, err := DecodeContextGuard(guardStr); err == nil {
+			config.ContextGuard = guard
+		}
+	}
+
+	// Load response cache config
+	if cacheStr, err := storage.GetConfig("response_cache"); err == nil && cacheStr != "" {
+		if cache, err := DecodeResponseCache(cacheStr); err == nil {
+			config.ResponseCache = cache
+		}
+	}
+
+	// Load tool repair config
+	if repairStr, err := storage.GetConfig("tool_repair"); err == nil && repairStr != "" {
+		if repair, err := DecodeToolRepair(repairStr); err == nil {
+			config.ToolRepair = repair
+		}
+	}
+
+	// Load server tools config
+	if toolsStr, err := storage.GetConfig("server_tools"); err == nil && toolsStr != "" {
+		if tools, err := DecodeServerTools(toolsStr); err == nil {
+			config.ServerTools = tools
+		}
+	}
+
+	// Load backup schedule config
+	if scheduleStr, err := storage.GetConfig("backup_schedule"); err == nil && scheduleStr != "" {
+		if schedule, err := DecodeBackupSchedule(scheduleStr); err == nil {
+			config.BackupSchedule = schedule
+		}
+	}
+
+	// Load stats sync config
+	if syncStr, err := storage.GetConfig("stats_sync"); err == nil && syncStr != "" {
+		if sync, err := DecodeStatsSync(syncStr); err == nil {
+			config.StatsSync = sync
+		}
+	}
+
+	// Load stats report config
+	if reportStr, err := storage.GetConfig("stats_report"); err == nil && reportStr != "" {
+		if report, err := DecodeStatsReport(reportStr); err == nil {
+			config.StatsReport = report
+		}
+	}
+
+	// Load stats archive config
+	if archiveStr, err := storage.GetConfig("stats_archive"); err == nil && archiveStr != "" {
+		if archive, err := DecodeStatsArchive(archiveStr); err == nil {
+			config.StatsArchive = archive
+		}
+	}
+
 	// Load Claude notification config
 	if enabledStr, err := storage.GetConfig("claude_notification_enabled"); err == nil && enabledStr != "" {
 		config.ClaudeNotificationEnabled = enabledStr == "true"
@@ -643,24 +834,43 @@ func (c *Config) SaveToStorage(storage StorageAdapter) error {
 	}
 
 	existingNames := make(map[string]bool)
+	existingUIDs := make(map[string]string) // name -> uid
+	uidNames := make(map[string]string)     // uid -> name
 	for _, ep := range existingEndpoints {
 		existingNames[ep.Name] = true
+		existingUIDs[ep.Name] = ep.UID
+		if ep.UID != "" {
+			uidNames[ep.UID] = ep.Name
+		}
 	}
 
 	// Save/update endpoints
 	for i, ep := range c.Endpoints {
 		endpoint := &StorageEndpoint{
-			Name:        ep.Name,
-			APIUrl:      ep.APIUrl,
-			APIKey:      ep.APIKey,
-			Enabled:     ep.Enabled,
-			Transformer: ep.Transformer,
-			Model:       ep.Model,
-			Remark:      ep.Remark,
-			SortOrder:   i, // Use array index as sort order
+			UID:             ep.UID,
+			Name:            ep.Name,
+			APIUrl:          ep.APIUrl,
+			APIKey:          ep.APIKey,
+			Enabled:         ep.Enabled,
+			Transformer:     ep.Transformer,
+			Model:           ep.Model,
+			Remark:          ep.Remark,
+			SortOrder:       i, // Use array index as sort order
+			AzureResource:   ep.AzureResource,
+			AzureDeployment: ep.AzureDeployment,
+			AzureAPIVersion: ep.AzureAPIVersion,
+			RewriteRules:    ep.storedRewriteRules(),
+			Capabilities:    ep.storedCapabilities(),
 		}
 
-		if existingNames[ep.Name] {
+		// Endpoints are matched by UID first, so a renamed endpoint keeps its row
+		if oldName, ok := uidNames[ep.UID]; ok && existingNames[oldName] {
+			if err := storage.UpdateEndpoint(endpoint); err != nil {
+				return fmt.Errorf("failed to update endpoint %s: %w", ep.Name, err)
+			}
+			delete(existingNames, oldName)
+		} else if existingNames[ep.Name] {
+			endpoint.UID = existingUIDs[ep.Name]
 			if err := storage.UpdateEndpoint(endpoint); err != nil {
 				return fmt.Errorf("failed to update endpoint %s: %w", ep.Name, err)
 			}
@@ -683,6 +893,7 @@ func (c *Config) SaveToStorage(storage StorageAdapter) error {
 	storage.SetConfig("port", strconv.Itoa(c.Port))
 	storage.SetConfig("logLevel", strconv.Itoa(c.LogLevel))
 	storage.SetConfig("language", c.Language)
+	storage.SetConfig("statsHourlyDays", strconv.Itoa(c.StatsHourlyDays))
 	storage.SetConfig("theme", c.Theme)
 	storage.SetConfig("themeAuto", strconv.FormatBool(c.ThemeAuto))
 	storage.SetConfig("autoLightTheme", c.AutoLightTheme)
@@ -717,6 +928,16 @@ func (c *Config) SaveToStorage(storage StorageAdapter) error {
 			storage.SetConfig("backup_s3_useSSL", strconv.FormatBool(c.Backup.S3.UseSSL))
 			storage.SetConfig("backup_s3_forcePathStyle", strconv.FormatBool(c.Backup.S3.ForcePathStyle))
 		}
+		if c.Backup.SFTP != nil {
+			storage.SetConfig("backup_sftp_host", c.Backup.SFTP.Host)
+			storage.SetConfig("backup_sftp_port", strconv.Itoa(c.Backup.SFTP.Port))
+			storage.SetConfig("backup_sftp_username", c.Backup.SFTP.Username)
+			storage.SetConfig("backup_sftp_password", c.Backup.SFTP.Password)
+			storage.SetConfig("backup_sftp_privateKey", c.Backup.SFTP.PrivateKey)
+			storage.SetConfig("backup_sftp_passphrase", c.Backup.SFTP.Passphrase)
+			storage.SetConfig("backup_sftp_dir", c.Backup.SFTP.Dir)
+			storage.SetConfig("backup_sftp_hostKey", c.Backup.SFTP.HostKey)
+		}
 	}
 
 	// Save Update config
@@ -742,6 +963,30 @@ func (c *Config) SaveToStorage(storage StorageAdapter) error {
 		storage.SetConfig("proxy_url", "")
 	}
 
+	// Save context guard config
+	storage.SetConfig("context_guard", EncodeContextGuard(c.ContextGuard))
+
+	// Save response cache config
+	storage.SetConfig("response_cache", EncodeResponseCache(c.ResponseCache))
+
+	// Save tool repair config
+	storage.SetConfig("tool_repair", EncodeToolRepair(c.ToolRepair))
+
+	// Save server tools config
+	storage.SetConfig("server_tools", EncodeServerTools(c.ServerTools))
+
+	// Save backup schedule config
+	storage.SetConfig("backup_schedule", EncodeBackupSchedule(c.BackupSchedule))
+
+	// Save stats sync config
+	storage.SetConfig("stats_sync", EncodeStatsSync(c.StatsSync))
+
+	// Save stats report config
+	storage.SetConfig("stats_report", EncodeStatsReport(c.StatsReport))
+
+	// Save stats archive config
+	storage.SetConfig("stats_archive", EncodeStatsArchive(c.StatsArchive))
+
 	// Save Claude notification config
 	storage.SetConfig("claude_notification_enabled", strconv.FormatBool(c.ClaudeNotificationEnabled))
 	storage.SetConfig("claude_notification_type", c.ClaudeNotificationType)
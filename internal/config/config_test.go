@@ -0,0 +1,75 @@
+package config
+
+import "testing"
+
+// memoryStorage is a StorageAdapter that keeps endpoints and settings in memory
+type memoryStorage struct {
+	endpoints []StorageEndpoint
+	config    map[string]string
+}
+
+func (m *memoryStorage) GetEndpoints() ([]StorageEndpoint, error) { return m.endpoints, nil }
+
+func (m *memoryStorage) SaveEndpoint(ep *StorageEndpoint) error {
+	m.endpoints = append(m.endpoints, *ep)
+	return nil
+}
+
+func (m *memoryStorage) UpdateEndpoint(ep *StorageEndpoint) error {
+	for i := range m.endpoints {
+		if m.endpoints[i].Name == ep.Name {
+			m.endpoints[i] = *ep
+		}
+	}
+	return nil
+}
+
+func (m *memoryStorage) DeleteEndpoint(name string) error {
+	for i := range m.endpoints {
+		if m.endpoints[i].Name == name {
+			m.endpoints = append(m.endpoints[:i], m.endpoints[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *memoryStorage) GetConfig(key string) (string, error) { return m.config[key], nil }
+
+func (m *memoryStorage) SetConfig(key, value string) error {
+	m.config[key] = value
+	return nil
+}
+
+func TestUndecodableEndpointSettingsSurviveSave(t *testing.T) {
+	const rules, caps = `[{"action": "set_header"`, `{"thinking": "yes"}`
+	store := &memoryStorage{
+		endpoints: []StorageEndpoint{{Name: "a", APIUrl: "https://a", APIKey: "k", Enabled: true, Transformer: "claude", RewriteRules: rules, Capabilities: caps}},
+		config:    map[string]string{},
+	}
+
+	cfg, err := LoadFromStorage(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep := cfg.GetEndpoints()[0]; ep.RewriteRules != nil || ep.Capabilities != nil {
+		t.Fatalf("Expected undecodable settings to be ignored, got %+v", ep)
+	}
+	if err := cfg.SaveToStorage(store); err != nil {
+		t.Fatal(err)
+	}
+	if ep := store.endpoints[0]; ep.RewriteRules != rules || ep.Capabilities != caps {
+		t.Fatalf("Saving rewrote the stored settings to %q and %q", ep.RewriteRules, ep.Capabilities)
+	}
+
+	// Settings set afterwards replace the stored JSON
+	endpoints := cfg.GetEndpoints()
+	endpoints[0].RewriteRules = []RewriteRule{{Action: RewriteSetHeader, Header: "x-a", Value: "1"}}
+	cfg.UpdateEndpoints(endpoints)
+	if err := cfg.SaveToStorage(store); err != nil {
+		t.Fatal(err)
+	}
+	if ep := store.endpoints[0]; ep.RewriteRules == rules || ep.Capabilities != caps {
+		t.Fatalf("Expected the new rules and the kept capabilities, got %q and %q", ep.RewriteRules, ep.Capabilities)
+	}
+}
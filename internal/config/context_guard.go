@@ -0,0 +1,144 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Context guard actions taken when a request exceeds the model's context window
+const (
+	ContextActionReroute          = "reroute"            // Send the request to an endpoint with a larger window
+	ContextActionCompact          = "compact"            // Compact the request in place
+	ContextActionRerouteOrCompact = "reroute_or_compact" // Reroute when possible, otherwise compact
+)
+
+// Compaction steps, applied in the configured order until the request fits
+const (
+	CompactDropToolResults = "drop_tool_results" // Replace old tool result bodies with a placeholder
+	CompactTrimTurns       = "trim_turns"        // Remove middle turns, oldest first
+)
+
+// DefaultKeepTurns is the number of recent turns compaction never touches
+const DefaultKeepTurns = 4
+
+// DefaultCompaction is the compaction policy used when none is configured
+var DefaultCompaction = []string{CompactDropToolResults, CompactTrimTurns}
+
+// ContextGuardConfig keeps requests within the context window of the upstream model
+type ContextGuardConfig struct {
+	Enabled     bool           `json:"enabled"`
+	ModelLimits map[string]int `json:"modelLimits"`          // Max input tokens by model name; a trailing * matches a prefix
+	Action      string         `json:"action,omitempty"`     // reroute | compact | reroute_or_compact (default)
+	Compaction  []string       `json:"compaction,omitempty"` // Ordered compaction steps (default: drop_tool_results, trim_turns)
+	KeepTurns   int            `json:"keepTurns,omitempty"`  // Recent turns kept intact (default 4)
+}
+
+// LimitFor returns the max input tokens configured for a model, or 0 when there is none.
+// Exact names win over prefixes, and longer prefixes over shorter ones.
+func (g *ContextGuardConfig) LimitFor(model string) int {
+	if g == nil || model == "" {
+		return 0
+	}
+	model = strings.ToLower(model)
+
+	limit, matched := 0, -1
+	for key, value := range g.ModelLimits {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == model {
+			return value
+		}
+		if prefix, ok := strings.CutSuffix(key, "*"); ok && strings.HasPrefix(model, prefix) && len(prefix) > matched {
+			limit, matched = value, len(prefix)
+		}
+	}
+	return limit
+}
+
+// ActionOrDefault returns the configured action, defaulting to reroute_or_compact
+func (g *ContextGuardConfig) ActionOrDefault() string {
+	if g.Action == "" {
+		return ContextActionRerouteOrCompact
+	}
+	return g.Action
+}
+
+// CompactionOrDefault returns the configured compaction steps, defaulting to DefaultCompaction
+func (g *ContextGuardConfig) CompactionOrDefault() []string {
+	if len(g.Compaction) == 0 {
+		return DefaultCompaction
+	}
+	return g.Compaction
+}
+
+// KeepTurnsOrDefault returns the number of recent turns to keep, defaulting to DefaultKeepTurns
+func (g *ContextGuardConfig) KeepTurnsOrDefault() int {
+	if g.KeepTurns <= 0 {
+		return DefaultKeepTurns
+	}
+	return g.KeepTurns
+}
+
+// Validate checks the action, compaction steps and limits
+func (g *ContextGuardConfig) Validate() error {
+	switch g.Action {
+	case "", ContextActionReroute, ContextActionCompact, ContextActionRerouteOrCompact:
+	default:
+		return fmt.Errorf("unknown context guard action: %s", g.Action)
+	}
+	for _, step := range g.Compaction {
+		if step != CompactDropToolResults && step != CompactTrimTurns {
+			return fmt.Errorf("unknown compaction step: %s", step)
+		}
+	}
+	for model, limit := range g.ModelLimits {
+		if limit <= 0 {
+			return fmt.Errorf("invalid context limit for %s: %d", model, limit)
+		}
+	}
+	if g.KeepTurns < 0 {
+		return fmt.Errorf("keepTurns must not be negative")
+	}
+	return nil
+}
+
+// EncodeContextGuard serializes the config for storage, returning an empty string for nil
+func EncodeContextGuard(g *ContextGuardConfig) string {
+	if g == nil {
+		return ""
+	}
+	data, err := json.Marshal(g)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// DecodeContextGuard parses the config from storage; an empty string yields nil
+func DecodeContextGuard(data string) (*ContextGuardConfig, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var g ContextGuardConfig
+	if err := json.Unmarshal([]byte(data), &g); err != nil {
+		return nil, fmt.Errorf("invalid context guard config: %w", err)
+	}
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// GetContextGuard returns the context guard configuration (thread-safe)
+func (c *Config) GetContextGuard() *ContextGuardConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ContextGuard
+}
+
+// UpdateContextGuard updates the context guard configuration (thread-safe)
+func (c *Config) UpdateContextGuard(guard *ContextGuardConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ContextGuard = guard
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Replay modes for cached streaming responses
+const (
+	CacheReplayInstant = "instant" // Write all events at once
+	CacheReplayTimed   = "timed"   // Reproduce the original gaps between events
+)
+
+// Response cache defaults
+const (
+	DefaultCacheTTLSeconds = 3600
+	DefaultCacheMaxEntries = 1000
+	DefaultCacheMaxSizeMB  = 100
+)
+
+// ResponseCacheConfig caches responses to identical deterministic requests
+type ResponseCacheConfig struct {
+	Enabled    bool        `json:"enabled"`
+	TTLSeconds int         `json:"ttlSeconds,omitempty"` // Entry lifetime (default 3600)
+	MaxEntries int         `json:"maxEntries,omitempty"` // Max cached responses (default 1000)
+	MaxSizeMB  int         `json:"maxSizeMB,omitempty"`  // Max total size of cached bodies (default 100)
+	Replay     string      `json:"replay,omitempty"`     // instant (default) | timed
+	Rules      []CacheRule `json:"rules,omitempty"`      // First matching rule decides; without rules every request may be cached
+}
+
+// CacheRule enables or disables caching for requests matching a model and path
+type CacheRule struct {
+	Model         string `json:"model,omitempty"`         // Model name; a trailing * matches a prefix; empty matches all
+	Path          string `json:"path,omitempty"`          // Request path prefix, e.g. /v1/messages; empty matches all
+	Enabled       bool   `json:"enabled"`                 // Whether matching requests are cached
+	AllowSampling bool   `json:"allowSampling,omitempty"` // Also cache requests with a non-zero temperature
+}
+
+// Match reports whether the rule applies to a request
+func (r CacheRule) Match(model, path string) bool {
+	if r.Path != "" && !strings.HasPrefix(path, r.Path) {
+		return false
+	}
+	if r.Model == "" {
+		return true
+	}
+	pattern, model := strings.ToLower(r.Model), strings.ToLower(model)
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(model, prefix)
+	}
+	return pattern == model
+}
+
+// RuleFor returns the rule deciding whether a request is cached. Without rules, every request is
+// cached as long as it is deterministic; with rules, unmatched requests are not cached.
+func (c *ResponseCacheConfig) RuleFor(model, path string) (CacheRule, bool) {
+	if len(c.Rules) == 0 {
+		return CacheRule{Enabled: true}, true
+	}
+	for _, rule := range c.Rules {
+		if rule.Match(model, path) {
+			return rule, true
+		}
+	}
+	return CacheRule{}, false
+}
+
+// TTLOrDefault returns the entry lifetime in seconds, defaulting to DefaultCacheTTLSeconds
+func (c *ResponseCacheConfig) TTLOrDefault() int {
+	if c.TTLSeconds <= 0 {
+		return DefaultCacheTTLSeconds
+	}
+	return c.TTLSeconds
+}
+
+// MaxEntriesOrDefault returns the max number of entries, defaulting to DefaultCacheMaxEntries
+func (c *ResponseCacheConfig) MaxEntriesOrDefault() int {
+	if c.MaxEntries <= 0 {
+		return DefaultCacheMaxEntries
+	}
+	return c.MaxEntries
+}
+
+// MaxBytesOrDefault returns the max total size in bytes, defaulting to DefaultCacheMaxSizeMB
+func (c *ResponseCacheConfig) MaxBytesOrDefault() int64 {
+	size := c.MaxSizeMB
+	if size <= 0 {
+		size = DefaultCacheMaxSizeMB
+	}
+	return int64(size) << 20
+}
+
+// ReplayOrDefault returns the replay mode, defaulting to instant
+func (c *ResponseCacheConfig) ReplayOrDefault() string {
+	if c.Replay == "" {
+		return CacheReplayInstant
+	}
+	return c.Replay
+}
+
+// Validate checks the replay mode and limits
+func (c *ResponseCacheConfig) Validate() error {
+	switch c.Replay {
+	case "", CacheReplayInstant, CacheReplayTimed:
+	default:
+		return fmt.Errorf("unknown cache replay mode: %s", c.Replay)
+	}
+	if c.TTLSeconds < 0 || c.MaxEntries < 0 || c.MaxSizeMB < 0 {
+		return fmt.Errorf("cache limits must not be negative")
+	}
+	return nil
+}
+
+// EncodeResponseCache serializes the config for storage, returning an empty string for nil
+func EncodeResponseCache(c *ResponseCacheConfig) string {
+	if c == nil {
+		return ""
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// DecodeResponseCache parses the config from storage; an empty string yields nil
+func DecodeResponseCache(data string) (*ResponseCacheConfig, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var c ResponseCacheConfig
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, fmt.Errorf("invalid response cache config: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetResponseCache returns the response cache configuration (thread-safe)
+func (c *Config) GetResponseCache() *ResponseCacheConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ResponseCache
+}
+
+// UpdateResponseCache updates the response cache configuration (thread-safe)
+func (c *Config) UpdateResponseCache(cache *ResponseCacheConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ResponseCache = cache
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rewrite rule actions
+const (
+	RewriteSetHeader    = "set_header"    // Set a request header
+	RewriteRemoveHeader = "remove_header" // Remove a request header
+	RewriteSet          = "set"           // Set a request body field (JSONPath)
+	RewriteDelete       = "delete"        // Delete a request body field (JSONPath)
+	RewriteClamp        = "clamp"         // Clamp a numeric request body field (JSONPath)
+	RewritePrefix       = "prefix"        // Prepend text to a request body field (JSONPath)
+	RewriteReplace      = "replace"       // Regex replace on non-streaming response text
+)
+
+// Phases of request body rules
+const (
+	RewritePhaseUpstream = "upstream" // After TransformRequest, in the upstream API format (default)
+	RewritePhaseClient   = "client"   // Before TransformRequest, in the client API format
+)
+
+// RewriteRule is a declarative request/response tweak applied to a single endpoint.
+// Rules run in the order they are configured.
+type RewriteRule struct {
+	Action      string      `json:"action"`
+	Phase       string      `json:"phase,omitempty"`       // Body rules only: upstream or client
+	Header      string      `json:"header,omitempty"`      // Header name for header rules
+	Path        string      `json:"path,omitempty"`        // JSONPath, e.g. $.metadata or $.messages[*].content[*].cache_control
+	Value       interface{} `json:"value,omitempty"`       // Value for set_header, set and prefix
+	Min         *float64    `json:"min,omitempty"`         // Lower bound for clamp
+	Max         *float64    `json:"max,omitempty"`         // Upper bound for clamp
+	Pattern     string      `json:"pattern,omitempty"`     // Regular expression for replace
+	Replacement string      `json:"replacement,omitempty"` // Replacement for replace, supports $1 references
+}
+
+// IsBodyRule reports whether the rule rewrites the request body
+func (r RewriteRule) IsBodyRule() bool {
+	switch r.Action {
+	case RewriteSet, RewriteDelete, RewriteClamp, RewritePrefix:
+		return true
+	}
+	return false
+}
+
+// PhaseOrDefault returns the phase of a body rule, defaulting to upstream
+func (r RewriteRule) PhaseOrDefault() string {
+	if r.Phase == "" {
+		return RewritePhaseUpstream
+	}
+	return r.Phase
+}
+
+// Validate checks that the rule is complete and well-formed
+func (r RewriteRule) Validate() error {
+	switch r.Action {
+	case RewriteSetHeader:
+		if r.Header == "" {
+			return fmt.Errorf("%s requires header", r.Action)
+		}
+		if _, ok := r.Value.(string); !ok {
+			return fmt.Errorf("%s requires a string value", r.Action)
+		}
+	case RewriteRemoveHeader:
+		if r.Header == "" {
+			return fmt.Errorf("%s requires header", r.Action)
+		}
+	case RewriteSet, RewriteDelete, RewriteClamp, RewritePrefix:
+		if !strings.HasPrefix(r.Path, "$") || len(r.Path) < 2 {
+			return fmt.Errorf("%s requires a JSONPath starting with $", r.Action)
+		}
+		if r.Phase != "" && r.Phase != RewritePhaseUpstream && r.Phase != RewritePhaseClient {
+			return fmt.Errorf("invalid phase: %s", r.Phase)
+		}
+		if r.Action == RewriteClamp && r.Min == nil && r.Max == nil {
+			return fmt.Errorf("%s requires min or max", r.Action)
+		}
+		if r.Action == RewritePrefix {
+			if _, ok := r.Value.(string); !ok {
+				return fmt.Errorf("%s requires a string value", r.Action)
+			}
+		}
+	case RewriteReplace:
+		if r.Pattern == "" {
+			return fmt.Errorf("%s requires pattern", r.Action)
+		}
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown action: %s", r.Action)
+	}
+	return nil
+}
+
+// ValidateRewriteRules validates an ordered list of rules
+func ValidateRewriteRules(rules []RewriteRule) error {
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("rewrite rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// EncodeRewriteRules serializes rules for storage, returning an empty string for no rules
+func EncodeRewriteRules(rules []RewriteRule) string {
+	if len(rules) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// storedRewriteRules encodes the rules for storage. Stored rules that could not be decoded are kept
+// until new rules are set, so saving the config does not erase them.
+func (e Endpoint) storedRewriteRules() string {
+	if len(e.RewriteRules) == 0 && e.rawRewriteRules != "" {
+		return e.rawRewriteRules
+	}
+	return EncodeRewriteRules(e.RewriteRules)
+}
+
+// DecodeRewriteRules parses rules from storage
+func DecodeRewriteRules(data string) ([]RewriteRule, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var rules []RewriteRule
+	if err := json.Unmarshal([]byte(data), &rules); err != nil {
+		return nil, fmt.Errorf("invalid rewrite rules: %w", err)
+	}
+	return rules, nil
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Tools ccNexus can execute itself
+const (
+	ServerToolWebFetch    = "web_fetch"    // Fetch a URL from an allowed host
+	ServerToolCalculator  = "calculator"   // Evaluate an arithmetic expression
+	ServerToolSandboxRead = "sandbox_read" // Read a file from the sandbox directory
+)
+
+// Server tool defaults
+const (
+	DefaultServerToolMaxRounds   = 5
+	DefaultServerToolResultBytes = 100 * 1024
+)
+
+// ServerToolsConfig lets the proxy execute selected tools itself, emulating server tools on
+// upstreams that have none
+type ServerToolsConfig struct {
+	Enabled        bool     `json:"enabled"`
+	Tools          []string `json:"tools"`                    // Enabled tools: web_fetch, calculator, sandbox_read
+	FetchAllowlist []string `json:"fetchAllowlist,omitempty"` // Hosts web_fetch may access; *.example.com matches subdomains
+	SandboxDir     string   `json:"sandboxDir,omitempty"`     // Directory sandbox_read is confined to
+	MaxRounds      int      `json:"maxRounds,omitempty"`      // Max server tool rounds per request (default 5)
+	MaxResultBytes int      `json:"maxResultBytes,omitempty"` // Max size of a tool result (default 100 KB)
+}
+
+// Has reports whether a tool is enabled
+func (c *ServerToolsConfig) Has(name string) bool {
+	for _, tool := range c.Tools {
+		if tool == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsHost reports whether web_fetch may access a host
+func (c *ServerToolsConfig) AllowsHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, entry := range c.FetchAllowlist {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" || entry == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(entry, "*."); ok && strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxRoundsOrDefault returns the max number of rounds, defaulting to DefaultServerToolMaxRounds
+func (c *ServerToolsConfig) MaxRoundsOrDefault() int {
+	if c.MaxRounds <= 0 {
+		return DefaultServerToolMaxRounds
+	}
+	return c.MaxRounds
+}
+
+// MaxResultBytesOrDefault returns the max result size, defaulting to DefaultServerToolResultBytes
+func (c *ServerToolsConfig) MaxResultBytesOrDefault() int {
+	if c.MaxResultBytes <= 0 {
+		return DefaultServerToolResultBytes
+	}
+	return c.MaxResultBytes
+}
+
+// Validate checks the tool names and their settings
+func (c *ServerToolsConfig) Validate() error {
+	for _, tool := range c.Tools {
+		switch tool {
+		case ServerToolWebFetch:
+			if len(c.FetchAllowlist) == 0 {
+				return fmt.Errorf("%s requires a fetch allowlist", tool)
+			}
+		case ServerToolCalculator:
+		case ServerToolSandboxRead:
+			if strings.TrimSpace(c.SandboxDir) == "" {
+				return fmt.Errorf("%s requires a sandbox directory", tool)
+			}
+		default:
+			return fmt.Errorf("unknown server tool: %s", tool)
+		}
+	}
+	if c.MaxRounds < 0 || c.MaxResultBytes < 0 {
+		return fmt.Errorf("server tool limits must not be negative")
+	}
+	return nil
+}
+
+// EncodeServerTools serializes the config for storage, returning an empty string for nil
+func EncodeServerTools(c *ServerToolsConfig) string {
+	if c == nil {
+		return ""
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// DecodeServerTools parses the config from storage; an empty string yields nil
+func DecodeServerTools(data string) (*ServerToolsConfig, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var c ServerToolsConfig
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, fmt.Errorf("invalid server tools config: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetServerTools returns the server tools configuration (thread-safe)
+func (c *Config) GetServerTools() *ServerToolsConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ServerTools
+}
+
+// UpdateServerTools updates the server tools configuration (thread-safe)
+func (c *Config) UpdateServerTools(tools *ServerToolsConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ServerTools = tools
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DefaultStatsArchiveAfterMonths is how many months of daily stats are kept before they are
+// rolled up into monthly stats
+const DefaultStatsArchiveAfterMonths = 12
+
+// StatsArchiveConfig controls the monthly rollup of old daily stats. Months older than AfterMonths
+// are rolled up by endpoint, device and model; with PruneDaily their daily stats are then deleted.
+type StatsArchiveConfig struct {
+	Enabled     bool `json:"enabled"`
+	AfterMonths int  `json:"afterMonths"` // Months of daily stats kept before the rollup, including the current month
+	PruneDaily  bool `json:"pruneDaily"`  // Delete the daily stats of rolled up months
+}
+
+// DefaultStatsArchive returns the archive config used until one is saved: rollups after a year,
+// keeping the daily stats
+func DefaultStatsArchive() *StatsArchiveConfig {
+	return &StatsArchiveConfig{Enabled: true, AfterMonths: DefaultStatsArchiveAfterMonths}
+}
+
+// Validate checks the stats archive config
+func (c *StatsArchiveConfig) Validate() error {
+	if c.AfterMonths < 1 {
+		return fmt.Errorf("stats archive afterMonths must be at least 1")
+	}
+	return nil
+}
+
+// EncodeStatsArchive serializes the config for storage, returning an empty string for nil
+func EncodeStatsArchive(c *StatsArchiveConfig) string {
+	if c == nil {
+		return ""
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// DecodeStatsArchive parses the config from storage; an empty string yields nil
+func DecodeStatsArchive(data string) (*StatsArchiveConfig, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var c StatsArchiveConfig
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, fmt.Errorf("invalid stats archive config: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetStatsArchive returns the stats archive configuration, or the default when none is saved (thread-safe)
+func (c *Config) GetStatsArchive() *StatsArchiveConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.StatsArchive == nil {
+		return DefaultStatsArchive()
+	}
+	return c.StatsArchive
+}
+
+// UpdateStatsArchive updates the stats archive configuration (thread-safe)
+func (c *Config) UpdateStatsArchive(archive *StatsArchiveConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.StatsArchive = archive
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// StatsReportConfig controls the monthly stats report. At the start of each month the stats of
+// the previous month are exported and written to the backup provider.
+type StatsReportConfig struct {
+	Enabled     bool     `json:"enabled"`
+	Provider    string   `json:"provider"`              // webdav | local | s3 | sftp
+	Format      string   `json:"format,omitempty"`      // csv (default) | jsonl | parquet
+	Granularity string   `json:"granularity,omitempty"` // day (default) | month | hour
+	Dimensions  []string `json:"dimensions,omitempty"`  // endpoint, device, clientModel, upstreamModel, clientFormat
+}
+
+// Validate checks the stats report config
+func (c *StatsReportConfig) Validate() error {
+	switch c.Provider {
+	case "webdav", "local", "s3", "sftp":
+	default:
+		return fmt.Errorf("unknown stats report provider: %s", c.Provider)
+	}
+	switch c.Format {
+	case "", "csv", "jsonl", "parquet":
+	default:
+		return fmt.Errorf("unknown stats report format: %s", c.Format)
+	}
+	switch c.Granularity {
+	case "", "hour", "day", "month":
+	default:
+		return fmt.Errorf("unknown stats report granularity: %s", c.Granularity)
+	}
+	for _, dim := range c.Dimensions {
+		switch dim {
+		case "endpoint", "device", "clientModel", "upstreamModel", "clientFormat":
+		default:
+			return fmt.Errorf("unknown stats report dimension: %s", dim)
+		}
+	}
+	return nil
+}
+
+// EncodeStatsReport serializes the config for storage, returning an empty string for nil
+func EncodeStatsReport(c *StatsReportConfig) string {
+	if c == nil {
+		return ""
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// DecodeStatsReport parses the config from storage; an empty string yields nil
+func DecodeStatsReport(data string) (*StatsReportConfig, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var c StatsReportConfig
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, fmt.Errorf("invalid stats report config: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetStatsReport returns the stats report configuration (thread-safe)
+func (c *Config) GetStatsReport() *StatsReportConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.StatsReport
+}
+
+// UpdateStatsReport updates the stats report configuration (thread-safe)
+func (c *Config) UpdateStatsReport(report *StatsReportConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.StatsReport = report
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lich0821/ccNexus/internal/schedule"
+)
+
+// DefaultStatsSyncInterval is used when no sync interval is set
+const DefaultStatsSyncInterval = "15m"
+
+// StatsSyncConfig controls continuous multi-device stats sync. Each device pushes its own daily
+// stats as append-only changesets to the provider and pulls the changesets of other devices.
+type StatsSyncConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Provider string `json:"provider"`           // webdav | local | s3 | sftp
+	Interval string `json:"interval,omitempty"` // Go duration, defaults to 15m
+}
+
+// IntervalOrDefault returns the sync interval, defaulting to DefaultStatsSyncInterval
+func (c *StatsSyncConfig) IntervalOrDefault() string {
+	if strings.TrimSpace(c.Interval) == "" {
+		return DefaultStatsSyncInterval
+	}
+	return strings.TrimSpace(c.Interval)
+}
+
+// Schedule returns the sync times
+func (c *StatsSyncConfig) Schedule() (schedule.Schedule, error) {
+	return schedule.ParseInterval(c.IntervalOrDefault())
+}
+
+// Validate checks the stats sync config
+func (c *StatsSyncConfig) Validate() error {
+	switch c.Provider {
+	case "webdav", "local", "s3", "sftp":
+	default:
+		return fmt.Errorf("unknown stats sync provider: %s", c.Provider)
+	}
+	if _, err := c.Schedule(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// EncodeStatsSync serializes the config for storage, returning an empty string for nil
+func EncodeStatsSync(c *StatsSyncConfig) string {
+	if c == nil {
+		return ""
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// DecodeStatsSync parses the config from storage; an empty string yields nil
+func DecodeStatsSync(data string) (*StatsSyncConfig, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var c StatsSyncConfig
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, fmt.Errorf("invalid stats sync config: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetStatsSync returns the stats sync configuration (thread-safe)
+func (c *Config) GetStatsSync() *StatsSyncConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.StatsSync
+}
+
+// UpdateStatsSync updates the stats sync configuration (thread-safe)
+func (c *Config) UpdateStatsSync(sync *StatsSyncConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.StatsSync = sync
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Actions taken when a tool call from a non-Claude upstream has invalid arguments
+const (
+	ToolRepairActionRepair = "repair" // Send the repaired arguments
+	ToolRepairActionText   = "text"   // Turn the tool call into a text block
+	ToolRepairActionRetry  = "retry"  // Retry the request once, then repair
+)
+
+// ToolRepairConfig checks the tool calls of non-Claude upstreams against the request's tool
+// schemas and repairs malformed arguments
+type ToolRepairConfig struct {
+	Enabled bool   `json:"enabled"`
+	Action  string `json:"action,omitempty"` // repair (default) | text | retry
+}
+
+// ActionOrDefault returns the configured action, defaulting to repair
+func (c *ToolRepairConfig) ActionOrDefault() string {
+	if c.Action == "" {
+		return ToolRepairActionRepair
+	}
+	return c.Action
+}
+
+// Validate checks the action
+func (c *ToolRepairConfig) Validate() error {
+	switch c.Action {
+	case "", ToolRepairActionRepair, ToolRepairActionText, ToolRepairActionRetry:
+		return nil
+	}
+	return fmt.Errorf("unknown tool repair action: %s", c.Action)
+}
+
+// EncodeToolRepair serializes the config for storage, returning an empty string for nil
+func EncodeToolRepair(c *ToolRepairConfig) string {
+	if c == nil {
+		return ""
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// DecodeToolRepair parses the config from storage; an empty string yields nil
+func DecodeToolRepair(data string) (*ToolRepairConfig, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var c ToolRepairConfig
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, fmt.Errorf("invalid tool repair config: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetToolRepair returns the tool repair configuration (thread-safe)
+func (c *Config) GetToolRepair() *ToolRepairConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ToolRepair
+}
+
+// UpdateToolRepair updates the tool repair configuration (thread-safe)
+func (c *Config) UpdateToolRepair(repair *ToolRepairConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ToolRepair = repair
+}
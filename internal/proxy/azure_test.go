@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lich0821/ccNexus/internal/config"
+)
+
+// azureRequest is what an Azure OpenAI upstream saw of a proxied request
+type azureRequest struct {
+	path, apiVersion, apiKey, authorization, xAPIKey string
+}
+
+// azureUpstream answers deployment Chat Completions and Responses API requests the way Azure
+// OpenAI does and records each request
+func azureUpstream(requests *[]azureRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, azureRequest{
+			path:          r.URL.Path,
+			apiVersion:    r.URL.Query().Get("api-version"),
+			apiKey:        r.Header.Get("api-key"),
+			authorization: r.Header.Get("Authorization"),
+			xAPIKey:       r.Header.Get("x-api-key"),
+		})
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/openai/responses" {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id": "resp_1", "object": "response", "status": "completed", "model": "gpt-4o",
+				"output": []interface{}{map[string]interface{}{"type": "message", "role": "assistant",
+					"content": []interface{}{map[string]interface{}{"type": "output_text", "text": "Hi from Azure"}}}},
+				"usage": map[string]interface{}{"input_tokens": 10, "output_tokens": 5, "total_tokens": 15},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": "chatcmpl-1", "object": "chat.completion", "model": "gpt-4o",
+			"choices": []interface{}{map[string]interface{}{"index": 0, "finish_reason": "stop",
+				"message": map[string]interface{}{"role": "assistant", "content": "Hi from Azure"}}},
+			"usage": map[string]interface{}{"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15},
+		})
+	}))
+}
+
+func TestAzureEndpoints(t *testing.T) {
+	var requests []azureRequest
+	upstream := azureUpstream(&requests)
+	defer upstream.Close()
+
+	tests := []struct {
+		name, transformer, path, body string
+		wantPath, wantAPIVersion      string
+	}{
+		{"claude to azure", "azure", "/v1/messages",
+			`{"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "user", "content": "Hi"}]}`,
+			"/openai/deployments/prod-4o/chat/completions", config.DefaultAzureAPIVersion},
+		{"chat to azure", "azure", "/v1/chat/completions",
+			`{"model": "gpt-4o", "messages": [{"role": "user", "content": "Hi"}]}`,
+			"/openai/deployments/prod-4o/chat/completions", config.DefaultAzureAPIVersion},
+		{"claude to azure2", "azure2", "/v1/messages",
+			`{"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "user", "content": "Hi"}]}`,
+			"/openai/responses", config.DefaultAzureResponsesAPIVersion},
+		{"responses to azure2", "azure2", responsesPath,
+			`{"model": "gpt-4o", "input": "Hi"}`,
+			"/openai/responses", config.DefaultAzureResponsesAPIVersion},
+	}
+	for _, tt := range tests {
+		requests = nil
+		p := newTestProxy(config.Endpoint{Name: "azure", APIUrl: upstream.URL, APIKey: "azure-key", Enabled: true,
+			Transformer: tt.transformer, Model: "gpt-4o", AzureDeployment: "prod-4o"})
+
+		req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer client-token")
+		req.Header.Set("x-api-key", "client-key")
+		rec := httptest.NewRecorder()
+		p.handleProxy(rec, req)
+
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Hi from Azure") {
+			t.Fatalf("%s: status = %d, body %s", tt.name, rec.Code, rec.Body.String())
+		}
+		if len(requests) != 1 {
+			t.Fatalf("%s: upstream got %d requests, want 1", tt.name, len(requests))
+		}
+		got := requests[0]
+		if got.path != tt.wantPath || got.apiVersion != tt.wantAPIVersion {
+			t.Errorf("%s: upstream got %s?api-version=%s, want %s?api-version=%s", tt.name, got.path, got.apiVersion, tt.wantPath, tt.wantAPIVersion)
+		}
+		if got.apiKey != "azure-key" || got.authorization != "" || got.xAPIKey != "" {
+			t.Errorf("%s: upstream got api-key %q, Authorization %q, x-api-key %q; want only the api-key", tt.name, got.apiKey, got.authorization, got.xAPIKey)
+		}
+	}
+}
+
+func TestBuildAzureRequestFromResource(t *testing.T) {
+	endpoint := config.Endpoint{Transformer: "azure", AzureResource: "my-res", APIKey: "azure-key", Model: "gpt-4o", AzureAPIVersion: "2024-06-01"}
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	req, err := buildProxyRequest(r, endpoint, []byte(`{}`), "cc_azure")
+	if err != nil {
+		t.Fatalf("buildProxyRequest failed: %v", err)
+	}
+	want := "https://my-res.openai.azure.com/openai/deployments/gpt-4o/chat/completions?api-version=2024-06-01"
+	if req.URL.String() != want {
+		t.Errorf("URL = %s, want %s", req.URL, want)
+	}
+	if req.Header.Get("api-key") != "azure-key" || req.Header.Get("Authorization") != "" {
+		t.Errorf("Expected only the api-key header, got %v", req.Header)
+	}
+}
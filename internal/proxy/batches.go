@@ -0,0 +1,904 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+)
+
+// pinnedEndpointKey carries the name of the endpoint a request must run on
+type pinnedEndpointKey struct{}
+
+// Message batch processing statuses
+const (
+	batchInProgress = "in_progress"
+	batchCanceling  = "canceling"
+	batchEnded      = "ended"
+)
+
+// Message batch modes
+const (
+	batchModePassthrough = "passthrough" // Created on a Claude endpoint, which runs it
+	batchModeEmulated    = "emulated"    // Run locally through the proxy pipeline
+)
+
+// Final statuses of batch items
+const (
+	batchItemSucceeded = "succeeded"
+	batchItemErrored   = "errored"
+	batchItemCanceled  = "canceled"
+	batchItemExpired   = "expired"
+)
+
+const (
+	batchWorkers       = 4
+	batchPollInterval  = 5 * time.Second
+	batchLifetime      = 24 * time.Hour
+	maxBatchRequests   = 100000
+	defaultBatchLimit  = 20
+	maxBatchLimit      = 1000
+	batchesPath        = "/v1/messages/batches"
+	batchResultsSuffix = "/results"
+)
+
+// batchItemHeaders are the client headers replayed on every request of an emulated batch
+var batchItemHeaders = []string{"anthropic-version", "anthropic-beta"}
+
+// MessageBatch is a Message Batches API job. Request counts are computed from the items of
+// emulated batches; passthrough batches keep the last object returned by the upstream instead.
+type MessageBatch struct {
+	ID                string
+	EndpointName      string
+	Mode              string // passthrough | emulated
+	Status            string // in_progress | canceling | ended
+	Headers           string // JSON object of request headers replayed for each item
+	Upstream          string // Last batch object returned by the upstream (passthrough only)
+	CreatedAt         time.Time
+	ExpiresAt         time.Time
+	EndedAt           time.Time // Zero while the batch is running
+	CancelInitiatedAt time.Time // Zero unless the batch was canceled
+	Processing        int
+	Succeeded         int
+	Errored           int
+	Canceled          int
+	Expired           int
+}
+
+// MessageBatchItem is a single request of an emulated batch
+type MessageBatchItem struct {
+	BatchID  string
+	Index    int
+	CustomID string
+	Params   string
+	Status   string
+	Result   string // JSON result object once the item is done
+}
+
+// MessageBatchStorage persists message batches and the queue of emulated batch items
+type MessageBatchStorage interface {
+	CreateMessageBatch(batch *MessageBatch, items []MessageBatchItem) error
+	GetMessageBatch(id string) (*MessageBatch, error)
+	ListMessageBatches(limit int, beforeID, afterID string) ([]MessageBatch, bool, error)
+	UpdateMessageBatch(batch *MessageBatch) error
+	DeleteMessageBatch(id string) error
+	ClaimMessageBatchItem() (*MessageBatchItem, error)
+	FinishMessageBatchItem(batchID string, index int, status, result string) error
+	SettlePendingMessageBatchItems(batchID, status, result string) error
+	ResetRunningMessageBatchItems() error
+	GetMessageBatchItems(batchID string) ([]MessageBatchItem, error)
+}
+
+// SetMessageBatches sets the storage for message batches and starts the workers running emulated
+// batches. Calling it again stops the workers started before, once their items finish; Stop stops
+// them for good.
+func (p *Proxy) SetMessageBatches(store MessageBatchStorage) {
+	p.stopBatchWorkers()
+	p.batchWorkersWG.Wait()
+
+	p.batchWorkersMu.Lock()
+	defer p.batchWorkersMu.Unlock()
+	p.batches = store
+	if p.batchWake == nil {
+		p.batchWake = make(chan struct{}, batchWorkers)
+	}
+
+	// Items that were running when the process stopped go back to the queue
+	if err := store.ResetRunningMessageBatchItems(); err != nil {
+		logger.Warn("Failed to requeue interrupted batch items: %v", err)
+	}
+	stop := make(chan struct{})
+	p.batchStop = stop
+	for i := 0; i < batchWorkers; i++ {
+		p.batchWorkersWG.Add(1)
+		go p.runBatchWorker(stop)
+	}
+}
+
+// stopBatchWorkers stops the workers started by SetMessageBatches. Items they are running finish,
+// but no new items are claimed.
+func (p *Proxy) stopBatchWorkers() {
+	p.batchWorkersMu.Lock()
+	defer p.batchWorkersMu.Unlock()
+	if p.batchStop != nil {
+		close(p.batchStop)
+		p.batchStop = nil
+	}
+}
+
+// handleMessageBatches handles the Message Batches API (/v1/messages/batches)
+func (p *Proxy) handleMessageBatches(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, batchesPath), "/")
+	parts := strings.Split(rest, "/")
+
+	switch {
+	case rest == "" && r.Method == http.MethodPost:
+		p.createMessageBatch(w, r)
+	case rest == "" && r.Method == http.MethodGet:
+		p.listMessageBatches(w, r)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		p.retrieveMessageBatch(w, r, parts[0])
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		p.deleteMessageBatch(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "cancel" && r.Method == http.MethodPost:
+		p.cancelMessageBatch(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "results" && r.Method == http.MethodGet:
+		p.messageBatchResults(w, r, parts[0])
+	default:
+		writeBatchError(w, http.StatusNotFound, "not_found_error", "Not found")
+	}
+}
+
+// createMessageBatch passes a batch through to a Claude endpoint, or queues it for local emulation
+func (p *Proxy) createMessageBatch(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		writeBatchError(w, http.StatusBadRequest, "invalid_request_error", "Failed to read request body")
+		return
+	}
+
+	var req struct {
+		Requests []struct {
+			CustomID string          `json:"custom_id"`
+			Params   json.RawMessage `json:"params"`
+		} `json:"requests"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeBatchError(w, http.StatusBadRequest, "invalid_request_error", "Invalid request body")
+		return
+	}
+	if len(req.Requests) == 0 || len(req.Requests) > maxBatchRequests {
+		writeBatchError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("requests: must contain between 1 and %d items", maxBatchRequests))
+		return
+	}
+
+	seen := make(map[string]bool, len(req.Requests))
+	items := make([]MessageBatchItem, len(req.Requests))
+	for i, item := range req.Requests {
+		if item.CustomID == "" || seen[item.CustomID] {
+			writeBatchError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("requests.%d.custom_id: must be unique and not empty", i))
+			return
+		}
+		seen[item.CustomID] = true
+		var params map[string]interface{}
+		if err := json.Unmarshal(item.Params, &params); err != nil || params == nil {
+			writeBatchError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("requests.%d.params: must be an object", i))
+			return
+		}
+		items[i] = MessageBatchItem{Index: i, CustomID: item.CustomID, Params: string(item.Params)}
+	}
+
+	endpoint := p.getCurrentEndpoint()
+	if endpoint.Name == "" {
+		writeBatchError(w, http.StatusServiceUnavailable, "api_error", "No enabled endpoints configured")
+		return
+	}
+	if isClaudeEndpoint(endpoint) {
+		p.createPassthroughBatch(w, r, endpoint, items)
+		return
+	}
+	if p.batches == nil {
+		writeBatchError(w, http.StatusNotImplemented, "api_error", "Message batches are not available for non-Claude endpoints")
+		return
+	}
+
+	headers := make(map[string]string)
+	for _, name := range batchItemHeaders {
+		if value := r.Header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+	headerJSON, _ := json.Marshal(headers)
+
+	now := time.Now()
+	batch := &MessageBatch{
+		ID:           newMessageBatchID(),
+		EndpointName: endpoint.Name,
+		Mode:         batchModeEmulated,
+		Status:       batchInProgress,
+		Headers:      string(headerJSON),
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(batchLifetime),
+		Processing:   len(items),
+	}
+	if err := p.batches.CreateMessageBatch(batch, items); err != nil {
+		logger.Error("Failed to store message batch: %v", err)
+		writeBatchError(w, http.StatusInternalServerError, "api_error", "Failed to store message batch")
+		return
+	}
+	logger.Info("[%s] Queued message batch %s with %d requests for local processing", endpoint.Name, batch.ID, len(items))
+	p.wakeBatchWorkers()
+
+	writeJSON(w, http.StatusOK, batchObject(r, batch))
+}
+
+// createPassthroughBatch sends a batch to a Claude endpoint after applying its rewrites and model override
+func (p *Proxy) createPassthroughBatch(w http.ResponseWriter, r *http.Request, endpoint config.Endpoint, items []MessageBatchItem) {
+	trans, err := prepareTransformerForClient(ClientFormatClaude, endpoint)
+	if err != nil {
+		writeBatchError(w, http.StatusInternalServerError, "api_error", err.Error())
+		return
+	}
+
+	requests := make([]map[string]interface{}, len(items))
+	for i, item := range items {
+		params := applyBodyRewrites(endpoint.Name, []byte(item.Params), endpoint.RewriteRules, config.RewritePhaseClient)
+		params, err = trans.TransformRequest(params)
+		if err != nil {
+			writeBatchError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("requests.%d.params: %v", i, err))
+			return
+		}
+		params = applyBodyRewrites(endpoint.Name, params, endpoint.RewriteRules, config.RewritePhaseUpstream)
+		requests[i] = map[string]interface{}{"custom_id": item.CustomID, "params": json.RawMessage(params)}
+	}
+	body, err := json.Marshal(map[string]interface{}{"requests": requests})
+	if err != nil {
+		writeBatchError(w, http.StatusInternalServerError, "api_error", err.Error())
+		return
+	}
+
+	status, header, respBody, err := p.forwardBatchRequest(r, endpoint, batchesPath, body)
+	if err != nil {
+		writeBatchError(w, http.StatusBadGateway, "api_error", err.Error())
+		return
+	}
+	if status == http.StatusOK && p.batches != nil {
+		if batch := passthroughBatch(endpoint.Name, respBody); batch != nil {
+			if err := p.batches.CreateMessageBatch(batch, nil); err != nil {
+				logger.Warn("[%s] Failed to record message batch %s: %v", endpoint.Name, batch.ID, err)
+			}
+			logger.Info("[%s] Created message batch %s upstream with %d requests", endpoint.Name, batch.ID, len(items))
+		}
+		respBody = localizeBatchObject(r, respBody)
+	}
+	writeUpstreamResponse(w, status, header, respBody)
+}
+
+// listMessageBatches lists the batches created through the proxy, newest first
+func (p *Proxy) listMessageBatches(w http.ResponseWriter, r *http.Request) {
+	if p.batches == nil {
+		p.forwardToCurrentClaudeEndpoint(w, r, r.URL.Path)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultBatchLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxBatchLimit {
+			writeBatchError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("limit: must be between 1 and %d", maxBatchLimit))
+			return
+		}
+		limit = n
+	}
+
+	batches, hasMore, err := p.batches.ListMessageBatches(limit, query.Get("before_id"), query.Get("after_id"))
+	if err != nil {
+		logger.Error("Failed to list message batches: %v", err)
+		writeBatchError(w, http.StatusInternalServerError, "api_error", "Failed to list message batches")
+		return
+	}
+
+	data := make([]json.RawMessage, 0, len(batches))
+	for i := range batches {
+		data = append(data, p.batchJSON(r, &batches[i]))
+	}
+	var firstID, lastID interface{}
+	if len(batches) > 0 {
+		firstID, lastID = batches[0].ID, batches[len(batches)-1].ID
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":     data,
+		"has_more": hasMore,
+		"first_id": firstID,
+		"last_id":  lastID,
+	})
+}
+
+// retrieveMessageBatch returns a batch, refreshing passthrough batches from their endpoint
+func (p *Proxy) retrieveMessageBatch(w http.ResponseWriter, r *http.Request, id string) {
+	batch, ok := p.lookupMessageBatch(w, r, id)
+	if !ok {
+		return
+	}
+	if batch.Mode == batchModePassthrough {
+		p.forwardPassthroughBatch(w, r, batch, batchesPath+"/"+id, nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, batchObject(r, batch))
+}
+
+// cancelMessageBatch stops a batch; requests already running still finish
+func (p *Proxy) cancelMessageBatch(w http.ResponseWriter, r *http.Request, id string) {
+	batch, ok := p.lookupMessageBatch(w, r, id)
+	if !ok {
+		return
+	}
+	if batch.Mode == batchModePassthrough {
+		p.forwardPassthroughBatch(w, r, batch, batchesPath+"/"+id+"/cancel", []byte("{}"))
+		return
+	}
+
+	if batch.Status == batchInProgress {
+		batch.Status = batchCanceling
+		batch.CancelInitiatedAt = time.Now()
+		if err := p.batches.UpdateMessageBatch(batch); err != nil {
+			writeBatchError(w, http.StatusInternalServerError, "api_error", "Failed to cancel message batch")
+			return
+		}
+		if err := p.batches.SettlePendingMessageBatchItems(id, batchItemCanceled, `{"type":"canceled"}`); err != nil {
+			logger.Error("Failed to cancel items of message batch %s: %v", id, err)
+		}
+		p.finishBatchIfDone(id)
+		logger.Info("Canceled message batch %s", id)
+	}
+
+	if updated, err := p.batches.GetMessageBatch(id); err == nil && updated != nil {
+		batch = updated
+	}
+	writeJSON(w, http.StatusOK, batchObject(r, batch))
+}
+
+// deleteMessageBatch removes an ended batch and its results
+func (p *Proxy) deleteMessageBatch(w http.ResponseWriter, r *http.Request, id string) {
+	batch, ok := p.lookupMessageBatch(w, r, id)
+	if !ok {
+		return
+	}
+
+	if batch.Mode == batchModePassthrough {
+		endpoint, found := p.findEndpoint(batch.EndpointName)
+		if !found {
+			writeBatchError(w, http.StatusNotFound, "not_found_error", fmt.Sprintf("Endpoint %s of message batch %s is no longer configured", batch.EndpointName, id))
+			return
+		}
+		status, header, body, err := p.forwardBatchRequest(r, endpoint, batchesPath+"/"+id, nil)
+		if err != nil {
+			writeBatchError(w, http.StatusBadGateway, "api_error", err.Error())
+			return
+		}
+		if status == http.StatusOK {
+			p.batches.DeleteMessageBatch(id)
+		}
+		writeUpstreamResponse(w, status, header, body)
+		return
+	}
+
+	if batch.Status != batchEnded {
+		writeBatchError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("Message batch %s is still processing; cancel it before deleting", id))
+		return
+	}
+	if err := p.batches.DeleteMessageBatch(id); err != nil {
+		writeBatchError(w, http.StatusInternalServerError, "api_error", "Failed to delete message batch")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "type": "message_batch_deleted"})
+}
+
+// messageBatchResults streams the results of an ended batch as JSONL, in submission order
+func (p *Proxy) messageBatchResults(w http.ResponseWriter, r *http.Request, id string) {
+	batch, ok := p.lookupMessageBatch(w, r, id)
+	if !ok {
+		return
+	}
+
+	if batch.Mode == batchModePassthrough {
+		endpoint, found := p.findEndpoint(batch.EndpointName)
+		if !found {
+			writeBatchError(w, http.StatusNotFound, "not_found_error", fmt.Sprintf("Endpoint %s of message batch %s is no longer configured", batch.EndpointName, id))
+			return
+		}
+		status, header, body, err := p.forwardBatchRequest(r, endpoint, batchesPath+"/"+id+batchResultsSuffix, nil)
+		if err != nil {
+			writeBatchError(w, http.StatusBadGateway, "api_error", err.Error())
+			return
+		}
+		writeUpstreamResponse(w, status, header, body)
+		return
+	}
+
+	if batch.Status != batchEnded {
+		writeBatchError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("Message batch %s has not ended yet", id))
+		return
+	}
+	items, err := p.batches.GetMessageBatchItems(id)
+	if err != nil {
+		writeBatchError(w, http.StatusInternalServerError, "api_error", "Failed to read message batch results")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-jsonl")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	for _, item := range items {
+		result := json.RawMessage(item.Result)
+		if !json.Valid(result) {
+			result = json.RawMessage(fmt.Sprintf(`{"type":%q}`, item.Status))
+		}
+		encoder.Encode(map[string]interface{}{"custom_id": item.CustomID, "result": result})
+	}
+}
+
+// lookupMessageBatch loads a batch, answering with an error when it cannot. Batches the proxy does
+// not know, or all batches when there is no batch storage, are left to the current endpoint.
+func (p *Proxy) lookupMessageBatch(w http.ResponseWriter, r *http.Request, id string) (*MessageBatch, bool) {
+	if p.batches == nil {
+		p.forwardToCurrentClaudeEndpoint(w, r, r.URL.Path)
+		return nil, false
+	}
+	batch, err := p.batches.GetMessageBatch(id)
+	if err != nil {
+		logger.Error("Failed to load message batch %s: %v", id, err)
+		writeBatchError(w, http.StatusInternalServerError, "api_error", "Failed to load message batch")
+		return nil, false
+	}
+	if batch == nil {
+		if isClaudeEndpoint(p.getCurrentEndpoint()) {
+			p.forwardToCurrentClaudeEndpoint(w, r, r.URL.Path)
+			return nil, false
+		}
+		writeBatchError(w, http.StatusNotFound, "not_found_error", fmt.Sprintf("Message batch %s not found", id))
+		return nil, false
+	}
+	return batch, true
+}
+
+// forwardPassthroughBatch forwards a request about a passthrough batch to its endpoint and records
+// the returned batch object
+func (p *Proxy) forwardPassthroughBatch(w http.ResponseWriter, r *http.Request, batch *MessageBatch, path string, body []byte) {
+	endpoint, found := p.findEndpoint(batch.EndpointName)
+	if !found {
+		// The endpoint is gone; the last known state is the best answer left
+		writeJSON(w, http.StatusOK, p.batchJSON(r, batch))
+		return
+	}
+
+	status, header, respBody, err := p.forwardBatchRequest(r, endpoint, path, body)
+	if err != nil {
+		writeBatchError(w, http.StatusBadGateway, "api_error", err.Error())
+		return
+	}
+	if status == http.StatusOK {
+		if updated := passthroughBatch(endpoint.Name, respBody); updated != nil {
+			batch.Status = updated.Status
+			batch.Upstream = updated.Upstream
+			batch.EndedAt = updated.EndedAt
+			batch.CancelInitiatedAt = updated.CancelInitiatedAt
+			if err := p.batches.UpdateMessageBatch(batch); err != nil {
+				logger.Warn("[%s] Failed to update message batch %s: %v", endpoint.Name, batch.ID, err)
+			}
+		}
+		respBody = localizeBatchObject(r, respBody)
+	}
+	writeUpstreamResponse(w, status, header, respBody)
+}
+
+// forwardToCurrentClaudeEndpoint passes a batch request to the current endpoint when it speaks the Claude API
+func (p *Proxy) forwardToCurrentClaudeEndpoint(w http.ResponseWriter, r *http.Request, path string) {
+	endpoint := p.getCurrentEndpoint()
+	if endpoint.Name == "" || !isClaudeEndpoint(endpoint) {
+		writeBatchError(w, http.StatusNotImplemented, "api_error", "Message batches are not available for non-Claude endpoints")
+		return
+	}
+	var body []byte
+	if r.Method == http.MethodPost {
+		body, _ = io.ReadAll(r.Body)
+		r.Body.Close()
+	}
+	status, header, respBody, err := p.forwardBatchRequest(r, endpoint, path, body)
+	if err != nil {
+		writeBatchError(w, http.StatusBadGateway, "api_error", err.Error())
+		return
+	}
+	writeUpstreamResponse(w, status, header, respBody)
+}
+
+// forwardBatchRequest sends a Message Batches API request to a Claude endpoint
+func (p *Proxy) forwardBatchRequest(r *http.Request, endpoint config.Endpoint, path string, body []byte) (int, http.Header, []byte, error) {
+	proxyReq, err := buildProxyRequest(r, endpoint, body, "cc_claude")
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	targetURL, err := url.Parse(normalizeAPIUrl(endpoint.APIUrl) + path)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	targetURL.RawQuery = r.URL.RawQuery
+	proxyReq.URL = targetURL
+	if body == nil {
+		proxyReq.Body = http.NoBody
+		proxyReq.ContentLength = 0
+	}
+	applyHeaderRewrites(proxyReq, endpoint.RewriteRules)
+
+	resp, err := sendRequest(p.getEndpointContext(endpoint.Name), proxyReq, p.config)
+	if err != nil {
+		logger.Error("[%s] Message batch request failed: %v", endpoint.Name, err)
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	var respBody []byte
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		respBody, err = decompressGzip(resp.Body)
+	} else {
+		respBody, err = io.ReadAll(resp.Body)
+	}
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.Warn("[%s] Message batch request returned HTTP %d: %s", endpoint.Name, resp.StatusCode, string(respBody))
+	}
+	return resp.StatusCode, resp.Header, respBody, nil
+}
+
+// findEndpoint returns the configured endpoint with the given name, enabled or not
+func (p *Proxy) findEndpoint(name string) (config.Endpoint, bool) {
+	for _, ep := range p.config.GetEndpoints() {
+		if ep.Name == name {
+			return ep, true
+		}
+	}
+	return config.Endpoint{}, false
+}
+
+// isClaudeEndpoint reports whether an endpoint speaks the Anthropic API natively
+func isClaudeEndpoint(endpoint config.Endpoint) bool {
+	return endpoint.Transformer == "" || endpoint.Transformer == "claude"
+}
+
+// newMessageBatchID returns a new ID for an emulated batch
+func newMessageBatchID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return "msgbatch_" + hex.EncodeToString(buf)
+}
+
+// passthroughBatch builds the local record of a batch object returned by a Claude endpoint
+func passthroughBatch(endpointName string, body []byte) *MessageBatch {
+	var obj struct {
+		ID                string     `json:"id"`
+		ProcessingStatus  string     `json:"processing_status"`
+		CreatedAt         time.Time  `json:"created_at"`
+		ExpiresAt         time.Time  `json:"expires_at"`
+		EndedAt           *time.Time `json:"ended_at"`
+		CancelInitiatedAt *time.Time `json:"cancel_initiated_at"`
+	}
+	if err := json.Unmarshal(body, &obj); err != nil || obj.ID == "" {
+		return nil
+	}
+
+	batch := &MessageBatch{
+		ID:           obj.ID,
+		EndpointName: endpointName,
+		Mode:         batchModePassthrough,
+		Status:       obj.ProcessingStatus,
+		Upstream:     string(body),
+		CreatedAt:    obj.CreatedAt,
+		ExpiresAt:    obj.ExpiresAt,
+	}
+	if batch.CreatedAt.IsZero() {
+		batch.CreatedAt = time.Now()
+	}
+	if obj.EndedAt != nil {
+		batch.EndedAt = *obj.EndedAt
+	}
+	if obj.CancelInitiatedAt != nil {
+		batch.CancelInitiatedAt = *obj.CancelInitiatedAt
+	}
+	return batch
+}
+
+// batchJSON returns the API object of any batch
+func (p *Proxy) batchJSON(r *http.Request, batch *MessageBatch) json.RawMessage {
+	if batch.Mode == batchModePassthrough {
+		return localizeBatchObject(r, []byte(batch.Upstream))
+	}
+	data, _ := json.Marshal(batchObject(r, batch))
+	return data
+}
+
+// batchObject returns the API object of an emulated batch
+func batchObject(r *http.Request, batch *MessageBatch) map[string]interface{} {
+	obj := map[string]interface{}{
+		"id":                batch.ID,
+		"type":              "message_batch",
+		"processing_status": batch.Status,
+		"request_counts": map[string]int{
+			"processing": batch.Processing,
+			"succeeded":  batch.Succeeded,
+			"errored":    batch.Errored,
+			"canceled":   batch.Canceled,
+			"expired":    batch.Expired,
+		},
+		"created_at":          batch.CreatedAt.UTC().Format(time.RFC3339),
+		"expires_at":          batch.ExpiresAt.UTC().Format(time.RFC3339),
+		"ended_at":            nil,
+		"cancel_initiated_at": nil,
+		"archived_at":         nil,
+		"results_url":         nil,
+	}
+	if !batch.EndedAt.IsZero() {
+		obj["ended_at"] = batch.EndedAt.UTC().Format(time.RFC3339)
+	}
+	if !batch.CancelInitiatedAt.IsZero() {
+		obj["cancel_initiated_at"] = batch.CancelInitiatedAt.UTC().Format(time.RFC3339)
+	}
+	if batch.Status == batchEnded {
+		obj["results_url"] = batchResultsURL(r, batch.ID)
+	}
+	return obj
+}
+
+// localizeBatchObject points the results_url of an upstream batch object at the proxy
+func localizeBatchObject(r *http.Request, body []byte) []byte {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return body
+	}
+	id, _ := obj["id"].(string)
+	if resultsURL, ok := obj["results_url"].(string); !ok || resultsURL == "" || id == "" {
+		return body
+	}
+	obj["results_url"] = batchResultsURL(r, id)
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return body
+	}
+	return data
+}
+
+// batchResultsURL returns the proxy URL serving a batch's results
+func batchResultsURL(r *http.Request, id string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return fmt.Sprintf("%s://%s%s/%s%s", scheme, r.Host, batchesPath, id, batchResultsSuffix)
+}
+
+// writeUpstreamResponse relays an upstream response, dropping headers that no longer apply
+func writeUpstreamResponse(w http.ResponseWriter, status int, header http.Header, body []byte) {
+	for key, values := range header {
+		if key == "Content-Length" || key == "Content-Encoding" {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// writeBatchError writes an error in the Anthropic API format
+func writeBatchError(w http.ResponseWriter, status int, errType, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"type":  "error",
+		"error": map[string]string{"type": errType, "message": message},
+	})
+}
+
+// wakeBatchWorkers tells idle workers that new items are queued
+func (p *Proxy) wakeBatchWorkers() {
+	for i := 0; i < batchWorkers; i++ {
+		select {
+		case p.batchWake <- struct{}{}:
+		default:
+			return
+		}
+	}
+}
+
+// runBatchWorker processes queued batch items until the process exits
+func (p *Proxy) runBatchWorker(stop <-chan struct{}) {
+	defer p.batchWorkersWG.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		item, err := p.batches.ClaimMessageBatchItem()
+		if err != nil {
+			logger.Error("Failed to claim batch item: %v", err)
+		}
+		if item == nil {
+			select {
+			case <-stop:
+				return
+			case <-p.batchWake:
+			case <-time.After(batchPollInterval):
+			}
+			continue
+		}
+		p.runBatchItem(item)
+	}
+}
+
+// runBatchItem sends one batch request through the normal proxy pipeline and records its result
+func (p *Proxy) runBatchItem(item *MessageBatchItem) {
+	batch, err := p.batches.GetMessageBatch(item.BatchID)
+	if err != nil || batch == nil {
+		return // deleted meanwhile
+	}
+
+	if time.Now().After(batch.ExpiresAt) {
+		expired := `{"type":"expired"}`
+		p.batches.FinishMessageBatchItem(item.BatchID, item.Index, batchItemExpired, expired)
+		p.batches.SettlePendingMessageBatchItems(item.BatchID, batchItemExpired, expired)
+		p.finishBatchIfDone(item.BatchID)
+		return
+	}
+
+	// Batch results are whole messages, so items never stream
+	body := []byte(item.Params)
+	var params map[string]interface{}
+	if json.Unmarshal(body, &params) == nil {
+		delete(params, "stream")
+		if data, err := json.Marshal(params); err == nil {
+			body = data
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var headers map[string]string
+	json.Unmarshal([]byte(batch.Headers), &headers)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	req = req.WithContext(context.WithValue(req.Context(), pinnedEndpointKey{}, batch.EndpointName))
+
+	rec := newBatchRecorder()
+	p.handleProxy(rec, req)
+
+	status, result := batchItemResult(rec.status, rec.body.Bytes())
+	if err := p.batches.FinishMessageBatchItem(item.BatchID, item.Index, status, result); err != nil {
+		logger.Error("Failed to record result of batch item %s/%s: %v", item.BatchID, item.CustomID, err)
+	}
+	logger.Debug("Batch %s item %s %s", item.BatchID, item.CustomID, status)
+	p.finishBatchIfDone(item.BatchID)
+}
+
+// finishBatchIfDone ends a batch once none of its items is left to process
+func (p *Proxy) finishBatchIfDone(id string) {
+	batch, err := p.batches.GetMessageBatch(id)
+	if err != nil || batch == nil || batch.Status == batchEnded || batch.Processing > 0 {
+		return
+	}
+	batch.Status = batchEnded
+	batch.EndedAt = time.Now()
+	if err := p.batches.UpdateMessageBatch(batch); err != nil {
+		logger.Error("Failed to end message batch %s: %v", id, err)
+		return
+	}
+	logger.Info("Message batch %s ended: %d succeeded, %d errored, %d canceled, %d expired",
+		id, batch.Succeeded, batch.Errored, batch.Canceled, batch.Expired)
+}
+
+// batchItemResult turns a pipeline response into a batch result object and its status
+func batchItemResult(status int, body []byte) (string, string) {
+	if status == http.StatusOK && json.Valid(body) {
+		data, _ := json.Marshal(map[string]interface{}{"type": "succeeded", "message": json.RawMessage(body)})
+		return batchItemSucceeded, string(data)
+	}
+
+	var apiErr struct {
+		Type  string          `json:"type"`
+		Error json.RawMessage `json:"error"`
+	}
+	var errBody interface{}
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Type == "error" && len(apiErr.Error) > 0 {
+		errBody = json.RawMessage(body)
+	} else {
+		// Keep just the message of errors in other API formats
+		var upstreamErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		message := strings.TrimSpace(string(body))
+		if json.Unmarshal(body, &upstreamErr) == nil && upstreamErr.Error.Message != "" {
+			message = upstreamErr.Error.Message
+		}
+		if message == "" {
+			message = http.StatusText(status)
+		}
+		errBody = map[string]interface{}{
+			"type":  "error",
+			"error": map[string]string{"type": batchErrorType(status), "message": message},
+		}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"type": "errored", "error": errBody})
+	return batchItemErrored, string(data)
+}
+
+// batchErrorType maps an HTTP status to an Anthropic error type
+func batchErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case 529:
+		return "overloaded_error"
+	}
+	return "api_error"
+}
+
+// batchRecorder captures the response of a batch item run through the proxy pipeline
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBatchRecorder() *batchRecorder {
+	return &batchRecorder{header: make(http.Header)}
+}
+
+func (b *batchRecorder) Header() http.Header { return b.header }
+
+func (b *batchRecorder) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *batchRecorder) Write(data []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(data)
+}
+
+// Flush is a no-op; batch items are never streamed to a client
+func (b *batchRecorder) Flush() {}
@@ -0,0 +1,377 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/config"
+)
+
+// memoryBatchStorage keeps message batches and their items in maps
+type memoryBatchStorage struct {
+	mu      sync.Mutex
+	batches map[string]*MessageBatch
+	items   map[string][]MessageBatchItem
+}
+
+func newMemoryBatchStorage() *memoryBatchStorage {
+	return &memoryBatchStorage{batches: make(map[string]*MessageBatch), items: make(map[string][]MessageBatchItem)}
+}
+
+func (s *memoryBatchStorage) CreateMessageBatch(batch *MessageBatch, items []MessageBatchItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *batch
+	s.batches[batch.ID] = &stored
+	for _, item := range items {
+		item.BatchID, item.Status = batch.ID, "pending"
+		s.items[batch.ID] = append(s.items[batch.ID], item)
+	}
+	return nil
+}
+
+func (s *memoryBatchStorage) GetMessageBatch(id string) (*MessageBatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.batches[id]
+	if !ok {
+		return nil, nil
+	}
+	batch := *stored
+	batch.Processing, batch.Succeeded, batch.Errored, batch.Canceled, batch.Expired = 0, 0, 0, 0, 0
+	for _, item := range s.items[id] {
+		switch item.Status {
+		case "pending", "running":
+			batch.Processing++
+		case batchItemSucceeded:
+			batch.Succeeded++
+		case batchItemErrored:
+			batch.Errored++
+		case batchItemCanceled:
+			batch.Canceled++
+		case batchItemExpired:
+			batch.Expired++
+		}
+	}
+	return &batch, nil
+}
+
+func (s *memoryBatchStorage) ListMessageBatches(limit int, beforeID, afterID string) ([]MessageBatch, bool, error) {
+	return nil, false, nil
+}
+
+func (s *memoryBatchStorage) UpdateMessageBatch(batch *MessageBatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.batches[batch.ID]
+	stored.Status, stored.Upstream, stored.EndedAt, stored.CancelInitiatedAt = batch.Status, batch.Upstream, batch.EndedAt, batch.CancelInitiatedAt
+	return nil
+}
+
+func (s *memoryBatchStorage) DeleteMessageBatch(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.batches, id)
+	delete(s.items, id)
+	return nil
+}
+
+func (s *memoryBatchStorage) ClaimMessageBatchItem() (*MessageBatchItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.batches))
+	for id := range s.batches {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if s.batches[id].Status != batchInProgress {
+			continue
+		}
+		for i := range s.items[id] {
+			if s.items[id][i].Status == "pending" {
+				s.items[id][i].Status = "running"
+				item := s.items[id][i]
+				return &item, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryBatchStorage) FinishMessageBatchItem(batchID string, index int, status, result string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.items[batchID] {
+		if s.items[batchID][i].Index == index {
+			s.items[batchID][i].Status, s.items[batchID][i].Result = status, result
+		}
+	}
+	return nil
+}
+
+func (s *memoryBatchStorage) SettlePendingMessageBatchItems(batchID, status, result string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.items[batchID] {
+		if s.items[batchID][i].Status == "pending" {
+			s.items[batchID][i].Status, s.items[batchID][i].Result = status, result
+		}
+	}
+	return nil
+}
+
+func (s *memoryBatchStorage) ResetRunningMessageBatchItems() error { return nil }
+
+func (s *memoryBatchStorage) GetMessageBatchItems(batchID string) ([]MessageBatchItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]MessageBatchItem(nil), s.items[batchID]...), nil
+}
+
+// chatUpstream answers every request with a chat completion naming the upstream and counts its requests
+func chatUpstream(name string, hits *int, mu *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*hits++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": "chatcmpl-1", "object": "chat.completion", "model": "gpt-test",
+			"choices": []interface{}{map[string]interface{}{"index": 0, "finish_reason": "stop",
+				"message": map[string]interface{}{"role": "assistant", "content": "from " + name}}},
+			"usage": map[string]interface{}{"prompt_tokens": 3, "completion_tokens": 2},
+		})
+	}))
+}
+
+// newBatchTestProxy returns a proxy with two OpenAI endpoints, a and b, and in-memory batch storage
+// without workers, so tests run batch items themselves
+func newBatchTestProxy(t *testing.T) (*Proxy, *memoryBatchStorage, map[string]*int) {
+	t.Helper()
+	var mu sync.Mutex
+	hits := map[string]*int{"a": new(int), "b": new(int)}
+	a := chatUpstream("a", hits["a"], &mu)
+	b := chatUpstream("b", hits["b"], &mu)
+	t.Cleanup(a.Close)
+	t.Cleanup(b.Close)
+
+	p := newTestProxy(
+		config.Endpoint{Name: "a", APIUrl: a.URL, APIKey: "k", Enabled: true, Transformer: "openai", Model: "gpt-test"},
+		config.Endpoint{Name: "b", APIUrl: b.URL, APIKey: "k", Enabled: true, Transformer: "openai", Model: "gpt-test"},
+	)
+	store := newMemoryBatchStorage()
+	p.batches = store
+	p.batchWake = make(chan struct{}, batchWorkers)
+	return p, store, hits
+}
+
+func batchRequest(t *testing.T, p *Proxy, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	p.handleMessageBatches(rec, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+	return rec
+}
+
+func createTestBatch(t *testing.T, p *Proxy) string {
+	t.Helper()
+	rec := batchRequest(t, p, http.MethodPost, batchesPath, `{"requests": [
+		{"custom_id": "first", "params": {"model": "claude-sonnet-4", "max_tokens": 10, "messages": [{"role": "user", "content": "Hi"}]}},
+		{"custom_id": "second", "params": {"model": "claude-sonnet-4", "max_tokens": 10, "stream": true, "messages": [{"role": "user", "content": "Hello"}]}}
+	]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	var batch struct {
+		ID               string `json:"id"`
+		ProcessingStatus string `json:"processing_status"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &batch)
+	if batch.ID == "" || batch.ProcessingStatus != batchInProgress {
+		t.Fatalf("Unexpected batch: %s", rec.Body.String())
+	}
+	return batch.ID
+}
+
+// runQueuedBatchItems runs every queued item the way the workers do
+func runQueuedBatchItems(t *testing.T, p *Proxy) {
+	t.Helper()
+	for {
+		item, err := p.batches.ClaimMessageBatchItem()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item == nil {
+			return
+		}
+		p.runBatchItem(item)
+	}
+}
+
+type batchResultLine struct {
+	CustomID string `json:"custom_id"`
+	Result   struct {
+		Type    string `json:"type"`
+		Message struct {
+			Content []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"message"`
+	} `json:"result"`
+}
+
+func batchResults(t *testing.T, p *Proxy, id string) []batchResultLine {
+	t.Helper()
+	rec := batchRequest(t, p, http.MethodGet, batchesPath+"/"+id+batchResultsSuffix, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("results status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	var lines []batchResultLine
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var line batchResultLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("Result line is not JSON: %s", scanner.Text())
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestEmulatedBatchLifecycle(t *testing.T) {
+	p, _, hits := newBatchTestProxy(t)
+	id := createTestBatch(t, p)
+
+	// Results are only available once the batch has ended
+	if rec := batchRequest(t, p, http.MethodGet, batchesPath+"/"+id+batchResultsSuffix, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("results before the end: status = %d", rec.Code)
+	}
+
+	// Items stay on the endpoint the batch was created on after a switch
+	if err := p.SetCurrentEndpoint("b"); err != nil {
+		t.Fatal(err)
+	}
+	runQueuedBatchItems(t, p)
+	if *hits["a"] != 2 || *hits["b"] != 0 {
+		t.Errorf("upstream requests a=%d b=%d, want 2 and 0", *hits["a"], *hits["b"])
+	}
+	if p.GetCurrentEndpointName() != "b" {
+		t.Errorf("Batch items changed the current endpoint to %s", p.GetCurrentEndpointName())
+	}
+
+	var batch struct {
+		ProcessingStatus string         `json:"processing_status"`
+		RequestCounts    map[string]int `json:"request_counts"`
+		EndedAt          *string        `json:"ended_at"`
+	}
+	json.Unmarshal(batchRequest(t, p, http.MethodGet, batchesPath+"/"+id, "").Body.Bytes(), &batch)
+	if batch.ProcessingStatus != batchEnded || batch.RequestCounts["succeeded"] != 2 || batch.EndedAt == nil {
+		t.Errorf("Unexpected ended batch: %+v", batch)
+	}
+
+	lines := batchResults(t, p, id)
+	if len(lines) != 2 || lines[0].CustomID != "first" || lines[1].CustomID != "second" {
+		t.Fatalf("Expected results in submission order, got %+v", lines)
+	}
+	for _, line := range lines {
+		if line.Result.Type != batchItemSucceeded || len(line.Result.Message.Content) == 0 || line.Result.Message.Content[0].Text != "from a" {
+			t.Errorf("Unexpected result for %s: %+v", line.CustomID, line.Result)
+		}
+	}
+}
+
+func TestEmulatedBatchPinnedEndpointRemoved(t *testing.T) {
+	p, _, hits := newBatchTestProxy(t)
+	id := createTestBatch(t, p)
+
+	endpoints := p.config.GetEndpoints()
+	endpoints[0].Enabled = false
+	p.config.UpdateEndpoints(endpoints)
+	runQueuedBatchItems(t, p)
+
+	if *hits["a"] != 0 || *hits["b"] != 0 {
+		t.Errorf("upstream requests a=%d b=%d, want none", *hits["a"], *hits["b"])
+	}
+	for _, line := range batchResults(t, p, id) {
+		if line.Result.Type != batchItemErrored {
+			t.Errorf("Expected %s to error, got %s", line.CustomID, line.Result.Type)
+		}
+	}
+}
+
+func TestEmulatedBatchExpiry(t *testing.T) {
+	p, store, hits := newBatchTestProxy(t)
+	id := createTestBatch(t, p)
+	store.batches[id].ExpiresAt = time.Now().Add(-time.Minute)
+
+	runQueuedBatchItems(t, p)
+	if *hits["a"] != 0 {
+		t.Errorf("Expired items reached the upstream %d times", *hits["a"])
+	}
+	batch, _ := store.GetMessageBatch(id)
+	if batch.Status != batchEnded || batch.Expired != 2 {
+		t.Errorf("Unexpected batch after expiry: %+v", batch)
+	}
+	for _, line := range batchResults(t, p, id) {
+		if line.Result.Type != batchItemExpired {
+			t.Errorf("Expected %s to expire, got %s", line.CustomID, line.Result.Type)
+		}
+	}
+}
+
+func TestEmulatedBatchCancel(t *testing.T) {
+	p, store, hits := newBatchTestProxy(t)
+	id := createTestBatch(t, p)
+
+	rec := batchRequest(t, p, http.MethodPost, batchesPath+"/"+id+"/cancel", "")
+	var batch struct {
+		ProcessingStatus  string         `json:"processing_status"`
+		RequestCounts     map[string]int `json:"request_counts"`
+		CancelInitiatedAt *string        `json:"cancel_initiated_at"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &batch)
+	if rec.Code != http.StatusOK || batch.ProcessingStatus != batchEnded || batch.RequestCounts["canceled"] != 2 || batch.CancelInitiatedAt == nil {
+		t.Errorf("Unexpected canceled batch: %d %s", rec.Code, rec.Body.String())
+	}
+
+	runQueuedBatchItems(t, p)
+	if *hits["a"] != 0 {
+		t.Errorf("Canceled items reached the upstream %d times", *hits["a"])
+	}
+	if item, _ := store.ClaimMessageBatchItem(); item != nil {
+		t.Errorf("A canceled batch left item %s queued", item.CustomID)
+	}
+	for _, line := range batchResults(t, p, id) {
+		if line.Result.Type != batchItemCanceled {
+			t.Errorf("Expected %s to be canceled, got %s", line.CustomID, line.Result.Type)
+		}
+	}
+}
+
+func TestBatchWorkersStopWithProxy(t *testing.T) {
+	p := newTestProxy()
+	store := newMemoryBatchStorage()
+	p.SetMessageBatches(store)
+	p.SetMessageBatches(store) // restarts the workers rather than adding more
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.batchWorkersWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Batch workers still run after Stop")
+	}
+}
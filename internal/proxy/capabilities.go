@@ -0,0 +1,697 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+)
+
+// imagePlaceholder replaces image inputs sent to endpoints without vision support
+const imagePlaceholder = "[image omitted]"
+
+// lossyCapabilities are features whose degradation changes the answer noticeably,
+// so a capable endpoint is preferred over degrading the request
+var lossyCapabilities = map[string]bool{
+	config.CapImages:         true,
+	config.CapThinking:       true,
+	config.CapResponseFormat: true,
+	config.CapToolChoice:     true,
+}
+
+// detectRequestFeatures returns the capabilities a client request relies on
+func detectRequestFeatures(clientFormat ClientFormat, body []byte) []string {
+	var req map[string]interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil
+	}
+
+	used := make(map[string]bool)
+	switch clientFormat {
+	case ClientFormatClaude:
+		if thinking, ok := req["thinking"].(map[string]interface{}); ok && thinking["type"] != "disabled" {
+			used[config.CapThinking] = true
+		}
+		if messages, ok := req["messages"].([]interface{}); ok && claudeHasImages(messages) {
+			used[config.CapImages] = true
+		}
+		toolChoice, _ := req["tool_choice"].(map[string]interface{})
+		switch toolChoice["type"] {
+		case "any", "tool", "none":
+			used[config.CapToolChoice] = true
+		}
+		if tools, ok := req["tools"].([]interface{}); ok && len(tools) > 0 && toolChoice["disable_parallel_tool_use"] != true {
+			used[config.CapParallelTools] = true
+		}
+		if _, ok := req["output_format"].(map[string]interface{}); ok {
+			used[config.CapResponseFormat] = true
+		}
+		if extractTextValue(req["system"]) != "" {
+			used[config.CapSystem] = true
+		}
+	case ClientFormatOpenAIChat:
+		if effort, ok := req["reasoning_effort"].(string); ok && effort != "" && effort != "none" {
+			used[config.CapThinking] = true
+		}
+		messages, _ := req["messages"].([]interface{})
+		for _, msg := range messages {
+			m, _ := msg.(map[string]interface{})
+			if role := m["role"]; role == "system" || role == "developer" {
+				used[config.CapSystem] = true
+			}
+			if hasPartType(m["content"], "image_url") {
+				used[config.CapImages] = true
+			}
+		}
+		if choice, ok := req["tool_choice"]; ok && choice != nil && choice != "auto" {
+			used[config.CapToolChoice] = true
+		}
+		if tools, ok := req["tools"].([]interface{}); ok && len(tools) > 0 && req["parallel_tool_calls"] != false {
+			used[config.CapParallelTools] = true
+		}
+		if format, ok := req["response_format"].(map[string]interface{}); ok && format["type"] != "text" {
+			used[config.CapResponseFormat] = true
+		}
+	case ClientFormatOpenAIResponses:
+		if reasoning, ok := req["reasoning"].(map[string]interface{}); ok && reasoning["effort"] != "none" {
+			used[config.CapThinking] = true
+		}
+		if instructions, ok := req["instructions"].(string); ok && instructions != "" {
+			used[config.CapSystem] = true
+		}
+		input, _ := req["input"].([]interface{})
+		for _, item := range input {
+			m, _ := item.(map[string]interface{})
+			if role := m["role"]; role == "system" || role == "developer" {
+				used[config.CapSystem] = true
+			}
+			if hasPartType(m["content"], "input_image") {
+				used[config.CapImages] = true
+			}
+		}
+		if choice, ok := req["tool_choice"]; ok && choice != nil && choice != "auto" {
+			used[config.CapToolChoice] = true
+		}
+		if tools, ok := req["tools"].([]interface{}); ok && len(tools) > 0 && req["parallel_tool_calls"] != false {
+			used[config.CapParallelTools] = true
+		}
+		if text, ok := req["text"].(map[string]interface{}); ok {
+			if format, ok := text["format"].(map[string]interface{}); ok && format["type"] != "text" {
+				used[config.CapResponseFormat] = true
+			}
+		}
+	}
+
+	var features []string
+	for _, capability := range config.AllCapabilities {
+		if used[capability] {
+			features = append(features, capability)
+		}
+	}
+	return features
+}
+
+// claudeHasImages reports whether Claude messages contain image blocks, including inside tool results
+func claudeHasImages(blocks []interface{}) bool {
+	for _, block := range blocks {
+		m, ok := block.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if m["type"] == "image" {
+			return true
+		}
+		if nested, ok := m["content"].([]interface{}); ok && claudeHasImages(nested) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPartType reports whether an OpenAI content part array contains a part of the given type
+func hasPartType(content interface{}, partType string) bool {
+	parts, _ := content.([]interface{})
+	for _, part := range parts {
+		if m, ok := part.(map[string]interface{}); ok && m["type"] == partType {
+			return true
+		}
+	}
+	return false
+}
+
+// extractTextValue flattens a string or an array of text blocks/parts into plain text
+func extractTextValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				if text, ok := m["text"].(string); ok && text != "" {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// negotiateCapabilities picks the endpoint and request body to use when the current endpoint lacks
+// features the request relies on. Lossy features are routed to a capable endpoint when one exists;
+// whatever is still missing afterwards is degraded in place. Each decision is logged.
+func (p *Proxy) negotiateCapabilities(clientFormat ClientFormat, endpoint config.Endpoint, body []byte, features []string, routed map[string]bool) (config.Endpoint, []byte) {
+	missing := endpoint.MissingCapabilities(features)
+	if len(missing) == 0 {
+		return endpoint, body
+	}
+
+	var lossy []string
+	for _, capability := range missing {
+		if lossyCapabilities[capability] {
+			lossy = append(lossy, capability)
+		}
+	}
+	if len(lossy) > 0 {
+		if alt, ok := p.findCapableEndpoint(clientFormat, lossy, endpoint.Name, routed); ok {
+			routed[alt.Name] = true
+			logger.Info("[%s] Endpoint lacks %s, routing request to %s", endpoint.Name, strings.Join(lossy, ", "), alt.Name)
+			endpoint = alt
+			missing = alt.MissingCapabilities(features)
+			if len(missing) == 0 {
+				return endpoint, body
+			}
+		}
+	}
+
+	degraded, changes := degradeRequest(clientFormat, body, missing)
+	for _, change := range changes {
+		logger.Info("[%s] Degraded request: %s", endpoint.Name, change)
+	}
+	return endpoint, degraded
+}
+
+// findCapableEndpoint returns the first other enabled endpoint that supports all given capabilities
+// and can serve the client format, skipping endpoints this request was already routed to
+func (p *Proxy) findCapableEndpoint(clientFormat ClientFormat, capabilities []string, exclude string, routed map[string]bool) (config.Endpoint, bool) {
+	for _, ep := range p.getEnabledEndpoints() {
+		if ep.Name == exclude || routed[ep.Name] {
+			continue
+		}
+		if len(ep.MissingCapabilities(capabilities)) > 0 {
+			continue
+		}
+		if _, err := prepareTransformerForClient(clientFormat, ep); err != nil {
+			continue
+		}
+		return ep, true
+	}
+	return config.Endpoint{}, false
+}
+
+// degradeRequest rewrites a client request so it no longer relies on the missing capabilities.
+// It returns the new body and a description of every change made.
+func degradeRequest(clientFormat ClientFormat, body []byte, missing []string) ([]byte, []string) {
+	doc, err := decodeJSONBody(body)
+	if err != nil {
+		return body, nil
+	}
+	req, ok := doc.(map[string]interface{})
+	if !ok {
+		return body, nil
+	}
+
+	lacks := make(map[string]bool, len(missing))
+	for _, capability := range missing {
+		lacks[capability] = true
+	}
+
+	var changes []string
+	// response_format runs first: its emulation adds a system instruction that a later system fold carries along
+	if lacks[config.CapResponseFormat] && degradeResponseFormat(clientFormat, req) {
+		changes = append(changes, "response_format replaced with a JSON-only instruction")
+	}
+	if lacks[config.CapThinking] && degradeThinking(clientFormat, req) {
+		changes = append(changes, "thinking disabled")
+	}
+	if lacks[config.CapImages] {
+		if n := degradeImages(clientFormat, req); n > 0 {
+			changes = append(changes, fmt.Sprintf("%d image(s) replaced with text placeholders", n))
+		}
+	}
+	if lacks[config.CapToolChoice] && degradeToolChoice(clientFormat, req) {
+		changes = append(changes, "tool_choice removed")
+	}
+	if lacks[config.CapParallelTools] && degradeParallelTools(clientFormat, req, lacks[config.CapToolChoice]) {
+		changes = append(changes, "parallel tool calls disabled")
+	}
+	if lacks[config.CapSystem] && degradeSystem(clientFormat, req) {
+		changes = append(changes, "system prompt folded into the first user message")
+	}
+
+	if len(changes) == 0 {
+		return body, nil
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return body, nil
+	}
+	return data, changes
+}
+
+// jsonOnlyInstruction builds the instruction that emulates structured output
+func jsonOnlyInstruction(schema interface{}) string {
+	instruction := "Respond only with a valid JSON object, without markdown fences or any other text."
+	if schema != nil {
+		if data, err := json.Marshal(schema); err == nil {
+			instruction += " The JSON must match this schema: " + string(data)
+		}
+	}
+	return instruction
+}
+
+// degradeResponseFormat removes the structured output setting and asks for JSON in the system prompt instead
+func degradeResponseFormat(clientFormat ClientFormat, req map[string]interface{}) bool {
+	switch clientFormat {
+	case ClientFormatClaude:
+		format, ok := req["output_format"].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		delete(req, "output_format")
+		instruction := jsonOnlyInstruction(format["schema"])
+		switch system := req["system"].(type) {
+		case string:
+			req["system"] = system + "\n\n" + instruction
+		case []interface{}:
+			req["system"] = append(system, map[string]interface{}{"type": "text", "text": instruction})
+		default:
+			req["system"] = instruction
+		}
+	case ClientFormatOpenAIChat:
+		format, ok := req["response_format"].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		delete(req, "response_format")
+		var schema interface{}
+		if jsonSchema, ok := format["json_schema"].(map[string]interface{}); ok {
+			schema = jsonSchema["schema"]
+		}
+		messages, _ := req["messages"].([]interface{})
+		system := map[string]interface{}{"role": "system", "content": jsonOnlyInstruction(schema)}
+		req["messages"] = append([]interface{}{system}, messages...)
+	case ClientFormatOpenAIResponses:
+		text, ok := req["text"].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		format, ok := text["format"].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		delete(text, "format")
+		if len(text) == 0 {
+			delete(req, "text")
+		}
+		instruction := jsonOnlyInstruction(format["schema"])
+		if instructions, ok := req["instructions"].(string); ok && instructions != "" {
+			req["instructions"] = instructions + "\n\n" + instruction
+		} else {
+			req["instructions"] = instruction
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// degradeThinking turns off reasoning and drops reasoning content from the history
+func degradeThinking(clientFormat ClientFormat, req map[string]interface{}) bool {
+	changed := false
+	switch clientFormat {
+	case ClientFormatClaude:
+		if _, ok := req["thinking"]; ok {
+			delete(req, "thinking")
+			changed = true
+		}
+		messages, _ := req["messages"].([]interface{})
+		for _, msg := range messages {
+			m, ok := msg.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			blocks, ok := m["content"].([]interface{})
+			if !ok {
+				continue
+			}
+			kept := make([]interface{}, 0, len(blocks))
+			for _, block := range blocks {
+				if b, ok := block.(map[string]interface{}); ok && (b["type"] == "thinking" || b["type"] == "redacted_thinking") {
+					changed = true
+					continue
+				}
+				kept = append(kept, block)
+			}
+			m["content"] = kept
+		}
+	case ClientFormatOpenAIChat:
+		if _, ok := req["reasoning_effort"]; ok {
+			delete(req, "reasoning_effort")
+			changed = true
+		}
+	case ClientFormatOpenAIResponses:
+		if _, ok := req["reasoning"]; ok {
+			delete(req, "reasoning")
+			changed = true
+		}
+		if input, ok := req["input"].([]interface{}); ok {
+			kept := make([]interface{}, 0, len(input))
+			for _, item := range input {
+				if m, ok := item.(map[string]interface{}); ok && m["type"] == "reasoning" {
+					changed = true
+					continue
+				}
+				kept = append(kept, item)
+			}
+			req["input"] = kept
+		}
+	}
+	return changed
+}
+
+// degradeImages replaces image inputs with text placeholders and returns how many were replaced
+func degradeImages(clientFormat ClientFormat, req map[string]interface{}) int {
+	switch clientFormat {
+	case ClientFormatClaude:
+		messages, _ := req["messages"].([]interface{})
+		return replaceClaudeImages(messages)
+	case ClientFormatOpenAIChat:
+		messages, _ := req["messages"].([]interface{})
+		return replaceImageParts(messages, "image_url", "text")
+	case ClientFormatOpenAIResponses:
+		input, _ := req["input"].([]interface{})
+		return replaceImageParts(input, "input_image", "input_text")
+	}
+	return 0
+}
+
+// replaceClaudeImages replaces image blocks in Claude messages, including inside tool results
+func replaceClaudeImages(items []interface{}) int {
+	count := 0
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		blocks, ok := m["content"].([]interface{})
+		if !ok {
+			continue
+		}
+		for i, block := range blocks {
+			b, ok := block.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if b["type"] == "image" {
+				blocks[i] = map[string]interface{}{"type": "text", "text": imagePlaceholder}
+				count++
+				continue
+			}
+			if b["type"] == "tool_result" {
+				count += replaceClaudeImages([]interface{}{b})
+			}
+		}
+	}
+	return count
+}
+
+// replaceImageParts replaces OpenAI image content parts with text parts of textType
+func replaceImageParts(items []interface{}, imageType, textType string) int {
+	count := 0
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		parts, ok := m["content"].([]interface{})
+		if !ok {
+			continue
+		}
+		for i, part := range parts {
+			if p, ok := part.(map[string]interface{}); ok && p["type"] == imageType {
+				parts[i] = map[string]interface{}{"type": textType, "text": imagePlaceholder}
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// degradeToolChoice removes tool_choice; "none" is honored by dropping the tools
+func degradeToolChoice(clientFormat ClientFormat, req map[string]interface{}) bool {
+	choice, ok := req["tool_choice"]
+	if !ok {
+		return false
+	}
+	delete(req, "tool_choice")
+
+	none := choice == "none"
+	if m, ok := choice.(map[string]interface{}); ok && clientFormat == ClientFormatClaude && m["type"] == "none" {
+		none = true
+	}
+	if none {
+		delete(req, "tools")
+	}
+	return true
+}
+
+// degradeParallelTools asks the upstream for at most one tool call per turn
+func degradeParallelTools(clientFormat ClientFormat, req map[string]interface{}, lacksToolChoice bool) bool {
+	if tools, ok := req["tools"].([]interface{}); !ok || len(tools) == 0 {
+		return false
+	}
+	switch clientFormat {
+	case ClientFormatClaude:
+		// Claude expresses this through tool_choice, which cannot be sent when unsupported
+		if lacksToolChoice {
+			return false
+		}
+		choice, ok := req["tool_choice"].(map[string]interface{})
+		if !ok {
+			choice = map[string]interface{}{"type": "auto"}
+			req["tool_choice"] = choice
+		}
+		choice["disable_parallel_tool_use"] = true
+	case ClientFormatOpenAIChat, ClientFormatOpenAIResponses:
+		req["parallel_tool_calls"] = false
+	default:
+		return false
+	}
+	return true
+}
+
+// degradeSystem moves system instructions into the first user message
+func degradeSystem(clientFormat ClientFormat, req map[string]interface{}) bool {
+	var systemText string
+	var items []interface{}
+	var itemsKey, textType string
+
+	switch clientFormat {
+	case ClientFormatClaude:
+		systemText = extractTextValue(req["system"])
+		delete(req, "system")
+		itemsKey, textType = "messages", "text"
+	case ClientFormatOpenAIChat, ClientFormatOpenAIResponses:
+		itemsKey, textType = "messages", "text"
+		if clientFormat == ClientFormatOpenAIResponses {
+			itemsKey, textType = "input", "input_text"
+			if instructions, ok := req["instructions"].(string); ok {
+				systemText = instructions
+			}
+			delete(req, "instructions")
+			// A plain string input is a single user message
+			if input, ok := req["input"].(string); ok {
+				if systemText == "" {
+					return false
+				}
+				req["input"] = systemText + "\n\n" + input
+				return true
+			}
+		}
+		all, _ := req[itemsKey].([]interface{})
+		var texts []string
+		if systemText != "" {
+			texts = append(texts, systemText)
+		}
+		for _, item := range all {
+			m, ok := item.(map[string]interface{})
+			if ok && (m["role"] == "system" || m["role"] == "developer") {
+				if text := extractTextValue(m["content"]); text != "" {
+					texts = append(texts, text)
+				}
+				continue
+			}
+			items = append(items, item)
+		}
+		systemText = strings.Join(texts, "\n\n")
+		req[itemsKey] = items
+	default:
+		return false
+	}
+
+	if systemText == "" {
+		return false
+	}
+	if items == nil {
+		items, _ = req[itemsKey].([]interface{})
+	}
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok || m["role"] != "user" {
+			continue
+		}
+		switch content := m["content"].(type) {
+		case string:
+			m["content"] = systemText + "\n\n" + content
+		case []interface{}:
+			m["content"] = append([]interface{}{map[string]interface{}{"type": textType, "text": systemText}}, content...)
+		default:
+			m["content"] = systemText
+		}
+		return true
+	}
+	// No user message to carry it: start the conversation with one
+	user := map[string]interface{}{"role": "user", "content": systemText}
+	req[itemsKey] = append([]interface{}{user}, items...)
+	return true
+}
+
+// probePNG is a 1x1 transparent PNG used to probe image support
+const probePNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+
+// capabilityProbes are small Claude-format requests, one per detectable capability.
+// Parallel tool calls and response_format cannot be probed cheaply and stay unknown.
+var capabilityProbes = []struct {
+	capability string
+	body       map[string]interface{}
+}{
+	{config.CapSystem, map[string]interface{}{
+		"system":   "Reply with OK.",
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": "Hi"}},
+	}},
+	{config.CapImages, map[string]interface{}{
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": []interface{}{
+			map[string]interface{}{"type": "image", "source": map[string]interface{}{"type": "base64", "media_type": "image/png", "data": probePNG}},
+			map[string]interface{}{"type": "text", "text": "What color is this image?"},
+		}}},
+	}},
+	{config.CapToolChoice, map[string]interface{}{
+		"tools": []interface{}{map[string]interface{}{
+			"name": "ping", "description": "Ping", "input_schema": map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+		}},
+		"tool_choice": map[string]interface{}{"type": "tool", "name": "ping"},
+		"messages":    []interface{}{map[string]interface{}{"role": "user", "content": "Call ping."}},
+	}},
+	{config.CapThinking, map[string]interface{}{
+		"max_tokens": 1100,
+		"thinking":   map[string]interface{}{"type": "enabled", "budget_tokens": 1024},
+		"messages":   []interface{}{map[string]interface{}{"role": "user", "content": "Hi"}},
+	}},
+}
+
+// DetectCapabilities probes an endpoint with small requests sent through its transformer, exactly as
+// the proxy would send them. 2xx means supported, 400/422 means rejected; any other outcome leaves the
+// capability unknown. Capabilities the transformer cannot express are marked unsupported without a probe.
+func DetectCapabilities(endpoint config.Endpoint, cfg *config.Config) (*config.Capabilities, error) {
+	model := endpoint.Model
+	if model == "" {
+		model = "claude-sonnet-4-5-20250929"
+	}
+
+	caps := &config.Capabilities{}
+	detected := 0
+	var lastErr error
+
+	for _, probe := range capabilityProbes {
+		if config.TransformerLacks(endpoint.Transformer, probe.capability) {
+			caps.Set(probe.capability, false)
+			continue
+		}
+
+		body := map[string]interface{}{"model": model, "max_tokens": 16}
+		for k, v := range probe.body {
+			body[k] = v
+		}
+		data, _ := json.Marshal(body)
+
+		req, err := newProbeRequest(endpoint, data)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		resp, err := sendRequest(ctx, req, cfg)
+		if err != nil {
+			cancel()
+			lastErr = err
+			logger.Debug("[%s] Capability probe %s failed: %v", endpoint.Name, probe.capability, err)
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		cancel()
+
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			caps.Set(probe.capability, true)
+			detected++
+		case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnprocessableEntity:
+			caps.Set(probe.capability, false)
+			detected++
+		default:
+			lastErr = fmt.Errorf("HTTP %d", resp.StatusCode)
+			logger.Debug("[%s] Capability probe %s inconclusive: HTTP %d", endpoint.Name, probe.capability, resp.StatusCode)
+		}
+	}
+
+	if detected == 0 && lastErr != nil {
+		return nil, fmt.Errorf("capability detection failed: %w", lastErr)
+	}
+	caps.DetectedAt = time.Now().Format(time.RFC3339)
+	return caps, nil
+}
+
+// newProbeRequest builds the upstream request for a Claude-format probe body
+func newProbeRequest(endpoint config.Endpoint, claudeBody []byte) (*http.Request, error) {
+	trans, err := prepareTransformerForClient(ClientFormatClaude, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	transformedBody, err := trans.TransformRequest(claudeBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to transform probe: %w", err)
+	}
+	transformedBody = applyBodyRewrites(endpoint.Name, transformedBody, endpoint.RewriteRules, config.RewritePhaseUpstream)
+
+	incoming, err := http.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(claudeBody))
+	if err != nil {
+		return nil, err
+	}
+	incoming.Header.Set("Content-Type", "application/json")
+	incoming.Header.Set("anthropic-version", "2023-06-01")
+
+	proxyReq, err := buildProxyRequest(incoming, endpoint, transformedBody, trans.Name())
+	if err != nil {
+		return nil, err
+	}
+	applyHeaderRewrites(proxyReq, endpoint.RewriteRules)
+	return proxyReq, nil
+}
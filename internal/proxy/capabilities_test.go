@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lich0821/ccNexus/internal/config"
+)
+
+func boolPtr(v bool) *bool { return &v }
+
+const capabilitiesClaudeRequest = `{
+	"model": "claude-sonnet-4",
+	"max_tokens": 1024,
+	"system": "Be brief",
+	"thinking": {"type": "enabled", "budget_tokens": 512},
+	"tools": [{"name": "lookup", "input_schema": {"type": "object"}}],
+	"tool_choice": {"type": "any"},
+	"messages": [
+		{"role": "user", "content": [
+			{"type": "text", "text": "What is this?"},
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "aGVsbG8="}}
+		]},
+		{"role": "assistant", "content": [{"type": "thinking", "thinking": "Hmm"}, {"type": "text", "text": "A cat"}]},
+		{"role": "user", "content": [{"type": "text", "text": "Sure?"}]}
+	]
+}`
+
+func TestDetectRequestFeatures(t *testing.T) {
+	got := detectRequestFeatures(ClientFormatClaude, []byte(capabilitiesClaudeRequest))
+	want := []string{config.CapThinking, config.CapParallelTools, config.CapImages, config.CapToolChoice, config.CapSystem}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Claude features = %v, want %v", got, want)
+	}
+
+	chat := `{"model": "gpt-4o", "response_format": {"type": "json_object"}, "parallel_tool_calls": false,
+		"tools": [{"type": "function", "function": {"name": "lookup"}}],
+		"messages": [{"role": "developer", "content": "Be brief"}, {"role": "user", "content": [{"type": "image_url", "image_url": {"url": "x"}}]}]}`
+	got = detectRequestFeatures(ClientFormatOpenAIChat, []byte(chat))
+	want = []string{config.CapImages, config.CapResponseFormat, config.CapSystem}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Chat features = %v, want %v", got, want)
+	}
+
+	responses := `{"model": "gpt-4o", "reasoning": {"effort": "high"}, "instructions": "Be brief", "input": "Hi"}`
+	got = detectRequestFeatures(ClientFormatOpenAIResponses, []byte(responses))
+	want = []string{config.CapThinking, config.CapSystem}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Responses features = %v, want %v", got, want)
+	}
+}
+
+func TestEndpointSupportsFallsBackToTransformerDefault(t *testing.T) {
+	ollama := config.Endpoint{Transformer: "ollama"}
+	if ollama.Supports(config.CapToolChoice) || !ollama.Supports(config.CapImages) {
+		t.Errorf("Unexpected Ollama defaults")
+	}
+	ollama.Capabilities = &config.Capabilities{ToolChoice: boolPtr(true), Images: boolPtr(false)}
+	if !ollama.Supports(config.CapToolChoice) || ollama.Supports(config.CapImages) {
+		t.Errorf("An explicit profile should override the transformer default")
+	}
+}
+
+func TestNegotiateCapabilitiesRoutesLossyFeatures(t *testing.T) {
+	textOnly := config.Endpoint{Name: "text", APIUrl: "http://text", Enabled: true, Transformer: "claude",
+		Capabilities: &config.Capabilities{Images: boolPtr(false)}}
+	disabled := config.Endpoint{Name: "disabled", APIUrl: "http://disabled", Transformer: "claude"}
+	vision := config.Endpoint{Name: "vision", APIUrl: "http://vision", Enabled: true, Transformer: "claude"}
+	p := newTestProxy(textOnly, disabled, vision)
+
+	body := []byte(capabilitiesClaudeRequest)
+	features := detectRequestFeatures(ClientFormatClaude, body)
+	routed := make(map[string]bool)
+	endpoint, got := p.negotiateCapabilities(ClientFormatClaude, textOnly, body, features, routed)
+	if endpoint.Name != "vision" || !routed["vision"] {
+		t.Fatalf("Expected the request to be routed to vision, got %s", endpoint.Name)
+	}
+	if string(got) != string(body) {
+		t.Errorf("A routed request should not be degraded, got %s", got)
+	}
+
+	// Once vision was tried, the request stays on the endpoint and is degraded instead
+	endpoint, got = p.negotiateCapabilities(ClientFormatClaude, textOnly, body, features, routed)
+	if endpoint.Name != "text" {
+		t.Fatalf("Expected the request to stay on text, got %s", endpoint.Name)
+	}
+	if strings.Contains(string(got), `"type":"image"`) || !strings.Contains(string(got), imagePlaceholder) {
+		t.Errorf("Expected the image to be replaced, got %s", got)
+	}
+}
+
+func TestNegotiateCapabilitiesDegradesWithoutRouting(t *testing.T) {
+	// parallel_tools is not lossy, so it never triggers routing
+	single := config.Endpoint{Name: "single", APIUrl: "http://single", Enabled: true, Transformer: "claude",
+		Capabilities: &config.Capabilities{ParallelTools: boolPtr(false)}}
+	other := config.Endpoint{Name: "other", APIUrl: "http://other", Enabled: true, Transformer: "claude"}
+	p := newTestProxy(single, other)
+
+	body := []byte(capabilitiesClaudeRequest)
+	endpoint, got := p.negotiateCapabilities(ClientFormatClaude, single, body, detectRequestFeatures(ClientFormatClaude, body), map[string]bool{})
+	if endpoint.Name != "single" {
+		t.Fatalf("Expected the request to stay on single, got %s", endpoint.Name)
+	}
+	var req map[string]interface{}
+	json.Unmarshal(got, &req)
+	if req["tool_choice"].(map[string]interface{})["disable_parallel_tool_use"] != true {
+		t.Errorf("Expected parallel tool use to be disabled, got %v", req["tool_choice"])
+	}
+}
+
+func TestDegradeRequestClaude(t *testing.T) {
+	missing := []string{config.CapThinking, config.CapImages, config.CapToolChoice, config.CapSystem}
+	got, changes := degradeRequest(ClientFormatClaude, []byte(capabilitiesClaudeRequest), missing)
+	if len(changes) != 4 {
+		t.Errorf("Expected 4 changes, got %v", changes)
+	}
+
+	var req struct {
+		System     interface{} `json:"system"`
+		Thinking   interface{} `json:"thinking"`
+		ToolChoice interface{} `json:"tool_choice"`
+		Tools      []interface{}
+		Messages   []struct {
+			Role    string                   `json:"role"`
+			Content []map[string]interface{} `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(got, &req); err != nil {
+		t.Fatalf("Degraded request is not JSON: %v", err)
+	}
+	if req.System != nil || req.Thinking != nil || req.ToolChoice != nil {
+		t.Errorf("Expected system, thinking and tool_choice to be removed: %s", got)
+	}
+	if len(req.Tools) != 1 {
+		t.Errorf("tool_choice any should keep the tools")
+	}
+	first := req.Messages[0].Content
+	if first[0]["text"] != "Be brief" || first[2]["text"] != imagePlaceholder {
+		t.Errorf("Expected the system prompt first and the image replaced, got %v", first)
+	}
+	for _, block := range req.Messages[1].Content {
+		if block["type"] == "thinking" {
+			t.Errorf("Expected thinking blocks to be dropped from the history")
+		}
+	}
+}
+
+func TestDegradeRequestResponseFormat(t *testing.T) {
+	chat := `{"model": "gpt-4o", "response_format": {"type": "json_schema", "json_schema": {"schema": {"type": "object"}}},
+		"messages": [{"role": "user", "content": "Hi"}]}`
+	got, changes := degradeRequest(ClientFormatOpenAIChat, []byte(chat), []string{config.CapResponseFormat})
+	if len(changes) != 1 || strings.Contains(string(got), "response_format") {
+		t.Fatalf("Expected response_format to be removed, got %s", got)
+	}
+	var req struct {
+		Messages []map[string]interface{} `json:"messages"`
+	}
+	json.Unmarshal(got, &req)
+	if req.Messages[0]["role"] != "system" || !strings.Contains(req.Messages[0]["content"].(string), `{"type":"object"}`) {
+		t.Errorf("Expected a JSON-only system instruction with the schema, got %v", req.Messages[0])
+	}
+
+	// Nothing to degrade leaves the body untouched
+	body := []byte(`{"model": "gpt-4o", "messages": []}`)
+	if got, changes := degradeRequest(ClientFormatOpenAIChat, body, []string{config.CapImages}); string(got) != string(body) || len(changes) != 0 {
+		t.Errorf("Expected no changes, got %s %v", got, changes)
+	}
+}
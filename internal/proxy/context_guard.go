@@ -0,0 +1,332 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+)
+
+// contextGuardHeader reports every action the context guard took on a request
+const contextGuardHeader = "X-CCNexus-Context-Guard"
+
+// toolResultPlaceholder replaces tool result bodies dropped by compaction
+const toolResultPlaceholder = "[tool result omitted to fit the context window]"
+
+// guardContext keeps a request within the context window of the endpoint's model. When the estimated
+// input exceeds the configured limit, the request is rerouted to an endpoint whose model fits or
+// compacted in place, depending on the configured action. It returns the endpoint and body to use and
+// a description of every action taken.
+func (p *Proxy) guardContext(clientFormat ClientFormat, endpoint config.Endpoint, body []byte, requestModel string, features []string, routed map[string]bool) (config.Endpoint, []byte, []string) {
+	guard := p.config.GetContextGuard()
+	if guard == nil || !guard.Enabled {
+		return endpoint, body, nil
+	}
+
+	limit := guard.LimitFor(upstreamModel(endpoint, requestModel))
+	if limit <= 0 {
+		return endpoint, body, nil
+	}
+	tokens := estimateClientInputTokens(clientFormat, endpoint, body)
+	if tokens <= limit {
+		return endpoint, body, nil
+	}
+
+	var actions []string
+	action := guard.ActionOrDefault()
+
+	if action != config.ContextActionCompact {
+		if alt, ok := p.findLargerContextEndpoint(clientFormat, guard, body, requestModel, features, endpoint.Name, routed); ok {
+			routed[alt.Name] = true
+			actions = append(actions, fmt.Sprintf("rerouted %s->%s (%d tokens > %d)", endpoint.Name, alt.Name, tokens, limit))
+			logger.Info("[%s] Context guard: %d estimated tokens exceed %d, routing request to %s", endpoint.Name, tokens, limit, alt.Name)
+			return alt, body, actions
+		}
+		if action == config.ContextActionReroute {
+			actions = append(actions, fmt.Sprintf("exceeds limit (%d tokens > %d), no larger endpoint", tokens, limit))
+			logger.Warn("[%s] Context guard: %d estimated tokens exceed %d and no endpoint has a larger window", endpoint.Name, tokens, limit)
+			return endpoint, body, actions
+		}
+	}
+
+	estimate := func(b []byte) int { return estimateClientInputTokens(clientFormat, endpoint, b) }
+	compacted, changes, after := compactRequest(clientFormat, body, guard, limit, estimate)
+	actions = append(actions, changes...)
+	if len(changes) > 0 {
+		actions = append(actions, fmt.Sprintf("compacted %d->%d tokens", tokens, after))
+	}
+	if after > limit {
+		actions = append(actions, fmt.Sprintf("exceeds limit (%d tokens > %d)", after, limit))
+	}
+	for _, a := range actions {
+		logger.Info("[%s] Context guard: %s", endpoint.Name, a)
+	}
+	return endpoint, compacted, actions
+}
+
+// rerouteContextOverflow picks an endpoint with a larger window after an upstream rejected the request
+// for its size. Only endpoints whose configured limit exceeds the failed endpoint's are considered.
+func (p *Proxy) rerouteContextOverflow(clientFormat ClientFormat, endpoint config.Endpoint, body []byte, requestModel string, features []string, routed map[string]bool) (config.Endpoint, bool) {
+	guard := p.config.GetContextGuard()
+	if guard == nil || !guard.Enabled {
+		return config.Endpoint{}, false
+	}
+
+	limit := guard.LimitFor(upstreamModel(endpoint, requestModel))
+	excluded := map[string]bool{endpoint.Name: true}
+	for {
+		alt, ok := p.findLargerContextEndpoint(clientFormat, guard, body, requestModel, features, endpoint.Name, mergeRouted(routed, excluded))
+		if !ok {
+			return config.Endpoint{}, false
+		}
+		if limit <= 0 || guard.LimitFor(upstreamModel(alt, requestModel)) > limit {
+			return alt, true
+		}
+		excluded[alt.Name] = true
+	}
+}
+
+// mergeRouted returns the union of two sets of endpoint names
+func mergeRouted(a, b map[string]bool) map[string]bool {
+	merged := make(map[string]bool, len(a)+len(b))
+	for name := range a {
+		merged[name] = true
+	}
+	for name := range b {
+		merged[name] = true
+	}
+	return merged
+}
+
+// upstreamModel returns the model an endpoint actually serves for a request
+func upstreamModel(endpoint config.Endpoint, requestModel string) string {
+	if endpoint.Model != "" {
+		return endpoint.Model
+	}
+	return requestModel
+}
+
+// findLargerContextEndpoint returns the first other enabled endpoint whose model fits the request and
+// that supports its features. Endpoints without a configured limit are not considered, since
+// nothing is known about their window.
+func (p *Proxy) findLargerContextEndpoint(clientFormat ClientFormat, guard *config.ContextGuardConfig, body []byte, requestModel string, features []string, exclude string, routed map[string]bool) (config.Endpoint, bool) {
+	for _, ep := range p.getEnabledEndpoints() {
+		if ep.Name == exclude || routed[ep.Name] {
+			continue
+		}
+		limit := guard.LimitFor(upstreamModel(ep, requestModel))
+		if limit <= 0 || estimateClientInputTokens(clientFormat, ep, body) > limit {
+			continue
+		}
+		if len(ep.MissingCapabilities(features)) > 0 {
+			continue
+		}
+		if _, err := prepareTransformerForClient(clientFormat, ep); err != nil {
+			continue
+		}
+		return ep, true
+	}
+	return config.Endpoint{}, false
+}
+
+// compactRequest applies the configured compaction steps in order until the request fits the limit.
+// The system prompt, the first turn and the most recent turns are always kept. It returns the new
+// body, a description of each change and the final estimate.
+func compactRequest(clientFormat ClientFormat, body []byte, guard *config.ContextGuardConfig, limit int, estimate func([]byte) int) ([]byte, []string, int) {
+	tokens := estimate(body)
+
+	doc, err := decodeJSONBody(body)
+	if err != nil {
+		return body, nil, tokens
+	}
+	req, ok := doc.(map[string]interface{})
+	if !ok {
+		return body, nil, tokens
+	}
+	key := conversationKey(clientFormat)
+	items, ok := req[key].([]interface{})
+	if !ok {
+		return body, nil, tokens
+	}
+
+	turns := turnStarts(clientFormat, items)
+	keepTurns := guard.KeepTurnsOrDefault()
+	protectedFrom := 0 // items from here on belong to the most recent turns
+	if len(turns) > keepTurns {
+		protectedFrom = turns[len(turns)-keepTurns]
+	}
+
+	var changes []string
+	for _, step := range guard.CompactionOrDefault() {
+		if tokens <= limit || protectedFrom == 0 {
+			break
+		}
+
+		switch step {
+		case config.CompactDropToolResults:
+			dropped := 0
+			for _, item := range items[:protectedFrom] {
+				dropped += dropToolResults(clientFormat, item)
+			}
+			if dropped == 0 {
+				continue
+			}
+			changes = append(changes, fmt.Sprintf("dropped %d tool results", dropped))
+
+		case config.CompactTrimTurns:
+			// The first turn usually states the task, so only the turns between it and the recent ones go
+			if len(turns) < 2 || turns[1] >= protectedFrom {
+				continue
+			}
+			var middle [][]interface{}
+			for i := 1; i < len(turns) && turns[i] < protectedFrom; i++ {
+				end := protectedFrom
+				if i+1 < len(turns) && turns[i+1] < protectedFrom {
+					end = turns[i+1]
+				}
+				middle = append(middle, items[turns[i]:end])
+			}
+
+			// Estimate what each turn costs once, then drop the oldest until the rest fits
+			base := estimateItems(req, key, nil, estimate)
+			removed, saved := 0, 0
+			for removed < len(middle) && tokens-saved > limit {
+				saved += estimateItems(req, key, middle[removed], estimate) - base
+				removed++
+			}
+
+			trimmed := make([]interface{}, 0, len(items))
+			trimmed = append(trimmed, items[:turns[1]]...)
+			for i := 0; i < removed; i++ {
+				trimmed = append(trimmed, pinnedItems(middle[i])...)
+			}
+			for i := removed; i < len(middle); i++ {
+				trimmed = append(trimmed, middle[i]...)
+			}
+			trimmed = append(trimmed, items[protectedFrom:]...)
+
+			protectedFrom -= len(items) - len(trimmed)
+			items = trimmed
+			turns = turnStarts(clientFormat, items)
+			changes = append(changes, fmt.Sprintf("trimmed %d turns", removed))
+		}
+
+		req[key] = items
+		if data, err := json.Marshal(req); err == nil {
+			body = data
+			tokens = estimate(body)
+		}
+	}
+
+	return body, changes, tokens
+}
+
+// conversationKey returns the request field holding the conversation
+func conversationKey(clientFormat ClientFormat) string {
+	if clientFormat == ClientFormatOpenAIResponses {
+		return "input"
+	}
+	return "messages"
+}
+
+// estimateItems estimates a request carrying only the given conversation items
+func estimateItems(req map[string]interface{}, key string, items []interface{}, estimate func([]byte) int) int {
+	if items == nil {
+		items = []interface{}{}
+	}
+	data, err := json.Marshal(map[string]interface{}{"model": req["model"], key: items})
+	if err != nil {
+		return 0
+	}
+	return estimate(data)
+}
+
+// turnStarts returns the indexes of the items that start a turn: user messages other than tool results
+func turnStarts(clientFormat ClientFormat, items []interface{}) []int {
+	var starts []int
+	for i, item := range items {
+		msg, ok := item.(map[string]interface{})
+		if !ok || msg["role"] != "user" {
+			continue
+		}
+		if clientFormat == ClientFormatClaude && isToolResultMessage(msg) {
+			continue
+		}
+		starts = append(starts, i)
+	}
+	return starts
+}
+
+// isToolResultMessage reports whether a Claude user message only carries tool results
+func isToolResultMessage(msg map[string]interface{}) bool {
+	blocks, ok := msg["content"].([]interface{})
+	if !ok || len(blocks) == 0 {
+		return false
+	}
+	for _, block := range blocks {
+		if b, ok := block.(map[string]interface{}); !ok || b["type"] != "tool_result" {
+			return false
+		}
+	}
+	return true
+}
+
+// pinnedItems returns the items trimming must keep: system and developer messages
+func pinnedItems(items []interface{}) []interface{} {
+	var pinned []interface{}
+	for _, item := range items {
+		if msg, ok := item.(map[string]interface{}); ok && (msg["role"] == "system" || msg["role"] == "developer") {
+			pinned = append(pinned, item)
+		}
+	}
+	return pinned
+}
+
+// dropToolResults replaces the tool result bodies in a conversation item and returns how many it replaced
+func dropToolResults(clientFormat ClientFormat, item interface{}) int {
+	msg, ok := item.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+
+	switch clientFormat {
+	case ClientFormatClaude:
+		blocks, ok := msg["content"].([]interface{})
+		if !ok {
+			return 0
+		}
+		dropped := 0
+		for _, block := range blocks {
+			b, ok := block.(map[string]interface{})
+			if !ok || b["type"] != "tool_result" || b["content"] == toolResultPlaceholder {
+				continue
+			}
+			b["content"] = toolResultPlaceholder
+			dropped++
+		}
+		return dropped
+	case ClientFormatOpenAIChat:
+		if msg["role"] == "tool" && msg["content"] != toolResultPlaceholder {
+			msg["content"] = toolResultPlaceholder
+			return 1
+		}
+	case ClientFormatOpenAIResponses:
+		if msg["type"] == "function_call_output" && msg["output"] != toolResultPlaceholder {
+			msg["output"] = toolResultPlaceholder
+			return 1
+		}
+	}
+	return 0
+}
+
+// setContextGuardHeader reports the guard's actions on the response, clearing any left by an earlier attempt
+func setContextGuardHeader(header http.Header, actions []string) {
+	if len(actions) == 0 {
+		header.Del(contextGuardHeader)
+		return
+	}
+	header.Set(contextGuardHeader, strings.Join(actions, "; "))
+}
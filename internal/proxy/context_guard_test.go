@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lich0821/ccNexus/internal/config"
+)
+
+func TestContextLengthErrorsAreNotRetried(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusRequestEntityTooLarge} {
+		if shouldRetry(status) {
+			t.Errorf("shouldRetry(%d) = true, want false", status)
+		}
+	}
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway} {
+		if !shouldRetry(status) {
+			t.Errorf("shouldRetry(%d) = false, want true", status)
+		}
+	}
+
+	tests := []struct {
+		status int
+		body   string
+		want   bool
+	}{
+		{http.StatusRequestEntityTooLarge, "", true},
+		{http.StatusBadRequest, `{"error": {"type": "invalid_request_error", "message": "prompt is too long: 210000 tokens > 200000 maximum"}}`, true},
+		{http.StatusBadRequest, `{"error": {"code": "context_length_exceeded"}}`, true},
+		{http.StatusBadRequest, `{"error": {"message": "This model's Maximum Context length is 8192 tokens"}}`, true},
+		{http.StatusBadRequest, `{"error": {"message": "max_tokens: field required"}}`, false},
+		{http.StatusInternalServerError, `context length`, false},
+	}
+	for _, tt := range tests {
+		if got := isContextLengthError(tt.status, []byte(tt.body)); got != tt.want {
+			t.Errorf("isContextLengthError(%d, %s) = %v, want %v", tt.status, tt.body, got, tt.want)
+		}
+	}
+}
+
+// contextLimitUpstream rejects every request for its size with the given status
+func contextLimitUpstream(status int, hits *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*hits++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"type": "error", "error": {"type": "invalid_request_error", "message": "prompt is too long"}}`))
+	}))
+}
+
+func TestUpstreamContextErrorReroutesToLargerWindow(t *testing.T) {
+	smallHits, largeHits := 0, 0
+	small := contextLimitUpstream(http.StatusBadRequest, &smallHits)
+	defer small.Close()
+	large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		largeHits++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "msg_1", "type": "message", "role": "assistant", "model": "claude-large",
+			"content": [{"type": "text", "text": "Done"}], "stop_reason": "end_turn",
+			"usage": {"input_tokens": 10, "output_tokens": 1}}`))
+	}))
+	defer large.Close()
+
+	p := newTestProxy(
+		config.Endpoint{Name: "small", APIUrl: small.URL, APIKey: "k", Enabled: true, Transformer: "claude", Model: "claude-small"},
+		config.Endpoint{Name: "large", APIUrl: large.URL, APIKey: "k", Enabled: true, Transformer: "claude", Model: "claude-large"},
+	)
+	p.config.UpdateContextGuard(&config.ContextGuardConfig{
+		Enabled:     true,
+		ModelLimits: map[string]int{"claude-small": 1000, "claude-large": 200000},
+	})
+
+	body := `{"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "user", "content": "Hi"}]}`
+	rec := httptest.NewRecorder()
+	p.handleProxy(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body)))
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Done") {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	if smallHits != 1 || largeHits != 1 {
+		t.Errorf("Expected one request to each endpoint, got small=%d large=%d", smallHits, largeHits)
+	}
+	if got := rec.Header().Get(contextGuardHeader); !strings.Contains(got, "rerouted small->large") {
+		t.Errorf("%s = %q, want the reroute reported", contextGuardHeader, got)
+	}
+}
+
+func TestUpstreamContextErrorWithoutLargerWindowIsReturned(t *testing.T) {
+	hits := 0
+	upstream := contextLimitUpstream(http.StatusRequestEntityTooLarge, &hits)
+	defer upstream.Close()
+
+	// Without a larger window the error goes back to the client after a single attempt
+	p := newTestProxy(
+		config.Endpoint{Name: "a", APIUrl: upstream.URL, APIKey: "k", Enabled: true, Transformer: "claude", Model: "claude-small"},
+		config.Endpoint{Name: "b", APIUrl: upstream.URL, APIKey: "k", Enabled: true, Transformer: "claude", Model: "claude-small"},
+	)
+	p.config.UpdateContextGuard(&config.ContextGuardConfig{Enabled: true, ModelLimits: map[string]int{"claude-small": 1000}})
+
+	body := `{"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "user", "content": "Hi"}]}`
+	rec := httptest.NewRecorder()
+	p.handleProxy(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body)))
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+	if hits != 1 {
+		t.Errorf("Expected a single upstream request, got %d", hits)
+	}
+}
@@ -0,0 +1,305 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+	"github.com/lich0821/ccNexus/internal/tokencount"
+)
+
+const (
+	tokenCountTimeout   = 30 * time.Second
+	tokenCountCacheTTL  = 10 * time.Minute
+	tokenCountCacheSize = 512
+)
+
+// Native token counters of upstream APIs
+const (
+	counterClaude    = "claude"    // POST /v1/messages/count_tokens
+	counterGemini    = "gemini"    // POST /v1beta/models/{model}:countTokens
+	counterResponses = "responses" // POST /v1/responses/input_tokens
+)
+
+// Fields that generation requests carry but the counting endpoints reject
+var (
+	claudeCountExcludedFields    = []string{"max_tokens", "stream", "temperature", "top_p", "top_k", "stop_sequences", "metadata", "service_tier"}
+	responsesCountExcludedFields = []string{"stream", "max_output_tokens", "temperature", "top_p", "store", "metadata", "include", "user", "service_tier", "background"}
+)
+
+// tokenCountCache caches upstream token counts by request hash
+type tokenCountCache struct {
+	mu      sync.Mutex
+	entries map[string]tokenCountEntry
+}
+
+type tokenCountEntry struct {
+	tokens  int
+	expires time.Time
+}
+
+func newTokenCountCache() *tokenCountCache {
+	return &tokenCountCache{entries: make(map[string]tokenCountEntry)}
+}
+
+func (c *tokenCountCache) get(key string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return 0, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return 0, false
+	}
+	return entry.tokens, true
+}
+
+func (c *tokenCountCache) put(key string, tokens int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= tokenCountCacheSize {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		// Still full: drop everything rather than track recency
+		if len(c.entries) >= tokenCountCacheSize {
+			c.entries = make(map[string]tokenCountEntry)
+		}
+	}
+	c.entries[key] = tokenCountEntry{tokens: tokens, expires: now.Add(tokenCountCacheTTL)}
+}
+
+// tokenCountKey hashes everything that affects an upstream count
+func tokenCountKey(clientFormat ClientFormat, endpoint config.Endpoint, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00", clientFormat, endpoint.Name, endpoint.APIUrl, endpoint.Transformer, endpoint.Model)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// nativeCounter returns the native token counter reachable through a transformer, if any
+func nativeCounter(transformerName string) string {
+	switch transformerName {
+	case "cc_claude", "cx_chat_claude", "cx_resp_claude":
+		return counterClaude
+	case "cc_gemini", "cx_chat_gemini", "cx_resp_gemini":
+		return counterGemini
+	case "cc_openai2", "cx_chat_openai2", "cx_resp_openai2":
+		return counterResponses
+	}
+	return ""
+}
+
+// serveTokenCount answers a token counting request with the current endpoint's native counter,
+// falling back to the local estimator
+func (p *Proxy) serveTokenCount(w http.ResponseWriter, r *http.Request, clientFormat ClientFormat) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil || !json.Valid(body) {
+		logger.Error("Failed to decode token count request: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	endpoint := p.getCurrentEndpoint()
+	tokens, ok := 0, false
+	if endpoint.Name != "" {
+		tokens, ok = p.countTokensUpstream(r, clientFormat, endpoint, body)
+	}
+	if !ok {
+		tokens = estimateClientInputTokens(clientFormat, endpoint, body)
+		logger.Debug("Estimated input tokens locally: %d", tokens)
+	}
+
+	response := map[string]interface{}{
+		"input_tokens": tokens,
+	}
+	if clientFormat == ClientFormatOpenAIResponses {
+		response["object"] = "response.input_tokens"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// countTokensUpstream asks the endpoint's native counter for the request's input tokens
+func (p *Proxy) countTokensUpstream(r *http.Request, clientFormat ClientFormat, endpoint config.Endpoint, body []byte) (int, bool) {
+	trans, err := prepareTransformerForClient(clientFormat, endpoint)
+	if err != nil {
+		return 0, false
+	}
+	counter := nativeCounter(trans.Name())
+	if counter == "" {
+		return 0, false
+	}
+
+	key := tokenCountKey(clientFormat, endpoint, body)
+	if tokens, ok := p.tokenCounts.get(key); ok {
+		logger.Debug("[%s] Token count cache hit: %d", endpoint.Name, tokens)
+		return tokens, true
+	}
+
+	requestBody := applyBodyRewrites(endpoint.Name, body, endpoint.RewriteRules, config.RewritePhaseClient)
+	transformedBody, err := trans.TransformRequest(requestBody)
+	if err != nil {
+		logger.Debug("[%s] Failed to transform token count request: %v", endpoint.Name, err)
+		return 0, false
+	}
+	transformedBody = applyBodyRewrites(endpoint.Name, transformedBody, endpoint.RewriteRules, config.RewritePhaseUpstream)
+
+	countBody, countPath, err := buildCountTokensBody(counter, endpoint, transformedBody)
+	if err != nil {
+		logger.Debug("[%s] Failed to build token count request: %v", endpoint.Name, err)
+		return 0, false
+	}
+
+	proxyReq, err := buildProxyRequest(r, endpoint, countBody, trans.Name())
+	if err != nil {
+		return 0, false
+	}
+	targetURL, err := url.Parse(normalizeAPIUrl(endpoint.APIUrl) + countPath)
+	if err != nil {
+		return 0, false
+	}
+	query := proxyReq.URL.Query()
+	query.Del("alt")
+	if counter != counterClaude {
+		// Client query parameters such as ?beta=true only make sense to Anthropic
+		for k := range r.URL.Query() {
+			query.Del(k)
+		}
+	}
+	targetURL.RawQuery = query.Encode()
+	proxyReq.URL = targetURL
+	proxyReq.Method = http.MethodPost
+	applyHeaderRewrites(proxyReq, endpoint.RewriteRules)
+
+	ctx, cancel := context.WithTimeout(p.getEndpointContext(endpoint.Name), tokenCountTimeout)
+	defer cancel()
+	resp, err := sendRequest(ctx, proxyReq, p.config)
+	if err != nil {
+		logger.Debug("[%s] Token count request failed: %v", endpoint.Name, err)
+		return 0, false
+	}
+	defer resp.Body.Close()
+
+	var respBody []byte
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		respBody, err = decompressGzip(resp.Body)
+	} else {
+		respBody, err = io.ReadAll(resp.Body)
+	}
+	if err != nil {
+		return 0, false
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.Debug("[%s] Token count request returned HTTP %d: %s", endpoint.Name, resp.StatusCode, string(respBody))
+		return 0, false
+	}
+
+	tokens, ok := parseCountTokensResponse(counter, respBody)
+	if !ok {
+		logger.Debug("[%s] Unexpected token count response: %s", endpoint.Name, string(respBody))
+		return 0, false
+	}
+
+	p.tokenCounts.put(key, tokens)
+	logger.Debug("[%s] Upstream counted %d input tokens", endpoint.Name, tokens)
+	return tokens, true
+}
+
+// buildCountTokensBody turns a transformed generation request into a request for the native counter
+func buildCountTokensBody(counter string, endpoint config.Endpoint, transformedBody []byte) ([]byte, string, error) {
+	var req map[string]interface{}
+	if err := json.Unmarshal(transformedBody, &req); err != nil {
+		return nil, "", err
+	}
+
+	switch counter {
+	case counterClaude:
+		for _, field := range claudeCountExcludedFields {
+			delete(req, field)
+		}
+		data, err := json.Marshal(req)
+		return data, "/v1/messages/count_tokens", err
+	case counterResponses:
+		for _, field := range responsesCountExcludedFields {
+			delete(req, field)
+		}
+		data, err := json.Marshal(req)
+		return data, "/v1/responses/input_tokens", err
+	case counterGemini:
+		delete(req, "stream")
+		req["model"] = "models/" + endpoint.Model
+		data, err := json.Marshal(map[string]interface{}{"generateContentRequest": req})
+		return data, fmt.Sprintf("/v1beta/models/%s:countTokens", endpoint.Model), err
+	}
+	return nil, "", fmt.Errorf("unknown token counter: %s", counter)
+}
+
+// parseCountTokensResponse extracts the token count from a native counter response
+func parseCountTokensResponse(counter string, body []byte) (int, bool) {
+	var resp struct {
+		InputTokens *int `json:"input_tokens"`
+		TotalTokens *int `json:"totalTokens"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return 0, false
+	}
+	if counter == counterGemini {
+		if resp.TotalTokens == nil {
+			return 0, false
+		}
+		return *resp.TotalTokens, true
+	}
+	if resp.InputTokens == nil {
+		return 0, false
+	}
+	return *resp.InputTokens, true
+}
+
+// estimateClientInputTokens estimates input tokens locally, with the tokenizer of the model
+// the endpoint actually serves
+func estimateClientInputTokens(clientFormat ClientFormat, endpoint config.Endpoint, body []byte) int {
+	switch clientFormat {
+	case ClientFormatOpenAIResponses:
+		var req tokencount.ResponsesInputTokensRequest
+		if json.Unmarshal(body, &req) != nil {
+			return 0
+		}
+		if endpoint.Model != "" {
+			req.Model = endpoint.Model
+		}
+		return tokencount.EstimateResponsesInputTokens(&req)
+	default:
+		var req tokencount.CountTokensRequest
+		if json.Unmarshal(body, &req) != nil {
+			return 0
+		}
+		if endpoint.Model != "" {
+			req.Model = endpoint.Model
+		}
+		return tokencount.EstimateInputTokens(&req)
+	}
+}
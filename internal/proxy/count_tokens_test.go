@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lich0821/ccNexus/internal/config"
+)
+
+// countTokensUpstream serves a native token counter and records the requests it receives
+func countTokensUpstream(t *testing.T, status int, response string, requests *[]*http.Request, bodies *[]map[string]interface{}) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		*requests = append(*requests, r)
+		*bodies = append(*bodies, body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+}
+
+func newCountTokensProxy(upstreamURL, transformerName, model string) *Proxy {
+	return newTestProxy(config.Endpoint{
+		Name:        "test",
+		APIUrl:      upstreamURL,
+		APIKey:      "test-key",
+		Enabled:     true,
+		Transformer: transformerName,
+		Model:       model,
+	})
+}
+
+func countTokens(t *testing.T, handler http.HandlerFunc, path, body string) map[string]interface{} {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Response is not JSON: %s", rec.Body.String())
+	}
+	return resp
+}
+
+const countTokensClaudeRequest = `{"model": "claude-sonnet-4", "max_tokens": 1024, "stream": true, "temperature": 0.5,
+	"messages": [{"role": "user", "content": "Hello there, how are you today?"}]}`
+
+func TestCountTokensForwardsToClaude(t *testing.T) {
+	var requests []*http.Request
+	var bodies []map[string]interface{}
+	upstream := countTokensUpstream(t, http.StatusOK, `{"input_tokens": 42}`, &requests, &bodies)
+	defer upstream.Close()
+	p := newCountTokensProxy(upstream.URL, "claude", "")
+
+	resp := countTokens(t, p.handleCountTokens, "/v1/messages/count_tokens", countTokensClaudeRequest)
+	if resp["input_tokens"] != float64(42) {
+		t.Errorf("input_tokens = %v, want the upstream count 42", resp["input_tokens"])
+	}
+	if len(requests) != 1 || requests[0].URL.Path != "/v1/messages/count_tokens" {
+		t.Fatalf("Expected one request to /v1/messages/count_tokens, got %d", len(requests))
+	}
+	for _, field := range []string{"max_tokens", "stream", "temperature"} {
+		if _, ok := bodies[0][field]; ok {
+			t.Errorf("Expected %s to be removed from the count request, got %v", field, bodies[0])
+		}
+	}
+	if bodies[0]["messages"] == nil {
+		t.Errorf("Expected the messages to be forwarded, got %v", bodies[0])
+	}
+
+	// The same request is answered from the cache
+	resp = countTokens(t, p.handleCountTokens, "/v1/messages/count_tokens", countTokensClaudeRequest)
+	if resp["input_tokens"] != float64(42) || len(requests) != 1 {
+		t.Errorf("Expected a cached count, got %v after %d upstream requests", resp["input_tokens"], len(requests))
+	}
+}
+
+func TestCountTokensFallsBackToEstimator(t *testing.T) {
+	var requests []*http.Request
+	var bodies []map[string]interface{}
+	upstream := countTokensUpstream(t, http.StatusBadRequest, `{"error": "unsupported"}`, &requests, &bodies)
+	defer upstream.Close()
+
+	// A failed upstream count falls back to the local estimate and is not cached
+	p := newCountTokensProxy(upstream.URL, "claude", "")
+	for i := 0; i < 2; i++ {
+		resp := countTokens(t, p.handleCountTokens, "/v1/messages/count_tokens", countTokensClaudeRequest)
+		if tokens, _ := resp["input_tokens"].(float64); tokens <= 0 {
+			t.Errorf("Expected a local estimate, got %v", resp["input_tokens"])
+		}
+	}
+	if len(requests) != 2 {
+		t.Errorf("Expected failed counts to be retried upstream, got %d requests", len(requests))
+	}
+
+	// Chat Completions has no native counter, so the upstream is never asked
+	requests = nil
+	p = newCountTokensProxy(upstream.URL, "openai", "gpt-4o")
+	resp := countTokens(t, p.handleCountTokens, "/v1/messages/count_tokens", countTokensClaudeRequest)
+	if tokens, _ := resp["input_tokens"].(float64); tokens <= 0 {
+		t.Errorf("Expected a local estimate, got %v", resp["input_tokens"])
+	}
+	if len(requests) != 0 {
+		t.Errorf("Expected no upstream request, got %d", len(requests))
+	}
+}
+
+func TestResponsesInputTokensForwardsToResponses(t *testing.T) {
+	var requests []*http.Request
+	var bodies []map[string]interface{}
+	upstream := countTokensUpstream(t, http.StatusOK, `{"object": "response.input_tokens", "input_tokens": 17}`, &requests, &bodies)
+	defer upstream.Close()
+	p := newCountTokensProxy(upstream.URL, "openai2", "gpt-4o")
+
+	body := `{"model": "gpt-4o", "stream": true, "max_output_tokens": 100, "instructions": "Be brief", "input": "Hi"}`
+	resp := countTokens(t, p.handleResponsesInputTokens, "/v1/responses/input_tokens", body)
+	if resp["input_tokens"] != float64(17) || resp["object"] != "response.input_tokens" {
+		t.Errorf("Unexpected response: %v", resp)
+	}
+	if len(requests) != 1 || requests[0].URL.Path != "/v1/responses/input_tokens" {
+		t.Fatalf("Expected one request to /v1/responses/input_tokens, got %d", len(requests))
+	}
+	if _, ok := bodies[0]["stream"]; ok || bodies[0]["max_output_tokens"] != nil || bodies[0]["input"] != "Hi" {
+		t.Errorf("Unexpected count request: %v", bodies[0])
+	}
+}
+
+func TestBuildCountTokensBodyGemini(t *testing.T) {
+	endpoint := config.Endpoint{Model: "gemini-2.5-flash"}
+	body, path, err := buildCountTokensBody(counterGemini, endpoint, []byte(`{"stream": true, "contents": [{"role": "user", "parts": [{"text": "Hi"}]}]}`))
+	if err != nil {
+		t.Fatalf("buildCountTokensBody failed: %v", err)
+	}
+	if path != "/v1beta/models/gemini-2.5-flash:countTokens" {
+		t.Errorf("path = %s", path)
+	}
+	var req struct {
+		GenerateContentRequest map[string]interface{} `json:"generateContentRequest"`
+	}
+	json.Unmarshal(body, &req)
+	if req.GenerateContentRequest["model"] != "models/gemini-2.5-flash" || req.GenerateContentRequest["stream"] != nil {
+		t.Errorf("Unexpected Gemini count request: %s", body)
+	}
+
+	if tokens, ok := parseCountTokensResponse(counterGemini, []byte(`{"totalTokens": 9}`)); !ok || tokens != 9 {
+		t.Errorf("parseCountTokensResponse(gemini) = %d, %v", tokens, ok)
+	}
+	if _, ok := parseCountTokensResponse(counterClaude, []byte(`{"totalTokens": 9}`)); ok {
+		t.Errorf("A Claude counter response without input_tokens should be rejected")
+	}
+}
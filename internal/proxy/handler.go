@@ -6,7 +6,6 @@ import (
 
 	"github.com/lich0821/ccNexus/internal/config"
 	"github.com/lich0821/ccNexus/internal/logger"
-	"github.com/lich0821/ccNexus/internal/tokencount"
 )
 
 // handleHealth handles health check requests
@@ -36,72 +35,14 @@ func (p *Proxy) GetStats() *Stats {
 	return p.stats
 }
 
-// handleCountTokens handles token counting requests
+// handleCountTokens handles Claude token counting requests (/v1/messages/count_tokens)
 func (p *Proxy) handleCountTokens(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var req struct {
-		Model    string                   `json:"model"`
-		System   interface{}              `json:"system,omitempty"`
-		Messages []map[string]interface{} `json:"messages"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Error("Failed to decode count_tokens request: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	systemText := ""
-	if req.System != nil {
-		switch sys := req.System.(type) {
-		case string:
-			systemText = sys
-		case []interface{}:
-			for _, block := range sys {
-				if blockMap, ok := block.(map[string]interface{}); ok {
-					if text, ok := blockMap["text"].(string); ok {
-						systemText += text + "\n"
-					}
-				}
-			}
-		}
-	}
-
-	totalTokens := 0
-	if systemText != "" {
-		totalTokens += tokencount.EstimateOutputTokens(systemText)
-	}
-
-	for _, msg := range req.Messages {
-		content, ok := msg["content"]
-		if !ok {
-			continue
-		}
-
-		switch c := content.(type) {
-		case string:
-			totalTokens += tokencount.EstimateOutputTokens(c)
-		case []interface{}:
-			for _, block := range c {
-				if blockMap, ok := block.(map[string]interface{}); ok {
-					if text, ok := blockMap["text"].(string); ok {
-						totalTokens += tokencount.EstimateOutputTokens(text)
-					}
-				}
-			}
-		}
-	}
-
-	response := map[string]interface{}{
-		"input_tokens": totalTokens,
-	}
+	p.serveTokenCount(w, r, ClientFormatClaude)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// handleResponsesInputTokens handles Responses token counting requests (/v1/responses/input_tokens)
+func (p *Proxy) handleResponsesInputTokens(w http.ResponseWriter, r *http.Request) {
+	p.serveTokenCount(w, r, ClientFormatOpenAIResponses)
 }
 
 // UpdateConfig updates the proxy configuration
@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+)
+
+const (
+	modelListCacheTTL      = 10 * time.Minute
+	modelListErrorCacheTTL = time.Minute // Failed fetches are retried sooner, but not on every call
+	defaultModelListLimit  = 20
+	maxModelListLimit      = 1000
+	modelsPath             = "/v1/models"
+)
+
+// ModelLister fetches the models an endpoint offers
+type ModelLister interface {
+	ListModels(endpoint config.Endpoint) ([]string, error)
+}
+
+// SetModelLister sets how /v1/models fetches the models of each endpoint. Without one,
+// only the models configured on the endpoints are listed.
+func (p *Proxy) SetModelLister(lister ModelLister) {
+	p.modelLister = lister
+}
+
+// modelListCache caches the model list of each endpoint
+type modelListCache struct {
+	mu      sync.Mutex
+	entries map[string]modelListEntry
+}
+
+type modelListEntry struct {
+	models    []string
+	fetchedAt time.Time
+	expires   time.Time
+}
+
+func newModelListCache() *modelListCache {
+	return &modelListCache{entries: make(map[string]modelListEntry)}
+}
+
+func (c *modelListCache) get(key string) (modelListEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return modelListEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *modelListCache) put(key string, entry modelListEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry
+}
+
+// modelListKey identifies the upstream a model list was fetched from
+func modelListKey(endpoint config.Endpoint) string {
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%s", endpoint.Name, endpoint.APIUrl, endpoint.APIKey, endpoint.Transformer)
+}
+
+// listedModel is a model offered through the proxy
+type listedModel struct {
+	ID       string
+	Endpoint string
+	Created  time.Time
+}
+
+// endpointModels returns the models of an endpoint, from the cache when possible
+func (p *Proxy) endpointModels(endpoint config.Endpoint) modelListEntry {
+	key := modelListKey(endpoint)
+	if entry, ok := p.modelLists.get(key); ok {
+		return entry
+	}
+
+	now := time.Now()
+	entry := modelListEntry{fetchedAt: now, expires: now.Add(modelListCacheTTL)}
+	if p.modelLister != nil {
+		models, err := p.modelLister.ListModels(endpoint)
+		if err != nil {
+			logger.Warn("[%s] Failed to fetch models: %v", endpoint.Name, err)
+			entry.expires = now.Add(modelListErrorCacheTTL)
+		}
+		entry.models = models
+	}
+	// The configured model is always reachable, even when the upstream cannot list it
+	if endpoint.Model != "" {
+		entry.models = append([]string{endpoint.Model}, entry.models...)
+	}
+	p.modelLists.put(key, entry)
+	return entry
+}
+
+// aggregateModels lists the models of all enabled endpoints in endpoint order. A model offered
+// by several endpoints is listed once, under the first of them.
+func (p *Proxy) aggregateModels() []listedModel {
+	endpoints := p.getEnabledEndpoints()
+	lists := make([]modelListEntry, len(endpoints))
+
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, endpoint config.Endpoint) {
+			defer wg.Done()
+			lists[i] = p.endpointModels(endpoint)
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	var models []listedModel
+	for i, list := range lists {
+		for _, id := range list.models {
+			if id = strings.TrimSpace(id); id == "" || seen[id] {
+				continue
+			}
+			seen[id] = true
+			models = append(models, listedModel{ID: id, Endpoint: endpoints[i].Name, Created: list.fetchedAt})
+		}
+	}
+	return models
+}
+
+// handleModels answers /v1/models and /v1/models/{id} with the models of all enabled endpoints,
+// in the Anthropic format when the client sends anthropic-version and the OpenAI format otherwise
+func (p *Proxy) handleModels(w http.ResponseWriter, r *http.Request) {
+	anthropic := r.Header.Get("anthropic-version") != ""
+	if r.Method != http.MethodGet {
+		writeModelsError(w, anthropic, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	models := p.aggregateModels()
+	if id := strings.Trim(strings.TrimPrefix(r.URL.Path, modelsPath), "/"); id != "" {
+		for _, m := range models {
+			if m.ID == id {
+				writeJSON(w, http.StatusOK, modelObject(m, anthropic))
+				return
+			}
+		}
+		writeModelsError(w, anthropic, http.StatusNotFound, fmt.Sprintf("Model %s not found", id))
+		return
+	}
+
+	if !anthropic {
+		data := make([]map[string]interface{}, len(models))
+		for i, m := range models {
+			data[i] = modelObject(m, false)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": data})
+		return
+	}
+
+	// Anthropic lists are paginated with limit, after_id and before_id
+	query := r.URL.Query()
+	limit := defaultModelListLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxModelListLimit {
+			writeModelsError(w, true, http.StatusBadRequest, fmt.Sprintf("limit: must be between 1 and %d", maxModelListLimit))
+			return
+		}
+		limit = n
+	}
+	// An unknown cursor yields an empty page
+	page, hasMore := models, false
+	if afterID := query.Get("after_id"); afterID != "" {
+		page = nil
+		if i := modelIndex(models, afterID); i >= 0 {
+			page = models[i+1:]
+		}
+	} else if beforeID := query.Get("before_id"); beforeID != "" {
+		page = nil
+		if i := modelIndex(models, beforeID); i >= 0 {
+			page = models[:i]
+		}
+		if len(page) > limit {
+			page, hasMore = page[len(page)-limit:], true
+		}
+	}
+	if len(page) > limit {
+		page, hasMore = page[:limit], true
+	}
+
+	data := make([]map[string]interface{}, len(page))
+	for i, m := range page {
+		data[i] = modelObject(m, true)
+	}
+	var firstID, lastID interface{}
+	if len(page) > 0 {
+		firstID, lastID = page[0].ID, page[len(page)-1].ID
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":     data,
+		"has_more": hasMore,
+		"first_id": firstID,
+		"last_id":  lastID,
+	})
+}
+
+// modelIndex returns the position of a model in the list, or -1 when it is not there
+func modelIndex(models []listedModel, id string) int {
+	for i, m := range models {
+		if m.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// modelObject returns the API object of a model
+func modelObject(m listedModel, anthropic bool) map[string]interface{} {
+	if anthropic {
+		return map[string]interface{}{
+			"type":         "model",
+			"id":           m.ID,
+			"display_name": m.ID,
+			"created_at":   m.Created.UTC().Format(time.RFC3339),
+		}
+	}
+	return map[string]interface{}{
+		"id":       m.ID,
+		"object":   "model",
+		"created":  m.Created.Unix(),
+		"owned_by": m.Endpoint,
+	}
+}
+
+// writeModelsError writes an error in the format of the client
+func writeModelsError(w http.ResponseWriter, anthropic bool, status int, message string) {
+	if anthropic {
+		writeBatchError(w, status, batchErrorType(status), message)
+		return
+	}
+	writeOpenAIError(w, status, "invalid_request_error", "", message)
+}
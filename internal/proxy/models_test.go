@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/lich0821/ccNexus/internal/config"
+)
+
+// fakeModelLister returns fixed model lists by endpoint name and counts its calls
+type fakeModelLister struct {
+	mu     sync.Mutex
+	models map[string][]string
+	calls  map[string]int
+}
+
+func (l *fakeModelLister) ListModels(endpoint config.Endpoint) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls[endpoint.Name]++
+	models, ok := l.models[endpoint.Name]
+	if !ok {
+		return nil, fmt.Errorf("unreachable")
+	}
+	return models, nil
+}
+
+func newModelsTestProxy() (*Proxy, *fakeModelLister) {
+	p := newTestProxy(
+		config.Endpoint{Name: "a", APIUrl: "http://a", Enabled: true, Transformer: "claude"},
+		config.Endpoint{Name: "b", APIUrl: "http://b", Enabled: true, Transformer: "openai", Model: "gpt-4o"},
+		config.Endpoint{Name: "c", APIUrl: "http://c", Enabled: false, Transformer: "openai", Model: "gpt-disabled"},
+		config.Endpoint{Name: "d", APIUrl: "http://d", Enabled: true, Transformer: "gemini", Model: "gemini-2.5-pro"},
+	)
+	lister := &fakeModelLister{
+		models: map[string][]string{
+			"a": {"claude-sonnet-4", "claude-haiku-4"},
+			"b": {"gpt-4o-mini", "claude-sonnet-4", " "},
+			"c": {"gpt-hidden"},
+		},
+		calls: make(map[string]int),
+	}
+	p.SetModelLister(lister)
+	return p, lister
+}
+
+func getModels(p *Proxy, path string, anthropic bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if anthropic {
+		req.Header.Set("anthropic-version", "2023-06-01")
+	}
+	rec := httptest.NewRecorder()
+	p.handleModels(rec, req)
+	return rec
+}
+
+func TestModelsOpenAIList(t *testing.T) {
+	p, lister := newModelsTestProxy()
+
+	rec := getModels(p, modelsPath, false)
+	var resp struct {
+		Object string `json:"object"`
+		Data   []struct {
+			ID      string `json:"id"`
+			Object  string `json:"object"`
+			OwnedBy string `json:"owned_by"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	// Enabled endpoints in order, configured models first, duplicates and blanks dropped, and a
+	// failed fetch still lists the configured model
+	want := []struct{ id, owner string }{
+		{"claude-sonnet-4", "a"}, {"claude-haiku-4", "a"}, {"gpt-4o", "b"}, {"gpt-4o-mini", "b"}, {"gemini-2.5-pro", "d"},
+	}
+	if resp.Object != "list" || len(resp.Data) != len(want) {
+		t.Fatalf("Unexpected list: %s", rec.Body.String())
+	}
+	for i, w := range want {
+		if resp.Data[i].ID != w.id || resp.Data[i].OwnedBy != w.owner || resp.Data[i].Object != "model" {
+			t.Errorf("model %d = %+v, want %s owned by %s", i, resp.Data[i], w.id, w.owner)
+		}
+	}
+
+	// Lists are cached per endpoint, failed fetches included
+	getModels(p, modelsPath, false)
+	for _, name := range []string{"a", "b", "d"} {
+		if lister.calls[name] != 1 {
+			t.Errorf("endpoint %s was asked %d times, want once", name, lister.calls[name])
+		}
+	}
+	if lister.calls["c"] != 0 {
+		t.Errorf("Disabled endpoints should not be asked for models")
+	}
+}
+
+func TestModelsRetrieve(t *testing.T) {
+	p, _ := newModelsTestProxy()
+
+	rec := getModels(p, modelsPath+"/gpt-4o-mini", false)
+	var model map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &model)
+	if rec.Code != http.StatusOK || model["id"] != "gpt-4o-mini" || model["owned_by"] != "b" {
+		t.Errorf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	rec = getModels(p, modelsPath+"/claude-haiku-4", true)
+	json.Unmarshal(rec.Body.Bytes(), &model)
+	if rec.Code != http.StatusOK || model["type"] != "model" || model["display_name"] != "claude-haiku-4" {
+		t.Errorf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	rec = getModels(p, modelsPath+"/gpt-hidden", true)
+	var apiErr struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &apiErr)
+	if rec.Code != http.StatusNotFound || apiErr.Type != "error" || apiErr.Error.Type != "not_found_error" {
+		t.Errorf("Models of disabled endpoints should not be found: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = getModels(p, modelsPath+"/missing", false)
+	if rec.Code != http.StatusNotFound || !json.Valid(rec.Body.Bytes()) {
+		t.Errorf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestModelsAnthropicPagination(t *testing.T) {
+	p, _ := newModelsTestProxy()
+
+	type page struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+		HasMore bool    `json:"has_more"`
+		FirstID *string `json:"first_id"`
+		LastID  *string `json:"last_id"`
+	}
+	ids := func(path string) ([]string, page) {
+		rec := getModels(p, path, true)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status = %d, body %s", path, rec.Code, rec.Body.String())
+		}
+		var pg page
+		json.Unmarshal(rec.Body.Bytes(), &pg)
+		var got []string
+		for _, m := range pg.Data {
+			got = append(got, m.ID)
+		}
+		return got, pg
+	}
+	equal := func(a, b []string) bool { return fmt.Sprint(a) == fmt.Sprint(b) }
+
+	got, pg := ids(modelsPath + "?limit=2")
+	if !equal(got, []string{"claude-sonnet-4", "claude-haiku-4"}) || !pg.HasMore || *pg.LastID != "claude-haiku-4" {
+		t.Errorf("first page = %v has_more=%v", got, pg.HasMore)
+	}
+	got, pg = ids(modelsPath + "?limit=2&after_id=claude-haiku-4")
+	if !equal(got, []string{"gpt-4o", "gpt-4o-mini"}) || !pg.HasMore {
+		t.Errorf("second page = %v has_more=%v", got, pg.HasMore)
+	}
+	got, pg = ids(modelsPath + "?limit=2&after_id=gpt-4o-mini")
+	if !equal(got, []string{"gemini-2.5-pro"}) || pg.HasMore {
+		t.Errorf("last page = %v has_more=%v", got, pg.HasMore)
+	}
+	got, pg = ids(modelsPath + "?limit=2&before_id=gemini-2.5-pro")
+	if !equal(got, []string{"gpt-4o", "gpt-4o-mini"}) || !pg.HasMore {
+		t.Errorf("page before gemini = %v has_more=%v", got, pg.HasMore)
+	}
+	got, pg = ids(modelsPath + "?after_id=unknown")
+	if len(got) != 0 || pg.FirstID != nil || pg.HasMore {
+		t.Errorf("unknown cursor = %v", got)
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=1001", "?limit=x"} {
+		if rec := getModels(p, modelsPath+query, true); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: status = %d, want 400", query, rec.Code)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, modelsPath, nil)
+	rec := httptest.NewRecorder()
+	p.handleModels(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %d, want 405", rec.Code)
+	}
+}
@@ -44,11 +44,23 @@ type Proxy struct {
 	endpointCancel   map[string]context.CancelFunc // cancel functions per endpoint
 	ctxMu            sync.RWMutex                 // protects context maps
 	onEndpointSuccess func(endpointName string)   // callback when endpoint request succeeds
+	tokenCounts      *tokenCountCache             // upstream token counts by request hash
+	responseCache    ResponseCacheStorage         // cached responses, nil when no storage is attached
+	batches          MessageBatchStorage          // message batches, nil when no storage is attached
+	batchWake        chan struct{}                // wakes batch workers when items are queued
+	batchStop        chan struct{}                // closed to stop the batch workers, nil when none run
+	batchWorkersMu   sync.Mutex                   // protects batchStop
+	batchWorkersWG   sync.WaitGroup               // running batch workers
+	modelLister      ModelLister                  // fetches endpoint models for /v1/models
+	modelLists       *modelListCache              // model lists by endpoint
+	wsSessions       *wsSessionStore              // Responses websocket sessions
+	responseStore    ResponseStore                // Responses API state for stateless upstreams
 }
 
 // New creates a new Proxy instance
 func New(cfg *config.Config, statsStorage StatsStorage, deviceID string) *Proxy {
 	stats := NewStats(statsStorage, deviceID)
+	stats.SetHourlyRetention(cfg.GetStatsHourlyDays)
 
 	return &Proxy{
 		config:         cfg,
@@ -57,6 +69,9 @@ func New(cfg *config.Config, statsStorage StatsStorage, deviceID string) *Proxy
 		activeRequests: make(map[string]bool),
 		endpointCtx:    make(map[string]context.Context),
 		endpointCancel: make(map[string]context.CancelFunc),
+		tokenCounts:    newTokenCountCache(),
+		modelLists:     newModelListCache(),
+		wsSessions:     newWSSessionStore(),
 	}
 }
 
@@ -84,6 +99,13 @@ func (p *Proxy) StartWithMux(customMux *http.ServeMux) error {
 	// Register proxy routes
 	mux.HandleFunc("/", p.handleProxy)
 	mux.HandleFunc("/v1/messages/count_tokens", p.handleCountTokens)
+	mux.HandleFunc("/v1/responses", p.handleResponses)
+	mux.HandleFunc("/v1/responses/", p.handleStoredResponse)
+	mux.HandleFunc("/v1/responses/input_tokens", p.handleResponsesInputTokens)
+	mux.HandleFunc("/v1/messages/batches", p.handleMessageBatches)
+	mux.HandleFunc("/v1/messages/batches/", p.handleMessageBatches)
+	mux.HandleFunc("/v1/models", p.handleModels)
+	mux.HandleFunc("/v1/models/", p.handleModels)
 	mux.HandleFunc("/health", p.handleHealth)
 	mux.HandleFunc("/stats", p.handleStats)
 
@@ -94,12 +116,15 @@ func (p *Proxy) StartWithMux(customMux *http.ServeMux) error {
 
 	logger.Info("ccNexus starting on port %d", port)
 	logger.Info("Configured %d endpoints", len(p.config.GetEndpoints()))
+	p.stats.StartDownsampling()
 
 	return p.server.ListenAndServe()
 }
 
 // Stop stops the proxy server
 func (p *Proxy) Stop() error {
+	p.stats.StopDownsampling()
+	p.stopBatchWorkers()
 	if p.server != nil {
 		return p.server.Close()
 	}
@@ -305,6 +330,24 @@ func (p *Proxy) handleProxy(w http.ResponseWriter, r *http.Request) {
 	logger.DebugLog("Method: %s, Path: %s, ClientFormat: %s", r.Method, r.URL.Path, clientFormat)
 	logger.DebugLog("Request Body: %s", string(bodyBytes))
 
+	// Run tools the proxy executes itself in a loop of rounds through this handler
+	if cfg := p.serverToolsFor(r, clientFormat); cfg != nil {
+		p.handleServerTools(w, r, bodyBytes, clientFormat, cfg)
+		return
+	}
+
+	// Replay conversations stored by the proxy for upstreams without Responses state
+	originalBody := bodyBytes
+	var previousResponseID string
+	if clientFormat == ClientFormatOpenAIResponses && p.responseStore != nil {
+		bodyBytes, previousResponseID, err = p.expandPreviousResponse(bodyBytes)
+		if err != nil {
+			logger.Error("Failed to expand previous response: %v", err)
+			http.Error(w, "Failed to load previous response", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	var streamReq struct {
 		Model    string      `json:"model"`
 		Thinking interface{} `json:"thinking"`
@@ -312,6 +355,15 @@ func (p *Proxy) handleProxy(w http.ResponseWriter, r *http.Request) {
 	}
 	json.Unmarshal(bodyBytes, &streamReq)
 
+	// Features the request relies on, matched against each endpoint's capability profile
+	features := detectRequestFeatures(clientFormat, bodyBytes)
+	thinkingRequested := false
+	for _, feature := range features {
+		if feature == config.CapThinking {
+			thinkingRequested = true
+		}
+	}
+
 	endpoints := p.getEnabledEndpoints()
 	if len(endpoints) == 0 {
 		logger.Error("No enabled endpoints available")
@@ -322,9 +374,41 @@ func (p *Proxy) handleProxy(w http.ResponseWriter, r *http.Request) {
 	maxRetries := len(endpoints) * 2
 	endpointAttempts := 0
 	lastEndpointName := ""
+	routed := make(map[string]bool) // endpoints this request was already routed to for capabilities
+	toolRetried := false            // the request was already retried for invalid tool calls
+
+	// Requests pinned to an endpoint, such as batch items, never move to another one
+	pinned, _ := r.Context().Value(pinnedEndpointKey{}).(string)
+	if pinned != "" {
+		maxRetries = 2
+		for _, ep := range endpoints {
+			if ep.Name != pinned {
+				routed[ep.Name] = true
+			}
+		}
+	}
+	rotate := func() {
+		if pinned == "" {
+			p.rotateEndpoint()
+		}
+	}
+
+	// Larger-window endpoint chosen after an upstream context length error
+	var overflowEndpoint *config.Endpoint
+	var overflowActions []string
 
 	for retry := 0; retry < maxRetries; retry++ {
 		endpoint := p.getCurrentEndpoint()
+		if overflowEndpoint != nil {
+			endpoint = *overflowEndpoint
+		}
+		if pinned != "" {
+			var found bool
+			if endpoint, found = p.findEndpoint(pinned); !found || !endpoint.Enabled {
+				http.Error(w, fmt.Sprintf("Endpoint %s is not available", pinned), http.StatusServiceUnavailable)
+				return
+			}
+		}
 		if endpoint.Name == "" {
 			http.Error(w, "No enabled endpoints available", http.StatusServiceUnavailable)
 			return
@@ -336,31 +420,54 @@ func (p *Proxy) handleProxy(w http.ResponseWriter, r *http.Request) {
 		}
 		lastEndpointName = endpoint.Name
 
+		// Route to a capable endpoint or degrade the request when features are unsupported
+		clientBody := bodyBytes
+		if len(features) > 0 {
+			endpoint, clientBody = p.negotiateCapabilities(clientFormat, endpoint, bodyBytes, features, routed)
+		}
+
+		// Keep the request within the context window of the endpoint's model
+		var guardActions []string
+		endpoint, clientBody, guardActions = p.guardContext(clientFormat, endpoint, clientBody, streamReq.Model, features, routed)
+		setContextGuardHeader(w.Header(), append(overflowActions, guardActions...))
+
 		endpointAttempts++
 		p.markRequestActive(endpoint.Name)
-		p.stats.RecordRequest(endpoint.Name)
+		labels := UsageLabels{ClientModel: streamReq.Model, UpstreamModel: upstreamModel(endpoint, streamReq.Model), ClientFormat: clientFormat}
 
 		trans, err := prepareTransformerForClient(clientFormat, endpoint)
 		if err != nil {
 			logger.Error("[%s] %v", endpoint.Name, err)
-			p.stats.RecordError(endpoint.Name)
+			p.stats.RecordRequest(endpoint.Name, labels)
+			p.stats.RecordError(endpoint.Name, labels)
 			p.markRequestInactive(endpoint.Name)
 			if endpointAttempts >= 2 {
-				p.rotateEndpoint()
+				rotate()
 				endpointAttempts = 0
 			}
 			continue
 		}
 
 		transformerName := trans.Name()
+		storeState := clientFormat == ClientFormatOpenAIResponses && p.responseStore != nil && emulatesResponseState(transformerName)
+		if storeState {
+			if missing := unresolvedPreviousResponse(clientBody); missing != "" {
+				p.markRequestInactive(endpoint.Name)
+				writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "previous_response_not_found",
+					fmt.Sprintf("Previous response with id '%s' not found.", missing))
+				return
+			}
+		}
 
-		transformedBody, err := trans.TransformRequest(bodyBytes)
+		requestBody := applyBodyRewrites(endpoint.Name, clientBody, endpoint.RewriteRules, config.RewritePhaseClient)
+		transformedBody, err := trans.TransformRequest(requestBody)
 		if err != nil {
 			logger.Error("[%s] Failed to transform request: %v", endpoint.Name, err)
-			p.stats.RecordError(endpoint.Name)
+			p.stats.RecordRequest(endpoint.Name, labels)
+			p.stats.RecordError(endpoint.Name, labels)
 			p.markRequestInactive(endpoint.Name)
 			if endpointAttempts >= 2 {
-				p.rotateEndpoint()
+				rotate()
 				endpointAttempts = 0
 			}
 			continue
@@ -374,55 +481,92 @@ func (p *Proxy) handleProxy(w http.ResponseWriter, r *http.Request) {
 			logger.Warn("[%s] Failed to clean tool calls: %v", endpoint.Name, err)
 			cleanedBody = transformedBody
 		}
-		transformedBody = cleanedBody
-
-		var thinkingEnabled bool
-		if strings.Contains(transformerName, "openai") {
-			var openaiReq map[string]interface{}
-			if err := json.Unmarshal(transformedBody, &openaiReq); err == nil {
-				if enable, ok := openaiReq["enable_thinking"].(bool); ok {
-					thinkingEnabled = enable
-				}
-			}
+		transformedBody = applyBodyRewrites(endpoint.Name, cleanedBody, endpoint.RewriteRules, config.RewritePhaseUpstream)
+
+		// Serve identical deterministic requests from the response cache; hits count as cache hits,
+		// not as upstream requests
+		cacheKey := p.responseCacheKey(r, clientFormat, endpoint, clientBody, transformedBody, streamReq.Model)
+		if cacheKey != "" && p.serveCachedResponse(w, r, cacheKey, endpoint, labels) {
+			p.markRequestInactive(endpoint.Name)
+			return
+		}
+		p.stats.RecordRequest(endpoint.Name, labels)
+		setResponseCacheHeader(w.Header(), cacheKey)
+		respWriter := w
+		var recorder *cacheRecorder
+		if cacheKey != "" {
+			recorder = newCacheRecorder(w)
+			respWriter = recorder
+		}
+		var stored *responseStoreRecorder
+		if storeState && shouldStoreResponse(originalBody) {
+			stored = newResponseStoreRecorder(respWriter)
+			respWriter = stored
+		}
+		// Check tool calls of weaker models; retrying holds the response back until it is checked
+		repair := p.toolRepairFor(transformerName, clientBody, streamReq.Stream, toolRetried)
+		var held *heldResponse
+		if repair != nil && repair.retry {
+			held = newHeldResponse(respWriter)
+			respWriter = held
 		}
 
+		thinkingEnabled := thinkingRequested && endpoint.Supports(config.CapThinking)
+
 		proxyReq, err := buildProxyRequest(r, endpoint, transformedBody, transformerName)
 		if err != nil {
 			logger.Error("[%s] Failed to create request: %v", endpoint.Name, err)
-			p.stats.RecordError(endpoint.Name)
+			p.stats.RecordError(endpoint.Name, labels)
 			p.markRequestInactive(endpoint.Name)
 			if endpointAttempts >= 2 {
-				p.rotateEndpoint()
+				rotate()
 				endpointAttempts = 0
 			}
 			continue
 		}
+		applyHeaderRewrites(proxyReq, endpoint.RewriteRules)
 
 		ctx := p.getEndpointContext(endpoint.Name)
 		resp, err := sendRequest(ctx, proxyReq, p.config)
 		if err != nil {
 			logger.Error("[%s] Request failed: %v", endpoint.Name, err)
-			p.stats.RecordError(endpoint.Name)
+			p.stats.RecordError(endpoint.Name, labels)
 			p.markRequestInactive(endpoint.Name)
 			if endpointAttempts >= 2 {
-				p.rotateEndpoint()
+				rotate()
 				endpointAttempts = 0
 			}
 			continue
 		}
 
 		contentType := resp.Header.Get("Content-Type")
-		isStreaming := contentType == "text/event-stream" || (streamReq.Stream && strings.Contains(contentType, "text/event-stream"))
+		isStreaming := contentType == "text/event-stream" || (streamReq.Stream && strings.Contains(contentType, "text/event-stream")) ||
+			(streamReq.Stream && strings.Contains(contentType, "application/x-ndjson"))
 
 		if resp.StatusCode == http.StatusOK && isStreaming {
-			inputTokens, outputTokens, outputText := p.handleStreamingResponse(w, resp, endpoint, trans, transformerName, thinkingEnabled, streamReq.Model, bodyBytes)
+			inputTokens, outputTokens, outputText := p.handleStreamingResponse(respWriter, resp, endpoint, trans, transformerName, thinkingEnabled, streamReq.Model, bodyBytes, repair)
 
 			// Fallback: estimate tokens when usage is 0
 			if inputTokens == 0 || outputTokens == 0 {
 				inputTokens, outputTokens = p.estimateTokens(bodyBytes, outputText, inputTokens, outputTokens, endpoint.Name)
 			}
 
-			p.stats.RecordTokens(endpoint.Name, inputTokens, outputTokens)
+			p.stats.RecordTokens(endpoint.Name, labels, inputTokens, outputTokens)
+			if held != nil {
+				if repair.failed {
+					logger.Warn("[%s] Invalid tool call arguments, retrying the request", endpoint.Name)
+					p.markRequestInactive(endpoint.Name)
+					toolRetried = true
+					continue
+				}
+				held.release()
+			}
+			if recorder != nil {
+				p.storeCachedResponse(recorder, cacheKey, endpoint, streamReq.Model, inputTokens, outputTokens)
+			}
+			if stored != nil {
+				p.storeResponse(stored, endpoint, originalBody, previousResponseID, streamReq.Model)
+			}
 			p.markRequestInactive(endpoint.Name)
 			if p.onEndpointSuccess != nil {
 				p.onEndpointSuccess(endpoint.Name)
@@ -432,9 +576,24 @@ func (p *Proxy) handleProxy(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if resp.StatusCode == http.StatusOK {
-			inputTokens, outputTokens, err := p.handleNonStreamingResponse(w, resp, endpoint, trans)
+			inputTokens, outputTokens, err := p.handleNonStreamingResponse(respWriter, resp, endpoint, trans, repair)
 			if err == nil {
-				p.stats.RecordTokens(endpoint.Name, inputTokens, outputTokens)
+				p.stats.RecordTokens(endpoint.Name, labels, inputTokens, outputTokens)
+				if held != nil {
+					if repair.failed {
+						logger.Warn("[%s] Invalid tool call arguments, retrying the request", endpoint.Name)
+						p.markRequestInactive(endpoint.Name)
+						toolRetried = true
+						continue
+					}
+					held.release()
+				}
+				if recorder != nil {
+					p.storeCachedResponse(recorder, cacheKey, endpoint, streamReq.Model, inputTokens, outputTokens)
+				}
+				if stored != nil {
+					p.storeResponse(stored, endpoint, originalBody, previousResponseID, streamReq.Model)
+				}
 				p.markRequestInactive(endpoint.Name)
 				if p.onEndpointSuccess != nil {
 					p.onEndpointSuccess(endpoint.Name)
@@ -458,10 +617,10 @@ func (p *Proxy) handleProxy(w http.ResponseWriter, r *http.Request) {
 			}
 			logger.Warn("[%s] Request failed %d: %s", endpoint.Name, resp.StatusCode, errMsg)
 			logger.DebugLog("[%s] Request failed %d: %s", endpoint.Name, resp.StatusCode, errMsg)
-			p.stats.RecordError(endpoint.Name)
+			p.stats.RecordError(endpoint.Name, labels)
 			p.markRequestInactive(endpoint.Name)
 			if endpointAttempts >= 2 {
-				p.rotateEndpoint()
+				rotate()
 				endpointAttempts = 0
 			}
 			continue
@@ -475,6 +634,16 @@ func (p *Proxy) handleProxy(w http.ResponseWriter, r *http.Request) {
 		}
 		resp.Body.Close()
 		p.markRequestInactive(endpoint.Name)
+		// An oversized request can only succeed on an endpoint with a larger window
+		if isContextLengthError(resp.StatusCode, respBody) {
+			if alt, ok := p.rerouteContextOverflow(clientFormat, endpoint, clientBody, streamReq.Model, features, routed); ok {
+				logger.Warn("[%s] Upstream rejected the request for its size, routing it to %s", endpoint.Name, alt.Name)
+				routed[alt.Name] = true
+				overflowEndpoint = &alt
+				overflowActions = append(overflowActions, fmt.Sprintf("rerouted %s->%s (upstream context length error)", endpoint.Name, alt.Name))
+				continue
+			}
+		}
 		// Log non-200 responses for debugging
 		if resp.StatusCode != http.StatusOK {
 			errMsg := string(respBody)
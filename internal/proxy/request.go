@@ -63,6 +63,15 @@ func prepareCCTransformer(endpoint config.Endpoint, endpointTransformer string)
 			return nil, fmt.Errorf("Gemini transformer requires model field")
 		}
 		return cc.NewGeminiTransformer(endpoint.Model), nil
+	case "azure":
+		return cc.NewAzureTransformer(endpoint.AzureDeploymentName()), nil
+	case "azure2":
+		return cc.NewAzure2Transformer(endpoint.AzureDeploymentName()), nil
+	case "ollama":
+		if endpoint.Model == "" {
+			return nil, fmt.Errorf("Ollama transformer requires model field")
+		}
+		return cc.NewOllamaTransformer(endpoint.Model), nil
 	default:
 		return nil, fmt.Errorf("unsupported endpoint transformer: %s", endpointTransformer)
 	}
@@ -92,6 +101,15 @@ func prepareCxChatTransformer(endpoint config.Endpoint, endpointTransformer stri
 			return nil, fmt.Errorf("Gemini transformer requires model field")
 		}
 		return chat.NewGeminiTransformer(endpoint.Model), nil
+	case "azure":
+		return chat.NewAzureTransformer(endpoint.AzureDeploymentName()), nil
+	case "azure2":
+		return chat.NewAzure2Transformer(endpoint.AzureDeploymentName()), nil
+	case "ollama":
+		if endpoint.Model == "" {
+			return nil, fmt.Errorf("Ollama transformer requires model field")
+		}
+		return chat.NewOllamaTransformer(endpoint.Model), nil
 	default:
 		return nil, fmt.Errorf("unsupported endpoint transformer for Codex Chat: %s", endpointTransformer)
 	}
@@ -121,6 +139,15 @@ func prepareCxRespTransformer(endpoint config.Endpoint, endpointTransformer stri
 			return nil, fmt.Errorf("Gemini transformer requires model field")
 		}
 		return responses.NewGeminiTransformer(endpoint.Model), nil
+	case "azure":
+		return responses.NewAzureTransformer(endpoint.AzureDeploymentName()), nil
+	case "azure2":
+		return responses.NewAzure2Transformer(endpoint.AzureDeploymentName()), nil
+	case "ollama":
+		if endpoint.Model == "" {
+			return nil, fmt.Errorf("Ollama transformer requires model field")
+		}
+		return responses.NewOllamaTransformer(endpoint.Model), nil
 	default:
 		return nil, fmt.Errorf("unsupported endpoint transformer for Codex Responses: %s", endpointTransformer)
 	}
@@ -144,6 +171,10 @@ func getTargetPath(originalPath string, endpoint config.Endpoint, transformedBod
 			return fmt.Sprintf("/v1beta/models/%s:streamGenerateContent", endpoint.Model)
 		}
 		return fmt.Sprintf("/v1beta/models/%s:generateContent", endpoint.Model)
+	case "cc_azure", "cx_chat_azure", "cx_resp_azure", "cc_azure2", "cx_chat_azure2", "cx_resp_azure2":
+		return endpoint.AzurePath()
+	case "cc_ollama", "cx_chat_ollama", "cx_resp_ollama":
+		return "/api/chat"
 	}
 	return originalPath
 }
@@ -155,7 +186,11 @@ func buildProxyRequest(r *http.Request, endpoint config.Endpoint, transformedBod
 		targetPath = r.URL.Path
 	}
 
-	normalizedAPIUrl := normalizeAPIUrl(endpoint.APIUrl)
+	apiUrl := endpoint.APIUrl
+	if endpoint.IsAzure() {
+		apiUrl = endpoint.AzureBaseURL()
+	}
+	normalizedAPIUrl := normalizeAPIUrl(apiUrl)
 	targetURL := fmt.Sprintf("%s%s", normalizedAPIUrl, targetPath)
 	if r.URL.RawQuery != "" {
 		targetURL += "?" + r.URL.RawQuery
@@ -188,6 +223,21 @@ func buildProxyRequest(r *http.Request, endpoint config.Endpoint, transformedBod
 		q.Set("key", endpoint.APIKey)
 		q.Set("alt", "sse")
 		proxyReq.URL.RawQuery = q.Encode()
+	case "cc_azure", "cx_chat_azure", "cx_resp_azure", "cc_azure2", "cx_chat_azure2", "cx_resp_azure2":
+		// Azure OpenAI uses api-key header and api-version query instead of Bearer auth
+		proxyReq.Header.Del("Authorization")
+		proxyReq.Header.Del("x-api-key")
+		proxyReq.Header.Set("api-key", endpoint.APIKey)
+		q := proxyReq.URL.Query()
+		q.Set("api-version", endpoint.AzureAPIVersionOrDefault())
+		proxyReq.URL.RawQuery = q.Encode()
+	case "cc_ollama", "cx_chat_ollama", "cx_resp_ollama":
+		// Local Ollama needs no auth; a key is only sent for reverse-proxied instances
+		proxyReq.Header.Del("Authorization")
+		proxyReq.Header.Del("x-api-key")
+		if endpoint.APIKey != "" {
+			proxyReq.Header.Set("Authorization", "Bearer "+endpoint.APIKey)
+		}
 	default:
 		// Claude endpoints
 		proxyReq.Header.Set("x-api-key", endpoint.APIKey)
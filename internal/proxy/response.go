@@ -8,10 +8,11 @@ import (
 	"github.com/lich0821/ccNexus/internal/config"
 	"github.com/lich0821/ccNexus/internal/logger"
 	"github.com/lich0821/ccNexus/internal/transformer"
+	"github.com/lich0821/ccNexus/internal/transformer/convert"
 )
 
 // handleNonStreamingResponse processes non-streaming responses
-func (p *Proxy) handleNonStreamingResponse(w http.ResponseWriter, resp *http.Response, endpoint config.Endpoint, trans transformer.Transformer) (int, int, error) {
+func (p *Proxy) handleNonStreamingResponse(w http.ResponseWriter, resp *http.Response, endpoint config.Endpoint, trans transformer.Transformer, repair *toolRepair) (int, int, error) {
 	var bodyBytes []byte
 	var err error
 
@@ -39,6 +40,14 @@ func (p *Proxy) handleNonStreamingResponse(w http.ResponseWriter, resp *http.Res
 		return 0, 0, err
 	}
 
+	if repair != nil {
+		if repaired, failed, err := convert.RepairToolUses(transformedResp, repair.schemas, repair.asText); err == nil {
+			transformedResp, repair.failed = repaired, failed
+		}
+	}
+
+	transformedResp = applyResponseRewrites(endpoint.Name, transformedResp, endpoint.RewriteRules)
+
 	logger.DebugLog("[%s] Transformed Response: %s", endpoint.Name, string(transformedResp))
 
 	// Extract token usage
@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+)
+
+// responseCacheHeader tells the client whether a response came from the response cache
+const responseCacheHeader = "X-CCNexus-Cache"
+
+// Request fields that vary between otherwise identical requests without affecting the response
+var cacheKeyIgnoredFields = []string{"metadata", "user"}
+
+// CachedResponse is a client-facing response kept by the response cache
+type CachedResponse struct {
+	Key          string
+	EndpointName string
+	Model        string
+	Status       int
+	Header       http.Header
+	Body         []byte
+	Chunks       []CachedChunk // Flushed pieces of a streamed body, nil for non-streaming responses
+	InputTokens  int
+	OutputTokens int
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+// CachedChunk is a flushed piece of a streamed body and when it was written
+type CachedChunk struct {
+	Offset time.Duration // Time since the response headers were written
+	Length int
+}
+
+// ResponseCacheStorage persists cached responses
+type ResponseCacheStorage interface {
+	GetCachedResponse(key string) (*CachedResponse, error)
+	PutCachedResponse(entry *CachedResponse, maxEntries int, maxBytes int64) error
+}
+
+// SetResponseCache sets the storage used by the response cache
+func (p *Proxy) SetResponseCache(store ResponseCacheStorage) {
+	p.responseCache = store
+}
+
+// responseCacheKey returns the cache key of a request, or an empty string when the request
+// must not be cached. Only deterministic requests are cached unless a rule allows sampling.
+func (p *Proxy) responseCacheKey(r *http.Request, clientFormat ClientFormat, endpoint config.Endpoint, clientBody, transformedBody []byte, requestModel string) string {
+	cfg := p.config.GetResponseCache()
+	if p.responseCache == nil || cfg == nil || !cfg.Enabled {
+		return ""
+	}
+	rule, ok := cfg.RuleFor(upstreamModel(endpoint, requestModel), r.URL.Path)
+	if !ok || !rule.Enabled {
+		return ""
+	}
+	if !rule.AllowSampling && !isDeterministicRequest(clientBody) {
+		return ""
+	}
+
+	normalized, err := normalizeCacheBody(transformedBody)
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00", clientFormat, r.URL.Path, endpoint.Name, endpoint.APIUrl, endpoint.Transformer, endpoint.Model)
+	h.Write(normalized)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isDeterministicRequest reports whether a request asks for greedy sampling (temperature 0)
+func isDeterministicRequest(body []byte) bool {
+	var req struct {
+		Temperature *float64 `json:"temperature"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return false
+	}
+	return req.Temperature != nil && *req.Temperature == 0
+}
+
+// normalizeCacheBody re-encodes a request with sorted keys and without per-caller fields
+func normalizeCacheBody(body []byte) ([]byte, error) {
+	doc, err := decodeJSONBody(body)
+	if err != nil {
+		return nil, err
+	}
+	if req, ok := doc.(map[string]interface{}); ok {
+		for _, field := range cacheKeyIgnoredFields {
+			delete(req, field)
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// serveCachedResponse replays a cached response for the key, reporting whether there was one
+func (p *Proxy) serveCachedResponse(w http.ResponseWriter, r *http.Request, key string, endpoint config.Endpoint, labels UsageLabels) bool {
+	entry, err := p.responseCache.GetCachedResponse(key)
+	if err != nil {
+		logger.Warn("[%s] Failed to read response cache: %v", endpoint.Name, err)
+		return false
+	}
+	if entry == nil {
+		return false
+	}
+
+	timed := p.config.GetResponseCache().ReplayOrDefault() == config.CacheReplayTimed
+	for k, values := range entry.Header {
+		w.Header()[k] = values
+	}
+	w.Header().Set(responseCacheHeader, "hit")
+	w.WriteHeader(entry.Status)
+
+	if len(entry.Chunks) == 0 {
+		w.Write(entry.Body)
+	} else {
+		flusher, _ := w.(http.Flusher)
+		start := time.Now()
+		pos := 0
+		for _, chunk := range entry.Chunks {
+			if timed {
+				if wait := chunk.Offset - time.Since(start); wait > 0 {
+					select {
+					case <-time.After(wait):
+					case <-r.Context().Done():
+						return true
+					}
+				}
+			}
+			end := pos + chunk.Length
+			if end > len(entry.Body) {
+				end = len(entry.Body)
+			}
+			if _, err := w.Write(entry.Body[pos:end]); err != nil {
+				logger.Debug("[%s] Client disconnected during cache replay: %v", endpoint.Name, err)
+				return true
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			pos = end
+		}
+	}
+
+	p.stats.RecordCacheHit(endpoint.Name, labels, entry.InputTokens+entry.OutputTokens)
+	logger.Debug("[%s] Served response from cache, saved %d tokens", endpoint.Name, entry.InputTokens+entry.OutputTokens)
+	return true
+}
+
+// storeCachedResponse saves a response captured by a cacheRecorder
+func (p *Proxy) storeCachedResponse(rec *cacheRecorder, key string, endpoint config.Endpoint, model string, inputTokens, outputTokens int) {
+	cfg := p.config.GetResponseCache()
+	if cfg == nil || rec.failed || rec.status != http.StatusOK || rec.body.Len() == 0 {
+		return
+	}
+	if !p.isCurrentEndpoint(endpoint.Name) {
+		return // a switched endpoint cuts streams short
+	}
+
+	header := make(http.Header)
+	for k, values := range rec.header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(k), "X-Ccnexus-") || k == "Date" || k == "Set-Cookie" {
+			continue
+		}
+		header[k] = values
+	}
+
+	now := time.Now()
+	entry := &CachedResponse{
+		Key:          key,
+		EndpointName: endpoint.Name,
+		Model:        upstreamModel(endpoint, model),
+		Status:       rec.status,
+		Header:       header,
+		Body:         rec.body.Bytes(),
+		Chunks:       rec.finish(),
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(time.Duration(cfg.TTLOrDefault()) * time.Second),
+	}
+	if err := p.responseCache.PutCachedResponse(entry, cfg.MaxEntriesOrDefault(), cfg.MaxBytesOrDefault()); err != nil {
+		logger.Warn("[%s] Failed to store response in cache: %v", endpoint.Name, err)
+		return
+	}
+	logger.Debug("[%s] Stored response in cache (%d bytes)", endpoint.Name, len(entry.Body))
+}
+
+// setResponseCacheHeader reports a cache miss for cacheable requests, clearing the header otherwise
+func setResponseCacheHeader(header http.Header, key string) {
+	if key == "" {
+		header.Del(responseCacheHeader)
+		return
+	}
+	header.Set(responseCacheHeader, "miss")
+}
+
+// cacheRecorder passes a response through to the client while capturing it for the cache,
+// noting when each flushed piece of a stream was written
+type cacheRecorder struct {
+	http.ResponseWriter
+	status  int
+	header  http.Header
+	body    bytes.Buffer
+	chunks  []CachedChunk
+	flushed int
+	start   time.Time
+	failed  bool
+}
+
+func newCacheRecorder(w http.ResponseWriter) *cacheRecorder {
+	return &cacheRecorder{ResponseWriter: w}
+}
+
+func (c *cacheRecorder) WriteHeader(status int) {
+	c.status = status
+	c.header = c.ResponseWriter.Header().Clone()
+	c.start = time.Now()
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *cacheRecorder) Write(data []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	n, err := c.ResponseWriter.Write(data)
+	c.body.Write(data[:n])
+	if err != nil {
+		c.failed = true
+	}
+	return n, err
+}
+
+// Flush marks the end of a streamed piece
+func (c *cacheRecorder) Flush() {
+	if pending := c.body.Len() - c.flushed; pending > 0 {
+		c.chunks = append(c.chunks, CachedChunk{Offset: time.Since(c.start), Length: pending})
+		c.flushed = c.body.Len()
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// finish returns the recorded chunks, including any unflushed tail; nil when nothing was flushed
+func (c *cacheRecorder) finish() []CachedChunk {
+	if len(c.chunks) == 0 {
+		return nil
+	}
+	if pending := c.body.Len() - c.flushed; pending > 0 {
+		c.chunks = append(c.chunks, CachedChunk{Offset: time.Since(c.start), Length: pending})
+		c.flushed = c.body.Len()
+	}
+	return c.chunks
+}
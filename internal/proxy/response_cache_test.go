@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/lich0821/ccNexus/internal/config"
+)
+
+// memoryResponseCache keeps cached responses in a map
+type memoryResponseCache struct {
+	mu      sync.Mutex
+	entries map[string]*CachedResponse
+}
+
+func (c *memoryResponseCache) GetCachedResponse(key string) (*CachedResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[key], nil
+}
+
+func (c *memoryResponseCache) PutCachedResponse(entry *CachedResponse, maxEntries int, maxBytes int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[entry.Key] = entry
+	return nil
+}
+
+// recordingStatsStorage keeps every recorded stat
+type recordingStatsStorage struct {
+	memoryStatsStorage
+	mu    sync.Mutex
+	stats []StatRecord
+}
+
+func (s *recordingStatsStorage) RecordDailyStat(stat interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats = append(s.stats, *stat.(*StatRecord))
+	return nil
+}
+
+func (s *recordingStatsStorage) totals() (requests, errors, cacheHits, savedTokens int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stat := range s.stats {
+		requests += stat.Requests
+		errors += stat.Errors
+		cacheHits += stat.CacheHits
+		savedTokens += stat.SavedTokens
+	}
+	return
+}
+
+func TestCacheHitsAreNotCountedAsRequests(t *testing.T) {
+	upstreamHits := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "msg_1", "type": "message", "role": "assistant", "model": "claude-test",
+			"content": [{"type": "text", "text": "Hello"}], "stop_reason": "end_turn",
+			"usage": {"input_tokens": 10, "output_tokens": 5}}`))
+	}))
+	defer upstream.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.UpdateEndpoints([]config.Endpoint{{Name: "test", APIUrl: upstream.URL, APIKey: "k", Enabled: true, Transformer: "claude"}})
+	cfg.UpdateResponseCache(&config.ResponseCacheConfig{Enabled: true})
+	stats := &recordingStatsStorage{}
+	p := New(cfg, stats, "test")
+	p.SetResponseCache(&memoryResponseCache{entries: make(map[string]*CachedResponse)})
+
+	body := `{"model": "claude-test", "max_tokens": 100, "temperature": 0, "messages": [{"role": "user", "content": "Hi"}]}`
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		p.handleProxy(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	if upstreamHits != 1 {
+		t.Fatalf("Expected one upstream request, got %d", upstreamHits)
+	}
+	requests, errors, cacheHits, savedTokens := stats.totals()
+	if requests != 1 || errors != 0 || cacheHits != 2 || savedTokens != 30 {
+		t.Errorf("requests=%d errors=%d cacheHits=%d savedTokens=%d, want 1, 0, 2, 30", requests, errors, cacheHits, savedTokens)
+	}
+}
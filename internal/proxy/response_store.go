@@ -0,0 +1,449 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+)
+
+const (
+	responseStoreRetention = 30 * 24 * time.Hour
+	maxResponseChainDepth  = 1000 // Guards previous_response_id chains against cycles
+	responsesPath          = "/v1/responses"
+)
+
+// StoredResponse is a Responses API response kept locally for upstreams without Responses state
+type StoredResponse struct {
+	ID           string
+	PreviousID   string // previous_response_id of the request, empty for the first turn
+	EndpointName string
+	Model        string
+	Input        string // JSON array of the request's own input items
+	Output       string // JSON array of output items
+	Response     string // JSON response object as returned to the client
+	CreatedAt    time.Time
+}
+
+// ResponseStore persists Responses API responses
+type ResponseStore interface {
+	GetStoredResponse(id string) (*StoredResponse, error)
+	PutStoredResponse(r *StoredResponse, cutoff time.Time) error
+	DeleteStoredResponse(id string) (bool, error)
+}
+
+// SetResponseStore sets the storage for Responses API state
+func (p *Proxy) SetResponseStore(store ResponseStore) {
+	p.responseStore = store
+}
+
+// emulatesResponseState reports whether a transformer sends Responses requests to an upstream
+// that keeps no conversation state, so previous_response_id and store are handled locally
+func emulatesResponseState(transformerName string) bool {
+	switch transformerName {
+	case "cx_resp_claude", "cx_resp_gemini", "cx_resp_openai", "cx_resp_azure", "cx_resp_ollama":
+		return true
+	}
+	return false
+}
+
+// isResponsesEndpoint reports whether an endpoint serves the Responses API natively
+func isResponsesEndpoint(endpoint config.Endpoint) bool {
+	return endpoint.Transformer == "openai2" || endpoint.Transformer == "azure2"
+}
+
+// expandPreviousResponse replaces a locally stored previous_response_id with the conversation it
+// stands for, returning the new body and the ID. IDs the store does not know are left in place.
+func (p *Proxy) expandPreviousResponse(body []byte) ([]byte, string, error) {
+	var req map[string]interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return body, "", nil
+	}
+	previousID, _ := req["previous_response_id"].(string)
+	if previousID == "" {
+		return body, "", nil
+	}
+
+	var chain []*StoredResponse
+	seen := make(map[string]bool)
+	for id := previousID; id != "" && len(chain) < maxResponseChainDepth && !seen[id]; {
+		seen[id] = true
+		stored, err := p.responseStore.GetStoredResponse(id)
+		if err != nil {
+			return nil, "", err
+		}
+		if stored == nil {
+			if id == previousID {
+				return body, "", nil
+			}
+			logger.Warn("Response %s in the history of %s is no longer stored", id, previousID)
+			break
+		}
+		chain = append(chain, stored)
+		id = stored.PreviousID
+	}
+
+	var history []interface{}
+	for i := len(chain) - 1; i >= 0; i-- {
+		var input, output []interface{}
+		json.Unmarshal([]byte(chain[i].Input), &input)
+		json.Unmarshal([]byte(chain[i].Output), &output)
+		history = append(history, input...)
+		history = append(history, output...)
+	}
+	req["input"] = append(history, responsesInputItems(req["input"])...)
+	delete(req, "previous_response_id")
+
+	expanded, err := json.Marshal(req)
+	if err != nil {
+		return nil, "", err
+	}
+	logger.Debug("Expanded previous_response_id %s into %d history items", previousID, len(history))
+	return expanded, previousID, nil
+}
+
+// responsesInputItems returns the input of a Responses request as a list of items
+func responsesInputItems(input interface{}) []interface{} {
+	switch v := input.(type) {
+	case string:
+		return []interface{}{map[string]interface{}{
+			"type":    "message",
+			"role":    "user",
+			"content": []interface{}{map[string]interface{}{"type": "input_text", "text": v}},
+		}}
+	case []interface{}:
+		return v
+	}
+	return nil
+}
+
+// unresolvedPreviousResponse returns the previous_response_id left in a request, if any
+func unresolvedPreviousResponse(body []byte) string {
+	var req struct {
+		PreviousResponseID string `json:"previous_response_id"`
+	}
+	json.Unmarshal(body, &req)
+	return req.PreviousResponseID
+}
+
+// shouldStoreResponse reports whether the client asked to keep the response; the Responses API
+// stores responses unless store is false
+func shouldStoreResponse(body []byte) bool {
+	var req struct {
+		Store *bool `json:"store"`
+	}
+	json.Unmarshal(body, &req)
+	return req.Store == nil || *req.Store
+}
+
+// newResponseID returns a new ID for a locally stored response
+func newResponseID() string {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return "resp_" + hex.EncodeToString(buf)
+}
+
+// storeResponse saves a response captured by a responseStoreRecorder. requestBody is the
+// request as sent by the client, before its history was expanded.
+func (p *Proxy) storeResponse(rec *responseStoreRecorder, endpoint config.Endpoint, requestBody []byte, previousID, model string) {
+	if rec.failed || rec.status != http.StatusOK || rec.response == nil {
+		return
+	}
+	if !p.isCurrentEndpoint(endpoint.Name) {
+		return // a switched endpoint cuts streams short
+	}
+
+	var req struct {
+		Input interface{} `json:"input"`
+	}
+	json.Unmarshal(requestBody, &req)
+	input, _ := json.Marshal(responsesInputItems(req.Input))
+
+	now := time.Now()
+	resp := rec.response
+	output := rec.output()
+	resp["output"] = output
+	resp["object"] = "response"
+	if _, ok := resp["created_at"]; !ok {
+		resp["created_at"] = now.Unix()
+	}
+	if m, _ := resp["model"].(string); m == "" {
+		resp["model"] = model
+	}
+	if previousID != "" {
+		resp["previous_response_id"] = previousID
+	} else {
+		resp["previous_response_id"] = nil
+	}
+	outputJSON, _ := json.Marshal(output)
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+
+	stored := &StoredResponse{
+		ID:           rec.id,
+		PreviousID:   previousID,
+		EndpointName: endpoint.Name,
+		Model:        model,
+		Input:        string(input),
+		Output:       string(outputJSON),
+		Response:     string(respJSON),
+		CreatedAt:    now,
+	}
+	if err := p.responseStore.PutStoredResponse(stored, now.Add(-responseStoreRetention)); err != nil {
+		logger.Warn("[%s] Failed to store response %s: %v", endpoint.Name, rec.id, err)
+		return
+	}
+	logger.Debug("[%s] Stored response %s", endpoint.Name, rec.id)
+}
+
+// handleStoredResponse serves GET and DELETE /v1/responses/{id} from the local store. Other
+// requests, and responses the store does not know, go to Responses-native endpoints as before.
+func (p *Proxy) handleStoredResponse(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, responsesPath+"/")
+	if p.responseStore == nil || id == "" || strings.Contains(id, "/") ||
+		(r.Method != http.MethodGet && r.Method != http.MethodDelete) {
+		p.handleProxy(w, r)
+		return
+	}
+
+	stored, err := p.responseStore.GetStoredResponse(id)
+	if err != nil {
+		logger.Error("Failed to load response %s: %v", id, err)
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "", "Failed to load response")
+		return
+	}
+	if stored == nil {
+		if isResponsesEndpoint(p.getCurrentEndpoint()) {
+			p.handleProxy(w, r)
+			return
+		}
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "", fmt.Sprintf("Response with id '%s' not found.", id))
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if _, err := p.responseStore.DeleteStoredResponse(id); err != nil {
+			writeOpenAIError(w, http.StatusInternalServerError, "server_error", "", "Failed to delete response")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "object": "response", "deleted": true})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(stored.Response))
+}
+
+// writeOpenAIError writes an error in the OpenAI API format
+func writeOpenAIError(w http.ResponseWriter, status int, errType, code, message string) {
+	var errCode interface{}
+	if code != "" {
+		errCode = code
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{"message": message, "type": errType, "param": nil, "code": errCode},
+	})
+}
+
+// responseStoreRecorder passes a Responses API response through to the client under a locally
+// assigned ID, collecting the response object and its output items for the store
+type responseStoreRecorder struct {
+	http.ResponseWriter
+	id        string
+	status    int
+	streaming bool
+	failed    bool
+	response  map[string]interface{}
+	items     map[int]map[string]interface{} // Streamed output items by output_index
+	texts     map[int]*strings.Builder       // Streamed output text by output_index
+	arguments map[int]*strings.Builder       // Streamed function call arguments by output_index
+	reasoning map[int]*strings.Builder       // Streamed reasoning text by output_index
+}
+
+func newResponseStoreRecorder(w http.ResponseWriter) *responseStoreRecorder {
+	return &responseStoreRecorder{
+		ResponseWriter: w,
+		id:             newResponseID(),
+		items:          make(map[int]map[string]interface{}),
+		texts:          make(map[int]*strings.Builder),
+		arguments:      make(map[int]*strings.Builder),
+		reasoning:      make(map[int]*strings.Builder),
+	}
+}
+
+func (r *responseStoreRecorder) WriteHeader(status int) {
+	r.status = status
+	r.streaming = strings.Contains(r.ResponseWriter.Header().Get("Content-Type"), "text/event-stream")
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseStoreRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	out := data
+	if r.status == http.StatusOK {
+		if r.streaming {
+			out = r.rewriteEvents(data)
+		} else {
+			out = r.rewriteResponse(data)
+		}
+	}
+	if _, err := r.ResponseWriter.Write(out); err != nil {
+		r.failed = true
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (r *responseStoreRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// rewriteResponse gives a non-streaming response object the local ID
+func (r *responseStoreRecorder) rewriteResponse(data []byte) []byte {
+	var resp map[string]interface{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return data
+	}
+	resp["id"] = r.id
+	r.response = resp
+	out, err := json.Marshal(resp)
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+// rewriteEvents gives the response objects of SSE events the local ID and records the output
+func (r *responseStoreRecorder) rewriteEvents(data []byte) []byte {
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		payload := strings.TrimPrefix(line, "data: ")
+		if payload == line || !strings.HasPrefix(payload, "{") {
+			continue
+		}
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			continue
+		}
+		r.observeEvent(event)
+		if resp, ok := event["response"].(map[string]interface{}); ok {
+			resp["id"] = r.id
+			if out, err := json.Marshal(event); err == nil {
+				lines[i] = "data: " + string(out)
+			}
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// observeEvent records the response and output items carried by a streaming event
+func (r *responseStoreRecorder) observeEvent(event map[string]interface{}) {
+	eventType, _ := event["type"].(string)
+	idx, _ := event["output_index"].(float64)
+	index := int(idx)
+
+	switch eventType {
+	case "response.output_item.added":
+		item, ok := event["item"].(map[string]interface{})
+		if !ok {
+			return
+		}
+		if r.items[index] == nil {
+			r.items[index] = make(map[string]interface{})
+		}
+		for k, v := range item {
+			r.items[index][k] = v
+		}
+	case "response.output_item.done":
+		// The finished item is complete, except for what converted streams only sent in deltas
+		item, ok := event["item"].(map[string]interface{})
+		if !ok {
+			return
+		}
+		for k, v := range r.items[index] {
+			if _, ok := item[k]; !ok {
+				item[k] = v
+			}
+		}
+		r.items[index] = item
+	case "response.output_text.delta":
+		appendDelta(r.texts, index, event["delta"])
+	case "response.output_text.done":
+		if text, ok := event["text"].(string); ok && text != "" {
+			r.texts[index] = &strings.Builder{}
+			r.texts[index].WriteString(text)
+		}
+	case "response.function_call_arguments.delta":
+		appendDelta(r.arguments, index, event["delta"])
+	case "response.function_call_arguments.done":
+		if arguments, ok := event["arguments"].(string); ok {
+			r.arguments[index] = &strings.Builder{}
+			r.arguments[index].WriteString(arguments)
+		}
+	case "response.reasoning_summary_text.delta", "response.reasoning_text.delta":
+		appendDelta(r.reasoning, index, event["delta"])
+	default:
+		if resp, ok := event["response"].(map[string]interface{}); ok {
+			r.response = resp
+		}
+	}
+}
+
+// appendDelta adds a streamed text delta to the text collected for an output index
+func appendDelta(texts map[int]*strings.Builder, index int, delta interface{}) {
+	text, _ := delta.(string)
+	if texts[index] == nil {
+		texts[index] = &strings.Builder{}
+	}
+	texts[index].WriteString(text)
+}
+
+// output returns the output items of the response. Streams are rebuilt from their events, since
+// converted streams only carry the output in deltas.
+func (r *responseStoreRecorder) output() []interface{} {
+	if output, ok := r.response["output"].([]interface{}); ok && len(output) > 0 {
+		return output
+	}
+
+	indexes := make([]int, 0, len(r.items))
+	for index := range r.items {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	output := make([]interface{}, 0, len(indexes))
+	for _, index := range indexes {
+		item := r.items[index]
+		switch item["type"] {
+		case "message":
+			if text, ok := r.texts[index]; ok {
+				item["content"] = []interface{}{map[string]interface{}{"type": "output_text", "text": text.String(), "annotations": []interface{}{}}}
+			}
+		case "function_call":
+			if arguments, _ := item["arguments"].(string); arguments == "" && r.arguments[index] != nil {
+				item["arguments"] = r.arguments[index].String()
+			}
+		case "reasoning":
+			if summary, _ := item["summary"].([]interface{}); len(summary) == 0 && r.reasoning[index] != nil {
+				item["summary"] = []interface{}{map[string]interface{}{"type": "summary_text", "text": r.reasoning[index].String()}}
+			}
+		}
+		item["status"] = "completed"
+		output = append(output, item)
+	}
+	return output
+}
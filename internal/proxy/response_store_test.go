@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/config"
+)
+
+// memoryResponseStore keeps stored responses in a map
+type memoryResponseStore struct {
+	mu        sync.Mutex
+	responses map[string]*StoredResponse
+}
+
+func (s *memoryResponseStore) GetStoredResponse(id string) (*StoredResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.responses[id], nil
+}
+
+func (s *memoryResponseStore) PutStoredResponse(r *StoredResponse, cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[r.ID] = r
+	return nil
+}
+
+func (s *memoryResponseStore) DeleteStoredResponse(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.responses[id]
+	delete(s.responses, id)
+	return ok, nil
+}
+
+// claudeToolUpstream answers the first request with a streamed tool call and later ones with text,
+// recording every request body
+func claudeToolUpstream(requests *[]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		*requests = append(*requests, req)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		send := func(event string, data string) {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+			w.(http.Flusher).Flush()
+		}
+		send("message_start", `{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-test","content":[],"usage":{"input_tokens":5,"output_tokens":0}}}`)
+		if len(*requests) == 1 {
+			send("content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`)
+			send("content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`)
+			send("content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`)
+			send("content_block_stop", `{"type":"content_block_stop","index":0}`)
+			send("message_delta", `{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":4}}`)
+		} else {
+			send("content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`)
+			send("content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Sunny"}}`)
+			send("content_block_stop", `{"type":"content_block_stop","index":0}`)
+			send("message_delta", `{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":1}}`)
+		}
+		send("message_stop", `{"type":"message_stop"}`)
+	}))
+}
+
+func newResponseStoreTestProxy(upstreamURL string) (*Proxy, *memoryResponseStore) {
+	p := newTestProxy(config.Endpoint{Name: "test", APIUrl: upstreamURL, APIKey: "k", Enabled: true, Transformer: "claude", Model: "claude-test"})
+	store := &memoryResponseStore{responses: make(map[string]*StoredResponse)}
+	p.SetResponseStore(store)
+	return p, store
+}
+
+// completedResponseID returns the response ID of the response.completed event of a stream
+func completedResponseID(t *testing.T, stream string) string {
+	t.Helper()
+	for _, line := range strings.Split(stream, "\n") {
+		payload := strings.TrimPrefix(line, "data: ")
+		var event struct {
+			Type     string `json:"type"`
+			Response struct {
+				ID string `json:"id"`
+			} `json:"response"`
+		}
+		if json.Unmarshal([]byte(payload), &event) == nil && event.Type == "response.completed" {
+			return event.Response.ID
+		}
+	}
+	t.Fatalf("No response.completed event in %s", stream)
+	return ""
+}
+
+func postResponses(p *Proxy, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	p.handleProxy(rec, httptest.NewRequest(http.MethodPost, responsesPath, bytes.NewBufferString(body)))
+	return rec
+}
+
+func TestResponseStoreChainsToolCalls(t *testing.T) {
+	var requests []map[string]interface{}
+	upstream := claudeToolUpstream(&requests)
+	defer upstream.Close()
+	p, store := newResponseStoreTestProxy(upstream.URL)
+
+	rec := postResponses(p, `{"model": "gpt-5", "stream": true, "input": "Weather in Paris?"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	firstID := completedResponseID(t, rec.Body.String())
+	stored := store.responses[firstID]
+	if stored == nil {
+		t.Fatalf("Response %s was not stored", firstID)
+	}
+	var output []map[string]interface{}
+	json.Unmarshal([]byte(stored.Output), &output)
+	if len(output) != 1 || output[0]["type"] != "function_call" || output[0]["call_id"] != "toolu_1" || output[0]["arguments"] != `{"city":"Paris"}` {
+		t.Fatalf("Expected the function call to be stored, got %s", stored.Output)
+	}
+
+	// The next turn only sends the tool output; the proxy replays the stored conversation
+	rec = postResponses(p, `{"model": "gpt-5", "stream": true, "previous_response_id": "`+firstID+`",
+		"input": [{"type": "function_call_output", "call_id": "toolu_1", "output": "Sunny"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	secondID := completedResponseID(t, rec.Body.String())
+	if store.responses[secondID] == nil || store.responses[secondID].PreviousID != firstID {
+		t.Fatalf("Expected %s to be stored after %s", secondID, firstID)
+	}
+
+	messages, _ := json.Marshal(requests[1]["messages"])
+	for _, want := range []string{`"content":"Weather in Paris?"`, `"type":"tool_use"`, `"id":"toolu_1"`, `"city":"Paris"`, `"type":"tool_result"`, `"tool_use_id":"toolu_1"`} {
+		if !strings.Contains(string(messages), want) {
+			t.Errorf("Expected %s in the replayed conversation, got %s", want, messages)
+		}
+	}
+
+	// A third turn replays both earlier turns
+	postResponses(p, `{"model": "gpt-5", "stream": true, "previous_response_id": "`+secondID+`", "input": "Thanks"}`)
+	messages, _ = json.Marshal(requests[2]["messages"])
+	for _, want := range []string{`"tool_use_id":"toolu_1"`, `{"content":"Sunny","role":"assistant"}`, `{"content":"Thanks","role":"user"}`} {
+		if !strings.Contains(string(messages), want) {
+			t.Errorf("Expected %s in the replayed conversation, got %s", want, messages)
+		}
+	}
+}
+
+func TestStoredResponseRetrieveAndDelete(t *testing.T) {
+	var requests []map[string]interface{}
+	upstream := claudeToolUpstream(&requests)
+	defer upstream.Close()
+	p, store := newResponseStoreTestProxy(upstream.URL)
+
+	id := completedResponseID(t, postResponses(p, `{"model": "gpt-5", "stream": true, "input": "Hi"}`).Body.String())
+	// store: false keeps nothing
+	postResponses(p, `{"model": "gpt-5", "stream": true, "store": false, "input": "Hi"}`)
+	if len(store.responses) != 1 {
+		t.Errorf("Expected only the first response to be stored, got %d", len(store.responses))
+	}
+
+	rec := httptest.NewRecorder()
+	p.handleStoredResponse(rec, httptest.NewRequest(http.MethodGet, responsesPath+"/"+id, nil))
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || resp["id"] != id || resp["object"] != "response" || resp["previous_response_id"] != nil {
+		t.Errorf("GET stored response: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	p.handleStoredResponse(rec, httptest.NewRequest(http.MethodDelete, responsesPath+"/"+id, nil))
+	if rec.Code != http.StatusOK || store.responses[id] != nil {
+		t.Errorf("DELETE stored response: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	p.handleStoredResponse(rec, httptest.NewRequest(http.MethodGet, responsesPath+"/"+id, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET deleted response: status = %d", rec.Code)
+	}
+
+	// A previous response the store does not know cannot be replayed
+	rec = postResponses(p, `{"model": "gpt-5", "previous_response_id": "`+id+`", "input": "Hi"}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "previous_response_not_found") {
+		t.Errorf("Unknown previous response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestResponseStoreRecorderFoldsStreamedItems(t *testing.T) {
+	rec := newResponseStoreRecorder(httptest.NewRecorder())
+	for _, event := range []string{
+		`{"type":"response.output_item.added","output_index":0,"item":{"type":"reasoning","id":"rs_1","summary":[]}}`,
+		`{"type":"response.reasoning_summary_text.delta","output_index":0,"delta":"Think "}`,
+		`{"type":"response.reasoning_summary_text.delta","output_index":0,"delta":"first"}`,
+		`{"type":"response.output_item.done","output_index":0,"item":{"type":"reasoning","id":"rs_1"}}`,
+		`{"type":"response.output_item.added","output_index":1,"item":{"type":"message","role":"assistant","content":[]}}`,
+		`{"type":"response.output_text.delta","output_index":1,"delta":"Let me check"}`,
+		`{"type":"response.output_item.done","output_index":1,"item":{"type":"message","role":"assistant","status":"completed"}}`,
+		`{"type":"response.output_item.added","output_index":2,"item":{"type":"function_call","call_id":"call_1","name":"a","arguments":""}}`,
+		`{"type":"response.function_call_arguments.delta","output_index":2,"delta":"{\"x\":"}`,
+		`{"type":"response.function_call_arguments.delta","output_index":2,"delta":"1}"}`,
+		`{"type":"response.output_item.added","output_index":3,"item":{"type":"function_call","call_id":"call_2","name":"b","arguments":""}}`,
+		`{"type":"response.function_call_arguments.done","output_index":3,"arguments":"{\"y\":2}"}`,
+		`{"type":"response.completed","response":{"id":"resp_up","status":"completed"}}`,
+	} {
+		var e map[string]interface{}
+		json.Unmarshal([]byte(event), &e)
+		rec.observeEvent(e)
+	}
+
+	output, _ := json.Marshal(rec.output())
+	for _, want := range []string{
+		`{"id":"rs_1","status":"completed","summary":[{"text":"Think first","type":"summary_text"}],"type":"reasoning"}`,
+		`"content":[{"annotations":[],"text":"Let me check","type":"output_text"}]`,
+		`"arguments":"{\"x\":1}","call_id":"call_1"`,
+		`"arguments":"{\"y\":2}","call_id":"call_2"`,
+	} {
+		if !strings.Contains(string(output), want) {
+			t.Errorf("Expected %s in %s", want, output)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/lich0821/ccNexus/internal/logger"
+)
+
+const (
+	wsResumeWindow   = 5 * time.Minute // How long a disconnected session can be resumed
+	wsSessionBuffer  = 4096            // Events kept per session for replay on resume
+	wsPingInterval   = 30 * time.Second
+	wsPongTimeout    = 90 * time.Second
+	wsMaxFrameSize   = 32 << 20
+	wsEventIDPrefix  = "evt_"
+	wsSessionPrefix  = "sess_"
+	wsResponseCreate = "response.create"
+)
+
+// Request headers that belong to the websocket handshake rather than to the proxied requests
+var wsHandshakeHeaders = []string{"Connection", "Upgrade", "Sec-Websocket-Key", "Sec-Websocket-Version", "Sec-Websocket-Extensions", "Sec-Websocket-Protocol"}
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  64 * 1024,
+	WriteBufferSize: 64 * 1024,
+}
+
+// wsSession is a Responses websocket session. It outlives its connection for wsResumeWindow,
+// so a client can reconnect and pick up the events it missed.
+type wsSession struct {
+	id         string
+	mu         sync.Mutex
+	conn       *websocket.Conn
+	header     http.Header // Headers of the handshake, sent with every proxied request
+	seq        int
+	events     [][]byte // Recent events; events[i] has sequence number firstSeq+i
+	firstSeq   int
+	busy       bool // A response is being generated
+	detachedAt time.Time
+}
+
+// wsSessionStore keeps the sessions that are connected or can still be resumed
+type wsSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*wsSession
+}
+
+func newWSSessionStore() *wsSessionStore {
+	return &wsSessionStore{sessions: make(map[string]*wsSession)}
+}
+
+func (s *wsSessionStore) create(header http.Header) *wsSession {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	session := &wsSession{id: wsSessionPrefix + hex.EncodeToString(buf), header: header, firstSeq: 1}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session.id] = session
+	return session
+}
+
+func (s *wsSessionStore) get(id string) *wsSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id]
+}
+
+// expireLater drops a session once it has been idle and disconnected for the resume window
+func (s *wsSessionStore) expireLater(session *wsSession) {
+	time.AfterFunc(wsResumeWindow, func() {
+		session.mu.Lock()
+		expired := session.conn == nil && !session.busy && time.Since(session.detachedAt) >= wsResumeWindow
+		session.mu.Unlock()
+		if expired {
+			s.mu.Lock()
+			delete(s.sessions, session.id)
+			s.mu.Unlock()
+			logger.Debug("Responses websocket session %s expired", session.id)
+		}
+	})
+}
+
+// handleResponses serves /v1/responses over HTTP, or over a websocket when the client asks for one
+func (p *Proxy) handleResponses(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		p.handleResponsesWebSocket(w, r)
+		return
+	}
+	p.handleProxy(w, r)
+}
+
+// handleResponsesWebSocket runs a websocket connection. A new connection starts a session; one
+// with session_id (and optionally last_event_id) resumes a session and replays the missed events.
+func (p *Proxy) handleResponsesWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Warn("Responses websocket upgrade failed: %v", err)
+		return
+	}
+	conn.SetReadLimit(wsMaxFrameSize)
+
+	query := r.URL.Query()
+	var session *wsSession
+	if id := query.Get("session_id"); id != "" {
+		session = p.wsSessions.get(id)
+		if session == nil {
+			writeWSError(conn, "session_not_found", fmt.Sprintf("Session %s not found or expired", id))
+			conn.Close()
+			return
+		}
+		lastSeq, _ := strconv.Atoi(strings.TrimPrefix(query.Get("last_event_id"), wsEventIDPrefix))
+		if err := session.resume(conn, lastSeq); err != nil {
+			writeWSError(conn, "session_resume_failed", err.Error())
+			conn.Close()
+			return
+		}
+		logger.Debug("Responses websocket session %s resumed after event %d", session.id, lastSeq)
+	} else {
+		header := r.Header.Clone()
+		for _, name := range wsHandshakeHeaders {
+			header.Del(name)
+		}
+		session = p.wsSessions.create(header)
+		session.attach(conn, map[string]interface{}{
+			"type":    "session.created",
+			"session": map[string]interface{}{"id": session.id},
+		})
+		logger.Debug("Responses websocket session %s started", session.id)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		p.handleWSFrame(session, data)
+	}
+
+	session.detach(conn)
+	p.wsSessions.expireLater(session)
+	conn.Close()
+}
+
+// handleWSFrame handles a client event
+func (p *Proxy) handleWSFrame(session *wsSession, data []byte) {
+	var frame map[string]interface{}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		session.emitError("invalid_request_error", "Frame is not a JSON object")
+		return
+	}
+
+	eventType, _ := frame["type"].(string)
+	if eventType != wsResponseCreate {
+		session.emitError("invalid_request_error", fmt.Sprintf("Unsupported event type: %s", eventType))
+		return
+	}
+
+	// The request is either nested under "response" or sent inline next to "type"
+	req, ok := frame["response"].(map[string]interface{})
+	if !ok {
+		req = frame
+		delete(req, "type")
+		delete(req, "event_id")
+	}
+	req["stream"] = true
+	body, err := json.Marshal(req)
+	if err != nil {
+		session.emitError("invalid_request_error", err.Error())
+		return
+	}
+
+	session.mu.Lock()
+	if session.busy {
+		session.mu.Unlock()
+		session.emitError("invalid_request_error", "A response is already in progress in this session")
+		return
+	}
+	session.busy = true
+	session.mu.Unlock()
+
+	go p.runWSResponse(session, body)
+}
+
+// runWSResponse sends a response.create through the HTTP pipeline and emits its events
+func (p *Proxy) runWSResponse(session *wsSession, body []byte) {
+	defer func() {
+		session.mu.Lock()
+		session.busy = false
+		disconnected := session.conn == nil
+		session.mu.Unlock()
+		if disconnected {
+			p.wsSessions.expireLater(session)
+		}
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, responsesPath, bytes.NewReader(body))
+	if err != nil {
+		session.emitError("invalid_request_error", err.Error())
+		return
+	}
+	req.Header = session.header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Del("Content-Length")
+
+	rec := &wsEventWriter{session: session, header: make(http.Header)}
+	p.handleProxy(rec, req)
+	rec.finish()
+}
+
+// attach binds a new connection to the session and greets it. Callers must not hold s.mu.
+func (s *wsSession) attach(conn *websocket.Conn, greeting map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn = conn
+	data, _ := json.Marshal(greeting)
+	conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// resume binds a reconnected client and replays the events after lastSeq
+func (s *wsSession) resume(conn *websocket.Conn, lastSeq int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lastSeq < s.firstSeq-1 || lastSeq > s.seq {
+		return fmt.Errorf("event %s%d is no longer available", wsEventIDPrefix, lastSeq)
+	}
+	if s.conn != nil {
+		s.conn.Close() // a session has one client at a time
+	}
+	s.conn = conn
+
+	greeting, _ := json.Marshal(map[string]interface{}{
+		"type":    "session.resumed",
+		"session": map[string]interface{}{"id": s.id},
+	})
+	if err := conn.WriteMessage(websocket.TextMessage, greeting); err != nil {
+		return err
+	}
+	for _, event := range s.events[lastSeq-s.firstSeq+1:] {
+		if err := conn.WriteMessage(websocket.TextMessage, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// detach unbinds a closed connection unless another one already took its place
+func (s *wsSession) detach(conn *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == conn {
+		s.conn = nil
+		s.detachedAt = time.Now()
+	}
+}
+
+// emit numbers an event, keeps it for replay and sends it to the connected client, if any
+func (s *wsSession) emit(event map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	event["event_id"] = fmt.Sprintf("%s%d", wsEventIDPrefix, s.seq)
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	s.events = append(s.events, data)
+	if len(s.events) > wsSessionBuffer {
+		drop := len(s.events) - wsSessionBuffer
+		s.events = append([][]byte(nil), s.events[drop:]...)
+		s.firstSeq += drop
+	}
+
+	if s.conn != nil {
+		if err := s.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			// The read loop notices the broken connection; events stay buffered for a resume
+			logger.Debug("Responses websocket session %s write failed: %v", s.id, err)
+			s.conn.Close()
+			s.conn = nil
+			s.detachedAt = time.Now()
+		}
+	}
+}
+
+// emitError sends an error event in the format of the Responses streaming API
+func (s *wsSession) emitError(code, message string) {
+	s.emit(map[string]interface{}{
+		"type":    "error",
+		"code":    code,
+		"message": message,
+		"param":   nil,
+	})
+}
+
+// writeWSError sends an error to a connection that has no session
+func writeWSError(conn *websocket.Conn, code, message string) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":    "error",
+		"code":    code,
+		"message": message,
+		"param":   nil,
+	})
+	conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// wsEventWriter turns the SSE response of the HTTP pipeline into session events
+type wsEventWriter struct {
+	session *wsSession
+	header  http.Header
+	status  int
+	pending bytes.Buffer // Partial SSE data, or the body of a failed response
+}
+
+func (w *wsEventWriter) Header() http.Header { return w.header }
+
+func (w *wsEventWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *wsEventWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.pending.Write(data)
+	if w.status != http.StatusOK {
+		return len(data), nil
+	}
+
+	// Emit every complete line; a partial line waits for the next write
+	for {
+		line, err := w.pending.ReadString('\n')
+		if err != nil {
+			w.pending.Reset()
+			w.pending.WriteString(line)
+			break
+		}
+		w.emitLine(strings.TrimRight(line, "\r\n"))
+	}
+	return len(data), nil
+}
+
+// Flush is a no-op; every event is sent as soon as it is written
+func (w *wsEventWriter) Flush() {}
+
+func (w *wsEventWriter) emitLine(line string) {
+	payload := strings.TrimPrefix(line, "data: ")
+	if payload == line || !strings.HasPrefix(payload, "{") {
+		return
+	}
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		return
+	}
+	w.session.emit(event)
+}
+
+// finish emits what is left: the last event of the stream, or the error of a failed request
+func (w *wsEventWriter) finish() {
+	if w.status == http.StatusOK || w.status == 0 {
+		if w.pending.Len() > 0 {
+			w.emitLine(strings.TrimSpace(w.pending.String()))
+		}
+		return
+	}
+
+	message := strings.TrimSpace(w.pending.String())
+	var upstreamErr struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(w.pending.Bytes(), &upstreamErr) == nil && upstreamErr.Error.Message != "" {
+		message = upstreamErr.Error.Message
+	}
+	if message == "" {
+		message = http.StatusText(w.status)
+	}
+	w.session.emit(map[string]interface{}{
+		"type":    "error",
+		"code":    fmt.Sprintf("http_%d", w.status),
+		"message": message,
+		"param":   nil,
+		"status":  w.status,
+	})
+}
@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/lich0821/ccNexus/internal/config"
+)
+
+type memoryStatsStorage struct{}
+
+func (memoryStatsStorage) RecordDailyStat(stat interface{}) error { return nil }
+
+func (memoryStatsStorage) GetTotalStats() (int, map[string]interface{}, error) {
+	return 0, map[string]interface{}{}, nil
+}
+
+func (memoryStatsStorage) GetDailyStats(endpointName, startDate, endDate string) ([]interface{}, error) {
+	return nil, nil
+}
+
+func (memoryStatsStorage) DownsampleUsageStats(beforeDate string) (int, error) { return 0, nil }
+
+// newTestProxy returns a proxy over the given endpoints that keeps no stats
+func newTestProxy(endpoints ...config.Endpoint) *Proxy {
+	cfg := config.DefaultConfig()
+	cfg.UpdateEndpoints(endpoints)
+	return New(cfg, memoryStatsStorage{}, "test")
+}
+
+// claudeStreamUpstream serves a Claude Messages stream with one text delta per word, waiting
+// delay before each delta
+func claudeStreamUpstream(t *testing.T, words []string, delay time.Duration) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		send := func(event string, data map[string]interface{}) {
+			payload, _ := json.Marshal(data)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+			flusher.Flush()
+		}
+
+		send("message_start", map[string]interface{}{
+			"type": "message_start",
+			"message": map[string]interface{}{
+				"id": "msg_test", "type": "message", "role": "assistant", "model": "claude-test",
+				"content": []interface{}{}, "usage": map[string]interface{}{"input_tokens": 3, "output_tokens": 0},
+			},
+		})
+		send("content_block_start", map[string]interface{}{
+			"type": "content_block_start", "index": 0,
+			"content_block": map[string]interface{}{"type": "text", "text": ""},
+		})
+		for _, word := range words {
+			time.Sleep(delay)
+			send("content_block_delta", map[string]interface{}{
+				"type": "content_block_delta", "index": 0,
+				"delta": map[string]interface{}{"type": "text_delta", "text": word},
+			})
+		}
+		send("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": 0})
+		send("message_delta", map[string]interface{}{
+			"type":  "message_delta",
+			"delta": map[string]interface{}{"stop_reason": "end_turn"},
+			"usage": map[string]interface{}{"output_tokens": len(words)},
+		})
+		send("message_stop", map[string]interface{}{"type": "message_stop"})
+	}))
+}
+
+func newWSTestServer(t *testing.T, upstreamURL string) *httptest.Server {
+	t.Helper()
+	p := newTestProxy(config.Endpoint{
+		Name:        "test",
+		APIUrl:      upstreamURL,
+		APIKey:      "test-key",
+		Enabled:     true,
+		Transformer: "claude",
+		Model:       "claude-test",
+	})
+	return httptest.NewServer(http.HandlerFunc(p.handleResponses))
+}
+
+func dialWS(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + responsesPath
+	if query != "" {
+		url += "?" + query
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", url, err)
+	}
+	return conn
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event map[string]interface{}
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatalf("read event: %v", err)
+	}
+	return event
+}
+
+func eventSeq(t *testing.T, event map[string]interface{}) int {
+	t.Helper()
+	id, _ := event["event_id"].(string)
+	seq, err := strconv.Atoi(strings.TrimPrefix(id, wsEventIDPrefix))
+	if err != nil {
+		t.Fatalf("event %v has no valid event_id", event)
+	}
+	return seq
+}
+
+func sendResponseCreate(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	err := conn.WriteJSON(map[string]interface{}{
+		"type": wsResponseCreate,
+		"response": map[string]interface{}{
+			"model": "gpt-5",
+			"input": "Say hello",
+		},
+	})
+	if err != nil {
+		t.Fatalf("send response.create: %v", err)
+	}
+}
+
+func TestResponsesWebSocketStreamsEvents(t *testing.T) {
+	upstream := claudeStreamUpstream(t, []string{"Hello", ", ", "world"}, 0)
+	defer upstream.Close()
+	server := newWSTestServer(t, upstream.URL)
+	defer server.Close()
+
+	conn := dialWS(t, server, "")
+	defer conn.Close()
+
+	created := readEvent(t, conn)
+	if created["type"] != "session.created" {
+		t.Fatalf("first event = %v, want session.created", created["type"])
+	}
+
+	sendResponseCreate(t, conn)
+
+	var types []string
+	var text strings.Builder
+	lastSeq := 0
+	for {
+		event := readEvent(t, conn)
+		seq := eventSeq(t, event)
+		if seq <= lastSeq {
+			t.Fatalf("event_id %d after %d", seq, lastSeq)
+		}
+		lastSeq = seq
+
+		eventType, _ := event["type"].(string)
+		types = append(types, eventType)
+		if eventType == "error" {
+			t.Fatalf("error event: %v", event)
+		}
+		if eventType == "response.output_text.delta" {
+			delta, _ := event["delta"].(string)
+			text.WriteString(delta)
+		}
+		if eventType == "response.completed" {
+			break
+		}
+	}
+
+	if types[0] != "response.created" {
+		t.Errorf("first response event = %s, want response.created", types[0])
+	}
+	if got := text.String(); got != "Hello, world" {
+		t.Errorf("streamed text = %q, want %q", got, "Hello, world")
+	}
+}
+
+func TestResponsesWebSocketResume(t *testing.T) {
+	words := []string{"one ", "two ", "three ", "four ", "five ", "six"}
+	upstream := claudeStreamUpstream(t, words, 50*time.Millisecond)
+	defer upstream.Close()
+	server := newWSTestServer(t, upstream.URL)
+	defer server.Close()
+
+	conn := dialWS(t, server, "")
+	created := readEvent(t, conn)
+	session, _ := created["session"].(map[string]interface{})
+	sessionID, _ := session["id"].(string)
+	if sessionID == "" {
+		t.Fatalf("session.created without id: %v", created)
+	}
+
+	sendResponseCreate(t, conn)
+
+	// Drop the connection after the first delta
+	var text strings.Builder
+	lastSeq := 0
+	for {
+		event := readEvent(t, conn)
+		lastSeq = eventSeq(t, event)
+		if event["type"] == "response.output_text.delta" {
+			delta, _ := event["delta"].(string)
+			text.WriteString(delta)
+			break
+		}
+	}
+	conn.Close()
+	time.Sleep(120 * time.Millisecond)
+
+	conn = dialWS(t, server, fmt.Sprintf("session_id=%s&last_event_id=%s%d", sessionID, wsEventIDPrefix, lastSeq))
+	defer conn.Close()
+
+	resumed := readEvent(t, conn)
+	if resumed["type"] != "session.resumed" {
+		t.Fatalf("first event after reconnect = %v, want session.resumed", resumed)
+	}
+
+	for {
+		event := readEvent(t, conn)
+		seq := eventSeq(t, event)
+		if seq != lastSeq+1 {
+			t.Fatalf("event_id %d after %d, want no gap", seq, lastSeq)
+		}
+		lastSeq = seq
+
+		if event["type"] == "response.output_text.delta" {
+			delta, _ := event["delta"].(string)
+			text.WriteString(delta)
+		}
+		if event["type"] == "response.completed" {
+			break
+		}
+	}
+
+	if got, want := text.String(), strings.Join(words, ""); got != want {
+		t.Errorf("streamed text = %q, want %q", got, want)
+	}
+}
+
+func TestResponsesWebSocketUnknownSession(t *testing.T) {
+	server := newWSTestServer(t, "http://127.0.0.1:1")
+	defer server.Close()
+
+	conn := dialWS(t, server, "session_id=sess_missing")
+	defer conn.Close()
+
+	event := readEvent(t, conn)
+	if event["type"] != "error" || event["code"] != "session_not_found" {
+		t.Fatalf("event = %v, want session_not_found error", event)
+	}
+}
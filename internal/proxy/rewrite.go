@@ -0,0 +1,372 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+)
+
+// pathToken is one step of a parsed JSONPath
+type pathToken struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// leafAction tells rewritePath what to do with a matched field
+type leafAction int
+
+const (
+	leafKeep leafAction = iota
+	leafSet
+	leafRemove
+)
+
+// leafOp computes the new value of a matched field
+type leafOp func(current interface{}, exists bool) (interface{}, leafAction)
+
+// rewriteRegexCache caches compiled response patterns
+var rewriteRegexCache sync.Map
+
+// parseJSONPath parses the supported JSONPath subset: $.a.b, $.a[0], $.a[*], $.a.*, $['a']
+func parseJSONPath(path string) ([]pathToken, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path must start with $")
+	}
+	var tokens []pathToken
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" {
+				return nil, fmt.Errorf("empty key in path %s", path)
+			}
+			if name == "*" {
+				tokens = append(tokens, pathToken{wildcard: true})
+			} else {
+				tokens = append(tokens, pathToken{key: name})
+			}
+			rest = rest[end:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("unclosed bracket in path %s", path)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			switch {
+			case inner == "*":
+				tokens = append(tokens, pathToken{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				tokens = append(tokens, pathToken{key: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid index %q in path %s", inner, path)
+				}
+				tokens = append(tokens, pathToken{index: index, isIndex: true})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q in path %s", rest, path)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("path %s selects the whole document", path)
+	}
+	return tokens, nil
+}
+
+// rewritePath applies op to every field matched by tokens and returns the (possibly replaced) node.
+// When create is true, missing object keys along the path are created.
+func rewritePath(node interface{}, tokens []pathToken, create bool, op leafOp) interface{} {
+	tok := tokens[0]
+	last := len(tokens) == 1
+
+	if node == nil && create && !tok.isIndex && !tok.wildcard {
+		node = map[string]interface{}{}
+	}
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if tok.isIndex {
+			return n
+		}
+		keys := []string{tok.key}
+		if tok.wildcard {
+			keys = make([]string, 0, len(n))
+			for k := range n {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+		}
+		for _, k := range keys {
+			child, exists := n[k]
+			if last {
+				value, action := op(child, exists)
+				switch action {
+				case leafSet:
+					n[k] = value
+				case leafRemove:
+					delete(n, k)
+				}
+				continue
+			}
+			if !exists && !create {
+				continue
+			}
+			n[k] = rewritePath(child, tokens[1:], create, op)
+		}
+		return n
+	case []interface{}:
+		if !tok.isIndex && !tok.wildcard {
+			return n
+		}
+		var indices []int
+		if tok.wildcard {
+			for i := range n {
+				indices = append(indices, i)
+			}
+		} else {
+			index := tok.index
+			if index < 0 {
+				index += len(n)
+			}
+			if index < 0 || index >= len(n) {
+				return n
+			}
+			indices = []int{index}
+		}
+		removed := make(map[int]bool)
+		for _, i := range indices {
+			if last {
+				value, action := op(n[i], true)
+				switch action {
+				case leafSet:
+					n[i] = value
+				case leafRemove:
+					removed[i] = true
+				}
+				continue
+			}
+			n[i] = rewritePath(n[i], tokens[1:], create, op)
+		}
+		if len(removed) > 0 {
+			kept := make([]interface{}, 0, len(n)-len(removed))
+			for i, v := range n {
+				if !removed[i] {
+					kept = append(kept, v)
+				}
+			}
+			return kept
+		}
+		return n
+	}
+	return node
+}
+
+// bodyRuleOp builds the leaf operation for a body rewrite rule
+func bodyRuleOp(rule config.RewriteRule) leafOp {
+	switch rule.Action {
+	case config.RewriteSet:
+		return func(current interface{}, exists bool) (interface{}, leafAction) {
+			return rule.Value, leafSet
+		}
+	case config.RewriteDelete:
+		return func(current interface{}, exists bool) (interface{}, leafAction) {
+			if !exists {
+				return nil, leafKeep
+			}
+			return nil, leafRemove
+		}
+	case config.RewriteClamp:
+		return func(current interface{}, exists bool) (interface{}, leafAction) {
+			num, ok := current.(json.Number)
+			if !exists || !ok {
+				return nil, leafKeep
+			}
+			v, err := num.Float64()
+			if err != nil {
+				return nil, leafKeep
+			}
+			clamped := v
+			if rule.Min != nil && clamped < *rule.Min {
+				clamped = *rule.Min
+			}
+			if rule.Max != nil && clamped > *rule.Max {
+				clamped = *rule.Max
+			}
+			if clamped == v {
+				return nil, leafKeep
+			}
+			return json.Number(strconv.FormatFloat(clamped, 'f', -1, 64)), leafSet
+		}
+	case config.RewritePrefix:
+		prefix, _ := rule.Value.(string)
+		return func(current interface{}, exists bool) (interface{}, leafAction) {
+			switch v := current.(type) {
+			case string:
+				return prefix + v, leafSet
+			case []interface{}:
+				// Block arrays such as Claude system prompts get a leading text block
+				block := map[string]interface{}{"type": "text", "text": prefix}
+				return append([]interface{}{block}, v...), leafSet
+			case nil:
+				return prefix, leafSet
+			}
+			return nil, leafKeep
+		}
+	}
+	return nil
+}
+
+// decodeJSONBody decodes a JSON body preserving number precision
+func decodeJSONBody(body []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// applyBodyRewrites applies the endpoint's body rules of the given phase to a JSON request body
+func applyBodyRewrites(endpointName string, body []byte, rules []config.RewriteRule, phase string) []byte {
+	var doc interface{}
+	applied := 0
+	for i, rule := range rules {
+		if !rule.IsBodyRule() || rule.PhaseOrDefault() != phase {
+			continue
+		}
+		if doc == nil {
+			var err error
+			if doc, err = decodeJSONBody(body); err != nil {
+				logger.Warn("[%s] Skipping rewrite rules: request body is not JSON: %v", endpointName, err)
+				return body
+			}
+		}
+		tokens, err := parseJSONPath(rule.Path)
+		if err != nil {
+			logger.Warn("[%s] Skipping rewrite rule %d: %v", endpointName, i+1, err)
+			continue
+		}
+		doc = rewritePath(doc, tokens, rule.Action == config.RewriteSet, bodyRuleOp(rule))
+		applied++
+	}
+	if applied == 0 {
+		return body
+	}
+
+	rewritten, err := json.Marshal(doc)
+	if err != nil {
+		logger.Warn("[%s] Failed to encode rewritten request: %v", endpointName, err)
+		return body
+	}
+	logger.Debug("[%s] Applied %d %s rewrite rule(s)", endpointName, applied, phase)
+	return rewritten
+}
+
+// applyHeaderRewrites applies the endpoint's header rules to the upstream request
+func applyHeaderRewrites(req *http.Request, rules []config.RewriteRule) {
+	for _, rule := range rules {
+		switch rule.Action {
+		case config.RewriteSetHeader:
+			value, _ := rule.Value.(string)
+			req.Header.Set(rule.Header, value)
+		case config.RewriteRemoveHeader:
+			req.Header.Del(rule.Header)
+		}
+	}
+}
+
+// applyResponseRewrites applies replace rules to the text fields of a non-streaming response
+func applyResponseRewrites(endpointName string, body []byte, rules []config.RewriteRule) []byte {
+	var patterns []*regexp.Regexp
+	var replacements []string
+	for i, rule := range rules {
+		if rule.Action != config.RewriteReplace {
+			continue
+		}
+		re, err := compileRewritePattern(rule.Pattern)
+		if err != nil {
+			logger.Warn("[%s] Skipping rewrite rule %d: %v", endpointName, i+1, err)
+			continue
+		}
+		patterns = append(patterns, re)
+		replacements = append(replacements, rule.Replacement)
+	}
+	if len(patterns) == 0 {
+		return body
+	}
+
+	doc, err := decodeJSONBody(body)
+	if err != nil {
+		return body
+	}
+	doc = replaceResponseText(doc, "", patterns, replacements)
+
+	rewritten, err := json.Marshal(doc)
+	if err != nil {
+		logger.Warn("[%s] Failed to encode rewritten response: %v", endpointName, err)
+		return body
+	}
+	return rewritten
+}
+
+// replaceResponseText walks the response and rewrites "text" and string "content" fields,
+// which covers Claude, OpenAI Chat and Responses payloads
+func replaceResponseText(node interface{}, key string, patterns []*regexp.Regexp, replacements []string) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for k, v := range n {
+			if k == "input" {
+				continue // Tool arguments are data, not response text
+			}
+			n[k] = replaceResponseText(v, k, patterns, replacements)
+		}
+		return n
+	case []interface{}:
+		for i, v := range n {
+			n[i] = replaceResponseText(v, key, patterns, replacements)
+		}
+		return n
+	case string:
+		if key != "text" && key != "content" {
+			return n
+		}
+		for i, re := range patterns {
+			n = re.ReplaceAllString(n, replacements[i])
+		}
+		return n
+	}
+	return node
+}
+
+// compileRewritePattern compiles a replace pattern once and caches it
+func compileRewritePattern(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := rewriteRegexCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	rewriteRegexCache.Store(pattern, re)
+	return re, nil
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/lich0821/ccNexus/internal/config"
+)
+
+func float64Ptr(v float64) *float64 { return &v }
+
+func TestParseJSONPath(t *testing.T) {
+	tokens, err := parseJSONPath(`$.messages[*].content[0]['cache_control']`)
+	if err != nil {
+		t.Fatalf("parseJSONPath failed: %v", err)
+	}
+	want := []pathToken{{key: "messages"}, {wildcard: true}, {key: "content"}, {index: 0, isIndex: true}, {key: "cache_control"}}
+	if len(tokens) != len(want) {
+		t.Fatalf("Expected %d tokens, got %+v", len(want), tokens)
+	}
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("token %d = %+v, want %+v", i, tokens[i], want[i])
+		}
+	}
+
+	for _, path := range []string{"", "$", "messages", "$.a[", "$.a[x]", "$..a"} {
+		if _, err := parseJSONPath(path); err == nil {
+			t.Errorf("parseJSONPath(%q) should fail", path)
+		}
+	}
+}
+
+func TestApplyBodyRewrites(t *testing.T) {
+	body := []byte(`{
+		"model": "gpt-4o",
+		"max_tokens": 64000,
+		"temperature": 0.2,
+		"system": [{"type": "text", "text": "Be brief"}],
+		"metadata": {"user_id": "u1"},
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "Hi", "cache_control": {"type": "ephemeral"}}]},
+			{"role": "user", "content": [{"type": "text", "text": "Again", "cache_control": {"type": "ephemeral"}}]}
+		]
+	}`)
+	rules := []config.RewriteRule{
+		{Action: config.RewriteDelete, Path: "$.metadata"},
+		{Action: config.RewriteDelete, Path: "$.messages[*].content[*].cache_control"},
+		{Action: config.RewriteClamp, Path: "$.max_tokens", Max: float64Ptr(32000)},
+		{Action: config.RewriteClamp, Path: "$.temperature", Min: float64Ptr(0), Max: float64Ptr(1)},
+		{Action: config.RewriteSet, Path: "$.extra.reasoning.effort", Value: "high"},
+		{Action: config.RewritePrefix, Path: "$.system", Value: "Answer in English. "},
+		{Action: config.RewriteSet, Path: "$.model", Value: "client-only", Phase: config.RewritePhaseClient},
+		{Action: config.RewriteSetHeader, Header: "X-Test", Value: "1"},
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(applyBodyRewrites("test", body, rules, config.RewritePhaseUpstream), &got); err != nil {
+		t.Fatalf("Rewritten body is not JSON: %v", err)
+	}
+	if _, ok := got["metadata"]; ok {
+		t.Errorf("Expected metadata to be deleted")
+	}
+	for _, msg := range got["messages"].([]interface{}) {
+		block := msg.(map[string]interface{})["content"].([]interface{})[0].(map[string]interface{})
+		if _, ok := block["cache_control"]; ok {
+			t.Errorf("Expected cache_control to be deleted from %v", block)
+		}
+	}
+	if got["max_tokens"] != float64(32000) {
+		t.Errorf("max_tokens = %v, want 32000", got["max_tokens"])
+	}
+	if got["temperature"] != 0.2 {
+		t.Errorf("temperature = %v, want it unchanged", got["temperature"])
+	}
+	effort := got["extra"].(map[string]interface{})["reasoning"].(map[string]interface{})["effort"]
+	if effort != "high" {
+		t.Errorf("Expected missing objects to be created, got %v", got["extra"])
+	}
+	system := got["system"].([]interface{})
+	if len(system) != 2 || system[0].(map[string]interface{})["text"] != "Answer in English. " {
+		t.Errorf("Expected a leading system block, got %v", system)
+	}
+	if got["model"] != "gpt-4o" {
+		t.Errorf("Client phase rule ran in the upstream phase: model = %v", got["model"])
+	}
+
+	clientBody := applyBodyRewrites("test", body, rules, config.RewritePhaseClient)
+	if err := json.Unmarshal(clientBody, &got); err != nil || got["model"] != "client-only" {
+		t.Errorf("Expected the client phase rule to set the model, got %s", clientBody)
+	}
+}
+
+func TestApplyBodyRewritesKeepsBodyWithoutRules(t *testing.T) {
+	body := []byte(`{"b": 1, "a": 12345678901234567890}`)
+	if got := applyBodyRewrites("test", body, nil, config.RewritePhaseUpstream); string(got) != string(body) {
+		t.Errorf("Expected the body to be untouched, got %s", got)
+	}
+
+	// Large integers keep their precision through a rewrite
+	rules := []config.RewriteRule{{Action: config.RewriteSet, Path: "$.c", Value: true}}
+	got := applyBodyRewrites("test", body, rules, config.RewritePhaseUpstream)
+	if string(got) != `{"a":12345678901234567890,"b":1,"c":true}` {
+		t.Errorf("Unexpected rewritten body: %s", got)
+	}
+
+	notJSON := []byte("not json")
+	if got := applyBodyRewrites("test", notJSON, rules, config.RewritePhaseUpstream); string(got) != string(notJSON) {
+		t.Errorf("Expected a non-JSON body to be untouched, got %s", got)
+	}
+}
+
+func TestApplyHeaderRewrites(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com", nil)
+	req.Header.Set("anthropic-beta", "prompt-caching")
+	applyHeaderRewrites(req, []config.RewriteRule{
+		{Action: config.RewriteRemoveHeader, Header: "anthropic-beta"},
+		{Action: config.RewriteSetHeader, Header: "X-Org", Value: "team"},
+		{Action: config.RewriteDelete, Path: "$.metadata"},
+	})
+	if req.Header.Get("anthropic-beta") != "" || req.Header.Get("X-Org") != "team" {
+		t.Errorf("Unexpected headers: %v", req.Header)
+	}
+}
+
+func TestApplyResponseRewrites(t *testing.T) {
+	body := []byte(`{"content": [
+		{"type": "text", "text": "I am GPT-4o"},
+		{"type": "tool_use", "name": "echo", "input": {"text": "GPT-4o"}}
+	]}`)
+	rules := []config.RewriteRule{{Action: config.RewriteReplace, Pattern: `GPT-(\w+)`, Replacement: "model $1"}}
+
+	var got struct {
+		Content []struct {
+			Text  string            `json:"text"`
+			Input map[string]string `json:"input"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(applyResponseRewrites("test", body, rules), &got); err != nil {
+		t.Fatalf("Rewritten response is not JSON: %v", err)
+	}
+	if got.Content[0].Text != "I am model 4o" {
+		t.Errorf("text = %q, want the pattern replaced", got.Content[0].Text)
+	}
+	if got.Content[1].Input["text"] != "GPT-4o" {
+		t.Errorf("Tool input should not be rewritten, got %q", got.Content[1].Input["text"])
+	}
+}
+
+func TestValidateRewriteRules(t *testing.T) {
+	valid := []config.RewriteRule{
+		{Action: config.RewriteSetHeader, Header: "X-Test", Value: "1"},
+		{Action: config.RewriteClamp, Path: "$.max_tokens", Max: float64Ptr(1)},
+		{Action: config.RewriteReplace, Pattern: "a+"},
+	}
+	if err := config.ValidateRewriteRules(valid); err != nil {
+		t.Errorf("ValidateRewriteRules(valid) = %v", err)
+	}
+
+	for _, rule := range []config.RewriteRule{
+		{Action: "rename"},
+		{Action: config.RewriteSetHeader, Header: "X-Test"},
+		{Action: config.RewriteSet, Path: "metadata"},
+		{Action: config.RewriteSet, Path: "$.a", Phase: "later"},
+		{Action: config.RewriteClamp, Path: "$.max_tokens"},
+		{Action: config.RewritePrefix, Path: "$.system", Value: 1},
+		{Action: config.RewriteReplace, Pattern: "("},
+	} {
+		if err := config.ValidateRewriteRules([]config.RewriteRule{rule}); err == nil {
+			t.Errorf("ValidateRewriteRules(%+v) should fail", rule)
+		}
+	}
+}
@@ -0,0 +1,815 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+	"github.com/lich0821/ccNexus/internal/transformer"
+)
+
+// serverToolRoundKey marks the requests of a server tool loop, which must not start another loop
+type serverToolRoundKey struct{}
+
+// serverToolCall is a call of a server tool made by the model
+type serverToolCall struct {
+	ID    string
+	Name  string
+	Input map[string]interface{}
+	Err   error // Arguments could not be parsed
+}
+
+// serverToolResult is the outcome of a server tool call
+type serverToolResult struct {
+	ID      string
+	Content string
+	IsError bool
+}
+
+// serverToolDialect follows a server tool conversation in the format of the client
+type serverToolDialect interface {
+	// parseTurn reads the model's turn from a response and returns the tool calls it made
+	parseTurn(body []byte, streaming bool) ([]serverToolCall, error)
+	// continueRequest appends the last turn and the tool results, returning the next request
+	continueRequest(results []serverToolResult) ([]byte, error)
+	// dropCalls removes the calls of the tools drop matches from a turn that goes to the client
+	dropCalls(body []byte, streaming bool, drop func(name string) bool) []byte
+}
+
+// serverToolsFor returns the server tools config when a request should run the server tool
+// loop. Claude Code requests to Claude endpoints are left alone, as Claude has its own tools.
+func (p *Proxy) serverToolsFor(r *http.Request, clientFormat ClientFormat) *config.ServerToolsConfig {
+	cfg := p.config.GetServerTools()
+	if cfg == nil || !cfg.Enabled || len(cfg.Tools) == 0 || r.Method != http.MethodPost {
+		return nil
+	}
+	if r.Context().Value(serverToolRoundKey{}) != nil {
+		return nil
+	}
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/v1/messages", "/v1/chat/completions", "/v1/responses":
+	default:
+		return nil
+	}
+	if clientFormat == ClientFormatClaude {
+		if transformer := p.getCurrentEndpoint().Transformer; transformer == "" || transformer == "claude" {
+			return nil
+		}
+	}
+	return cfg
+}
+
+// handleServerTools offers the server tools to the model and runs the request in rounds.
+// While the model calls nothing but server tools, the proxy executes them and sends the
+// conversation back; the first response without server tool calls goes to the client. Every
+// round is buffered until its tool calls are known, so the answer is not streamed as it is
+// generated. The client never defined the server tools, so their calls are removed from the turns
+// it gets.
+func (p *Proxy) handleServerTools(w http.ResponseWriter, r *http.Request, body []byte, clientFormat ClientFormat, cfg *config.ServerToolsConfig) {
+	var req map[string]interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		p.proxyServerToolRound(w, r, body) // let the pipeline report the bad request
+		return
+	}
+	injectServerTools(clientFormat, req, serverToolSpecs(cfg))
+	body, _ = json.Marshal(req)
+
+	maxRounds := cfg.MaxRoundsOrDefault()
+	dialect := newServerToolDialect(clientFormat, body, maxRounds)
+	for round := 1; ; round++ {
+		held := newHeldResponse(w)
+		p.proxyServerToolRound(held, r, body)
+		if held.status != http.StatusOK {
+			held.release()
+			return
+		}
+
+		streaming := strings.Contains(held.header.Get("Content-Type"), "text/event-stream")
+		calls, err := dialect.parseTurn(held.body.Bytes(), streaming)
+		if err != nil {
+			logger.Warn("Server tools: failed to read model response: %v", err)
+			held.release()
+			return
+		}
+		if len(calls) == 0 {
+			held.release()
+			return
+		}
+		releaseClientTurn := func() {
+			body := dialect.dropCalls(held.body.Bytes(), streaming, cfg.Has)
+			held.body.Reset()
+			held.body.Write(body)
+			held.release()
+		}
+		// Client tools need the client, so a turn that also calls them goes back without the
+		// server tool calls
+		for _, call := range calls {
+			if !cfg.Has(call.Name) {
+				logger.Debug("Server tools: turn calls client tool %s, returning it to the client", call.Name)
+				releaseClientTurn()
+				return
+			}
+		}
+		if round > maxRounds {
+			logger.Warn("Server tools: stopped after %d rounds", maxRounds)
+			releaseClientTurn()
+			return
+		}
+
+		results := make([]serverToolResult, len(calls))
+		for i, call := range calls {
+			results[i] = p.runServerTool(r.Context(), cfg, call)
+		}
+		if body, err = dialect.continueRequest(results); err != nil {
+			logger.Warn("Server tools: failed to continue the conversation: %v", err)
+			held.release()
+			return
+		}
+	}
+}
+
+// proxyServerToolRound sends one round of a server tool loop through the proxy pipeline
+func (p *Proxy) proxyServerToolRound(w http.ResponseWriter, r *http.Request, body []byte) {
+	ctx := context.WithValue(r.Context(), serverToolRoundKey{}, true)
+	req := r.Clone(ctx)
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Del("Content-Length")
+	p.handleProxy(w, req)
+}
+
+// injectServerTools adds the server tools to a request, replacing client tools of the same name
+func injectServerTools(clientFormat ClientFormat, req map[string]interface{}, specs []serverToolSpec) {
+	names := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		names[spec.Name] = true
+	}
+
+	existing, _ := req["tools"].([]interface{})
+	tools := make([]interface{}, 0, len(existing)+len(specs))
+	for _, tool := range existing {
+		if !names[requestToolName(tool)] {
+			tools = append(tools, tool)
+		}
+	}
+	for _, spec := range specs {
+		switch clientFormat {
+		case ClientFormatClaude:
+			tools = append(tools, map[string]interface{}{
+				"name": spec.Name, "description": spec.Description, "input_schema": spec.Schema,
+			})
+		case ClientFormatOpenAIChat:
+			tools = append(tools, map[string]interface{}{
+				"type": "function",
+				"function": map[string]interface{}{
+					"name": spec.Name, "description": spec.Description, "parameters": spec.Schema,
+				},
+			})
+		case ClientFormatOpenAIResponses:
+			tools = append(tools, map[string]interface{}{
+				"type": "function", "name": spec.Name, "description": spec.Description, "parameters": spec.Schema,
+			})
+		}
+	}
+	req["tools"] = tools
+}
+
+// requestToolName returns the name of a tool definition in any client format
+func requestToolName(tool interface{}) string {
+	m, ok := tool.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	if fn, ok := m["function"].(map[string]interface{}); ok {
+		name, _ := fn["name"].(string)
+		return name
+	}
+	name, _ := m["name"].(string)
+	return name
+}
+
+func newServerToolDialect(clientFormat ClientFormat, body []byte, maxRounds int) serverToolDialect {
+	switch clientFormat {
+	case ClientFormatOpenAIChat:
+		return &chatToolDialect{request: body}
+	case ClientFormatOpenAIResponses:
+		return &responsesToolDialect{request: body}
+	default:
+		chain := transformer.NewToolChainHandler("", "", body)
+		chain.SetMaxDepth(maxRounds)
+		return &claudeToolDialect{chain: chain}
+	}
+}
+
+// parseToolArguments parses the JSON arguments of a call
+func parseToolArguments(args string) (map[string]interface{}, error) {
+	input := map[string]interface{}{}
+	if strings.TrimSpace(args) == "" {
+		return input, nil
+	}
+	if err := json.Unmarshal([]byte(args), &input); err != nil {
+		return map[string]interface{}{}, fmt.Errorf("invalid tool arguments: %w", err)
+	}
+	return input, nil
+}
+
+// sseEvents returns the JSON payloads of an SSE stream
+func sseEvents(body []byte) []map[string]interface{} {
+	var events []map[string]interface{}
+	for _, line := range strings.Split(string(body), "\n") {
+		data, ok := strings.CutPrefix(strings.TrimRight(line, "\r"), "data:")
+		if !ok {
+			continue
+		}
+		var event map[string]interface{}
+		if json.Unmarshal([]byte(strings.TrimSpace(data)), &event) == nil {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// rewriteSSE rewrites the JSON payload of each event of an SSE stream; edit returns false to drop
+// the event. Other lines, like "event:" and "data: [DONE]", are kept as they are.
+func rewriteSSE(body []byte, edit func(event map[string]interface{}) bool) []byte {
+	var out bytes.Buffer
+	for _, block := range strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n\n") {
+		if strings.TrimSpace(block) == "" {
+			continue
+		}
+		lines := strings.Split(block, "\n")
+		for i, line := range lines {
+			data, ok := strings.CutPrefix(line, "data:")
+			var event map[string]interface{}
+			if !ok || json.Unmarshal([]byte(strings.TrimSpace(data)), &event) != nil {
+				continue
+			}
+			if !edit(event) {
+				lines = nil
+				break
+			}
+			payload, _ := json.Marshal(event)
+			lines[i] = "data: " + string(payload)
+			break
+		}
+		if lines != nil {
+			out.WriteString(strings.Join(lines, "\n"))
+			out.WriteString("\n\n")
+		}
+	}
+	return out.Bytes()
+}
+
+// eventIndex returns an integer field of an event, or -1
+func eventIndex(event map[string]interface{}, key string) int {
+	if i, ok := event[key].(float64); ok {
+		return int(i)
+	}
+	return -1
+}
+
+// claudeToolDialect follows a Messages API conversation with the tool chain handler
+type claudeToolDialect struct {
+	chain   *transformer.ToolChainHandler
+	content []map[string]interface{} // Content blocks of the last turn
+}
+
+func (d *claudeToolDialect) parseTurn(body []byte, streaming bool) ([]serverToolCall, error) {
+	d.content = nil
+	if streaming {
+		d.content = claudeContentFromStream(body)
+	} else {
+		var resp struct {
+			Content []map[string]interface{} `json:"content"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, err
+		}
+		d.content = resp.Content
+	}
+
+	var calls []serverToolCall
+	for _, block := range d.content {
+		if block["type"] != "tool_use" {
+			continue
+		}
+		call := serverToolCall{}
+		call.ID, _ = block["id"].(string)
+		call.Name, _ = block["name"].(string)
+		call.Input, _ = block["input"].(map[string]interface{})
+		if call.Input == nil {
+			call.Input = map[string]interface{}{}
+		}
+		calls = append(calls, call)
+	}
+	return calls, nil
+}
+
+// claudeContentFromStream rebuilds the content blocks of a Messages API stream
+func claudeContentFromStream(body []byte) []map[string]interface{} {
+	var content []map[string]interface{}
+	var partialJSON []strings.Builder
+	for _, event := range sseEvents(body) {
+		index := -1
+		if i, ok := event["index"].(float64); ok {
+			index = int(i)
+		}
+		switch event["type"] {
+		case "content_block_start":
+			block, _ := event["content_block"].(map[string]interface{})
+			if index < 0 || block == nil {
+				continue
+			}
+			for len(content) <= index {
+				content = append(content, nil)
+				partialJSON = append(partialJSON, strings.Builder{})
+			}
+			content[index] = block
+		case "content_block_delta":
+			delta, _ := event["delta"].(map[string]interface{})
+			if index < 0 || index >= len(content) || content[index] == nil || delta == nil {
+				continue
+			}
+			block := content[index]
+			switch delta["type"] {
+			case "text_delta":
+				text, _ := block["text"].(string)
+				deltaText, _ := delta["text"].(string)
+				block["text"] = text + deltaText
+			case "input_json_delta":
+				partial, _ := delta["partial_json"].(string)
+				partialJSON[index].WriteString(partial)
+			}
+		case "content_block_stop":
+			if index < 0 || index >= len(content) || content[index] == nil {
+				continue
+			}
+			if content[index]["type"] == "tool_use" {
+				input, _ := parseToolArguments(partialJSON[index].String())
+				content[index]["input"] = input
+			}
+		}
+	}
+
+	blocks := content[:0]
+	for _, block := range content {
+		if block != nil {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+func (d *claudeToolDialect) dropCalls(body []byte, streaming bool, drop func(name string) bool) []byte {
+	isDropped := func(block map[string]interface{}) bool {
+		name, _ := block["name"].(string)
+		return block["type"] == "tool_use" && drop(name)
+	}
+	toolUses := 0
+	if !streaming {
+		var resp map[string]interface{}
+		if json.Unmarshal(body, &resp) != nil {
+			return body
+		}
+		content, _ := resp["content"].([]interface{})
+		kept := make([]interface{}, 0, len(content))
+		for _, item := range content {
+			block, _ := item.(map[string]interface{})
+			if isDropped(block) {
+				continue
+			}
+			if block["type"] == "tool_use" {
+				toolUses++
+			}
+			kept = append(kept, item)
+		}
+		resp["content"] = kept
+		if toolUses == 0 && resp["stop_reason"] == "tool_use" {
+			resp["stop_reason"] = "end_turn"
+		}
+		result, _ := json.Marshal(resp)
+		return result
+	}
+
+	// Blocks after a dropped one move up, so the client sees consecutive indexes
+	indexes := make(map[int]int)
+	dropped := make(map[int]bool)
+	return rewriteSSE(body, func(event map[string]interface{}) bool {
+		index := eventIndex(event, "index")
+		switch event["type"] {
+		case "content_block_start":
+			block, _ := event["content_block"].(map[string]interface{})
+			if isDropped(block) {
+				dropped[index] = true
+				return false
+			}
+			if block["type"] == "tool_use" {
+				toolUses++
+			}
+			indexes[index] = len(indexes)
+			event["index"] = indexes[index]
+		case "content_block_delta", "content_block_stop":
+			if dropped[index] {
+				return false
+			}
+			if i, ok := indexes[index]; ok {
+				event["index"] = i
+			}
+		case "message_delta":
+			if delta, ok := event["delta"].(map[string]interface{}); ok && toolUses == 0 && delta["stop_reason"] == "tool_use" {
+				delta["stop_reason"] = "end_turn"
+			}
+		}
+		return true
+	})
+}
+
+func (d *claudeToolDialect) continueRequest(results []serverToolResult) ([]byte, error) {
+	// Thinking blocks are left out: without signatures no upstream would accept them back
+	for _, block := range d.content {
+		switch block["type"] {
+		case "tool_use":
+			id, _ := block["id"].(string)
+			name, _ := block["name"].(string)
+			input, _ := block["input"].(map[string]interface{})
+			d.chain.AddToolCall(id, name, input)
+		case "text":
+			if text, _ := block["text"].(string); text != "" {
+				d.chain.AddContent(map[string]interface{}{"type": "text", "text": text})
+			}
+		}
+	}
+	for _, result := range results {
+		if result.IsError {
+			d.chain.AddToolError(result.ID, result.Content)
+		} else {
+			d.chain.AddToolResult(result.ID, result.Content)
+		}
+	}
+	return d.chain.Advance()
+}
+
+// chatToolDialect follows a Chat Completions conversation
+type chatToolDialect struct {
+	request []byte
+	message map[string]interface{} // Assistant message of the last turn
+}
+
+func (d *chatToolDialect) parseTurn(body []byte, streaming bool) ([]serverToolCall, error) {
+	if streaming {
+		d.message = chatMessageFromStream(body)
+	} else {
+		var resp struct {
+			Choices []struct {
+				Message map[string]interface{} `json:"message"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, err
+		}
+		d.message = nil
+		if len(resp.Choices) > 0 {
+			d.message = resp.Choices[0].Message
+		}
+	}
+	if d.message == nil {
+		return nil, nil
+	}
+
+	toolCalls, _ := d.message["tool_calls"].([]interface{})
+	var calls []serverToolCall
+	for _, item := range toolCalls {
+		tc, _ := item.(map[string]interface{})
+		fn, _ := tc["function"].(map[string]interface{})
+		call := serverToolCall{}
+		call.ID, _ = tc["id"].(string)
+		call.Name, _ = fn["name"].(string)
+		args, _ := fn["arguments"].(string)
+		call.Input, call.Err = parseToolArguments(args)
+		calls = append(calls, call)
+	}
+	return calls, nil
+}
+
+// chatMessageFromStream rebuilds the assistant message of a Chat Completions stream
+func chatMessageFromStream(body []byte) map[string]interface{} {
+	var content strings.Builder
+	var toolCalls []map[string]interface{}
+	var arguments []strings.Builder
+	for _, chunk := range sseEvents(body) {
+		choices, _ := chunk["choices"].([]interface{})
+		if len(choices) == 0 {
+			continue
+		}
+		choice, _ := choices[0].(map[string]interface{})
+		delta, _ := choice["delta"].(map[string]interface{})
+		if delta == nil {
+			continue
+		}
+		if text, ok := delta["content"].(string); ok {
+			content.WriteString(text)
+		}
+		deltaCalls, _ := delta["tool_calls"].([]interface{})
+		for _, item := range deltaCalls {
+			tc, _ := item.(map[string]interface{})
+			index := len(toolCalls) - 1
+			if i, ok := tc["index"].(float64); ok {
+				index = int(i)
+			}
+			if index < 0 {
+				index = 0
+			}
+			for len(toolCalls) <= index {
+				toolCalls = append(toolCalls, map[string]interface{}{"type": "function", "function": map[string]interface{}{}})
+				arguments = append(arguments, strings.Builder{})
+			}
+			call := toolCalls[index]
+			if id, ok := tc["id"].(string); ok && id != "" {
+				call["id"] = id
+			}
+			if fn, ok := tc["function"].(map[string]interface{}); ok {
+				if name, ok := fn["name"].(string); ok && name != "" {
+					call["function"].(map[string]interface{})["name"] = name
+				}
+				if args, ok := fn["arguments"].(string); ok {
+					arguments[index].WriteString(args)
+				}
+			}
+		}
+	}
+
+	message := map[string]interface{}{"role": "assistant", "content": content.String()}
+	if len(toolCalls) > 0 {
+		calls := make([]interface{}, len(toolCalls))
+		for i, call := range toolCalls {
+			call["function"].(map[string]interface{})["arguments"] = arguments[i].String()
+			calls[i] = call
+		}
+		message["tool_calls"] = calls
+	}
+	return message
+}
+
+func (d *chatToolDialect) dropCalls(body []byte, streaming bool, drop func(name string) bool) []byte {
+	isDropped := func(tc map[string]interface{}) bool {
+		fn, _ := tc["function"].(map[string]interface{})
+		name, _ := fn["name"].(string)
+		return name != "" && drop(name)
+	}
+	if !streaming {
+		var resp map[string]interface{}
+		if json.Unmarshal(body, &resp) != nil {
+			return body
+		}
+		choices, _ := resp["choices"].([]interface{})
+		for _, item := range choices {
+			choice, _ := item.(map[string]interface{})
+			message, _ := choice["message"].(map[string]interface{})
+			if message == nil {
+				continue
+			}
+			toolCalls, _ := message["tool_calls"].([]interface{})
+			kept := make([]interface{}, 0, len(toolCalls))
+			for _, call := range toolCalls {
+				if tc, _ := call.(map[string]interface{}); !isDropped(tc) {
+					kept = append(kept, call)
+				}
+			}
+			if len(kept) > 0 {
+				message["tool_calls"] = kept
+				continue
+			}
+			delete(message, "tool_calls")
+			if choice["finish_reason"] == "tool_calls" {
+				choice["finish_reason"] = "stop"
+			}
+		}
+		result, _ := json.Marshal(resp)
+		return result
+	}
+
+	// Only the first chunk of a call has its name; later chunks are matched by index
+	indexes := make(map[int]int)
+	dropped := make(map[int]bool)
+	last := 0
+	return rewriteSSE(body, func(chunk map[string]interface{}) bool {
+		choices, _ := chunk["choices"].([]interface{})
+		for _, item := range choices {
+			choice, _ := item.(map[string]interface{})
+			if delta, ok := choice["delta"].(map[string]interface{}); ok {
+				toolCalls, _ := delta["tool_calls"].([]interface{})
+				var kept []interface{}
+				for _, call := range toolCalls {
+					tc, _ := call.(map[string]interface{})
+					index := eventIndex(tc, "index")
+					if index < 0 {
+						index = last
+					}
+					last = index
+					if _, seen := indexes[index]; !seen && !dropped[index] {
+						if isDropped(tc) {
+							dropped[index] = true
+						} else {
+							indexes[index] = len(indexes)
+						}
+					}
+					if dropped[index] {
+						continue
+					}
+					tc["index"] = indexes[index]
+					kept = append(kept, tc)
+				}
+				if len(kept) > 0 {
+					delta["tool_calls"] = kept
+				} else {
+					delete(delta, "tool_calls")
+				}
+			}
+			if choice["finish_reason"] == "tool_calls" && len(indexes) == 0 {
+				choice["finish_reason"] = "stop"
+			}
+		}
+		return true
+	})
+}
+
+func (d *chatToolDialect) continueRequest(results []serverToolResult) ([]byte, error) {
+	var req map[string]interface{}
+	if err := json.Unmarshal(d.request, &req); err != nil {
+		return nil, err
+	}
+	messages, _ := req["messages"].([]interface{})
+
+	assistant := map[string]interface{}{"role": "assistant", "content": d.message["content"], "tool_calls": d.message["tool_calls"]}
+	messages = append(messages, assistant)
+	for _, result := range results {
+		messages = append(messages, map[string]interface{}{
+			"role": "tool", "tool_call_id": result.ID, "content": result.Content,
+		})
+	}
+	req["messages"] = messages
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	d.request = body
+	return body, nil
+}
+
+// responsesToolDialect follows a Responses API conversation
+type responsesToolDialect struct {
+	request []byte
+	output  []map[string]interface{} // Output items of the last turn
+}
+
+func (d *responsesToolDialect) parseTurn(body []byte, streaming bool) ([]serverToolCall, error) {
+	d.output = nil
+	if streaming {
+		var completed []interface{}
+		for _, event := range sseEvents(body) {
+			switch event["type"] {
+			case "response.output_item.done":
+				if item, ok := event["item"].(map[string]interface{}); ok {
+					d.output = append(d.output, item)
+				}
+			case "response.completed":
+				if resp, ok := event["response"].(map[string]interface{}); ok {
+					completed, _ = resp["output"].([]interface{})
+				}
+			}
+		}
+		if len(d.output) == 0 {
+			for _, item := range completed {
+				if m, ok := item.(map[string]interface{}); ok {
+					d.output = append(d.output, m)
+				}
+			}
+		}
+	} else {
+		var resp struct {
+			Output []map[string]interface{} `json:"output"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, err
+		}
+		d.output = resp.Output
+	}
+
+	var calls []serverToolCall
+	for _, item := range d.output {
+		if item["type"] != "function_call" {
+			continue
+		}
+		call := serverToolCall{}
+		call.ID, _ = item["call_id"].(string)
+		call.Name, _ = item["name"].(string)
+		args, _ := item["arguments"].(string)
+		call.Input, call.Err = parseToolArguments(args)
+		calls = append(calls, call)
+	}
+	return calls, nil
+}
+
+func (d *responsesToolDialect) dropCalls(body []byte, streaming bool, drop func(name string) bool) []byte {
+	isDropped := func(item map[string]interface{}) bool {
+		name, _ := item["name"].(string)
+		return item["type"] == "function_call" && drop(name)
+	}
+	keepOutput := func(resp map[string]interface{}) {
+		output, ok := resp["output"].([]interface{})
+		if !ok {
+			return
+		}
+		kept := make([]interface{}, 0, len(output))
+		for _, entry := range output {
+			if item, _ := entry.(map[string]interface{}); !isDropped(item) {
+				kept = append(kept, entry)
+			}
+		}
+		resp["output"] = kept
+	}
+	if !streaming {
+		var resp map[string]interface{}
+		if json.Unmarshal(body, &resp) != nil {
+			return body
+		}
+		keepOutput(resp)
+		result, _ := json.Marshal(resp)
+		return result
+	}
+
+	// Items after a dropped one move up, so the client sees consecutive output indexes
+	indexes := make(map[int]int)
+	dropped := make(map[int]bool)
+	return rewriteSSE(body, func(event map[string]interface{}) bool {
+		if resp, ok := event["response"].(map[string]interface{}); ok {
+			keepOutput(resp)
+		}
+		index := eventIndex(event, "output_index")
+		if index < 0 {
+			return true
+		}
+		if item, ok := event["item"].(map[string]interface{}); ok && event["type"] == "response.output_item.added" {
+			if isDropped(item) {
+				dropped[index] = true
+			} else {
+				indexes[index] = len(indexes)
+			}
+		}
+		if dropped[index] {
+			return false
+		}
+		if i, ok := indexes[index]; ok {
+			event["output_index"] = i
+		}
+		return true
+	})
+}
+
+func (d *responsesToolDialect) continueRequest(results []serverToolResult) ([]byte, error) {
+	var req map[string]interface{}
+	if err := json.Unmarshal(d.request, &req); err != nil {
+		return nil, err
+	}
+
+	var input []interface{}
+	switch v := req["input"].(type) {
+	case string:
+		input = []interface{}{map[string]interface{}{"role": "user", "content": v}}
+	case []interface{}:
+		input = v
+	}
+	// Reasoning items are left out: they only replay on the upstream that produced them
+	for _, item := range d.output {
+		switch item["type"] {
+		case "message":
+			input = append(input, map[string]interface{}{
+				"type": "message", "role": "assistant", "content": item["content"],
+			})
+		case "function_call":
+			input = append(input, map[string]interface{}{
+				"type": "function_call", "call_id": item["call_id"], "name": item["name"], "arguments": item["arguments"],
+			})
+		}
+	}
+	for _, result := range results {
+		input = append(input, map[string]interface{}{
+			"type": "function_call_output", "call_id": result.ID, "output": result.Content,
+		})
+	}
+	req["input"] = input
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	d.request = body
+	return body, nil
+}
@@ -0,0 +1,571 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+)
+
+const (
+	webFetchTimeout      = 30 * time.Second
+	webFetchMaxRedirects = 5
+)
+
+// serverToolSpec is the definition of a server tool offered to the model
+type serverToolSpec struct {
+	Name        string
+	Description string
+	Schema      map[string]interface{}
+}
+
+// serverToolSpecs returns the definitions of the enabled server tools
+func serverToolSpecs(cfg *config.ServerToolsConfig) []serverToolSpec {
+	var specs []serverToolSpec
+	for _, name := range cfg.Tools {
+		switch name {
+		case config.ServerToolWebFetch:
+			specs = append(specs, serverToolSpec{
+				Name: name,
+				Description: "Fetch a web page or file over HTTP(S) and return its content as text. Only these hosts can be fetched: " +
+					strings.Join(cfg.FetchAllowlist, ", "),
+				Schema: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"url": map[string]interface{}{"type": "string", "description": "The http or https URL to fetch"},
+					},
+					"required": []interface{}{"url"},
+				},
+			})
+		case config.ServerToolCalculator:
+			specs = append(specs, serverToolSpec{
+				Name: name,
+				Description: "Evaluate an arithmetic expression exactly. Supports + - * / % ^, parentheses, the functions " +
+					"sqrt, abs, floor, ceil, round, min, max, pow, exp, ln, log10, log2, sin, cos, tan, asin, acos, atan, and the constants pi and e.",
+				Schema: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"expression": map[string]interface{}{"type": "string", "description": "The expression, e.g. (2 + 3) * sqrt(16)"},
+					},
+					"required": []interface{}{"expression"},
+				},
+			})
+		case config.ServerToolSandboxRead:
+			specs = append(specs, serverToolSpec{
+				Name:        name,
+				Description: "Read a text file, or list a directory, in the sandbox directory. Paths are relative to the sandbox.",
+				Schema: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"path": map[string]interface{}{"type": "string", "description": "Path relative to the sandbox, e.g. notes/todo.md"},
+					},
+					"required": []interface{}{"path"},
+				},
+			})
+		}
+	}
+	return specs
+}
+
+// runServerTool executes a server tool call. Failures are reported to the model as error results.
+func (p *Proxy) runServerTool(ctx context.Context, cfg *config.ServerToolsConfig, call serverToolCall) serverToolResult {
+	result := serverToolResult{ID: call.ID}
+	output, err := "", call.Err
+	if err == nil {
+		switch call.Name {
+		case config.ServerToolWebFetch:
+			output, err = p.webFetch(ctx, cfg, stringArg(call.Input, "url"))
+		case config.ServerToolCalculator:
+			output, err = calculate(stringArg(call.Input, "expression"))
+		case config.ServerToolSandboxRead:
+			output, err = sandboxRead(cfg, stringArg(call.Input, "path"))
+		default:
+			err = fmt.Errorf("unknown server tool: %s", call.Name)
+		}
+	}
+
+	if err != nil {
+		logger.Info("Server tool %s failed: %v", call.Name, err)
+		result.Content, result.IsError = "Error: "+err.Error(), true
+		return result
+	}
+	logger.Info("Server tool %s executed (%d bytes)", call.Name, len(output))
+	result.Content = truncateToolResult(output, cfg.MaxResultBytesOrDefault())
+	return result
+}
+
+func stringArg(input map[string]interface{}, key string) string {
+	value, _ := input[key].(string)
+	return strings.TrimSpace(value)
+}
+
+// truncateToolResult cuts a result to the max size on a UTF-8 boundary
+func truncateToolResult(output string, maxBytes int) string {
+	if len(output) <= maxBytes {
+		return output
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8RuneStart(output[cut]) {
+		cut--
+	}
+	return output[:cut] + fmt.Sprintf("\n[truncated: %d of %d bytes shown]", cut, len(output))
+}
+
+func utf8RuneStart(b byte) bool { return b&0xC0 != 0x80 }
+
+// isPublicIP reports whether web_fetch may connect to an address. Loopback, private, link-local,
+// multicast and unspecified addresses are refused so that the model cannot reach internal services.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// checkFetchAddress refuses connections to non-public addresses. It runs on every dial, after DNS
+// resolution, so redirects and DNS rebinding cannot bypass it.
+func checkFetchAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("address %s is not public", host)
+	}
+	return nil
+}
+
+// checkFetchHost resolves a host and refuses it when any of its addresses is not public. Used when
+// fetching through a proxy, where the proxy rather than ccNexus dials the host.
+func checkFetchHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return fmt.Errorf("host %s resolves to non-public address %s", host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// webFetch fetches a URL from an allowed host. Redirects are followed only within the allowlist,
+// and hosts resolving to non-public addresses are refused.
+func (p *Proxy) webFetch(ctx context.Context, cfg *config.ServerToolsConfig, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid URL: %s", rawURL)
+	}
+	if !cfg.AllowsHost(u.Hostname()) {
+		return "", fmt.Errorf("host %s is not in the fetch allowlist", u.Hostname())
+	}
+
+	dialer := &net.Dialer{Timeout: webFetchTimeout, Control: checkFetchAddress}
+	transport := &http.Transport{DialContext: dialer.DialContext}
+	proxied := false
+	if proxyCfg := p.config.GetProxy(); proxyCfg != nil && proxyCfg.URL != "" {
+		if proxyTransport, err := CreateProxyTransport(proxyCfg.URL); err == nil {
+			transport = proxyTransport
+			proxied = true
+		}
+	}
+	if proxied {
+		if err := checkFetchHost(ctx, u.Hostname()); err != nil {
+			return "", err
+		}
+	}
+
+	client := &http.Client{
+		Timeout:   webFetchTimeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= webFetchMaxRedirects {
+				return fmt.Errorf("too many redirects")
+			}
+			if !cfg.AllowsHost(req.URL.Hostname()) {
+				return fmt.Errorf("redirect to %s is not in the fetch allowlist", req.URL.Hostname())
+			}
+			if proxied {
+				return checkFetchHost(req.Context(), req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "ccNexus")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	maxBytes := cfg.MaxResultBytesOrDefault()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxBytes)*4))
+	if err != nil {
+		return "", err
+	}
+	contentType := resp.Header.Get("Content-Type")
+	text := string(data)
+	if strings.Contains(contentType, "html") {
+		text = htmlToText(text)
+	}
+	return fmt.Sprintf("URL: %s\nContent-Type: %s\n\n%s", resp.Request.URL, contentType, text), nil
+}
+
+var (
+	htmlDropPattern  = regexp.MustCompile(`(?is)<(script|style|noscript|svg|head)\b.*?</(script|style|noscript|svg|head)>`)
+	htmlBreakPattern = regexp.MustCompile(`(?i)<(br|/p|/div|/li|/h[1-6]|/tr)\b[^>]*>`)
+	htmlTagPattern   = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLinePattern = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+`)
+	spacePattern     = regexp.MustCompile(`[ \t]+`)
+)
+
+// htmlToText reduces an HTML page to its readable text
+func htmlToText(page string) string {
+	page = htmlDropPattern.ReplaceAllString(page, "")
+	page = htmlBreakPattern.ReplaceAllString(page, "\n")
+	page = htmlTagPattern.ReplaceAllString(page, "")
+	replacer := strings.NewReplacer("&nbsp;", " ", "&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&#39;", "'")
+	page = replacer.Replace(page)
+	page = spacePattern.ReplaceAllString(page, " ")
+	page = blankLinePattern.ReplaceAllString(page, "\n\n")
+	return strings.TrimSpace(page)
+}
+
+// sandboxRead reads a file, or lists a directory, inside the sandbox directory
+func sandboxRead(cfg *config.ServerToolsConfig, path string) (string, error) {
+	root, err := filepath.Abs(cfg.SandboxDir)
+	if err != nil {
+		return "", err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return "", fmt.Errorf("sandbox directory is not available")
+	}
+
+	target, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(path)))
+	if err != nil {
+		return "", fmt.Errorf("%s not found", path)
+	}
+	// Symlinks are resolved first, so a link cannot lead out of the sandbox
+	if rel, err := filepath.Rel(root, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the sandbox", path)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return "", fmt.Errorf("%s not found", path)
+	}
+	if info.IsDir() {
+		entries, err := os.ReadDir(target)
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		for _, entry := range entries {
+			b.WriteString(entry.Name())
+			if entry.IsDir() {
+				b.WriteString("/")
+			}
+			b.WriteString("\n")
+		}
+		return b.String(), nil
+	}
+
+	f, err := os.Open(target)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, int64(cfg.MaxResultBytesOrDefault())+1))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// calculate evaluates an arithmetic expression
+func calculate(expression string) (string, error) {
+	if expression == "" {
+		return "", fmt.Errorf("empty expression")
+	}
+	c := &calculator{src: expression}
+	value, err := c.expr()
+	if err == nil && c.peek() != 0 {
+		err = fmt.Errorf("unexpected %q at position %d", c.peek(), c.pos+1)
+	}
+	if err != nil {
+		return "", err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return "", fmt.Errorf("result is not a finite number")
+	}
+	return strconv.FormatFloat(value, 'g', 15, 64), nil
+}
+
+// calculator is a recursive descent evaluator for arithmetic expressions
+type calculator struct {
+	src string
+	pos int
+}
+
+var calculatorFunctions = map[string]func(args []float64) (float64, error){
+	"sqrt":  unaryFunction(math.Sqrt),
+	"abs":   unaryFunction(math.Abs),
+	"floor": unaryFunction(math.Floor),
+	"ceil":  unaryFunction(math.Ceil),
+	"round": unaryFunction(math.Round),
+	"exp":   unaryFunction(math.Exp),
+	"ln":    unaryFunction(math.Log),
+	"log":   unaryFunction(math.Log10),
+	"log10": unaryFunction(math.Log10),
+	"log2":  unaryFunction(math.Log2),
+	"sin":   unaryFunction(math.Sin),
+	"cos":   unaryFunction(math.Cos),
+	"tan":   unaryFunction(math.Tan),
+	"asin":  unaryFunction(math.Asin),
+	"acos":  unaryFunction(math.Acos),
+	"atan":  unaryFunction(math.Atan),
+	"pow": func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("pow takes 2 arguments")
+		}
+		return math.Pow(args[0], args[1]), nil
+	},
+	"min": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("min needs arguments")
+		}
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Min(m, a)
+		}
+		return m, nil
+	},
+	"max": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("max needs arguments")
+		}
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Max(m, a)
+		}
+		return m, nil
+	},
+}
+
+func unaryFunction(fn func(float64) float64) func(args []float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("function takes 1 argument")
+		}
+		return fn(args[0]), nil
+	}
+}
+
+func (c *calculator) peek() byte {
+	for c.pos < len(c.src) && (c.src[c.pos] == ' ' || c.src[c.pos] == '\t' || c.src[c.pos] == '\n') {
+		c.pos++
+	}
+	if c.pos >= len(c.src) {
+		return 0
+	}
+	return c.src[c.pos]
+}
+
+// expr := term (('+' | '-') term)*
+func (c *calculator) expr() (float64, error) {
+	left, err := c.term()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := c.peek()
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		c.pos++
+		right, err := c.term()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			left += right
+		} else {
+			left -= right
+		}
+	}
+}
+
+// term := unary (('*' | '/' | '%') unary)*
+func (c *calculator) term() (float64, error) {
+	left, err := c.unary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := c.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return left, nil
+		}
+		if op == '*' && c.pos+1 < len(c.src) && c.src[c.pos+1] == '*' {
+			return left, nil // ** is a power, handled by power
+		}
+		c.pos++
+		right, err := c.unary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '*':
+			left *= right
+		case '/':
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			left /= right
+		case '%':
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			left = math.Mod(left, right)
+		}
+	}
+}
+
+// unary := ('-' | '+') unary | power
+func (c *calculator) unary() (float64, error) {
+	switch c.peek() {
+	case '-':
+		c.pos++
+		v, err := c.unary()
+		return -v, err
+	case '+':
+		c.pos++
+		return c.unary()
+	}
+	return c.power()
+}
+
+// power := primary (('^' | '**') unary)?
+func (c *calculator) power() (float64, error) {
+	base, err := c.primary()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c.peek() == '^':
+		c.pos++
+	case c.peek() == '*' && c.pos+1 < len(c.src) && c.src[c.pos+1] == '*':
+		c.pos += 2
+	default:
+		return base, nil
+	}
+	exponent, err := c.unary()
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(base, exponent), nil
+}
+
+// primary := number | constant | function '(' args ')' | '(' expr ')'
+func (c *calculator) primary() (float64, error) {
+	ch := c.peek()
+	switch {
+	case ch == 0:
+		return 0, fmt.Errorf("unexpected end of expression")
+	case ch == '(':
+		c.pos++
+		v, err := c.expr()
+		if err != nil {
+			return 0, err
+		}
+		if c.peek() != ')' {
+			return 0, fmt.Errorf("missing )")
+		}
+		c.pos++
+		return v, nil
+	case ch >= '0' && ch <= '9' || ch == '.':
+		start := c.pos
+		for c.pos < len(c.src) && (c.src[c.pos] >= '0' && c.src[c.pos] <= '9' || c.src[c.pos] == '.' || c.src[c.pos] == '_') {
+			c.pos++
+		}
+		// Exponent, e.g. 1.5e-3
+		if c.pos < len(c.src) && (c.src[c.pos] == 'e' || c.src[c.pos] == 'E') {
+			next := c.pos + 1
+			if next < len(c.src) && (c.src[next] == '+' || c.src[next] == '-') {
+				next++
+			}
+			if next < len(c.src) && c.src[next] >= '0' && c.src[next] <= '9' {
+				c.pos = next
+				for c.pos < len(c.src) && c.src[c.pos] >= '0' && c.src[c.pos] <= '9' {
+					c.pos++
+				}
+			}
+		}
+		v, err := strconv.ParseFloat(strings.ReplaceAll(c.src[start:c.pos], "_", ""), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", c.src[start:c.pos])
+		}
+		return v, nil
+	case unicode.IsLetter(rune(ch)):
+		start := c.pos
+		for c.pos < len(c.src) && (unicode.IsLetter(rune(c.src[c.pos])) || c.src[c.pos] >= '0' && c.src[c.pos] <= '9') {
+			c.pos++
+		}
+		name := strings.ToLower(c.src[start:c.pos])
+		switch name {
+		case "pi":
+			return math.Pi, nil
+		case "e":
+			return math.E, nil
+		}
+		fn, ok := calculatorFunctions[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown name %q", name)
+		}
+		if c.peek() != '(' {
+			return 0, fmt.Errorf("missing ( after %s", name)
+		}
+		c.pos++
+		var args []float64
+		if c.peek() != ')' {
+			for {
+				arg, err := c.expr()
+				if err != nil {
+					return 0, err
+				}
+				args = append(args, arg)
+				if c.peek() != ',' {
+					break
+				}
+				c.pos++
+			}
+		}
+		if c.peek() != ')' {
+			return 0, fmt.Errorf("missing ) after arguments of %s", name)
+		}
+		c.pos++
+		return fn(args)
+	}
+	return 0, fmt.Errorf("unexpected %q at position %d", ch, c.pos+1)
+}
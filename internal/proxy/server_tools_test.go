@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lich0821/ccNexus/internal/config"
+)
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"(1 + 2) * 3", "9"},
+		{"2 ^ 3 ^ 2", "512"},
+		{"-2 ** 2", "-4"},
+		{"10 % 4 + 7 / 2", "5.5"},
+		{"sqrt(16) + max(1, 5, 3)", "9"},
+		{"round(pi * 100) / 100", "3.14"},
+		{"1.5e3 + 1_000", "2500"},
+	}
+	for _, tt := range tests {
+		got, err := calculate(tt.expression)
+		if err != nil || got != tt.want {
+			t.Errorf("calculate(%q) = %q, %v; want %q", tt.expression, got, err, tt.want)
+		}
+	}
+
+	for _, expression := range []string{"", "1 +", "1 / 0", "foo(1)", "(1 + 2", "2 3"} {
+		if got, err := calculate(expression); err == nil {
+			t.Errorf("calculate(%q) = %q, want an error", expression, got)
+		}
+	}
+}
+
+func TestSandboxReadStaysInSandbox(t *testing.T) {
+	dir := t.TempDir()
+	sandbox := filepath.Join(dir, "sandbox")
+	os.MkdirAll(filepath.Join(sandbox, "notes"), 0o755)
+	os.WriteFile(filepath.Join(sandbox, "notes", "todo.md"), []byte("buy milk"), 0o644)
+	os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0o644)
+	os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(sandbox, "link.txt"))
+	cfg := &config.ServerToolsConfig{SandboxDir: sandbox}
+
+	if got, err := sandboxRead(cfg, "notes/todo.md"); err != nil || got != "buy milk" {
+		t.Errorf("sandboxRead(notes/todo.md) = %q, %v", got, err)
+	}
+	if got, err := sandboxRead(cfg, "notes"); err != nil || got != "todo.md\n" {
+		t.Errorf("sandboxRead(notes) = %q, %v", got, err)
+	}
+	for _, path := range []string{"../secret.txt", "notes/../../secret.txt", "link.txt"} {
+		if got, err := sandboxRead(cfg, path); err == nil {
+			t.Errorf("sandboxRead(%s) = %q, want an error", path, got)
+		}
+	}
+}
+
+func TestServerToolsLoop(t *testing.T) {
+	var requests []map[string]interface{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		message := map[string]interface{}{"role": "assistant", "content": "The answer is 42."}
+		finish := "stop"
+		if len(requests) == 1 {
+			message = map[string]interface{}{
+				"role": "assistant",
+				"tool_calls": []interface{}{map[string]interface{}{
+					"id": "call_1", "type": "function",
+					"function": map[string]interface{}{"name": "calculator", "arguments": `{"expression": "6 * 7"}`},
+				}},
+			}
+			finish = "tool_calls"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": "chatcmpl-1", "object": "chat.completion", "model": "gpt-test",
+			"choices": []interface{}{map[string]interface{}{"index": 0, "message": message, "finish_reason": finish}},
+			"usage":   map[string]interface{}{"prompt_tokens": 10, "completion_tokens": 5},
+		})
+	}))
+	defer upstream.Close()
+
+	p := newTestProxy(config.Endpoint{
+		Name:        "test",
+		APIUrl:      upstream.URL,
+		APIKey:      "test-key",
+		Enabled:     true,
+		Transformer: "openai",
+		Model:       "gpt-test",
+	})
+	p.config.UpdateServerTools(&config.ServerToolsConfig{Enabled: true, Tools: []string{config.ServerToolCalculator}})
+
+	body := `{"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "user", "content": "What is 6 * 7?"}]}`
+	rec := httptest.NewRecorder()
+	p.handleProxy(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "The answer is 42.") || strings.Contains(rec.Body.String(), "tool_use") {
+		t.Errorf("client response = %s", rec.Body.String())
+	}
+	if len(requests) != 2 {
+		t.Fatalf("upstream got %d requests, want 2", len(requests))
+	}
+	tools, _ := json.Marshal(requests[0]["tools"])
+	if !strings.Contains(string(tools), "calculator") {
+		t.Errorf("first request tools = %s", tools)
+	}
+	messages, _ := json.Marshal(requests[1]["messages"])
+	if !strings.Contains(string(messages), `"tool_call_id":"call_1"`) || !strings.Contains(string(messages), "42") {
+		t.Errorf("second request messages = %s", messages)
+	}
+}
+
+// mixedToolsUpstream is an OpenAI Chat upstream that calls the calculator server tool and the
+// client's read_file tool in one turn, streamed when the request asks for it
+func mixedToolsUpstream(hits *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Stream bool `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		*hits++
+		calls := []interface{}{
+			map[string]interface{}{"index": 0, "id": "call_1", "type": "function",
+				"function": map[string]interface{}{"name": "calculator", "arguments": `{"expression": "6 * 7"}`}},
+			map[string]interface{}{"index": 1, "id": "call_2", "type": "function",
+				"function": map[string]interface{}{"name": "read_file", "arguments": `{"path": "a.txt"}`}},
+		}
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, chunk := range []map[string]interface{}{
+				{"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{"role": "assistant", "content": "Checking."}}}},
+				{"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{"tool_calls": calls}}}},
+				{"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{}, "finish_reason": "tool_calls"}}},
+			} {
+				chunk["id"], chunk["model"] = "chatcmpl-1", "gpt-test"
+				data, _ := json.Marshal(chunk)
+				w.Write([]byte("data: " + string(data) + "\n\n"))
+			}
+			w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": "chatcmpl-1", "object": "chat.completion", "model": "gpt-test",
+			"choices": []interface{}{map[string]interface{}{"index": 0, "finish_reason": "tool_calls",
+				"message": map[string]interface{}{"role": "assistant", "content": "Checking.", "tool_calls": calls}}},
+			"usage": map[string]interface{}{"prompt_tokens": 10, "completion_tokens": 5},
+		})
+	}))
+}
+
+func TestServerToolCallsNeverReachTheClient(t *testing.T) {
+	hits := 0
+	upstream := mixedToolsUpstream(&hits)
+	defer upstream.Close()
+	p := newTestProxy(config.Endpoint{Name: "test", APIUrl: upstream.URL, APIKey: "k", Enabled: true, Transformer: "openai", Model: "gpt-test"})
+	p.config.UpdateServerTools(&config.ServerToolsConfig{Enabled: true, Tools: []string{config.ServerToolCalculator}})
+
+	tests := []struct {
+		name, path, body string
+		want             []string
+	}{
+		{"claude", "/v1/messages", `{"model": "claude-sonnet-4", "max_tokens": 100,
+			"tools": [{"name": "read_file", "input_schema": {"type": "object"}}], "messages": [{"role": "user", "content": "Go"}]}`,
+			[]string{`"name":"read_file"`, `"stop_reason":"tool_use"`}},
+		{"claude stream", "/v1/messages", `{"model": "claude-sonnet-4", "max_tokens": 100, "stream": true,
+			"tools": [{"name": "read_file", "input_schema": {"type": "object"}}], "messages": [{"role": "user", "content": "Go"}]}`,
+			[]string{`"name":"read_file"`, `"content_block":{"id":"call_2","input":{},"name":"read_file","type":"tool_use"},"index":1`}},
+		{"chat", "/v1/chat/completions", `{"model": "gpt-test",
+			"tools": [{"type": "function", "function": {"name": "read_file", "parameters": {"type": "object"}}}], "messages": [{"role": "user", "content": "Go"}]}`,
+			[]string{`"name":"read_file"`, `"finish_reason":"tool_calls"`}},
+		{"chat stream", "/v1/chat/completions", `{"model": "gpt-test", "stream": true,
+			"tools": [{"type": "function", "function": {"name": "read_file", "parameters": {"type": "object"}}}], "messages": [{"role": "user", "content": "Go"}]}`,
+			[]string{`"name":"read_file"`, `"index":0`, "data: [DONE]"}},
+	}
+	for _, tt := range tests {
+		hits = 0
+		rec := httptest.NewRecorder()
+		p.handleProxy(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || hits != 1 {
+			t.Fatalf("%s: status = %d after %d upstream requests, body %s", tt.name, rec.Code, hits, body)
+		}
+		if strings.Contains(body, "calculator") || strings.Contains(body, "call_1") {
+			t.Errorf("%s: the server tool call reached the client: %s", tt.name, body)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: expected %s in %s", tt.name, want, body)
+			}
+		}
+	}
+}
+
+func TestWebFetchRefusesInternalAddresses(t *testing.T) {
+	fetched := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		w.Write([]byte("internal"))
+	}))
+	defer target.Close()
+
+	p := newTestProxy()
+	cfg := &config.ServerToolsConfig{Tools: []string{config.ServerToolWebFetch}, FetchAllowlist: []string{"*"}}
+	port := target.URL[strings.LastIndex(target.URL, ":"):]
+	for _, rawURL := range []string{target.URL, "http://localhost" + port} {
+		if _, err := p.webFetch(context.Background(), cfg, rawURL); err == nil || !strings.Contains(err.Error(), "not public") {
+			t.Errorf("Fetching %s: err = %v, want a non-public address error", rawURL, err)
+		}
+	}
+	if fetched {
+		t.Error("The internal server should not have been reached")
+	}
+
+	for ip, want := range map[string]bool{
+		"127.0.0.1": false, "10.1.2.3": false, "192.168.0.1": false, "169.254.169.254": false, "0.0.0.0": false,
+		"::1": false, "fe80::1": false, "fd00::1": false, "::ffff:127.0.0.1": false, "8.8.8.8": true, "2606:4700::1111": true,
+	} {
+		if got := isPublicIP(net.ParseIP(ip)); got != want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
@@ -15,6 +15,8 @@ type DailyStats struct {
 	Errors       int    `json:"errors"`
 	InputTokens  int    `json:"inputTokens"`
 	OutputTokens int    `json:"outputTokens"`
+	CacheHits    int    `json:"cacheHits"`   // Requests served from the response cache
+	SavedTokens  int    `json:"savedTokens"` // Tokens cache hits did not spend upstream
 }
 
 // EndpointStats represents statistics for a single endpoint
@@ -23,6 +25,8 @@ type EndpointStats struct {
 	Errors       int                    `json:"errors"`       // Computed from DailyHistory
 	InputTokens  int                    `json:"inputTokens"`  // Computed from DailyHistory
 	OutputTokens int                    `json:"outputTokens"` // Computed from DailyHistory
+	CacheHits    int                    `json:"cacheHits"`    // Computed from DailyHistory
+	SavedTokens  int                    `json:"savedTokens"`  // Computed from DailyHistory
 	LastUsed     time.Time              `json:"lastUsed"`
 	DailyHistory map[string]*DailyStats `json:"dailyHistory"` // Key: date string (source of truth)
 }
@@ -32,8 +36,22 @@ type StatsStorage interface {
 	RecordDailyStat(stat interface{}) error
 	GetTotalStats() (int, map[string]interface{}, error)
 	GetDailyStats(endpointName, startDate, endDate string) ([]interface{}, error)
+	DownsampleUsageStats(beforeDate string) (int, error)
 }
 
+// UsageLabels describe a request for the hourly usage rollups
+type UsageLabels struct {
+	ClientModel   string // Model the client asked for
+	UpstreamModel string // Model sent to the endpoint
+	ClientFormat  ClientFormat
+}
+
+// DefaultHourlyStatsRetentionDays is how long hourly usage is kept before it is downsampled to days
+const DefaultHourlyStatsRetentionDays = 7
+
+// usageDownsampleInterval is how often hourly usage older than the retention is downsampled
+const usageDownsampleInterval = time.Hour
+
 // StatRecord represents a stat record for storage
 type StatRecord struct {
 	EndpointName string
@@ -42,7 +60,15 @@ type StatRecord struct {
 	Errors       int
 	InputTokens  int
 	OutputTokens int
+	CacheHits    int
+	SavedTokens  int
 	DeviceID     string
+
+	// Hourly usage rollup; empty Hour records the daily stat only
+	Hour          string
+	ClientModel   string
+	UpstreamModel string
+	ClientFormat  string
 }
 
 // StatsData represents aggregated stats data
@@ -51,6 +77,8 @@ type StatsData struct {
 	Errors       int
 	InputTokens  int64
 	OutputTokens int64
+	CacheHits    int
+	SavedTokens  int64
 }
 
 // DailyRecord represents daily stats
@@ -60,6 +88,8 @@ type DailyRecord struct {
 	Errors       int
 	InputTokens  int
 	OutputTokens int
+	CacheHits    int
+	SavedTokens  int
 }
 
 // Stats represents overall proxy statistics
@@ -74,6 +104,11 @@ type Stats struct {
 	saveMu        sync.Mutex
 	saveDebounce  time.Duration
 	lastSaveError error
+
+	// Hourly usage downsampling
+	retentionDays  func() int
+	downsampleMu   sync.Mutex
+	downsampleStop chan struct{}
 }
 
 // NewStats creates a new Stats instance
@@ -85,63 +120,133 @@ func NewStats(storage StatsStorage, deviceID string) *Stats {
 	}
 }
 
-// RecordRequest records a request for an endpoint
-func (s *Stats) RecordRequest(endpointName string) {
-	date := time.Now().Format("2006-01-02")
+// SetHourlyRetention sets the function returning how many days hourly usage is kept
+func (s *Stats) SetHourlyRetention(days func() int) {
+	s.downsampleMu.Lock()
+	defer s.downsampleMu.Unlock()
+	s.retentionDays = days
+}
+
+// record stores a stat record with the hourly usage labels
+func (s *Stats) record(stat *StatRecord, labels UsageLabels) error {
+	now := time.Now()
+	stat.Date = now.Format("2006-01-02")
+	stat.Hour = now.Format("2006-01-02 15")
+	stat.ClientModel = labels.ClientModel
+	stat.UpstreamModel = labels.UpstreamModel
+	stat.ClientFormat = string(labels.ClientFormat)
+	stat.DeviceID = s.deviceID
+	return s.storage.RecordDailyStat(stat)
+}
+
+// StartDownsampling folds hourly usage older than the retention into days now and then every
+// usageDownsampleInterval in the background, until StopDownsampling is called
+func (s *Stats) StartDownsampling() {
+	s.downsampleMu.Lock()
+	defer s.downsampleMu.Unlock()
+	if s.downsampleStop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	s.downsampleStop = stop
+
+	go func() {
+		ticker := time.NewTicker(usageDownsampleInterval)
+		defer ticker.Stop()
+		s.downsampleUsage(time.Now())
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				s.downsampleUsage(now)
+			}
+		}
+	}()
+}
+
+// StopDownsampling stops the background downsampling started by StartDownsampling
+func (s *Stats) StopDownsampling() {
+	s.downsampleMu.Lock()
+	defer s.downsampleMu.Unlock()
+	if s.downsampleStop != nil {
+		close(s.downsampleStop)
+		s.downsampleStop = nil
+	}
+}
 
+// downsampleUsage folds hourly usage older than the retention into days
+func (s *Stats) downsampleUsage(now time.Time) {
+	s.downsampleMu.Lock()
+	days := DefaultHourlyStatsRetentionDays
+	if s.retentionDays != nil {
+		if d := s.retentionDays(); d > 0 {
+			days = d
+		}
+	}
+	s.downsampleMu.Unlock()
+
+	before := now.AddDate(0, 0, -days).Format("2006-01-02")
+	removed, err := s.storage.DownsampleUsageStats(before)
+	if err != nil {
+		logger.Error("Failed to downsample hourly usage: %v", err)
+		return
+	}
+	if removed > 0 {
+		logger.Debug("Downsampled %d hourly usage rows before %s", removed, before)
+	}
+}
+
+// RecordRequest records a request for an endpoint
+func (s *Stats) RecordRequest(endpointName string, labels UsageLabels) {
 	stat := &StatRecord{
 		EndpointName: endpointName,
-		Date:         date,
 		Requests:     1,
-		Errors:       0,
-		InputTokens:  0,
-		OutputTokens: 0,
-		DeviceID:     s.deviceID,
 	}
 
-	if err := s.storage.RecordDailyStat(stat); err != nil {
+	if err := s.record(stat, labels); err != nil {
 		logger.Error("Failed to record request: %v", err)
 	}
 }
 
 // RecordError records an error for an endpoint
-func (s *Stats) RecordError(endpointName string) {
-	date := time.Now().Format("2006-01-02")
-
+func (s *Stats) RecordError(endpointName string, labels UsageLabels) {
 	stat := &StatRecord{
 		EndpointName: endpointName,
-		Date:         date,
-		Requests:     0,
 		Errors:       1,
-		InputTokens:  0,
-		OutputTokens: 0,
-		DeviceID:     s.deviceID,
 	}
 
-	if err := s.storage.RecordDailyStat(stat); err != nil {
+	if err := s.record(stat, labels); err != nil {
 		logger.Error("Failed to record error: %v", err)
 	}
 }
 
 // RecordTokens records token usage for an endpoint
-func (s *Stats) RecordTokens(endpointName string, inputTokens, outputTokens int) {
-	date := time.Now().Format("2006-01-02")
-
+func (s *Stats) RecordTokens(endpointName string, labels UsageLabels, inputTokens, outputTokens int) {
 	stat := &StatRecord{
 		EndpointName: endpointName,
-		Date:         date,
-		Requests:     0,
-		Errors:       0,
 		InputTokens:  inputTokens,
 		OutputTokens: outputTokens,
-		DeviceID:     s.deviceID,
 	}
 
-	if err := s.storage.RecordDailyStat(stat); err != nil {
+	if err := s.record(stat, labels); err != nil {
 		logger.Error("Failed to record tokens: %v", err)
 	}
 }
 
+// RecordCacheHit records a request served from the response cache and the tokens it saved
+func (s *Stats) RecordCacheHit(endpointName string, labels UsageLabels, savedTokens int) {
+	stat := &StatRecord{
+		EndpointName: endpointName,
+		CacheHits:    1,
+		SavedTokens:  savedTokens,
+	}
+
+	if err := s.record(stat, labels); err != nil {
+		logger.Error("Failed to record cache hit: %v", err)
+	}
+}
+
 // scheduleSave schedules a save operation with debounce to avoid frequent writes
 func (s *Stats) scheduleSave() {
 	s.saveMu.Lock()
@@ -191,6 +296,8 @@ func (s *Stats) GetStats() (int, map[string]*EndpointStats) {
 			Errors:       int(v.FieldByName("Errors").Int()),
 			InputTokens:  int(v.FieldByName("InputTokens").Int()),
 			OutputTokens: int(v.FieldByName("OutputTokens").Int()),
+			CacheHits:    int(v.FieldByName("CacheHits").Int()),
+			SavedTokens:  int(v.FieldByName("SavedTokens").Int()),
 			LastUsed:     time.Now(),
 			DailyHistory: make(map[string]*DailyStats),
 		}
@@ -259,6 +366,8 @@ func (s *Stats) GetPeriodStats(startDate, endDate string) map[string]*DailyStats
 			aggregated.Errors += int(v.FieldByName("Errors").Int())
 			aggregated.InputTokens += int(v.FieldByName("InputTokens").Int())
 			aggregated.OutputTokens += int(v.FieldByName("OutputTokens").Int())
+			aggregated.CacheHits += int(v.FieldByName("CacheHits").Int())
+			aggregated.SavedTokens += int(v.FieldByName("SavedTokens").Int())
 		}
 
 		result[endpointName] = aggregated
@@ -300,6 +409,8 @@ func (s *Stats) GetDailyStats(date string) map[string]*DailyStats {
 				Errors:       int(v.FieldByName("Errors").Int()),
 				InputTokens:  int(v.FieldByName("InputTokens").Int()),
 				OutputTokens: int(v.FieldByName("OutputTokens").Int()),
+				CacheHits:    int(v.FieldByName("CacheHits").Int()),
+				SavedTokens:  int(v.FieldByName("SavedTokens").Int()),
 			}
 		}
 	}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// downsamplingStatsStorage reports each downsampling run
+type downsamplingStatsStorage struct {
+	memoryStatsStorage
+	runs chan string
+}
+
+func (s *downsamplingStatsStorage) DownsampleUsageStats(beforeDate string) (int, error) {
+	s.runs <- beforeDate
+	return 0, nil
+}
+
+func TestUsageIsDownsampledInTheBackground(t *testing.T) {
+	storage := &downsamplingStatsStorage{runs: make(chan string, 10)}
+	stats := NewStats(storage, "test")
+	stats.SetHourlyRetention(func() int { return 3 })
+
+	// Recording a request never downsamples
+	stats.RecordRequest("a", UsageLabels{})
+	select {
+	case before := <-storage.runs:
+		t.Fatalf("recording downsampled usage before %s", before)
+	default:
+	}
+
+	stats.StartDownsampling()
+	stats.StartDownsampling()
+	select {
+	case before := <-storage.runs:
+		if want := time.Now().AddDate(0, 0, -3).Format("2006-01-02"); before != want {
+			t.Errorf("downsampled before %s, want %s", before, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("downsampling did not run on start")
+	}
+	stats.StopDownsampling()
+	stats.StopDownsampling()
+	if len(storage.runs) != 0 {
+		t.Errorf("downsampling ran %d more times", len(storage.runs))
+	}
+}
@@ -18,16 +18,25 @@ import (
 )
 
 // handleStreamingResponse processes streaming SSE responses
-func (p *Proxy) handleStreamingResponse(w http.ResponseWriter, resp *http.Response, endpoint config.Endpoint, trans transformer.Transformer, transformerName string, thinkingEnabled bool, modelName string, bodyBytes []byte) (int, int, string) {
+func (p *Proxy) handleStreamingResponse(w http.ResponseWriter, resp *http.Response, endpoint config.Endpoint, trans transformer.Transformer, transformerName string, thinkingEnabled bool, modelName string, bodyBytes []byte, repair *toolRepair) (int, int, string) {
+	// NDJSON streams (Ollama) carry one event per line and are re-emitted as SSE
+	ndjson := strings.Contains(resp.Header.Get("Content-Type"), "application/x-ndjson")
+
 	// Copy response headers except Content-Length and Content-Encoding
 	for key, values := range resp.Header {
 		if key == "Content-Length" || key == "Content-Encoding" {
 			continue
 		}
+		if ndjson && key == "Content-Type" {
+			continue
+		}
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
+	if ndjson {
+		w.Header().Set("Content-Type", "text/event-stream")
+	}
 	w.WriteHeader(resp.StatusCode)
 
 	flusher, ok := w.(http.Flusher)
@@ -53,15 +62,21 @@ func (p *Proxy) handleStreamingResponse(w http.ResponseWriter, resp *http.Respon
 	// Create stream context for all transformers except pure passthrough
 	var streamCtx *transformer.StreamContext
 	switch transformerName {
-	case "cx_chat_openai", "cx_resp_openai2":
+	case "cx_chat_openai", "cx_resp_openai2", "cx_chat_azure", "cx_resp_azure2":
 		// Pure passthrough - no context needed
 	default:
 		// cc_claude needs context for input_tokens fallback
 		streamCtx = transformer.NewStreamContext()
 		streamCtx.ModelName = modelName
-		// Pre-estimate input tokens for fallback
+		streamCtx.EnableThinking = thinkingEnabled
+		// Estimate input tokens for fallback, only if the upstream reports none
 		if bodyBytes != nil {
-			streamCtx.InputTokens = p.estimateInputTokens(bodyBytes)
+			streamCtx.InputTokensEstimate = func() int { return p.estimateInputTokens(bodyBytes) }
+		}
+		if repair != nil {
+			streamCtx.ToolRepair = true
+			streamCtx.ToolRepairAsText = repair.asText
+			streamCtx.ToolSchemas = repair.schemas
 		}
 	}
 
@@ -101,7 +116,7 @@ func (p *Proxy) handleStreamingResponse(w http.ResponseWriter, resp *http.Respon
 
 		buffer.WriteString(line + "\n")
 
-		if line == "" {
+		if line == "" || ndjson {
 			eventCount++
 			eventData := buffer.Bytes()
 			logger.DebugLog("[%s] SSE Event #%d (Original): %s", endpoint.Name, eventCount, string(eventData))
@@ -136,6 +151,9 @@ func (p *Proxy) handleStreamingResponse(w http.ResponseWriter, resp *http.Respon
 	}
 
 	resp.Body.Close()
+	if repair != nil && streamCtx != nil {
+		repair.failed = streamCtx.ToolRepairFailed
+	}
 	return inputTokens, outputTokens, outputText.String()
 }
 
@@ -151,6 +169,12 @@ func (p *Proxy) transformStreamEvent(eventData []byte, trans transformer.Transfo
 		return trans.(*cc.OpenAI2Transformer).TransformResponseWithContext(eventData, true, streamCtx)
 	case "cc_gemini":
 		return trans.(*cc.GeminiTransformer).TransformResponseWithContext(eventData, true, streamCtx)
+	case "cc_azure":
+		return trans.(*cc.AzureTransformer).TransformResponseWithContext(eventData, true, streamCtx)
+	case "cc_azure2":
+		return trans.(*cc.Azure2Transformer).TransformResponseWithContext(eventData, true, streamCtx)
+	case "cc_ollama":
+		return trans.(*cc.OllamaTransformer).TransformResponseWithContext(eventData, true, streamCtx)
 	// Codex Chat transformers
 	case "cx_chat_claude":
 		return trans.(*chat.ClaudeTransformer).TransformResponseWithContext(eventData, true, streamCtx)
@@ -160,6 +184,12 @@ func (p *Proxy) transformStreamEvent(eventData []byte, trans transformer.Transfo
 		return trans.(*chat.OpenAI2Transformer).TransformResponseWithContext(eventData, true, streamCtx)
 	case "cx_chat_gemini":
 		return trans.(*chat.GeminiTransformer).TransformResponseWithContext(eventData, true, streamCtx)
+	case "cx_chat_azure":
+		return eventData, nil // passthrough
+	case "cx_chat_azure2":
+		return trans.(*chat.Azure2Transformer).TransformResponseWithContext(eventData, true, streamCtx)
+	case "cx_chat_ollama":
+		return trans.(*chat.OllamaTransformer).TransformResponseWithContext(eventData, true, streamCtx)
 	// Codex Responses transformers
 	case "cx_resp_claude":
 		return trans.(*responses.ClaudeTransformer).TransformResponseWithContext(eventData, true, streamCtx)
@@ -169,6 +199,12 @@ func (p *Proxy) transformStreamEvent(eventData []byte, trans transformer.Transfo
 		return eventData, nil // passthrough
 	case "cx_resp_gemini":
 		return trans.(*responses.GeminiTransformer).TransformResponseWithContext(eventData, true, streamCtx)
+	case "cx_resp_azure":
+		return trans.(*responses.AzureTransformer).TransformResponseWithContext(eventData, true, streamCtx)
+	case "cx_resp_azure2":
+		return eventData, nil // passthrough
+	case "cx_resp_ollama":
+		return trans.(*responses.OllamaTransformer).TransformResponseWithContext(eventData, true, streamCtx)
 	default:
 		return trans.TransformResponse(eventData, true)
 	}
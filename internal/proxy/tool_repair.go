@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/transformer/convert"
+)
+
+// toolRepair is the tool argument repair of a single upstream attempt
+type toolRepair struct {
+	schemas map[string]interface{} // Tool input schemas from the client request, by tool name
+	asText  bool                   // Send invalid tool calls as text blocks
+	retry   bool                   // Hold the response back and retry once if a tool call is invalid
+	failed  bool                   // A tool call had invalid arguments
+}
+
+// toolRepairFor returns the tool repair for a request, or nil when it does not apply. Only
+// Claude Code requests to OpenAI Chat and Gemini upstreams are repaired. Retrying holds the whole
+// response back, so streaming requests, and requests already retried for invalid tool calls, get
+// their arguments repaired instead.
+func (p *Proxy) toolRepairFor(transformerName string, clientBody []byte, stream, retried bool) *toolRepair {
+	cfg := p.config.GetToolRepair()
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+	switch transformerName {
+	case "cc_openai", "cc_azure", "cc_gemini":
+	default:
+		return nil
+	}
+
+	schemas := convert.ToolInputSchemas(clientBody)
+	if len(schemas) == 0 {
+		return nil
+	}
+	action := cfg.ActionOrDefault()
+	return &toolRepair{
+		schemas: schemas,
+		asText:  action == config.ToolRepairActionText,
+		retry:   action == config.ToolRepairActionRetry && !stream && !retried,
+	}
+}
+
+// heldResponse buffers a response until it is known whether it has to be retried
+type heldResponse struct {
+	target http.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newHeldResponse(target http.ResponseWriter) *heldResponse {
+	return &heldResponse{target: target, header: make(http.Header)}
+}
+
+func (h *heldResponse) Header() http.Header { return h.header }
+
+func (h *heldResponse) WriteHeader(status int) {
+	if h.status == 0 {
+		h.status = status
+	}
+}
+
+func (h *heldResponse) Write(data []byte) (int, error) {
+	if h.status == 0 {
+		h.status = http.StatusOK
+	}
+	return h.body.Write(data)
+}
+
+// Flush is a no-op; the response is sent by release
+func (h *heldResponse) Flush() {}
+
+// release sends the held response to the client
+func (h *heldResponse) release() {
+	for key, values := range h.header {
+		for _, value := range values {
+			h.target.Header().Add(key, value)
+		}
+	}
+	if h.status != 0 {
+		h.target.WriteHeader(h.status)
+	}
+	h.target.Write(h.body.Bytes())
+	if flusher, ok := h.target.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lich0821/ccNexus/internal/config"
+)
+
+// badThenGoodToolUpstream is an OpenAI Chat upstream whose first tool call has arguments that are
+// not valid JSON and whose later ones are valid, streamed when the request asks for it
+func badThenGoodToolUpstream(hits *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Stream bool `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		*hits++
+		args := `{"city": "Paris"`
+		if *hits > 1 {
+			args = `{"city": "Paris"}`
+		}
+
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			call, _ := json.Marshal(map[string]interface{}{
+				"index": 0, "id": "call_1", "type": "function",
+				"function": map[string]interface{}{"name": "get_weather", "arguments": args},
+			})
+			fmt.Fprintf(w, "data: {\"id\":\"chatcmpl-1\",\"model\":\"gpt-test\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"tool_calls\":[%s]}}]}\n\n", call)
+			fmt.Fprint(w, "data: {\"id\":\"chatcmpl-1\",\"model\":\"gpt-test\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"tool_calls\"}],\"usage\":{\"prompt_tokens\":10,\"completion_tokens\":5}}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": "chatcmpl-1", "object": "chat.completion", "model": "gpt-test",
+			"choices": []interface{}{map[string]interface{}{"index": 0, "finish_reason": "tool_calls", "message": map[string]interface{}{
+				"role": "assistant",
+				"tool_calls": []interface{}{map[string]interface{}{
+					"id": "call_1", "type": "function",
+					"function": map[string]interface{}{"name": "get_weather", "arguments": args},
+				}},
+			}}},
+			"usage": map[string]interface{}{"prompt_tokens": 10, "completion_tokens": 5},
+		})
+	}))
+}
+
+func newToolRepairTestProxy(upstreamURL string) *Proxy {
+	p := newTestProxy(config.Endpoint{Name: "test", APIUrl: upstreamURL, APIKey: "k", Enabled: true, Transformer: "openai", Model: "gpt-test"})
+	p.config.UpdateToolRepair(&config.ToolRepairConfig{Enabled: true, Action: config.ToolRepairActionRetry})
+	return p
+}
+
+func toolRepairRequest(stream bool) string {
+	return fmt.Sprintf(`{"model": "claude-sonnet-4", "max_tokens": 100, "stream": %t,
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}}],
+		"messages": [{"role": "user", "content": "Weather in Paris?"}]}`, stream)
+}
+
+func TestToolRepairRetriesInvalidToolCallOnce(t *testing.T) {
+	hits := 0
+	upstream := badThenGoodToolUpstream(&hits)
+	defer upstream.Close()
+	p := newToolRepairTestProxy(upstream.URL)
+
+	rec := httptest.NewRecorder()
+	p.handleProxy(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(toolRepairRequest(false))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	if hits != 2 {
+		t.Fatalf("upstream got %d requests, want one retry", hits)
+	}
+	var resp struct {
+		Content []struct {
+			Type  string                 `json:"type"`
+			Input map[string]interface{} `json:"input"`
+		} `json:"content"`
+		StopReason string `json:"stop_reason"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Expected a single JSON response, got %s", rec.Body.String())
+	}
+	if len(resp.Content) != 1 || resp.Content[0].Type != "tool_use" || resp.Content[0].Input["city"] != "Paris" || resp.StopReason != "tool_use" {
+		t.Errorf("Expected the retried tool call, got %s", rec.Body.String())
+	}
+}
+
+func TestToolRepairStreamsWithoutRetrying(t *testing.T) {
+	hits := 0
+	upstream := badThenGoodToolUpstream(&hits)
+	defer upstream.Close()
+	p := newToolRepairTestProxy(upstream.URL)
+
+	// Retrying would hold the whole stream back, so streamed tool calls are repaired instead
+	rec := httptest.NewRecorder()
+	p.handleProxy(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(toolRepairRequest(true))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	if hits != 1 {
+		t.Fatalf("upstream got %d requests, want no retry", hits)
+	}
+	body := rec.Body.String()
+	if strings.Count(body, "event: message_start") != 1 || !strings.Contains(body, `"type":"tool_use"`) || !strings.Contains(body, `{\"city\":\"Paris\"}`) {
+		t.Errorf("Expected one stream with the repaired tool call, got %s", body)
+	}
+}
@@ -17,11 +17,49 @@ func normalizeAPIUrl(apiUrl string) string {
 	return apiUrl
 }
 
-// shouldRetry determines if a response should trigger a retry
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// shouldRetry determines if a response should trigger a retry. Oversized requests are not retried,
+// since every endpoint with the same window would reject them again.
 func shouldRetry(statusCode int) bool {
 	return statusCode != http.StatusOK &&
 		statusCode != http.StatusBadRequest &&
-		statusCode != http.StatusUnauthorized
+		statusCode != http.StatusUnauthorized &&
+		statusCode != http.StatusRequestEntityTooLarge
+}
+
+// contextLengthMarkers are phrases upstream APIs use to reject input beyond the context window
+var contextLengthMarkers = []string{
+	"context_length_exceeded",
+	"context length",
+	"context window",
+	"maximum context",
+	"prompt is too long",
+	"input is too long",
+	"too many tokens",
+	"exceeds the maximum number of tokens",
+}
+
+// isContextLengthError reports whether an upstream error rejected the request for its size
+func isContextLengthError(statusCode int, body []byte) bool {
+	if statusCode == http.StatusRequestEntityTooLarge {
+		return true
+	}
+	if statusCode != http.StatusBadRequest {
+		return false
+	}
+	msg := strings.ToLower(string(body))
+	for _, marker := range contextLengthMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
 }
 
 // cleanIncompleteToolCalls removes incomplete tool_use blocks from request
@@ -101,16 +139,16 @@ func (p *Proxy) estimateInputTokens(bodyBytes []byte) int {
 
 // estimateTokens estimates tokens when API doesn't provide usage
 func (p *Proxy) estimateTokens(bodyBytes []byte, outputText string, inputTokens, outputTokens int, endpointName string) (int, int) {
-	if inputTokens == 0 {
-		var req tokencount.CountTokensRequest
-		if json.Unmarshal(bodyBytes, &req) == nil {
-			inputTokens = tokencount.EstimateInputTokens(&req)
-			logger.Debug("[%s] Estimated input tokens: %d", endpointName, inputTokens)
-		}
+	var req tokencount.CountTokensRequest
+	parsed := json.Unmarshal(bodyBytes, &req) == nil
+
+	if inputTokens == 0 && parsed {
+		inputTokens = tokencount.EstimateInputTokens(&req)
+		logger.Debug("[%s] Estimated input tokens: %d", endpointName, inputTokens)
 	}
 
 	if outputTokens == 0 && outputText != "" {
-		outputTokens = tokencount.EstimateOutputTokens(outputText)
+		outputTokens = tokencount.EstimateOutputTokens(req.Model, outputText)
 		logger.Debug("[%s] Estimated output tokens: %d", endpointName, outputTokens)
 	}
 
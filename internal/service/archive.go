@@ -3,20 +3,195 @@ package service
 import (
     "encoding/json"
     "fmt"
+    "sort"
+    "sync"
     "time"
 
+    "github.com/lich0821/ccNexus/internal/config"
     "github.com/lich0821/ccNexus/internal/logger"
     "github.com/lich0821/ccNexus/internal/storage"
 )
 
-// ArchiveService handles archive data operations
+// archiveInterval is how often old months are rolled up
+const archiveInterval = 24 * time.Hour
+
+// ArchiveStatus is the state of the monthly rollup job
+type ArchiveStatus struct {
+    Enabled     bool       `json:"enabled"`
+    Running     bool       `json:"running"`
+    LastRun     *time.Time `json:"lastRun,omitempty"`
+    LastSuccess bool       `json:"lastSuccess"`
+    LastError   string     `json:"lastError,omitempty"`
+    Months      []string   `json:"months,omitempty"` // Months rolled up by the last run
+    NextRun     *time.Time `json:"nextRun,omitempty"`
+}
+
+// ArchiveService handles archive data operations and rolls daily stats older than the configured
+// number of months into monthly rollups
 type ArchiveService struct {
-    storage *storage.SQLiteStorage
+    config  *config.Config
+    storage storage.Storage
+
+    mu      sync.Mutex
+    status  ArchiveStatus
+    started bool
+    wake    chan struct{}
+    stop    chan struct{}
+    done    chan struct{}
 }
 
 // NewArchiveService creates a new ArchiveService
-func NewArchiveService(s *storage.SQLiteStorage) *ArchiveService {
-    return &ArchiveService{storage: s}
+func NewArchiveService(cfg *config.Config, s storage.Storage) *ArchiveService {
+    return &ArchiveService{config: cfg, storage: s, wake: make(chan struct{}, 1)}
+}
+
+// Start begins rolling up old months in the background
+func (a *ArchiveService) Start() {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if a.started {
+        return
+    }
+    a.started = true
+    a.stop = make(chan struct{})
+    a.done = make(chan struct{})
+    go a.loop()
+}
+
+// Stop ends the rollup job, waiting for a running rollup to finish
+func (a *ArchiveService) Stop() {
+    a.mu.Lock()
+    if !a.started {
+        a.mu.Unlock()
+        return
+    }
+    a.started = false
+    close(a.stop)
+    done := a.done
+    a.mu.Unlock()
+    <-done
+}
+
+// GetConfig returns the stats archive configuration as JSON
+func (a *ArchiveService) GetConfig() string {
+    data, _ := json.Marshal(a.config.GetStatsArchive())
+    return string(data)
+}
+
+// SetConfig updates the stats archive configuration from JSON; an empty string restores the default
+func (a *ArchiveService) SetConfig(configJSON string) error {
+    cfg, err := config.DecodeStatsArchive(configJSON)
+    if err != nil {
+        return err
+    }
+    a.config.UpdateStatsArchive(cfg)
+    if a.storage != nil {
+        configAdapter := storage.NewConfigStorageAdapter(a.storage)
+        if err := a.config.SaveToStorage(configAdapter); err != nil {
+            return fmt.Errorf("failed to save config: %w", err)
+        }
+    }
+    a.notify()
+    return nil
+}
+
+// GetStatus returns the rollup status as JSON
+func (a *ArchiveService) GetStatus() string {
+    data, _ := json.Marshal(a.Status())
+    return string(data)
+}
+
+// Status returns the rollup status
+func (a *ArchiveService) Status() ArchiveStatus {
+    a.mu.Lock()
+    st := a.status
+    a.mu.Unlock()
+    st.Enabled = a.config.GetStatsArchive().Enabled
+    return st
+}
+
+// RunNow rolls up old months immediately, even if the job is disabled
+func (a *ArchiveService) RunNow() error {
+    return a.run(*a.config.GetStatsArchive(), time.Now())
+}
+
+// notify wakes the loop to roll up with a changed config
+func (a *ArchiveService) notify() {
+    select {
+    case a.wake <- struct{}{}:
+    default:
+    }
+}
+
+func (a *ArchiveService) loop() {
+    defer close(a.done)
+    for {
+        wait := archiveInterval
+        if cfg := a.config.GetStatsArchive(); cfg.Enabled {
+            a.run(*cfg, time.Now())
+            next := time.Now().Add(wait)
+            a.mu.Lock()
+            a.status.NextRun = &next
+            a.mu.Unlock()
+        } else {
+            a.mu.Lock()
+            a.status.NextRun = nil
+            a.mu.Unlock()
+        }
+
+        timer := time.NewTimer(wait)
+        select {
+        case <-a.stop:
+            timer.Stop()
+            return
+        case <-a.wake:
+            timer.Stop()
+        case <-timer.C:
+        }
+    }
+}
+
+// run rolls up the months before the ones the config keeps as daily stats
+func (a *ArchiveService) run(cfg config.StatsArchiveConfig, now time.Time) error {
+    if a.storage == nil {
+        return fmt.Errorf("storage_not_initialized")
+    }
+    a.mu.Lock()
+    if a.status.Running {
+        a.mu.Unlock()
+        return fmt.Errorf("stats_archive_running")
+    }
+    a.status.Running = true
+    a.mu.Unlock()
+
+    months, err := a.storage.RollupMonthlyStats(ArchiveBeforeMonth(cfg.AfterMonths, now), cfg.PruneDaily)
+    if err != nil {
+        logger.Error("Failed to roll up monthly stats: %v", err)
+    } else if len(months) > 0 {
+        logger.Info("Rolled up the stats of %d months (prune dailies: %v)", len(months), cfg.PruneDaily)
+    }
+
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    finished := time.Now()
+    a.status.Running = false
+    a.status.LastRun = &finished
+    a.status.LastSuccess = err == nil
+    a.status.LastError = ""
+    if err != nil {
+        a.status.LastError = err.Error()
+    }
+    a.status.Months = months
+    return err
+}
+
+// ArchiveBeforeMonth returns the first month (2006-01) kept as daily stats when the last
+// afterMonths months, including the current one, are kept
+func ArchiveBeforeMonth(afterMonths int, now time.Time) string {
+    if afterMonths < 1 {
+        afterMonths = config.DefaultStatsArchiveAfterMonths
+    }
+    return time.Date(now.Year(), now.Month()-time.Month(afterMonths-1), 1, 0, 0, 0, 0, now.Location()).Format("2006-01")
 }
 
 // ListArchives returns a list of all available archive months
@@ -51,7 +226,8 @@ func (a *ArchiveService) ListArchives() string {
     return string(data)
 }
 
-// GetArchiveData returns archived data for a specific month
+// GetArchiveData returns archived data for a specific month. Totals come from the monthly
+// rollups; the daily history is only available while the month keeps its daily stats.
 func (a *ArchiveService) GetArchiveData(month string) string {
     if a.storage == nil {
         result := map[string]interface{}{
@@ -62,28 +238,67 @@ func (a *ArchiveService) GetArchiveData(month string) string {
         return string(data)
     }
 
-    archiveData, err := a.storage.GetMonthlyArchiveData(month)
-    if err != nil {
-        logger.Error("Failed to get archive data for %s: %v", month, err)
-        result := map[string]interface{}{
-            "success": false,
-            "message": fmt.Sprintf("Failed to load archive: %v", err),
+    monthly, err := a.storage.GetMonthlyStats(month)
+    if err == nil {
+        var dailies []storage.MonthlyArchiveData
+        dailies, err = a.storage.GetMonthlyArchiveData(month)
+        if err == nil {
+            return archiveResult(monthly, dailies)
         }
-        data, _ := json.Marshal(result)
-        return string(data)
     }
 
-    endpoints := make(map[string]map[string]interface{})
-    var totalRequests, totalErrors, totalInputTokens, totalOutputTokens int
+    logger.Error("Failed to get archive data for %s: %v", month, err)
+    result := map[string]interface{}{
+        "success": false,
+        "message": fmt.Sprintf("Failed to load archive: %v", err),
+    }
+    data, _ := json.Marshal(result)
+    return string(data)
+}
 
-    for _, record := range archiveData {
-        if endpoints[record.EndpointName] == nil {
-            endpoints[record.EndpointName] = map[string]interface{}{
+func archiveResult(monthly []storage.MonthlyStat, dailies []storage.MonthlyArchiveData) string {
+    endpoints := make(map[string]map[string]interface{})
+    endpoint := func(name string) map[string]interface{} {
+        if endpoints[name] == nil {
+            endpoints[name] = map[string]interface{}{
                 "dailyHistory": make(map[string]interface{}),
+                "models":       make(map[string]interface{}),
+                "requests":     int64(0),
+                "errors":       int64(0),
+                "inputTokens":  int64(0),
+                "outputTokens": int64(0),
             }
         }
+        return endpoints[name]
+    }
 
-        dailyHistory := endpoints[record.EndpointName]["dailyHistory"].(map[string]interface{})
+    var totalRequests, totalErrors, totalInputTokens, totalOutputTokens int64
+    for _, stat := range monthly {
+        ep := endpoint(stat.EndpointName)
+        ep["requests"] = ep["requests"].(int64) + stat.Requests
+        ep["errors"] = ep["errors"].(int64) + stat.Errors
+        ep["inputTokens"] = ep["inputTokens"].(int64) + stat.InputTokens
+        ep["outputTokens"] = ep["outputTokens"].(int64) + stat.OutputTokens
+
+        models := ep["models"].(map[string]interface{})
+        model, _ := models[stat.Model].(map[string]int64)
+        if model == nil {
+            model = map[string]int64{}
+            models[stat.Model] = model
+        }
+        model["requests"] += stat.Requests
+        model["errors"] += stat.Errors
+        model["inputTokens"] += stat.InputTokens
+        model["outputTokens"] += stat.OutputTokens
+
+        totalRequests += stat.Requests
+        totalErrors += stat.Errors
+        totalInputTokens += stat.InputTokens
+        totalOutputTokens += stat.OutputTokens
+    }
+
+    for _, record := range dailies {
+        dailyHistory := endpoint(record.EndpointName)["dailyHistory"].(map[string]interface{})
         dailyHistory[record.Date] = map[string]interface{}{
             "date":         record.Date,
             "requests":     record.Requests,
@@ -91,11 +306,6 @@ func (a *ArchiveService) GetArchiveData(month string) string {
             "inputTokens":  record.InputTokens,
             "outputTokens": record.OutputTokens,
         }
-
-        totalRequests += record.Requests
-        totalErrors += record.Errors
-        totalInputTokens += record.InputTokens
-        totalOutputTokens += record.OutputTokens
     }
 
     summary := map[string]interface{}{
@@ -103,6 +313,7 @@ func (a *ArchiveService) GetArchiveData(month string) string {
         "totalErrors":       totalErrors,
         "totalInputTokens":  totalInputTokens,
         "totalOutputTokens": totalOutputTokens,
+        "models":            archiveModels(monthly),
     }
 
     archive := map[string]interface{}{
@@ -119,6 +330,29 @@ func (a *ArchiveService) GetArchiveData(month string) string {
     return string(data)
 }
 
+// archiveModels returns the requests of every model of a month, most used first
+func archiveModels(monthly []storage.MonthlyStat) []map[string]interface{} {
+    requests := make(map[string]int64)
+    for _, stat := range monthly {
+        requests[stat.Model] += stat.Requests
+    }
+    models := make([]string, 0, len(requests))
+    for model := range requests {
+        models = append(models, model)
+    }
+    sort.Slice(models, func(i, j int) bool {
+        if requests[models[i]] != requests[models[j]] {
+            return requests[models[i]] > requests[models[j]]
+        }
+        return models[i] < models[j]
+    })
+    result := make([]map[string]interface{}, 0, len(models))
+    for _, model := range models {
+        result = append(result, map[string]interface{}{"model": model, "requests": requests[model]})
+    }
+    return result
+}
+
 // GetArchiveTrend returns trend comparison between selected month and previous month
 func (a *ArchiveService) GetArchiveTrend(month string) string {
     if a.storage == nil {
@@ -142,7 +376,7 @@ func (a *ArchiveService) GetArchiveTrend(month string) string {
 
     previousMonth := t.AddDate(0, -1, 0).Format("2006-01")
 
-    currentData, err := a.storage.GetMonthlyArchiveData(month)
+    currentData, err := a.storage.GetMonthlyStats(month)
     if err != nil {
         logger.Error("Failed to get current month data: %v", err)
         result := map[string]interface{}{
@@ -153,8 +387,8 @@ func (a *ArchiveService) GetArchiveTrend(month string) string {
         return string(data)
     }
 
-    previousData, err := a.storage.GetMonthlyArchiveData(previousMonth)
-    if err != nil {
+    previousData, err := a.storage.GetMonthlyStats(previousMonth)
+    if err != nil || len(previousData) == 0 {
         logger.Debug("Previous month %s has no data, returning flat trend", previousMonth)
         result := map[string]interface{}{
             "success":     true,
@@ -168,16 +402,16 @@ func (a *ArchiveService) GetArchiveTrend(month string) string {
 
     var currentRequests, currentErrors, currentTokens int
     for _, record := range currentData {
-        currentRequests += record.Requests
-        currentErrors += record.Errors
-        currentTokens += record.InputTokens + record.OutputTokens
+        currentRequests += int(record.Requests)
+        currentErrors += int(record.Errors)
+        currentTokens += int(record.InputTokens + record.OutputTokens)
     }
 
     var previousRequests, previousErrors, previousTokens int
     for _, record := range previousData {
-        previousRequests += record.Requests
-        previousErrors += record.Errors
-        previousTokens += record.InputTokens + record.OutputTokens
+        previousRequests += int(record.Requests)
+        previousErrors += int(record.Errors)
+        previousTokens += int(record.InputTokens + record.OutputTokens)
     }
 
     requestsTrend := calculateTrend(currentRequests, previousRequests)
@@ -195,17 +429,7 @@ func (a *ArchiveService) GetArchiveTrend(month string) string {
     return string(data)
 }
 
-// GenerateMockArchives is deprecated - returns error message
-func (a *ArchiveService) GenerateMockArchives(monthsCount int) string {
-    result := map[string]interface{}{
-        "success": false,
-        "message": "Mock archives are no longer supported. Use real data from SQLite.",
-    }
-    data, _ := json.Marshal(result)
-    return string(data)
-}
-
-// DeleteArchive deletes all data for a specific month
+// DeleteArchive deletes all data for a specific month, including its rollups
 func (a *ArchiveService) DeleteArchive(month string) string {
     if a.storage == nil {
         result := map[string]interface{}{
@@ -2,23 +2,24 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
 	"github.com/lich0821/ccNexus/internal/storage"
 )
 
+// BackupService backs up to and restores from the registered BackupProviders
 type BackupService struct {
 	config  *config.Config
-	storage *storage.SQLiteStorage
+	storage storage.Storage
 	version string
-
-	webdav *WebDAVService
 }
 
-func NewBackupService(cfg *config.Config, s *storage.SQLiteStorage, version string, webdav *WebDAVService) *BackupService {
-	return &BackupService{config: cfg, storage: s, version: version, webdav: webdav}
+func NewBackupService(cfg *config.Config, s storage.Storage, version string) *BackupService {
+	return &BackupService{config: cfg, storage: s, version: version}
 }
 
 func (b *BackupService) UpdateBackupProvider(provider string) error {
@@ -87,113 +88,165 @@ func (b *BackupService) UpdateS3BackupConfig(endpoint, region, bucket, prefix, a
 	return b.saveConfig()
 }
 
+func (b *BackupService) UpdateSFTPBackupConfig(host string, port int, username, password, privateKey, passphrase, dir, hostKey string) error {
+	sftpCfg := normalizeSFTPBackupConfig(host, port, username, password, privateKey, passphrase, dir, hostKey)
+	if err := validateSFTPBackupConfig(sftpCfg); err != nil {
+		return err
+	}
+
+	backup := cloneBackupConfig(b.config.GetBackup())
+	if backup == nil {
+		backup = &config.BackupConfig{}
+	}
+	backup.Provider = string(BackupProviderSFTP)
+	backup.SFTP = sftpCfg
+	b.config.UpdateBackup(backup)
+
+	return b.saveConfig()
+}
+
 func (b *BackupService) ListBackups(provider string) string {
-	provider = normalizeUserInput(provider)
-	if provider == "" {
-		provider = string(BackupProviderWebDAV)
+	_, p, err := b.backupProvider(provider)
+	if err != nil {
+		return marshalBackupListResult(false, err.Error(), nil)
 	}
 
-	switch BackupProvider(provider) {
-	case BackupProviderWebDAV:
-		if b.webdav == nil {
-			return marshalBackupListResult(false, "WebDAV服务未初始化", nil)
-		}
-		return b.webdav.ListWebDAVBackups()
-	case BackupProviderLocal:
-		return b.listLocalBackups()
-	case BackupProviderS3:
-		return b.listS3Backups()
-	default:
-		return marshalBackupListResult(false, "未知备份类型", nil)
+	backups, err := listBackupFiles(p)
+	if err != nil {
+		return marshalBackupListResult(false, fmt.Sprintf("获取备份列表失败: %v", err), nil)
 	}
+	return marshalBackupListResult(true, "获取备份列表成功", backups)
 }
 
 func (b *BackupService) DeleteBackups(provider string, filenames []string) error {
-	provider = normalizeUserInput(provider)
-	if provider == "" {
-		provider = string(BackupProviderWebDAV)
+	name, p, err := b.backupProvider(provider)
+	if err != nil {
+		return err
 	}
 
-	switch BackupProvider(provider) {
-	case BackupProviderWebDAV:
-		if b.webdav == nil {
-			return fmt.Errorf("webdav_not_configured")
+	var names []string
+	for _, filename := range filenames {
+		if filename = ensureDBFilename(filename); filename != "" {
+			names = append(names, filename)
 		}
-		return b.webdav.DeleteWebDAVBackups(filenames)
-	case BackupProviderLocal:
-		return b.deleteLocalBackups(filenames)
-	case BackupProviderS3:
-		return b.deleteS3Backups(filenames)
-	default:
-		return fmt.Errorf("backup_provider_invalid")
 	}
+	if err := p.Delete(names); err != nil {
+		logger.Error("Failed to delete backups from %s: %v", name, err)
+		return fmt.Errorf("delete_backup_failed")
+	}
+
+	logger.Info("Backups deleted from %s: %v", name, names)
+	return nil
 }
 
+// BackupToProvider writes a full backup archive
 func (b *BackupService) BackupToProvider(provider, filename string) error {
-	provider = normalizeUserInput(provider)
-	if provider == "" {
-		provider = string(BackupProviderWebDAV)
+	_, err := b.backupToProvider(provider, filename, 1)
+	return err
+}
+
+// BackupDeltaToProvider writes an incremental archive holding the stats changed since the last
+// full archive at the provider, or a full archive when there is none yet
+func (b *BackupService) BackupDeltaToProvider(provider, filename string) error {
+	_, err := b.backupToProvider(provider, filename, 0)
+	return err
+}
+
+// backupToProvider writes a full archive or a delta (see createBackupArchive) and returns its filename
+func (b *BackupService) backupToProvider(provider, filename string, fullEvery int) (string, error) {
+	if b.storage == nil {
+		return "", fmt.Errorf("storage_not_initialized")
 	}
 
-	switch BackupProvider(provider) {
-	case BackupProviderWebDAV:
-		if b.webdav == nil {
-			return fmt.Errorf("webdav_not_configured")
-		}
-		return b.webdav.BackupToWebDAV(filename)
-	case BackupProviderLocal:
-		return b.backupToLocal(filename)
-	case BackupProviderS3:
-		return b.backupToS3(filename)
-	default:
-		return fmt.Errorf("backup_provider_invalid")
+	name, p, err := b.backupProvider(provider)
+	if err != nil {
+		return "", err
+	}
+
+	tmpDir, cleanup, err := tempDirUnique(name + "_backup")
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	location := p.Location()
+	archive, err := createBackupArchive(b.storage, b.version, name, location, tmpDir, filename, fullEvery)
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.Put(archive.Filename, archive.Path); err != nil {
+		logger.Error("Failed to upload backup to %s: %v", name, err)
+		return "", fmt.Errorf("backup_upload_failed")
+	}
+
+	recordBackupArchive(b.storage, name, location, archive)
+	if err := b.storage.SnapshotEndpointSyncBase(); err != nil {
+		logger.Warn("Failed to snapshot endpoint sync base: %v", err)
 	}
+
+	logger.Info("Backup created at %s: %s (%s)", name, archive.Filename, archive.Manifest.Kind)
+	return archive.Filename, nil
 }
 
 func (b *BackupService) DetectBackupConflict(provider, filename string) string {
-	provider = normalizeUserInput(provider)
-	if provider == "" {
-		provider = string(BackupProviderWebDAV)
+	if b.storage == nil {
+		return marshalConflictResult(false, "存储未初始化", nil)
 	}
 
-	switch BackupProvider(provider) {
-	case BackupProviderWebDAV:
-		if b.webdav == nil {
-			return marshalConflictResult(false, "WebDAV服务未初始化", nil)
-		}
-		return b.webdav.DetectWebDAVConflict(filename)
-	case BackupProviderLocal:
-		return b.detectLocalConflict(filename)
-	case BackupProviderS3:
-		return b.detectS3Conflict(filename)
-	default:
-		return marshalConflictResult(false, "未知备份类型", nil)
+	name, p, err := b.backupProvider(provider)
+	if err != nil {
+		return marshalConflictResult(false, err.Error(), nil)
+	}
+
+	backupPath, cleanup, err := openBackup(p, name, filename)
+	if err != nil {
+		return marshalConflictResult(false, err.Error(), nil)
 	}
+	defer cleanup()
+
+	conflicts, err := b.storage.DetectEndpointConflicts(backupPath)
+	if errors.Is(err, storage.ErrSchemaTooNew) {
+		return marshalConflictResult(false, "backup_schema_incompatible", nil)
+	}
+	if err != nil {
+		return marshalConflictResult(false, fmt.Sprintf("检测冲突失败: %v", err), nil)
+	}
+	return marshalConflictResult(true, "", conflicts)
 }
 
 func (b *BackupService) RestoreFromProvider(provider, filename, choice string, reloadConfig func(*config.Config) error) error {
-	provider = normalizeUserInput(provider)
-	if provider == "" {
-		provider = string(BackupProviderWebDAV)
+	if b.storage == nil {
+		return fmt.Errorf("storage_not_initialized")
 	}
 
-	switch BackupProvider(provider) {
-	case BackupProviderWebDAV:
-		if b.webdav == nil {
-			return fmt.Errorf("webdav_not_configured")
-		}
-		return b.webdav.RestoreFromWebDAV(filename, choice, reloadConfig)
-	case BackupProviderLocal:
-		return b.restoreFromLocal(filename, choice, reloadConfig)
-	case BackupProviderS3:
-		return b.restoreFromS3(filename, choice, reloadConfig)
-	default:
-		return fmt.Errorf("backup_provider_invalid")
+	name, p, err := b.backupProvider(provider)
+	if err != nil {
+		return err
 	}
+
+	backupPath, cleanup, err := openBackup(p, name, filename)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if err := b.restoreBackup(backupPath, choice, reloadConfig); err != nil {
+		return err
+	}
+
+	logger.Info("Configuration and statistics restored from %s: %s", name, filename)
+	return nil
 }
 
 func (b *BackupService) TestS3Connection(endpoint, region, bucket, prefix, accessKey, secretKey, sessionToken string, useSSL, forcePathStyle bool) string {
-	result := b.testS3Connection(endpoint, region, bucket, prefix, accessKey, secretKey, sessionToken, useSSL, forcePathStyle)
+	result := testS3Connection(endpoint, region, bucket, prefix, accessKey, secretKey, sessionToken, useSSL, forcePathStyle)
+	data, _ := json.Marshal(result)
+	return string(data)
+}
+
+func (b *BackupService) TestSFTPConnection(host string, port int, username, password, privateKey, passphrase, dir, hostKey string) string {
+	result := testSFTPConnection(normalizeSFTPBackupConfig(host, port, username, password, privateKey, passphrase, dir, hostKey))
 	data, _ := json.Marshal(result)
 	return string(data)
 }
@@ -210,15 +263,6 @@ func (b *BackupService) saveConfig() error {
 	return nil
 }
 
-func isValidBackupProvider(provider string) bool {
-	switch BackupProvider(provider) {
-	case BackupProviderWebDAV, BackupProviderLocal, BackupProviderS3:
-		return true
-	default:
-		return false
-	}
-}
-
 func cloneBackupConfig(src *config.BackupConfig) *config.BackupConfig {
 	if src == nil {
 		return nil
@@ -241,6 +285,10 @@ func cloneBackupConfig(src *config.BackupConfig) *config.BackupConfig {
 			ForcePathStyle: src.S3.ForcePathStyle,
 		}
 	}
+	if src.SFTP != nil {
+		sftpCfg := *src.SFTP
+		dst.SFTP = &sftpCfg
+	}
 	return dst
 }
 
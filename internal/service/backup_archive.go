@@ -0,0 +1,169 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/lich0821/ccNexus/internal/logger"
+	"github.com/lich0821/ccNexus/internal/storage"
+)
+
+// backupArchiveExt is the extension of compressed backup archives; plain .db backups of older
+// versions can still be restored
+const backupArchiveExt = ".ccnx"
+
+// backupDeltaSuffix marks incremental archives, e.g. name.delta.ccnx
+const backupDeltaSuffix = ".delta"
+
+// backupArchiveStateKey stores, per provider, the last full archive that deltas build on
+const backupArchiveStateKey = "backup_archive_state"
+
+// backupArchiveState is the full archive of a provider and the number of deltas made since
+type backupArchiveState struct {
+	Location string             `json:"location"`
+	Base     storage.BackupBase `json:"base"`
+	Deltas   int                `json:"deltas"`
+}
+
+// pendingBackupArchive is an archive written to a temp dir and waiting for upload
+type pendingBackupArchive struct {
+	Path     string
+	Filename string
+	Manifest *storage.BackupManifest
+}
+
+// backupArchiveFilename names the archive of a backup, replacing any backup extension
+func backupArchiveFilename(filename string, delta bool) string {
+	filename = filepath.Base(strings.TrimSpace(filename))
+	for _, ext := range []string{backupArchiveExt, ".db", ".json"} {
+		filename = strings.TrimSuffix(filename, ext)
+	}
+	filename = strings.TrimSuffix(filename, backupDeltaSuffix)
+	if filename == "" || filename == "." {
+		return ""
+	}
+	if delta {
+		filename += backupDeltaSuffix
+	}
+	return filename + backupArchiveExt
+}
+
+// isBackupFilename reports whether a file listed at a backup location is a backup
+func isBackupFilename(name string) bool {
+	return strings.HasSuffix(name, ".db") || strings.HasSuffix(name, backupArchiveExt)
+}
+
+// isDeltaBackupFilename reports whether a backup is an incremental archive
+func isDeltaBackupFilename(name string) bool {
+	return strings.HasSuffix(name, backupDeltaSuffix+backupArchiveExt)
+}
+
+func loadBackupArchiveStates(s storage.Storage) map[string]backupArchiveState {
+	states := make(map[string]backupArchiveState)
+	if data, err := s.GetConfig(backupArchiveStateKey); err == nil && data != "" {
+		_ = json.Unmarshal([]byte(data), &states)
+	}
+	return states
+}
+
+// createBackupArchive writes the archive of a backup to dir. fullEvery decides between a full
+// archive and a delta: 1 always makes a full archive, 0 makes a delta whenever a full archive
+// exists at the location, and n > 1 makes a full archive after n-1 deltas. Deltas never refer
+// to a full archive at another location (see BackupProvider.Location).
+func createBackupArchive(s storage.Storage, version, provider, location, dir, filename string, fullEvery int) (*pendingBackupArchive, error) {
+	var base *storage.BackupBase
+	state, ok := loadBackupArchiveStates(s)[provider]
+	if fullEvery != 1 && ok && state.Location == location && (fullEvery == 0 || state.Deltas < fullEvery-1) {
+		base = &state.Base
+	}
+
+	name := backupArchiveFilename(filename, base != nil)
+	if name == "" {
+		return nil, fmt.Errorf("filename_required")
+	}
+	path := filepath.Join(dir, name)
+	manifest, err := s.CreateBackupArchive(path, version, base)
+	if err != nil {
+		logger.Error("Failed to create backup archive: %v", err)
+		return nil, fmt.Errorf("create_db_backup_failed")
+	}
+	return &pendingBackupArchive{Path: path, Filename: name, Manifest: manifest}, nil
+}
+
+// recordBackupArchive remembers an uploaded full archive as the base of later deltas
+func recordBackupArchive(s storage.Storage, provider, location string, archive *pendingBackupArchive) {
+	states := loadBackupArchiveStates(s)
+	state := states[provider]
+	if archive.Manifest.Kind == storage.BackupKindFull {
+		state = backupArchiveState{
+			Location: location,
+			Base: storage.BackupBase{
+				Filename:     archive.Filename,
+				SHA256:       archive.Manifest.SHA256,
+				StatsVersion: archive.Manifest.StatsVersion,
+			},
+		}
+	} else {
+		state.Deltas++
+	}
+	states[provider] = state
+	data, _ := json.Marshal(states)
+	if err := s.SetConfig(backupArchiveStateKey, string(data)); err != nil {
+		logger.Warn("Failed to save backup archive state: %v", err)
+	}
+}
+
+// openBackupFile turns a downloaded backup into a database file that can be merged. Archives
+// are verified and extracted into workDir; a delta is applied to its full archive, which fetch
+// returns as a local file. Plain .db backups are returned unchanged.
+func openBackupFile(filename, path, workDir string, fetch func(filename string) (string, error)) (string, error) {
+	if !strings.HasSuffix(filename, backupArchiveExt) {
+		return path, nil
+	}
+
+	payload := filepath.Join(workDir, "payload")
+	manifest, err := storage.ExtractBackupArchive(path, payload)
+	if err != nil {
+		logger.Error("Failed to extract backup archive %s: %v", filename, err)
+		return "", backupArchiveError(err)
+	}
+	if manifest.Kind == storage.BackupKindFull {
+		return payload, nil
+	}
+
+	basePath, err := fetch(manifest.Base.Filename)
+	if err != nil {
+		logger.Error("Failed to fetch base archive %s of %s: %v", manifest.Base.Filename, filename, err)
+		return "", fmt.Errorf("backup_base_missing")
+	}
+	snapshot := filepath.Join(workDir, "base.db")
+	baseManifest, err := storage.ExtractBackupArchive(basePath, snapshot)
+	if err != nil {
+		logger.Error("Failed to extract base archive %s: %v", manifest.Base.Filename, err)
+		return "", backupArchiveError(err)
+	}
+	if baseManifest.Kind != storage.BackupKindFull || baseManifest.SHA256 != manifest.Base.SHA256 {
+		logger.Error("Base archive %s does not match delta %s", manifest.Base.Filename, filename)
+		return "", fmt.Errorf("backup_base_missing")
+	}
+	if err := storage.ApplyBackupDelta(snapshot, payload); err != nil {
+		logger.Error("Failed to apply backup delta %s: %v", filename, err)
+		return "", backupArchiveError(err)
+	}
+	return snapshot, nil
+}
+
+// backupArchiveError maps archive errors to the error codes shown by the frontends
+func backupArchiveError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrBackupChecksum):
+		return fmt.Errorf("backup_checksum_mismatch")
+	case errors.Is(err, storage.ErrBackupSchema):
+		return fmt.Errorf("backup_schema_incompatible")
+	default:
+		return fmt.Errorf("backup_archive_invalid")
+	}
+}
@@ -50,7 +50,7 @@ func ensureDBFilename(filename string) string {
 	if strings.HasSuffix(filename, ".json") {
 		return filename
 	}
-	if strings.HasSuffix(filename, ".db") {
+	if strings.HasSuffix(filename, ".db") || strings.HasSuffix(filename, backupArchiveExt) {
 		return filename
 	}
 	return filename + ".db"
@@ -92,11 +92,23 @@ func sortBackupsByModTimeDesc(backups []BackupListItem) {
 	})
 }
 
-func nowMeta(version string) []byte {
-	meta := map[string]interface{}{
-		"backupTime": time.Now(),
-		"version":    version,
+// mergeBackup restores a downloaded backup into storage according to the restore choice:
+// "remote" and "keep_local" merge the whole database preferring one side, while "merge" or a
+// JSON storage.MergeResolution merge endpoints field by field against the last synced snapshot
+func mergeBackup(s storage.Storage, backupPath, choice string) error {
+	choice = strings.TrimSpace(choice)
+	switch {
+	case choice == "remote":
+		return s.MergeFromBackup(backupPath, storage.MergeStrategyOverwriteLocal)
+	case choice == string(storage.MergeStrategyThreeWay):
+		return s.ThreeWayMergeFromBackup(backupPath, nil)
+	case strings.HasPrefix(choice, "{"):
+		var resolution storage.MergeResolution
+		if err := json.Unmarshal([]byte(choice), &resolution); err != nil {
+			return fmt.Errorf("invalid merge resolution: %w", err)
+		}
+		return s.ThreeWayMergeFromBackup(backupPath, &resolution)
+	default:
+		return s.MergeFromBackup(backupPath, storage.MergeStrategyKeepLocal)
 	}
-	data, _ := json.MarshalIndent(meta, "", "  ")
-	return data
 }
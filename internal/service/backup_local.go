@@ -2,184 +2,124 @@ package service
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/lich0821/ccNexus/internal/config"
-	"github.com/lich0821/ccNexus/internal/logger"
-	"github.com/lich0821/ccNexus/internal/storage"
 )
 
-func (b *BackupService) getLocalDir() (string, error) {
-	backup := b.config.GetBackup()
-	if backup == nil || backup.Local == nil || backup.Local.Dir == "" {
-		return "", fmt.Errorf("backup_local_not_configured")
-	}
-	return backup.Local.Dir, nil
+func init() {
+	RegisterBackupProvider(string(BackupProviderLocal), func(cfg *config.Config) (BackupProvider, error) {
+		backup := cfg.GetBackup()
+		if backup == nil || backup.Local == nil || backup.Local.Dir == "" {
+			return nil, fmt.Errorf("backup_local_not_configured")
+		}
+		return &localBackupProvider{dir: backup.Local.Dir}, nil
+	})
 }
 
-func (b *BackupService) listLocalBackups() string {
-	dir, err := b.getLocalDir()
-	if err != nil {
-		return marshalBackupListResult(false, "本地备份未配置", nil)
-	}
+// localBackupProvider keeps backups in a directory, which may itself be synced by other tools
+type localBackupProvider struct {
+	dir string
+}
+
+func (p *localBackupProvider) Location() string { return "local:" + p.dir }
 
-	entries, err := os.ReadDir(dir)
+func (p *localBackupProvider) List() ([]BackupListItem, error) {
+	entries, err := os.ReadDir(p.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return marshalBackupListResult(false, fmt.Sprintf("读取备份目录失败: %v", err), nil)
+		return nil, err
 	}
 
-	var backups []BackupListItem
+	var files []BackupListItem
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
 		}
-		name := entry.Name()
-		if name == "" {
-			continue
-		}
-		// Skip metadata files
-		if strings.HasSuffix(name, ".meta.json") {
-			continue
-		}
-		// Include .db files only
-		if filepath.Ext(name) != ".db" {
-			continue
-		}
-
 		info, err := entry.Info()
 		if err != nil {
 			continue
 		}
-		backups = append(backups, BackupListItem{
-			Filename: name,
+		files = append(files, BackupListItem{
+			Filename: entry.Name(),
 			Size:     info.Size(),
 			ModTime:  info.ModTime(),
 		})
 	}
-
-	sortBackupsByModTimeDesc(backups)
-	return marshalBackupListResult(true, "获取备份列表成功", backups)
+	return files, nil
 }
 
-func (b *BackupService) backupToLocal(filename string) error {
-	if b.storage == nil {
-		return fmt.Errorf("storage_not_initialized")
+// Put writes through a temporary file next to the destination, so the final rename stays on one
+// file system and a partial backup is never visible
+func (p *localBackupProvider) Put(filename, localPath string) error {
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return err
 	}
 
-	dir, err := b.getLocalDir()
+	src, err := os.Open(localPath)
 	if err != nil {
 		return err
 	}
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		logger.Error("Failed to create backup dir: %v", err)
-		return fmt.Errorf("create_backup_dir_failed")
-	}
-
-	filename = ensureDBFilename(filename)
-	if filename == "" {
-		return fmt.Errorf("filename_required")
-	}
-
-	finalPath := filepath.Join(dir, filename)
-	tmpPath := finalPath + ".tmp"
-	_ = os.Remove(tmpPath)
-
-	if err := b.storage.CreateBackupCopy(tmpPath); err != nil {
-		logger.Error("Failed to create backup copy: %v", err)
-		return fmt.Errorf("create_db_backup_failed")
-	}
-
-	if err := os.Rename(tmpPath, finalPath); err != nil {
-		_ = os.Remove(tmpPath)
-		logger.Error("Failed to rename backup file: %v", err)
-		return fmt.Errorf("backup_write_failed")
-	}
-
-	_ = os.WriteFile(finalPath+".meta.json", nowMeta(b.version), 0644)
-	return nil
-}
-
-func (b *BackupService) detectLocalConflict(filename string) string {
-	if b.storage == nil {
-		return marshalConflictResult(false, "存储未初始化", nil)
-	}
+	defer src.Close()
 
-	dir, err := b.getLocalDir()
+	tmp, err := os.CreateTemp(p.dir, ".backup-*")
 	if err != nil {
-		return marshalConflictResult(false, "本地备份未配置", nil)
+		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	filename = ensureDBFilename(filename)
-	path := filepath.Join(dir, filename)
-	if _, err := os.Stat(path); err != nil {
-		return marshalConflictResult(false, "备份文件不存在", nil)
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return err
 	}
-
-	conflicts, err := b.storage.DetectEndpointConflicts(path)
-	if err != nil {
-		return marshalConflictResult(false, fmt.Sprintf("检测冲突失败: %v", err), nil)
+	if err := tmp.Close(); err != nil {
+		return err
 	}
-
-	return marshalConflictResult(true, "", conflicts)
+	return os.Rename(tmp.Name(), filepath.Join(p.dir, filename))
 }
 
-func (b *BackupService) restoreFromLocal(filename, choice string, reloadConfig func(*config.Config) error) error {
-	if b.storage == nil {
-		return fmt.Errorf("storage_not_initialized")
-	}
-
-	dir, err := b.getLocalDir()
+func (p *localBackupProvider) Get(filename, localPath string) error {
+	src, err := os.Open(filepath.Join(p.dir, filename))
 	if err != nil {
 		return err
 	}
+	defer src.Close()
 
-	filename = ensureDBFilename(filename)
-	backupPath := filepath.Join(dir, filename)
-	if _, err := os.Stat(backupPath); err != nil {
-		return fmt.Errorf("backup_file_not_found")
-	}
-
-	strategy := storage.MergeStrategyKeepLocal
-	if choice == "remote" {
-		strategy = storage.MergeStrategyOverwriteLocal
-	}
-
-	if err := b.storage.MergeFromBackup(backupPath, strategy); err != nil {
-		logger.Error("Failed to merge from backup: %v", err)
-		return fmt.Errorf("merge_data_failed")
-	}
-
-	configAdapter := storage.NewConfigStorageAdapter(b.storage)
-	newConfig, err := config.LoadFromStorage(configAdapter)
+	dst, err := os.Create(localPath)
 	if err != nil {
-		logger.Error("Failed to load config from storage: %v", err)
-		return fmt.Errorf("load_config_failed")
+		return err
 	}
-	*b.config = *newConfig
-
-	if err := reloadConfig(newConfig); err != nil {
-		logger.Error("Failed to reload config: %v", err)
-		return fmt.Errorf("update_proxy_config_failed")
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
 	}
+	return dst.Close()
+}
 
+func (p *localBackupProvider) Delete(filenames []string) error {
+	for _, name := range filenames {
+		for _, file := range []string{name, name + ".meta.json"} {
+			if err := os.Remove(filepath.Join(p.dir, file)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-func (b *BackupService) deleteLocalBackups(filenames []string) error {
-	dir, err := b.getLocalDir()
-	if err != nil {
+// Test checks that backups can be written to the directory
+func (p *localBackupProvider) Test() error {
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
 		return err
 	}
-
-	for _, name := range filenames {
-		name = ensureDBFilename(name)
-		if name == "" {
-			continue
-		}
-		_ = os.Remove(filepath.Join(dir, name))
-		_ = os.Remove(filepath.Join(dir, name+".meta.json"))
+	f, err := os.CreateTemp(p.dir, ".test-*")
+	if err != nil {
+		return err
 	}
-	return nil
+	f.Close()
+	return os.Remove(f.Name())
 }
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+	"github.com/lich0821/ccNexus/internal/storage"
+)
+
+// BackupProvider stores backup files in one flat directory of a backup location. Names passed
+// to it are plain filenames; archives, deltas and merging are handled by BackupService.
+type BackupProvider interface {
+	// Location identifies where backups are stored, so deltas never refer to a full archive
+	// at another location
+	Location() string
+	// List returns the files of the backup directory; an empty or missing directory has none
+	List() ([]BackupListItem, error)
+	// Put uploads the local file localPath as filename, replacing an existing file
+	Put(filename, localPath string) error
+	// Get downloads filename to the local file localPath. A missing file yields an error
+	// matching os.ErrNotExist.
+	Get(filename, localPath string) error
+	// Delete removes backups together with their legacy .meta.json files; missing files are ignored
+	Delete(filenames []string) error
+	// Test checks that the location can be reached with the configured credentials
+	Test() error
+}
+
+// BackupProviderFactory creates a provider from the current configuration, or returns an error
+// code such as backup_s3_not_configured when it is incomplete
+type BackupProviderFactory func(cfg *config.Config) (BackupProvider, error)
+
+var (
+	backupProvidersMu sync.RWMutex
+	backupProviders   = make(map[string]BackupProviderFactory)
+)
+
+// RegisterBackupProvider makes a provider available under name; providers register in init
+func RegisterBackupProvider(name string, factory BackupProviderFactory) {
+	backupProvidersMu.Lock()
+	defer backupProvidersMu.Unlock()
+	if _, ok := backupProviders[name]; ok {
+		panic("backup provider registered twice: " + name)
+	}
+	backupProviders[name] = factory
+}
+
+func isValidBackupProvider(provider string) bool {
+	backupProvidersMu.RLock()
+	defer backupProvidersMu.RUnlock()
+	_, ok := backupProviders[provider]
+	return ok
+}
+
+// newBackupProvider creates the provider registered under name
+func newBackupProvider(cfg *config.Config, name string) (BackupProvider, error) {
+	backupProvidersMu.RLock()
+	factory, ok := backupProviders[name]
+	backupProvidersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("backup_provider_invalid")
+	}
+	return factory(cfg)
+}
+
+// backupProvider creates a provider by name, defaulting to WebDAV, and returns the normalized name
+func (b *BackupService) backupProvider(provider string) (string, BackupProvider, error) {
+	provider = normalizeUserInput(provider)
+	if provider == "" {
+		provider = string(BackupProviderWebDAV)
+	}
+	p, err := newBackupProvider(b.config, provider)
+	if err != nil {
+		return provider, nil, err
+	}
+	if pinner, ok := p.(hostKeyPinner); ok {
+		if backup := b.config.GetBackup(); backup != nil && backup.SFTP != nil {
+			server := *backup.SFTP
+			pinner.setHostKeyPinner(func(fingerprint string) error { return b.pinSFTPHostKey(&server, fingerprint) })
+		}
+	}
+	return provider, p, nil
+}
+
+// hostKeyPinner is implemented by providers that pin the server key seen on the first connection
+// and need it persisted to the configuration
+type hostKeyPinner interface {
+	setHostKeyPinner(pin func(fingerprint string) error)
+}
+
+// listBackupFiles lists the backups of a provider, newest first, skipping other files
+func listBackupFiles(p BackupProvider) ([]BackupListItem, error) {
+	files, err := p.List()
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]BackupListItem, 0, len(files))
+	for _, f := range files {
+		if strings.HasSuffix(f.Filename, ".meta.json") || !isBackupFilename(f.Filename) {
+			continue
+		}
+		backups = append(backups, f)
+	}
+	sortBackupsByModTimeDesc(backups)
+	return backups, nil
+}
+
+// openBackup downloads a backup into a temp dir and, for archives, verifies and extracts it,
+// fetching the full archive of a delta from the same provider. It returns the database file
+// to merge and a cleanup function for the temp files.
+func openBackup(p BackupProvider, provider, filename string) (string, func(), error) {
+	filename = ensureDBFilename(filename)
+	if filename == "" {
+		return "", nil, fmt.Errorf("filename_required")
+	}
+
+	workDir, cleanup, err := tempDirUnique(provider + "_restore")
+	if err != nil {
+		return "", nil, err
+	}
+
+	download := filepath.Join(workDir, "restore"+filepath.Ext(filename))
+	if err := p.Get(filename, download); err != nil {
+		cleanup()
+		return "", nil, backupDownloadError(provider, filename, err)
+	}
+
+	dbPath, err := openBackupFile(filename, download, workDir, func(base string) (string, error) {
+		basePath := filepath.Join(workDir, "base"+backupArchiveExt)
+		return basePath, p.Get(path.Base(base), basePath)
+	})
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return dbPath, cleanup, nil
+}
+
+// deltaBase returns the filename of the full archive a delta builds on, read from its manifest
+func (b *BackupService) deltaBase(provider, filename string) (string, error) {
+	name, p, err := b.backupProvider(provider)
+	if err != nil {
+		return "", err
+	}
+	workDir, cleanup, err := tempDirUnique(name + "_manifest")
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	download := filepath.Join(workDir, filename)
+	if err := p.Get(filename, download); err != nil {
+		return "", err
+	}
+	manifest, err := storage.ReadBackupManifest(download)
+	if err != nil {
+		return "", err
+	}
+	if manifest.Kind != storage.BackupKindDelta || manifest.Base == nil {
+		return "", fmt.Errorf("%s is not a delta archive", filename)
+	}
+	return path.Base(manifest.Base.Filename), nil
+}
+
+func backupDownloadError(provider, filename string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("backup_file_not_found")
+	}
+	logger.Error("Failed to download backup %s from %s: %v", filename, provider, err)
+	return fmt.Errorf("restore_download_failed")
+}
+
+// restoreBackup merges a downloaded backup and reloads the configuration it brought along
+func (b *BackupService) restoreBackup(backupPath, choice string, reloadConfig func(*config.Config) error) error {
+	if err := mergeBackup(b.storage, backupPath, choice); err != nil {
+		logger.Error("Failed to merge from backup: %v", err)
+		if errors.Is(err, storage.ErrSchemaTooNew) {
+			return fmt.Errorf("backup_schema_incompatible")
+		}
+		return fmt.Errorf("merge_data_failed")
+	}
+
+	configAdapter := storage.NewConfigStorageAdapter(b.storage)
+	newConfig, err := config.LoadFromStorage(configAdapter)
+	if err != nil {
+		logger.Error("Failed to load config from storage: %v", err)
+		return fmt.Errorf("load_config_failed")
+	}
+	*b.config = *newConfig
+
+	if err := reloadConfig(newConfig); err != nil {
+		logger.Error("Failed to reload config: %v", err)
+		return fmt.Errorf("update_proxy_config_failed")
+	}
+	return nil
+}
@@ -0,0 +1,541 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+)
+
+// backupScheduleStatusKey stores the last run of each schedule, so intervals survive restarts
+const backupScheduleStatusKey = "backup_schedule_status"
+
+// backupSchedulerMaxWait bounds a sleep, so config changes made elsewhere (e.g. a restore) are picked up
+const backupSchedulerMaxWait = time.Minute
+
+// BackupRunStatus is the state of a provider's backup schedule
+type BackupRunStatus struct {
+	Provider     string     `json:"provider"`
+	Running      bool       `json:"running"`
+	LastRun      *time.Time `json:"lastRun,omitempty"`
+	LastSuccess  bool       `json:"lastSuccess"`
+	LastError    string     `json:"lastError,omitempty"`
+	LastFilename string     `json:"lastFilename,omitempty"`
+	Deleted      int        `json:"deleted"` // Backups removed by retention in the last run
+	NextRun      *time.Time `json:"nextRun,omitempty"`
+}
+
+// BackupScheduler runs the scheduled backups of BackupService and applies their retention
+type BackupScheduler struct {
+	backup   *BackupService
+	onStatus func(BackupRunStatus)
+
+	mu      sync.Mutex
+	status  map[string]*BackupRunStatus
+	started bool
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewBackupScheduler(backup *BackupService) *BackupScheduler {
+	return &BackupScheduler{
+		backup: backup,
+		status: make(map[string]*BackupRunStatus),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// SetOnStatus sets a callback invoked whenever a scheduled backup starts or finishes
+func (s *BackupScheduler) SetOnStatus(fn func(BackupRunStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onStatus = fn
+}
+
+// Start begins running the schedules in the background
+func (s *BackupScheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	s.loadStatus()
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.loop()
+}
+
+// Stop ends the scheduler, waiting for a running backup to finish
+func (s *BackupScheduler) Stop() {
+	s.mu.Lock()
+	if !s.started {
+		s.mu.Unlock()
+		return
+	}
+	s.started = false
+	close(s.stop)
+	done := s.done
+	s.mu.Unlock()
+	<-done
+}
+
+// GetSchedules returns the backup schedule configuration as JSON
+func (s *BackupScheduler) GetSchedules() string {
+	schedules := s.backup.config.GetBackupSchedule()
+	if schedules == nil {
+		schedules = &config.BackupScheduleConfig{}
+	}
+	if schedules.Schedules == nil {
+		schedules = &config.BackupScheduleConfig{Schedules: []config.BackupSchedule{}}
+	}
+	data, _ := json.Marshal(schedules)
+	return string(data)
+}
+
+// SetSchedules updates the backup schedules from JSON; an empty string removes them
+func (s *BackupScheduler) SetSchedules(schedulesJSON string) error {
+	schedules, err := config.DecodeBackupSchedule(schedulesJSON)
+	if err != nil {
+		return err
+	}
+	s.backup.config.UpdateBackupSchedule(schedules)
+	if err := s.backup.saveConfig(); err != nil {
+		return err
+	}
+
+	// Next runs are recomputed from the new schedules
+	s.mu.Lock()
+	for _, st := range s.status {
+		st.NextRun = nil
+	}
+	s.mu.Unlock()
+	s.notify()
+	return nil
+}
+
+// GetStatus returns the status of all providers with a schedule or a past run as JSON
+func (s *BackupScheduler) GetStatus() string {
+	data, _ := json.Marshal(s.Status())
+	return string(data)
+}
+
+// Status returns the status of all providers with a schedule or a past run, by provider
+func (s *BackupScheduler) Status() []BackupRunStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]BackupRunStatus, 0, len(s.status))
+	for _, st := range s.status {
+		result = append(result, *st)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Provider < result[j].Provider })
+	return result
+}
+
+// RunNow runs a provider's schedule immediately, including its retention
+func (s *BackupScheduler) RunNow(provider string) error {
+	schedules := s.backup.config.GetBackupSchedule()
+	if schedules == nil || schedules.Find(provider) == nil {
+		return fmt.Errorf("backup_schedule_not_found")
+	}
+	sched := *schedules.Find(provider)
+	s.mu.Lock()
+	running := s.statusLocked(provider).Running
+	s.mu.Unlock()
+	if running {
+		return fmt.Errorf("backup_running")
+	}
+	s.run(sched, time.Now())
+	s.notify()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st := s.status[provider]; st != nil && !st.LastSuccess {
+		return fmt.Errorf("%s", st.LastError)
+	}
+	return nil
+}
+
+// notify wakes the loop to recompute the next runs
+func (s *BackupScheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *BackupScheduler) loop() {
+	defer close(s.done)
+	for {
+		now := time.Now()
+		due, next := s.plan(now)
+		for _, sched := range due {
+			s.run(sched, now)
+		}
+		if len(due) > 0 {
+			continue
+		}
+
+		wait := backupSchedulerMaxWait
+		if !next.IsZero() && next.Sub(now) < wait {
+			wait = next.Sub(now)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// plan updates the next run of every enabled schedule and returns the schedules that are due
+// and the earliest next run of the others
+func (s *BackupScheduler) plan(now time.Time) ([]config.BackupSchedule, time.Time) {
+	schedules := s.backup.config.GetBackupSchedule()
+	if schedules == nil {
+		return nil, time.Time{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []config.BackupSchedule
+	var earliest time.Time
+	for _, sched := range schedules.Schedules {
+		st := s.statusLocked(sched.Provider)
+		if !sched.Enabled {
+			st.NextRun = nil
+			continue
+		}
+		if st.Running {
+			continue // Started by RunNow, which sets the next run when it finishes
+		}
+		spec, err := sched.Schedule()
+		if err != nil {
+			continue
+		}
+
+		// Cron runs missed while ccNexus was not running are skipped; an overdue interval runs once
+		var next time.Time
+		switch {
+		case st.NextRun != nil:
+			next = *st.NextRun
+		case sched.Cron == "" && st.LastRun != nil:
+			next = spec.Next(*st.LastRun)
+		default:
+			next = spec.Next(now)
+		}
+		if next.IsZero() {
+			st.NextRun = nil
+			continue
+		}
+		st.NextRun = &next
+		if !next.After(now) {
+			due = append(due, sched)
+		} else if earliest.IsZero() || next.Before(earliest) {
+			earliest = next
+		}
+	}
+	return due, earliest
+}
+
+// run makes a backup for a schedule and applies its retention
+func (s *BackupScheduler) run(sched config.BackupSchedule, now time.Time) {
+	s.mu.Lock()
+	st := s.statusLocked(sched.Provider)
+	if st.Running {
+		s.mu.Unlock()
+		return
+	}
+	st.Running = true
+	s.mu.Unlock()
+	s.emit(sched.Provider)
+
+	filename := formatBackupFilename(sched.FilenamePatternOrDefault(), now)
+	fullEvery := sched.FullEvery
+	if fullEvery < 1 {
+		fullEvery = 1
+	}
+	logger.Info("Scheduled backup to %s: %s", sched.Provider, filename)
+	written, err := s.backup.backupToProvider(sched.Provider, filename, fullEvery)
+	if err == nil {
+		filename = written
+	}
+
+	deleted := 0
+	if err == nil && sched.Retention.IsSet() {
+		var pruneErr error
+		deleted, pruneErr = s.applyRetention(sched)
+		if pruneErr != nil {
+			logger.Warn("Backup retention for %s failed: %v", sched.Provider, pruneErr)
+		}
+	}
+	if err != nil {
+		logger.Error("Scheduled backup to %s failed: %v", sched.Provider, err)
+	} else {
+		logger.Info("Scheduled backup to %s finished (%d old backups removed)", sched.Provider, deleted)
+	}
+
+	s.mu.Lock()
+	finished := time.Now()
+	st.Running = false
+	st.LastRun = &finished
+	st.LastSuccess = err == nil
+	st.LastError = ""
+	if err != nil {
+		st.LastError = err.Error()
+	}
+	st.LastFilename = filename
+	st.Deleted = deleted
+	if spec, specErr := sched.Schedule(); specErr == nil && sched.Enabled {
+		next := spec.Next(finished)
+		st.NextRun = &next
+	}
+	s.saveStatusLocked()
+	s.mu.Unlock()
+	s.emit(sched.Provider)
+}
+
+// applyRetention deletes the scheduled backups of a provider that no retention rule keeps.
+// Only files matching the schedule's filename pattern are considered, so manual backups stay.
+func (s *BackupScheduler) applyRetention(sched config.BackupSchedule) (int, error) {
+	var list BackupListResult
+	if err := json.Unmarshal([]byte(s.backup.ListBackups(sched.Provider)), &list); err != nil {
+		return 0, err
+	}
+	if !list.Success {
+		return 0, fmt.Errorf("%s", list.Message)
+	}
+
+	pattern := sched.FilenamePatternOrDefault()
+	backups := make(map[string]time.Time)
+	for _, item := range list.Backups {
+		if t, ok := parseBackupFilename(pattern, item.Filename); ok {
+			backups[item.Filename] = t
+		}
+	}
+	expired, err := keepDeltaBases(backups, expiredBackups(backups, sched.Retention), func(delta string) (string, error) {
+		return s.backup.deltaBase(sched.Provider, delta)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	if err := s.backup.DeleteBackups(sched.Provider, expired); err != nil {
+		return 0, err
+	}
+	return len(expired), nil
+}
+
+func (s *BackupScheduler) statusLocked(provider string) *BackupRunStatus {
+	st := s.status[provider]
+	if st == nil {
+		st = &BackupRunStatus{Provider: provider}
+		s.status[provider] = st
+	}
+	return st
+}
+
+func (s *BackupScheduler) emit(provider string) {
+	s.mu.Lock()
+	fn := s.onStatus
+	var st BackupRunStatus
+	if cur := s.status[provider]; cur != nil {
+		st = *cur
+	}
+	s.mu.Unlock()
+	if fn != nil {
+		fn(st)
+	}
+}
+
+// loadStatus restores the last runs saved by saveStatusLocked
+func (s *BackupScheduler) loadStatus() {
+	if s.backup.storage == nil {
+		return
+	}
+	data, err := s.backup.storage.GetConfig(backupScheduleStatusKey)
+	if err != nil || data == "" {
+		return
+	}
+	var saved []BackupRunStatus
+	if err := json.Unmarshal([]byte(data), &saved); err != nil {
+		logger.Warn("Failed to load backup schedule status: %v", err)
+		return
+	}
+	for i := range saved {
+		st := saved[i]
+		st.Running, st.NextRun = false, nil
+		s.status[st.Provider] = &st
+	}
+}
+
+func (s *BackupScheduler) saveStatusLocked() {
+	if s.backup.storage == nil {
+		return
+	}
+	saved := make([]BackupRunStatus, 0, len(s.status))
+	for _, st := range s.status {
+		if st.LastRun != nil {
+			cp := *st
+			cp.Running, cp.NextRun = false, nil
+			saved = append(saved, cp)
+		}
+	}
+	data, _ := json.Marshal(saved)
+	if err := s.backup.storage.SetConfig(backupScheduleStatusKey, string(data)); err != nil {
+		logger.Warn("Failed to save backup schedule status: %v", err)
+	}
+}
+
+// formatBackupFilename replaces the time placeholders of a filename pattern
+func formatBackupFilename(pattern string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 >= len(pattern) {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		default:
+			b.WriteByte(pattern[i])
+		}
+	}
+	return b.String()
+}
+
+// parseBackupFilename reads the backup time from a filename made by formatBackupFilename
+func parseBackupFilename(pattern, filename string) (time.Time, bool) {
+	var expr strings.Builder
+	var fields []byte
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 >= len(pattern) {
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case 'Y':
+			expr.WriteString(`(\d{4})`)
+		case 'm', 'd', 'H', 'M', 'S':
+			expr.WriteString(`(\d{2})`)
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			continue
+		}
+		fields = append(fields, pattern[i])
+	}
+	expr.WriteString(`(?:\.delta)?(?:\.db|\.ccnx)$`)
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return time.Time{}, false
+	}
+	match := re.FindStringSubmatch(filename)
+	if match == nil {
+		return time.Time{}, false
+	}
+	values := map[byte]int{'m': 1, 'd': 1}
+	for i, field := range fields {
+		values[field], _ = strconv.Atoi(match[i+1])
+	}
+	t := time.Date(values['Y'], time.Month(values['m']), values['d'], values['H'], values['M'], values['S'], 0, time.Local)
+	return t, true
+}
+
+// expiredBackups returns the backups no retention rule keeps, given their times
+func expiredBackups(backups map[string]time.Time, retention config.BackupRetention) []string {
+	names := make([]string, 0, len(backups))
+	for name := range backups {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return backups[names[i]].After(backups[names[j]]) })
+
+	keep := make(map[string]bool)
+	for i := 0; i < retention.KeepLast && i < len(names); i++ {
+		keep[names[i]] = true
+	}
+	keepPeriods := func(count int, period func(time.Time) string) {
+		seen := make(map[string]bool)
+		for _, name := range names {
+			if len(seen) >= count {
+				return
+			}
+			key := period(backups[name])
+			if !seen[key] {
+				seen[key] = true
+				keep[name] = true
+			}
+		}
+	}
+	keepPeriods(retention.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") })
+	keepPeriods(retention.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepPeriods(retention.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") })
+
+	var expired []string
+	for _, name := range names {
+		if !keep[name] {
+			expired = append(expired, name)
+		}
+	}
+	return expired
+}
+
+// keepDeltaBases takes the full archives that kept deltas build on out of the expired backups.
+// baseOf returns the full archive named in the manifest of a delta. When the base of a kept delta
+// cannot be read nothing is deleted, since a delta without its base cannot be restored.
+func keepDeltaBases(backups map[string]time.Time, expired []string, baseOf func(delta string) (string, error)) ([]string, error) {
+	isExpired := make(map[string]bool, len(expired))
+	for _, name := range expired {
+		isExpired[name] = true
+	}
+	needed := make(map[string]bool)
+	for name := range backups {
+		if isExpired[name] || !isDeltaBackupFilename(name) {
+			continue
+		}
+		base, err := baseOf(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the base of %s: %w", name, err)
+		}
+		needed[base] = true
+	}
+
+	var result []string
+	for _, name := range expired {
+		if !needed[name] {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/config"
+)
+
+func TestBackupFilenameRoundTrip(t *testing.T) {
+	at := time.Date(2026, 3, 14, 9, 5, 7, 0, time.Local)
+	name := ensureDBFilename(formatBackupFilename(config.DefaultBackupFilenamePattern, at))
+	if name != "ccnexus-auto-20260314-090507.db" {
+		t.Fatalf("filename = %s", name)
+	}
+	got, ok := parseBackupFilename(config.DefaultBackupFilenamePattern, name)
+	if !ok || !got.Equal(at) {
+		t.Errorf("parseBackupFilename = %v, %v; want %v", got, ok, at)
+	}
+	for _, delta := range []bool{false, true} {
+		archive := backupArchiveFilename(formatBackupFilename(config.DefaultBackupFilenamePattern, at), delta)
+		if got, ok := parseBackupFilename(config.DefaultBackupFilenamePattern, archive); !ok || !got.Equal(at) {
+			t.Errorf("parseBackupFilename(%s) = %v, %v; want %v", archive, got, ok, at)
+		}
+	}
+	for _, other := range []string{"manual.db", "ccnexus-auto-20260314-0905.db", "ccnexus-auto-20260314-090507.db.tmp"} {
+		if _, ok := parseBackupFilename(config.DefaultBackupFilenamePattern, other); ok {
+			t.Errorf("parseBackupFilename matched %s", other)
+		}
+	}
+}
+
+func TestExpiredBackups(t *testing.T) {
+	// One backup a day at noon from 2026-01-01 to 2026-03-31
+	backups := map[string]time.Time{}
+	for d := time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local); d.Month() <= 3 && d.Year() == 2026; d = d.AddDate(0, 0, 1) {
+		backups[d.Format("2006-01-02")] = d
+	}
+	// A second backup on the last day, which KeepLast keeps but daily does not need
+	backups["2026-03-31b"] = time.Date(2026, 3, 31, 18, 0, 0, 0, time.Local)
+
+	expired := expiredBackups(backups, config.BackupRetention{KeepLast: 2, KeepDaily: 3, KeepWeekly: 2, KeepMonthly: 3})
+	kept := map[string]bool{}
+	for name := range backups {
+		kept[name] = true
+	}
+	for _, name := range expired {
+		delete(kept, name)
+	}
+	var names []string
+	for name := range kept {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Last 2: 03-31b, 03-31. Daily: 03-31b, 03-30, 03-29. Weekly: 03-31b (W14), 03-29 (W13).
+	// Monthly: 03-31b, 02-28, 01-31.
+	want := []string{"2026-01-31", "2026-02-28", "2026-03-29", "2026-03-30", "2026-03-31", "2026-03-31b"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("kept %v, want %v", names, want)
+	}
+
+	if expired := expiredBackups(backups, config.BackupRetention{}); len(expired) != len(backups) {
+		t.Errorf("empty retention expired %d of %d", len(expired), len(backups))
+	}
+}
+
+func TestKeepDeltaBases(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 3, 0, 0, 0, time.Local) }
+	backups := map[string]time.Time{
+		"b1.ccnx":       day(1),
+		"b2.delta.ccnx": day(2),
+		"b3.ccnx":       day(3),
+		"b4.delta.ccnx": day(4),
+		"b5.delta.ccnx": day(5),
+	}
+	// The bases come from the delta manifests: b5 builds on b1 although b3 is newer
+	bases := map[string]string{"b2.delta.ccnx": "b1.ccnx", "b4.delta.ccnx": "b3.ccnx", "b5.delta.ccnx": "b1.ccnx"}
+	baseOf := func(delta string) (string, error) {
+		if base, ok := bases[delta]; ok {
+			return base, nil
+		}
+		return "", os.ErrNotExist
+	}
+
+	got, err := keepDeltaBases(backups, []string{"b1.ccnx", "b2.delta.ccnx", "b3.ccnx", "b4.delta.ccnx"}, baseOf)
+	sort.Strings(got)
+	if want := []string{"b2.delta.ccnx", "b3.ccnx", "b4.delta.ccnx"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("expired %v (%v), want %v", got, err, want)
+	}
+
+	// Nothing is deleted when the base of a kept delta is unknown
+	delete(bases, "b5.delta.ccnx")
+	if got, err := keepDeltaBases(backups, []string{"b1.ccnx", "b3.ccnx"}, baseOf); err == nil || got != nil {
+		t.Errorf("expired %v, want an error", got)
+	}
+}
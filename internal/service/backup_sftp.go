@@ -0,0 +1,333 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultSFTPPort is used when no port is configured
+const defaultSFTPPort = 22
+
+func init() {
+	RegisterBackupProvider(string(BackupProviderSFTP), func(cfg *config.Config) (BackupProvider, error) {
+		backup := cfg.GetBackup()
+		if backup == nil || backup.SFTP == nil {
+			return nil, fmt.Errorf("backup_sftp_not_configured")
+		}
+		if err := validateSFTPBackupConfig(backup.SFTP); err != nil {
+			return nil, err
+		}
+		return &sftpBackupProvider{cfg: backup.SFTP}, nil
+	})
+}
+
+func normalizeSFTPBackupConfig(host string, port int, username, password, privateKey, passphrase, dir, hostKey string) *config.SFTPBackupConfig {
+	return &config.SFTPBackupConfig{
+		Host:       normalizeUserInput(host),
+		Port:       port,
+		Username:   normalizeUserInput(username),
+		Password:   password,
+		PrivateKey: strings.TrimSpace(privateKey),
+		Passphrase: passphrase,
+		Dir:        normalizeUserInput(dir),
+		HostKey:    normalizeUserInput(hostKey),
+	}
+}
+
+func validateSFTPBackupConfig(cfg *config.SFTPBackupConfig) error {
+	if cfg.Host == "" || cfg.Username == "" || cfg.Dir == "" || (cfg.Password == "" && cfg.PrivateKey == "") {
+		return fmt.Errorf("backup_sftp_not_configured")
+	}
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return fmt.Errorf("backup_sftp_port_invalid")
+	}
+	return nil
+}
+
+// sftpBackupProvider keeps backups in a directory of an SSH server. Each operation opens its
+// own connection, since backups are rare and connections would otherwise go stale.
+type sftpBackupProvider struct {
+	cfg *config.SFTPBackupConfig
+
+	mu     sync.Mutex
+	pin    func(fingerprint string) error // Persists the fingerprint seen on the first connection
+	pinned string                         // Fingerprint pinned by this provider
+	seen   string                         // Fingerprint of the last server key, for connection tests
+}
+
+// setHostKeyPinner implements hostKeyPinner
+func (p *sftpBackupProvider) setHostKeyPinner(pin func(fingerprint string) error) {
+	p.pin = pin
+}
+
+// checkHostKey accepts the server key when it matches the configured fingerprint. Without one,
+// the first key seen is pinned and persisted, like a first connection with ssh; without a way
+// to persist it the connection is refused.
+func (p *sftpBackupProvider) checkHostKey(_ string, _ net.Addr, key ssh.PublicKey) error {
+	got := ssh.FingerprintSHA256(key)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seen = got
+	want := p.cfg.HostKey
+	if want == "" {
+		want = p.pinned
+	}
+	if want == "" {
+		if p.pin == nil {
+			return fmt.Errorf("no host key configured for %s; the server presented %s", p.cfg.Host, got)
+		}
+		if err := p.pin(got); err != nil {
+			return fmt.Errorf("failed to pin host key %s: %w", got, err)
+		}
+		p.pinned = got
+		return nil
+	}
+	if got != want {
+		return fmt.Errorf("host key mismatch for %s: the server presented %s but %s is pinned; update the host key if the server key changed on purpose", p.cfg.Host, got, want)
+	}
+	return nil
+}
+
+func (p *sftpBackupProvider) addr() string {
+	port := p.cfg.Port
+	if port == 0 {
+		port = defaultSFTPPort
+	}
+	return net.JoinHostPort(p.cfg.Host, strconv.Itoa(port))
+}
+
+func (p *sftpBackupProvider) Location() string {
+	return "sftp:" + p.cfg.Username + "@" + p.addr() + ":" + path.Clean(p.cfg.Dir)
+}
+
+// sshConfig authenticates with the private key and/or the password and verifies the server key
+// with checkHostKey
+func (p *sftpBackupProvider) sshConfig() (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if p.cfg.PrivateKey != "" {
+		var signer ssh.Signer
+		var err error
+		if p.cfg.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(p.cfg.PrivateKey), []byte(p.cfg.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(p.cfg.PrivateKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if p.cfg.Password != "" {
+		password := p.cfg.Password
+		auth = append(auth, ssh.Password(password), ssh.KeyboardInteractive(func(_, _ string, questions []string, _ []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range answers {
+				answers[i] = password
+			}
+			return answers, nil
+		}))
+	}
+
+	return &ssh.ClientConfig{
+		User:            p.cfg.Username,
+		Auth:            auth,
+		HostKeyCallback: p.checkHostKey,
+		Timeout:         10 * time.Second,
+	}, nil
+}
+
+// connect opens an SFTP session; the returned function closes it
+func (p *sftpBackupProvider) connect() (*sftp.Client, func(), error) {
+	sshCfg, err := p.sshConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := ssh.Dial("tcp", p.addr(), sshCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return client, func() {
+		client.Close()
+		conn.Close()
+	}, nil
+}
+
+func (p *sftpBackupProvider) withClient(fn func(client *sftp.Client) error) error {
+	client, closeFn, err := p.connect()
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	return fn(client)
+}
+
+func (p *sftpBackupProvider) remotePath(filename string) string {
+	return path.Join(p.cfg.Dir, filename)
+}
+
+func (p *sftpBackupProvider) List() ([]BackupListItem, error) {
+	var files []BackupListItem
+	err := p.withClient(func(client *sftp.Client) error {
+		entries, err := client.ReadDir(p.cfg.Dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !entry.Mode().IsRegular() {
+				continue
+			}
+			files = append(files, BackupListItem{
+				Filename: entry.Name(),
+				Size:     entry.Size(),
+				ModTime:  entry.ModTime(),
+			})
+		}
+		return nil
+	})
+	return files, err
+}
+
+func (p *sftpBackupProvider) Put(filename, localPath string) error {
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return p.withClient(func(client *sftp.Client) error {
+		return sftpWriteFile(client, p.remotePath(filename), src)
+	})
+}
+
+func (p *sftpBackupProvider) Get(filename, localPath string) error {
+	return p.withClient(func(client *sftp.Client) error {
+		src, err := client.Open(p.remotePath(filename))
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		dst, err := os.Create(localPath)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			dst.Close()
+			return err
+		}
+		return dst.Close()
+	})
+}
+
+func (p *sftpBackupProvider) Delete(filenames []string) error {
+	return p.withClient(func(client *sftp.Client) error {
+		for _, name := range filenames {
+			for _, file := range []string{name, name + ".meta.json"} {
+				if err := client.Remove(p.remotePath(file)); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Test logs in and makes sure the backup directory exists
+func (p *sftpBackupProvider) Test() error {
+	return p.withClient(func(client *sftp.Client) error {
+		if err := client.MkdirAll(p.cfg.Dir); err != nil {
+			return err
+		}
+		info, err := client.Stat(p.cfg.Dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", p.cfg.Dir)
+		}
+		return nil
+	})
+}
+
+// sftpWriteFile uploads through a temporary file that is renamed into place, so other devices
+// never see a partial file
+func sftpWriteFile(client *sftp.Client, target string, src io.Reader) error {
+	if err := client.MkdirAll(path.Dir(target)); err != nil {
+		return err
+	}
+	tmp := path.Join(path.Dir(target), "."+path.Base(target)+".tmp")
+	dst, err := client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		client.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		client.Remove(tmp)
+		return err
+	}
+	// Plain SFTP rename fails if the target exists; servers without the posix-rename extension
+	// get the target removed first
+	if err := client.PosixRename(tmp, target); err != nil {
+		client.Remove(target)
+		if err := client.Rename(tmp, target); err != nil {
+			client.Remove(tmp)
+			return err
+		}
+	}
+	return nil
+}
+
+func testSFTPConnection(cfg *config.SFTPBackupConfig) BackupTestResult {
+	if err := validateSFTPBackupConfig(cfg); err != nil {
+		return BackupTestResult{Success: false, Message: "参数不完整"}
+	}
+	// A test never pins: a key seen without a configured fingerprint is reported so it can be checked
+	p := &sftpBackupProvider{cfg: cfg, pin: func(string) error { return nil }}
+	if err := p.Test(); err != nil {
+		return BackupTestResult{Success: false, Message: fmt.Sprintf("连接失败: %v", err)}
+	}
+	if cfg.HostKey == "" {
+		return BackupTestResult{Success: true, Message: fmt.Sprintf("连接成功，主机密钥指纹 %s，首次备份时将固定此密钥", p.seen)}
+	}
+	return BackupTestResult{Success: true, Message: "连接成功"}
+}
+
+// pinSFTPHostKey saves the host key seen on the first connection to an SFTP server without a
+// configured fingerprint. The key is only saved while the configuration still names that server.
+func (b *BackupService) pinSFTPHostKey(server *config.SFTPBackupConfig, fingerprint string) error {
+	backup := cloneBackupConfig(b.config.GetBackup())
+	if backup == nil || backup.SFTP == nil || backup.SFTP.Host != server.Host || backup.SFTP.Port != server.Port || backup.SFTP.HostKey != "" {
+		return nil
+	}
+	backup.SFTP.HostKey = fingerprint
+	b.config.UpdateBackup(backup)
+	if err := b.saveConfig(); err != nil {
+		return err
+	}
+	logger.Info("Pinned SFTP host key of %s: %s", server.Host, fingerprint)
+	return nil
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/storage"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// startTestSFTPServer serves SFTP on a random local port, accepting the password "secret" and
+// the returned client key. It returns the port, the PEM encoded client key and the fingerprint
+// of the server key.
+func startTestSFTPServer(t *testing.T) (int, string, string) {
+	t.Helper()
+
+	_, hostPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPub, clientPriv, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, _ := ssh.NewPublicKey(clientPub)
+
+	serverCfg := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	serverCfg.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSFTPConn(conn, serverCfg)
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port, string(pem.EncodeToMemory(block)), ssh.FingerprintSHA256(hostSigner.PublicKey())
+}
+
+func serveTestSFTPConn(conn net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					if server, err := sftp.NewServer(channel); err == nil {
+						server.Serve()
+					}
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func TestSFTPBackupProvider(t *testing.T) {
+	port, key, fingerprint := startTestSFTPServer(t)
+	dir := filepath.Join(t.TempDir(), "backups")
+
+	local := filepath.Join(t.TempDir(), "a.ccnx")
+	if err := os.WriteFile(local, []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &sftpBackupProvider{cfg: &config.SFTPBackupConfig{Host: "127.0.0.1", Port: port, Username: "u", PrivateKey: key, Dir: dir, HostKey: fingerprint}}
+	if err := p.Test(); err != nil {
+		t.Fatalf("key auth: %v", err)
+	}
+	if err := p.Put("a.ccnx", local); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err := p.Put("a.ccnx", local); err != nil {
+		t.Fatalf("put over existing file: %v", err)
+	}
+	files, err := p.List()
+	if err != nil || len(files) != 1 || files[0].Filename != "a.ccnx" || files[0].Size != 7 {
+		t.Fatalf("list = %+v, %v", files, err)
+	}
+
+	got := filepath.Join(t.TempDir(), "got")
+	if err := p.Get("a.ccnx", got); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if data, _ := os.ReadFile(got); string(data) != "archive" {
+		t.Fatalf("downloaded %q", data)
+	}
+	if err := p.Get("missing.ccnx", got); backupDownloadError("sftp", "missing.ccnx", err).Error() != "backup_file_not_found" {
+		t.Fatalf("missing file: %v", err)
+	}
+
+	if err := p.Delete([]string{"a.ccnx", "missing.ccnx"}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if files, _ := p.List(); len(files) != 0 {
+		t.Fatalf("files left after delete: %+v", files)
+	}
+
+	wrongPassword := &sftpBackupProvider{cfg: &config.SFTPBackupConfig{Host: "127.0.0.1", Port: port, Username: "u", Password: "wrong", Dir: dir, HostKey: fingerprint}}
+	if err := wrongPassword.Test(); err == nil {
+		t.Fatal("wrong password accepted")
+	}
+	wrongHost := &sftpBackupProvider{cfg: &config.SFTPBackupConfig{Host: "127.0.0.1", Port: port, Username: "u", Password: "secret", Dir: dir, HostKey: "SHA256:other"}}
+	if err := wrongHost.Test(); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Fatalf("unexpected host key accepted: %v", err)
+	}
+	// Without a fingerprint, a key is only accepted when it can be pinned
+	unpinned := &sftpBackupProvider{cfg: &config.SFTPBackupConfig{Host: "127.0.0.1", Port: port, Username: "u", Password: "secret", Dir: dir}}
+	if err := unpinned.Test(); err == nil || !strings.Contains(err.Error(), fingerprint) {
+		t.Fatalf("unknown host key accepted: %v", err)
+	}
+	if result := testSFTPConnection(unpinned.cfg); !result.Success || !strings.Contains(result.Message, fingerprint) {
+		t.Fatalf("connection test = %+v, want the fingerprint reported", result)
+	}
+}
+
+func TestBackupServiceThroughSFTP(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	port, _, fingerprint := startTestSFTPServer(t)
+
+	db := newTestStorage(t)
+	if err := db.SaveEndpoint(&storage.Endpoint{Name: "a", APIUrl: "https://a", APIKey: "k", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.DefaultConfig()
+	b := NewBackupService(cfg, db, "test")
+	if err := b.UpdateSFTPBackupConfig("127.0.0.1", port, "u", "secret", "", "", filepath.Join(t.TempDir(), "remote"), ""); err != nil {
+		t.Fatalf("update config: %v", err)
+	}
+
+	full, err := b.backupToProvider("sftp", "snap", 0)
+	if err != nil {
+		t.Fatalf("full backup: %v", err)
+	}
+	if pinned := cfg.GetBackup().SFTP.HostKey; pinned != fingerprint {
+		t.Fatalf("pinned host key = %q, want %s", pinned, fingerprint)
+	}
+	if saved, _ := db.GetConfig("backup_sftp_hostKey"); saved != fingerprint {
+		t.Fatalf("saved host key = %q, want %s", saved, fingerprint)
+	}
+	delta, err := b.backupToProvider("sftp", "snap2", 0)
+	if err != nil || !isDeltaBackupFilename(delta) {
+		t.Fatalf("delta backup = %q, %v", delta, err)
+	}
+	if base, err := b.deltaBase("sftp", delta); err != nil || base != full {
+		t.Fatalf("base of %s = %q, %v; want %s", delta, base, err, full)
+	}
+
+	var list BackupListResult
+	if err := json.Unmarshal([]byte(b.ListBackups("sftp")), &list); err != nil || !list.Success || len(list.Backups) != 2 {
+		t.Fatalf("list = %+v, %v", list, err)
+	}
+	names := map[string]bool{list.Backups[0].Filename: true, list.Backups[1].Filename: true}
+	if !names[full] || !names[delta] {
+		t.Fatalf("list %+v lacks %s or %s", list.Backups, full, delta)
+	}
+
+	var detect BackupTestResult
+	if err := json.Unmarshal([]byte(b.DetectBackupConflict("sftp", delta)), &detect); err != nil || !detect.Success {
+		t.Fatalf("detect conflicts on delta: %+v, %v", detect, err)
+	}
+	if err := b.RestoreFromProvider("sftp", delta, "merge", func(*config.Config) error { return nil }); err != nil {
+		t.Fatalf("restore delta: %v", err)
+	}
+
+	// A server presenting another key is refused
+	backup := cloneBackupConfig(cfg.GetBackup())
+	backup.SFTP.HostKey = "SHA256:other"
+	cfg.UpdateBackup(backup)
+	if _, err := b.backupToProvider("sftp", "snap3", 0); err == nil {
+		t.Fatal("backup to a server with another host key succeeded")
+	}
+}
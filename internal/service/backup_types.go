@@ -2,12 +2,14 @@ package service
 
 import "time"
 
-type BackupProvider string
+// BackupProviderName is the name a BackupProvider is registered under
+type BackupProviderName string
 
 const (
-	BackupProviderWebDAV BackupProvider = "webdav"
-	BackupProviderLocal  BackupProvider = "local"
-	BackupProviderS3     BackupProvider = "s3"
+	BackupProviderWebDAV BackupProviderName = "webdav"
+	BackupProviderLocal  BackupProviderName = "local"
+	BackupProviderS3     BackupProviderName = "s3"
+	BackupProviderSFTP   BackupProviderName = "sftp"
 )
 
 type BackupListItem struct {
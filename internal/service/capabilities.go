@@ -0,0 +1,101 @@
+package service
+
+import (
+    "encoding/json"
+    "fmt"
+
+    "github.com/lich0821/ccNexus/internal/config"
+    "github.com/lich0821/ccNexus/internal/logger"
+    "github.com/lich0821/ccNexus/internal/proxy"
+    "github.com/lich0821/ccNexus/internal/storage"
+)
+
+// DetectEndpointCapabilities probes an endpoint by index and saves the detected profile.
+// Probing sends a few paid requests, so it only runs when asked for.
+func (e *EndpointService) DetectEndpointCapabilities(index int) string {
+    endpoints := e.config.GetEndpoints()
+
+    if index < 0 || index >= len(endpoints) {
+        result := map[string]interface{}{
+            "success": false,
+            "message": fmt.Sprintf("Invalid endpoint index: %d", index),
+        }
+        data, _ := json.Marshal(result)
+        return string(data)
+    }
+
+    caps, err := e.detectAndSaveCapabilities(endpoints[index])
+    if err != nil {
+        result := map[string]interface{}{
+            "success": false,
+            "message": err.Error(),
+        }
+        data, _ := json.Marshal(result)
+        return string(data)
+    }
+
+    result := map[string]interface{}{
+        "success":      true,
+        "capabilities": caps,
+    }
+    data, _ := json.Marshal(result)
+    return string(data)
+}
+
+// detectAndSaveCapabilities probes an endpoint and stores the profile on the endpoint with the same name
+func (e *EndpointService) detectAndSaveCapabilities(endpoint config.Endpoint) (*config.Capabilities, error) {
+    logger.Info("Detecting capabilities: %s", endpoint.Name)
+
+    caps, err := proxy.DetectCapabilities(endpoint, e.config)
+    if err != nil {
+        logger.Warn("Capability detection failed for %s: %v", endpoint.Name, err)
+        return nil, err
+    }
+
+    if err := e.saveEndpointCapabilities(endpoint.Name, caps); err != nil {
+        return nil, err
+    }
+    logger.Info("Endpoint capabilities detected: %s [%s]", endpoint.Name, caps.String())
+    return caps, nil
+}
+
+// UpdateEndpointCapabilities sets the capability profile of an endpoint by index.
+// capsJSON is a JSON object such as {"images":false}; an empty string clears the profile.
+func (e *EndpointService) UpdateEndpointCapabilities(index int, capsJSON string) error {
+    endpoints := e.config.GetEndpoints()
+
+    if index < 0 || index >= len(endpoints) {
+        return fmt.Errorf("invalid endpoint index: %d", index)
+    }
+
+    caps, err := config.DecodeCapabilities(capsJSON)
+    if err != nil {
+        return err
+    }
+
+    if err := e.saveEndpointCapabilities(endpoints[index].Name, caps); err != nil {
+        return err
+    }
+    logger.Info("Endpoint capabilities updated: %s [%s]", endpoints[index].Name, caps.String())
+    return nil
+}
+
+// saveEndpointCapabilities sets the profile of the named endpoint and persists the config. Only
+// that endpoint changes, so edits made while a detection was running are kept.
+func (e *EndpointService) saveEndpointCapabilities(name string, caps *config.Capabilities) error {
+    if !e.config.UpdateEndpoint(name, func(ep *config.Endpoint) { ep.Capabilities = caps }) {
+        return fmt.Errorf("endpoint '%s' no longer exists", name)
+    }
+
+    if err := e.proxy.UpdateConfig(e.config); err != nil {
+        return err
+    }
+
+    if e.storage != nil {
+        configAdapter := storage.NewConfigStorageAdapter(e.storage)
+        if err := e.config.SaveToStorage(configAdapter); err != nil {
+            return fmt.Errorf("failed to save config: %w", err)
+        }
+    }
+    return nil
+}
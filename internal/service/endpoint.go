@@ -36,11 +36,11 @@ const (
 type EndpointService struct {
     config  *config.Config
     proxy   *proxy.Proxy
-    storage *storage.SQLiteStorage
+    storage storage.Storage
 }
 
 // NewEndpointService creates a new EndpointService
-func NewEndpointService(cfg *config.Config, p *proxy.Proxy, s *storage.SQLiteStorage) *EndpointService {
+func NewEndpointService(cfg *config.Config, p *proxy.Proxy, s storage.Storage) *EndpointService {
     return &EndpointService{
         config:  cfg,
         proxy:   p,
@@ -48,6 +48,14 @@ func NewEndpointService(cfg *config.Config, p *proxy.Proxy, s *storage.SQLiteSto
     }
 }
 
+// endpointBaseURL returns the URL requests are sent to, resolving Azure resource names
+func endpointBaseURL(endpoint config.Endpoint) string {
+    if endpoint.IsAzure() {
+        return endpoint.AzureBaseURL()
+    }
+    return endpoint.APIUrl
+}
+
 // normalizeAPIUrl ensures the API URL has the correct format
 func normalizeAPIUrl(apiUrl string) string {
     return strings.TrimSuffix(apiUrl, "/")
@@ -155,24 +163,29 @@ func (e *EndpointService) UpdateEndpoint(index int, name, apiUrl, apiKey, transf
         }
     }
 
-    enabled := endpoints[index].Enabled
-
     if transformer == "" {
         transformer = "claude"
     }
 
     apiUrl = normalizeAPIUrl(apiUrl)
 
-    endpoints[index] = config.Endpoint{
-        Name:        name,
-        APIUrl:      apiUrl,
-        APIKey:      apiKey,
-        Enabled:     enabled,
-        Transformer: transformer,
-        Model:       model,
-        Remark:      remark,
+    // A profile describes one upstream model; it is re-detected once the target changes
+    capabilities := endpoints[index].Capabilities
+    if apiUrl != endpoints[index].APIUrl || transformer != endpoints[index].Transformer || model != endpoints[index].Model {
+        capabilities = nil
     }
 
+    // Start from the stored endpoint so that settings edited elsewhere are kept
+    updated := endpoints[index]
+    updated.Name = name
+    updated.APIUrl = apiUrl
+    updated.APIKey = apiKey
+    updated.Transformer = transformer
+    updated.Model = model
+    updated.Remark = remark
+    updated.Capabilities = capabilities
+    endpoints[index] = updated
+
     e.config.UpdateEndpoints(endpoints)
 
     if err := e.config.Validate(); err != nil {
@@ -207,6 +220,75 @@ func (e *EndpointService) UpdateEndpoint(index int, name, apiUrl, apiKey, transf
     return nil
 }
 
+// UpdateEndpointAzure updates the Azure OpenAI settings of an endpoint by index
+func (e *EndpointService) UpdateEndpointAzure(index int, resource, deployment, apiVersion string) error {
+    endpoints := e.config.GetEndpoints()
+
+    if index < 0 || index >= len(endpoints) {
+        return fmt.Errorf("invalid endpoint index: %d", index)
+    }
+
+    endpoints[index].AzureResource = strings.TrimSpace(resource)
+    endpoints[index].AzureDeployment = strings.TrimSpace(deployment)
+    endpoints[index].AzureAPIVersion = strings.TrimSpace(apiVersion)
+
+    e.config.UpdateEndpoints(endpoints)
+
+    if err := e.config.Validate(); err != nil {
+        return err
+    }
+
+    if err := e.proxy.UpdateConfig(e.config); err != nil {
+        return err
+    }
+
+    if e.storage != nil {
+        configAdapter := storage.NewConfigStorageAdapter(e.storage)
+        if err := e.config.SaveToStorage(configAdapter); err != nil {
+            return fmt.Errorf("failed to save config: %w", err)
+        }
+    }
+
+    logger.Info("Endpoint Azure settings updated: %s [%s/%s]", endpoints[index].Name, endpoints[index].AzureDeploymentName(), endpoints[index].AzureAPIVersionOrDefault())
+    return nil
+}
+
+// UpdateEndpointRewriteRules replaces the rewrite rules of an endpoint by index.
+// rulesJSON is a JSON array of rules; an empty string clears them.
+func (e *EndpointService) UpdateEndpointRewriteRules(index int, rulesJSON string) error {
+    endpoints := e.config.GetEndpoints()
+
+    if index < 0 || index >= len(endpoints) {
+        return fmt.Errorf("invalid endpoint index: %d", index)
+    }
+
+    rules, err := config.DecodeRewriteRules(rulesJSON)
+    if err != nil {
+        return err
+    }
+    if err := config.ValidateRewriteRules(rules); err != nil {
+        return err
+    }
+
+    endpoints[index].RewriteRules = rules
+
+    e.config.UpdateEndpoints(endpoints)
+
+    if err := e.proxy.UpdateConfig(e.config); err != nil {
+        return err
+    }
+
+    if e.storage != nil {
+        configAdapter := storage.NewConfigStorageAdapter(e.storage)
+        if err := e.config.SaveToStorage(configAdapter); err != nil {
+            return fmt.Errorf("failed to save config: %w", err)
+        }
+    }
+
+    logger.Info("Endpoint rewrite rules updated: %s (%d rules)", endpoints[index].Name, len(rules))
+    return nil
+}
+
 // ToggleEndpoint toggles the enabled state of an endpoint
 func (e *EndpointService) ToggleEndpoint(index int, enabled bool) error {
     endpoints := e.config.GetEndpoints()
@@ -379,6 +461,31 @@ func (e *EndpointService) TestEndpoint(index int) string {
             },
         })
 
+    case "azure":
+        apiPath = endpoint.AzurePath()
+        requestBody, err = json.Marshal(map[string]interface{}{
+            "model":      endpoint.AzureDeploymentName(),
+            "max_tokens": testMaxTokens,
+            "messages": []map[string]interface{}{
+                {"role": "user", "content": testMessage},
+            },
+        })
+
+    case "azure2":
+        apiPath = endpoint.AzurePath()
+        requestBody, err = json.Marshal(map[string]interface{}{
+            "model": endpoint.AzureDeploymentName(),
+            "input": []map[string]interface{}{
+                {
+                    "type": "message",
+                    "role": "user",
+                    "content": []map[string]interface{}{
+                        {"type": "input_text", "text": testMessage},
+                    },
+                },
+            },
+        })
+
     case "gemini":
         model := endpoint.Model
         if model == "" {
@@ -392,6 +499,17 @@ func (e *EndpointService) TestEndpoint(index int) string {
             "generationConfig": map[string]int{"maxOutputTokens": testMaxTokens},
         })
 
+    case "ollama":
+        apiPath = "/api/chat"
+        requestBody, err = json.Marshal(map[string]interface{}{
+            "model":  endpoint.Model,
+            "stream": false,
+            "messages": []map[string]interface{}{
+                {"role": "user", "content": testMessage},
+            },
+            "options": map[string]int{"num_predict": testMaxTokens},
+        })
+
     default:
         result := map[string]interface{}{
             "success": false,
@@ -410,7 +528,7 @@ func (e *EndpointService) TestEndpoint(index int) string {
         return string(data)
     }
 
-    normalizedAPIUrl := normalizeAPIUrl(endpoint.APIUrl)
+    normalizedAPIUrl := normalizeAPIUrl(endpointBaseURL(endpoint))
     if !strings.HasPrefix(normalizedAPIUrl, "http://") && !strings.HasPrefix(normalizedAPIUrl, "https://") {
         normalizedAPIUrl = "https://" + normalizedAPIUrl
     }
@@ -433,10 +551,19 @@ func (e *EndpointService) TestEndpoint(index int) string {
         req.Header.Set("anthropic-version", "2023-06-01")
     case "openai", "openai2":
         req.Header.Set("Authorization", "Bearer "+endpoint.APIKey)
+    case "azure", "azure2":
+        req.Header.Set("api-key", endpoint.APIKey)
+        q := req.URL.Query()
+        q.Set("api-version", endpoint.AzureAPIVersionOrDefault())
+        req.URL.RawQuery = q.Encode()
     case "gemini":
         q := req.URL.Query()
         q.Add("key", endpoint.APIKey)
         req.URL.RawQuery = q.Encode()
+    case "ollama":
+        if endpoint.APIKey != "" {
+            req.Header.Set("Authorization", "Bearer "+endpoint.APIKey)
+        }
     }
 
     client := e.createHTTPClient(30 * time.Second)
@@ -494,7 +621,7 @@ func (e *EndpointService) TestEndpoint(index int) string {
                 }
             }
         }
-    case "openai":
+    case "openai", "azure":
         if choices, ok := responseData["choices"].([]interface{}); ok && len(choices) > 0 {
             if choice, ok := choices[0].(map[string]interface{}); ok {
                 if msg, ok := choice["message"].(map[string]interface{}); ok {
@@ -518,6 +645,12 @@ func (e *EndpointService) TestEndpoint(index int) string {
                 }
             }
         }
+    case "ollama":
+        if msg, ok := responseData["message"].(map[string]interface{}); ok {
+            if content, ok := msg["content"].(string); ok {
+                message = content
+            }
+        }
     }
 
     if message == "" {
@@ -552,7 +685,7 @@ func (e *EndpointService) TestEndpointLight(index int) string {
         transformer = "claude"
     }
 
-    normalizedURL := normalizeAPIUrl(endpoint.APIUrl)
+    normalizedURL := normalizeAPIUrl(endpointBaseURL(endpoint))
     if !strings.HasPrefix(normalizedURL, "http://") && !strings.HasPrefix(normalizedURL, "https://") {
         normalizedURL = "https://" + normalizedURL
     }
@@ -586,7 +719,11 @@ func (e *EndpointService) TestEndpointLight(index int) string {
     }
 
     // Step 3: Minimal request (fallback)
-    statusCode, err = e.testMinimalRequest(normalizedURL, endpoint.APIKey, transformer, endpoint.Model)
+    model := endpoint.Model
+    if endpoint.IsAzure() {
+        model = endpoint.AzureDeploymentName()
+    }
+    statusCode, err = e.testMinimalRequest(normalizedURL, endpoint.APIKey, transformer, model)
     if err == nil {
         return e.testResult(true, "ok", "minimal", "Minimal request successful")
     }
@@ -622,7 +759,7 @@ func (e *EndpointService) TestAllEndpointsZeroCost() string {
             transformer = "claude"
         }
 
-        normalizedURL := normalizeAPIUrl(endpoint.APIUrl)
+        normalizedURL := normalizeAPIUrl(endpointBaseURL(endpoint))
         if !strings.HasPrefix(normalizedURL, "http://") && !strings.HasPrefix(normalizedURL, "https://") {
             normalizedURL = "https://" + normalizedURL
         }
@@ -661,9 +798,14 @@ func (e *EndpointService) TestAllEndpointsZeroCost() string {
 
 func (e *EndpointService) testModelsAPI(apiUrl, apiKey, transformer string) (int, error) {
     var url string
-    if transformer == "gemini" {
+    switch transformer {
+    case "gemini":
         url = fmt.Sprintf("%s/v1beta/models?key=%s", apiUrl, apiKey)
-    } else {
+    case "azure", "azure2":
+        url = fmt.Sprintf("%s/openai/models?api-version=%s", apiUrl, config.DefaultAzureAPIVersion)
+    case "ollama":
+        url = fmt.Sprintf("%s/api/tags", apiUrl)
+    default:
         url = fmt.Sprintf("%s/v1/models", apiUrl)
     }
 
@@ -672,7 +814,15 @@ func (e *EndpointService) testModelsAPI(apiUrl, apiKey, transformer string) (int
         return 0, err
     }
 
-    if transformer != "gemini" {
+    switch transformer {
+    case "gemini":
+    case "azure", "azure2":
+        req.Header.Set("api-key", apiKey)
+    case "ollama":
+        if apiKey != "" {
+            req.Header.Set("Authorization", "Bearer "+apiKey)
+        }
+    default:
         req.Header.Set("Authorization", "Bearer "+apiKey)
     }
 
@@ -832,6 +982,21 @@ func (e *EndpointService) testMinimalRequest(apiUrl, apiKey, transformer, model
                 {"type": "message", "role": "user", "content": []map[string]interface{}{{"type": "input_text", "text": "Hi"}}},
             },
         })
+    case "azure":
+        url = fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s", apiUrl, model, config.DefaultAzureAPIVersion)
+        body, _ = json.Marshal(map[string]interface{}{
+            "model":      model,
+            "max_tokens": 1,
+            "messages":   []map[string]interface{}{{"role": "user", "content": "Hi"}},
+        })
+    case "azure2":
+        url = fmt.Sprintf("%s/openai/responses?api-version=%s", apiUrl, config.DefaultAzureResponsesAPIVersion)
+        body, _ = json.Marshal(map[string]interface{}{
+            "model": model,
+            "input": []map[string]interface{}{
+                {"type": "message", "role": "user", "content": []map[string]interface{}{{"type": "input_text", "text": "Hi"}}},
+            },
+        })
     case "gemini":
         if model == "" {
             model = "gemini-2.0-flash"
@@ -841,6 +1006,14 @@ func (e *EndpointService) testMinimalRequest(apiUrl, apiKey, transformer, model
             "contents":         []map[string]interface{}{{"parts": []map[string]string{{"text": "Hi"}}}},
             "generationConfig": map[string]int{"maxOutputTokens": 1},
         })
+    case "ollama":
+        url = fmt.Sprintf("%s/api/chat", apiUrl)
+        body, _ = json.Marshal(map[string]interface{}{
+            "model":    model,
+            "stream":   false,
+            "messages": []map[string]interface{}{{"role": "user", "content": "Hi"}},
+            "options":  map[string]int{"num_predict": 1},
+        })
     default:
         return 0, fmt.Errorf("unsupported transformer: %s", transformer)
     }
@@ -854,6 +1027,12 @@ func (e *EndpointService) testMinimalRequest(apiUrl, apiKey, transformer, model
     if transformer == "claude" {
         req.Header.Set("x-api-key", apiKey)
         req.Header.Set("anthropic-version", "2023-06-01")
+    } else if transformer == "azure" || transformer == "azure2" {
+        req.Header.Set("api-key", apiKey)
+    } else if transformer == "ollama" {
+        if apiKey != "" {
+            req.Header.Set("Authorization", "Bearer "+apiKey)
+        }
     } else if transformer != "gemini" {
         req.Header.Set("Authorization", "Bearer "+apiKey)
     }
@@ -879,31 +1058,7 @@ func (e *EndpointService) FetchModels(apiUrl, apiKey, transformer string) string
         transformer = "claude"
     }
 
-    normalizedAPIUrl := normalizeAPIUrl(apiUrl)
-    if !strings.HasPrefix(normalizedAPIUrl, "http://") && !strings.HasPrefix(normalizedAPIUrl, "https://") {
-        normalizedAPIUrl = "https://" + normalizedAPIUrl
-    }
-
-    var models []string
-    var err error
-
-    switch transformer {
-    case "claude":
-        models, err = e.fetchOpenAIModels(normalizedAPIUrl, apiKey)
-    case "openai", "openai2":
-        models, err = e.fetchOpenAIModels(normalizedAPIUrl, apiKey)
-    case "gemini":
-        models, err = e.fetchGeminiModels(normalizedAPIUrl, apiKey)
-    default:
-        result := map[string]interface{}{
-            "success": false,
-            "message": fmt.Sprintf("Unsupported transformer: %s", transformer),
-            "models":  []string{},
-        }
-        data, _ := json.Marshal(result)
-        return string(data)
-    }
-
+    models, err := e.fetchModels(apiUrl, apiKey, transformer)
     if err != nil {
         result := map[string]interface{}{
             "success": false,
@@ -924,6 +1079,39 @@ func (e *EndpointService) FetchModels(apiUrl, apiKey, transformer string) string
     return string(data)
 }
 
+// ListModels fetches the models of a configured endpoint; it implements proxy.ModelLister
+func (e *EndpointService) ListModels(endpoint config.Endpoint) ([]string, error) {
+    transformer := endpoint.Transformer
+    if transformer == "" {
+        transformer = "claude"
+    }
+    apiUrl := endpoint.APIUrl
+    if endpoint.IsAzure() {
+        apiUrl = endpoint.AzureBaseURL()
+    }
+    return e.fetchModels(apiUrl, endpoint.APIKey, transformer)
+}
+
+// fetchModels fetches the models of a provider with the API of the given transformer
+func (e *EndpointService) fetchModels(apiUrl, apiKey, transformer string) ([]string, error) {
+    normalizedAPIUrl := normalizeAPIUrl(apiUrl)
+    if !strings.HasPrefix(normalizedAPIUrl, "http://") && !strings.HasPrefix(normalizedAPIUrl, "https://") {
+        normalizedAPIUrl = "https://" + normalizedAPIUrl
+    }
+
+    switch transformer {
+    case "claude", "openai", "openai2":
+        return e.fetchOpenAIModels(normalizedAPIUrl, apiKey)
+    case "azure", "azure2":
+        return e.fetchAzureModels(normalizedAPIUrl, apiKey)
+    case "gemini":
+        return e.fetchGeminiModels(normalizedAPIUrl, apiKey)
+    case "ollama":
+        return e.fetchOllamaModels(normalizedAPIUrl, apiKey)
+    }
+    return nil, fmt.Errorf("Unsupported transformer: %s", transformer)
+}
+
 func (e *EndpointService) fetchOpenAIModels(apiUrl, apiKey string) ([]string, error) {
     url := fmt.Sprintf("%s/v1/models", apiUrl)
 
@@ -968,6 +1156,52 @@ func (e *EndpointService) fetchOpenAIModels(apiUrl, apiKey string) ([]string, er
     return models, nil
 }
 
+// fetchAzureModels lists the models available on an Azure OpenAI resource
+func (e *EndpointService) fetchAzureModels(apiUrl, apiKey string) ([]string, error) {
+    url := fmt.Sprintf("%s/openai/models?api-version=%s", apiUrl, config.DefaultAzureAPIVersion)
+
+    req, err := http.NewRequest("GET", url, nil)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create request: %v", err)
+    }
+
+    req.Header.Set("api-key", apiKey)
+
+    client := e.createHTTPClient(30 * time.Second)
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("request failed: %v", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        body, _ := io.ReadAll(resp.Body)
+        return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+    }
+
+    var result struct {
+        Data []struct {
+            ID string `json:"id"`
+        } `json:"data"`
+    }
+
+    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+        return nil, fmt.Errorf("failed to parse response: %v", err)
+    }
+
+    seen := make(map[string]bool)
+    models := make([]string, 0, len(result.Data))
+    for _, m := range result.Data {
+        id := strings.TrimSpace(m.ID)
+        if id != "" && !seen[id] {
+            seen[id] = true
+            models = append(models, id)
+        }
+    }
+
+    return models, nil
+}
+
 func (e *EndpointService) fetchGeminiModels(apiUrl, apiKey string) ([]string, error) {
     url := fmt.Sprintf("%s/v1beta/models?key=%s", apiUrl, apiKey)
 
@@ -1009,3 +1243,48 @@ func (e *EndpointService) fetchGeminiModels(apiUrl, apiKey string) ([]string, er
 
     return models, nil
 }
+
+// fetchOllamaModels lists the locally pulled models via /api/tags
+func (e *EndpointService) fetchOllamaModels(apiUrl, apiKey string) ([]string, error) {
+    url := fmt.Sprintf("%s/api/tags", apiUrl)
+
+    req, err := http.NewRequest("GET", url, nil)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create request: %v", err)
+    }
+
+    if apiKey != "" {
+        req.Header.Set("Authorization", "Bearer "+apiKey)
+    }
+
+    client := e.createHTTPClient(30 * time.Second)
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("request failed: %v", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        body, _ := io.ReadAll(resp.Body)
+        return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+    }
+
+    var result struct {
+        Models []struct {
+            Name string `json:"name"`
+        } `json:"models"`
+    }
+
+    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+        return nil, fmt.Errorf("failed to parse response: %v", err)
+    }
+
+    models := make([]string, 0, len(result.Models))
+    for _, m := range result.Models {
+        if name := strings.TrimSpace(m.Name); name != "" {
+            models = append(models, name)
+        }
+    }
+
+    return models, nil
+}
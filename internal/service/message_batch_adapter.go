@@ -0,0 +1,88 @@
+package service
+
+import (
+	"github.com/lich0821/ccNexus/internal/proxy"
+	"github.com/lich0821/ccNexus/internal/storage"
+)
+
+// MessageBatchAdapter adapts a storage.Storage to the proxy.MessageBatchStorage interface
+type MessageBatchAdapter struct {
+	storage storage.Storage
+}
+
+// NewMessageBatchAdapter creates a new adapter
+func NewMessageBatchAdapter(s storage.Storage) *MessageBatchAdapter {
+	return &MessageBatchAdapter{storage: s}
+}
+
+// CreateMessageBatch stores a batch and its items
+func (a *MessageBatchAdapter) CreateMessageBatch(batch *proxy.MessageBatch, items []proxy.MessageBatchItem) error {
+	stored := make([]storage.MessageBatchItem, len(items))
+	for i, item := range items {
+		stored[i] = storage.MessageBatchItem(item)
+	}
+	return a.storage.CreateMessageBatch((*storage.MessageBatch)(batch), stored)
+}
+
+// GetMessageBatch returns a batch, or nil when it does not exist
+func (a *MessageBatchAdapter) GetMessageBatch(id string) (*proxy.MessageBatch, error) {
+	batch, err := a.storage.GetMessageBatch(id)
+	return (*proxy.MessageBatch)(batch), err
+}
+
+// ListMessageBatches returns a page of batches, newest first
+func (a *MessageBatchAdapter) ListMessageBatches(limit int, beforeID, afterID string) ([]proxy.MessageBatch, bool, error) {
+	batches, hasMore, err := a.storage.ListMessageBatches(limit, beforeID, afterID)
+	if err != nil {
+		return nil, false, err
+	}
+	result := make([]proxy.MessageBatch, len(batches))
+	for i, batch := range batches {
+		result[i] = proxy.MessageBatch(batch)
+	}
+	return result, hasMore, nil
+}
+
+// UpdateMessageBatch saves the state of a batch
+func (a *MessageBatchAdapter) UpdateMessageBatch(batch *proxy.MessageBatch) error {
+	return a.storage.UpdateMessageBatch((*storage.MessageBatch)(batch))
+}
+
+// DeleteMessageBatch removes a batch and its items
+func (a *MessageBatchAdapter) DeleteMessageBatch(id string) error {
+	return a.storage.DeleteMessageBatch(id)
+}
+
+// ClaimMessageBatchItem takes the next pending item off the queue
+func (a *MessageBatchAdapter) ClaimMessageBatchItem() (*proxy.MessageBatchItem, error) {
+	item, err := a.storage.ClaimMessageBatchItem()
+	return (*proxy.MessageBatchItem)(item), err
+}
+
+// FinishMessageBatchItem records the outcome of an item
+func (a *MessageBatchAdapter) FinishMessageBatchItem(batchID string, index int, status, result string) error {
+	return a.storage.FinishMessageBatchItem(batchID, index, status, result)
+}
+
+// SettlePendingMessageBatchItems finishes every pending item of a batch
+func (a *MessageBatchAdapter) SettlePendingMessageBatchItems(batchID, status, result string) error {
+	return a.storage.SettlePendingMessageBatchItems(batchID, status, result)
+}
+
+// ResetRunningMessageBatchItems requeues items left running by an interrupted process
+func (a *MessageBatchAdapter) ResetRunningMessageBatchItems() error {
+	return a.storage.ResetRunningMessageBatchItems()
+}
+
+// GetMessageBatchItems returns the items of a batch in submission order
+func (a *MessageBatchAdapter) GetMessageBatchItems(batchID string) ([]proxy.MessageBatchItem, error) {
+	items, err := a.storage.GetMessageBatchItems(batchID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]proxy.MessageBatchItem, len(items))
+	for i, item := range items {
+		result[i] = proxy.MessageBatchItem(item)
+	}
+	return result, nil
+}
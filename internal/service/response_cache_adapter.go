@@ -0,0 +1,90 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/proxy"
+	"github.com/lich0821/ccNexus/internal/storage"
+)
+
+// ResponseCacheAdapter adapts a storage.Storage to the proxy.ResponseCacheStorage interface
+type ResponseCacheAdapter struct {
+	storage storage.Storage
+}
+
+// NewResponseCacheAdapter creates a new adapter
+func NewResponseCacheAdapter(s storage.Storage) *ResponseCacheAdapter {
+	return &ResponseCacheAdapter{storage: s}
+}
+
+// GetCachedResponse returns an unexpired cached response, or nil when there is none
+func (a *ResponseCacheAdapter) GetCachedResponse(key string) (*proxy.CachedResponse, error) {
+	entry, err := a.storage.GetCachedResponse(key)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	result := &proxy.CachedResponse{
+		Key:          entry.Key,
+		EndpointName: entry.EndpointName,
+		Model:        entry.Model,
+		Status:       entry.Status,
+		Header:       make(http.Header),
+		Body:         entry.Body,
+		InputTokens:  entry.InputTokens,
+		OutputTokens: entry.OutputTokens,
+		CreatedAt:    entry.CreatedAt,
+		ExpiresAt:    entry.ExpiresAt,
+	}
+	if entry.Headers != "" {
+		if err := json.Unmarshal([]byte(entry.Headers), &result.Header); err != nil {
+			return nil, err
+		}
+	}
+	if entry.Chunks != "" {
+		var chunks [][2]int64
+		if err := json.Unmarshal([]byte(entry.Chunks), &chunks); err != nil {
+			return nil, err
+		}
+		for _, c := range chunks {
+			result.Chunks = append(result.Chunks, proxy.CachedChunk{Offset: time.Duration(c[0]) * time.Millisecond, Length: int(c[1])})
+		}
+	}
+	return result, nil
+}
+
+// PutCachedResponse stores a response and enforces the cache limits
+func (a *ResponseCacheAdapter) PutCachedResponse(entry *proxy.CachedResponse, maxEntries int, maxBytes int64) error {
+	headers, err := json.Marshal(entry.Header)
+	if err != nil {
+		return err
+	}
+	var chunks string
+	if len(entry.Chunks) > 0 {
+		pairs := make([][2]int64, len(entry.Chunks))
+		for i, c := range entry.Chunks {
+			pairs[i] = [2]int64{c.Offset.Milliseconds(), int64(c.Length)}
+		}
+		data, err := json.Marshal(pairs)
+		if err != nil {
+			return err
+		}
+		chunks = string(data)
+	}
+
+	return a.storage.PutCachedResponse(&storage.CachedResponse{
+		Key:          entry.Key,
+		EndpointName: entry.EndpointName,
+		Model:        entry.Model,
+		Status:       entry.Status,
+		Headers:      string(headers),
+		Body:         entry.Body,
+		Chunks:       chunks,
+		InputTokens:  entry.InputTokens,
+		OutputTokens: entry.OutputTokens,
+		CreatedAt:    entry.CreatedAt,
+		ExpiresAt:    entry.ExpiresAt,
+	}, maxEntries, maxBytes)
+}
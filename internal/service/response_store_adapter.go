@@ -0,0 +1,34 @@
+package service
+
+import (
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/proxy"
+	"github.com/lich0821/ccNexus/internal/storage"
+)
+
+// ResponseStoreAdapter adapts a storage.Storage to the proxy.ResponseStore interface
+type ResponseStoreAdapter struct {
+	storage storage.Storage
+}
+
+// NewResponseStoreAdapter creates a new adapter
+func NewResponseStoreAdapter(s storage.Storage) *ResponseStoreAdapter {
+	return &ResponseStoreAdapter{storage: s}
+}
+
+// GetStoredResponse returns a stored response, or nil when it does not exist
+func (a *ResponseStoreAdapter) GetStoredResponse(id string) (*proxy.StoredResponse, error) {
+	r, err := a.storage.GetStoredResponse(id)
+	return (*proxy.StoredResponse)(r), err
+}
+
+// PutStoredResponse stores a response and drops the ones created before cutoff
+func (a *ResponseStoreAdapter) PutStoredResponse(r *proxy.StoredResponse, cutoff time.Time) error {
+	return a.storage.PutStoredResponse((*storage.StoredResponse)(r), cutoff)
+}
+
+// DeleteStoredResponse removes a stored response, reporting whether it existed
+func (a *ResponseStoreAdapter) DeleteStoredResponse(id string) (bool, error) {
+	return a.storage.DeleteStoredResponse(id)
+}
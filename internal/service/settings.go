@@ -15,11 +15,11 @@ import (
 // SettingsService handles settings operations
 type SettingsService struct {
     config  *config.Config
-    storage *storage.SQLiteStorage
+    storage storage.Storage
 }
 
 // NewSettingsService creates a new SettingsService
-func NewSettingsService(cfg *config.Config, s *storage.SQLiteStorage) *SettingsService {
+func NewSettingsService(cfg *config.Config, s storage.Storage) *SettingsService {
     return &SettingsService{config: cfg, storage: s}
 }
 
@@ -310,6 +310,150 @@ func (s *SettingsService) SetProxyURL(proxyURL string) error {
     return nil
 }
 
+// GetContextGuard returns the context guard configuration as JSON
+func (s *SettingsService) GetContextGuard() string {
+    guard := s.config.GetContextGuard()
+    if guard == nil {
+        guard = &config.ContextGuardConfig{ModelLimits: map[string]int{}}
+    }
+    data, _ := json.Marshal(guard)
+    return string(data)
+}
+
+// SetContextGuard updates the context guard configuration from JSON; an empty string removes it
+func (s *SettingsService) SetContextGuard(guardJSON string) error {
+    guard, err := config.DecodeContextGuard(guardJSON)
+    if err != nil {
+        return err
+    }
+    s.config.UpdateContextGuard(guard)
+
+    if s.storage != nil {
+        configAdapter := storage.NewConfigStorageAdapter(s.storage)
+        if err := s.config.SaveToStorage(configAdapter); err != nil {
+            return fmt.Errorf("failed to save context guard config: %w", err)
+        }
+    }
+
+    if guard != nil && guard.Enabled {
+        logger.Info("Context guard enabled with %d model limits", len(guard.ModelLimits))
+    } else {
+        logger.Info("Context guard disabled")
+    }
+    return nil
+}
+
+// GetResponseCache returns the response cache configuration as JSON
+func (s *SettingsService) GetResponseCache() string {
+    cache := s.config.GetResponseCache()
+    if cache == nil {
+        cache = &config.ResponseCacheConfig{}
+    }
+    data, _ := json.Marshal(cache)
+    return string(data)
+}
+
+// SetResponseCache updates the response cache configuration from JSON; an empty string removes it
+func (s *SettingsService) SetResponseCache(cacheJSON string) error {
+    cache, err := config.DecodeResponseCache(cacheJSON)
+    if err != nil {
+        return err
+    }
+    s.config.UpdateResponseCache(cache)
+
+    if s.storage != nil {
+        configAdapter := storage.NewConfigStorageAdapter(s.storage)
+        if err := s.config.SaveToStorage(configAdapter); err != nil {
+            return fmt.Errorf("failed to save response cache config: %w", err)
+        }
+    }
+
+    if cache != nil && cache.Enabled {
+        logger.Info("Response cache enabled (ttl %ds, replay %s)", cache.TTLOrDefault(), cache.ReplayOrDefault())
+    } else {
+        logger.Info("Response cache disabled")
+    }
+    return nil
+}
+
+// ClearResponseCache removes every cached response
+func (s *SettingsService) ClearResponseCache() error {
+    if s.storage == nil {
+        return nil
+    }
+    if err := s.storage.ClearResponseCache(); err != nil {
+        return fmt.Errorf("failed to clear response cache: %w", err)
+    }
+    logger.Info("Response cache cleared")
+    return nil
+}
+
+// GetToolRepair returns the tool repair configuration as JSON
+func (s *SettingsService) GetToolRepair() string {
+    repair := s.config.GetToolRepair()
+    if repair == nil {
+        repair = &config.ToolRepairConfig{}
+    }
+    data, _ := json.Marshal(repair)
+    return string(data)
+}
+
+// SetToolRepair updates the tool repair configuration from JSON; an empty string removes it
+func (s *SettingsService) SetToolRepair(repairJSON string) error {
+    repair, err := config.DecodeToolRepair(repairJSON)
+    if err != nil {
+        return err
+    }
+    s.config.UpdateToolRepair(repair)
+
+    if s.storage != nil {
+        configAdapter := storage.NewConfigStorageAdapter(s.storage)
+        if err := s.config.SaveToStorage(configAdapter); err != nil {
+            return fmt.Errorf("failed to save tool repair config: %w", err)
+        }
+    }
+
+    if repair != nil && repair.Enabled {
+        logger.Info("Tool repair enabled (action %s)", repair.ActionOrDefault())
+    } else {
+        logger.Info("Tool repair disabled")
+    }
+    return nil
+}
+
+// GetServerTools returns the server tools configuration as JSON
+func (s *SettingsService) GetServerTools() string {
+    tools := s.config.GetServerTools()
+    if tools == nil {
+        tools = &config.ServerToolsConfig{}
+    }
+    data, _ := json.Marshal(tools)
+    return string(data)
+}
+
+// SetServerTools updates the server tools configuration from JSON; an empty string removes it
+func (s *SettingsService) SetServerTools(toolsJSON string) error {
+    tools, err := config.DecodeServerTools(toolsJSON)
+    if err != nil {
+        return err
+    }
+    s.config.UpdateServerTools(tools)
+
+    if s.storage != nil {
+        configAdapter := storage.NewConfigStorageAdapter(s.storage)
+        if err := s.config.SaveToStorage(configAdapter); err != nil {
+            return fmt.Errorf("failed to save server tools config: %w", err)
+        }
+    }
+
+    if tools != nil && tools.Enabled {
+        logger.Info("Server tools enabled: %v", tools.Tools)
+    } else {
+        logger.Info("Server tools disabled")
+    }
+    return nil
+}
+
 // SettingsData represents the settings data for batch save
 type SettingsData struct {
 	CloseWindowBehavior       string `json:"closeWindowBehavior"`
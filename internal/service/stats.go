@@ -2,21 +2,25 @@ package service
 
 import (
 	"encoding/json"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/lich0821/ccNexus/internal/config"
 	"github.com/lich0821/ccNexus/internal/proxy"
+	"github.com/lich0821/ccNexus/internal/storage"
 )
 
 // StatsService handles statistics operations
 type StatsService struct {
-	proxy  *proxy.Proxy
-	config *config.Config
+	proxy   *proxy.Proxy
+	config  *config.Config
+	storage storage.Storage
 }
 
 // NewStatsService creates a new stats service
-func NewStatsService(p *proxy.Proxy, cfg *config.Config) *StatsService {
-	return &StatsService{proxy: p, config: cfg}
+func NewStatsService(p *proxy.Proxy, cfg *config.Config, s storage.Storage) *StatsService {
+	return &StatsService{proxy: p, config: cfg, storage: s}
 }
 
 // GetStats returns current statistics
@@ -66,12 +70,14 @@ func (s *StatsService) getPeriodStats(period, startDate, endDate string) string
 		stats = s.proxy.GetStats().GetPeriodStats(startDate, endDate)
 	}
 
-	var totalRequests, totalErrors, totalInputTokens, totalOutputTokens int
+	var totalRequests, totalErrors, totalInputTokens, totalOutputTokens, totalCacheHits, totalSavedTokens int
 	for _, st := range stats {
 		totalRequests += st.Requests
 		totalErrors += st.Errors
 		totalInputTokens += st.InputTokens
 		totalOutputTokens += st.OutputTokens
+		totalCacheHits += st.CacheHits
+		totalSavedTokens += st.SavedTokens
 	}
 
 	activeEndpoints, totalEndpoints := s.countEndpoints()
@@ -83,6 +89,8 @@ func (s *StatsService) getPeriodStats(period, startDate, endDate string) string
 		"totalSuccess":      totalRequests - totalErrors,
 		"totalInputTokens":  totalInputTokens,
 		"totalOutputTokens": totalOutputTokens,
+		"totalCacheHits":    totalCacheHits,
+		"totalSavedTokens":  totalSavedTokens,
 		"activeEndpoints":   activeEndpoints,
 		"totalEndpoints":    totalEndpoints,
 		"endpoints":         stats,
@@ -168,6 +176,100 @@ func (s *StatsService) GetStatsTrendByPeriod(period string) string {
 	return string(data)
 }
 
+// GetStatsUsage returns the usage of a period (daily, yesterday, weekly, monthly) by time bucket
+// of the granularity (hour or day), split by the comma separated groupBy dimensions
+// (endpoint, clientModel, upstreamModel, clientFormat, device)
+func (s *StatsService) GetStatsUsage(period, granularity, groupBy string) string {
+	startDate, endDate := StatsPeriodRange(period, time.Now())
+	query, err := NewUsageQuery(granularity, groupBy, startDate, endDate, "")
+	if err != nil {
+		data, _ := json.Marshal(map[string]interface{}{"error": err.Error()})
+		return string(data)
+	}
+	buckets, err := s.storage.GetUsageStats(query)
+	if err != nil {
+		data, _ := json.Marshal(map[string]interface{}{"error": err.Error()})
+		return string(data)
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"period":      period,
+		"startDate":   startDate,
+		"endDate":     endDate,
+		"granularity": query.Granularity,
+		"groupBy":     query.GroupBy,
+		"series":      buckets,
+	})
+	return string(data)
+}
+
+// ExportStats writes the stats selected by the JSON export options to a file and returns the
+// number of rows and the path as JSON
+func (s *StatsService) ExportStats(optionsJSON, path string) string {
+	var opts StatsExportOptions
+	err := json.Unmarshal([]byte(optionsJSON), &opts)
+	if err == nil {
+		err = opts.Normalize()
+	}
+	if err != nil {
+		data, _ := json.Marshal(map[string]interface{}{"error": err.Error()})
+		return string(data)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		data, _ := json.Marshal(map[string]interface{}{"error": err.Error()})
+		return string(data)
+	}
+	rows, err := ExportStats(s.storage, opts, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		data, _ := json.Marshal(map[string]interface{}{"error": err.Error()})
+		return string(data)
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{"path": path, "rows": rows, "format": opts.Format})
+	return string(data)
+}
+
+// StatsPeriodRange returns the first and last date of a stats period (daily, yesterday, weekly, monthly)
+func StatsPeriodRange(period string, now time.Time) (string, string) {
+	today := now.Format("2006-01-02")
+	switch period {
+	case "yesterday":
+		yesterday := now.AddDate(0, 0, -1).Format("2006-01-02")
+		return yesterday, yesterday
+	case "weekly":
+		weekday := int(now.Weekday())
+		if weekday == 0 {
+			weekday = 7
+		}
+		return now.AddDate(0, 0, -(weekday - 1)).Format("2006-01-02"), today
+	case "monthly":
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).Format("2006-01-02"), today
+	default: // daily
+		return today, today
+	}
+}
+
+// NewUsageQuery parses the granularity and comma separated groupBy parameters of a usage query
+// and validates them with storage.UsageQuery.Validate; an empty granularity means days
+func NewUsageQuery(granularity, groupBy, startDate, endDate, deviceID string) (storage.UsageQuery, error) {
+	query := storage.UsageQuery{Granularity: granularity, GroupBy: []string{}, StartDate: startDate, EndDate: endDate, DeviceID: deviceID}
+	if query.Granularity == "" {
+		query.Granularity = storage.UsageGranularityDay
+	}
+	for _, dim := range strings.Split(groupBy, ",") {
+		if dim = strings.TrimSpace(dim); dim != "" {
+			query.GroupBy = append(query.GroupBy, dim)
+		}
+	}
+	return query, query.Validate()
+}
+
 type statsSummary struct {
 	requests, errors, tokens int
 }
@@ -0,0 +1,336 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/lich0821/ccNexus/internal/storage"
+)
+
+// Stats export formats
+const (
+	StatsExportCSV     = "csv"
+	StatsExportJSONL   = "jsonl"
+	StatsExportParquet = "parquet"
+)
+
+// StatsExportOptions selects the stats to export. Exports by endpoint, device and date come from
+// the daily stats of all devices; model and client format dimensions and hourly rows come from the
+// local usage rollups, which start when hourly stats were introduced.
+type StatsExportOptions struct {
+	StartDate   string   `json:"startDate"`             // 2006-01-02, inclusive
+	EndDate     string   `json:"endDate"`               // 2006-01-02, inclusive
+	Granularity string   `json:"granularity,omitempty"` // hour | day (default) | month
+	Dimensions  []string `json:"dimensions,omitempty"`  // endpoint, device, clientModel, upstreamModel, clientFormat
+	Format      string   `json:"format,omitempty"`      // csv (default) | jsonl | parquet
+}
+
+// StatsExportRow is one exported row; dimensions that are not exported are empty
+type StatsExportRow struct {
+	Period        string `json:"period" parquet:"period"`
+	Endpoint      string `json:"endpoint,omitempty" parquet:"endpoint"`
+	Device        string `json:"device,omitempty" parquet:"device"`
+	ClientModel   string `json:"clientModel,omitempty" parquet:"client_model"`
+	UpstreamModel string `json:"upstreamModel,omitempty" parquet:"upstream_model"`
+	ClientFormat  string `json:"clientFormat,omitempty" parquet:"client_format"`
+	Requests      int64  `json:"requests" parquet:"requests"`
+	Errors        int64  `json:"errors" parquet:"errors"`
+	InputTokens   int64  `json:"inputTokens" parquet:"input_tokens"`
+	OutputTokens  int64  `json:"outputTokens" parquet:"output_tokens"`
+	CacheHits     int64  `json:"cacheHits" parquet:"cache_hits"`
+	SavedTokens   int64  `json:"savedTokens" parquet:"saved_tokens"`
+}
+
+// statsExportDimensions are the dimensions in column order
+var statsExportDimensions = []string{
+	storage.UsageGroupEndpoint, storage.UsageGroupDevice, storage.UsageGroupClientModel,
+	storage.UsageGroupUpstreamModel, storage.UsageGroupClientFormat,
+}
+
+// Normalize validates the options and fills in the defaults
+func (o *StatsExportOptions) Normalize() error {
+	start, err := time.Parse("2006-01-02", o.StartDate)
+	if err != nil {
+		return fmt.Errorf("stats_export_invalid_date")
+	}
+	end, err := time.Parse("2006-01-02", o.EndDate)
+	if err != nil || end.Before(start) {
+		return fmt.Errorf("stats_export_invalid_date")
+	}
+
+	switch o.Granularity {
+	case "":
+		o.Granularity = "day"
+	case "hour", "day", "month":
+	default:
+		return fmt.Errorf("stats_export_invalid_granularity")
+	}
+	switch o.Format {
+	case "":
+		o.Format = StatsExportCSV
+	case StatsExportCSV, StatsExportJSONL, StatsExportParquet:
+	default:
+		return fmt.Errorf("stats_export_invalid_format")
+	}
+
+	// Dimensions are kept in column order without duplicates
+	selected := make(map[string]bool)
+	for _, dim := range o.Dimensions {
+		dim = strings.TrimSpace(dim)
+		if dim == "" {
+			continue
+		}
+		if !containsString(statsExportDimensions, dim) {
+			return fmt.Errorf("stats_export_invalid_dimension")
+		}
+		selected[dim] = true
+	}
+	o.Dimensions = []string{}
+	for _, dim := range statsExportDimensions {
+		if selected[dim] {
+			o.Dimensions = append(o.Dimensions, dim)
+		}
+	}
+	return nil
+}
+
+// needsUsageStats reports whether the export needs the hourly usage rollups
+func (o *StatsExportOptions) needsUsageStats() bool {
+	if o.Granularity == "hour" {
+		return true
+	}
+	for _, dim := range o.Dimensions {
+		if dim != storage.UsageGroupEndpoint && dim != storage.UsageGroupDevice {
+			return true
+		}
+	}
+	return false
+}
+
+// ContentType returns the MIME type of the export format
+func (o *StatsExportOptions) ContentType() string {
+	switch o.Format {
+	case StatsExportJSONL:
+		return "application/x-ndjson"
+	case StatsExportParquet:
+		return "application/vnd.apache.parquet"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+// Filename returns the default filename of the export
+func (o *StatsExportOptions) Filename() string {
+	return fmt.Sprintf("ccnexus-stats-%s-%s.%s", o.StartDate, o.EndDate, o.Format)
+}
+
+// ExportStats writes the stats selected by the options to w. The options must be normalized.
+func ExportStats(s storage.Storage, opts StatsExportOptions, w io.Writer) (int, error) {
+	rows, err := statsExportRows(s, opts)
+	if err != nil {
+		return 0, err
+	}
+	switch opts.Format {
+	case StatsExportJSONL:
+		err = writeStatsJSONL(w, rows)
+	case StatsExportParquet:
+		err = writeStatsParquet(w, rows)
+	default:
+		err = writeStatsCSV(w, rows, opts.Dimensions)
+	}
+	return len(rows), err
+}
+
+// statsExportRows collects the rows of an export, sorted by period and dimensions
+func statsExportRows(s storage.Storage, opts StatsExportOptions) ([]StatsExportRow, error) {
+	var rows []StatsExportRow
+	if opts.needsUsageStats() {
+		granularity := storage.UsageGranularityDay
+		if opts.Granularity == "hour" {
+			granularity = storage.UsageGranularityHour
+		}
+		buckets, err := s.GetUsageStats(storage.UsageQuery{
+			Granularity: granularity,
+			GroupBy:     opts.Dimensions,
+			StartDate:   opts.StartDate,
+			EndDate:     opts.EndDate,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range buckets {
+			rows = append(rows, StatsExportRow{
+				Period: b.Bucket, Endpoint: b.EndpointName, Device: b.DeviceID,
+				ClientModel: b.ClientModel, UpstreamModel: b.UpstreamModel, ClientFormat: b.ClientFormat,
+				Requests: b.Requests, Errors: b.Errors, InputTokens: b.InputTokens, OutputTokens: b.OutputTokens,
+				CacheHits: b.CacheHits, SavedTokens: b.SavedTokens,
+			})
+		}
+	} else {
+		var err error
+		if rows, err = dailyStatsExportRows(s, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Granularity == "month" {
+		for i := range rows {
+			rows[i].Period = rows[i].Period[:7]
+		}
+	}
+	return mergeStatsExportRows(rows), nil
+}
+
+// dailyStatsExportRows reads the daily stats of the date range, per device when exported by device
+func dailyStatsExportRows(s storage.Storage, opts StatsExportOptions) ([]StatsExportRow, error) {
+	byEndpoint := containsString(opts.Dimensions, storage.UsageGroupEndpoint)
+	devices := []string{""}
+	if containsString(opts.Dimensions, storage.UsageGroupDevice) {
+		deviceStats, err := s.GetDeviceStats()
+		if err != nil {
+			return nil, err
+		}
+		devices = devices[:0]
+		for _, d := range deviceStats {
+			devices = append(devices, d.DeviceID)
+		}
+	}
+
+	var rows []StatsExportRow
+	for _, device := range devices {
+		stats, err := s.GetAllStatsForDevice(device)
+		if err != nil {
+			return nil, err
+		}
+		for endpoint, daily := range stats {
+			if !byEndpoint {
+				endpoint = ""
+			}
+			for _, stat := range daily {
+				if stat.Date < opts.StartDate || stat.Date > opts.EndDate {
+					continue
+				}
+				rows = append(rows, StatsExportRow{
+					Period: stat.Date, Endpoint: endpoint, Device: device,
+					Requests: int64(stat.Requests), Errors: int64(stat.Errors),
+					InputTokens: int64(stat.InputTokens), OutputTokens: int64(stat.OutputTokens),
+					CacheHits: int64(stat.CacheHits), SavedTokens: int64(stat.SavedTokens),
+				})
+			}
+		}
+	}
+	return rows, nil
+}
+
+// mergeStatsExportRows sums rows with the same period and dimensions and sorts them
+func mergeStatsExportRows(rows []StatsExportRow) []StatsExportRow {
+	type key struct{ period, endpoint, device, clientModel, upstreamModel, clientFormat string }
+	index := make(map[key]int)
+	merged := make([]StatsExportRow, 0, len(rows))
+	for _, r := range rows {
+		k := key{r.Period, r.Endpoint, r.Device, r.ClientModel, r.UpstreamModel, r.ClientFormat}
+		i, ok := index[k]
+		if !ok {
+			index[k] = len(merged)
+			merged = append(merged, r)
+			continue
+		}
+		m := &merged[i]
+		m.Requests += r.Requests
+		m.Errors += r.Errors
+		m.InputTokens += r.InputTokens
+		m.OutputTokens += r.OutputTokens
+		m.CacheHits += r.CacheHits
+		m.SavedTokens += r.SavedTokens
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		a, b := merged[i], merged[j]
+		for _, pair := range [][2]string{
+			{a.Period, b.Period}, {a.Endpoint, b.Endpoint}, {a.Device, b.Device},
+			{a.ClientModel, b.ClientModel}, {a.UpstreamModel, b.UpstreamModel}, {a.ClientFormat, b.ClientFormat},
+		} {
+			if pair[0] != pair[1] {
+				return pair[0] < pair[1]
+			}
+		}
+		return false
+	})
+	return merged
+}
+
+// writeStatsCSV writes a header and one line per row, with a column per exported dimension
+func writeStatsCSV(w io.Writer, rows []StatsExportRow, dimensions []string) error {
+	cw := csv.NewWriter(w)
+	header := append([]string{"period"}, dimensions...)
+	header = append(header, "requests", "errors", "inputTokens", "outputTokens", "cacheHits", "savedTokens")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		record := []string{r.Period}
+		for _, dim := range dimensions {
+			record = append(record, r.dimension(dim))
+		}
+		for _, v := range []int64{r.Requests, r.Errors, r.InputTokens, r.OutputTokens, r.CacheHits, r.SavedTokens} {
+			record = append(record, strconv.FormatInt(v, 10))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeStatsJSONL(w io.Writer, rows []StatsExportRow) error {
+	enc := json.NewEncoder(w)
+	for _, r := range rows {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStatsParquet writes the rows with every dimension column; unexported ones are empty
+func writeStatsParquet(w io.Writer, rows []StatsExportRow) error {
+	pw := parquet.NewGenericWriter[StatsExportRow](w)
+	if _, err := pw.Write(rows); err != nil {
+		return err
+	}
+	return pw.Close()
+}
+
+func (r *StatsExportRow) dimension(dim string) string {
+	switch dim {
+	case storage.UsageGroupEndpoint:
+		return r.Endpoint
+	case storage.UsageGroupDevice:
+		return r.Device
+	case storage.UsageGroupClientModel:
+		return r.ClientModel
+	case storage.UsageGroupUpstreamModel:
+		return r.UpstreamModel
+	case storage.UsageGroupClientFormat:
+		return r.ClientFormat
+	}
+	return ""
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/storage"
+)
+
+func newStatsExportTestStorage(t *testing.T) *storage.SQLiteStorage {
+	t.Helper()
+	db := newTestStorage(t)
+	for _, stat := range []storage.DailyStat{
+		{EndpointName: "a", Date: "2026-03-01", Requests: 2, InputTokens: 10, DeviceID: "dev"},
+		{EndpointName: "b", Date: "2026-03-01", Requests: 1, InputTokens: 5, DeviceID: "dev"},
+		{EndpointName: "a", Date: "2026-03-31", Requests: 3, InputTokens: 1, DeviceID: "dev"},
+		{EndpointName: "a", Date: "2026-04-01", Requests: 9, DeviceID: "dev"},
+	} {
+		stat := stat
+		if err := db.RecordDailyStat(&stat); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, stat := range []storage.UsageStat{
+		{EndpointName: "a", ClientModel: "opus", ClientFormat: "claude", Hour: "2026-03-01 09", DeviceID: "dev", Requests: 2, InputTokens: 10},
+		{EndpointName: "b", ClientModel: "opus", ClientFormat: "openai", Hour: "2026-03-01 10", DeviceID: "dev", Requests: 1, InputTokens: 5},
+		{EndpointName: "a", ClientModel: "haiku", ClientFormat: "claude", Hour: "2026-03-31 23", DeviceID: "dev", Requests: 3, InputTokens: 1},
+	} {
+		stat := stat
+		if err := db.RecordUsageStat(&stat); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestExportStatsFormats(t *testing.T) {
+	db := newStatsExportTestStorage(t)
+
+	opts := StatsExportOptions{StartDate: "2026-03-01", EndDate: "2026-03-31", Granularity: "month", Dimensions: []string{"endpoint"}}
+	if err := opts.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := ExportStats(db, opts, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "period,endpoint,requests,errors,inputTokens,outputTokens,cacheHits,savedTokens\n" +
+		"2026-03,a,5,0,11,0,0,0\n" +
+		"2026-03,b,1,0,5,0,0,0\n"
+	if buf.String() != want {
+		t.Fatalf("csv export = %q, want %q", buf.String(), want)
+	}
+
+	opts = StatsExportOptions{StartDate: "2026-03-01", EndDate: "2026-03-31", Dimensions: []string{"clientModel"}, Format: "jsonl"}
+	if err := opts.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if _, err := ExportStats(db, opts, &buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"period":"2026-03-01","clientModel":"opus","requests":3`) {
+		t.Fatalf("jsonl export = %q", buf.String())
+	}
+
+	opts = StatsExportOptions{StartDate: "2026-03-01", EndDate: "2026-03-01", Granularity: "hour", Dimensions: []string{"clientFormat"}, Format: "parquet"}
+	if err := opts.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if _, err := ExportStats(db, opts, &buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := parquet.Read[StatsExportRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("read parquet: %v", err)
+	}
+	if len(rows) != 2 || rows[0].Period != "2026-03-01 09" || rows[0].ClientFormat != "claude" || rows[1].ClientFormat != "openai" {
+		t.Fatalf("parquet export = %+v", rows)
+	}
+
+	for _, bad := range []StatsExportOptions{
+		{StartDate: "2026-03-02", EndDate: "2026-03-01"},
+		{StartDate: "2026-03-01", EndDate: "2026-03-01", Format: "xlsx"},
+		{StartDate: "2026-03-01", EndDate: "2026-03-01", Dimensions: []string{"model"}},
+	} {
+		if err := bad.Normalize(); err == nil {
+			t.Fatalf("invalid options accepted: %+v", bad)
+		}
+	}
+}
+
+func TestStatsReportWritesPreviousMonth(t *testing.T) {
+	db := newStatsExportTestStorage(t)
+	dir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.UpdateBackup(&config.BackupConfig{Provider: "local", Local: &config.LocalBackupConfig{Dir: dir}})
+	reports := NewStatsReportService(NewBackupService(cfg, db, "test"))
+
+	if err := reports.RunNow("2026-03"); err == nil {
+		t.Fatal("report ran without a config")
+	}
+	cfg.UpdateStatsReport(&config.StatsReportConfig{Enabled: true, Provider: "local", Dimensions: []string{"endpoint"}})
+	if err := reports.RunNow("2026-03"); err != nil {
+		t.Fatalf("RunNow: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "ccnexus-stats-report-2026-03.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "2026-04") || strings.Count(string(data), "\n") != 4 {
+		t.Fatalf("report = %q", data)
+	}
+	status := reports.Status()
+	if !status.LastSuccess || status.LastMonth != "2026-03" || status.Rows != 3 {
+		t.Fatalf("status = %+v", status)
+	}
+
+	// The reported month is remembered across restarts
+	again := NewStatsReportService(NewBackupService(cfg, db, "test"))
+	if got := again.Status().LastMonth; got != "2026-03" {
+		t.Fatalf("last month after restart = %q", got)
+	}
+}
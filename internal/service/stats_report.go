@@ -0,0 +1,345 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+)
+
+// statsReportStateKey stores the last reported month; it is device specific and never backed up
+const statsReportStateKey = "stats_report_state"
+
+// statsReportRetry is the wait before a failed report is written again
+const statsReportRetry = time.Hour
+
+// statsReportState is the progress of the monthly report of this device
+type statsReportState struct {
+	LastMonth string     `json:"lastMonth"` // Last month reported successfully, 2006-01
+	LastRun   *time.Time `json:"lastRun,omitempty"`
+}
+
+// StatsReportStatus is the state of the monthly stats report
+type StatsReportStatus struct {
+	Enabled      bool       `json:"enabled"`
+	Provider     string     `json:"provider,omitempty"`
+	Running      bool       `json:"running"`
+	LastRun      *time.Time `json:"lastRun,omitempty"`
+	LastSuccess  bool       `json:"lastSuccess"`
+	LastError    string     `json:"lastError,omitempty"`
+	LastMonth    string     `json:"lastMonth,omitempty"`    // Last month reported successfully
+	LastFilename string     `json:"lastFilename,omitempty"` // File written by the last report
+	Rows         int        `json:"rows"`                   // Rows in the last report
+	NextRun      *time.Time `json:"nextRun,omitempty"`
+}
+
+// StatsReportService writes the stats of the previous month to a backup provider at the start of
+// each month. A month missed while ccNexus was not running is reported when it starts again.
+type StatsReportService struct {
+	backup *BackupService
+
+	mu        sync.Mutex
+	status    StatsReportStatus
+	retryAt   time.Time
+	started   bool
+	stateRead bool
+	wake      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewStatsReportService(backup *BackupService) *StatsReportService {
+	return &StatsReportService{
+		backup: backup,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Start begins reporting in the background
+func (s *StatsReportService) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.loop()
+}
+
+// Stop ends reporting, waiting for a running report to finish
+func (s *StatsReportService) Stop() {
+	s.mu.Lock()
+	if !s.started {
+		s.mu.Unlock()
+		return
+	}
+	s.started = false
+	close(s.stop)
+	done := s.done
+	s.mu.Unlock()
+	<-done
+}
+
+// GetConfig returns the stats report configuration as JSON
+func (s *StatsReportService) GetConfig() string {
+	cfg := s.backup.config.GetStatsReport()
+	if cfg == nil {
+		cfg = &config.StatsReportConfig{Provider: string(BackupProviderLocal), Format: StatsExportCSV, Granularity: "day",
+			Dimensions: []string{"endpoint"}}
+	}
+	data, _ := json.Marshal(cfg)
+	return string(data)
+}
+
+// SetConfig updates the stats report configuration from JSON; an empty string disables the report
+func (s *StatsReportService) SetConfig(configJSON string) error {
+	cfg, err := config.DecodeStatsReport(configJSON)
+	if err != nil {
+		return err
+	}
+	s.backup.config.UpdateStatsReport(cfg)
+	if err := s.backup.saveConfig(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.retryAt = time.Time{}
+	s.mu.Unlock()
+	s.notify()
+	return nil
+}
+
+// GetStatus returns the stats report status as JSON
+func (s *StatsReportService) GetStatus() string {
+	data, _ := json.Marshal(s.Status())
+	return string(data)
+}
+
+// Status returns the stats report status
+func (s *StatsReportService) Status() StatsReportStatus {
+	cfg := s.backup.config.GetStatsReport()
+	s.readState()
+	s.mu.Lock()
+	st := s.status
+	s.mu.Unlock()
+	st.Enabled, st.Provider = false, ""
+	if cfg != nil {
+		st.Enabled, st.Provider = cfg.Enabled, cfg.Provider
+	}
+	return st
+}
+
+// RunNow writes the report of a month (2006-01) immediately, even if the report is disabled.
+// An empty month reports the previous month.
+func (s *StatsReportService) RunNow(month string) error {
+	cfg := s.backup.config.GetStatsReport()
+	if cfg == nil {
+		return fmt.Errorf("stats_report_not_configured")
+	}
+	if month == "" {
+		month = previousMonth(time.Now())
+	} else if _, err := time.Parse("2006-01", month); err != nil {
+		return fmt.Errorf("stats_report_invalid_month")
+	}
+	if err := s.run(*cfg, month); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// notify wakes the loop to recompute the next report
+func (s *StatsReportService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *StatsReportService) loop() {
+	defer close(s.done)
+	for {
+		now := time.Now()
+		wait := statsSyncMaxWait
+		if cfg := s.backup.config.GetStatsReport(); cfg != nil && cfg.Enabled {
+			next := s.nextRun(now)
+			if !next.After(now) {
+				s.run(*cfg, previousMonth(now))
+				continue
+			}
+			if next.Sub(now) < wait {
+				wait = next.Sub(now)
+			}
+		} else {
+			s.mu.Lock()
+			s.status.NextRun = nil
+			s.mu.Unlock()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// nextRun returns when the next report is due: now if the previous month has not been reported,
+// otherwise the start of the next month. Failed reports wait for statsReportRetry.
+func (s *StatsReportService) nextRun(now time.Time) time.Time {
+	s.readState()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := now
+	if s.status.LastMonth >= previousMonth(now) {
+		next = time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+	} else if s.retryAt.After(now) {
+		next = s.retryAt
+	}
+	s.status.NextRun = &next
+	return next
+}
+
+// run exports the stats of a month and writes them to the provider of the config
+func (s *StatsReportService) run(cfg config.StatsReportConfig, month string) error {
+	s.mu.Lock()
+	if s.status.Running {
+		s.mu.Unlock()
+		return fmt.Errorf("stats_report_running")
+	}
+	s.status.Running = true
+	s.mu.Unlock()
+
+	filename, rows, err := s.report(cfg, month)
+	if err != nil {
+		logger.Error("Stats report of %s via %s failed: %v", month, cfg.Provider, err)
+	} else {
+		logger.Info("Stats report of %s written to %s as %s (%d rows)", month, cfg.Provider, filename, rows)
+	}
+
+	finished := time.Now()
+	if err == nil {
+		if saveErr := s.saveState(month, finished); saveErr != nil {
+			logger.Warn("Failed to save stats report state: %v", saveErr)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Running = false
+	s.status.LastRun = &finished
+	s.status.LastSuccess = err == nil
+	s.status.LastError = ""
+	s.status.NextRun = nil
+	if err != nil {
+		s.status.LastError = err.Error()
+		s.retryAt = finished.Add(statsReportRetry)
+		return err
+	}
+	s.status.LastFilename, s.status.Rows = filename, rows
+	if month > s.status.LastMonth {
+		s.status.LastMonth = month
+	}
+	return nil
+}
+
+// report writes the export of a month to a temporary file and puts it on the provider
+func (s *StatsReportService) report(cfg config.StatsReportConfig, month string) (string, int, error) {
+	if s.backup.storage == nil {
+		return "", 0, fmt.Errorf("storage_not_initialized")
+	}
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		return "", 0, fmt.Errorf("stats_report_invalid_month")
+	}
+	opts := StatsExportOptions{
+		StartDate:   start.Format("2006-01-02"),
+		EndDate:     start.AddDate(0, 1, -1).Format("2006-01-02"),
+		Granularity: cfg.Granularity,
+		Dimensions:  cfg.Dimensions,
+		Format:      cfg.Format,
+	}
+	if err := opts.Normalize(); err != nil {
+		return "", 0, err
+	}
+
+	_, provider, err := s.backup.backupProvider(cfg.Provider)
+	if err != nil {
+		return "", 0, err
+	}
+	dir, cleanup, err := tempDirUnique("stats_report")
+	if err != nil {
+		return "", 0, err
+	}
+	defer cleanup()
+
+	filename := fmt.Sprintf("ccnexus-stats-report-%s.%s", month, opts.Format)
+	localPath := filepath.Join(dir, filename)
+	f, err := os.Create(localPath)
+	if err != nil {
+		return "", 0, err
+	}
+	rows, err := ExportStats(s.backup.storage, opts, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	if err := provider.Put(filename, localPath); err != nil {
+		return "", 0, err
+	}
+	return filename, rows, nil
+}
+
+// readState loads the last reported month once
+func (s *StatsReportService) readState() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stateRead || s.backup.storage == nil {
+		return
+	}
+	s.stateRead = true
+	data, err := s.backup.storage.GetConfig(statsReportStateKey)
+	if err != nil || data == "" {
+		return
+	}
+	var state statsReportState
+	if err := json.Unmarshal([]byte(data), &state); err != nil {
+		logger.Warn("Failed to load stats report state: %v", err)
+		return
+	}
+	s.status.LastMonth, s.status.LastRun = state.LastMonth, state.LastRun
+	s.status.LastSuccess = state.LastRun != nil
+}
+
+// saveState records a reported month; reporting an older month again keeps the latest
+func (s *StatsReportService) saveState(month string, finished time.Time) error {
+	s.readState()
+	s.mu.Lock()
+	state := statsReportState{LastMonth: s.status.LastMonth, LastRun: &finished}
+	s.mu.Unlock()
+	if month > state.LastMonth {
+		state.LastMonth = month
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.backup.storage.SetConfig(statsReportStateKey, string(data))
+}
+
+// previousMonth returns the month (2006-01) before the one of t
+func previousMonth(t time.Time) string {
+	return time.Date(t.Year(), t.Month()-1, 1, 0, 0, 0, 0, t.Location()).Format("2006-01")
+}
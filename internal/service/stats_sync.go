@@ -0,0 +1,506 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/logger"
+	"github.com/lich0821/ccNexus/internal/proxy"
+	"github.com/lich0821/ccNexus/internal/storage"
+)
+
+// statsSyncStateKey stores what has been pushed and applied; it is device specific and never backed up
+const statsSyncStateKey = "stats_sync_state"
+
+// statsSyncMaxWait bounds a sleep, so config changes made elsewhere (e.g. a restore) are picked up
+const statsSyncMaxWait = time.Minute
+
+var (
+	statsSyncDeviceName    = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	statsSyncChangesetName = regexp.MustCompile(`^(\d+)\.json$`)
+)
+
+// statsChangeset is one append-only file of a device: the rows it changed since its previous changeset
+type statsChangeset struct {
+	DeviceID  string                `json:"deviceId"`
+	Seq       int                   `json:"seq"`
+	CreatedAt time.Time             `json:"createdAt"`
+	Changes   []storage.StatsChange `json:"changes"`
+}
+
+// statsSyncState is the sync progress of this device for one sync location
+type statsSyncState struct {
+	Location      string         `json:"location"`
+	PushedVersion int64          `json:"pushedVersion"` // Highest local sync_version already pushed
+	Applied       map[string]int `json:"applied"`       // Last applied changeset per device
+	LastSync      *time.Time     `json:"lastSync,omitempty"`
+}
+
+// StatsSyncStatus is the state of stats sync
+type StatsSyncStatus struct {
+	Enabled     bool       `json:"enabled"`
+	Provider    string     `json:"provider,omitempty"`
+	DeviceID    string     `json:"deviceId"`
+	Running     bool       `json:"running"`
+	LastSync    *time.Time `json:"lastSync,omitempty"`
+	LastSuccess bool       `json:"lastSuccess"`
+	LastError   string     `json:"lastError,omitempty"`
+	Pushed      int        `json:"pushed"` // Rows pushed in the last sync
+	Pulled      int        `json:"pulled"` // Changesets of other devices applied in the last sync
+	NextSync    *time.Time `json:"nextSync,omitempty"`
+}
+
+// DeviceStatsInfo is a device with stats, as listed by GetDevices
+type DeviceStatsInfo struct {
+	storage.DeviceStats
+	Local bool `json:"local"`
+}
+
+// StatsSyncService keeps the per-device stats of several ccNexus installations in sync through
+// the backup providers. Every device only writes its own changesets, so rows are never merged
+// or double counted; the stats of all devices are aggregated when read.
+type StatsSyncService struct {
+	backup *BackupService
+
+	mu      sync.Mutex
+	status  StatsSyncStatus
+	started bool
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewStatsSyncService(backup *BackupService) *StatsSyncService {
+	return &StatsSyncService{
+		backup: backup,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Start begins syncing in the background
+func (s *StatsSyncService) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	if state, err := s.loadState(); err == nil {
+		s.status.LastSync = state.LastSync
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.loop()
+}
+
+// Stop ends syncing, waiting for a running sync to finish
+func (s *StatsSyncService) Stop() {
+	s.mu.Lock()
+	if !s.started {
+		s.mu.Unlock()
+		return
+	}
+	s.started = false
+	close(s.stop)
+	done := s.done
+	s.mu.Unlock()
+	<-done
+}
+
+// GetConfig returns the stats sync configuration as JSON
+func (s *StatsSyncService) GetConfig() string {
+	cfg := s.backup.config.GetStatsSync()
+	if cfg == nil {
+		cfg = &config.StatsSyncConfig{Interval: config.DefaultStatsSyncInterval}
+	}
+	data, _ := json.Marshal(cfg)
+	return string(data)
+}
+
+// SetConfig updates the stats sync configuration from JSON; an empty string disables sync
+func (s *StatsSyncService) SetConfig(configJSON string) error {
+	cfg, err := config.DecodeStatsSync(configJSON)
+	if err != nil {
+		return err
+	}
+	s.backup.config.UpdateStatsSync(cfg)
+	if err := s.backup.saveConfig(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.status.NextSync = nil
+	s.mu.Unlock()
+	s.notify()
+	return nil
+}
+
+// GetStatus returns the stats sync status as JSON
+func (s *StatsSyncService) GetStatus() string {
+	data, _ := json.Marshal(s.Status())
+	return string(data)
+}
+
+// Status returns the stats sync status
+func (s *StatsSyncService) Status() StatsSyncStatus {
+	cfg := s.backup.config.GetStatsSync()
+	s.mu.Lock()
+	st := s.status
+	s.mu.Unlock()
+	st.Enabled, st.Provider = false, ""
+	if cfg != nil {
+		st.Enabled, st.Provider = cfg.Enabled, cfg.Provider
+	}
+	if s.backup.storage != nil {
+		st.DeviceID, _ = s.backup.storage.GetOrCreateDeviceID()
+	}
+	return st
+}
+
+// SyncNow pushes and pulls immediately, even if periodic sync is disabled
+func (s *StatsSyncService) SyncNow() error {
+	cfg := s.backup.config.GetStatsSync()
+	if cfg == nil {
+		return fmt.Errorf("stats_sync_not_configured")
+	}
+	if err := s.run(*cfg); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// GetDevices returns every device with stats and its totals as JSON
+func (s *StatsSyncService) GetDevices() string {
+	devices, err := s.Devices()
+	if err != nil {
+		logger.Error("Failed to list stats devices: %v", err)
+		devices = []DeviceStatsInfo{}
+	}
+	data, _ := json.Marshal(devices)
+	return string(data)
+}
+
+// Devices returns every device with stats and its totals
+func (s *StatsSyncService) Devices() ([]DeviceStatsInfo, error) {
+	if s.backup.storage == nil {
+		return nil, fmt.Errorf("storage_not_initialized")
+	}
+	localID, err := s.backup.storage.GetOrCreateDeviceID()
+	if err != nil {
+		return nil, err
+	}
+	stats, err := s.backup.storage.GetDeviceStats()
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]DeviceStatsInfo, 0, len(stats))
+	for _, d := range stats {
+		devices = append(devices, DeviceStatsInfo{DeviceStats: d, Local: d.DeviceID == localID})
+	}
+	return devices, nil
+}
+
+// GetDeviceStats returns the per-endpoint totals of one device between two dates (inclusive) as
+// JSON; an empty device ID aggregates all devices
+func (s *StatsSyncService) GetDeviceStats(deviceID, startDate, endDate string) string {
+	result := map[string]*proxy.DailyStats{}
+	if s.backup.storage != nil {
+		all, err := s.backup.storage.GetAllStatsForDevice(deviceID)
+		if err != nil {
+			logger.Error("Failed to get stats of device %s: %v", deviceID, err)
+		}
+		for endpoint, stats := range all {
+			for _, stat := range stats {
+				if stat.Date < startDate || stat.Date > endDate {
+					continue
+				}
+				ep := result[endpoint]
+				if ep == nil {
+					ep = &proxy.DailyStats{}
+					result[endpoint] = ep
+				}
+				ep.Requests += stat.Requests
+				ep.Errors += stat.Errors
+				ep.InputTokens += stat.InputTokens
+				ep.OutputTokens += stat.OutputTokens
+				ep.CacheHits += stat.CacheHits
+				ep.SavedTokens += stat.SavedTokens
+			}
+		}
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"deviceId":  deviceID,
+		"startDate": startDate,
+		"endDate":   endDate,
+		"endpoints": result,
+	})
+	return string(data)
+}
+
+// notify wakes the loop to recompute the next sync
+func (s *StatsSyncService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *StatsSyncService) loop() {
+	defer close(s.done)
+	for {
+		now := time.Now()
+		wait := statsSyncMaxWait
+		if cfg := s.backup.config.GetStatsSync(); cfg != nil && cfg.Enabled {
+			next := s.nextSync(*cfg, now)
+			if !next.After(now) {
+				s.run(*cfg)
+				continue
+			}
+			if next.Sub(now) < wait {
+				wait = next.Sub(now)
+			}
+		} else {
+			s.mu.Lock()
+			s.status.NextSync = nil
+			s.mu.Unlock()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// nextSync returns when the next periodic sync is due; the first sync runs right away
+func (s *StatsSyncService) nextSync(cfg config.StatsSyncConfig, now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status.NextSync != nil {
+		return *s.status.NextSync
+	}
+	next := now
+	if spec, err := cfg.Schedule(); err == nil && s.status.LastSync != nil {
+		next = spec.Next(*s.status.LastSync)
+	}
+	s.status.NextSync = &next
+	return next
+}
+
+// run pushes the local changes and applies the changesets of other devices
+func (s *StatsSyncService) run(cfg config.StatsSyncConfig) error {
+	s.mu.Lock()
+	if s.status.Running {
+		s.mu.Unlock()
+		return fmt.Errorf("stats_sync_running")
+	}
+	s.status.Running = true
+	s.mu.Unlock()
+
+	pushed, pulled, err := s.sync(cfg.Provider)
+	if err != nil {
+		logger.Error("Stats sync via %s failed: %v", cfg.Provider, err)
+	} else if pushed > 0 || pulled > 0 {
+		logger.Info("Stats sync via %s: pushed %d rows, applied %d changesets", cfg.Provider, pushed, pulled)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	finished := time.Now()
+	s.status.Running = false
+	s.status.LastSync = &finished
+	s.status.LastSuccess = err == nil
+	s.status.LastError = ""
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+	s.status.Pushed, s.status.Pulled = pushed, pulled
+	s.status.NextSync = nil
+	if spec, specErr := cfg.Schedule(); specErr == nil {
+		next := spec.Next(finished)
+		s.status.NextSync = &next
+	}
+	return err
+}
+
+func (s *StatsSyncService) sync(provider string) (int, int, error) {
+	if s.backup.storage == nil {
+		return 0, 0, fmt.Errorf("storage_not_initialized")
+	}
+	store, err := s.backup.statsSyncStore(provider)
+	if err != nil {
+		return 0, 0, err
+	}
+	if closer, ok := store.(io.Closer); ok {
+		defer closer.Close()
+	}
+	deviceID, err := s.backup.storage.GetOrCreateDeviceID()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	state, err := s.loadState()
+	if err != nil {
+		return 0, 0, err
+	}
+	if state.Location != store.Location() {
+		// A new location has none of our changesets yet, so everything is pushed and pulled again
+		state = &statsSyncState{Location: store.Location()}
+	}
+	if state.Applied == nil {
+		state.Applied = make(map[string]int)
+	}
+
+	pushed, err := pushStatsChanges(store, s.backup.storage, deviceID, state)
+	if err != nil {
+		return 0, 0, fmt.Errorf("push failed: %w", err)
+	}
+	pulled, err := pullStatsChanges(store, s.backup.storage, deviceID, state)
+	if saveErr := s.saveState(state); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	if err != nil {
+		return pushed, pulled, fmt.Errorf("pull failed: %w", err)
+	}
+	return pushed, pulled, nil
+}
+
+// pushStatsChanges writes the local rows changed since the last push as the next changeset of this device
+func pushStatsChanges(store statsSyncStore, db storage.Storage, deviceID string, state *statsSyncState) (int, error) {
+	changes, version, err := db.GetStatsChanges(deviceID, state.PushedVersion)
+	if err != nil || len(changes) == 0 {
+		return 0, err
+	}
+
+	seqs, err := listChangesets(store, deviceID)
+	if err != nil {
+		return 0, err
+	}
+	seq := 1
+	if len(seqs) > 0 {
+		seq = seqs[len(seqs)-1] + 1
+	}
+
+	data, err := json.Marshal(statsChangeset{DeviceID: deviceID, Seq: seq, CreatedAt: time.Now().UTC(), Changes: changes})
+	if err != nil {
+		return 0, err
+	}
+	if err := store.Write(path.Join(deviceID, changesetFilename(seq)), data); err != nil {
+		return 0, err
+	}
+	state.PushedVersion = version
+	return len(changes), nil
+}
+
+// pullStatsChanges applies the changesets of other devices that were not applied yet, in order
+func pullStatsChanges(store statsSyncStore, db storage.Storage, deviceID string, state *statsSyncState) (int, error) {
+	devices, err := store.List("")
+	if err != nil {
+		return 0, err
+	}
+
+	pulled := 0
+	var errs []string
+	for _, device := range devices {
+		if device == deviceID || !statsSyncDeviceName.MatchString(device) {
+			continue
+		}
+		seqs, err := listChangesets(store, device)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", device, err))
+			continue
+		}
+		for _, seq := range seqs {
+			if seq <= state.Applied[device] {
+				continue
+			}
+			if err := applyChangeset(store, db, device, seq); err != nil {
+				errs = append(errs, fmt.Sprintf("%s/%s: %v", device, changesetFilename(seq), err))
+				break
+			}
+			state.Applied[device] = seq
+			pulled++
+		}
+	}
+	if len(errs) > 0 {
+		return pulled, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return pulled, nil
+}
+
+func applyChangeset(store statsSyncStore, db storage.Storage, device string, seq int) error {
+	data, err := store.Read(path.Join(device, changesetFilename(seq)))
+	if err != nil {
+		return err
+	}
+	var cs statsChangeset
+	if err := json.Unmarshal(data, &cs); err != nil {
+		return err
+	}
+	if cs.DeviceID != device {
+		return fmt.Errorf("changeset belongs to device %q", cs.DeviceID)
+	}
+	return db.ApplyStatsChanges(device, cs.Changes)
+}
+
+// listChangesets returns the sequence numbers of a device's changesets in ascending order
+func listChangesets(store statsSyncStore, device string) ([]int, error) {
+	names, err := store.List(device)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []int
+	for _, name := range names {
+		if m := statsSyncChangesetName.FindStringSubmatch(name); m != nil {
+			if seq, err := strconv.Atoi(m[1]); err == nil {
+				seqs = append(seqs, seq)
+			}
+		}
+	}
+	sort.Ints(seqs)
+	return seqs, nil
+}
+
+func changesetFilename(seq int) string {
+	return fmt.Sprintf("%010d.json", seq)
+}
+
+func (s *StatsSyncService) loadState() (*statsSyncState, error) {
+	if s.backup.storage == nil {
+		return nil, fmt.Errorf("storage_not_initialized")
+	}
+	state := &statsSyncState{}
+	data, err := s.backup.storage.GetConfig(statsSyncStateKey)
+	if err != nil || data == "" {
+		return state, err
+	}
+	if err := json.Unmarshal([]byte(data), state); err != nil {
+		logger.Warn("Failed to load stats sync state: %v", err)
+		return &statsSyncState{}, nil
+	}
+	return state, nil
+}
+
+func (s *StatsSyncService) saveState(state *statsSyncState) error {
+	now := time.Now()
+	state.LastSync = &now
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.backup.storage.SetConfig(statsSyncStateKey, string(data))
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/webdav"
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/sftp"
+)
+
+// statsSyncDir is the directory next to the backups that holds the stats changesets
+const statsSyncDir = "stats-sync"
+
+// statsSyncStore reads and writes changeset files below the sync root; names are slash-separated
+// and relative to the root
+type statsSyncStore interface {
+	// Location identifies the sync root, so sync state is reset when the target changes
+	Location() string
+	// List returns the names of the entries of a directory, or nothing if it does not exist
+	List(dir string) ([]string, error)
+	Read(name string) ([]byte, error)
+	Write(name string, data []byte) error
+}
+
+// statsSyncProvider is implemented by backup providers that can hold stats changesets
+type statsSyncProvider interface {
+	statsSyncStore() (statsSyncStore, error)
+}
+
+// statsSyncStore returns the changeset store of a backup provider. Stores that hold a
+// connection implement io.Closer and must be closed after use.
+func (b *BackupService) statsSyncStore(provider string) (statsSyncStore, error) {
+	p, err := newBackupProvider(b.config, provider)
+	if err != nil {
+		return nil, err
+	}
+	sp, ok := p.(statsSyncProvider)
+	if !ok {
+		return nil, fmt.Errorf("backup_provider_invalid")
+	}
+	return sp.statsSyncStore()
+}
+
+func (p *localBackupProvider) statsSyncStore() (statsSyncStore, error) {
+	return &localStatsSyncStore{root: filepath.Join(p.dir, statsSyncDir)}, nil
+}
+
+func (p *s3BackupProvider) statsSyncStore() (statsSyncStore, error) {
+	root := strings.Trim(strings.TrimSpace(p.cfg.Prefix), "/")
+	return &s3StatsSyncStore{client: p.client, bucket: p.cfg.Bucket, root: path.Join(root, statsSyncDir)}, nil
+}
+
+func (p *webdavBackupProvider) statsSyncStore() (statsSyncStore, error) {
+	root := path.Join(path.Dir(path.Clean("/"+p.cfg.StatsPath)), statsSyncDir)
+	return &webdavStatsSyncStore{client: p.client, url: p.cfg.URL, root: root}, nil
+}
+
+func (p *sftpBackupProvider) statsSyncStore() (statsSyncStore, error) {
+	client, closeFn, err := p.connect()
+	if err != nil {
+		return nil, err
+	}
+	return &sftpStatsSyncStore{client: client, close: closeFn, location: p.Location(), root: path.Join(p.cfg.Dir, statsSyncDir)}, nil
+}
+
+type localStatsSyncStore struct {
+	root string
+}
+
+func (s *localStatsSyncStore) Location() string { return "local:" + s.root }
+
+func (s *localStatsSyncStore) List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.root, filepath.FromSlash(dir)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *localStatsSyncStore) Read(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.root, filepath.FromSlash(name)))
+}
+
+// Write writes through a temporary file, so other devices syncing the directory never see a partial changeset
+func (s *localStatsSyncStore) Write(name string, data []byte) error {
+	target := filepath.Join(s.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+type s3StatsSyncStore struct {
+	client *minio.Client
+	bucket string
+	root   string
+}
+
+func (s *s3StatsSyncStore) Location() string { return "s3:" + s.bucket + "/" + s.root }
+
+func (s *s3StatsSyncStore) key(name string) string { return path.Join(s.root, name) }
+
+func (s *s3StatsSyncStore) List(dir string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	prefix := s.key(dir) + "/"
+	var names []string
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if name := strings.TrimSuffix(strings.TrimPrefix(obj.Key, prefix), "/"); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (s *s3StatsSyncStore) Read(name string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	obj, err := s.client.GetObject(ctx, s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return io.ReadAll(obj)
+}
+
+func (s *s3StatsSyncStore) Write(name string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	_, err := s.client.PutObject(ctx, s.bucket, s.key(name), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{ContentType: "application/json"})
+	return err
+}
+
+type webdavStatsSyncStore struct {
+	client *webdav.Client
+	url    string
+	root   string
+}
+
+func (s *webdavStatsSyncStore) Location() string { return "webdav:" + s.url + s.root }
+
+func (s *webdavStatsSyncStore) List(dir string) ([]string, error) {
+	return s.client.ReadDirNames(path.Join(s.root, dir))
+}
+
+func (s *webdavStatsSyncStore) Read(name string) ([]byte, error) {
+	return s.client.ReadFile(path.Join(s.root, name))
+}
+
+func (s *webdavStatsSyncStore) Write(name string, data []byte) error {
+	return s.client.WriteFile(path.Join(s.root, name), data)
+}
+
+// sftpStatsSyncStore keeps one connection for a whole sync run
+type sftpStatsSyncStore struct {
+	client   *sftp.Client
+	close    func()
+	location string
+	root     string
+}
+
+func (s *sftpStatsSyncStore) Location() string { return s.location + "/" + statsSyncDir }
+
+func (s *sftpStatsSyncStore) List(dir string) ([]string, error) {
+	entries, err := s.client.ReadDir(path.Join(s.root, dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *sftpStatsSyncStore) Read(name string) ([]byte, error) {
+	f, err := s.client.Open(path.Join(s.root, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (s *sftpStatsSyncStore) Write(name string, data []byte) error {
+	return sftpWriteFile(s.client, path.Join(s.root, name), bytes.NewReader(data))
+}
+
+func (s *sftpStatsSyncStore) Close() error {
+	s.close()
+	return nil
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/lich0821/ccNexus/internal/storage"
+)
+
+func newStatsSyncTestDevice(t *testing.T) (*storage.SQLiteStorage, string) {
+	t.Helper()
+	db := newTestStorage(t)
+	deviceID, err := db.GetOrCreateDeviceID()
+	if err != nil {
+		t.Fatalf("GetOrCreateDeviceID: %v", err)
+	}
+	return db, deviceID
+}
+
+func TestStatsSyncBetweenDevices(t *testing.T) {
+	store := &localStatsSyncStore{root: t.TempDir()}
+	dbA, idA := newStatsSyncTestDevice(t)
+	dbB, idB := newStatsSyncTestDevice(t)
+	if idA == idB {
+		idB = idA + "-b"
+		if err := dbB.SetConfig("device_id", idB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stateA := &statsSyncState{Applied: map[string]int{}}
+	stateB := &statsSyncState{Applied: map[string]int{}}
+
+	record := func(db *storage.SQLiteStorage, deviceID string, requests int) {
+		t.Helper()
+		stat := &storage.DailyStat{EndpointName: "ep", Date: "2026-03-14", Requests: requests, DeviceID: deviceID}
+		if err := db.RecordDailyStat(stat); err != nil {
+			t.Fatal(err)
+		}
+	}
+	syncDevice := func(db *storage.SQLiteStorage, deviceID string, state *statsSyncState) {
+		t.Helper()
+		if _, err := pushStatsChanges(store, db, deviceID, state); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+		if _, err := pullStatsChanges(store, db, deviceID, state); err != nil {
+			t.Fatalf("pull: %v", err)
+		}
+	}
+	totalRequests := func(db *storage.SQLiteStorage, deviceID string) int {
+		t.Helper()
+		all, err := db.GetAllStatsForDevice(deviceID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total := 0
+		for _, stat := range all["ep"] {
+			total += stat.Requests
+		}
+		return total
+	}
+
+	record(dbA, idA, 3)
+	record(dbB, idB, 5)
+	syncDevice(dbA, idA, stateA)
+	syncDevice(dbB, idB, stateB)
+	syncDevice(dbA, idA, stateA)
+
+	record(dbA, idA, 2)
+	syncDevice(dbA, idA, stateA)
+	syncDevice(dbB, idB, stateB)
+	// Syncing again without changes neither pushes nor double counts
+	syncDevice(dbB, idB, stateB)
+
+	for _, db := range []*storage.SQLiteStorage{dbA, dbB} {
+		if got := totalRequests(db, ""); got != 10 {
+			t.Errorf("total requests = %d, want 10", got)
+		}
+		if got := totalRequests(db, idA); got != 5 {
+			t.Errorf("requests of A = %d, want 5", got)
+		}
+		if got := totalRequests(db, idB); got != 5 {
+			t.Errorf("requests of B = %d, want 5", got)
+		}
+	}
+	if seqs, _ := listChangesets(store, idA); len(seqs) != 2 {
+		t.Errorf("device A wrote changesets %v, want 2", seqs)
+	}
+	if err := dbA.ApplyStatsChanges(idA, nil); err == nil {
+		t.Error("ApplyStatsChanges accepted changes for the local device")
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lich0821/ccNexus/internal/config"
+	"github.com/lich0821/ccNexus/internal/proxy"
+	"github.com/lich0821/ccNexus/internal/storage"
+)
+
+// newTestStorage opens a SQLite storage in a temporary directory, closed when the test ends
+func newTestStorage(t *testing.T) *storage.SQLiteStorage {
+	t.Helper()
+	db, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "main.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newStatsTestService(t *testing.T) (*StatsService, *proxy.Proxy, *storage.SQLiteStorage) {
+	t.Helper()
+	db := newTestStorage(t)
+	cfg := config.DefaultConfig()
+	cfg.UpdateEndpoints([]config.Endpoint{
+		{Name: "a", APIUrl: "http://a", Enabled: true},
+		{Name: "b", APIUrl: "http://b"},
+	})
+	p := proxy.New(cfg, storage.NewStatsStorageAdapter(db), "dev")
+	return NewStatsService(p, cfg, db), p, db
+}
+
+func TestPeriodStatsSummary(t *testing.T) {
+	svc, p, _ := newStatsTestService(t)
+	stats := p.GetStats()
+	labels := proxy.UsageLabels{ClientModel: "claude-sonnet-4", ClientFormat: proxy.ClientFormatClaude}
+	for i := 0; i < 3; i++ {
+		stats.RecordRequest("a", labels)
+	}
+	stats.RecordError("a", labels)
+	stats.RecordTokens("a", labels, 100, 50)
+	stats.RecordRequest("b", labels)
+	stats.RecordTokens("b", labels, 7, 3)
+	stats.RecordCacheHit("a", labels, 40)
+	stats.RecordCacheHit("a", labels, 20)
+
+	var summary struct {
+		TotalRequests     int `json:"totalRequests"`
+		TotalErrors       int `json:"totalErrors"`
+		TotalSuccess      int `json:"totalSuccess"`
+		TotalInputTokens  int `json:"totalInputTokens"`
+		TotalOutputTokens int `json:"totalOutputTokens"`
+		TotalCacheHits    int `json:"totalCacheHits"`
+		TotalSavedTokens  int `json:"totalSavedTokens"`
+		ActiveEndpoints   int `json:"activeEndpoints"`
+		TotalEndpoints    int `json:"totalEndpoints"`
+		Endpoints         map[string]*proxy.DailyStats
+	}
+	if err := json.Unmarshal([]byte(svc.GetStatsDaily()), &summary); err != nil {
+		t.Fatalf("GetStatsDaily is not JSON: %v", err)
+	}
+	if summary.TotalRequests != 4 || summary.TotalErrors != 1 || summary.TotalSuccess != 3 {
+		t.Errorf("requests=%d errors=%d success=%d, want 4, 1, 3", summary.TotalRequests, summary.TotalErrors, summary.TotalSuccess)
+	}
+	if summary.TotalInputTokens != 107 || summary.TotalOutputTokens != 53 {
+		t.Errorf("tokens = %d/%d, want 107/53", summary.TotalInputTokens, summary.TotalOutputTokens)
+	}
+	if summary.TotalCacheHits != 2 || summary.TotalSavedTokens != 60 {
+		t.Errorf("cacheHits=%d savedTokens=%d, want 2, 60", summary.TotalCacheHits, summary.TotalSavedTokens)
+	}
+	if summary.ActiveEndpoints != 1 || summary.TotalEndpoints != 2 {
+		t.Errorf("endpoints = %d/%d, want 1/2", summary.ActiveEndpoints, summary.TotalEndpoints)
+	}
+	if a := summary.Endpoints["a"]; a == nil || a.Requests != 3 || a.CacheHits != 2 {
+		t.Errorf("endpoint a = %+v, want 3 requests and 2 cache hits", a)
+	}
+}
+
+func TestStatsTrend(t *testing.T) {
+	svc, p, db := newStatsTestService(t)
+	labels := proxy.UsageLabels{ClientFormat: proxy.ClientFormatClaude}
+	for i := 0; i < 3; i++ {
+		p.GetStats().RecordRequest("a", labels)
+	}
+	p.GetStats().RecordTokens("a", labels, 90, 0)
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	if err := db.RecordDailyStat(&storage.DailyStat{EndpointName: "a", Date: yesterday, Requests: 2, Errors: 1, InputTokens: 100, DeviceID: "dev"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var trend map[string]float64
+	if err := json.Unmarshal([]byte(svc.GetStatsTrend()), &trend); err != nil {
+		t.Fatalf("GetStatsTrend is not JSON: %v", err)
+	}
+	want := map[string]float64{
+		"current": 3, "previous": 2, "trend": 50,
+		"currentErrors": 0, "previousErrors": 1, "errorsTrend": -100,
+		"currentTokens": 90, "previousTokens": 100, "tokensTrend": -10,
+	}
+	for key, value := range want {
+		if trend[key] != value {
+			t.Errorf("%s = %v, want %v", key, trend[key], value)
+		}
+	}
+}
+
+func TestCalculateTrend(t *testing.T) {
+	tests := []struct {
+		current, previous int
+		want              float64
+	}{
+		{0, 0, 0},
+		{5, 0, 100},
+		{15, 10, 50},
+		{5, 10, -50},
+		{50, 10, 100},
+	}
+	for _, tt := range tests {
+		if got := calculateTrend(tt.current, tt.previous); got != tt.want {
+			t.Errorf("calculateTrend(%d, %d) = %v, want %v", tt.current, tt.previous, got, tt.want)
+		}
+	}
+}
+
+func TestNewUsageQuery(t *testing.T) {
+	query, err := NewUsageQuery("", " clientModel, ,device", "2026-03-01", "2026-03-02", "dev")
+	if err != nil || query.Granularity != storage.UsageGranularityDay || len(query.GroupBy) != 2 ||
+		query.GroupBy[0] != storage.UsageGroupClientModel || query.GroupBy[1] != storage.UsageGroupDevice || query.DeviceID != "dev" {
+		t.Errorf("query = %+v, %v", query, err)
+	}
+	if query, err := NewUsageQuery("hour", "", "2026-03-01", "2026-03-01", ""); err != nil || query.GroupBy == nil {
+		t.Errorf("query = %+v, %v; want an empty groupBy list", query, err)
+	}
+	for _, params := range [][2]string{{"week", ""}, {"day", "model"}} {
+		if _, err := NewUsageQuery(params[0], params[1], "2026-03-01", "2026-03-01", ""); err == nil {
+			t.Errorf("NewUsageQuery(%q, %q) accepted", params[0], params[1])
+		}
+	}
+}
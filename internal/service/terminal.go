@@ -14,11 +14,11 @@ import (
 // TerminalService handles terminal and session operations
 type TerminalService struct {
     config  *config.Config
-    storage *storage.SQLiteStorage
+    storage storage.Storage
 }
 
 // NewTerminalService creates a new TerminalService
-func NewTerminalService(cfg *config.Config, s *storage.SQLiteStorage) *TerminalService {
+func NewTerminalService(cfg *config.Config, s storage.Storage) *TerminalService {
     return &TerminalService{config: cfg, storage: s}
 }
 
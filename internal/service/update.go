@@ -14,13 +14,13 @@ import (
 // UpdateService handles auto-update operations
 type UpdateService struct {
     config  *config.Config
-    storage *storage.SQLiteStorage
+    storage storage.Storage
     updater *updater.Updater
     version string
 }
 
 // NewUpdateService creates a new UpdateService
-func NewUpdateService(cfg *config.Config, s *storage.SQLiteStorage, version string) *UpdateService {
+func NewUpdateService(cfg *config.Config, s storage.Storage, version string) *UpdateService {
     return &UpdateService{
         config:  cfg,
         storage: s,
@@ -2,9 +2,8 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
 
 	"github.com/lich0821/ccNexus/internal/config"
 	"github.com/lich0821/ccNexus/internal/logger"
@@ -15,12 +14,12 @@ import (
 // WebDAVService handles WebDAV backup/restore operations
 type WebDAVService struct {
 	config  *config.Config
-	storage *storage.SQLiteStorage
+	storage storage.Storage
 	version string
 }
 
 // NewWebDAVService creates a new WebDAVService
-func NewWebDAVService(cfg *config.Config, s *storage.SQLiteStorage, version string) *WebDAVService {
+func NewWebDAVService(cfg *config.Config, s storage.Storage, version string) *WebDAVService {
 	return &WebDAVService{config: cfg, storage: s, version: version}
 }
 
@@ -70,282 +69,88 @@ func (w *WebDAVService) TestWebDAVConnection(url, username, password string) str
 	return string(data)
 }
 
+// backups returns a BackupService sharing the configuration and storage; the WebDAV methods
+// below are kept for existing callers and go through the generic provider flows
+func (w *WebDAVService) backups() *BackupService {
+	return NewBackupService(w.config, w.storage, w.version)
+}
+
 // BackupToWebDAV backs up configuration and stats to WebDAV
 func (w *WebDAVService) BackupToWebDAV(filename string) error {
-	logger.Info("Starting backup process for file: %s", filename)
-
-	webdavCfg := w.config.GetWebDAV()
-	if webdavCfg == nil {
-		logger.Error("WebDAV configuration is not set")
-		return fmt.Errorf("webdav_not_configured")
-	}
-
-	if w.storage == nil {
-		logger.Error("Storage is not initialized")
-		return fmt.Errorf("storage_not_initialized")
-	}
-
-	client, err := webdav.NewClient(webdavCfg)
-	if err != nil {
-		logger.Error("Failed to create WebDAV client: %v", err)
-		return fmt.Errorf("webdav_client_failed")
-	}
-
-	manager := webdav.NewManager(client)
-
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		logger.Error("Failed to get home directory: %v", err)
-		return fmt.Errorf("get_home_dir_failed")
-	}
-	tempDir := filepath.Join(homeDir, ".ccNexus", "temp")
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		logger.Error("Failed to create temp directory: %v", err)
-		return fmt.Errorf("create_temp_dir_failed")
-	}
-	tempBackupPath := filepath.Join(tempDir, "backup_temp.db")
-
-	if _, err := os.Stat(tempBackupPath); err == nil {
-		os.Remove(tempBackupPath)
-	}
-
-	defer func() {
-		os.Remove(tempBackupPath)
-		os.RemoveAll(tempDir)
-	}()
-
-	logger.Info("Creating database backup copy (excluding app_config)...")
-	if err := w.storage.CreateBackupCopy(tempBackupPath); err != nil {
-		logger.Error("Failed to create database backup: %v", err)
-		return fmt.Errorf("create_db_backup_failed")
-	}
-
-	logger.Info("Uploading backup to WebDAV (version: %s)...", w.version)
-	if err := manager.BackupDatabase(tempBackupPath, w.version, filename); err != nil {
-		logger.Error("Failed to upload backup to WebDAV: %v", err)
-		return fmt.Errorf("backup_upload_failed")
-	}
-
-	logger.Info("Backup created successfully: %s", filename)
-	return nil
+	return w.backups().BackupToProvider(string(BackupProviderWebDAV), filename)
 }
 
 // RestoreFromWebDAV restores configuration and stats from WebDAV
 func (w *WebDAVService) RestoreFromWebDAV(filename, choice string, reloadConfig func(*config.Config) error) error {
-	webdavCfg := w.config.GetWebDAV()
-	if webdavCfg == nil {
-		return fmt.Errorf("webdav_not_configured")
-	}
-
-	if w.storage == nil {
-		return fmt.Errorf("storage_not_initialized")
-	}
-
-	if choice == "keep_local" {
-		logger.Info("User chose to merge configuration (keep local on conflicts)")
-	}
-
-	client, err := webdav.NewClient(webdavCfg)
-	if err != nil {
-		return fmt.Errorf("webdav_client_failed")
-	}
-
-	manager := webdav.NewManager(client)
-
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("get_home_dir_failed")
-	}
-	tempDir := filepath.Join(homeDir, ".ccNexus", "temp")
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		return fmt.Errorf("create_temp_dir_failed")
-	}
-	tempRestorePath := filepath.Join(tempDir, "restore_temp.db")
-	defer os.Remove(tempRestorePath)
-	defer os.RemoveAll(tempDir)
-
-	if err := manager.RestoreDatabase(filename, tempRestorePath); err != nil {
-		return fmt.Errorf("restore_download_failed")
-	}
-
-	var strategy storage.MergeStrategy
-	if choice == "remote" {
-		strategy = storage.MergeStrategyOverwriteLocal
-	} else {
-		strategy = storage.MergeStrategyKeepLocal
-	}
-
-	if err := w.storage.MergeFromBackup(tempRestorePath, strategy); err != nil {
-		logger.Error("合并备份数据失败: %v", err)
-		return fmt.Errorf("merge_data_failed")
-	}
-
-	configAdapter := storage.NewConfigStorageAdapter(w.storage)
-	newConfig, err := config.LoadFromStorage(configAdapter)
-	if err != nil {
-		return fmt.Errorf("load_config_failed")
-	}
-
-	*w.config = *newConfig
-
-	if err := reloadConfig(newConfig); err != nil {
-		return fmt.Errorf("update_proxy_config_failed")
-	}
-
-	logger.Info("Configuration and statistics restored from: %s", filename)
-	return nil
+	return w.backups().RestoreFromProvider(string(BackupProviderWebDAV), filename, choice, reloadConfig)
 }
 
 // ListWebDAVBackups lists all backups on WebDAV server
 func (w *WebDAVService) ListWebDAVBackups() string {
-	logger.Info("Listing WebDAV backups...")
-
-	webdavCfg := w.config.GetWebDAV()
-	if webdavCfg == nil {
-		result := map[string]interface{}{
-			"success": false,
-			"message": "WebDAV未配置",
-			"backups": []interface{}{},
-		}
-		data, _ := json.Marshal(result)
-		return string(data)
-	}
-
-	client, err := webdav.NewClient(webdavCfg)
-	if err != nil {
-		result := map[string]interface{}{
-			"success": false,
-			"message": fmt.Sprintf("创建WebDAV客户端失败: %v", err),
-			"backups": []interface{}{},
-		}
-		data, _ := json.Marshal(result)
-		return string(data)
-	}
-
-	manager := webdav.NewManager(client)
-
-	backups, err := manager.ListConfigBackups()
-	if err != nil {
-		result := map[string]interface{}{
-			"success": false,
-			"message": fmt.Sprintf("获取备份列表失败: %v", err),
-			"backups": []interface{}{},
-		}
-		data, _ := json.Marshal(result)
-		return string(data)
-	}
-
-	logger.Info("Found %d backup(s)", len(backups))
-
-	result := map[string]interface{}{
-		"success": true,
-		"message": "获取备份列表成功",
-		"backups": backups,
-	}
-	data, _ := json.Marshal(result)
-	return string(data)
+	return w.backups().ListBackups(string(BackupProviderWebDAV))
 }
 
 // DeleteWebDAVBackups deletes backups from WebDAV server
 func (w *WebDAVService) DeleteWebDAVBackups(filenames []string) error {
-	webdavCfg := w.config.GetWebDAV()
-	if webdavCfg == nil {
-		return fmt.Errorf("webdav_not_configured")
-	}
-
-	client, err := webdav.NewClient(webdavCfg)
-	if err != nil {
-		return fmt.Errorf("webdav_client_failed")
-	}
-
-	manager := webdav.NewManager(client)
-
-	if err := manager.DeleteConfigBackups(filenames); err != nil {
-		return fmt.Errorf("delete_backup_failed")
-	}
-
-	logger.Info("Backups deleted: %v", filenames)
-	return nil
+	return w.backups().DeleteBackups(string(BackupProviderWebDAV), filenames)
 }
 
 // DetectWebDAVConflict detects conflicts between local and remote config
 func (w *WebDAVService) DetectWebDAVConflict(filename string) string {
-	webdavCfg := w.config.GetWebDAV()
-	if webdavCfg == nil {
-		result := map[string]interface{}{
-			"success": false,
-			"message": "WebDAV未配置",
-		}
-		data, _ := json.Marshal(result)
-		return string(data)
-	}
+	return w.backups().DetectBackupConflict(string(BackupProviderWebDAV), filename)
+}
 
-	if w.storage == nil {
-		result := map[string]interface{}{
-			"success": false,
-			"message": "存储未初始化",
+func init() {
+	RegisterBackupProvider(string(BackupProviderWebDAV), func(cfg *config.Config) (BackupProvider, error) {
+		webdavCfg := cfg.GetWebDAV()
+		if webdavCfg == nil {
+			return nil, fmt.Errorf("webdav_not_configured")
 		}
-		data, _ := json.Marshal(result)
-		return string(data)
-	}
-
-	client, err := webdav.NewClient(webdavCfg)
-	if err != nil {
-		result := map[string]interface{}{
-			"success": false,
-			"message": fmt.Sprintf("创建WebDAV客户端失败: %v", err),
+		client, err := webdav.NewClient(webdavCfg)
+		if err != nil {
+			logger.Error("Failed to create WebDAV client: %v", err)
+			return nil, fmt.Errorf("webdav_client_failed")
 		}
-		data, _ := json.Marshal(result)
-		return string(data)
-	}
+		return &webdavBackupProvider{cfg: webdavCfg, client: client, manager: webdav.NewManager(client)}, nil
+	})
+}
 
-	manager := webdav.NewManager(client)
+// webdavBackupProvider keeps backups in the config path of a WebDAV server
+type webdavBackupProvider struct {
+	cfg     *config.WebDAVConfig
+	client  *webdav.Client
+	manager *webdav.Manager
+}
 
-	homeDir, err := os.UserHomeDir()
+func (p *webdavBackupProvider) Location() string { return "webdav:" + p.cfg.URL + p.cfg.ConfigPath }
+
+func (p *webdavBackupProvider) List() ([]BackupListItem, error) {
+	backups, err := p.manager.ListConfigBackups()
 	if err != nil {
-		result := map[string]interface{}{
-			"success": false,
-			"message": fmt.Sprintf("获取用户目录失败: %v", err),
-		}
-		data, _ := json.Marshal(result)
-		return string(data)
+		return nil, err
 	}
-	tempDir := filepath.Join(homeDir, ".ccNexus", "temp")
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		result := map[string]interface{}{
-			"success": false,
-			"message": fmt.Sprintf("创建临时目录失败: %v", err),
-		}
-		data, _ := json.Marshal(result)
-		return string(data)
+	files := make([]BackupListItem, 0, len(backups))
+	for _, backup := range backups {
+		files = append(files, BackupListItem{Filename: backup.Filename, Size: backup.Size, ModTime: backup.ModTime})
 	}
-	tempRestorePath := filepath.Join(tempDir, "conflict_check_temp.db")
-	defer os.Remove(tempRestorePath)
-	defer os.RemoveAll(tempDir)
+	return files, nil
+}
 
-	if err := manager.RestoreDatabase(filename, tempRestorePath); err != nil {
-		result := map[string]interface{}{
-			"success": false,
-			"message": fmt.Sprintf("下载备份失败: %v", err),
-		}
-		data, _ := json.Marshal(result)
-		return string(data)
-	}
+func (p *webdavBackupProvider) Put(filename, localPath string) error {
+	return p.manager.BackupDatabase(localPath, filename)
+}
 
-	conflicts, err := w.storage.DetectEndpointConflicts(tempRestorePath)
-	if err != nil {
-		result := map[string]interface{}{
-			"success": false,
-			"message": fmt.Sprintf("检测冲突失败: %v", err),
-		}
-		data, _ := json.Marshal(result)
-		return string(data)
-	}
+func (p *webdavBackupProvider) Get(filename, localPath string) error {
+	return p.manager.RestoreDatabase(filename, localPath)
+}
 
-	result := map[string]interface{}{
-		"success":   true,
-		"conflicts": conflicts,
+func (p *webdavBackupProvider) Delete(filenames []string) error {
+	return p.manager.DeleteConfigBackups(filenames)
+}
+
+func (p *webdavBackupProvider) Test() error {
+	if result := p.client.TestConnection(); !result.Success {
+		return errors.New(result.Message)
 	}
-	data, _ := json.Marshal(result)
-	return string(data)
+	return nil
 }
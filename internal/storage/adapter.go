@@ -2,13 +2,13 @@ package storage
 
 import "github.com/lich0821/ccNexus/internal/config"
 
-// ConfigStorageAdapter adapts SQLiteStorage to config.StorageAdapter interface
+// ConfigStorageAdapter adapts a Storage to config.StorageAdapter interface
 type ConfigStorageAdapter struct {
-	storage *SQLiteStorage
+	storage Storage
 }
 
 // NewConfigStorageAdapter creates a new adapter
-func NewConfigStorageAdapter(storage *SQLiteStorage) *ConfigStorageAdapter {
+func NewConfigStorageAdapter(storage Storage) *ConfigStorageAdapter {
 	return &ConfigStorageAdapter{storage: storage}
 }
 
@@ -22,14 +22,20 @@ func (a *ConfigStorageAdapter) GetEndpoints() ([]config.StorageEndpoint, error)
 	result := make([]config.StorageEndpoint, len(endpoints))
 	for i, ep := range endpoints {
 		result[i] = config.StorageEndpoint{
-			Name:        ep.Name,
-			APIUrl:      ep.APIUrl,
-			APIKey:      ep.APIKey,
-			Enabled:     ep.Enabled,
-			Transformer: ep.Transformer,
-			Model:       ep.Model,
-			Remark:      ep.Remark,
-			SortOrder:   ep.SortOrder,
+			UID:             ep.UID,
+			Name:            ep.Name,
+			APIUrl:          ep.APIUrl,
+			APIKey:          ep.APIKey,
+			Enabled:         ep.Enabled,
+			Transformer:     ep.Transformer,
+			Model:           ep.Model,
+			Remark:          ep.Remark,
+			SortOrder:       ep.SortOrder,
+			AzureResource:   ep.AzureResource,
+			AzureDeployment: ep.AzureDeployment,
+			AzureAPIVersion: ep.AzureAPIVersion,
+			RewriteRules:    ep.RewriteRules,
+			Capabilities:    ep.Capabilities,
 		}
 	}
 	return result, nil
@@ -38,14 +44,20 @@ func (a *ConfigStorageAdapter) GetEndpoints() ([]config.StorageEndpoint, error)
 // SaveEndpoint saves an endpoint
 func (a *ConfigStorageAdapter) SaveEndpoint(ep *config.StorageEndpoint) error {
 	endpoint := &Endpoint{
-		Name:        ep.Name,
-		APIUrl:      ep.APIUrl,
-		APIKey:      ep.APIKey,
-		Enabled:     ep.Enabled,
-		Transformer: ep.Transformer,
-		Model:       ep.Model,
-		Remark:      ep.Remark,
-		SortOrder:   ep.SortOrder,
+		UID:             ep.UID,
+		Name:            ep.Name,
+		APIUrl:          ep.APIUrl,
+		APIKey:          ep.APIKey,
+		Enabled:         ep.Enabled,
+		Transformer:     ep.Transformer,
+		Model:           ep.Model,
+		Remark:          ep.Remark,
+		SortOrder:       ep.SortOrder,
+		AzureResource:   ep.AzureResource,
+		AzureDeployment: ep.AzureDeployment,
+		AzureAPIVersion: ep.AzureAPIVersion,
+		RewriteRules:    ep.RewriteRules,
+		Capabilities:    ep.Capabilities,
 	}
 	return a.storage.SaveEndpoint(endpoint)
 }
@@ -53,14 +65,20 @@ func (a *ConfigStorageAdapter) SaveEndpoint(ep *config.StorageEndpoint) error {
 // UpdateEndpoint updates an endpoint
 func (a *ConfigStorageAdapter) UpdateEndpoint(ep *config.StorageEndpoint) error {
 	endpoint := &Endpoint{
-		Name:        ep.Name,
-		APIUrl:      ep.APIUrl,
-		APIKey:      ep.APIKey,
-		Enabled:     ep.Enabled,
-		Transformer: ep.Transformer,
-		Model:       ep.Model,
-		Remark:      ep.Remark,
-		SortOrder:   ep.SortOrder,
+		UID:             ep.UID,
+		Name:            ep.Name,
+		APIUrl:          ep.APIUrl,
+		APIKey:          ep.APIKey,
+		Enabled:         ep.Enabled,
+		Transformer:     ep.Transformer,
+		Model:           ep.Model,
+		Remark:          ep.Remark,
+		SortOrder:       ep.SortOrder,
+		AzureResource:   ep.AzureResource,
+		AzureDeployment: ep.AzureDeployment,
+		AzureAPIVersion: ep.AzureAPIVersion,
+		RewriteRules:    ep.RewriteRules,
+		Capabilities:    ep.Capabilities,
 	}
 	return a.storage.UpdateEndpoint(endpoint)
 }
@@ -0,0 +1,535 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// BackupSchemaVersion is the database schema written to backup archives; restores refuse
+// archives of a newer schema
+const BackupSchemaVersion = SchemaVersion
+
+// backupArchiveFormat is the layout of the archive itself
+const backupArchiveFormat = 1
+
+// Kinds of backup archives
+const (
+	BackupKindFull  = "full"  // Complete database snapshot
+	BackupKindDelta = "delta" // Stats rows changed since a full archive, plus the current endpoints, settings and rollups
+)
+
+// Entries of a backup archive; the manifest always comes first
+const (
+	backupManifestEntry = "manifest.json"
+	backupSnapshotEntry = "ccnexus.db"
+	backupDeltaEntry    = "delta.json"
+)
+
+// Errors returned when a backup archive cannot be restored
+var (
+	ErrBackupArchiveInvalid = errors.New("invalid backup archive")
+	ErrBackupChecksum       = errors.New("backup checksum mismatch")
+	ErrBackupSchema         = errors.New("unsupported backup schema version")
+)
+
+// BackupManifest describes the payload of a backup archive
+type BackupManifest struct {
+	Format        int         `json:"format"`
+	Kind          string      `json:"kind"`
+	SchemaVersion int         `json:"schemaVersion"`
+	AppVersion    string      `json:"appVersion"`
+	DeviceID      string      `json:"deviceId"`
+	CreatedAt     time.Time   `json:"createdAt"`
+	Compression   string      `json:"compression"`
+	Payload       string      `json:"payload"` // Name of the payload entry
+	Size          int64       `json:"size"`    // Uncompressed payload size
+	SHA256        string      `json:"sha256"`  // Of the uncompressed payload
+	StatsVersion  int64       `json:"statsVersion"`
+	Base          *BackupBase `json:"base,omitempty"` // Full archive a delta builds on
+}
+
+// BackupBase identifies the full archive of a delta
+type BackupBase struct {
+	Filename     string `json:"filename"`
+	SHA256       string `json:"sha256"`
+	StatsVersion int64  `json:"statsVersion"` // The delta holds the stats rows changed after this version
+}
+
+// backupDelta is the payload of a delta archive. Endpoints, settings and monthly rollups are
+// small, so they are stored whole; stats rows only when they changed after the base, except those
+// of pruned months, which are all stored since the base may still hold dailies pruned since.
+// Deltas written before rollups were backed up have no MonthlyStats.
+type backupDelta struct {
+	Endpoints    []Endpoint         `json:"endpoints"`
+	AppConfig    map[string]string  `json:"appConfig"`
+	DailyStats   []backupStatsRow   `json:"dailyStats"`
+	MonthlyStats []backupMonthlyRow `json:"monthlyStats"`
+}
+
+type backupStatsRow struct {
+	StatsChange
+	DeviceID    string `json:"deviceId"`
+	syncVersion int64  // Kept in snapshots exported from another storage, not part of deltas
+}
+
+// backupSource is a storage that backup archives are made from
+type backupSource interface {
+	GetOrCreateDeviceID() (string, error)
+	// CreateBackupCopy writes a SQLite snapshot without device specific data
+	CreateBackupCopy(backupPath string) error
+	backupDeltaSince(sinceVersion int64) (*backupDelta, int64, error)
+}
+
+// CreateBackupArchive writes a gzip-compressed backup archive. Without a base the archive holds
+// a snapshot of the database taken with VACUUM INTO; with a base it holds a delta against it.
+func (s *SQLiteStorage) CreateBackupArchive(archivePath, appVersion string, base *BackupBase) (*BackupManifest, error) {
+	return createBackupArchive(s, archivePath, appVersion, base)
+}
+
+func createBackupArchive(src backupSource, archivePath, appVersion string, base *BackupBase) (*BackupManifest, error) {
+	deviceID, err := src.GetOrCreateDeviceID()
+	if err != nil {
+		return nil, err
+	}
+	manifest := &BackupManifest{
+		Format:        backupArchiveFormat,
+		SchemaVersion: BackupSchemaVersion,
+		AppVersion:    appVersion,
+		DeviceID:      deviceID,
+		CreatedAt:     time.Now(),
+		Compression:   "gzip",
+	}
+
+	if base == nil {
+		manifest.Kind, manifest.Payload = BackupKindFull, backupSnapshotEntry
+		snapshot := archivePath + ".snapshot"
+		_ = os.Remove(snapshot)
+		defer os.Remove(snapshot)
+		if err := src.CreateBackupCopy(snapshot); err != nil {
+			return nil, err
+		}
+		if manifest.StatsVersion, err = snapshotStatsVersion(snapshot); err != nil {
+			return nil, err
+		}
+		f, err := os.Open(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := writeBackupArchive(archivePath, manifest, f); err != nil {
+			return nil, err
+		}
+		return manifest, nil
+	}
+
+	delta, version, err := src.backupDeltaSince(base.StatsVersion)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Kind, manifest.Payload = BackupKindDelta, backupDeltaEntry
+	manifest.StatsVersion = version
+	manifest.Base = base
+	if err := writeBackupArchive(archivePath, manifest, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// snapshotStatsVersion returns the highest stats sync version of a snapshot
+func snapshotStatsVersion(path string) (int64, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	var version int64
+	err = db.QueryRow(`SELECT COALESCE(MAX(sync_version), 0) FROM daily_stats`).Scan(&version)
+	return version, err
+}
+
+// backupDeltaSince collects the delta payload and the highest stats sync version it covers
+func (s *SQLiteStorage) backupDeltaSince(sinceVersion int64) (*backupDelta, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	endpoints, err := getEndpointsFromDB(s.db, "main")
+	if err != nil {
+		return nil, 0, err
+	}
+	appConfig, err := safeAppConfig(s.db, "main")
+	if err != nil {
+		return nil, 0, err
+	}
+	monthly, err := queryMonthlyRows(s.db, "monthly_stats")
+	if err != nil {
+		return nil, 0, err
+	}
+	delta := &backupDelta{Endpoints: endpoints, AppConfig: appConfig, MonthlyStats: monthly}
+
+	rows, err := s.db.Query(`SELECT endpoint_name, date, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens, device_id, sync_version
+		FROM daily_stats WHERE sync_version > ? OR substr(date, 1, 7) IN (SELECT month FROM monthly_stats WHERE pruned = 1)
+		ORDER BY sync_version`, sinceVersion)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	version := sinceVersion
+	for rows.Next() {
+		var r backupStatsRow
+		var v int64
+		if err := rows.Scan(&r.EndpointName, &r.Date, &r.Requests, &r.Errors, &r.InputTokens, &r.OutputTokens, &r.CacheHits, &r.SavedTokens, &r.DeviceID, &v); err != nil {
+			return nil, 0, err
+		}
+		delta.DailyStats = append(delta.DailyStats, r)
+		if v > version {
+			version = v
+		}
+	}
+	return delta, version, rows.Err()
+}
+
+// safeAppConfig returns the app_config entries of a database (main or attached) that are shared
+// between devices (see safeConfigKeys)
+func safeAppConfig(q rowsQuerier, dbName string) (map[string]string, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(safeConfigKeys)), ",")
+	args := make([]interface{}, len(safeConfigKeys))
+	for i, key := range safeConfigKeys {
+		args[i] = key
+	}
+	rows, err := q.Query(fmt.Sprintf(`SELECT key, COALESCE(value, '') FROM %s.app_config WHERE key IN (%s)`, dbName, placeholders), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appConfig := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		appConfig[key] = value
+	}
+	return appConfig, rows.Err()
+}
+
+// writeBackupArchive hashes the payload into the manifest and writes the manifest and the
+// payload as a gzip-compressed tar file
+func writeBackupArchive(archivePath string, manifest *BackupManifest, payload io.ReadSeeker) error {
+	h := sha256.New()
+	size, err := io.Copy(h, payload)
+	if err != nil {
+		return err
+	}
+	if _, err := payload.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	manifest.Size, manifest.SHA256 = size, hex.EncodeToString(h.Sum(nil))
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	entries := []struct {
+		name string
+		size int64
+		r    io.Reader
+	}{
+		{backupManifestEntry, int64(len(manifestJSON)), bytes.NewReader(manifestJSON)},
+		{manifest.Payload, size, payload},
+	}
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: e.size, ModTime: manifest.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, e.r); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// openBackupArchive opens an archive and reads its manifest, leaving the reader at the payload
+func openBackupArchive(archivePath string) (*BackupManifest, *tar.Reader, func(), error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, fmt.Errorf("%w: %v", ErrBackupArchiveInvalid, err)
+	}
+	closeAll := func() {
+		gz.Close()
+		f.Close()
+	}
+
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != backupManifestEntry {
+		closeAll()
+		return nil, nil, nil, fmt.Errorf("%w: missing manifest", ErrBackupArchiveInvalid)
+	}
+	var manifest BackupManifest
+	if err := json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(&manifest); err != nil {
+		closeAll()
+		return nil, nil, nil, fmt.Errorf("%w: %v", ErrBackupArchiveInvalid, err)
+	}
+	return &manifest, tr, closeAll, nil
+}
+
+// ReadBackupManifest returns the manifest of a backup archive
+func ReadBackupManifest(archivePath string) (*BackupManifest, error) {
+	manifest, _, closeArchive, err := openBackupArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	closeArchive()
+	return manifest, nil
+}
+
+// ExtractBackupArchive writes the payload of a backup archive to destPath after checking that
+// this version can restore it and that the payload matches the manifest checksum
+func ExtractBackupArchive(archivePath, destPath string) (*BackupManifest, error) {
+	manifest, tr, closeArchive, err := openBackupArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArchive()
+
+	if manifest.Format < 1 || manifest.Format > backupArchiveFormat {
+		return nil, fmt.Errorf("%w: archive format %d", ErrBackupArchiveInvalid, manifest.Format)
+	}
+	if manifest.SchemaVersion < 1 || manifest.SchemaVersion > BackupSchemaVersion {
+		return nil, fmt.Errorf("%w: %d (supported up to %d)", ErrBackupSchema, manifest.SchemaVersion, BackupSchemaVersion)
+	}
+	if manifest.Kind != BackupKindFull && manifest.Kind != BackupKindDelta || manifest.Kind == BackupKindDelta && manifest.Base == nil {
+		return nil, fmt.Errorf("%w: kind %q", ErrBackupArchiveInvalid, manifest.Kind)
+	}
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != manifest.Payload {
+		return nil, fmt.Errorf("%w: missing payload", ErrBackupArchiveInvalid)
+	}
+	out, err := os.Create(destPath)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, h), tr)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(destPath)
+		return nil, fmt.Errorf("%w: %v", ErrBackupArchiveInvalid, err)
+	}
+	if size != manifest.Size || hex.EncodeToString(h.Sum(nil)) != manifest.SHA256 {
+		os.Remove(destPath)
+		return nil, ErrBackupChecksum
+	}
+	return manifest, nil
+}
+
+// ApplyBackupDelta applies an extracted delta payload to the extracted snapshot of its base, so
+// that the snapshot can be merged like a full backup
+func ApplyBackupDelta(snapshotPath, deltaPath string) error {
+	data, err := os.ReadFile(deltaPath)
+	if err != nil {
+		return err
+	}
+	var delta backupDelta
+	if err := json.Unmarshal(data, &delta); err != nil {
+		return fmt.Errorf("%w: %v", ErrBackupArchiveInvalid, err)
+	}
+
+	db, err := sql.Open("sqlite", snapshotPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceEndpoints(tx, delta.Endpoints); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM app_config`); err != nil {
+		return err
+	}
+	for key, value := range delta.AppConfig {
+		if _, err := tx.Exec(`INSERT INTO app_config (key, value) VALUES (?, ?)`, key, value); err != nil {
+			return err
+		}
+	}
+	if delta.MonthlyStats != nil {
+		// The base may predate monthly rollups
+		if _, err := tx.Exec(monthlyStatsSchema); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM monthly_stats`); err != nil {
+			return err
+		}
+		for _, r := range delta.MonthlyStats {
+			if err := insertMonthlyRow(tx, sqliteRebind, r); err != nil {
+				return err
+			}
+			// The delta holds every daily of a pruned month
+			if r.Pruned {
+				if _, err := tx.Exec(`DELETE FROM daily_stats WHERE substr(date, 1, 7) = ?`, r.Month); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, r := range delta.DailyStats {
+		_, err := tx.Exec(`
+			INSERT INTO daily_stats (endpoint_name, date, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens, device_id, sync_version)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0)
+			ON CONFLICT(endpoint_name, date, device_id) DO UPDATE SET
+				requests = excluded.requests,
+				errors = excluded.errors,
+				input_tokens = excluded.input_tokens,
+				output_tokens = excluded.output_tokens,
+				cache_hits = excluded.cache_hits,
+				saved_tokens = excluded.saved_tokens
+		`, r.EndpointName, r.Date, r.Requests, r.Errors, r.InputTokens, r.OutputTokens, r.CacheHits, r.SavedTokens, r.DeviceID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// readBackupSnapshot reads the endpoints, shared settings, stats rows and rollups of a SQLite backup
+// without changing it, for storages that cannot attach the file. Stats rows are summed per
+// endpoint, date and device like SQLiteStorage.MergeFromBackup does.
+func readBackupSnapshot(path string) (*backupDelta, error) {
+	// sql.Open would create a missing file
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if err := checkSchemaVersion(db, "main"); err != nil {
+		return nil, err
+	}
+	endpoints, err := getEndpointsFromDB(db, "main")
+	if err != nil {
+		return nil, err
+	}
+	appConfig, err := safeAppConfig(db, "main")
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &backupDelta{Endpoints: endpoints, AppConfig: appConfig}
+
+	// Older backups may lack the cache statistics columns
+	cacheColumns, err := dailyStatsCacheSelect(db, "main")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(fmt.Sprintf(`SELECT endpoint_name, date, SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens), %s, COALESCE(device_id, 'default')
+		FROM daily_stats GROUP BY endpoint_name, date, COALESCE(device_id, 'default')`, cacheColumns))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r backupStatsRow
+		if err := rows.Scan(&r.EndpointName, &r.Date, &r.Requests, &r.Errors, &r.InputTokens, &r.OutputTokens, &r.CacheHits, &r.SavedTokens, &r.DeviceID); err != nil {
+			return nil, err
+		}
+		snapshot.DailyStats = append(snapshot.DailyStats, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Older backups have no monthly rollups
+	exists, err := hasTable(db, "main", "monthly_stats")
+	if err != nil || !exists {
+		return snapshot, err
+	}
+	snapshot.MonthlyStats, err = queryMonthlyRows(db, "monthly_stats")
+	return snapshot, err
+}
+
+// writeBackupSnapshot creates a SQLite backup holding the given endpoints, shared settings, stats
+// rows and rollups, for storages whose data does not live in a SQLite file
+func writeBackupSnapshot(path string, snapshot *backupDelta) error {
+	s, err := NewSQLiteStorage(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceEndpoints(tx, snapshot.Endpoints); err != nil {
+		return err
+	}
+	for key, value := range snapshot.AppConfig {
+		if _, err := tx.Exec(`INSERT INTO app_config (key, value) VALUES (?, ?)`, key, value); err != nil {
+			return err
+		}
+	}
+	for _, r := range snapshot.DailyStats {
+		_, err := tx.Exec(`
+			INSERT INTO daily_stats (endpoint_name, date, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens, device_id, sync_version)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, r.EndpointName, r.Date, r.Requests, r.Errors, r.InputTokens, r.OutputTokens, r.CacheHits, r.SavedTokens, r.DeviceID, r.syncVersion)
+		if err != nil {
+			return err
+		}
+	}
+	for _, r := range snapshot.MonthlyStats {
+		if err := insertMonthlyRow(tx, sqliteRebind, r); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
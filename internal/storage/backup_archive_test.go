@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupArchiveFullAndDelta(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSQLiteStorage(filepath.Join(dir, "main.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.SaveEndpoint(&Endpoint{Name: "a", APIUrl: "https://a", APIKey: "k", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordDailyStat(&DailyStat{EndpointName: "a", Date: "2026-03-01", Requests: 1, DeviceID: "dev"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fullPath := filepath.Join(dir, "full.ccnx")
+	full, err := s.CreateBackupArchive(fullPath, "v1", nil)
+	if err != nil {
+		t.Fatalf("full archive: %v", err)
+	}
+	if full.Kind != BackupKindFull || full.SchemaVersion != BackupSchemaVersion || full.DeviceID == "" || full.StatsVersion == 0 {
+		t.Fatalf("unexpected manifest: %+v", full)
+	}
+
+	if err := s.SaveEndpoint(&Endpoint{Name: "b", APIUrl: "https://b", APIKey: "k", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordDailyStat(&DailyStat{EndpointName: "a", Date: "2026-03-02", Requests: 2, DeviceID: "dev"}); err != nil {
+		t.Fatal(err)
+	}
+	deltaPath := filepath.Join(dir, "delta.ccnx")
+	base := &BackupBase{Filename: "full.ccnx", SHA256: full.SHA256, StatsVersion: full.StatsVersion}
+	delta, err := s.CreateBackupArchive(deltaPath, "v1", base)
+	if err != nil {
+		t.Fatalf("delta archive: %v", err)
+	}
+	if delta.Kind != BackupKindDelta || delta.Base == nil || delta.StatsVersion <= full.StatsVersion {
+		t.Fatalf("unexpected delta manifest: %+v", delta)
+	}
+
+	snapshot := filepath.Join(dir, "snapshot.db")
+	if _, err := ExtractBackupArchive(fullPath, snapshot); err != nil {
+		t.Fatalf("extract full: %v", err)
+	}
+	payload := filepath.Join(dir, "delta.json")
+	if _, err := ExtractBackupArchive(deltaPath, payload); err != nil {
+		t.Fatalf("extract delta: %v", err)
+	}
+	if err := ApplyBackupDelta(snapshot, payload); err != nil {
+		t.Fatalf("apply delta: %v", err)
+	}
+
+	restored, err := NewSQLiteStorage(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	endpoints, err := restored.GetEndpoints()
+	if err != nil || len(endpoints) != 2 {
+		t.Fatalf("restored endpoints = %+v, %v", endpoints, err)
+	}
+	stats, err := restored.GetAllStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(stats["a"]); got != 2 {
+		t.Fatalf("restored %d stats rows, want 2", got)
+	}
+}
+
+func TestBackupArchiveRejectsTamperedAndNewerArchives(t *testing.T) {
+	dir := t.TempDir()
+	payload := []byte("snapshot")
+
+	write := func(name string, manifest BackupManifest, data []byte) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		gz := gzip.NewWriter(f)
+		tw := tar.NewWriter(gz)
+		m, _ := json.Marshal(manifest)
+		for _, e := range []struct {
+			name string
+			data []byte
+		}{{backupManifestEntry, m}, {backupSnapshotEntry, data}} {
+			tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data))})
+			tw.Write(e.data)
+		}
+		tw.Close()
+		gz.Close()
+		return path
+	}
+
+	good := BackupManifest{Format: backupArchiveFormat, Kind: BackupKindFull, SchemaVersion: BackupSchemaVersion, Payload: backupSnapshotEntry}
+	good.Size = int64(len(payload))
+	sum := sha256.Sum256(payload)
+	good.SHA256 = hex.EncodeToString(sum[:])
+
+	if _, err := ExtractBackupArchive(write("good.ccnx", good, payload), filepath.Join(dir, "good")); err != nil {
+		t.Fatalf("intact archive: %v", err)
+	}
+
+	// Same size, one byte changed after the manifest was written
+	if _, err := ExtractBackupArchive(write("tampered.ccnx", good, []byte("snapshoT")), filepath.Join(dir, "out")); !errors.Is(err, ErrBackupChecksum) {
+		t.Errorf("tampered archive: err = %v, want checksum mismatch", err)
+	}
+
+	newer := good
+	newer.SchemaVersion = BackupSchemaVersion + 1
+	if _, err := ExtractBackupArchive(write("newer.ccnx", newer, payload), filepath.Join(dir, "out")); !errors.Is(err, ErrBackupSchema) {
+		t.Errorf("newer schema: err = %v, want schema error", err)
+	}
+
+	if _, err := ExtractBackupArchive(filepath.Join(dir, "missing.ccnx"), filepath.Join(dir, "out")); err == nil {
+		t.Error("missing archive extracted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("rejected payload was left behind")
+	}
+}
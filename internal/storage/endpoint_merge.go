@@ -0,0 +1,407 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// endpointSyncBaseKey stores the endpoints as of the last backup or restore. It is the common
+// ancestor of three-way merges and device specific, so it is never part of a backup.
+const endpointSyncBaseKey = "endpoint_sync_base"
+
+// MergeStrategyThreeWay merges endpoints field by field against the last synced snapshot
+const MergeStrategyThreeWay MergeStrategy = "merge"
+
+// Sides of a merge, used in resolutions and MergeConflict.Deleted
+const (
+	MergeSideLocal  = "local"
+	MergeSideRemote = "remote"
+)
+
+// MergeFieldDeleted is the conflict field of an endpoint deleted on one side and changed on the other
+const MergeFieldDeleted = "deleted"
+
+// MergeResolution chooses a side for the conflicts of a three-way merge
+type MergeResolution struct {
+	Default   string                        `json:"default,omitempty"`   // local (default) or remote, for conflicts without a choice
+	Endpoints map[string]EndpointResolution `json:"endpoints,omitempty"` // By endpoint UID or conflict endpoint name
+}
+
+// EndpointResolution chooses a side for the conflicting fields of one endpoint
+type EndpointResolution struct {
+	Default string            `json:"default,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"` // By conflict field, e.g. apiKey or deleted
+}
+
+// side returns the chosen side of a conflicting field
+func (r *MergeResolution) side(conflict *MergeConflict, field string) string {
+	if r != nil {
+		er, ok := r.Endpoints[conflict.UID]
+		if !ok || conflict.UID == "" {
+			er, ok = r.Endpoints[conflict.EndpointName]
+		}
+		if ok {
+			if side := er.Fields[field]; side != "" {
+				return side
+			}
+			if er.Default != "" {
+				return er.Default
+			}
+		}
+		if r.Default != "" {
+			return r.Default
+		}
+	}
+	return MergeSideLocal
+}
+
+// endpointField is an endpoint field taking part in three-way merges
+type endpointField struct {
+	name string
+	get  func(Endpoint) string
+	set  func(dst *Endpoint, src Endpoint)
+}
+
+// endpointFields lists the merged fields; sortOrder is merged too but never reported as a conflict
+var endpointFields = []endpointField{
+	{"name", func(e Endpoint) string { return e.Name }, func(d *Endpoint, s Endpoint) { d.Name = s.Name }},
+	{"apiUrl", func(e Endpoint) string { return e.APIUrl }, func(d *Endpoint, s Endpoint) { d.APIUrl = s.APIUrl }},
+	{"apiKey", func(e Endpoint) string { return e.APIKey }, func(d *Endpoint, s Endpoint) { d.APIKey = s.APIKey }},
+	{"enabled", func(e Endpoint) string { return strconv.FormatBool(e.Enabled) }, func(d *Endpoint, s Endpoint) { d.Enabled = s.Enabled }},
+	{"transformer", func(e Endpoint) string { return e.Transformer }, func(d *Endpoint, s Endpoint) { d.Transformer = s.Transformer }},
+	{"model", func(e Endpoint) string { return e.Model }, func(d *Endpoint, s Endpoint) { d.Model = s.Model }},
+	{"remark", func(e Endpoint) string { return e.Remark }, func(d *Endpoint, s Endpoint) { d.Remark = s.Remark }},
+	{"azureResource", func(e Endpoint) string { return e.AzureResource }, func(d *Endpoint, s Endpoint) { d.AzureResource = s.AzureResource }},
+	{"azureDeployment", func(e Endpoint) string { return e.AzureDeployment }, func(d *Endpoint, s Endpoint) { d.AzureDeployment = s.AzureDeployment }},
+	{"azureApiVersion", func(e Endpoint) string { return e.AzureAPIVersion }, func(d *Endpoint, s Endpoint) { d.AzureAPIVersion = s.AzureAPIVersion }},
+	{"rewriteRules", func(e Endpoint) string { return e.RewriteRules }, func(d *Endpoint, s Endpoint) { d.RewriteRules = s.RewriteRules }},
+	{"capabilities", func(e Endpoint) string { return e.Capabilities }, func(d *Endpoint, s Endpoint) { d.Capabilities = s.Capabilities }},
+	{"sortOrder", func(e Endpoint) string { return strconv.Itoa(e.SortOrder) }, func(d *Endpoint, s Endpoint) { d.SortOrder = s.SortOrder }},
+}
+
+// endpointChanged reports whether any merged field differs, ignoring the sort order
+func endpointChanged(a, b Endpoint) bool {
+	for _, f := range endpointFields {
+		if f.name != "sortOrder" && f.get(a) != f.get(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// endpointVersions is one endpoint as known to the base, the local and the remote side
+type endpointVersions struct {
+	base, local, remote *Endpoint
+}
+
+// matchEndpoints groups the endpoints of the three sides. Endpoints are matched by UID first. Local
+// and remote endpoints with the same name are the same endpoint, created on both devices or by an
+// older version without UIDs; base endpoints are matched by name only when a UID is missing.
+func matchEndpoints(base, local, remote []Endpoint) []*endpointVersions {
+	groups := make([]*endpointVersions, 0, len(local)+len(remote))
+	for i := range local {
+		groups = append(groups, &endpointVersions{local: &local[i]})
+	}
+
+	// assign adds endpoints to free slots of the groups, by UID in a first pass so that a name
+	// never takes the group of an endpoint matched by UID
+	assign := func(eps []Endpoint, slot func(*endpointVersions) **Endpoint, others func(*endpointVersions) []*Endpoint, byNameWithUID bool) []*Endpoint {
+		matched := make([]bool, len(eps))
+		for pass := 0; pass < 2; pass++ {
+			for i := range eps {
+				ep := &eps[i]
+				if matched[i] || (pass == 0 && ep.UID == "") {
+					continue
+				}
+			groups:
+				for _, g := range groups {
+					if *slot(g) != nil {
+						continue
+					}
+					for _, other := range others(g) {
+						if other == nil {
+							continue
+						}
+						if pass == 0 && other.UID == ep.UID ||
+							pass == 1 && other.Name == ep.Name && (byNameWithUID || other.UID == "" || ep.UID == "") {
+							*slot(g) = ep
+							matched[i] = true
+							break groups
+						}
+					}
+				}
+			}
+		}
+		var unmatched []*Endpoint
+		for i := range eps {
+			if !matched[i] {
+				unmatched = append(unmatched, &eps[i])
+			}
+		}
+		return unmatched
+	}
+
+	for _, ep := range assign(remote, func(g *endpointVersions) **Endpoint { return &g.remote },
+		func(g *endpointVersions) []*Endpoint { return []*Endpoint{g.local} }, true) {
+		groups = append(groups, &endpointVersions{remote: ep})
+	}
+	// Base endpoints left unmatched were deleted on both sides
+	assign(base, func(g *endpointVersions) **Endpoint { return &g.base },
+		func(g *endpointVersions) []*Endpoint { return []*Endpoint{g.local, g.remote} }, false)
+	return groups
+}
+
+// mergeEndpointVersions merges the endpoints of the three sides. Changes made on only one side
+// are applied; fields changed differently on both sides are returned as conflicts and decided by
+// the resolution. With no base every difference is a conflict.
+func mergeEndpointVersions(base, local, remote []Endpoint, hasBase bool, resolution *MergeResolution) ([]Endpoint, []MergeConflict, error) {
+	if !hasBase {
+		base = nil
+	}
+
+	var merged []Endpoint
+	var conflicts []MergeConflict
+	for _, g := range matchEndpoints(base, local, remote) {
+		conflict := MergeConflict{}
+		if g.local != nil {
+			conflict.EndpointName, conflict.UID, conflict.LocalEndpoint = g.local.Name, g.local.UID, *g.local
+		}
+		if g.remote != nil {
+			if conflict.EndpointName == "" {
+				conflict.EndpointName, conflict.UID = g.remote.Name, g.remote.UID
+			}
+			conflict.RemoteEndpoint = *g.remote
+		}
+		if g.base != nil {
+			b := *g.base
+			conflict.BaseEndpoint = &b
+		}
+
+		switch {
+		case g.local != nil && g.remote == nil:
+			if g.base == nil {
+				merged = append(merged, *g.local) // Added locally
+				continue
+			}
+			if !endpointChanged(*g.base, *g.local) {
+				continue // Deleted remotely
+			}
+			conflict.ConflictFields, conflict.Deleted = []string{MergeFieldDeleted}, MergeSideRemote
+			conflicts = append(conflicts, conflict)
+			if resolution.side(&conflict, MergeFieldDeleted) == MergeSideLocal {
+				merged = append(merged, *g.local)
+			}
+		case g.local == nil && g.remote != nil:
+			if g.base == nil {
+				merged = append(merged, remoteOnly(*g.remote)) // Added remotely
+				continue
+			}
+			if !endpointChanged(*g.base, *g.remote) {
+				continue // Deleted locally
+			}
+			conflict.ConflictFields, conflict.Deleted = []string{MergeFieldDeleted}, MergeSideLocal
+			conflicts = append(conflicts, conflict)
+			if resolution.side(&conflict, MergeFieldDeleted) == MergeSideRemote {
+				merged = append(merged, remoteOnly(*g.remote))
+			}
+		case g.local != nil && g.remote != nil:
+			result := *g.local
+			if g.remote.UID != "" {
+				result.UID = g.remote.UID // Converge on one identity for endpoints matched by name
+			}
+			for _, f := range endpointFields {
+				l, r := f.get(*g.local), f.get(*g.remote)
+				if l == r {
+					continue
+				}
+				if g.base != nil {
+					b := f.get(*g.base)
+					if l == b {
+						f.set(&result, *g.remote)
+						continue
+					}
+					if r == b {
+						continue
+					}
+				}
+				if f.name == "sortOrder" {
+					continue // Keep the local order
+				}
+				conflict.ConflictFields = append(conflict.ConflictFields, f.name)
+				if resolution.side(&conflict, f.name) == MergeSideRemote {
+					f.set(&result, *g.remote)
+				}
+			}
+			if len(conflict.ConflictFields) > 0 {
+				conflicts = append(conflicts, conflict)
+			}
+			merged = append(merged, result)
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].SortOrder < merged[j].SortOrder })
+	names := make(map[string]bool, len(merged))
+	for i := range merged {
+		if names[merged[i].Name] {
+			return nil, conflicts, fmt.Errorf("endpoint name %q is used by two endpoints after merging", merged[i].Name)
+		}
+		names[merged[i].Name] = true
+		merged[i].SortOrder = i
+	}
+	return merged, conflicts, nil
+}
+
+// remoteOnly prepares an endpoint that exists only in the backup for insertion; its row ID
+// belongs to the other database
+func remoteOnly(ep Endpoint) Endpoint {
+	ep.ID = 0
+	return ep
+}
+
+// getEndpointSyncBase returns the endpoints of the last backup or restore
+func getEndpointSyncBase(q rowQuerier) ([]Endpoint, bool, error) {
+	var data string
+	err := q.QueryRow(`SELECT value FROM app_config WHERE key = ?`, endpointSyncBaseKey).Scan(&data)
+	if err == sql.ErrNoRows || data == "" {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	base, ok := decodeEndpointSyncBase(data)
+	return base, ok, nil
+}
+
+// decodeEndpointSyncBase parses a stored snapshot; an unreadable one counts as no snapshot
+func decodeEndpointSyncBase(data string) ([]Endpoint, bool) {
+	var base []Endpoint
+	if data == "" || json.Unmarshal([]byte(data), &base) != nil {
+		return nil, false
+	}
+	return base, true
+}
+
+func encodeEndpointSyncBase(endpoints []Endpoint) (string, error) {
+	if endpoints == nil {
+		endpoints = []Endpoint{}
+	}
+	data, err := json.Marshal(endpoints)
+	return string(data), err
+}
+
+func saveEndpointSyncBase(e execer, endpoints []Endpoint) error {
+	data, err := encodeEndpointSyncBase(endpoints)
+	if err != nil {
+		return err
+	}
+	_, err = e.Exec(`INSERT INTO app_config (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value=excluded.value, updated_at=CURRENT_TIMESTAMP`, endpointSyncBaseKey, data)
+	return err
+}
+
+// SnapshotEndpointSyncBase records the current endpoints as the base of the next three-way
+// merge; call it after a backup has been uploaded
+func (s *SQLiteStorage) SnapshotEndpointSyncBase() error {
+	endpoints, err := s.GetEndpoints()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return saveEndpointSyncBase(s.db, endpoints)
+}
+
+// ThreeWayMergeFromBackup restores a backup by merging its endpoints field by field against the
+// last synced snapshot, applying the resolution to conflicts. Stats rows of the backup are added
+// and shared settings are taken from the side the resolution defaults to.
+func (s *SQLiteStorage) ThreeWayMergeFromBackup(backupDBPath string, resolution *MergeResolution) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	detach, err := s.attachDatabase(backupDBPath, "backup")
+	if err != nil {
+		return fmt.Errorf("failed to attach backup database: %w", err)
+	}
+	defer detach()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	local, err := getEndpointsFromDB(tx, "main")
+	if err != nil {
+		return err
+	}
+	remote, err := getEndpointsFromDB(tx, "backup")
+	if err != nil {
+		return err
+	}
+	base, hasBase, err := getEndpointSyncBase(tx)
+	if err != nil {
+		return err
+	}
+	merged, _, err := mergeEndpointVersions(base, local, remote, hasBase, resolution)
+	if err != nil {
+		return err
+	}
+	if err := replaceEndpoints(tx, merged); err != nil {
+		return fmt.Errorf("failed to merge endpoints: %w", err)
+	}
+	if err := assignEndpointUIDs(tx); err != nil {
+		return err
+	}
+
+	skip, err := s.mergeMonthlyStats(tx, MergeStrategyKeepLocal)
+	if err != nil {
+		return fmt.Errorf("failed to merge monthly stats: %w", err)
+	}
+	if err := s.mergeDailyStats(tx, MergeStrategyKeepLocal, skip); err != nil {
+		return fmt.Errorf("failed to merge daily stats: %w", err)
+	}
+	settings := MergeStrategyKeepLocal
+	if resolution != nil && resolution.Default == MergeSideRemote {
+		settings = MergeStrategyOverwriteLocal
+	}
+	if err := s.mergeAppConfig(tx, settings); err != nil {
+		return fmt.Errorf("failed to merge app config: %w", err)
+	}
+
+	// The backup is now the common ancestor of both sides
+	if err := saveEndpointSyncBase(tx, remote); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// replaceEndpoints stores the merged endpoints, keeping the row IDs of local endpoints
+func replaceEndpoints(tx *sql.Tx, endpoints []Endpoint) error {
+	if _, err := tx.Exec(`DELETE FROM endpoints`); err != nil {
+		return err
+	}
+	for _, ep := range endpoints {
+		var id interface{}
+		if ep.ID > 0 {
+			id = ep.ID
+		}
+		_, err := tx.Exec(`INSERT INTO endpoints (id, name, api_url, api_key, enabled, transformer, model, remark, sort_order, azure_resource, azure_deployment, azure_api_version, rewrite_rules, capabilities, uid)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, ep.Name, ep.APIUrl, ep.APIKey, ep.Enabled, ep.Transformer, ep.Model, ep.Remark, ep.SortOrder, ep.AzureResource, ep.AzureDeployment, ep.AzureAPIVersion, ep.RewriteRules, ep.Capabilities, ep.UID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignEndpointUIDs gives endpoints without a UID (older databases and backups) a random one
+func assignEndpointUIDs(e execer) error {
+	_, err := e.Exec(`UPDATE endpoints SET uid = lower(hex(randomblob(16))) WHERE uid IS NULL OR uid = ''`)
+	return err
+}
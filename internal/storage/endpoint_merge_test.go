@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"testing"
+)
+
+func mergeTestEndpoint(uid, name, key string, order int) Endpoint {
+	return Endpoint{UID: uid, Name: name, APIUrl: "https://" + name, APIKey: key, Enabled: true, SortOrder: order}
+}
+
+func findMerged(t *testing.T, eps []Endpoint, uid string) *Endpoint {
+	t.Helper()
+	for i := range eps {
+		if eps[i].UID == uid {
+			return &eps[i]
+		}
+	}
+	return nil
+}
+
+func TestMergeEndpointVersions(t *testing.T) {
+	base := []Endpoint{
+		mergeTestEndpoint("a", "alpha", "k1", 0),
+		mergeTestEndpoint("b", "beta", "k2", 1),
+		mergeTestEndpoint("c", "gamma", "k3", 2),
+		mergeTestEndpoint("d", "delta", "k4", 3),
+	}
+
+	local := append([]Endpoint(nil), base...)
+	local[0].Remark = "local remark"    // Local-only change
+	local[1].APIKey = "local-key"       // Conflicts with the remote key
+	local = append(local[:2], local[3]) // gamma deleted locally
+	local[2].Name = "delta-renamed"     // Local rename
+	local = append(local, mergeTestEndpoint("e", "epsilon", "k5", 4))
+
+	remote := append([]Endpoint(nil), base...)
+	remote[0].Model = "remote-model" // Remote-only change of another field
+	remote[1].APIKey = "remote-key"
+	remote = remote[:3] // delta deleted remotely although renamed locally
+
+	merged, conflicts, err := mergeEndpointVersions(base, local, remote, true, nil)
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+
+	alpha := findMerged(t, merged, "a")
+	if alpha == nil || alpha.Remark != "local remark" || alpha.Model != "remote-model" {
+		t.Fatalf("non-conflicting changes not combined: %+v", alpha)
+	}
+	if findMerged(t, merged, "c") != nil {
+		t.Fatalf("locally deleted endpoint was resurrected")
+	}
+	if findMerged(t, merged, "e") == nil {
+		t.Fatalf("locally added endpoint missing")
+	}
+	if beta := findMerged(t, merged, "b"); beta == nil || beta.APIKey != "local-key" {
+		t.Fatalf("conflict should keep the local value by default: %+v", beta)
+	}
+
+	if len(conflicts) != 2 {
+		t.Fatalf("expected 2 conflicts, got %+v", conflicts)
+	}
+	for _, c := range conflicts {
+		switch c.UID {
+		case "b":
+			if len(c.ConflictFields) != 1 || c.ConflictFields[0] != "apiKey" {
+				t.Fatalf("unexpected beta conflict fields: %v", c.ConflictFields)
+			}
+		case "d":
+			if c.Deleted != MergeSideRemote || c.ConflictFields[0] != MergeFieldDeleted {
+				t.Fatalf("unexpected delta conflict: %+v", c)
+			}
+		default:
+			t.Fatalf("unexpected conflict: %+v", c)
+		}
+	}
+	if delta := findMerged(t, merged, "d"); delta == nil || delta.Name != "delta-renamed" {
+		t.Fatalf("renamed endpoint should be kept by default: %+v", delta)
+	}
+
+	resolution := &MergeResolution{Endpoints: map[string]EndpointResolution{
+		"b": {Fields: map[string]string{"apiKey": MergeSideRemote}},
+		"d": {Default: MergeSideRemote},
+	}}
+	merged, _, err = mergeEndpointVersions(base, local, remote, true, resolution)
+	if err != nil {
+		t.Fatalf("merge with resolution: %v", err)
+	}
+	if beta := findMerged(t, merged, "b"); beta == nil || beta.APIKey != "remote-key" {
+		t.Fatalf("field resolution not applied: %+v", beta)
+	}
+	if findMerged(t, merged, "d") != nil {
+		t.Fatalf("remote deletion not applied")
+	}
+	for i, ep := range merged {
+		if ep.SortOrder != i {
+			t.Fatalf("sort order not renumbered: %+v", merged)
+		}
+	}
+}
+
+func TestMergeEndpointVersionsRemoteRename(t *testing.T) {
+	base := []Endpoint{mergeTestEndpoint("a", "alpha", "k1", 0)}
+	local := []Endpoint{mergeTestEndpoint("a", "alpha", "k1", 0)}
+	remote := []Endpoint{mergeTestEndpoint("a", "alpha-2", "k1", 0)}
+
+	merged, conflicts, err := mergeEndpointVersions(base, local, remote, true, nil)
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if len(conflicts) != 0 || len(merged) != 1 || merged[0].Name != "alpha-2" {
+		t.Fatalf("remote rename should apply without conflicts: %+v %+v", merged, conflicts)
+	}
+}
@@ -3,17 +3,23 @@ package storage
 import "time"
 
 type Endpoint struct {
-	ID          int64     `json:"id"`
-	Name        string    `json:"name"`
-	APIUrl      string    `json:"apiUrl"`
-	APIKey      string    `json:"apiKey"`
-	Enabled     bool      `json:"enabled"`
-	Transformer string    `json:"transformer"`
-	Model       string    `json:"model"`
-	Remark      string    `json:"remark"`
-	SortOrder   int       `json:"sortOrder"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	ID              int64     `json:"id"`
+	UID             string    `json:"uid"` // Stable identity across renames and devices
+	Name            string    `json:"name"`
+	APIUrl          string    `json:"apiUrl"`
+	APIKey          string    `json:"apiKey"`
+	Enabled         bool      `json:"enabled"`
+	Transformer     string    `json:"transformer"`
+	Model           string    `json:"model"`
+	Remark          string    `json:"remark"`
+	SortOrder       int       `json:"sortOrder"`
+	AzureResource   string    `json:"azureResource"`
+	AzureDeployment string    `json:"azureDeployment"`
+	AzureAPIVersion string    `json:"azureApiVersion"`
+	RewriteRules    string    `json:"rewriteRules"` // JSON-encoded rule list
+	Capabilities    string    `json:"capabilities"` // JSON-encoded capability profile
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
 }
 
 type DailyStat struct {
@@ -24,6 +30,8 @@ type DailyStat struct {
 	Errors       int
 	InputTokens  int
 	OutputTokens int
+	CacheHits    int
+	SavedTokens  int
 	DeviceID     string
 	CreatedAt    time.Time
 }
@@ -33,8 +41,13 @@ type EndpointStats struct {
 	Errors       int
 	InputTokens  int64
 	OutputTokens int64
+	CacheHits    int
+	SavedTokens  int64
 }
 
+// Storage is the persistence used by the proxy and the services. SQLiteStorage keeps everything
+// in one local file; PostgresStorage serves deployments that share a database. Backups are SQLite
+// files for both.
 type Storage interface {
 	// Endpoints
 	GetEndpoints() ([]Endpoint, error)
@@ -46,13 +59,69 @@ type Storage interface {
 	RecordDailyStat(stat *DailyStat) error
 	GetDailyStats(endpointName, startDate, endDate string) ([]DailyStat, error)
 	GetAllStats() (map[string][]DailyStat, error)
+	GetAllStatsForDevice(deviceID string) (map[string][]DailyStat, error)
 	GetTotalStats() (int, map[string]*EndpointStats, error)
 	GetEndpointTotalStats(endpointName string) (*EndpointStats, error)
+	GetDeviceStats() ([]DeviceStats, error)
+
+	// Usage rollups by model and client format
+	RecordUsageStat(stat *UsageStat) error
+	RecordRequestStat(stat *DailyStat, usage *UsageStat) error // Daily stat and hourly usage in one transaction
+	GetUsageStats(q UsageQuery) ([]UsageBucket, error)
+	DownsampleUsageStats(beforeDate string) (int, error)
+
+	// Stats sync
+	GetStatsChanges(deviceID string, sinceVersion int64) ([]StatsChange, int64, error)
+	ApplyStatsChanges(deviceID string, changes []StatsChange) error
+
+	// Archives
+	GetArchiveMonths() ([]string, error)
+	GetMonthlyArchiveData(month string) ([]MonthlyArchiveData, error)
+	DeleteMonthlyStats(month string) error
+	RollupMonthlyStats(beforeMonth string, prune bool) ([]string, error)
+	GetMonthlyStats(month string) ([]MonthlyStat, error)
 
 	// Config
 	GetConfig(key string) (string, error)
 	SetConfig(key, value string) error
+	GetOrCreateDeviceID() (string, error)
+
+	// Response cache
+	GetCachedResponse(key string) (*CachedResponse, error)
+	PutCachedResponse(entry *CachedResponse, maxEntries int, maxBytes int64) error
+	ClearResponseCache() error
+	GetResponseCacheInfo() (int, int64, int, error)
+
+	// Stored Responses API responses
+	GetStoredResponse(id string) (*StoredResponse, error)
+	PutStoredResponse(r *StoredResponse, cutoff time.Time) error
+	DeleteStoredResponse(id string) (bool, error)
+
+	// Message batches
+	CreateMessageBatch(batch *MessageBatch, items []MessageBatchItem) error
+	GetMessageBatch(id string) (*MessageBatch, error)
+	ListMessageBatches(limit int, beforeID, afterID string) ([]MessageBatch, bool, error)
+	UpdateMessageBatch(batch *MessageBatch) error
+	DeleteMessageBatch(id string) error
+	ClaimMessageBatchItem() (*MessageBatchItem, error)
+	FinishMessageBatchItem(batchID string, index int, status, result string) error
+	SettlePendingMessageBatchItems(batchID, status, result string) error
+	ResetRunningMessageBatchItems() error
+	GetMessageBatchItems(batchID string) ([]MessageBatchItem, error)
+
+	// Backup and restore; backups are SQLite files
+	CreateBackupArchive(archivePath, appVersion string, base *BackupBase) (*BackupManifest, error)
+	CreateBackupCopy(backupPath string) error
+	DetectEndpointConflicts(remoteDBPath string) ([]MergeConflict, error)
+	MergeFromBackup(backupDBPath string, strategy MergeStrategy) error
+	ThreeWayMergeFromBackup(backupDBPath string, resolution *MergeResolution) error
+	SnapshotEndpointSyncBase() error
 
 	// Close
 	Close() error
 }
+
+var (
+	_ Storage = (*SQLiteStorage)(nil)
+	_ Storage = (*PostgresStorage)(nil)
+)
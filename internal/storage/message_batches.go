@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Item statuses the queue works with; finished items are succeeded, errored, canceled or expired
+const (
+	batchItemPending = "pending"
+	batchItemRunning = "running"
+)
+
+// MessageBatch is a Message Batches API job. Request counts are computed from the items of
+// emulated batches; passthrough batches keep the last object returned by the upstream instead.
+type MessageBatch struct {
+	ID                string
+	EndpointName      string
+	Mode              string // passthrough | emulated
+	Status            string // in_progress | canceling | ended
+	Headers           string // JSON object of request headers replayed for each item
+	Upstream          string // Last batch object returned by the upstream (passthrough only)
+	CreatedAt         time.Time
+	ExpiresAt         time.Time
+	EndedAt           time.Time // Zero while the batch is running
+	CancelInitiatedAt time.Time // Zero unless the batch was canceled
+	Processing        int
+	Succeeded         int
+	Errored           int
+	Canceled          int
+	Expired           int
+}
+
+// MessageBatchItem is a single request of an emulated batch
+type MessageBatchItem struct {
+	BatchID  string
+	Index    int
+	CustomID string
+	Params   string
+	Status   string
+	Result   string // JSON result object once the item is done
+}
+
+const messageBatchColumns = `b.id, b.endpoint_name, b.mode, b.processing_status, b.headers, b.upstream, b.created_at, b.expires_at, b.ended_at, b.cancel_initiated_at,
+	COALESCE(SUM(i.status IN ('pending', 'running')), 0), COALESCE(SUM(i.status = 'succeeded'), 0), COALESCE(SUM(i.status = 'errored'), 0),
+	COALESCE(SUM(i.status = 'canceled'), 0), COALESCE(SUM(i.status = 'expired'), 0)`
+
+// unixOrZero converts a stored unix timestamp, keeping 0 as the zero time
+func unixOrZero(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// zeroOrUnix converts a time for storage, storing the zero time as 0
+func zeroOrUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func scanMessageBatch(scan func(dest ...interface{}) error) (*MessageBatch, error) {
+	var b MessageBatch
+	var createdAt, expiresAt, endedAt, cancelAt int64
+	if err := scan(&b.ID, &b.EndpointName, &b.Mode, &b.Status, &b.Headers, &b.Upstream, &createdAt, &expiresAt, &endedAt, &cancelAt,
+		&b.Processing, &b.Succeeded, &b.Errored, &b.Canceled, &b.Expired); err != nil {
+		return nil, err
+	}
+	b.CreatedAt = unixOrZero(createdAt)
+	b.ExpiresAt = unixOrZero(expiresAt)
+	b.EndedAt = unixOrZero(endedAt)
+	b.CancelInitiatedAt = unixOrZero(cancelAt)
+	return &b, nil
+}
+
+// CreateMessageBatch stores a batch and its items
+func (s *SQLiteStorage) CreateMessageBatch(batch *MessageBatch, items []MessageBatchItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO message_batches
+		(id, endpoint_name, mode, processing_status, headers, upstream, created_at, expires_at, ended_at, cancel_initiated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		batch.ID, batch.EndpointName, batch.Mode, batch.Status, batch.Headers, batch.Upstream,
+		zeroOrUnix(batch.CreatedAt), zeroOrUnix(batch.ExpiresAt), zeroOrUnix(batch.EndedAt), zeroOrUnix(batch.CancelInitiatedAt))
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO message_batch_items (batch_id, idx, custom_id, params, status, result) VALUES (?, ?, ?, ?, ?, '')`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, item := range items {
+		if _, err := stmt.Exec(batch.ID, item.Index, item.CustomID, item.Params, batchItemPending); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetMessageBatch returns a batch, or nil when it does not exist
+func (s *SQLiteStorage) GetMessageBatch(id string) (*MessageBatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	row := s.db.QueryRow(`SELECT `+messageBatchColumns+`
+		FROM message_batches b LEFT JOIN message_batch_items i ON i.batch_id = b.id
+		WHERE b.id=? GROUP BY b.id`, id)
+	batch, err := scanMessageBatch(row.Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return batch, err
+}
+
+// ListMessageBatches returns up to limit batches, newest first. afterID returns the batches older
+// than that batch and beforeID the ones newer than it. The boolean reports whether more remain.
+func (s *SQLiteStorage) ListMessageBatches(limit int, beforeID, afterID string) ([]MessageBatch, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Local and upstream batch IDs do not sort alike, so cursors compare (created_at, id)
+	where, order, args := "", "DESC", []interface{}{}
+	switch {
+	case afterID != "":
+		where, args = "WHERE (b.created_at, b.id) < (SELECT created_at, id FROM message_batches WHERE id=?)", append(args, afterID)
+	case beforeID != "":
+		where, order, args = "WHERE (b.created_at, b.id) > (SELECT created_at, id FROM message_batches WHERE id=?)", "ASC", append(args, beforeID)
+	}
+	args = append(args, limit+1)
+
+	rows, err := s.db.Query(`SELECT `+messageBatchColumns+`
+		FROM message_batches b LEFT JOIN message_batch_items i ON i.batch_id = b.id
+		`+where+` GROUP BY b.id ORDER BY b.created_at `+order+`, b.id `+order+` LIMIT ?`, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var batches []MessageBatch
+	for rows.Next() {
+		batch, err := scanMessageBatch(rows.Scan)
+		if err != nil {
+			return nil, false, err
+		}
+		batches = append(batches, *batch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	if order == "ASC" {
+		for i, j := 0, len(batches)-1; i < j; i, j = i+1, j-1 {
+			batches[i], batches[j] = batches[j], batches[i]
+		}
+	}
+	return batches, hasMore, nil
+}
+
+// UpdateMessageBatch saves the status, timestamps and upstream object of a batch
+func (s *SQLiteStorage) UpdateMessageBatch(batch *MessageBatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec(`UPDATE message_batches SET processing_status=?, upstream=?, ended_at=?, cancel_initiated_at=? WHERE id=?`,
+		batch.Status, batch.Upstream, zeroOrUnix(batch.EndedAt), zeroOrUnix(batch.CancelInitiatedAt), batch.ID)
+	return err
+}
+
+// DeleteMessageBatch removes a batch and its items
+func (s *SQLiteStorage) DeleteMessageBatch(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM message_batch_items WHERE batch_id=?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM message_batches WHERE id=?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClaimMessageBatchItem marks the oldest pending item of a running batch as running and returns it,
+// or nil when there is no work
+func (s *SQLiteStorage) ClaimMessageBatchItem() (*MessageBatchItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var item MessageBatchItem
+	err := s.db.QueryRow(`SELECT i.batch_id, i.idx, i.custom_id, i.params
+		FROM message_batch_items i JOIN message_batches b ON b.id = i.batch_id
+		WHERE i.status=? AND b.processing_status='in_progress'
+		ORDER BY b.created_at, b.id, i.idx LIMIT 1`, batchItemPending).Scan(&item.BatchID, &item.Index, &item.CustomID, &item.Params)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.db.Exec(`UPDATE message_batch_items SET status=? WHERE batch_id=? AND idx=?`, batchItemRunning, item.BatchID, item.Index); err != nil {
+		return nil, err
+	}
+	item.Status = batchItemRunning
+	return &item, nil
+}
+
+// FinishMessageBatchItem records the outcome of an item
+func (s *SQLiteStorage) FinishMessageBatchItem(batchID string, index int, status, result string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec(`UPDATE message_batch_items SET status=?, result=? WHERE batch_id=? AND idx=?`, status, result, batchID, index)
+	return err
+}
+
+// SettlePendingMessageBatchItems gives every pending item of a batch the final status (canceled or
+// expired) with the matching result
+func (s *SQLiteStorage) SettlePendingMessageBatchItems(batchID, status, result string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec(`UPDATE message_batch_items SET status=?, result=? WHERE batch_id=? AND status=?`, status, result, batchID, batchItemPending)
+	return err
+}
+
+// ResetRunningMessageBatchItems returns items left running by an interrupted process to the queue
+func (s *SQLiteStorage) ResetRunningMessageBatchItems() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec(`UPDATE message_batch_items SET status=? WHERE status=?`, batchItemPending, batchItemRunning)
+	return err
+}
+
+// GetMessageBatchItems returns the items of a batch in submission order
+func (s *SQLiteStorage) GetMessageBatchItems(batchID string) ([]MessageBatchItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(`SELECT batch_id, idx, custom_id, params, status, result
+		FROM message_batch_items WHERE batch_id=? ORDER BY idx`, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []MessageBatchItem
+	for rows.Next() {
+		var item MessageBatchItem
+		if err := rows.Scan(&item.BatchID, &item.Index, &item.CustomID, &item.Params, &item.Status, &item.Result); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
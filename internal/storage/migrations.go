@@ -0,0 +1,403 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// SchemaVersion is the newest database schema this binary knows, the version of the last
+// migration. Databases and backups written by a newer schema are refused.
+const SchemaVersion = 3
+
+// ErrSchemaTooNew is returned when a database or backup was written by a newer version of ccNexus
+var ErrSchemaTooNew = errors.New("database schema is newer than supported")
+
+// schemaMigration is one numbered change of the database schema. Up and Down run in a
+// transaction together with the bookkeeping row in schema_migrations.
+type schemaMigration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+// schemaMigrations lists the SQLite migrations in order. A released migration is never changed;
+// schema changes get a new migration here and in postgresMigrations, and SchemaVersion is raised
+// to its version.
+var schemaMigrations = []schemaMigration{
+	{Version: 1, Name: "baseline", Up: migrateBaseline, Down: dropBaseline},
+	{Version: 2, Name: "usage_stats", Up: migrateUsageStats, Down: dropUsageStats},
+	{Version: 3, Name: "monthly_stats", Up: migrateMonthlyStats, Down: dropMonthlyStats},
+}
+
+// migrationSet is the migration list of one database engine together with the SQL that keeps
+// track of the applied migrations
+type migrationSet struct {
+	migrations  []schemaMigration
+	createTable string // Creates schema_migrations
+	record      string // Inserts a row of schema_migrations (version, name)
+	forget      string // Deletes the row of a version
+	version     func(q rowQuerier) (int, error)
+}
+
+var sqliteMigrations = migrationSet{
+	migrations: schemaMigrations,
+	createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	record: `INSERT INTO schema_migrations (version, name) VALUES (?, ?)`,
+	forget: `DELETE FROM schema_migrations WHERE version = ?`,
+	version: func(q rowQuerier) (int, error) {
+		return schemaVersionOf(q, "main")
+	},
+}
+
+// schemaVersionOf returns the schema version of a database (main or attached); databases written
+// before versioned migrations have version 0
+func schemaVersionOf(q rowQuerier, dbName string) (int, error) {
+	var count int
+	if err := q.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s.sqlite_master WHERE type='table' AND name='schema_migrations'`, dbName)).Scan(&count); err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	var version int
+	err := q.QueryRow(fmt.Sprintf(`SELECT COALESCE(MAX(version), 0) FROM %s.schema_migrations`, dbName)).Scan(&version)
+	return version, err
+}
+
+// checkSchemaVersion refuses databases of a newer schema than SchemaVersion
+func checkSchemaVersion(q rowQuerier, dbName string) error {
+	version, err := schemaVersionOf(q, dbName)
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("%w: %d (supported up to %d)", ErrSchemaTooNew, version, SchemaVersion)
+	}
+	return nil
+}
+
+// migrateSchema migrates the database up or down to the target version, one transaction per
+// migration. Down migrations exist for tests; the application only migrates up.
+func migrateSchema(db *sql.DB, set migrationSet, target int) error {
+	if _, err := db.Exec(set.createTable); err != nil {
+		return err
+	}
+	current, err := set.version(db)
+	if err != nil {
+		return err
+	}
+	if current > SchemaVersion {
+		return fmt.Errorf("%w: %d (supported up to %d)", ErrSchemaTooNew, current, SchemaVersion)
+	}
+
+	for _, m := range set.migrations {
+		if m.Version > current && m.Version <= target {
+			if err := runMigration(db, set, m, true); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(set.migrations) - 1; i >= 0; i-- {
+		if m := set.migrations[i]; m.Version <= current && m.Version > target {
+			if err := runMigration(db, set, m, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runMigration(db *sql.DB, set migrationSet, m schemaMigration, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if up {
+		err = m.Up(tx)
+		if err == nil {
+			_, err = tx.Exec(set.record, m.Version, m.Name)
+		}
+	} else {
+		err = m.Down(tx)
+		if err == nil {
+			_, err = tx.Exec(set.forget, m.Version)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+	}
+	return tx.Commit()
+}
+
+// baselineSchema is the schema before versioned migrations. Databases of that time may lack
+// columns added later, which migrateBaseline adds.
+const baselineSchema = `
+
+	CREATE TABLE IF NOT EXISTS endpoints (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		api_url TEXT NOT NULL,
+		api_key TEXT NOT NULL,
+		enabled BOOLEAN DEFAULT TRUE,
+		transformer TEXT DEFAULT 'claude',
+		model TEXT,
+		remark TEXT,
+		sort_order INTEGER DEFAULT 0,
+		azure_resource TEXT DEFAULT '',
+		azure_deployment TEXT DEFAULT '',
+		azure_api_version TEXT DEFAULT '',
+		rewrite_rules TEXT DEFAULT '',
+		capabilities TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS daily_stats (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		endpoint_name TEXT NOT NULL,
+		date TEXT NOT NULL,
+		requests INTEGER DEFAULT 0,
+		errors INTEGER DEFAULT 0,
+		input_tokens INTEGER DEFAULT 0,
+		output_tokens INTEGER DEFAULT 0,
+		cache_hits INTEGER DEFAULT 0,
+		saved_tokens INTEGER DEFAULT 0,
+		device_id TEXT DEFAULT 'default',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(endpoint_name, date, device_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS app_config (
+		key TEXT PRIMARY KEY,
+		value TEXT,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_daily_stats_date ON daily_stats(date);
+	CREATE INDEX IF NOT EXISTS idx_daily_stats_endpoint ON daily_stats(endpoint_name);
+	CREATE INDEX IF NOT EXISTS idx_daily_stats_device ON daily_stats(device_id);
+
+	CREATE TABLE IF NOT EXISTS response_cache (
+		key TEXT PRIMARY KEY,
+		endpoint_name TEXT NOT NULL,
+		model TEXT DEFAULT '',
+		status INTEGER NOT NULL,
+		headers TEXT DEFAULT '',
+		body BLOB,
+		chunks TEXT DEFAULT '',
+		input_tokens INTEGER DEFAULT 0,
+		output_tokens INTEGER DEFAULT 0,
+		size INTEGER DEFAULT 0,
+		hits INTEGER DEFAULT 0,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_response_cache_expires ON response_cache(expires_at);
+
+	CREATE TABLE IF NOT EXISTS message_batches (
+		id TEXT PRIMARY KEY,
+		endpoint_name TEXT NOT NULL,
+		mode TEXT NOT NULL,
+		processing_status TEXT NOT NULL,
+		headers TEXT DEFAULT '',
+		upstream TEXT DEFAULT '',
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		ended_at INTEGER DEFAULT 0,
+		cancel_initiated_at INTEGER DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS message_batch_items (
+		batch_id TEXT NOT NULL,
+		idx INTEGER NOT NULL,
+		custom_id TEXT NOT NULL,
+		params TEXT NOT NULL,
+		status TEXT NOT NULL,
+		result TEXT DEFAULT '',
+		PRIMARY KEY (batch_id, idx)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_message_batch_items_status ON message_batch_items(status);
+
+	CREATE TABLE IF NOT EXISTS stored_responses (
+		id TEXT PRIMARY KEY,
+		previous_id TEXT DEFAULT '',
+		endpoint_name TEXT NOT NULL,
+		model TEXT DEFAULT '',
+		input TEXT NOT NULL,
+		output TEXT NOT NULL,
+		response TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_stored_responses_created ON stored_responses(created_at);
+`
+
+// baselineTables are dropped by dropBaseline, children first
+var baselineTables = []string{"stored_responses", "message_batch_items", "message_batches", "response_cache", "app_config", "daily_stats", "endpoints"}
+
+// migrateBaseline creates the tables and brings databases created before versioned migrations to
+// the same layout, adding the columns that used to be probed for on every start
+func migrateBaseline(tx *sql.Tx) error {
+	if _, err := tx.Exec(baselineSchema); err != nil {
+		return err
+	}
+
+	// sort_order starts from the ID order of existing endpoints
+	exists, err := hasColumn(tx, "main", "endpoints", "sort_order")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if _, err := tx.Exec(`ALTER TABLE endpoints ADD COLUMN sort_order INTEGER DEFAULT 0`); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE endpoints SET sort_order = id WHERE sort_order = 0`); err != nil {
+			return err
+		}
+	}
+
+	// Azure OpenAI, rewrite rule, capability and UID columns
+	for _, column := range endpointTextColumns {
+		if err := addColumnIfMissing(tx, "endpoints", column, "TEXT DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	if err := assignEndpointUIDs(tx); err != nil {
+		return err
+	}
+
+	// Cache statistics columns
+	for _, column := range dailyStatsCacheColumns {
+		if err := addColumnIfMissing(tx, "daily_stats", column, "INTEGER DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+
+	// Stats sync version. Existing rows get their row ID as version, so that the first sync
+	// pushes the whole local history.
+	exists, err = hasColumn(tx, "main", "daily_stats", "sync_version")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if _, err := tx.Exec(`ALTER TABLE daily_stats ADD COLUMN sync_version INTEGER DEFAULT 0`); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE daily_stats SET sync_version = id`); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS idx_daily_stats_sync ON daily_stats(device_id, sync_version)`)
+	return err
+}
+
+func dropBaseline(tx *sql.Tx) error {
+	for _, table := range baselineTables {
+		if _, err := tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s`, table)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column unless the table already has it
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	exists, err := hasColumn(tx, "main", table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
+
+// usageStatsSchema holds the usage rollups by endpoint, client model, upstream model and client
+// format. Recent rows have hour granularity (bucket "2006-01-02 15"); older ones are downsampled
+// to day granularity (bucket "2006-01-02").
+const usageStatsSchema = `
+	CREATE TABLE IF NOT EXISTS usage_stats (
+		granularity TEXT NOT NULL,
+		bucket TEXT NOT NULL,
+		endpoint_name TEXT NOT NULL,
+		client_model TEXT NOT NULL DEFAULT '',
+		upstream_model TEXT NOT NULL DEFAULT '',
+		client_format TEXT NOT NULL DEFAULT '',
+		device_id TEXT NOT NULL DEFAULT 'default',
+		requests INTEGER DEFAULT 0,
+		errors INTEGER DEFAULT 0,
+		input_tokens INTEGER DEFAULT 0,
+		output_tokens INTEGER DEFAULT 0,
+		cache_hits INTEGER DEFAULT 0,
+		saved_tokens INTEGER DEFAULT 0,
+		UNIQUE(granularity, bucket, endpoint_name, client_model, upstream_model, client_format, device_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_usage_stats_bucket ON usage_stats(granularity, bucket);
+`
+
+func migrateUsageStats(tx *sql.Tx) error {
+	_, err := tx.Exec(usageStatsSchema)
+	return err
+}
+
+func dropUsageStats(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS usage_stats`)
+	return err
+}
+
+// monthlyStatsSchema holds the rollups of archived months by endpoint, device and client model.
+// pruned marks months whose daily stats were deleted after the rollup.
+const monthlyStatsSchema = `
+	CREATE TABLE IF NOT EXISTS monthly_stats (
+		month TEXT NOT NULL,
+		endpoint_name TEXT NOT NULL,
+		device_id TEXT NOT NULL DEFAULT 'default',
+		model TEXT NOT NULL DEFAULT '',
+		requests INTEGER DEFAULT 0,
+		errors INTEGER DEFAULT 0,
+		input_tokens INTEGER DEFAULT 0,
+		output_tokens INTEGER DEFAULT 0,
+		cache_hits INTEGER DEFAULT 0,
+		saved_tokens INTEGER DEFAULT 0,
+		pruned INTEGER NOT NULL DEFAULT 0,
+		UNIQUE(month, endpoint_name, device_id, model)
+	);
+`
+
+// restorePrunedMonthsSQL puts the rollups of pruned months back into daily_stats as the first
+// day of the month, so downgrading keeps the totals
+const restorePrunedMonthsSQL = `
+	INSERT INTO daily_stats (endpoint_name, date, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens, device_id)
+	SELECT endpoint_name, month || '-01', SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens), SUM(cache_hits), SUM(saved_tokens), device_id
+	FROM monthly_stats WHERE pruned = 1
+	GROUP BY endpoint_name, month, device_id
+	ON CONFLICT (endpoint_name, date, device_id) DO UPDATE SET
+		requests = daily_stats.requests + excluded.requests,
+		errors = daily_stats.errors + excluded.errors,
+		input_tokens = daily_stats.input_tokens + excluded.input_tokens,
+		output_tokens = daily_stats.output_tokens + excluded.output_tokens,
+		cache_hits = daily_stats.cache_hits + excluded.cache_hits,
+		saved_tokens = daily_stats.saved_tokens + excluded.saved_tokens
+`
+
+func migrateMonthlyStats(tx *sql.Tx) error {
+	_, err := tx.Exec(monthlyStatsSchema)
+	return err
+}
+
+func dropMonthlyStats(tx *sql.Tx) error {
+	if _, err := tx.Exec(restorePrunedMonthsSQL); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DROP TABLE IF EXISTS monthly_stats`)
+	return err
+}
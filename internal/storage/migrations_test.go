@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSchemaMigrationsAreOrdered(t *testing.T) {
+	for _, set := range []migrationSet{sqliteMigrations, postgresMigrations} {
+		for i, m := range set.migrations {
+			if m.Version != i+1 || m.Up == nil || m.Down == nil {
+				t.Fatalf("migration %d: %+v", i, m)
+			}
+		}
+		if last := set.migrations[len(set.migrations)-1].Version; last != SchemaVersion {
+			t.Fatalf("SchemaVersion = %d, last migration = %d", SchemaVersion, last)
+		}
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first released layout, before any probed column
+	if _, err := db.Exec(`
+		CREATE TABLE endpoints (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE NOT NULL, api_url TEXT NOT NULL, api_key TEXT NOT NULL, enabled BOOLEAN DEFAULT TRUE, transformer TEXT DEFAULT 'claude', model TEXT, remark TEXT, created_at DATETIME DEFAULT CURRENT_TIMESTAMP, updated_at DATETIME DEFAULT CURRENT_TIMESTAMP);
+		CREATE TABLE daily_stats (id INTEGER PRIMARY KEY AUTOINCREMENT, endpoint_name TEXT NOT NULL, date TEXT NOT NULL, requests INTEGER DEFAULT 0, errors INTEGER DEFAULT 0, input_tokens INTEGER DEFAULT 0, output_tokens INTEGER DEFAULT 0, device_id TEXT DEFAULT 'default', created_at DATETIME DEFAULT CURRENT_TIMESTAMP, UNIQUE(endpoint_name, date, device_id));
+		INSERT INTO endpoints (name, api_url, api_key, model, remark) VALUES ('a', 'https://a', 'k', '', ''), ('b', 'https://b', 'k', '', '');
+		INSERT INTO daily_stats (endpoint_name, date, requests) VALUES ('a', '2026-01-01', 3);
+	`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatalf("open legacy database: %v", err)
+	}
+	defer s.Close()
+
+	if version, err := schemaVersionOf(s.db, "main"); err != nil || version != SchemaVersion {
+		t.Fatalf("schema version = %d, %v", version, err)
+	}
+	endpoints, err := s.GetEndpoints()
+	if err != nil || len(endpoints) != 2 {
+		t.Fatalf("endpoints = %+v, %v", endpoints, err)
+	}
+	for _, ep := range endpoints {
+		if ep.UID == "" || ep.SortOrder != int(ep.ID) {
+			t.Fatalf("legacy endpoint not migrated: %+v", ep)
+		}
+	}
+	changes, _, err := s.GetStatsChanges("default", 0)
+	if err != nil || len(changes) != 1 {
+		t.Fatalf("legacy stats not versioned for sync: %+v, %v", changes, err)
+	}
+}
+
+func TestMigrateDownAndRefuseNewerSchema(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.db")
+	s, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateSchema(s.db, sqliteMigrations, 0); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+	if exists, _ := hasColumn(s.db, "main", "endpoints", "name"); exists {
+		t.Fatal("endpoints table left after migrating down")
+	}
+	if err := migrateSchema(s.db, sqliteMigrations, SchemaVersion); err != nil {
+		t.Fatalf("migrate up again: %v", err)
+	}
+	if err := s.SaveEndpoint(&Endpoint{Name: "a", APIUrl: "https://a", APIKey: "k", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	newer := filepath.Join(dir, "newer.db")
+	if err := s.CreateBackupCopy(newer); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	db, err := sql.Open("sqlite", newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, 'future')`, SchemaVersion+1); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if _, err := NewSQLiteStorage(newer); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("opening a newer database: err = %v", err)
+	}
+
+	s, err = NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.MergeFromBackup(newer, MergeStrategyKeepLocal); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("merging a newer backup: err = %v", err)
+	}
+	if _, err := s.DetectEndpointConflicts(newer); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("detecting conflicts with a newer backup: err = %v", err)
+	}
+	if _, err := s.GetEndpoints(); err != nil {
+		t.Fatalf("database unusable after refused merge: %v", err)
+	}
+}
@@ -0,0 +1,486 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// MonthlyStat is the rollup of a month of daily stats for an endpoint, device and client model.
+// Requests without model information (recorded on other devices or before per-model stats) have
+// an empty model.
+type MonthlyStat struct {
+	Month        string `json:"month"` // Format: "2006-01"
+	EndpointName string `json:"endpoint"`
+	DeviceID     string `json:"device"`
+	Model        string `json:"model,omitempty"`
+	Requests     int64  `json:"requests"`
+	Errors       int64  `json:"errors"`
+	InputTokens  int64  `json:"inputTokens"`
+	OutputTokens int64  `json:"outputTokens"`
+	CacheHits    int64  `json:"cacheHits"`
+	SavedTokens  int64  `json:"savedTokens"`
+}
+
+// allStatsSource combines the daily stats with the rollups of months whose dailies were pruned,
+// so totals do not change when old months are archived
+const allStatsSource = `(
+	SELECT endpoint_name, date, device_id, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens FROM daily_stats
+	UNION ALL
+	SELECT endpoint_name, month || '-01', device_id, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens
+	FROM monthly_stats WHERE pruned = 1
+) AS all_stats`
+
+// upsertMonthlyStatSQL adds to the rollup row of a month, endpoint, device and model
+const upsertMonthlyStatSQL = `
+	INSERT INTO monthly_stats (month, endpoint_name, device_id, model, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens, pruned)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (month, endpoint_name, device_id, model) DO UPDATE SET
+		requests = monthly_stats.requests + excluded.requests,
+		errors = monthly_stats.errors + excluded.errors,
+		input_tokens = monthly_stats.input_tokens + excluded.input_tokens,
+		output_tokens = monthly_stats.output_tokens + excluded.output_tokens,
+		cache_hits = monthly_stats.cache_hits + excluded.cache_hits,
+		saved_tokens = monthly_stats.saved_tokens + excluded.saved_tokens,
+		pruned = excluded.pruned
+`
+
+func (m *MonthlyStat) counters() []*int64 {
+	return []*int64{&m.Requests, &m.Errors, &m.InputTokens, &m.OutputTokens, &m.CacheHits, &m.SavedTokens}
+}
+
+// computeMonthlyStats sums the daily stats of a month per endpoint and device and splits them by
+// client model using the usage rollups. Whatever the usage rollups do not cover stays without a
+// model, so the totals always match the daily stats.
+func computeMonthlyStats(q rowsQuerier, rebind func(string) string, month string) ([]MonthlyStat, error) {
+	type key struct{ endpoint, device string }
+	rows, err := q.Query(rebind(`SELECT endpoint_name, COALESCE(device_id, 'default'), CAST(SUM(requests) AS BIGINT), CAST(SUM(errors) AS BIGINT),
+		CAST(SUM(input_tokens) AS BIGINT), CAST(SUM(output_tokens) AS BIGINT), CAST(SUM(COALESCE(cache_hits, 0)) AS BIGINT), CAST(SUM(COALESCE(saved_tokens, 0)) AS BIGINT)
+		FROM daily_stats WHERE substr(date, 1, 7) = ? GROUP BY endpoint_name, COALESCE(device_id, 'default')`), month)
+	if err != nil {
+		return nil, err
+	}
+	totals := make(map[key]*MonthlyStat)
+	var keys []key
+	for rows.Next() {
+		t := &MonthlyStat{Month: month}
+		if err := rows.Scan(&t.EndpointName, &t.DeviceID, &t.Requests, &t.Errors, &t.InputTokens, &t.OutputTokens, &t.CacheHits, &t.SavedTokens); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		k := key{t.EndpointName, t.DeviceID}
+		totals[k] = t
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(rebind(`SELECT endpoint_name, device_id, client_model, CAST(SUM(requests) AS BIGINT), CAST(SUM(errors) AS BIGINT),
+		CAST(SUM(input_tokens) AS BIGINT), CAST(SUM(output_tokens) AS BIGINT), CAST(SUM(cache_hits) AS BIGINT), CAST(SUM(saved_tokens) AS BIGINT)
+		FROM usage_stats WHERE substr(bucket, 1, 7) = ? AND client_model <> ''
+		GROUP BY endpoint_name, device_id, client_model ORDER BY endpoint_name, device_id, client_model`), month)
+	if err != nil {
+		return nil, err
+	}
+	byModel := make(map[key][]MonthlyStat)
+	for rows.Next() {
+		m := MonthlyStat{Month: month}
+		if err := rows.Scan(&m.EndpointName, &m.DeviceID, &m.Model, &m.Requests, &m.Errors, &m.InputTokens, &m.OutputTokens, &m.CacheHits, &m.SavedTokens); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		k := key{m.EndpointName, m.DeviceID}
+		byModel[k] = append(byModel[k], m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		return keys[i].device < keys[j].device
+	})
+	// Rows are ordered like the stored rollups: by endpoint, device and model, "" first
+	var result []MonthlyStat
+	for _, k := range keys {
+		rest := totals[k]
+		models := byModel[k]
+		for i := range models {
+			// A model never gets more than what is left of the daily total
+			mc, rc := models[i].counters(), rest.counters()
+			for j := range mc {
+				if *mc[j] > *rc[j] {
+					*mc[j] = *rc[j]
+				}
+				*rc[j] -= *mc[j]
+			}
+		}
+		for _, c := range rest.counters() {
+			if *c != 0 {
+				result = append(result, *rest)
+				break
+			}
+		}
+		result = append(result, models...)
+	}
+	return result, nil
+}
+
+// rollupMonth writes the rollup of a month. A month that keeps its dailies is recomputed from
+// them; once a month is pruned, its rollup only grows by the dailies that arrive later (e.g. from
+// stats sync), which are pruned in turn.
+func rollupMonth(tx *sql.Tx, rebind func(string) string, month string, prune bool) error {
+	stats, err := computeMonthlyStats(tx, rebind, month)
+	if err != nil {
+		return err
+	}
+
+	var prunedRows int
+	if err := tx.QueryRow(rebind(`SELECT COUNT(*) FROM monthly_stats WHERE month = ? AND pruned = 1`), month).Scan(&prunedRows); err != nil {
+		return err
+	}
+	pruned := prunedRows > 0
+	if !pruned {
+		if _, err := tx.Exec(rebind(`DELETE FROM monthly_stats WHERE month = ?`), month); err != nil {
+			return err
+		}
+	}
+	prune = prune || pruned
+
+	flag := 0
+	if prune {
+		flag = 1
+	}
+	for _, m := range stats {
+		if _, err := tx.Exec(rebind(upsertMonthlyStatSQL), m.Month, m.EndpointName, m.DeviceID, m.Model,
+			m.Requests, m.Errors, m.InputTokens, m.OutputTokens, m.CacheHits, m.SavedTokens, flag); err != nil {
+			return err
+		}
+	}
+	if !prune {
+		return nil
+	}
+
+	for _, query := range []string{
+		`DELETE FROM daily_stats WHERE substr(date, 1, 7) = ?`,
+		`DELETE FROM usage_stats WHERE substr(bucket, 1, 7) = ?`,
+		`UPDATE monthly_stats SET pruned = 1 WHERE month = ?`,
+	} {
+		if _, err := tx.Exec(rebind(query), month); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollupMonthlyStats rolls up every month before beforeMonth ("2006-01") that has daily stats
+func rollupMonthlyStats(db *sql.DB, rebind func(string) string, beforeMonth string, prune bool) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(rebind(`SELECT DISTINCT substr(date, 1, 7) FROM daily_stats WHERE date <> '' AND substr(date, 1, 7) < ? ORDER BY 1`), beforeMonth)
+	if err != nil {
+		return nil, err
+	}
+	months, err := scanStrings(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, month := range months {
+		if err := rollupMonth(tx, rebind, month, prune); err != nil {
+			return nil, err
+		}
+	}
+	return months, tx.Commit()
+}
+
+// getMonthlyStats returns the rollup of a month, or computes it from the dailies when the month
+// has not been rolled up yet
+func getMonthlyStats(q rowsQuerier, rebind func(string) string, month string) ([]MonthlyStat, error) {
+	rows, err := q.Query(rebind(`SELECT month, endpoint_name, device_id, model, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens
+		FROM monthly_stats WHERE month = ? ORDER BY endpoint_name, device_id, model`), month)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stats []MonthlyStat
+	for rows.Next() {
+		var m MonthlyStat
+		if err := rows.Scan(&m.Month, &m.EndpointName, &m.DeviceID, &m.Model, &m.Requests, &m.Errors, &m.InputTokens, &m.OutputTokens, &m.CacheHits, &m.SavedTokens); err != nil {
+			return nil, err
+		}
+		stats = append(stats, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(stats) > 0 {
+		return stats, nil
+	}
+	return computeMonthlyStats(q, rebind, month)
+}
+
+// getArchiveMonths lists the months with rollups or daily stats, newest first
+func getArchiveMonths(q rowsQuerier) ([]string, error) {
+	rows, err := q.Query(`SELECT month FROM monthly_stats
+		UNION SELECT substr(date, 1, 7) FROM daily_stats WHERE date IS NOT NULL AND date <> ''
+		ORDER BY 1 DESC`)
+	if err != nil {
+		return nil, err
+	}
+	return scanStrings(rows)
+}
+
+// deleteMonth removes the dailies, usage and rollups of a month
+func deleteMonth(tx *sql.Tx, rebind func(string) string, month string) error {
+	for _, query := range []string{
+		`DELETE FROM daily_stats WHERE substr(date, 1, 7) = ?`,
+		`DELETE FROM usage_stats WHERE substr(bucket, 1, 7) = ?`,
+		`DELETE FROM monthly_stats WHERE month = ?`,
+	} {
+		if _, err := tx.Exec(rebind(query), month); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupMonthlyRow is a rollup row carried by a backup delta or a snapshot exported from another storage
+type backupMonthlyRow struct {
+	MonthlyStat
+	Pruned bool `json:"pruned"`
+}
+
+// monthGroup is an endpoint and device of a month. A pruned month is merged per group rather than
+// per model, since two databases may split a group into models differently.
+type monthGroup struct{ month, endpoint, device string }
+
+// queryMonthlyRows reads every rollup row of a table ("monthly_stats", or "backup.monthly_stats"
+// for an attached SQLite database). The result is never nil, so a delta without rollups still
+// replaces those of its base.
+func queryMonthlyRows(q rowsQuerier, table string) ([]backupMonthlyRow, error) {
+	rows, err := q.Query(`SELECT month, endpoint_name, device_id, model, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens, pruned
+		FROM ` + table + ` ORDER BY month, endpoint_name, device_id, model`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]backupMonthlyRow, 0)
+	for rows.Next() {
+		var r backupMonthlyRow
+		var pruned int
+		if err := rows.Scan(&r.Month, &r.EndpointName, &r.DeviceID, &r.Model, &r.Requests, &r.Errors, &r.InputTokens, &r.OutputTokens, &r.CacheHits, &r.SavedTokens, &pruned); err != nil {
+			return nil, err
+		}
+		r.Pruned = pruned == 1
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+func insertMonthlyRow(e execer, rebind func(string) string, r backupMonthlyRow) error {
+	pruned := 0
+	if r.Pruned {
+		pruned = 1
+	}
+	_, err := e.Exec(rebind(`INSERT INTO monthly_stats (month, endpoint_name, device_id, model, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens, pruned)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		r.Month, r.EndpointName, r.DeviceID, r.Model, r.Requests, r.Errors, r.InputTokens, r.OutputTokens, r.CacheHits, r.SavedTokens, pruned)
+	return err
+}
+
+// mergePrunedMonths merges the rollups of the months a backup pruned. A month of an endpoint and
+// device is counted either from its dailies or from its pruned rollup, never both, so:
+//   - months the backup pruned are rolled up and pruned here too;
+//   - a group the backup pruned is kept or overwritten as a whole according to the strategy,
+//     together with the backup dailies that arrived after the pruning;
+//   - backup dailies of a group pruned only here would be counted on top of its rollup, so
+//     keep_local skips them and overwrite_local drops the local rollup of the group.
+//
+// It returns the groups whose backup dailies must be skipped. Rollups of months the backup did not
+// prune are not merged; they are recomputed from the merged dailies.
+func mergePrunedMonths(tx *sql.Tx, rebind func(string) string, monthly []backupMonthlyRow, dailyGroups []monthGroup, strategy MergeStrategy) (map[monthGroup]bool, error) {
+	if strategy != MergeStrategyKeepLocal && strategy != MergeStrategyOverwriteLocal {
+		return nil, fmt.Errorf("unknown merge strategy: %s", strategy)
+	}
+
+	backupGroups := make(map[monthGroup][]backupMonthlyRow)
+	var groups []monthGroup
+	var months []string
+	prunedMonths := make(map[string]bool)
+	for _, r := range monthly {
+		if !r.Pruned {
+			continue
+		}
+		g := monthGroup{r.Month, r.EndpointName, r.DeviceID}
+		if backupGroups[g] == nil {
+			groups = append(groups, g)
+		}
+		backupGroups[g] = append(backupGroups[g], r)
+		if !prunedMonths[r.Month] {
+			prunedMonths[r.Month] = true
+			months = append(months, r.Month)
+		}
+	}
+
+	sort.Strings(months)
+	for _, month := range months {
+		if err := rollupMonth(tx, rebind, month, true); err != nil {
+			return nil, err
+		}
+	}
+
+	countGroup := func(g monthGroup, prunedOnly bool) (int, error) {
+		query := `SELECT COUNT(*) FROM monthly_stats WHERE month = ? AND endpoint_name = ? AND device_id = ?`
+		if prunedOnly {
+			query += ` AND pruned = 1`
+		}
+		var n int
+		err := tx.QueryRow(rebind(query), g.month, g.endpoint, g.device).Scan(&n)
+		return n, err
+	}
+	deleteGroup := func(g monthGroup) error {
+		_, err := tx.Exec(rebind(`DELETE FROM monthly_stats WHERE month = ? AND endpoint_name = ? AND device_id = ?`), g.month, g.endpoint, g.device)
+		return err
+	}
+
+	skip := make(map[monthGroup]bool)
+	merged := make(map[monthGroup]bool)
+	for _, g := range groups {
+		n, err := countGroup(g, false)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			if strategy == MergeStrategyKeepLocal {
+				skip[g] = true
+				continue
+			}
+			if err := deleteGroup(g); err != nil {
+				return nil, err
+			}
+		}
+		for _, r := range backupGroups[g] {
+			if err := insertMonthlyRow(tx, rebind, r); err != nil {
+				return nil, err
+			}
+		}
+		merged[g] = true
+	}
+
+	for _, g := range dailyGroups {
+		if merged[g] || skip[g] {
+			continue
+		}
+		n, err := countGroup(g, true)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			continue
+		}
+		if strategy == MergeStrategyKeepLocal {
+			skip[g] = true
+		} else if err := deleteGroup(g); err != nil {
+			return nil, err
+		}
+	}
+	return skip, nil
+}
+
+// rollupMergedDailies rolls the dailies merged into pruned months into their rollups, like the
+// scheduled rollup does with dailies that arrive late
+func rollupMergedDailies(tx *sql.Tx, rebind func(string) string) error {
+	rows, err := tx.Query(`SELECT DISTINCT substr(date, 1, 7) FROM daily_stats
+		WHERE substr(date, 1, 7) IN (SELECT month FROM monthly_stats WHERE pruned = 1) ORDER BY 1`)
+	if err != nil {
+		return err
+	}
+	months, err := scanStrings(rows)
+	if err != nil {
+		return err
+	}
+	for _, month := range months {
+		if err := rollupMonth(tx, rebind, month, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dailyGroups lists the month groups of stats rows
+func dailyGroups(rows []backupStatsRow) []monthGroup {
+	seen := make(map[monthGroup]bool)
+	var groups []monthGroup
+	for _, r := range rows {
+		if len(r.Date) < 7 {
+			continue
+		}
+		g := monthGroup{r.Date[:7], r.EndpointName, r.DeviceID}
+		if !seen[g] {
+			seen[g] = true
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// prunedDeviceGroups returns the groups of a device whose dailies were rolled into pruned
+// rollups. A synced daily of such a group cannot replace the value it had when it was rolled up,
+// so applying it again would add it to the rollup a second time.
+func prunedDeviceGroups(q rowsQuerier, rebind func(string) string, deviceID string) (map[monthGroup]bool, error) {
+	rows, err := q.Query(rebind(`SELECT DISTINCT month, endpoint_name FROM monthly_stats WHERE device_id = ? AND pruned = 1`), deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	groups := make(map[monthGroup]bool)
+	for rows.Next() {
+		g := monthGroup{device: deviceID}
+		if err := rows.Scan(&g.month, &g.endpoint); err != nil {
+			return nil, err
+		}
+		groups[g] = true
+	}
+	return groups, rows.Err()
+}
+
+func scanStrings(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// sqliteRebind keeps the ? placeholders SQLite uses
+func sqliteRebind(query string) string { return query }
+
+// RollupMonthlyStats rolls the daily stats of every month before beforeMonth ("2006-01") into
+// monthly rollups by endpoint, device and model, pruning the dailies if requested. It returns the
+// months rolled up.
+func (s *SQLiteStorage) RollupMonthlyStats(beforeMonth string, prune bool) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return rollupMonthlyStats(s.db, sqliteRebind, beforeMonth, prune)
+}
+
+// GetMonthlyStats returns the rollup of a month by endpoint, device and model
+func (s *SQLiteStorage) GetMonthlyStats(month string) ([]MonthlyStat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return getMonthlyStats(s.db, sqliteRebind, month)
+}
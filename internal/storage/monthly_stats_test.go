@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRollupMonthlyStats(t *testing.T) {
+	s, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "main.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	daily := func(date string, requests int) {
+		t.Helper()
+		if err := s.RecordDailyStat(&DailyStat{EndpointName: "a", Date: date, Requests: requests, InputTokens: requests * 10, DeviceID: "dev"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	daily("2026-01-05", 4)
+	daily("2026-01-20", 6)
+	daily("2026-03-01", 1)
+	if err := s.RecordUsageStat(&UsageStat{EndpointName: "a", ClientModel: "opus", Hour: "2026-01-05 10", DeviceID: "dev", Requests: 6, InputTokens: 60}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(label string, wantOpus, wantRest int64) {
+		t.Helper()
+		stats, err := s.GetMonthlyStats("2026-01")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats) != 2 || stats[0].Model != "" || stats[0].Requests != wantRest || stats[1].Model != "opus" || stats[1].Requests != wantOpus {
+			t.Fatalf("%s: monthly stats = %+v", label, stats)
+		}
+		total, _, err := s.GetTotalStats()
+		if err != nil || int64(total) != wantOpus+wantRest+1 {
+			t.Fatalf("%s: total requests = %d, %v", label, total, err)
+		}
+	}
+	// Months that have not been rolled up are computed from the dailies
+	check("before rollup", 6, 4)
+
+	for i := 0; i < 2; i++ {
+		months, err := s.RollupMonthlyStats("2026-03", false)
+		if err != nil || len(months) != 1 || months[0] != "2026-01" {
+			t.Fatalf("rollup = %v, %v", months, err)
+		}
+		check("kept dailies", 6, 4)
+	}
+
+	if _, err := s.RollupMonthlyStats("2026-03", true); err != nil {
+		t.Fatal(err)
+	}
+	if dailies, _ := s.GetMonthlyArchiveData("2026-01"); len(dailies) != 0 {
+		t.Fatalf("dailies after pruning = %+v", dailies)
+	}
+	check("pruned", 6, 4)
+
+	// Stats arriving later for a pruned month are added to its rollup
+	daily("2026-01-31", 2)
+	if _, err := s.RollupMonthlyStats("2026-03", false); err != nil {
+		t.Fatal(err)
+	}
+	check("late dailies", 6, 6)
+
+	months, err := s.GetArchiveMonths()
+	if err != nil || len(months) != 2 || months[0] != "2026-03" || months[1] != "2026-01" {
+		t.Fatalf("archive months = %v, %v", months, err)
+	}
+
+	// Downgrading puts the pruned months back into daily_stats
+	if err := migrateSchema(s.db, sqliteMigrations, 2); err != nil {
+		t.Fatal(err)
+	}
+	var requests int
+	if err := s.db.QueryRow(`SELECT SUM(requests) FROM daily_stats WHERE date = '2026-01-01'`).Scan(&requests); err != nil || requests != 12 {
+		t.Fatalf("restored requests = %d, %v", requests, err)
+	}
+}
+
+func TestPrunedMonthsSurviveBackupAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	open := func(name string) *SQLiteStorage {
+		t.Helper()
+		s, err := NewSQLiteStorage(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+	daily := func(s *SQLiteStorage, date, device string, requests int) {
+		t.Helper()
+		if err := s.RecordDailyStat(&DailyStat{EndpointName: "a", Date: date, Requests: requests, DeviceID: device}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkTotal := func(label string, s *SQLiteStorage, want int) {
+		t.Helper()
+		total, _, err := s.GetTotalStats()
+		if err != nil || total != want {
+			t.Fatalf("%s: total requests = %d, %v, want %d", label, total, err, want)
+		}
+		stats, err := s.GetAllStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := 0
+		for _, stat := range stats["a"] {
+			sum += stat.Requests
+		}
+		if sum != want {
+			t.Fatalf("%s: daily stats sum to %d, want %d: %+v", label, sum, want, stats["a"])
+		}
+	}
+
+	s := open("main.db")
+	daily(s, "2026-01-05", "dev", 4)
+	daily(s, "2026-01-20", "dev", 6)
+	daily(s, "2026-03-01", "dev", 1)
+	fullPath := filepath.Join(dir, "full.ccnx")
+	full, err := s.CreateBackupArchive(fullPath, "v1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// January is pruned after the full backup; a late daily arrives for it afterwards
+	if _, err := s.RollupMonthlyStats("2026-03", true); err != nil {
+		t.Fatal(err)
+	}
+	daily(s, "2026-01-31", "dev2", 2)
+	checkTotal("source", s, 13)
+	deltaPath := filepath.Join(dir, "delta.ccnx")
+	if _, err := s.CreateBackupArchive(deltaPath, "v1", &BackupBase{Filename: "full.ccnx", SHA256: full.SHA256, StatsVersion: full.StatsVersion}); err != nil {
+		t.Fatal(err)
+	}
+
+	fullSnapshot := filepath.Join(dir, "full.db")
+	if _, err := ExtractBackupArchive(fullPath, fullSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := filepath.Join(dir, "snapshot.db")
+	if _, err := ExtractBackupArchive(fullPath, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	payload := filepath.Join(dir, "delta.json")
+	if _, err := ExtractBackupArchive(deltaPath, payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyBackupDelta(snapshot, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := open("restored.db")
+	for i := 0; i < 2; i++ {
+		if err := restored.MergeFromBackup(snapshot, MergeStrategyKeepLocal); err != nil {
+			t.Fatal(err)
+		}
+		checkTotal("restored", restored, 13)
+	}
+	monthly, err := restored.GetMonthlyStats("2026-01")
+	if err != nil || len(monthly) != 2 || monthly[0].DeviceID != "dev" || monthly[0].Requests != 10 || monthly[1].DeviceID != "dev2" || monthly[1].Requests != 2 {
+		t.Fatalf("restored monthly stats = %+v, %v", monthly, err)
+	}
+	daily2026, err := restored.GetDailyStats("a", "2026-01-01", "2026-01-31")
+	if err != nil || len(daily2026) != 1 || daily2026[0].Date != "2026-01-01" || daily2026[0].Requests != 12 {
+		t.Fatalf("restored January = %+v, %v", daily2026, err)
+	}
+
+	// The dailies of a month pruned on the other side are not counted on top of its rollup
+	for _, strategy := range []MergeStrategy{MergeStrategyKeepLocal, MergeStrategyOverwriteLocal} {
+		if err := restored.MergeFromBackup(fullSnapshot, strategy); err != nil {
+			t.Fatal(err)
+		}
+		checkTotal("unpruned backup, "+string(strategy), restored, 13)
+	}
+	unpruned := open("unpruned.db")
+	daily(unpruned, "2026-01-05", "dev", 4)
+	daily(unpruned, "2026-01-20", "dev", 6)
+	for _, strategy := range []MergeStrategy{MergeStrategyKeepLocal, MergeStrategyOverwriteLocal} {
+		if err := unpruned.MergeFromBackup(snapshot, strategy); err != nil {
+			t.Fatal(err)
+		}
+		checkTotal("unpruned local, "+string(strategy), unpruned, 13)
+	}
+}
+
+func TestPulledStatsAreNotCountedTwiceAfterPruning(t *testing.T) {
+	s, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "main.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.GetOrCreateDeviceID(); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := []StatsChange{
+		{EndpointName: "a", Date: "2026-01-05", Requests: 4, InputTokens: 40},
+		{EndpointName: "a", Date: "2026-01-20", Requests: 6, InputTokens: 60},
+	}
+	if err := s.ApplyStatsChanges("other", changes); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RollupMonthlyStats("2026-03", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new sync location pulls every changeset again; a group first seen after pruning still counts
+	pulled := append(changes, StatsChange{EndpointName: "b", Date: "2026-01-07", Requests: 3, InputTokens: 30})
+	for i := 0; i < 2; i++ {
+		if err := s.ApplyStatsChanges("other", pulled); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.RollupMonthlyStats("2026-03", false); err != nil {
+			t.Fatal(err)
+		}
+		devices, err := s.GetDeviceStats()
+		if err != nil || len(devices) != 1 || devices[0].Requests != 13 || devices[0].InputTokens != 130 {
+			t.Fatalf("pull %d: device stats = %+v, %v", i+1, devices, err)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// CreateBackupArchive writes a backup archive like SQLiteStorage.CreateBackupArchive. The full
+// snapshot is a SQLite file exported from PostgreSQL, so the backup restores into either storage.
+func (p *PostgresStorage) CreateBackupArchive(archivePath, appVersion string, base *BackupBase) (*BackupManifest, error) {
+	return createBackupArchive(p, archivePath, appVersion, base)
+}
+
+// CreateBackupCopy exports the endpoints, shared settings and stats into a new SQLite file
+func (p *PostgresStorage) CreateBackupCopy(backupPath string) error {
+	snapshot, _, err := p.backupDeltaSince(0)
+	if err != nil {
+		return err
+	}
+	return writeBackupSnapshot(backupPath, snapshot)
+}
+
+// backupDeltaSince reads in one snapshot transaction, so that a backup taken while the proxy
+// records stats is consistent
+func (p *PostgresStorage) backupDeltaSince(sinceVersion int64) (*backupDelta, int64, error) {
+	tx, err := p.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	endpoints, err := queryPostgresEndpoints(tx)
+	if err != nil {
+		return nil, 0, err
+	}
+	delta := &backupDelta{Endpoints: endpoints, AppConfig: make(map[string]string)}
+
+	rows, err := tx.Query(`SELECT key, COALESCE(value, '') FROM app_config WHERE key = ANY($1)`, safeConfigKeys)
+	if err != nil {
+		return nil, 0, err
+	}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		delta.AppConfig[key] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if delta.MonthlyStats, err = queryMonthlyRows(tx, "monthly_stats"); err != nil {
+		return nil, 0, err
+	}
+
+	// Like SQLiteStorage.backupDeltaSince, every daily of a pruned month is included
+	rows, err = tx.Query(`SELECT endpoint_name, date, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens, device_id, sync_version
+		FROM daily_stats WHERE sync_version > $1 OR substr(date, 1, 7) IN (SELECT month FROM monthly_stats WHERE pruned = 1)
+		ORDER BY sync_version`, sinceVersion)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	version := sinceVersion
+	for rows.Next() {
+		var r backupStatsRow
+		if err := rows.Scan(&r.EndpointName, &r.Date, &r.Requests, &r.Errors, &r.InputTokens, &r.OutputTokens, &r.CacheHits, &r.SavedTokens, &r.DeviceID, &r.syncVersion); err != nil {
+			return nil, 0, err
+		}
+		delta.DailyStats = append(delta.DailyStats, r)
+		if r.syncVersion > version {
+			version = r.syncVersion
+		}
+	}
+	return delta, version, rows.Err()
+}
+
+// DetectEndpointConflicts detects conflicts between the local endpoints and those of a SQLite backup
+func (p *PostgresStorage) DetectEndpointConflicts(remoteDBPath string) ([]MergeConflict, error) {
+	remote, err := readBackupSnapshot(remoteDBPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote database: %w", err)
+	}
+	local, err := queryPostgresEndpoints(p.db)
+	if err != nil {
+		return nil, err
+	}
+	base, hasBase, err := getPostgresEndpointSyncBase(p.db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only fields changed differently on both sides since the last sync are conflicts
+	_, conflicts, err := mergeEndpointVersions(base, local, remote.Endpoints, hasBase, nil)
+	return conflicts, err
+}
+
+// MergeFromBackup imports a SQLite backup, or the database file of a SQLite installation, like
+// SQLiteStorage.MergeFromBackup
+func (p *PostgresStorage) MergeFromBackup(backupDBPath string, strategy MergeStrategy) error {
+	backup, err := readBackupSnapshot(backupDBPath)
+	if err != nil {
+		return fmt.Errorf("failed to read backup database: %w", err)
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := mergePostgresEndpoints(tx, backup.Endpoints, strategy); err != nil {
+		return fmt.Errorf("failed to merge endpoints: %w", err)
+	}
+	if err := mergePostgresStats(tx, backup, strategy); err != nil {
+		return err
+	}
+	if err := mergePostgresAppConfig(tx, backup.AppConfig, strategy); err != nil {
+		return fmt.Errorf("failed to merge app config: %w", err)
+	}
+
+	// Endpoints of older backups have no UID; the backup is the base of the next three-way merge
+	if err := assignPostgresEndpointUIDs(tx); err != nil {
+		return err
+	}
+	if err := savePostgresEndpointSyncBase(tx, backup.Endpoints); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ThreeWayMergeFromBackup merges the endpoints of a SQLite backup field by field against the last
+// synced snapshot; see SQLiteStorage.ThreeWayMergeFromBackup
+func (p *PostgresStorage) ThreeWayMergeFromBackup(backupDBPath string, resolution *MergeResolution) error {
+	backup, err := readBackupSnapshot(backupDBPath)
+	if err != nil {
+		return fmt.Errorf("failed to read backup database: %w", err)
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Endpoints are replaced as a whole, so edits from other servers wait for the merge
+	if _, err := tx.Exec(`LOCK TABLE endpoints IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+	local, err := queryPostgresEndpoints(tx)
+	if err != nil {
+		return err
+	}
+	base, hasBase, err := getPostgresEndpointSyncBase(tx)
+	if err != nil {
+		return err
+	}
+	merged, _, err := mergeEndpointVersions(base, local, backup.Endpoints, hasBase, resolution)
+	if err != nil {
+		return err
+	}
+	if err := replacePostgresEndpoints(tx, merged); err != nil {
+		return fmt.Errorf("failed to merge endpoints: %w", err)
+	}
+	if err := assignPostgresEndpointUIDs(tx); err != nil {
+		return err
+	}
+
+	if err := mergePostgresStats(tx, backup, MergeStrategyKeepLocal); err != nil {
+		return err
+	}
+	settings := MergeStrategyKeepLocal
+	if resolution != nil && resolution.Default == MergeSideRemote {
+		settings = MergeStrategyOverwriteLocal
+	}
+	if err := mergePostgresAppConfig(tx, backup.AppConfig, settings); err != nil {
+		return fmt.Errorf("failed to merge app config: %w", err)
+	}
+
+	// The backup is now the common ancestor of both sides
+	if err := savePostgresEndpointSyncBase(tx, backup.Endpoints); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// SnapshotEndpointSyncBase records the current endpoints as the base of the next three-way
+// merge; call it after a backup has been uploaded
+func (p *PostgresStorage) SnapshotEndpointSyncBase() error {
+	endpoints, err := p.GetEndpoints()
+	if err != nil {
+		return err
+	}
+	return savePostgresEndpointSyncBase(p.db, endpoints)
+}
+
+// postgresOnConflict returns the conflict clause of a merge strategy: keep the local row, or
+// overwrite it with the given assignments
+func postgresOnConflict(strategy MergeStrategy, target, update string) (string, error) {
+	switch strategy {
+	case MergeStrategyKeepLocal:
+		return "ON CONFLICT (" + target + ") DO NOTHING", nil
+	case MergeStrategyOverwriteLocal:
+		return "ON CONFLICT (" + target + ") DO UPDATE SET " + update, nil
+	default:
+		return "", fmt.Errorf("unknown merge strategy: %s", strategy)
+	}
+}
+
+func mergePostgresEndpoints(tx *sql.Tx, endpoints []Endpoint, strategy MergeStrategy) error {
+	onConflict, err := postgresOnConflict(strategy, "name", `api_url=excluded.api_url, api_key=excluded.api_key, enabled=excluded.enabled,
+		transformer=excluded.transformer, model=excluded.model, remark=excluded.remark, sort_order=excluded.sort_order,
+		azure_resource=excluded.azure_resource, azure_deployment=excluded.azure_deployment, azure_api_version=excluded.azure_api_version,
+		rewrite_rules=excluded.rewrite_rules, capabilities=excluded.capabilities, uid=excluded.uid, updated_at=CURRENT_TIMESTAMP`)
+	if err != nil {
+		return err
+	}
+	for _, ep := range endpoints {
+		_, err := tx.Exec(`INSERT INTO endpoints (name, api_url, api_key, enabled, transformer, model, remark, sort_order, azure_resource, azure_deployment, azure_api_version, rewrite_rules, capabilities, uid)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) `+onConflict,
+			ep.Name, ep.APIUrl, ep.APIKey, ep.Enabled, ep.Transformer, ep.Model, ep.Remark, ep.SortOrder, ep.AzureResource, ep.AzureDeployment, ep.AzureAPIVersion, ep.RewriteRules, ep.Capabilities, ep.UID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergePostgresStats merges the rollups of the months the backup pruned (see mergePrunedMonths)
+// and its daily stats
+func mergePostgresStats(tx *sql.Tx, backup *backupDelta, strategy MergeStrategy) error {
+	skip, err := mergePrunedMonths(tx, postgresRebind, backup.MonthlyStats, dailyGroups(backup.DailyStats), strategy)
+	if err != nil {
+		return fmt.Errorf("failed to merge monthly stats: %w", err)
+	}
+	rows := make([]backupStatsRow, 0, len(backup.DailyStats))
+	for _, r := range backup.DailyStats {
+		if len(r.Date) < 7 || !skip[monthGroup{r.Date[:7], r.EndpointName, r.DeviceID}] {
+			rows = append(rows, r)
+		}
+	}
+	if err := mergePostgresDailyStats(tx, rows, strategy); err != nil {
+		return fmt.Errorf("failed to merge daily stats: %w", err)
+	}
+	if err := rollupMergedDailies(tx, postgresRebind); err != nil {
+		return fmt.Errorf("failed to merge daily stats: %w", err)
+	}
+	return nil
+}
+
+// mergePostgresDailyStats keeps the device ID of every row, so importing the same backup twice
+// does not count twice. Imported rows get new sync versions, like restores into SQLite.
+func mergePostgresDailyStats(tx *sql.Tx, rows []backupStatsRow, strategy MergeStrategy) error {
+	onConflict, err := postgresOnConflict(strategy, "endpoint_name, date, device_id", `requests=excluded.requests, errors=excluded.errors,
+		input_tokens=excluded.input_tokens, output_tokens=excluded.output_tokens, cache_hits=excluded.cache_hits,
+		saved_tokens=excluded.saved_tokens, sync_version=excluded.sync_version`)
+	if err != nil {
+		return err
+	}
+	if err := lockPostgresSyncVersions(tx); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO daily_stats (endpoint_name, date, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens, device_id, sync_version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, nextval('daily_stats_sync_version_seq')) ` + onConflict)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range rows {
+		if _, err := stmt.Exec(r.EndpointName, r.Date, r.Requests, r.Errors, r.InputTokens, r.OutputTokens, r.CacheHits, r.SavedTokens, r.DeviceID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergePostgresAppConfig merges the shared settings of a backup; device specific ones never leave it
+func mergePostgresAppConfig(tx *sql.Tx, appConfig map[string]string, strategy MergeStrategy) error {
+	onConflict, err := postgresOnConflict(strategy, "key", `value=excluded.value, updated_at=CURRENT_TIMESTAMP`)
+	if err != nil {
+		return err
+	}
+	for _, key := range safeConfigKeys {
+		value, ok := appConfig[key]
+		if !ok {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO app_config (key, value) VALUES ($1, $2) `+onConflict, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replacePostgresEndpoints stores the merged endpoints, keeping the row IDs of local endpoints
+func replacePostgresEndpoints(tx *sql.Tx, endpoints []Endpoint) error {
+	if _, err := tx.Exec(`DELETE FROM endpoints`); err != nil {
+		return err
+	}
+	for _, ep := range endpoints {
+		args := []interface{}{ep.Name, ep.APIUrl, ep.APIKey, ep.Enabled, ep.Transformer, ep.Model, ep.Remark, ep.SortOrder, ep.AzureResource, ep.AzureDeployment, ep.AzureAPIVersion, ep.RewriteRules, ep.Capabilities, ep.UID}
+		query := `INSERT INTO endpoints (name, api_url, api_key, enabled, transformer, model, remark, sort_order, azure_resource, azure_deployment, azure_api_version, rewrite_rules, capabilities, uid)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`
+		if ep.ID > 0 {
+			args = append(args, ep.ID)
+			query = `INSERT INTO endpoints (name, api_url, api_key, enabled, transformer, model, remark, sort_order, azure_resource, azure_deployment, azure_api_version, rewrite_rules, capabilities, uid, id)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+		}
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	// Explicit IDs do not advance the sequence
+	_, err := tx.Exec(`SELECT setval(pg_get_serial_sequence('endpoints', 'id'), GREATEST((SELECT COALESCE(MAX(id), 0) FROM endpoints), 1))`)
+	return err
+}
+
+// assignPostgresEndpointUIDs gives endpoints without a UID a random one
+func assignPostgresEndpointUIDs(tx *sql.Tx) error {
+	_, err := tx.Exec(`UPDATE endpoints SET uid = md5(random()::text || clock_timestamp()::text) WHERE uid IS NULL OR uid = ''`)
+	return err
+}
+
+// getPostgresEndpointSyncBase returns the endpoints of the last backup or restore
+func getPostgresEndpointSyncBase(q rowQuerier) ([]Endpoint, bool, error) {
+	var data sql.NullString
+	err := q.QueryRow(`SELECT value FROM app_config WHERE key = $1`, endpointSyncBaseKey).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	base, ok := decodeEndpointSyncBase(data.String)
+	return base, ok, nil
+}
+
+func savePostgresEndpointSyncBase(e execer, endpoints []Endpoint) error {
+	data, err := encodeEndpointSyncBase(endpoints)
+	if err != nil {
+		return err
+	}
+	_, err = e.Exec(`INSERT INTO app_config (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET value=excluded.value, updated_at=CURRENT_TIMESTAMP`, endpointSyncBaseKey, data)
+	return err
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// postgresMigrations lists the PostgreSQL migrations. They carry the same versions as
+// schemaMigrations, so both engines report the same schema for the same release.
+var postgresMigrations = migrationSet{
+	migrations: []schemaMigration{
+		{Version: 1, Name: "baseline", Up: migratePostgresBaseline, Down: dropPostgresBaseline},
+		{Version: 2, Name: "usage_stats", Up: migratePostgresUsageStats, Down: dropUsageStats},
+		{Version: 3, Name: "monthly_stats", Up: migratePostgresMonthlyStats, Down: dropMonthlyStats},
+	},
+	createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`,
+	record: `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`,
+	forget: `DELETE FROM schema_migrations WHERE version = $1`,
+	version: func(q rowQuerier) (int, error) {
+		var version int
+		err := q.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+		return version, err
+	},
+}
+
+// postgresBaselineSchema matches the SQLite baseline. Timestamps of cache, batch and response rows
+// are unix seconds as in SQLite; stats sync versions come from a sequence, so that several servers
+// sharing the database never hand out the same version.
+const postgresBaselineSchema = `
+	CREATE TABLE IF NOT EXISTS endpoints (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT UNIQUE NOT NULL,
+		api_url TEXT NOT NULL,
+		api_key TEXT NOT NULL,
+		enabled BOOLEAN DEFAULT TRUE,
+		transformer TEXT DEFAULT 'claude',
+		model TEXT DEFAULT '',
+		remark TEXT DEFAULT '',
+		sort_order INTEGER DEFAULT 0,
+		azure_resource TEXT DEFAULT '',
+		azure_deployment TEXT DEFAULT '',
+		azure_api_version TEXT DEFAULT '',
+		rewrite_rules TEXT DEFAULT '',
+		capabilities TEXT DEFAULT '',
+		uid TEXT DEFAULT '',
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE SEQUENCE IF NOT EXISTS daily_stats_sync_version_seq;
+
+	CREATE TABLE IF NOT EXISTS daily_stats (
+		id BIGSERIAL PRIMARY KEY,
+		endpoint_name TEXT NOT NULL,
+		date TEXT NOT NULL,
+		requests BIGINT DEFAULT 0,
+		errors BIGINT DEFAULT 0,
+		input_tokens BIGINT DEFAULT 0,
+		output_tokens BIGINT DEFAULT 0,
+		cache_hits BIGINT DEFAULT 0,
+		saved_tokens BIGINT DEFAULT 0,
+		device_id TEXT DEFAULT 'default',
+		sync_version BIGINT DEFAULT 0,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(endpoint_name, date, device_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS app_config (
+		key TEXT PRIMARY KEY,
+		value TEXT,
+		updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_daily_stats_date ON daily_stats(date);
+	CREATE INDEX IF NOT EXISTS idx_daily_stats_endpoint ON daily_stats(endpoint_name);
+	CREATE INDEX IF NOT EXISTS idx_daily_stats_device ON daily_stats(device_id);
+	CREATE INDEX IF NOT EXISTS idx_daily_stats_sync ON daily_stats(device_id, sync_version);
+
+	CREATE TABLE IF NOT EXISTS response_cache (
+		key TEXT PRIMARY KEY,
+		endpoint_name TEXT NOT NULL,
+		model TEXT DEFAULT '',
+		status INTEGER NOT NULL,
+		headers TEXT DEFAULT '',
+		body BYTEA,
+		chunks TEXT DEFAULT '',
+		input_tokens BIGINT DEFAULT 0,
+		output_tokens BIGINT DEFAULT 0,
+		size BIGINT DEFAULT 0,
+		hits BIGINT DEFAULT 0,
+		created_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_response_cache_expires ON response_cache(expires_at);
+
+	CREATE TABLE IF NOT EXISTS message_batches (
+		id TEXT PRIMARY KEY,
+		endpoint_name TEXT NOT NULL,
+		mode TEXT NOT NULL,
+		processing_status TEXT NOT NULL,
+		headers TEXT DEFAULT '',
+		upstream TEXT DEFAULT '',
+		created_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		ended_at BIGINT DEFAULT 0,
+		cancel_initiated_at BIGINT DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS message_batch_items (
+		batch_id TEXT NOT NULL,
+		idx INTEGER NOT NULL,
+		custom_id TEXT NOT NULL,
+		params TEXT NOT NULL,
+		status TEXT NOT NULL,
+		result TEXT DEFAULT '',
+		PRIMARY KEY (batch_id, idx)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_message_batch_items_status ON message_batch_items(status);
+
+	CREATE TABLE IF NOT EXISTS stored_responses (
+		id TEXT PRIMARY KEY,
+		previous_id TEXT DEFAULT '',
+		endpoint_name TEXT NOT NULL,
+		model TEXT DEFAULT '',
+		input TEXT NOT NULL,
+		output TEXT NOT NULL,
+		response TEXT NOT NULL,
+		created_at BIGINT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_stored_responses_created ON stored_responses(created_at);
+`
+
+func migratePostgresBaseline(tx *sql.Tx) error {
+	_, err := tx.Exec(postgresBaselineSchema)
+	return err
+}
+
+func dropPostgresBaseline(tx *sql.Tx) error {
+	for _, table := range baselineTables {
+		if _, err := tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s`, table)); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`DROP SEQUENCE IF EXISTS daily_stats_sync_version_seq`)
+	return err
+}
+
+const postgresUsageStatsSchema = `
+	CREATE TABLE IF NOT EXISTS usage_stats (
+		granularity TEXT NOT NULL,
+		bucket TEXT NOT NULL,
+		endpoint_name TEXT NOT NULL,
+		client_model TEXT NOT NULL DEFAULT '',
+		upstream_model TEXT NOT NULL DEFAULT '',
+		client_format TEXT NOT NULL DEFAULT '',
+		device_id TEXT NOT NULL DEFAULT 'default',
+		requests BIGINT DEFAULT 0,
+		errors BIGINT DEFAULT 0,
+		input_tokens BIGINT DEFAULT 0,
+		output_tokens BIGINT DEFAULT 0,
+		cache_hits BIGINT DEFAULT 0,
+		saved_tokens BIGINT DEFAULT 0,
+		UNIQUE(granularity, bucket, endpoint_name, client_model, upstream_model, client_format, device_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_usage_stats_bucket ON usage_stats(granularity, bucket);
+`
+
+func migratePostgresUsageStats(tx *sql.Tx) error {
+	_, err := tx.Exec(postgresUsageStatsSchema)
+	return err
+}
+
+const postgresMonthlyStatsSchema = `
+	CREATE TABLE IF NOT EXISTS monthly_stats (
+		month TEXT NOT NULL,
+		endpoint_name TEXT NOT NULL,
+		device_id TEXT NOT NULL DEFAULT 'default',
+		model TEXT NOT NULL DEFAULT '',
+		requests BIGINT DEFAULT 0,
+		errors BIGINT DEFAULT 0,
+		input_tokens BIGINT DEFAULT 0,
+		output_tokens BIGINT DEFAULT 0,
+		cache_hits BIGINT DEFAULT 0,
+		saved_tokens BIGINT DEFAULT 0,
+		pruned INTEGER NOT NULL DEFAULT 0,
+		UNIQUE(month, endpoint_name, device_id, model)
+	);
+`
+
+func migratePostgresMonthlyStats(tx *sql.Tx) error {
+	_, err := tx.Exec(postgresMonthlyStatsSchema)
+	return err
+}
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// openTestPostgres connects to the database in CCNEXUS_TEST_POSTGRES_URL, starting from an empty
+// schema. The test is skipped when the variable is not set.
+func openTestPostgres(t *testing.T) *PostgresStorage {
+	t.Helper()
+	dbURL := os.Getenv("CCNEXUS_TEST_POSTGRES_URL")
+	if dbURL == "" {
+		t.Skip("CCNEXUS_TEST_POSTGRES_URL not set")
+	}
+	p, err := NewPostgresStorage(dbURL)
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+	if err := migrateSchema(p.db, postgresMigrations, 0); err != nil {
+		t.Fatalf("reset schema: %v", err)
+	}
+	if err := migrateSchema(p.db, postgresMigrations, SchemaVersion); err != nil {
+		t.Fatalf("migrate schema: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestBackupSnapshotRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSQLiteStorage(filepath.Join(dir, "main.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.SaveEndpoint(&Endpoint{Name: "a", APIUrl: "https://a", APIKey: "k", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordDailyStat(&DailyStat{EndpointName: "a", Date: "2026-03-01", Requests: 2, DeviceID: "dev"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetConfig("port", "3000"); err != nil {
+		t.Fatal(err)
+	}
+	copyPath := filepath.Join(dir, "copy.db")
+	if err := s.CreateBackupCopy(copyPath); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := readBackupSnapshot(copyPath)
+	if err != nil {
+		t.Fatalf("read snapshot: %v", err)
+	}
+	if len(snapshot.Endpoints) != 1 || len(snapshot.DailyStats) != 1 || snapshot.AppConfig["port"] != "3000" {
+		t.Fatalf("unexpected snapshot: %+v", snapshot)
+	}
+
+	written := filepath.Join(dir, "written.db")
+	if err := writeBackupSnapshot(written, snapshot); err != nil {
+		t.Fatalf("write snapshot: %v", err)
+	}
+	again, err := readBackupSnapshot(written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Endpoints) != 1 || again.Endpoints[0].UID != snapshot.Endpoints[0].UID ||
+		len(again.DailyStats) != 1 || again.DailyStats[0].Requests != 2 || again.DailyStats[0].DeviceID != "dev" {
+		t.Fatalf("snapshot changed on round trip: %+v", again)
+	}
+
+	if _, err := readBackupSnapshot(filepath.Join(dir, "missing.db")); err == nil {
+		t.Fatal("reading a missing backup succeeded")
+	}
+}
+
+func TestPostgresStorage(t *testing.T) {
+	p := openTestPostgres(t)
+
+	if err := p.SaveEndpoint(&Endpoint{Name: "a", APIUrl: "https://a", APIKey: "k", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := p.RecordDailyStat(&DailyStat{EndpointName: "a", Date: "2026-03-01", Requests: 1, InputTokens: 10, DeviceID: "dev"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	total, stats, err := p.GetTotalStats()
+	if err != nil || total != 2 || stats["a"].InputTokens != 20 {
+		t.Fatalf("total stats = %d, %+v, %v", total, stats["a"], err)
+	}
+	changes, version, err := p.GetStatsChanges("dev", 0)
+	if err != nil || len(changes) != 1 || version == 0 {
+		t.Fatalf("stats changes = %+v, %d, %v", changes, version, err)
+	}
+
+	id, err := p.GetOrCreateDeviceID()
+	if err != nil || id == "" {
+		t.Fatalf("device id = %q, %v", id, err)
+	}
+	if again, _ := p.GetOrCreateDeviceID(); again != id {
+		t.Fatalf("device id changed: %q != %q", again, id)
+	}
+
+	// Import a SQLite database the way CCNEXUS_IMPORT_SQLITE does
+	dir := t.TempDir()
+	s, err := NewSQLiteStorage(filepath.Join(dir, "main.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.SaveEndpoint(&Endpoint{Name: "a", APIUrl: "https://other", APIKey: "k", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveEndpoint(&Endpoint{Name: "b", APIUrl: "https://b", APIKey: "k", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordDailyStat(&DailyStat{EndpointName: "b", Date: "2026-03-01", Requests: 3, DeviceID: "laptop"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.MergeFromBackup(s.dbPath, MergeStrategyKeepLocal); err != nil {
+		t.Fatalf("merge sqlite: %v", err)
+	}
+	endpoints, err := p.GetEndpoints()
+	if err != nil || len(endpoints) != 2 || endpoints[0].APIUrl != "https://a" {
+		t.Fatalf("endpoints after merge = %+v, %v", endpoints, err)
+	}
+	if devices, err := p.GetDeviceStats(); err != nil || len(devices) != 2 {
+		t.Fatalf("device stats after merge = %+v, %v", devices, err)
+	}
+
+	// Backups of a Postgres storage are SQLite files
+	backup := filepath.Join(dir, "backup.db")
+	if err := p.CreateBackupCopy(backup); err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	restored, err := NewSQLiteStorage(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if endpoints, err := restored.GetEndpoints(); err != nil || len(endpoints) != 2 {
+		t.Fatalf("backup endpoints = %+v, %v", endpoints, err)
+	}
+}
+
+func TestPostgresStatsChangesNeverSkipAVersion(t *testing.T) {
+	p := openTestPostgres(t)
+
+	// Each writer records one row of its own endpoint while stats sync reads incrementally
+	const writers = 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := p.RecordDailyStat(&DailyStat{EndpointName: fmt.Sprintf("ep%d", i), Date: "2026-03-01", Requests: 1, DeviceID: "dev"}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+
+	seen := make(map[string]bool)
+	var version int64
+	written := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(written)
+	}()
+	for done := false; !done; {
+		select {
+		case <-written:
+			done = true
+		default:
+		}
+		changes, next, err := p.GetStatsChanges("dev", version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range changes {
+			seen[c.EndpointName] = true
+		}
+		version = next
+	}
+	if len(seen) != writers {
+		t.Fatalf("incremental reads saw %d of %d rows", len(seen), writers)
+	}
+}
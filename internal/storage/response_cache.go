@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CachedResponse is a response stored in the response cache
+type CachedResponse struct {
+	Key          string
+	EndpointName string
+	Model        string
+	Status       int
+	Headers      string // JSON object of header values
+	Body         []byte
+	Chunks       string // JSON array of [offsetMs, length] pairs for streamed bodies, empty otherwise
+	InputTokens  int
+	OutputTokens int
+	Size         int64
+	Hits         int
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+// GetCachedResponse returns an unexpired cached response and counts the hit, or nil when there is none
+func (s *SQLiteStorage) GetCachedResponse(key string) (*CachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entry CachedResponse
+	var createdAt, expiresAt int64
+	err := s.db.QueryRow(`SELECT key, endpoint_name, model, status, headers, body, chunks, input_tokens, output_tokens, size, hits, created_at, expires_at
+		FROM response_cache WHERE key=? AND expires_at>?`, key, time.Now().Unix()).Scan(
+		&entry.Key, &entry.EndpointName, &entry.Model, &entry.Status, &entry.Headers, &entry.Body, &entry.Chunks,
+		&entry.InputTokens, &entry.OutputTokens, &entry.Size, &entry.Hits, &createdAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry.CreatedAt = time.Unix(createdAt, 0)
+	entry.ExpiresAt = time.Unix(expiresAt, 0)
+
+	if _, err := s.db.Exec(`UPDATE response_cache SET hits = hits + 1 WHERE key=?`, key); err != nil {
+		return nil, err
+	}
+	entry.Hits++
+	return &entry, nil
+}
+
+// PutCachedResponse stores a response, then evicts expired entries and the oldest ones
+// until the cache is within maxEntries and maxBytes
+func (s *SQLiteStorage) PutCachedResponse(entry *CachedResponse, maxEntries int, maxBytes int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.Size = int64(len(entry.Body))
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO response_cache
+		(key, endpoint_name, model, status, headers, body, chunks, input_tokens, output_tokens, size, hits, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?)`,
+		entry.Key, entry.EndpointName, entry.Model, entry.Status, entry.Headers, entry.Body, entry.Chunks,
+		entry.InputTokens, entry.OutputTokens, entry.Size, entry.CreatedAt.Unix(), entry.ExpiresAt.Unix())
+	if err != nil {
+		return err
+	}
+
+	return s.pruneResponseCache(maxEntries, maxBytes)
+}
+
+// pruneResponseCache evicts expired entries, then the oldest entries beyond the limits.
+// Callers must hold s.mu.
+func (s *SQLiteStorage) pruneResponseCache(maxEntries int, maxBytes int64) error {
+	if _, err := s.db.Exec(`DELETE FROM response_cache WHERE expires_at<=?`, time.Now().Unix()); err != nil {
+		return err
+	}
+	if maxEntries > 0 {
+		if _, err := s.db.Exec(`DELETE FROM response_cache WHERE key IN (
+			SELECT key FROM response_cache ORDER BY created_at DESC, rowid DESC LIMIT -1 OFFSET ?)`, maxEntries); err != nil {
+			return err
+		}
+	}
+	if maxBytes > 0 {
+		if _, err := s.db.Exec(`DELETE FROM response_cache WHERE key IN (
+			SELECT key FROM (SELECT key, SUM(size) OVER (ORDER BY created_at DESC, rowid DESC) AS total FROM response_cache)
+			WHERE total>?)`, maxBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClearResponseCache removes every cached response
+func (s *SQLiteStorage) ClearResponseCache() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec(`DELETE FROM response_cache`)
+	return err
+}
+
+// GetResponseCacheInfo returns the number of unexpired cached responses, their total size and hits
+func (s *SQLiteStorage) GetResponseCacheInfo() (int, int64, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries, hits int
+	var size int64
+	err := s.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(size), 0), COALESCE(SUM(hits), 0)
+		FROM response_cache WHERE expires_at>?`, time.Now().Unix()).Scan(&entries, &size, &hits)
+	return entries, size, hits, err
+}
@@ -7,6 +7,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/lich0821/ccNexus/internal/config"
+
 	_ "modernc.org/sqlite"
 )
 
@@ -16,7 +18,7 @@ import (
 // 是设备/平台特定的，不应在不同设备间同步。
 var safeConfigKeys = []string{
 	// 应用设置
-	"port", "logLevel", "language",
+	"port", "logLevel", "language", "statsHourlyDays",
 	// 主题设置
 	"theme", "themeAuto", "autoLightTheme", "autoDarkTheme",
 	// 窗口关闭行为
@@ -29,6 +31,9 @@ var safeConfigKeys = []string{
 	"backup_s3_endpoint", "backup_s3_region", "backup_s3_bucket", "backup_s3_prefix",
 	"backup_s3_accessKey", "backup_s3_secretKey", "backup_s3_sessionToken",
 	"backup_s3_useSSL", "backup_s3_forcePathStyle",
+	// SFTP 设置（服务器和凭证是通用的）
+	"backup_sftp_host", "backup_sftp_port", "backup_sftp_username", "backup_sftp_password",
+	"backup_sftp_privateKey", "backup_sftp_passphrase", "backup_sftp_dir", "backup_sftp_hostKey",
 	// 更新设置
 	"update_autoCheck", "update_checkInterval",
 }
@@ -64,88 +69,88 @@ func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
 	return s, nil
 }
 
+// initSchema brings the database to the newest schema (see migrations.go)
 func (s *SQLiteStorage) initSchema() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS endpoints (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT UNIQUE NOT NULL,
-		api_url TEXT NOT NULL,
-		api_key TEXT NOT NULL,
-		enabled BOOLEAN DEFAULT TRUE,
-		transformer TEXT DEFAULT 'claude',
-		model TEXT,
-		remark TEXT,
-		sort_order INTEGER DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS daily_stats (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		endpoint_name TEXT NOT NULL,
-		date TEXT NOT NULL,
-		requests INTEGER DEFAULT 0,
-		errors INTEGER DEFAULT 0,
-		input_tokens INTEGER DEFAULT 0,
-		output_tokens INTEGER DEFAULT 0,
-		device_id TEXT DEFAULT 'default',
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		UNIQUE(endpoint_name, date, device_id)
-	);
-
-	CREATE TABLE IF NOT EXISTS app_config (
-		key TEXT PRIMARY KEY,
-		value TEXT,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_daily_stats_date ON daily_stats(date);
-	CREATE INDEX IF NOT EXISTS idx_daily_stats_endpoint ON daily_stats(endpoint_name);
-	CREATE INDEX IF NOT EXISTS idx_daily_stats_device ON daily_stats(device_id);
-	`
-
-	if _, err := s.db.Exec(schema); err != nil {
-		return err
-	}
+	return migrateSchema(s.db, sqliteMigrations, SchemaVersion)
+}
 
-	// Migration: Add sort_order column if it doesn't exist
-	if err := s.migrateSortOrder(); err != nil {
-		return err
-	}
+// endpointTextColumns lists the optional TEXT columns added to the endpoints table after its first release
+var endpointTextColumns = []string{"azure_resource", "azure_deployment", "azure_api_version", "rewrite_rules", "capabilities", "uid"}
 
-	return nil
+// rowQuerier is implemented by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// rowsQuerier is implemented by both *sql.DB and *sql.Tx
+type rowsQuerier interface {
+	rowQuerier
+	Query(query string, args ...interface{}) (*sql.Rows, error)
 }
 
-// migrateSortOrder adds the sort_order column to existing databases
-func (s *SQLiteStorage) migrateSortOrder() error {
-	// Check if sort_order column exists
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// hasColumn reports whether a table in the given schema (main or attached) has the column
+func hasColumn(q rowQuerier, dbName, table, column string) (bool, error) {
 	var count int
-	err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('endpoints') WHERE name='sort_order'`).Scan(&count)
-	if err != nil {
-		return err
-	}
+	err := q.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?, ?) WHERE name=?`, table, dbName, column).Scan(&count)
+	return count > 0, err
+}
 
-	// If column doesn't exist, add it and set default values
-	if count == 0 {
-		// Add the column
-		if _, err := s.db.Exec(`ALTER TABLE endpoints ADD COLUMN sort_order INTEGER DEFAULT 0`); err != nil {
-			return err
-		}
+// hasTable reports whether the given schema (main or attached) has the table
+func hasTable(q rowQuerier, dbName, table string) (bool, error) {
+	var count int
+	err := q.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s.sqlite_master WHERE type='table' AND name=?`, dbName), table).Scan(&count)
+	return count > 0, err
+}
 
-		// Set sort_order for existing endpoints based on their current ID order
-		if _, err := s.db.Exec(`UPDATE endpoints SET sort_order = id WHERE sort_order = 0`); err != nil {
-			return err
+// endpointTextColumnsSelect returns the select list for optional endpoint columns of a (possibly older)
+// database, substituting empty strings for columns the database does not have yet
+func endpointTextColumnsSelect(q rowQuerier, dbName string) (string, error) {
+	exprs := make([]string, 0, len(endpointTextColumns))
+	for _, column := range endpointTextColumns {
+		exists, err := hasColumn(q, dbName, "endpoints", column)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			exprs = append(exprs, fmt.Sprintf("COALESCE(%s, '')", column))
+		} else {
+			exprs = append(exprs, "''")
 		}
 	}
+	return strings.Join(exprs, ", "), nil
+}
 
-	return nil
+// dailyStatsCacheColumns lists the cache statistics columns added to the daily_stats table after its first release
+var dailyStatsCacheColumns = []string{"cache_hits", "saved_tokens"}
+
+// dailyStatsCacheSelect returns the summed cache statistics columns of a (possibly older) database,
+// substituting zeros for columns the database does not have yet
+func dailyStatsCacheSelect(q rowQuerier, dbName string) (string, error) {
+	exprs := make([]string, 0, len(dailyStatsCacheColumns))
+	for _, column := range dailyStatsCacheColumns {
+		exists, err := hasColumn(q, dbName, "daily_stats", column)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			exprs = append(exprs, fmt.Sprintf("SUM(%s)", column))
+		} else {
+			exprs = append(exprs, "0")
+		}
+	}
+	return strings.Join(exprs, ", "), nil
 }
 
 func (s *SQLiteStorage) GetEndpoints() ([]Endpoint, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	rows, err := s.db.Query(`SELECT id, name, api_url, api_key, enabled, transformer, model, remark, sort_order, created_at, updated_at FROM endpoints ORDER BY sort_order ASC`)
+	rows, err := s.db.Query(`SELECT id, name, api_url, api_key, enabled, transformer, model, remark, sort_order, COALESCE(azure_resource, ''), COALESCE(azure_deployment, ''), COALESCE(azure_api_version, ''), COALESCE(rewrite_rules, ''), COALESCE(capabilities, ''), COALESCE(uid, ''), created_at, updated_at FROM endpoints ORDER BY sort_order ASC`)
 	if err != nil {
 		return nil, err
 	}
@@ -154,7 +159,7 @@ func (s *SQLiteStorage) GetEndpoints() ([]Endpoint, error) {
 	var endpoints []Endpoint
 	for rows.Next() {
 		var ep Endpoint
-		if err := rows.Scan(&ep.ID, &ep.Name, &ep.APIUrl, &ep.APIKey, &ep.Enabled, &ep.Transformer, &ep.Model, &ep.Remark, &ep.SortOrder, &ep.CreatedAt, &ep.UpdatedAt); err != nil {
+		if err := rows.Scan(&ep.ID, &ep.Name, &ep.APIUrl, &ep.APIKey, &ep.Enabled, &ep.Transformer, &ep.Model, &ep.Remark, &ep.SortOrder, &ep.AzureResource, &ep.AzureDeployment, &ep.AzureAPIVersion, &ep.RewriteRules, &ep.Capabilities, &ep.UID, &ep.CreatedAt, &ep.UpdatedAt); err != nil {
 			return nil, err
 		}
 		endpoints = append(endpoints, ep)
@@ -167,8 +172,11 @@ func (s *SQLiteStorage) SaveEndpoint(ep *Endpoint) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	result, err := s.db.Exec(`INSERT INTO endpoints (name, api_url, api_key, enabled, transformer, model, remark, sort_order) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		ep.Name, ep.APIUrl, ep.APIKey, ep.Enabled, ep.Transformer, ep.Model, ep.Remark, ep.SortOrder)
+	if ep.UID == "" {
+		ep.UID = config.NewEndpointUID()
+	}
+	result, err := s.db.Exec(`INSERT INTO endpoints (name, api_url, api_key, enabled, transformer, model, remark, sort_order, azure_resource, azure_deployment, azure_api_version, rewrite_rules, capabilities, uid) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ep.Name, ep.APIUrl, ep.APIKey, ep.Enabled, ep.Transformer, ep.Model, ep.Remark, ep.SortOrder, ep.AzureResource, ep.AzureDeployment, ep.AzureAPIVersion, ep.RewriteRules, ep.Capabilities, ep.UID)
 	if err != nil {
 		return err
 	}
@@ -185,8 +193,14 @@ func (s *SQLiteStorage) UpdateEndpoint(ep *Endpoint) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, err := s.db.Exec(`UPDATE endpoints SET api_url=?, api_key=?, enabled=?, transformer=?, model=?, remark=?, sort_order=?, updated_at=CURRENT_TIMESTAMP WHERE name=?`,
-		ep.APIUrl, ep.APIKey, ep.Enabled, ep.Transformer, ep.Model, ep.Remark, ep.SortOrder, ep.Name)
+	// With a UID the endpoint may also be renamed; without one it is found by name
+	if ep.UID != "" {
+		_, err := s.db.Exec(`UPDATE endpoints SET name=?, api_url=?, api_key=?, enabled=?, transformer=?, model=?, remark=?, sort_order=?, azure_resource=?, azure_deployment=?, azure_api_version=?, rewrite_rules=?, capabilities=?, updated_at=CURRENT_TIMESTAMP WHERE uid=?`,
+			ep.Name, ep.APIUrl, ep.APIKey, ep.Enabled, ep.Transformer, ep.Model, ep.Remark, ep.SortOrder, ep.AzureResource, ep.AzureDeployment, ep.AzureAPIVersion, ep.RewriteRules, ep.Capabilities, ep.UID)
+		return err
+	}
+	_, err := s.db.Exec(`UPDATE endpoints SET api_url=?, api_key=?, enabled=?, transformer=?, model=?, remark=?, sort_order=?, azure_resource=?, azure_deployment=?, azure_api_version=?, rewrite_rules=?, capabilities=?, updated_at=CURRENT_TIMESTAMP WHERE name=?`,
+		ep.APIUrl, ep.APIKey, ep.Enabled, ep.Transformer, ep.Model, ep.Remark, ep.SortOrder, ep.AzureResource, ep.AzureDeployment, ep.AzureAPIVersion, ep.RewriteRules, ep.Capabilities, ep.Name)
 	return err
 }
 
@@ -202,25 +216,36 @@ func (s *SQLiteStorage) RecordDailyStat(stat *DailyStat) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, err := s.db.Exec(`
-		INSERT INTO daily_stats (endpoint_name, date, requests, errors, input_tokens, output_tokens, device_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+	return recordSQLiteDailyStat(s.db, stat)
+}
+
+func recordSQLiteDailyStat(exec execer, stat *DailyStat) error {
+	// Every change gets a new sync version so that stats sync can push only changed rows
+	_, err := exec.Exec(`
+		INSERT INTO daily_stats (endpoint_name, date, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens, device_id, sync_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(sync_version), 0) + 1 FROM daily_stats))
 		ON CONFLICT(endpoint_name, date, device_id) DO UPDATE SET
 			requests = requests + excluded.requests,
 			errors = errors + excluded.errors,
 			input_tokens = input_tokens + excluded.input_tokens,
-			output_tokens = output_tokens + excluded.output_tokens
-	`, stat.EndpointName, stat.Date, stat.Requests, stat.Errors, stat.InputTokens, stat.OutputTokens, stat.DeviceID)
+			output_tokens = output_tokens + excluded.output_tokens,
+			cache_hits = cache_hits + excluded.cache_hits,
+			saved_tokens = saved_tokens + excluded.saved_tokens,
+			sync_version = excluded.sync_version
+	`, stat.EndpointName, stat.Date, stat.Requests, stat.Errors, stat.InputTokens, stat.OutputTokens, stat.CacheHits, stat.SavedTokens, stat.DeviceID)
 
 	return err
 }
 
+// GetDailyStats returns the stats of an endpoint per date, summed over devices. Months whose dailies
+// were pruned appear as their rollup on the first day of the month; summed rows have no ID or
+// creation time.
 func (s *SQLiteStorage) GetDailyStats(endpointName, startDate, endDate string) ([]DailyStat, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	query := `SELECT id, endpoint_name, date, SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens), device_id, created_at
-		FROM daily_stats WHERE endpoint_name=? AND date>=? AND date<=? GROUP BY date ORDER BY date DESC`
+	query := `SELECT endpoint_name, date, SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens), SUM(cache_hits), SUM(saved_tokens), MIN(device_id)
+		FROM ` + allStatsSource + ` WHERE endpoint_name=? AND date>=? AND date<=? GROUP BY endpoint_name, date ORDER BY date DESC`
 
 	rows, err := s.db.Query(query, endpointName, startDate, endDate)
 	if err != nil {
@@ -231,7 +256,7 @@ func (s *SQLiteStorage) GetDailyStats(endpointName, startDate, endDate string) (
 	var stats []DailyStat
 	for rows.Next() {
 		var stat DailyStat
-		if err := rows.Scan(&stat.ID, &stat.EndpointName, &stat.Date, &stat.Requests, &stat.Errors, &stat.InputTokens, &stat.OutputTokens, &stat.DeviceID, &stat.CreatedAt); err != nil {
+		if err := rows.Scan(&stat.EndpointName, &stat.Date, &stat.Requests, &stat.Errors, &stat.InputTokens, &stat.OutputTokens, &stat.CacheHits, &stat.SavedTokens, &stat.DeviceID); err != nil {
 			return nil, err
 		}
 		stats = append(stats, stat)
@@ -241,11 +266,17 @@ func (s *SQLiteStorage) GetDailyStats(endpointName, startDate, endDate string) (
 }
 
 func (s *SQLiteStorage) GetAllStats() (map[string][]DailyStat, error) {
+	return s.GetAllStatsForDevice("")
+}
+
+// GetAllStatsForDevice returns the daily stats of one device; an empty device ID aggregates all
+// devices. Pruned months appear like in GetDailyStats.
+func (s *SQLiteStorage) GetAllStatsForDevice(deviceID string) (map[string][]DailyStat, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	rows, err := s.db.Query(`SELECT id, endpoint_name, date, SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens), device_id, created_at
-		FROM daily_stats GROUP BY endpoint_name, date ORDER BY date DESC`)
+	rows, err := s.db.Query(`SELECT endpoint_name, date, SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens), SUM(cache_hits), SUM(saved_tokens), MIN(device_id)
+		FROM `+allStatsSource+` WHERE ? = '' OR device_id = ? GROUP BY endpoint_name, date ORDER BY date DESC`, deviceID, deviceID)
 	if err != nil {
 		return nil, err
 	}
@@ -254,7 +285,7 @@ func (s *SQLiteStorage) GetAllStats() (map[string][]DailyStat, error) {
 	result := make(map[string][]DailyStat)
 	for rows.Next() {
 		var stat DailyStat
-		if err := rows.Scan(&stat.ID, &stat.EndpointName, &stat.Date, &stat.Requests, &stat.Errors, &stat.InputTokens, &stat.OutputTokens, &stat.DeviceID, &stat.CreatedAt); err != nil {
+		if err := rows.Scan(&stat.EndpointName, &stat.Date, &stat.Requests, &stat.Errors, &stat.InputTokens, &stat.OutputTokens, &stat.CacheHits, &stat.SavedTokens, &stat.DeviceID); err != nil {
 			return nil, err
 		}
 		result[stat.EndpointName] = append(result[stat.EndpointName], stat)
@@ -291,8 +322,8 @@ func (s *SQLiteStorage) GetTotalStats() (int, map[string]*EndpointStats, error)
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	query := `SELECT endpoint_name, SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens)
-		FROM daily_stats GROUP BY endpoint_name`
+	query := `SELECT endpoint_name, SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens), SUM(cache_hits), SUM(saved_tokens)
+		FROM ` + allStatsSource + ` GROUP BY endpoint_name`
 
 	rows, err := s.db.Query(query)
 	if err != nil {
@@ -305,10 +336,10 @@ func (s *SQLiteStorage) GetTotalStats() (int, map[string]*EndpointStats, error)
 
 	for rows.Next() {
 		var endpointName string
-		var requests, errors int
-		var inputTokens, outputTokens int64
+		var requests, errors, cacheHits int
+		var inputTokens, outputTokens, savedTokens int64
 
-		if err := rows.Scan(&endpointName, &requests, &errors, &inputTokens, &outputTokens); err != nil {
+		if err := rows.Scan(&endpointName, &requests, &errors, &inputTokens, &outputTokens, &cacheHits, &savedTokens); err != nil {
 			return 0, nil, err
 		}
 
@@ -317,6 +348,8 @@ func (s *SQLiteStorage) GetTotalStats() (int, map[string]*EndpointStats, error)
 			Errors:       errors,
 			InputTokens:  inputTokens,
 			OutputTokens: outputTokens,
+			CacheHits:    cacheHits,
+			SavedTokens:  savedTokens,
 		}
 		totalRequests += requests
 	}
@@ -328,13 +361,13 @@ func (s *SQLiteStorage) GetEndpointTotalStats(endpointName string) (*EndpointSta
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	query := `SELECT SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens)
-		FROM daily_stats WHERE endpoint_name=?`
+	query := `SELECT SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens), SUM(cache_hits), SUM(saved_tokens)
+		FROM ` + allStatsSource + ` WHERE endpoint_name=?`
 
-	var requests, errors int
-	var inputTokens, outputTokens int64
+	var requests, errors, cacheHits int
+	var inputTokens, outputTokens, savedTokens int64
 
-	err := s.db.QueryRow(query, endpointName).Scan(&requests, &errors, &inputTokens, &outputTokens)
+	err := s.db.QueryRow(query, endpointName).Scan(&requests, &errors, &inputTokens, &outputTokens, &cacheHits, &savedTokens)
 	if err == sql.ErrNoRows {
 		return &EndpointStats{}, nil
 	}
@@ -347,6 +380,8 @@ func (s *SQLiteStorage) GetEndpointTotalStats(endpointName string) (*EndpointSta
 		Errors:       errors,
 		InputTokens:  inputTokens,
 		OutputTokens: outputTokens,
+		CacheHits:    cacheHits,
+		SavedTokens:  savedTokens,
 	}, nil
 }
 
@@ -390,32 +425,12 @@ func (s *SQLiteStorage) GetDBPath() string {
 	return s.dbPath
 }
 
-// GetArchiveMonths returns a list of all months that have data
+// GetArchiveMonths returns a list of all months that have daily stats or rollups
 func (s *SQLiteStorage) GetArchiveMonths() ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	query := `SELECT DISTINCT strftime('%Y-%m', date) as month
-		FROM daily_stats
-		WHERE date IS NOT NULL AND date != ''
-		ORDER BY month DESC`
-
-	rows, err := s.db.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var months []string
-	for rows.Next() {
-		var month string
-		if err := rows.Scan(&month); err != nil {
-			return nil, err
-		}
-		months = append(months, month)
-	}
-
-	return months, rows.Err()
+	return getArchiveMonths(s.db)
 }
 
 // MonthlyArchiveData represents archive data for a specific month
@@ -459,13 +474,21 @@ func (s *SQLiteStorage) GetMonthlyArchiveData(month string) ([]MonthlyArchiveDat
 	return results, rows.Err()
 }
 
-// DeleteMonthlyStats deletes all daily stats for a specific month
+// DeleteMonthlyStats deletes all stats of a specific month: dailies, usage and rollups
 func (s *SQLiteStorage) DeleteMonthlyStats(month string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, err := s.db.Exec(`DELETE FROM daily_stats WHERE strftime('%Y-%m', date) = ?`, month)
-	return err
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteMonth(tx, sqliteRebind, month); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // CreateBackupCopy 创建数据库备份副本，只保留安全的 app_config 配置项。
@@ -501,15 +524,42 @@ func (s *SQLiteStorage) CreateBackupCopy(backupPath string) error {
 		return fmt.Errorf("failed to clean app_config: %w", err)
 	}
 
+	// 响应缓存、批处理任务和保存的 Responses 会话只对本机有效，且可能很大，不进入备份
+	for _, table := range []string{"response_cache", "message_batch_items", "message_batches", "stored_responses"} {
+		if _, err = backupDB.Exec(fmt.Sprintf(`DELETE FROM %s`, table)); err != nil {
+			return fmt.Errorf("failed to clean %s: %w", table, err)
+		}
+	}
+	if _, err = backupDB.Exec(`VACUUM`); err != nil {
+		return fmt.Errorf("failed to compact backup: %w", err)
+	}
+
 	return nil
 }
 
+// attachDatabase 以 alias 挂载另一个数据库文件，返回卸载函数。
+// 由更新版本写入、结构版本高于 SchemaVersion 的数据库会被拒绝（ErrSchemaTooNew）。
+func (s *SQLiteStorage) attachDatabase(path, alias string) (func(), error) {
+	if _, err := s.db.Exec(fmt.Sprintf("ATTACH DATABASE '%s' AS %s", path, alias)); err != nil {
+		return nil, err
+	}
+	detach := func() { s.db.Exec("DETACH DATABASE " + alias) }
+	if err := checkSchemaVersion(s.db, alias); err != nil {
+		detach()
+		return nil, err
+	}
+	return detach, nil
+}
+
 // MergeConflict represents an endpoint merge conflict
 type MergeConflict struct {
-	EndpointName   string   `json:"endpointName"`
-	ConflictFields []string `json:"conflictFields"`
-	LocalEndpoint  Endpoint `json:"localEndpoint"`
-	RemoteEndpoint Endpoint `json:"remoteEndpoint"`
+	EndpointName   string    `json:"endpointName"`
+	UID            string    `json:"uid,omitempty"`
+	ConflictFields []string  `json:"conflictFields"`
+	LocalEndpoint  Endpoint  `json:"localEndpoint"`
+	RemoteEndpoint Endpoint  `json:"remoteEndpoint"`
+	BaseEndpoint   *Endpoint `json:"baseEndpoint,omitempty"` // The endpoint as of the last sync, if known
+	Deleted        string    `json:"deleted,omitempty"`      // Side that deleted the endpoint the other side changed
 }
 
 // DetectEndpointConflicts detects conflicts between local and remote endpoints
@@ -518,53 +568,41 @@ func (s *SQLiteStorage) DetectEndpointConflicts(remoteDBPath string) ([]MergeCon
 	defer s.mu.Unlock()
 
 	// Attach remote database
-	_, err := s.db.Exec(fmt.Sprintf("ATTACH DATABASE '%s' AS remote", remoteDBPath))
+	detach, err := s.attachDatabase(remoteDBPath, "remote")
 	if err != nil {
 		return nil, fmt.Errorf("failed to attach remote database: %w", err)
 	}
-	defer s.db.Exec("DETACH DATABASE remote")
+	defer detach()
 
 	// Get local endpoints
-	localEndpoints, err := s.getEndpointsFromDB(s.db, "main")
+	localEndpoints, err := getEndpointsFromDB(s.db, "main")
 	if err != nil {
 		return nil, err
 	}
 
 	// Get remote endpoints
-	remoteEndpoints, err := s.getEndpointsFromDB(s.db, "remote")
+	remoteEndpoints, err := getEndpointsFromDB(s.db, "remote")
 	if err != nil {
 		return nil, err
 	}
 
-	// Build local endpoint map
-	localMap := make(map[string]Endpoint)
-	for _, ep := range localEndpoints {
-		localMap[ep.Name] = ep
-	}
-
-	// Detect conflicts
-	var conflicts []MergeConflict
-	for _, remote := range remoteEndpoints {
-		if local, exists := localMap[remote.Name]; exists {
-			// Check for differences
-			conflictFields := compareEndpoints(local, remote)
-			if len(conflictFields) > 0 {
-				conflicts = append(conflicts, MergeConflict{
-					EndpointName:   remote.Name,
-					ConflictFields: conflictFields,
-					LocalEndpoint:  local,
-					RemoteEndpoint: remote,
-				})
-			}
-		}
+	base, hasBase, err := getEndpointSyncBase(s.db)
+	if err != nil {
+		return nil, err
 	}
 
-	return conflicts, nil
+	// Only fields changed differently on both sides since the last sync are conflicts
+	_, conflicts, err := mergeEndpointVersions(base, localEndpoints, remoteEndpoints, hasBase, nil)
+	return conflicts, err
 }
 
 // getEndpointsFromDB gets endpoints from a specific database (main or attached)
-func (s *SQLiteStorage) getEndpointsFromDB(db *sql.DB, dbName string) ([]Endpoint, error) {
-	query := fmt.Sprintf(`SELECT id, name, api_url, api_key, enabled, transformer, model, remark, COALESCE(sort_order, 0) as sort_order, created_at, updated_at FROM %s.endpoints`, dbName)
+func getEndpointsFromDB(db rowsQuerier, dbName string) ([]Endpoint, error) {
+	textColumns, err := endpointTextColumnsSelect(db, dbName)
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf(`SELECT id, name, api_url, api_key, enabled, transformer, model, remark, COALESCE(sort_order, 0) as sort_order, %s, created_at, updated_at FROM %s.endpoints`, textColumns, dbName)
 	rows, err := db.Query(query)
 	if err != nil {
 		return nil, err
@@ -574,7 +612,7 @@ func (s *SQLiteStorage) getEndpointsFromDB(db *sql.DB, dbName string) ([]Endpoin
 	var endpoints []Endpoint
 	for rows.Next() {
 		var ep Endpoint
-		if err := rows.Scan(&ep.ID, &ep.Name, &ep.APIUrl, &ep.APIKey, &ep.Enabled, &ep.Transformer, &ep.Model, &ep.Remark, &ep.SortOrder, &ep.CreatedAt, &ep.UpdatedAt); err != nil {
+		if err := rows.Scan(&ep.ID, &ep.Name, &ep.APIUrl, &ep.APIKey, &ep.Enabled, &ep.Transformer, &ep.Model, &ep.Remark, &ep.SortOrder, &ep.AzureResource, &ep.AzureDeployment, &ep.AzureAPIVersion, &ep.RewriteRules, &ep.Capabilities, &ep.UID, &ep.CreatedAt, &ep.UpdatedAt); err != nil {
 			return nil, err
 		}
 		endpoints = append(endpoints, ep)
@@ -583,32 +621,6 @@ func (s *SQLiteStorage) getEndpointsFromDB(db *sql.DB, dbName string) ([]Endpoin
 	return endpoints, rows.Err()
 }
 
-// compareEndpoints compares two endpoints and returns conflicting fields
-func compareEndpoints(local, remote Endpoint) []string {
-	var conflicts []string
-
-	if local.APIUrl != remote.APIUrl {
-		conflicts = append(conflicts, "apiUrl")
-	}
-	if local.APIKey != remote.APIKey {
-		conflicts = append(conflicts, "apiKey")
-	}
-	if local.Enabled != remote.Enabled {
-		conflicts = append(conflicts, "enabled")
-	}
-	if local.Transformer != remote.Transformer {
-		conflicts = append(conflicts, "transformer")
-	}
-	if local.Model != remote.Model {
-		conflicts = append(conflicts, "model")
-	}
-	if local.Remark != remote.Remark {
-		conflicts = append(conflicts, "remark")
-	}
-
-	return conflicts
-}
-
 // MergeStrategy 定义合并时如何处理冲突
 type MergeStrategy string
 
@@ -623,11 +635,11 @@ func (s *SQLiteStorage) MergeFromBackup(backupDBPath string, strategy MergeStrat
 	defer s.mu.Unlock()
 
 	// 挂载备份数据库
-	_, err := s.db.Exec(fmt.Sprintf("ATTACH DATABASE '%s' AS backup", backupDBPath))
+	detach, err := s.attachDatabase(backupDBPath, "backup")
 	if err != nil {
 		return fmt.Errorf("failed to attach backup database: %w", err)
 	}
-	defer s.db.Exec("DETACH DATABASE backup")
+	defer detach()
 
 	// 开启事务
 	tx, err := s.db.Begin()
@@ -641,8 +653,12 @@ func (s *SQLiteStorage) MergeFromBackup(backupDBPath string, strategy MergeStrat
 		return fmt.Errorf("failed to merge endpoints: %w", err)
 	}
 
-	// 2. 根据策略合并每日统计数据
-	if err := s.mergeDailyStats(tx, strategy); err != nil {
+	// 2. 根据策略合并已清理月份的月度汇总和每日统计数据
+	skip, err := s.mergeMonthlyStats(tx, strategy)
+	if err != nil {
+		return fmt.Errorf("failed to merge monthly stats: %w", err)
+	}
+	if err := s.mergeDailyStats(tx, strategy, skip); err != nil {
 		return fmt.Errorf("failed to merge daily stats: %w", err)
 	}
 
@@ -651,6 +667,18 @@ func (s *SQLiteStorage) MergeFromBackup(backupDBPath string, strategy MergeStrat
 		return fmt.Errorf("failed to merge app config: %w", err)
 	}
 
+	// 4. 旧版本备份中的端点没有 uid，补齐后以备份中的端点作为下次三方合并的基准
+	if err := assignEndpointUIDs(tx); err != nil {
+		return err
+	}
+	remote, err := getEndpointsFromDB(tx, "backup")
+	if err != nil {
+		return err
+	}
+	if err := saveEndpointSyncBase(tx, remote); err != nil {
+		return err
+	}
+
 	// 提交事务
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
@@ -661,79 +689,119 @@ func (s *SQLiteStorage) MergeFromBackup(backupDBPath string, strategy MergeStrat
 
 // mergeEndpoints 根据策略合并端点配置
 func (s *SQLiteStorage) mergeEndpoints(tx *sql.Tx, strategy MergeStrategy) error {
+	// 旧版本备份可能没有 Azure、改写规则和能力字段
+	textColumns, err := endpointTextColumnsSelect(tx, "backup")
+	if err != nil {
+		return err
+	}
+
 	switch strategy {
 	case MergeStrategyKeepLocal:
 		// 只插入新端点（忽略冲突）
-		_, err := tx.Exec(`
+		_, err := tx.Exec(fmt.Sprintf(`
 			INSERT OR IGNORE INTO endpoints
-			(name, api_url, api_key, enabled, transformer, model, remark, sort_order)
-			SELECT name, api_url, api_key, enabled, transformer, model, remark, COALESCE(sort_order, 0)
+			(name, api_url, api_key, enabled, transformer, model, remark, sort_order, azure_resource, azure_deployment, azure_api_version, rewrite_rules, capabilities, uid)
+			SELECT name, api_url, api_key, enabled, transformer, model, remark, COALESCE(sort_order, 0), %s
 			FROM backup.endpoints
-		`)
+		`, textColumns))
 		return err
 	case MergeStrategyOverwriteLocal:
 		// 替换已存在的端点
-		_, err := tx.Exec(`
+		_, err := tx.Exec(fmt.Sprintf(`
 			INSERT OR REPLACE INTO endpoints
-			(name, api_url, api_key, enabled, transformer, model, remark, sort_order)
-			SELECT name, api_url, api_key, enabled, transformer, model, remark, COALESCE(sort_order, 0)
+			(name, api_url, api_key, enabled, transformer, model, remark, sort_order, azure_resource, azure_deployment, azure_api_version, rewrite_rules, capabilities, uid)
+			SELECT name, api_url, api_key, enabled, transformer, model, remark, COALESCE(sort_order, 0), %s
 			FROM backup.endpoints
-		`)
+		`, textColumns))
 		return err
 	default:
 		return fmt.Errorf("unknown merge strategy: %s", strategy)
 	}
 }
 
+// mergeMonthlyStats 合并备份中已清理月份的月度汇总（见 mergePrunedMonths），返回需要跳过其每日统计的分组
+func (s *SQLiteStorage) mergeMonthlyStats(tx *sql.Tx, strategy MergeStrategy) (map[monthGroup]bool, error) {
+	// 旧版本备份没有月度汇总表
+	var monthly []backupMonthlyRow
+	exists, err := hasTable(tx, "backup", "monthly_stats")
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if monthly, err = queryMonthlyRows(tx, "backup.monthly_stats"); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := tx.Query(`SELECT DISTINCT substr(date, 1, 7), endpoint_name, COALESCE(device_id, 'default') FROM backup.daily_stats`)
+	if err != nil {
+		return nil, err
+	}
+	var groups []monthGroup
+	for rows.Next() {
+		var g monthGroup
+		if err := rows.Scan(&g.month, &g.endpoint, &g.device); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return mergePrunedMonths(tx, sqliteRebind, monthly, groups, strategy)
+}
+
 // mergeDailyStats 根据策略合并每日统计数据
-// 注意：备份数据的 device_id 会被替换为本地的 device_id，以避免跨设备恢复时产生重复记录
-func (s *SQLiteStorage) mergeDailyStats(tx *sql.Tx, strategy MergeStrategy) error {
-	// 获取本地 device_id，如果不存在则使用 'default'
-	var localDeviceID string
-	err := tx.QueryRow(`SELECT COALESCE((SELECT value FROM app_config WHERE key = 'device_id'), 'default')`).Scan(&localDeviceID)
+// 每条记录保留备份中的 device_id，按 (endpoint_name, date, device_id) 合并，重复恢复同一备份不会重复计数；
+// skip 中的分组已由本地的月度汇总计入，不再合并
+func (s *SQLiteStorage) mergeDailyStats(tx *sql.Tx, strategy MergeStrategy, skip map[monthGroup]bool) error {
+	// 旧版本备份可能没有缓存统计列，缺失时按 0 处理
+	cacheColumns, err := dailyStatsCacheSelect(tx, "backup")
 	if err != nil {
-		localDeviceID = "default"
+		return err
 	}
 
+	var insert string
 	switch strategy {
 	case MergeStrategyKeepLocal:
 		// 保留本地数据，只插入本地不存在的记录
-		// 使用本地 device_id 替代备份的 device_id，并按 endpoint_name 和 date 聚合避免冲突
-		_, err := tx.Exec(`
-			INSERT OR IGNORE INTO daily_stats
-			(endpoint_name, date, requests, errors, input_tokens, output_tokens, device_id)
-			SELECT endpoint_name, date, SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens), ?
-			FROM backup.daily_stats
-			GROUP BY endpoint_name, date
-		`, localDeviceID)
-		return err
+		insert = "INSERT OR IGNORE"
 	case MergeStrategyOverwriteLocal:
-		// 用备份数据覆盖本地数据
-		// 步骤1：删除主数据库中的冲突记录（只匹配 endpoint_name 和 date）
-		_, err := tx.Exec(`
-			DELETE FROM daily_stats
-			WHERE EXISTS (
-				SELECT 1 FROM backup.daily_stats b
-				WHERE b.endpoint_name = daily_stats.endpoint_name
-				AND b.date = daily_stats.date
-			)
-		`)
-		if err != nil {
-			return err
-		}
-
-		// 步骤2：使用本地 device_id 插入备份数据（按 endpoint_name 和 date 聚合，避免多设备数据冲突）
-		_, err = tx.Exec(`
-			INSERT INTO daily_stats
-			(endpoint_name, date, requests, errors, input_tokens, output_tokens, device_id)
-			SELECT endpoint_name, date, SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens), ?
-			FROM backup.daily_stats
-			GROUP BY endpoint_name, date
-		`, localDeviceID)
-		return err
+		// 用备份数据覆盖同一设备、同一端点、同一天的本地记录
+		insert = "INSERT OR REPLACE"
 	default:
 		return fmt.Errorf("unknown merge strategy: %s", strategy)
 	}
+
+	where := "1 = 1"
+	var args []interface{}
+	for g := range skip {
+		where += " AND NOT (substr(date, 1, 7) = ? AND endpoint_name = ? AND COALESCE(device_id, 'default') = ?)"
+		args = append(args, g.month, g.endpoint, g.device)
+	}
+	_, err = tx.Exec(fmt.Sprintf(`
+		%s INTO daily_stats
+		(endpoint_name, date, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens, device_id, sync_version)
+		SELECT endpoint_name, date, SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens), %s, COALESCE(device_id, 'default'), 0
+		FROM backup.daily_stats WHERE %s
+		GROUP BY endpoint_name, date, device_id
+	`, insert, cacheColumns, where), args...)
+	if err != nil {
+		return err
+	}
+	// 合并到已清理月份的记录计入月度汇总
+	if err := rollupMergedDailies(tx, sqliteRebind); err != nil {
+		return err
+	}
+
+	// 恢复的记录分配新的同步版本：本机记录需要重新推送给其他设备，所有记录都要进入下一个增量备份
+	_, err = tx.Exec(`
+		UPDATE daily_stats SET sync_version = (SELECT COALESCE(MAX(sync_version), 0) + 1 FROM daily_stats)
+		WHERE sync_version = 0
+	`)
+	return err
 }
 
 // mergeAppConfig 根据策略合并安全的 app_config 配置项
@@ -2,14 +2,14 @@ package storage
 
 import "reflect"
 
-// StatsStorageAdapter adapts SQLiteStorage to be used by proxy.Stats
+// StatsStorageAdapter adapts a Storage to be used by proxy.Stats
 // It implements the proxy.StatsStorage interface
 type StatsStorageAdapter struct {
-	storage *SQLiteStorage
+	storage Storage
 }
 
 // NewStatsStorageAdapter creates a new adapter
-func NewStatsStorageAdapter(storage *SQLiteStorage) *StatsStorageAdapter {
+func NewStatsStorageAdapter(storage Storage) *StatsStorageAdapter {
 	return &StatsStorageAdapter{storage: storage}
 }
 
@@ -28,9 +28,35 @@ func (a *StatsStorageAdapter) RecordDailyStat(stat interface{}) error {
 		Errors:       int(v.FieldByName("Errors").Int()),
 		InputTokens:  int(v.FieldByName("InputTokens").Int()),
 		OutputTokens: int(v.FieldByName("OutputTokens").Int()),
+		CacheHits:    int(v.FieldByName("CacheHits").Int()),
+		SavedTokens:  int(v.FieldByName("SavedTokens").Int()),
 		DeviceID:     v.FieldByName("DeviceID").String(),
 	}
-	return a.storage.RecordDailyStat(dailyStat)
+
+	// Records with an hour also feed the hourly usage rollup, written in the same transaction
+	hour := v.FieldByName("Hour")
+	if !hour.IsValid() || hour.String() == "" {
+		return a.storage.RecordDailyStat(dailyStat)
+	}
+	return a.storage.RecordRequestStat(dailyStat, &UsageStat{
+		EndpointName:  dailyStat.EndpointName,
+		ClientModel:   v.FieldByName("ClientModel").String(),
+		UpstreamModel: v.FieldByName("UpstreamModel").String(),
+		ClientFormat:  v.FieldByName("ClientFormat").String(),
+		Hour:          hour.String(),
+		DeviceID:      dailyStat.DeviceID,
+		Requests:      dailyStat.Requests,
+		Errors:        dailyStat.Errors,
+		InputTokens:   dailyStat.InputTokens,
+		OutputTokens:  dailyStat.OutputTokens,
+		CacheHits:     dailyStat.CacheHits,
+		SavedTokens:   dailyStat.SavedTokens,
+	})
+}
+
+// DownsampleUsageStats folds hourly usage of days before a date into daily rows
+func (a *StatsStorageAdapter) DownsampleUsageStats(beforeDate string) (int, error) {
+	return a.storage.DownsampleUsageStats(beforeDate)
 }
 
 // GetTotalStats gets total stats for all endpoints
@@ -47,6 +73,8 @@ func (a *StatsStorageAdapter) GetTotalStats() (int, map[string]interface{}, erro
 			Errors:       stats.Errors,
 			InputTokens:  stats.InputTokens,
 			OutputTokens: stats.OutputTokens,
+			CacheHits:    stats.CacheHits,
+			SavedTokens:  stats.SavedTokens,
 		}
 	}
 
@@ -59,6 +87,8 @@ type StatsDataCompat struct {
 	Errors       int
 	InputTokens  int64
 	OutputTokens int64
+	CacheHits    int
+	SavedTokens  int64
 }
 
 // GetDailyStats gets daily stats for an endpoint
@@ -76,6 +106,8 @@ func (a *StatsStorageAdapter) GetDailyStats(endpointName, startDate, endDate str
 			Errors:       stat.Errors,
 			InputTokens:  stat.InputTokens,
 			OutputTokens: stat.OutputTokens,
+			CacheHits:    stat.CacheHits,
+			SavedTokens:  stat.SavedTokens,
 		}
 	}
 
@@ -89,4 +121,6 @@ type DailyRecordCompat struct {
 	Errors       int
 	InputTokens  int
 	OutputTokens int
+	CacheHits    int
+	SavedTokens  int
 }
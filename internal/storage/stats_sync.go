@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"fmt"
+)
+
+// StatsChange is the absolute value of one daily stats row of a device, as exchanged by stats sync
+type StatsChange struct {
+	EndpointName string `json:"endpoint"`
+	Date         string `json:"date"`
+	Requests     int    `json:"requests"`
+	Errors       int    `json:"errors"`
+	InputTokens  int    `json:"inputTokens"`
+	OutputTokens int    `json:"outputTokens"`
+	CacheHits    int    `json:"cacheHits,omitempty"`
+	SavedTokens  int    `json:"savedTokens,omitempty"`
+}
+
+// DeviceStats summarizes the stats recorded by one device
+type DeviceStats struct {
+	DeviceID     string `json:"deviceId"`
+	Requests     int    `json:"requests"`
+	Errors       int    `json:"errors"`
+	InputTokens  int    `json:"inputTokens"`
+	OutputTokens int    `json:"outputTokens"`
+	FirstDate    string `json:"firstDate"`
+	LastDate     string `json:"lastDate"`
+}
+
+// GetStatsChanges returns the rows of a device changed after the given sync version, together
+// with the highest version among them (or sinceVersion when nothing changed)
+func (s *SQLiteStorage) GetStatsChanges(deviceID string, sinceVersion int64) ([]StatsChange, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(`SELECT endpoint_name, date, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens, sync_version
+		FROM daily_stats WHERE device_id = ? AND sync_version > ? ORDER BY sync_version`, deviceID, sinceVersion)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	maxVersion := sinceVersion
+	var changes []StatsChange
+	for rows.Next() {
+		var c StatsChange
+		var version int64
+		if err := rows.Scan(&c.EndpointName, &c.Date, &c.Requests, &c.Errors, &c.InputTokens, &c.OutputTokens, &c.CacheHits, &c.SavedTokens, &version); err != nil {
+			return nil, 0, err
+		}
+		changes = append(changes, c)
+		if version > maxVersion {
+			maxVersion = version
+		}
+	}
+	return changes, maxVersion, rows.Err()
+}
+
+// ApplyStatsChanges stores the rows of another device. Rows carry absolute values, so applying
+// the same changes twice leaves the stats unchanged. Rows of an endpoint and month already rolled
+// into a pruned rollup of the device are skipped, since they are counted there. Applied rows get a
+// new sync version so that incremental backups include them; only rows of the local device are
+// pushed by stats sync.
+func (s *SQLiteStorage) ApplyStatsChanges(deviceID string, changes []StatsChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var localID string
+	if err := s.db.QueryRow(`SELECT COALESCE((SELECT value FROM app_config WHERE key = 'device_id'), '')`).Scan(&localID); err != nil {
+		return err
+	}
+	if deviceID == "" || deviceID == localID {
+		return fmt.Errorf("refusing to apply stats changes for device %q", deviceID)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	pruned, err := prunedDeviceGroups(tx, sqliteRebind, deviceID)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO daily_stats (endpoint_name, date, requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens, device_id, sync_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(sync_version), 0) + 1 FROM daily_stats))
+		ON CONFLICT(endpoint_name, date, device_id) DO UPDATE SET
+			requests = excluded.requests,
+			errors = excluded.errors,
+			input_tokens = excluded.input_tokens,
+			output_tokens = excluded.output_tokens,
+			cache_hits = excluded.cache_hits,
+			saved_tokens = excluded.saved_tokens,
+			sync_version = excluded.sync_version
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, c := range changes {
+		if len(c.Date) >= 7 && pruned[monthGroup{c.Date[:7], c.EndpointName, deviceID}] {
+			continue
+		}
+		if _, err := stmt.Exec(c.EndpointName, c.Date, c.Requests, c.Errors, c.InputTokens, c.OutputTokens, c.CacheHits, c.SavedTokens, deviceID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetDeviceStats returns the totals of every device that has recorded stats
+func (s *SQLiteStorage) GetDeviceStats() ([]DeviceStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(`SELECT device_id, SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens), MIN(date), MAX(date)
+		FROM ` + allStatsSource + ` GROUP BY device_id ORDER BY device_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []DeviceStats
+	for rows.Next() {
+		var d DeviceStats
+		if err := rows.Scan(&d.DeviceID, &d.Requests, &d.Errors, &d.InputTokens, &d.OutputTokens, &d.FirstDate, &d.LastDate); err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
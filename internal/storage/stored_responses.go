@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// StoredResponse is a Responses API response kept for previous_response_id and retrieval
+type StoredResponse struct {
+	ID           string
+	PreviousID   string // previous_response_id of the request, empty for the first turn
+	EndpointName string
+	Model        string
+	Input        string // JSON array of the request's own input items
+	Output       string // JSON array of output items
+	Response     string // JSON response object as returned to the client
+	CreatedAt    time.Time
+}
+
+// GetStoredResponse returns a stored response, or nil when it does not exist
+func (s *SQLiteStorage) GetStoredResponse(id string) (*StoredResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var r StoredResponse
+	var createdAt int64
+	err := s.db.QueryRow(`SELECT id, previous_id, endpoint_name, model, input, output, response, created_at
+		FROM stored_responses WHERE id=?`, id).Scan(
+		&r.ID, &r.PreviousID, &r.EndpointName, &r.Model, &r.Input, &r.Output, &r.Response, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.CreatedAt = time.Unix(createdAt, 0)
+	return &r, nil
+}
+
+// PutStoredResponse stores a response and drops the responses created before the retention cutoff
+func (s *SQLiteStorage) PutStoredResponse(r *StoredResponse, cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO stored_responses
+		(id, previous_id, endpoint_name, model, input, output, response, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ID, r.PreviousID, r.EndpointName, r.Model, r.Input, r.Output, r.Response, r.CreatedAt.Unix())
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`DELETE FROM stored_responses WHERE created_at<?`, cutoff.Unix())
+	return err
+}
+
+// DeleteStoredResponse removes a stored response, reporting whether it existed
+func (s *SQLiteStorage) DeleteStoredResponse(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.db.Exec(`DELETE FROM stored_responses WHERE id=?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
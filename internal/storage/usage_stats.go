@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Granularities of usage stats queries
+const (
+	UsageGranularityHour = "hour"
+	UsageGranularityDay  = "day"
+)
+
+// Dimensions usage stats can be grouped by
+const (
+	UsageGroupEndpoint      = "endpoint"
+	UsageGroupClientModel   = "clientModel"
+	UsageGroupUpstreamModel = "upstreamModel"
+	UsageGroupClientFormat  = "clientFormat"
+	UsageGroupDevice        = "device"
+)
+
+// usageGroupColumns maps the group by dimensions to their columns
+var usageGroupColumns = map[string]string{
+	UsageGroupEndpoint:      "endpoint_name",
+	UsageGroupClientModel:   "client_model",
+	UsageGroupUpstreamModel: "upstream_model",
+	UsageGroupClientFormat:  "client_format",
+	UsageGroupDevice:        "device_id",
+}
+
+// UsageStat is one increment of the hourly usage rollup of a request
+type UsageStat struct {
+	EndpointName  string
+	ClientModel   string // Model the client asked for
+	UpstreamModel string // Model sent to the endpoint
+	ClientFormat  string
+	Hour          string // Format: "2006-01-02 15"
+	DeviceID      string
+	Requests      int
+	Errors        int
+	InputTokens   int
+	OutputTokens  int
+	CacheHits     int
+	SavedTokens   int
+}
+
+// UsageQuery selects usage stats between two dates (inclusive), summed per bucket of the
+// granularity and the group by dimensions. An empty device ID aggregates all devices.
+type UsageQuery struct {
+	Granularity string
+	GroupBy     []string
+	StartDate   string
+	EndDate     string
+	DeviceID    string
+}
+
+// Validate checks the granularity and the group by dimensions of a query
+func (q UsageQuery) Validate() error {
+	switch q.Granularity {
+	case UsageGranularityHour, UsageGranularityDay, "":
+	default:
+		return fmt.Errorf("invalid granularity: %s", q.Granularity)
+	}
+	for _, dim := range q.GroupBy {
+		if _, ok := usageGroupColumns[dim]; !ok {
+			return fmt.Errorf("invalid group by: %s", dim)
+		}
+	}
+	return nil
+}
+
+// UsageBucket is the usage of one time bucket ("2006-01-02 15" or "2006-01-02") and group.
+// Dimensions not grouped by are empty.
+type UsageBucket struct {
+	Bucket        string `json:"bucket"`
+	EndpointName  string `json:"endpoint,omitempty"`
+	ClientModel   string `json:"clientModel,omitempty"`
+	UpstreamModel string `json:"upstreamModel,omitempty"`
+	ClientFormat  string `json:"clientFormat,omitempty"`
+	DeviceID      string `json:"device,omitempty"`
+	Requests      int64  `json:"requests"`
+	Errors        int64  `json:"errors"`
+	InputTokens   int64  `json:"inputTokens"`
+	OutputTokens  int64  `json:"outputTokens"`
+	CacheHits     int64  `json:"cacheHits"`
+	SavedTokens   int64  `json:"savedTokens"`
+}
+
+// recordUsageSQL adds a usage increment to its hourly row
+const recordUsageSQL = `
+	INSERT INTO usage_stats (granularity, bucket, endpoint_name, client_model, upstream_model, client_format, device_id,
+		requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens)
+	VALUES ('hour', ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (granularity, bucket, endpoint_name, client_model, upstream_model, client_format, device_id) DO UPDATE SET
+		requests = usage_stats.requests + excluded.requests,
+		errors = usage_stats.errors + excluded.errors,
+		input_tokens = usage_stats.input_tokens + excluded.input_tokens,
+		output_tokens = usage_stats.output_tokens + excluded.output_tokens,
+		cache_hits = usage_stats.cache_hits + excluded.cache_hits,
+		saved_tokens = usage_stats.saved_tokens + excluded.saved_tokens
+`
+
+// downsampleUsageSQL folds the hourly rows of days before a date into daily rows;
+// deleteDownsampledUsageSQL then removes those hourly rows
+const (
+	downsampleUsageSQL = `
+	INSERT INTO usage_stats (granularity, bucket, endpoint_name, client_model, upstream_model, client_format, device_id,
+		requests, errors, input_tokens, output_tokens, cache_hits, saved_tokens)
+	SELECT 'day', substr(bucket, 1, 10), endpoint_name, client_model, upstream_model, client_format, device_id,
+		SUM(requests), SUM(errors), SUM(input_tokens), SUM(output_tokens), SUM(cache_hits), SUM(saved_tokens)
+	FROM usage_stats WHERE granularity = 'hour' AND bucket < ?
+	GROUP BY substr(bucket, 1, 10), endpoint_name, client_model, upstream_model, client_format, device_id
+	ON CONFLICT (granularity, bucket, endpoint_name, client_model, upstream_model, client_format, device_id) DO UPDATE SET
+		requests = usage_stats.requests + excluded.requests,
+		errors = usage_stats.errors + excluded.errors,
+		input_tokens = usage_stats.input_tokens + excluded.input_tokens,
+		output_tokens = usage_stats.output_tokens + excluded.output_tokens,
+		cache_hits = usage_stats.cache_hits + excluded.cache_hits,
+		saved_tokens = usage_stats.saved_tokens + excluded.saved_tokens
+`
+	deleteDownsampledUsageSQL = `DELETE FROM usage_stats WHERE granularity = 'hour' AND bucket < ?`
+)
+
+func usageStatArgs(stat *UsageStat) []interface{} {
+	return []interface{}{stat.Hour, stat.EndpointName, stat.ClientModel, stat.UpstreamModel, stat.ClientFormat, stat.DeviceID,
+		stat.Requests, stat.Errors, stat.InputTokens, stat.OutputTokens, stat.CacheHits, stat.SavedTokens}
+}
+
+// buildUsageQuery returns the SQL and arguments of a usage query, with ? placeholders. Hourly
+// buckets only exist within the retention window; daily buckets combine hourly and downsampled rows.
+func buildUsageQuery(q UsageQuery) (string, []interface{}, error) {
+	if err := q.Validate(); err != nil {
+		return "", nil, err
+	}
+	bucket := "substr(bucket, 1, 10)"
+	where := []string{"substr(bucket, 1, 10) >= ?", "substr(bucket, 1, 10) <= ?"}
+	args := []interface{}{q.StartDate, q.EndDate}
+	if q.Granularity == UsageGranularityHour {
+		bucket = "bucket"
+		where = append(where, "granularity = 'hour'")
+	}
+	if q.DeviceID != "" {
+		where = append(where, "device_id = ?")
+		args = append(args, q.DeviceID)
+	}
+
+	// Every dimension is selected, as '' when it is not grouped by
+	grouped := make(map[string]bool)
+	for _, dim := range q.GroupBy {
+		grouped[dim] = true
+	}
+	groupBy := []string{bucket}
+	var columns []string
+	for _, dim := range []string{UsageGroupEndpoint, UsageGroupClientModel, UsageGroupUpstreamModel, UsageGroupClientFormat, UsageGroupDevice} {
+		if grouped[dim] {
+			columns = append(columns, usageGroupColumns[dim])
+			groupBy = append(groupBy, usageGroupColumns[dim])
+		} else {
+			columns = append(columns, "''")
+		}
+	}
+
+	query := fmt.Sprintf(`SELECT %s, %s, CAST(SUM(requests) AS BIGINT), CAST(SUM(errors) AS BIGINT), CAST(SUM(input_tokens) AS BIGINT),
+		CAST(SUM(output_tokens) AS BIGINT), CAST(SUM(cache_hits) AS BIGINT), CAST(SUM(saved_tokens) AS BIGINT)
+		FROM usage_stats WHERE %s GROUP BY %s ORDER BY %s`,
+		bucket, strings.Join(columns, ", "), strings.Join(where, " AND "), strings.Join(groupBy, ", "), strings.Join(groupBy, ", "))
+	return query, args, nil
+}
+
+func scanUsageBuckets(rows *sql.Rows) ([]UsageBucket, error) {
+	defer rows.Close()
+	buckets := []UsageBucket{}
+	for rows.Next() {
+		var b UsageBucket
+		if err := rows.Scan(&b.Bucket, &b.EndpointName, &b.ClientModel, &b.UpstreamModel, &b.ClientFormat, &b.DeviceID,
+			&b.Requests, &b.Errors, &b.InputTokens, &b.OutputTokens, &b.CacheHits, &b.SavedTokens); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+// RecordUsageStat adds a request's usage to the hourly rollup
+func (s *SQLiteStorage) RecordUsageStat(stat *UsageStat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec(recordUsageSQL, usageStatArgs(stat)...)
+	return err
+}
+
+// RecordRequestStat records a request's daily stat and its hourly usage in one transaction, so
+// the daily totals and the usage rollup never disagree
+func (s *SQLiteStorage) RecordRequestStat(stat *DailyStat, usage *UsageStat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := recordSQLiteDailyStat(tx, stat); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(recordUsageSQL, usageStatArgs(usage)...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetUsageStats returns usage stats by time bucket and the requested dimensions
+func (s *SQLiteStorage) GetUsageStats(q UsageQuery) ([]UsageBucket, error) {
+	query, args, err := buildUsageQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanUsageBuckets(rows)
+}
+
+// DownsampleUsageStats folds the hourly usage of days before a date ("2006-01-02") into daily
+// rows and returns the number of hourly rows removed
+func (s *SQLiteStorage) DownsampleUsageStats(beforeDate string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(downsampleUsageSQL, beforeDate); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(deleteDownsampledUsageSQL, beforeDate)
+	if err != nil {
+		return 0, err
+	}
+	removed, _ := result.RowsAffected()
+	return int(removed), tx.Commit()
+}
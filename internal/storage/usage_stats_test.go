@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestUsageStatsRollupAndDownsample(t *testing.T) {
+	s, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "main.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	record := func(hour, clientModel string, requests, inputTokens int) {
+		t.Helper()
+		err := s.RecordUsageStat(&UsageStat{EndpointName: "a", ClientModel: clientModel, UpstreamModel: "up", ClientFormat: "claude",
+			Hour: hour, DeviceID: "dev", Requests: requests, InputTokens: inputTokens})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	record("2026-03-01 09", "opus", 1, 100)
+	record("2026-03-01 09", "opus", 1, 50)
+	record("2026-03-01 14", "haiku", 2, 10)
+	record("2026-03-02 08", "opus", 1, 5)
+
+	hourly, err := s.GetUsageStats(UsageQuery{Granularity: UsageGranularityHour, GroupBy: []string{UsageGroupClientModel}, StartDate: "2026-03-01", EndDate: "2026-03-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hourly) != 2 || hourly[0].Bucket != "2026-03-01 09" || hourly[0].ClientModel != "opus" || hourly[0].Requests != 2 || hourly[0].InputTokens != 150 ||
+		hourly[0].EndpointName != "" {
+		t.Fatalf("hourly usage = %+v", hourly)
+	}
+
+	if _, err := s.GetUsageStats(UsageQuery{GroupBy: []string{"model; DROP TABLE usage_stats"}}); err == nil {
+		t.Fatal("invalid group by accepted")
+	}
+
+	removed, err := s.DownsampleUsageStats("2026-03-02")
+	if err != nil || removed != 2 {
+		t.Fatalf("downsample removed %d rows: %v", removed, err)
+	}
+	// Downsampling again merges into the same daily rows
+	record("2026-03-01 23", "opus", 1, 1)
+	if _, err := s.DownsampleUsageStats("2026-03-02"); err != nil {
+		t.Fatal(err)
+	}
+
+	daily, err := s.GetUsageStats(UsageQuery{Granularity: UsageGranularityDay, GroupBy: []string{UsageGroupClientModel}, StartDate: "2026-03-01", EndDate: "2026-03-02"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []UsageBucket{
+		{Bucket: "2026-03-01", ClientModel: "haiku", Requests: 2, InputTokens: 10},
+		{Bucket: "2026-03-01", ClientModel: "opus", Requests: 3, InputTokens: 151},
+		{Bucket: "2026-03-02", ClientModel: "opus", Requests: 1, InputTokens: 5},
+	}
+	if len(daily) != len(want) {
+		t.Fatalf("daily usage = %+v", daily)
+	}
+	for i := range want {
+		if daily[i] != want[i] {
+			t.Fatalf("daily usage[%d] = %+v, want %+v", i, daily[i], want[i])
+		}
+	}
+
+	hourly, err = s.GetUsageStats(UsageQuery{Granularity: UsageGranularityHour, StartDate: "2026-03-01", EndDate: "2026-03-02"})
+	if err != nil || len(hourly) != 1 || hourly[0].Bucket != "2026-03-02 08" {
+		t.Fatalf("hourly usage after downsampling = %+v, %v", hourly, err)
+	}
+}
+
+func TestRecordRequestStatIsAtomic(t *testing.T) {
+	s, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "main.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	adapter := NewStatsStorageAdapter(s)
+	record := struct {
+		EndpointName, Date, DeviceID, Hour, ClientModel, UpstreamModel, ClientFormat string
+		Requests, Errors, InputTokens, OutputTokens, CacheHits, SavedTokens          int
+	}{EndpointName: "a", Date: "2026-03-01", DeviceID: "dev", Hour: "2026-03-01 09", ClientModel: "opus", Requests: 1, InputTokens: 10}
+	if err := adapter.RecordDailyStat(&record); err != nil {
+		t.Fatal(err)
+	}
+	usage, _ := s.GetUsageStats(UsageQuery{StartDate: "2026-03-01", EndDate: "2026-03-01"})
+	if len(usage) != 1 || usage[0].Requests != 1 || usage[0].InputTokens != 10 {
+		t.Fatalf("usage = %+v", usage)
+	}
+
+	// When the usage row cannot be written, the daily row is rolled back with it
+	if _, err := s.db.Exec(`DROP TABLE usage_stats`); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.RecordDailyStat(&record); err == nil {
+		t.Fatal("recording without a usage table succeeded")
+	}
+	daily, err := s.GetDailyStats("a", "2026-03-01", "2026-03-01")
+	if err != nil || len(daily) != 1 || daily[0].Requests != 1 {
+		t.Fatalf("daily stats = %+v, %v; want the first request only", daily, err)
+	}
+}
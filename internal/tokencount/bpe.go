@@ -0,0 +1,159 @@
+package tokencount
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dlclark/regexp2"
+)
+
+const maxRank = math.MaxInt
+
+// Pre-tokenizer patterns of the OpenAI encodings (as used by tiktoken)
+const (
+	cl100kPattern = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+	o200kPattern  = `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+)
+
+// Encoding is a byte-level BPE encoding in tiktoken format
+type Encoding struct {
+	Name    string
+	ranks   map[string]int
+	pattern *regexp2.Regexp
+}
+
+// NewEncoding creates an encoding from mergeable ranks and a pre-tokenizer pattern
+func NewEncoding(name string, ranks map[string]int, pattern string) (*Encoding, error) {
+	re, err := regexp2.Compile(pattern, regexp2.None)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern for %s: %w", name, err)
+	}
+	return &Encoding{Name: name, ranks: ranks, pattern: re}, nil
+}
+
+// Encode returns the token ranks of text. Special tokens are encoded as plain text.
+func (e *Encoding) Encode(text string) []int {
+	var tokens []int
+	e.split(text, func(piece string) {
+		if rank, ok := e.ranks[piece]; ok {
+			tokens = append(tokens, rank)
+			return
+		}
+		tokens = append(tokens, e.bytePairMerge([]byte(piece))...)
+	})
+	return tokens
+}
+
+// Count returns the number of tokens in text
+func (e *Encoding) Count(text string) int {
+	count := 0
+	e.split(text, func(piece string) {
+		if _, ok := e.ranks[piece]; ok {
+			count++
+			return
+		}
+		count += len(e.bytePairMerge([]byte(piece)))
+	})
+	return count
+}
+
+// split runs the pre-tokenizer over text and calls fn for every piece
+func (e *Encoding) split(text string, fn func(piece string)) {
+	if text == "" {
+		return
+	}
+	m, err := e.pattern.FindStringMatch(text)
+	for err == nil && m != nil {
+		fn(m.String())
+		m, err = e.pattern.FindNextMatch(m)
+	}
+}
+
+type bpePart struct {
+	start int
+	rank  int
+}
+
+// bytePairMerge repeatedly merges the adjacent pair with the lowest rank, like tiktoken's core BPE
+func (e *Encoding) bytePairMerge(piece []byte) []int {
+	parts := make([]bpePart, 0, len(piece)+1)
+	for i := 0; i < len(piece)-1; i++ {
+		parts = append(parts, bpePart{start: i, rank: e.rank(piece[i : i+2])})
+	}
+	parts = append(parts, bpePart{start: len(piece) - 1, rank: maxRank}, bpePart{start: len(piece), rank: maxRank})
+
+	// pairRank is the rank of parts[i] merged with parts[i+1] once parts[i+1] and parts[i+2] are merged
+	pairRank := func(i int) int {
+		if i+3 < len(parts) {
+			return e.rank(piece[parts[i].start:parts[i+3].start])
+		}
+		return maxRank
+	}
+
+	for {
+		minRank, minIdx := maxRank, -1
+		for i := 0; i < len(parts)-1; i++ {
+			if parts[i].rank < minRank {
+				minRank, minIdx = parts[i].rank, i
+			}
+		}
+		if minIdx < 0 {
+			break
+		}
+
+		if minIdx > 0 {
+			parts[minIdx-1].rank = pairRank(minIdx - 1)
+		}
+		parts[minIdx].rank = pairRank(minIdx)
+		parts = append(parts[:minIdx+1], parts[minIdx+2:]...)
+	}
+
+	tokens := make([]int, 0, len(parts)-1)
+	for i := 0; i < len(parts)-1; i++ {
+		tokens = append(tokens, e.rank(piece[parts[i].start:parts[i+1].start]))
+	}
+	return tokens
+}
+
+func (e *Encoding) rank(b []byte) int {
+	if r, ok := e.ranks[string(b)]; ok {
+		return r
+	}
+	return maxRank
+}
+
+// ParseTiktokenRanks reads a .tiktoken file: one base64 token and its rank per line
+func ParseTiktokenRanks(r io.Reader) (map[string]int, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed line: %q", line)
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid token %q: %w", fields[0], err)
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rank %q: %w", fields[1], err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ranks, nil
+}
@@ -28,52 +28,51 @@ type CountTokensResponse struct {
 	InputTokens int `json:"input_tokens"`
 }
 
-// EstimateInputTokens estimates input tokens for a request
+// EstimateInputTokens estimates input tokens for a request, using the BPE tokenizer
+// of req.Model when one is available
 func EstimateInputTokens(req *CountTokensRequest) int {
+	tk := ForModel(req.Model)
 	tokens := 10 // Base request overhead
 
 	// System prompt
 	if req.System != nil {
-		tokens += estimateAny(req.System) + 5
+		tokens += estimateAny(tk, req.System) + 5
 	}
 
 	// Messages
 	for _, msg := range req.Messages {
-		tokens += 10 + estimateAny(msg.Content)
+		tokens += 10 + estimateAny(tk, msg.Content)
 	}
 
 	// Tools
 	if len(req.Tools) > 0 {
-		tokens += estimateTools(req.Tools)
+		tokens += estimateTools(tk, req.Tools)
 	}
 
 	return tokens
 }
 
-// EstimateOutputTokens estimates tokens for output text
-func EstimateOutputTokens(text string) int {
-	return estimateText(text)
+// EstimateOutputTokens estimates tokens for output text of a model
+func EstimateOutputTokens(model, text string) int {
+	return ForModel(model).Count(text)
 }
 
-func estimateAny(v any) int {
+func estimateAny(tk *Tokenizer, v any) int {
 	switch val := v.(type) {
 	case string:
-		return estimateText(val)
+		return tk.Count(val)
 	case []any:
 		tokens := 0
 		for _, item := range val {
-			tokens += estimateBlock(item)
+			tokens += estimateBlock(tk, item)
 		}
 		return tokens
 	default:
-		if data, err := json.Marshal(v); err == nil {
-			return len(data) / 4
-		}
-		return 0
+		return tk.countJSON(v)
 	}
 }
 
-func estimateBlock(block any) int {
+func estimateBlock(tk *Tokenizer, block any) int {
 	m, ok := block.(map[string]any)
 	if !ok {
 		return 10
@@ -81,30 +80,30 @@ func estimateBlock(block any) int {
 
 	blockType, _ := m["type"].(string)
 	switch blockType {
-	case "text":
+	case "text", "input_text", "output_text":
 		if text, ok := m["text"].(string); ok {
-			return estimateText(text)
+			return tk.Count(text)
 		}
-	case "image":
+	case "image", "input_image":
 		return estimateImageBlock(m)
 	case "document":
 		return 500
 	case "tool_use":
 		if input, ok := m["input"]; ok {
-			if data, err := json.Marshal(input); err == nil {
-				return len(data) / 4
-			}
+			return tk.countJSON(input)
 		}
 	case "tool_result":
-		return estimateAny(m["content"])
+		return estimateAny(tk, m["content"])
 	}
 
-	if data, err := json.Marshal(block); err == nil {
-		return len(data) / 4
+	if tokens := tk.countJSON(block); tokens > 0 {
+		return tokens
 	}
 	return 10
 }
 
+// estimateText is the heuristic used for unknown models: about 4 characters per token
+// for English and 1.5 for Chinese
 func estimateText(text string) int {
 	if text == "" {
 		return 0
@@ -139,15 +138,15 @@ func estimateText(text string) int {
 	return tokens
 }
 
-func estimateTools(tools []Tool) int {
+func estimateTools(tk *Tokenizer, tools []Tool) int {
 	n := len(tools)
 	base, perTool := getToolOverhead(n)
 	tokens := base
 
 	for _, tool := range tools {
 		tokens += estimateToolName(tool.Name)
-		tokens += estimateText(tool.Description)
-		tokens += estimateSchema(tool.InputSchema, n)
+		tokens += tk.Count(tool.Description)
+		tokens += estimateSchema(tk, tool.InputSchema, n)
 		tokens += perTool
 	}
 
@@ -184,7 +183,7 @@ func estimateToolName(name string) int {
 	return tokens
 }
 
-func estimateSchema(schema any, toolCount int) int {
+func estimateSchema(tk *Tokenizer, schema any, toolCount int) int {
 	if schema == nil {
 		return 0
 	}
@@ -194,17 +193,21 @@ func estimateSchema(schema any, toolCount int) int {
 		return 0
 	}
 
-	var density float64
-	if toolCount == 1 {
-		density = 1.6
-	} else if toolCount <= 5 {
-		density = 1.9
+	var tokens int
+	if tk != nil {
+		tokens = tk.Count(string(data))
 	} else {
-		density = 2.2
+		var density float64
+		if toolCount == 1 {
+			density = 1.6
+		} else if toolCount <= 5 {
+			density = 1.9
+		} else {
+			density = 2.2
+		}
+		tokens = int(float64(len(data)) / density)
 	}
 
-	tokens := int(float64(len(data)) / density)
-
 	if strings.Contains(string(data), "$schema") {
 		if toolCount == 1 {
 			tokens += 15
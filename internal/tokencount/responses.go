@@ -0,0 +1,80 @@
+package tokencount
+
+// ResponsesInputTokensRequest is the subset of an OpenAI Responses request that is counted
+type ResponsesInputTokensRequest struct {
+	Model        string `json:"model"`
+	Instructions any    `json:"instructions,omitempty"`
+	Input        any    `json:"input"`
+	Tools        []any  `json:"tools,omitempty"`
+}
+
+// EstimateResponsesInputTokens estimates input tokens for a Responses request
+func EstimateResponsesInputTokens(req *ResponsesInputTokensRequest) int {
+	tk := ForModel(req.Model)
+	tokens := 10 // Base request overhead
+
+	if req.Instructions != nil {
+		tokens += estimateAny(tk, req.Instructions) + 5
+	}
+
+	switch input := req.Input.(type) {
+	case string:
+		tokens += 10 + tk.Count(input)
+	case []any:
+		for _, item := range input {
+			tokens += 10 + estimateResponsesItem(tk, item)
+		}
+	}
+
+	if len(req.Tools) > 0 {
+		tokens += estimateTools(tk, responsesTools(req.Tools))
+	}
+
+	return tokens
+}
+
+// estimateResponsesItem estimates a Responses input item: messages count their content,
+// function calls and outputs their arguments and output
+func estimateResponsesItem(tk *Tokenizer, item any) int {
+	m, ok := item.(map[string]any)
+	if !ok {
+		return tk.countJSON(item)
+	}
+
+	if content, ok := m["content"]; ok {
+		return estimateAny(tk, content)
+	}
+	switch m["type"] {
+	case "function_call":
+		name, _ := m["name"].(string)
+		arguments, _ := m["arguments"].(string)
+		return tk.Count(name) + tk.Count(arguments)
+	case "function_call_output":
+		if output, ok := m["output"].(string); ok {
+			return tk.Count(output)
+		}
+		return estimateAny(tk, m["output"])
+	}
+	return tk.countJSON(item)
+}
+
+// responsesTools converts Responses function tools to the tool shape used for estimation.
+// Built-in tools such as web_search are counted by name only.
+func responsesTools(tools []any) []Tool {
+	result := make([]Tool, 0, len(tools))
+	for _, t := range tools {
+		m, ok := t.(map[string]any)
+		if !ok {
+			continue
+		}
+		tool := Tool{}
+		tool.Name, _ = m["name"].(string)
+		if tool.Name == "" {
+			tool.Name, _ = m["type"].(string)
+		}
+		tool.Description, _ = m["description"].(string)
+		tool.InputSchema = m["parameters"]
+		result = append(result, tool)
+	}
+	return result
+}
@@ -0,0 +1,165 @@
+package tokencount
+
+import (
+	"compress/gzip"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/lich0821/ccNexus/internal/logger"
+)
+
+// vocabFS holds the gzip-compressed vocabularies bundled into the binary (vocab/<encoding>.tiktoken.gz)
+//
+//go:embed vocab/*.tiktoken.gz
+var vocabFS embed.FS
+
+const (
+	EncodingCl100k = "cl100k_base"
+	EncodingO200k  = "o200k_base"
+)
+
+var encodingPatterns = map[string]string{
+	EncodingCl100k: cl100kPattern,
+	EncodingO200k:  o200kPattern,
+}
+
+// claudeTokenRatio scales cl100k counts to approximate Claude's tokenizer, which is not public
+// and splits English and code into noticeably more tokens than cl100k
+const claudeTokenRatio = 1.15
+
+// o200kModelPrefixes lists OpenAI model families using o200k_base. Everything else with a gpt- or
+// text-embedding- prefix uses cl100k_base.
+var o200kModelPrefixes = []string{"gpt-4o", "chatgpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "gpt-oss", "o1", "o3", "o4", "codex"}
+
+var cl100kModelPrefixes = []string{"gpt-4", "gpt-3.5", "gpt-35", "text-embedding-"}
+
+// Tokenizer counts tokens for a model family. A nil Tokenizer falls back to the heuristic estimate.
+type Tokenizer struct {
+	encoding *Encoding
+	scale    float64
+}
+
+// ForModel returns the tokenizer for a model, or nil when the model is unknown
+// or its vocabulary is not available
+func ForModel(model string) *Tokenizer {
+	name, scale := encodingForModel(model)
+	if name == "" {
+		return nil
+	}
+	enc := GetEncoding(name)
+	if enc == nil {
+		return nil
+	}
+	return &Tokenizer{encoding: enc, scale: scale}
+}
+
+// encodingForModel maps a model name to an encoding name and a count scale
+func encodingForModel(model string) (string, float64) {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:] // provider prefixes such as "openai/gpt-4o"
+	}
+
+	if strings.Contains(model, "claude") {
+		return EncodingCl100k, claudeTokenRatio
+	}
+	for _, prefix := range o200kModelPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return EncodingO200k, 1
+		}
+	}
+	for _, prefix := range cl100kModelPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return EncodingCl100k, 1
+		}
+	}
+	return "", 0
+}
+
+// Count returns the number of tokens in text
+func (t *Tokenizer) Count(text string) int {
+	if t == nil {
+		return estimateText(text)
+	}
+	if text == "" {
+		return 0
+	}
+	count := t.encoding.Count(text)
+	if t.scale != 1 {
+		count = int(math.Ceil(float64(count) * t.scale))
+	}
+	return count
+}
+
+// countJSON returns the number of tokens in a value serialized as JSON
+func (t *Tokenizer) countJSON(v any) int {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	if t == nil {
+		return len(data) / 4
+	}
+	return t.Count(string(data))
+}
+
+type encodingEntry struct {
+	once sync.Once
+	enc  *Encoding
+}
+
+var (
+	encodingsMu sync.Mutex
+	encodings   = map[string]*encodingEntry{}
+)
+
+// GetEncoding returns a loaded encoding by name, or nil when its vocabulary is unavailable.
+// Vocabularies are decompressed from the embedded files once, on first use.
+func GetEncoding(name string) *Encoding {
+	encodingsMu.Lock()
+	entry, ok := encodings[name]
+	if !ok {
+		entry = &encodingEntry{}
+		encodings[name] = entry
+	}
+	encodingsMu.Unlock()
+
+	entry.once.Do(func() {
+		enc, err := loadEncoding(name)
+		if err != nil {
+			logger.Debug("Tokenizer %s unavailable, using heuristic estimate: %v", name, err)
+			return
+		}
+		entry.enc = enc
+	})
+	return entry.enc
+}
+
+func loadEncoding(name string) (*Encoding, error) {
+	pattern, ok := encodingPatterns[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding: %s", name)
+	}
+
+	file := name + ".tiktoken"
+	f, err := vocabFS.Open("vocab/" + file + ".gz")
+	if err != nil {
+		return nil, fmt.Errorf("vocabulary %s not embedded", file)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	defer r.Close()
+
+	ranks, err := ParseTiktokenRanks(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return NewEncoding(name, ranks, pattern)
+}
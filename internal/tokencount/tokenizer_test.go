@@ -0,0 +1,186 @@
+package tokencount
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testEncoding builds a tiny cl100k-style encoding: all single bytes plus a few merges
+func testEncoding(t testing.TB) *Encoding {
+	t.Helper()
+	ranks := make(map[string]int)
+	for i := 0; i < 256; i++ {
+		ranks[string([]byte{byte(i)})] = i
+	}
+	for i, merge := range []string{"he", "ll", "hell", "hello", " w", "or", " wor", "ld", " world"} {
+		ranks[merge] = 256 + i
+	}
+	enc, err := NewEncoding("test", ranks, cl100kPattern)
+	if err != nil {
+		t.Fatalf("NewEncoding failed: %v", err)
+	}
+	return enc
+}
+
+func TestEncodingBytePairMerge(t *testing.T) {
+	enc := testEncoding(t)
+
+	got := enc.Encode("hello world")
+	want := []int{259, 264}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Encode(hello world) = %v, want %v", got, want)
+	}
+
+	// "help" only merges up to "he" + "l" + "p"
+	got = enc.Encode("help")
+	want = []int{256, 'l', 'p'}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Encode(help) = %v, want %v", got, want)
+	}
+
+	if n := enc.Count("hello world, hello"); n != 5 {
+		t.Fatalf("Count = %d, want 5", n)
+	}
+	if n := enc.Count(""); n != 0 {
+		t.Fatalf("Count(\"\") = %d, want 0", n)
+	}
+}
+
+func TestEncodingMultibyte(t *testing.T) {
+	enc := testEncoding(t)
+	// Without merges every UTF-8 byte is one token
+	if n := enc.Count("你好"); n != 6 {
+		t.Fatalf("Count(你好) = %d, want 6", n)
+	}
+}
+
+func TestParseTiktokenRanks(t *testing.T) {
+	ranks, err := ParseTiktokenRanks(strings.NewReader("aGVsbG8= 0\nIHdvcmxk 1\n\n"))
+	if err != nil {
+		t.Fatalf("ParseTiktokenRanks failed: %v", err)
+	}
+	want := map[string]int{"hello": 0, " world": 1}
+	if !reflect.DeepEqual(ranks, want) {
+		t.Fatalf("ranks = %v, want %v", ranks, want)
+	}
+
+	if _, err := ParseTiktokenRanks(strings.NewReader("aGVsbG8=\n")); err == nil {
+		t.Fatal("expected error for line without rank")
+	}
+}
+
+func TestEncodingForModel(t *testing.T) {
+	tests := []struct {
+		model    string
+		encoding string
+		scale    float64
+	}{
+		{"gpt-4o-mini", EncodingO200k, 1},
+		{"openai/gpt-4.1", EncodingO200k, 1},
+		{"gpt-5-codex", EncodingO200k, 1},
+		{"o3-mini", EncodingO200k, 1},
+		{"gpt-4-turbo", EncodingCl100k, 1},
+		{"gpt-3.5-turbo", EncodingCl100k, 1},
+		{"claude-sonnet-4-5-20250929", EncodingCl100k, claudeTokenRatio},
+		{"anthropic/claude-3-haiku", EncodingCl100k, claudeTokenRatio},
+		{"gemini-2.5-pro", "", 0},
+		{"deepseek-chat", "", 0},
+	}
+	for _, tt := range tests {
+		name, scale := encodingForModel(tt.model)
+		if name != tt.encoding || scale != tt.scale {
+			t.Errorf("encodingForModel(%q) = %q, %v; want %q, %v", tt.model, name, scale, tt.encoding, tt.scale)
+		}
+	}
+}
+
+func TestTokenizerFallback(t *testing.T) {
+	if tk := ForModel("gemini-2.5-pro"); tk != nil {
+		t.Fatal("expected no tokenizer for unknown model")
+	}
+	text := strings.Repeat("hello world ", 10)
+	if got, want := EstimateOutputTokens("gemini-2.5-pro", text), estimateText(text); got != want {
+		t.Fatalf("EstimateOutputTokens = %d, want heuristic %d", got, want)
+	}
+}
+
+func TestTokenizerScale(t *testing.T) {
+	tk := &Tokenizer{encoding: testEncoding(t), scale: 1.5}
+	if n := tk.Count("hello world"); n != 3 {
+		t.Fatalf("Count = %d, want 3", n)
+	}
+}
+
+func TestBundledVocabularies(t *testing.T) {
+	tests := []struct {
+		encoding string
+		text     string
+		want     []int
+	}{
+		{EncodingCl100k, "hello world", []int{15339, 1917}},
+		{EncodingCl100k, "tiktoken is great!", []int{83, 1609, 5963, 374, 2294, 0}},
+		{EncodingO200k, "hello world", []int{24912, 2375}},
+	}
+	for _, tt := range tests {
+		enc := GetEncoding(tt.encoding)
+		if enc == nil {
+			t.Fatalf("%s vocabulary is not embedded", tt.encoding)
+		}
+		if got := enc.Encode(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s Encode(%q) = %v, want %v", tt.encoding, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestForModelUsesVocabulary(t *testing.T) {
+	text := "The quick brown fox jumps over the lazy dog."
+	if n := ForModel("gpt-4o").Count(text); n != 10 {
+		t.Errorf("gpt-4o Count = %d, want 10", n)
+	}
+	if n := ForModel("gpt-4").Count(text); n != 10 {
+		t.Errorf("gpt-4 Count = %d, want 10", n)
+	}
+	if n := ForModel("claude-sonnet-4").Count(text); n != 12 {
+		t.Errorf("claude-sonnet-4 Count = %d, want 12 (cl100k scaled)", n)
+	}
+}
+
+var benchText = strings.Repeat("The quick brown fox jumps over the lazy dog. 敏捷的棕色狐狸跳过了懒狗。\n"+
+	"func main() { fmt.Println(\"hello, world\") }\n", 200)
+
+func BenchmarkHeuristic(b *testing.B) {
+	b.SetBytes(int64(len(benchText)))
+	for i := 0; i < b.N; i++ {
+		estimateText(benchText)
+	}
+}
+
+func BenchmarkBPETestVocabulary(b *testing.B) {
+	enc := testEncoding(b)
+	b.SetBytes(int64(len(benchText)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		enc.Count(benchText)
+	}
+}
+
+func BenchmarkCl100k(b *testing.B) {
+	benchmarkEncoding(b, EncodingCl100k)
+}
+
+func BenchmarkO200k(b *testing.B) {
+	benchmarkEncoding(b, EncodingO200k)
+}
+
+func benchmarkEncoding(b *testing.B, name string) {
+	enc := GetEncoding(name)
+	if enc == nil {
+		b.Fatalf("%s vocabulary is not embedded", name)
+	}
+	b.SetBytes(int64(len(benchText)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		enc.Count(benchText)
+	}
+}
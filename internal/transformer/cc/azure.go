@@ -0,0 +1,30 @@
+package cc
+
+// AzureTransformer transforms Claude Code requests to Azure OpenAI Chat format.
+// The wire format is identical to OpenAI Chat; only routing and auth differ.
+type AzureTransformer struct {
+	*OpenAITransformer
+}
+
+// NewAzureTransformer creates a new transformer
+func NewAzureTransformer(deployment string) *AzureTransformer {
+	return &AzureTransformer{OpenAITransformer: NewOpenAITransformer(deployment)}
+}
+
+func (t *AzureTransformer) Name() string {
+	return "cc_azure"
+}
+
+// Azure2Transformer transforms Claude Code requests to Azure OpenAI Responses format
+type Azure2Transformer struct {
+	*OpenAI2Transformer
+}
+
+// NewAzure2Transformer creates a new transformer
+func NewAzure2Transformer(deployment string) *Azure2Transformer {
+	return &Azure2Transformer{OpenAI2Transformer: NewOpenAI2Transformer(deployment)}
+}
+
+func (t *Azure2Transformer) Name() string {
+	return "cc_azure2"
+}
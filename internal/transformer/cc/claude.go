@@ -76,7 +76,7 @@ func (t *ClaudeTransformer) TransformResponseWithContext(resp []byte, isStreamin
 				} else if eventType == "message_delta" {
 					// Fallback: fill input_tokens if 0
 					if usage, ok := event["usage"].(map[string]interface{}); ok {
-						if input, ok := usage["input_tokens"].(float64); ok && int(input) == 0 && ctx.InputTokens > 0 {
+						if input, ok := usage["input_tokens"].(float64); ok && int(input) == 0 && ctx.EstimatedInputTokens() > 0 {
 							usage["input_tokens"] = ctx.InputTokens
 							modified, _ := json.Marshal(event)
 							result.WriteString("data: ")
@@ -407,10 +407,16 @@ func GeminiStreamToClaude(event []byte, ctx *transformer.StreamContext) ([]byte,
 				ctx.ContentIndex++
 			}
 			// Handle function call
+			toolID := fmt.Sprintf("call_%s", part.FunctionCall.Name)
+			if ctx.ToolRepair {
+				result = append(result, toolBlockEvents(ctx, ctx.ContentIndex, toolID, part.FunctionCall.Name, part.FunctionCall.Args)...)
+				ctx.ContentIndex++
+				continue
+			}
 			result = append(result, buildClaudeEvent("content_block_start", map[string]interface{}{
 				"index": ctx.ContentIndex,
 				"content_block": map[string]interface{}{
-					"type": "tool_use", "id": fmt.Sprintf("call_%s", part.FunctionCall.Name), "name": part.FunctionCall.Name,
+					"type": "tool_use", "id": toolID, "name": part.FunctionCall.Name,
 				},
 			})...)
 			args, _ := json.Marshal(part.FunctionCall.Args)
@@ -429,7 +435,7 @@ func GeminiStreamToClaude(event []byte, ctx *transformer.StreamContext) ([]byte,
 			ctx.ContentBlockStarted = false
 		}
 		stopReason := "end_turn"
-		if hasFunctionCall || candidate.FinishReason == "TOOL_CODE" {
+		if (hasFunctionCall || candidate.FinishReason == "TOOL_CODE") && (!ctx.ToolRepair || ctx.ToolUseEmitted) {
 			stopReason = "tool_use"
 		}
 		result = append(result, buildClaudeEvent("message_delta", map[string]interface{}{
@@ -0,0 +1,342 @@
+package convert
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/lich0821/ccNexus/internal/transformer"
+)
+
+// ClaudeReqToOllama converts Claude request to Ollama native /api/chat request
+func ClaudeReqToOllama(claudeReq []byte, model string) ([]byte, error) {
+	var req transformer.ClaudeRequest
+	if err := json.Unmarshal(claudeReq, &req); err != nil {
+		return nil, err
+	}
+
+	var messages []transformer.OllamaMessage
+
+	// Convert system prompt
+	if req.System != nil {
+		systemText := extractSystemText(req.System)
+		if systemText != "" {
+			messages = append(messages, transformer.OllamaMessage{Role: "system", Content: systemText})
+		}
+	}
+
+	// Convert messages
+	toolUseIDToName := make(map[string]string) // Ollama identifies tool results by name
+	for _, msg := range req.Messages {
+		switch content := msg.Content.(type) {
+		case string:
+			messages = append(messages, transformer.OllamaMessage{Role: msg.Role, Content: content})
+		case []interface{}:
+			ollamaMsg := transformer.OllamaMessage{Role: msg.Role}
+			var textParts, thinkingParts []string
+			var toolResults []transformer.OllamaMessage
+
+			for _, block := range content {
+				m, ok := block.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				switch m["type"] {
+				case "text":
+					if text, ok := m["text"].(string); ok {
+						textParts = append(textParts, text)
+					}
+				case "thinking":
+					if thinking, ok := m["thinking"].(string); ok && thinking != "" {
+						thinkingParts = append(thinkingParts, thinking)
+					}
+				case "image":
+					if source, ok := m["source"].(map[string]interface{}); ok && source["type"] == "base64" {
+						if data, ok := source["data"].(string); ok && data != "" {
+							ollamaMsg.Images = append(ollamaMsg.Images, data)
+						}
+					}
+				case "tool_use":
+					id, _ := m["id"].(string)
+					name, _ := m["name"].(string)
+					if name == "" {
+						continue
+					}
+					if id != "" {
+						toolUseIDToName[id] = name
+					}
+					var tc transformer.OllamaToolCall
+					tc.Function.Name = name
+					if input, ok := m["input"].(map[string]interface{}); ok {
+						tc.Function.Arguments = input
+					} else {
+						tc.Function.Arguments = map[string]interface{}{}
+					}
+					ollamaMsg.ToolCalls = append(ollamaMsg.ToolCalls, tc)
+				case "tool_result":
+					toolUseID, _ := m["tool_use_id"].(string)
+					toolResults = append(toolResults, transformer.OllamaMessage{
+						Role:     "tool",
+						Content:  extractToolResultContent(m["content"]),
+						ToolName: toolUseIDToName[toolUseID],
+					})
+				}
+			}
+
+			// Tool results come from the user turn and must precede any new user text
+			messages = append(messages, toolResults...)
+
+			ollamaMsg.Content = strings.Join(textParts, "")
+			ollamaMsg.Thinking = strings.Join(thinkingParts, "")
+			if ollamaMsg.Content != "" || ollamaMsg.Thinking != "" || len(ollamaMsg.Images) > 0 || len(ollamaMsg.ToolCalls) > 0 {
+				messages = append(messages, ollamaMsg)
+			}
+		}
+	}
+
+	ollamaReq := transformer.OllamaRequest{
+		Model:    model,
+		Messages: messages,
+		Stream:   req.Stream,
+	}
+
+	// Generation options
+	options := map[string]interface{}{}
+	if req.MaxTokens > 0 {
+		options["num_predict"] = req.MaxTokens
+	}
+	if req.Temperature > 0 {
+		options["temperature"] = req.Temperature
+	}
+	if len(options) > 0 {
+		ollamaReq.Options = options
+	}
+
+	// Map Claude extended thinking to Ollama think flag
+	if thinking, ok := req.Thinking.(map[string]interface{}); ok {
+		switch thinking["type"] {
+		case "enabled":
+			enabled := true
+			ollamaReq.Think = &enabled
+		case "disabled":
+			disabled := false
+			ollamaReq.Think = &disabled
+		}
+	}
+
+	// Convert tools (Ollama has no tool_choice; "none" drops the tools)
+	toolChoiceNone := false
+	if tc, ok := req.ToolChoice.(map[string]interface{}); ok && tc["type"] == "none" {
+		toolChoiceNone = true
+	}
+	if len(req.Tools) > 0 && !toolChoiceNone {
+		for _, tool := range req.Tools {
+			ollamaReq.Tools = append(ollamaReq.Tools, transformer.OpenAITool{
+				Type: "function",
+				Function: struct {
+					Name        string                 `json:"name"`
+					Description string                 `json:"description,omitempty"`
+					Parameters  map[string]interface{} `json:"parameters"`
+				}{
+					Name:        tool.Name,
+					Description: tool.Description,
+					Parameters:  tool.InputSchema,
+				},
+			})
+		}
+	}
+
+	return json.Marshal(ollamaReq)
+}
+
+// OllamaRespToClaude converts Ollama /api/chat response to Claude response
+func OllamaRespToClaude(ollamaResp []byte) ([]byte, error) {
+	var resp transformer.OllamaResponse
+	if err := json.Unmarshal(ollamaResp, &resp); err != nil {
+		return nil, err
+	}
+
+	content := make([]map[string]interface{}, 0) // Initialize as empty array, not nil
+	stopReason := ollamaStopReason(resp.DoneReason)
+
+	if resp.Message.Thinking != "" {
+		content = append(content, map[string]interface{}{"type": "thinking", "thinking": resp.Message.Thinking})
+	}
+	if resp.Message.Content != "" {
+		content = append(content, splitThinkTaggedText(resp.Message.Content)...)
+	}
+	for _, tc := range resp.Message.ToolCalls {
+		args := tc.Function.Arguments
+		if args == nil {
+			args = map[string]interface{}{}
+		}
+		content = append(content, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    newOllamaID("toolu_"),
+			"name":  tc.Function.Name,
+			"input": args,
+		})
+		stopReason = "tool_use"
+	}
+
+	claudeResp := map[string]interface{}{
+		"id":          newOllamaID("msg_"),
+		"type":        "message",
+		"role":        "assistant",
+		"content":     content,
+		"model":       resp.Model,
+		"stop_reason": stopReason,
+		"usage": map[string]interface{}{
+			"input_tokens":  resp.PromptEvalCount,
+			"output_tokens": resp.EvalCount,
+		},
+	}
+
+	return json.Marshal(claudeResp)
+}
+
+// OllamaStreamToClaude converts one Ollama NDJSON stream line to Claude SSE events
+func OllamaStreamToClaude(event []byte, ctx *transformer.StreamContext) ([]byte, error) {
+	line := strings.TrimSpace(string(event))
+	if line == "" {
+		return nil, nil
+	}
+
+	var chunk transformer.OllamaResponse
+	if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+		return nil, nil
+	}
+
+	var result []byte
+
+	// message_start
+	if !ctx.MessageStartSent {
+		ctx.MessageStartSent = true
+		ctx.MessageID = newOllamaID("msg_")
+		result = append(result, buildClaudeEvent("message_start", map[string]interface{}{
+			"message": map[string]interface{}{
+				"id": ctx.MessageID, "type": "message", "role": "assistant", "content": []interface{}{},
+				"model": ctx.ModelName, "stop_reason": nil, "stop_sequence": nil,
+				"usage": map[string]interface{}{"input_tokens": 0, "output_tokens": 0},
+			},
+		})...)
+	}
+
+	// Native thinking output
+	if chunk.Message.Thinking != "" {
+		if !ctx.ThinkingBlockStarted {
+			ctx.ThinkingBlockStarted = true
+			ctx.ThinkingIndex = ctx.ContentIndex
+			ctx.ContentIndex++
+			result = append(result, buildClaudeEvent("content_block_start", map[string]interface{}{
+				"index": ctx.ThinkingIndex, "content_block": map[string]interface{}{"type": "thinking", "thinking": ""},
+			})...)
+		}
+		result = append(result, buildClaudeEvent("content_block_delta", map[string]interface{}{
+			"index": ctx.ThinkingIndex, "delta": map[string]interface{}{"type": "thinking_delta", "thinking": chunk.Message.Thinking},
+		})...)
+	}
+
+	// Text content (models without native thinking may still emit <think> tags)
+	if chunk.Message.Content != "" {
+		content := ctx.ThinkingBuffer + chunk.Message.Content
+		ctx.ThinkingBuffer = ""
+
+		emitText, emitThinking := makeThinkEmitters(ctx, &result)
+		emitTextWithClose := func(text string) {
+			if text == "" {
+				return
+			}
+			if ctx.ThinkingBlockStarted && !ctx.ContentBlockStarted && !ctx.InThinkingTag {
+				result = append(result, buildClaudeEvent("content_block_stop", map[string]interface{}{"index": ctx.ThinkingIndex})...)
+				ctx.ThinkingBlockStarted = false
+			}
+			emitText(text)
+		}
+		emitThinkingWithClose := func(text string) {
+			if text == "" {
+				return
+			}
+			emitThinking(text)
+			if ctx.ThinkingBlockStarted {
+				result = append(result, buildClaudeEvent("content_block_stop", map[string]interface{}{"index": ctx.ThinkingIndex})...)
+				ctx.ThinkingBlockStarted = false
+			}
+		}
+
+		consumeThinkTaggedStream(content, ctx, emitTextWithClose, emitThinkingWithClose)
+	}
+
+	// Tool calls arrive complete in a single chunk
+	for _, tc := range chunk.Message.ToolCalls {
+		if ctx.ThinkingBlockStarted {
+			result = append(result, buildClaudeEvent("content_block_stop", map[string]interface{}{"index": ctx.ThinkingIndex})...)
+			ctx.ThinkingBlockStarted = false
+		}
+		if ctx.ContentBlockStarted {
+			result = append(result, buildClaudeEvent("content_block_stop", map[string]interface{}{"index": ctx.ContentIndex})...)
+			ctx.ContentBlockStarted = false
+			ctx.ContentIndex++
+		}
+		args := tc.Function.Arguments
+		if args == nil {
+			args = map[string]interface{}{}
+		}
+		argsJSON, _ := json.Marshal(args)
+		result = append(result, buildClaudeEvent("content_block_start", map[string]interface{}{
+			"index": ctx.ContentIndex,
+			"content_block": map[string]interface{}{
+				"type": "tool_use", "id": newOllamaID("toolu_"), "name": tc.Function.Name, "input": map[string]interface{}{},
+			},
+		})...)
+		result = append(result, buildClaudeEvent("content_block_delta", map[string]interface{}{
+			"index": ctx.ContentIndex, "delta": map[string]interface{}{"type": "input_json_delta", "partial_json": string(argsJSON)},
+		})...)
+		result = append(result, buildClaudeEvent("content_block_stop", map[string]interface{}{"index": ctx.ContentIndex})...)
+		ctx.ContentIndex++
+		ctx.ToolCallCounter++
+	}
+
+	// Final chunk carries done_reason and usage
+	if chunk.Done {
+		emitText, emitThinking := makeThinkEmitters(ctx, &result)
+		flushThinkTaggedStream(ctx, emitText, emitThinking)
+		if ctx.ThinkingBlockStarted {
+			result = append(result, buildClaudeEvent("content_block_stop", map[string]interface{}{"index": ctx.ThinkingIndex})...)
+			ctx.ThinkingBlockStarted = false
+		}
+		if ctx.ContentBlockStarted {
+			result = append(result, buildClaudeEvent("content_block_stop", map[string]interface{}{"index": ctx.ContentIndex})...)
+			ctx.ContentBlockStarted = false
+		}
+		stopReason := ollamaStopReason(chunk.DoneReason)
+		if ctx.ToolCallCounter > 0 {
+			stopReason = "tool_use"
+		}
+		result = append(result, buildClaudeEvent("message_delta", map[string]interface{}{
+			"delta": map[string]interface{}{"stop_reason": stopReason, "stop_sequence": nil},
+			"usage": map[string]interface{}{"input_tokens": chunk.PromptEvalCount, "output_tokens": chunk.EvalCount},
+		})...)
+		result = append(result, buildClaudeEvent("message_stop", map[string]interface{}{})...)
+		ctx.FinishReasonSent = true
+	}
+
+	return result, nil
+}
+
+// ollamaStopReason maps Ollama done_reason to Claude stop_reason
+func ollamaStopReason(doneReason string) string {
+	if doneReason == "length" {
+		return "max_tokens"
+	}
+	return "end_turn"
+}
+
+// newOllamaID returns a random message or tool_use ID, since Ollama returns neither. IDs must
+// not repeat across turns, as clients match tool results to tool calls of the whole conversation.
+func newOllamaID(prefix string) string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return prefix + hex.EncodeToString(buf)
+}
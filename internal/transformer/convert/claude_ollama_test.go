@@ -0,0 +1,263 @@
+package convert
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/lich0821/ccNexus/internal/transformer"
+)
+
+func TestClaudeReqToOllamaToolsImagesThinking(t *testing.T) {
+	claudeReq := `{
+		"model": "claude-sonnet-4",
+		"max_tokens": 256,
+		"stream": true,
+		"system": "Be brief",
+		"thinking": {"type": "enabled", "budget_tokens": 1024},
+		"tools": [{"name": "get_weather", "description": "Weather", "input_schema": {"type": "object"}}],
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is this?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "aGVsbG8="}}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "Need weather"},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": "Sunny"}
+			]}
+		]
+	}`
+
+	ollamaReqBytes, err := ClaudeReqToOllama([]byte(claudeReq), "qwen3:8b")
+	if err != nil {
+		t.Fatalf("ClaudeReqToOllama failed: %v", err)
+	}
+
+	var ollamaReq transformer.OllamaRequest
+	if err := json.Unmarshal(ollamaReqBytes, &ollamaReq); err != nil {
+		t.Fatalf("Failed to unmarshal Ollama request: %v", err)
+	}
+
+	if ollamaReq.Model != "qwen3:8b" || !ollamaReq.Stream {
+		t.Fatalf("Unexpected model/stream: %s %v", ollamaReq.Model, ollamaReq.Stream)
+	}
+	if ollamaReq.Think == nil || !*ollamaReq.Think {
+		t.Fatalf("Expected think to be enabled")
+	}
+	if ollamaReq.Options["num_predict"] != float64(256) {
+		t.Fatalf("Expected num_predict 256, got %v", ollamaReq.Options["num_predict"])
+	}
+	if len(ollamaReq.Tools) != 1 || ollamaReq.Tools[0].Function.Name != "get_weather" {
+		t.Fatalf("Expected get_weather tool, got %+v", ollamaReq.Tools)
+	}
+	if len(ollamaReq.Messages) != 4 {
+		t.Fatalf("Expected 4 messages, got %d", len(ollamaReq.Messages))
+	}
+
+	user := ollamaReq.Messages[1]
+	if user.Content != "What is this?" || len(user.Images) != 1 || user.Images[0] != "aGVsbG8=" {
+		t.Fatalf("Unexpected user message: %+v", user)
+	}
+
+	assistant := ollamaReq.Messages[2]
+	if assistant.Thinking != "Need weather" {
+		t.Fatalf("Expected assistant thinking, got %q", assistant.Thinking)
+	}
+	if len(assistant.ToolCalls) != 1 || assistant.ToolCalls[0].Function.Arguments["city"] != "Paris" {
+		t.Fatalf("Unexpected tool calls: %+v", assistant.ToolCalls)
+	}
+
+	tool := ollamaReq.Messages[3]
+	if tool.Role != "tool" || tool.ToolName != "get_weather" || tool.Content != "Sunny" {
+		t.Fatalf("Unexpected tool message: %+v", tool)
+	}
+}
+
+func TestOllamaRespToClaudeWithToolCall(t *testing.T) {
+	ollamaResp := `{
+		"model": "qwen3:8b",
+		"message": {
+			"role": "assistant",
+			"content": "",
+			"thinking": "Check weather",
+			"tool_calls": [{"function": {"name": "get_weather", "arguments": {"city": "Paris"}}}]
+		},
+		"done": true,
+		"done_reason": "stop",
+		"prompt_eval_count": 12,
+		"eval_count": 7
+	}`
+
+	claudeRespBytes, err := OllamaRespToClaude([]byte(ollamaResp))
+	if err != nil {
+		t.Fatalf("OllamaRespToClaude failed: %v", err)
+	}
+
+	var claudeResp map[string]interface{}
+	if err := json.Unmarshal(claudeRespBytes, &claudeResp); err != nil {
+		t.Fatalf("Failed to unmarshal Claude response: %v", err)
+	}
+
+	if claudeResp["stop_reason"] != "tool_use" {
+		t.Fatalf("Expected stop_reason tool_use, got %v", claudeResp["stop_reason"])
+	}
+	content := claudeResp["content"].([]interface{})
+	if len(content) != 2 {
+		t.Fatalf("Expected 2 content blocks, got %d", len(content))
+	}
+	if content[0].(map[string]interface{})["type"] != "thinking" {
+		t.Fatalf("Expected first block thinking, got %v", content[0])
+	}
+	toolUse := content[1].(map[string]interface{})
+	if toolUse["type"] != "tool_use" || toolUse["name"] != "get_weather" {
+		t.Fatalf("Expected get_weather tool_use, got %v", toolUse)
+	}
+	usage := claudeResp["usage"].(map[string]interface{})
+	if usage["input_tokens"] != float64(12) || usage["output_tokens"] != float64(7) {
+		t.Fatalf("Unexpected usage: %v", usage)
+	}
+}
+
+func TestOllamaStreamToClaude(t *testing.T) {
+	ctx := transformer.NewStreamContext()
+	ctx.ModelName = "claude-sonnet-4"
+
+	lines := []string{
+		`{"model":"qwen3:8b","message":{"role":"assistant","content":"","thinking":"Hmm"},"done":false}`,
+		`{"model":"qwen3:8b","message":{"role":"assistant","content":"Hel"},"done":false}`,
+		`{"model":"qwen3:8b","message":{"role":"assistant","content":"lo"},"done":false}`,
+		`{"model":"qwen3:8b","message":{"role":"assistant","content":""},"done":true,"done_reason":"length","prompt_eval_count":5,"eval_count":3}`,
+	}
+
+	var allEvents []string
+	for _, line := range lines {
+		events, err := OllamaStreamToClaude([]byte(line+"\n"), ctx)
+		if err != nil {
+			t.Fatalf("OllamaStreamToClaude failed: %v", err)
+		}
+		if events != nil {
+			allEvents = append(allEvents, string(events))
+		}
+	}
+
+	fullEvents := strings.Join(allEvents, "")
+	assertContains(t, fullEvents, "event: message_start", "Expected message_start")
+	assertContains(t, fullEvents, "\"thinking\":\"Hmm\"", "Expected thinking delta")
+	assertContains(t, fullEvents, "\"text\":\"Hel\"", "Expected first text delta")
+	assertContains(t, fullEvents, "\"text\":\"lo\"", "Expected second text delta")
+	assertContains(t, fullEvents, "\"stop_reason\":\"max_tokens\"", "Expected max_tokens stop reason")
+	assertContains(t, fullEvents, "\"output_tokens\":3", "Expected output usage")
+	assertContains(t, fullEvents, "event: message_stop", "Expected message_stop")
+	if strings.Count(fullEvents, "event: content_block_stop") != 2 {
+		t.Fatalf("Expected 2 content_block_stop events, got: %s", fullEvents)
+	}
+}
+
+func TestOllamaStreamToClaudeToolCall(t *testing.T) {
+	ctx := transformer.NewStreamContext()
+
+	lines := []string{
+		`{"model":"qwen3:8b","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Paris"}}}]},"done":false}`,
+		`{"model":"qwen3:8b","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}`,
+	}
+
+	var allEvents []string
+	for _, line := range lines {
+		events, err := OllamaStreamToClaude([]byte(line), ctx)
+		if err != nil {
+			t.Fatalf("OllamaStreamToClaude failed: %v", err)
+		}
+		allEvents = append(allEvents, string(events))
+	}
+
+	fullEvents := strings.Join(allEvents, "")
+	assertContains(t, fullEvents, "\"type\":\"tool_use\"", "Expected tool_use block")
+	assertContains(t, fullEvents, "\"name\":\"get_weather\"", "Expected tool name")
+	assertContains(t, fullEvents, "\"partial_json\":\"{\\\"city\\\":\\\"Paris\\\"}\"", "Expected tool arguments")
+	assertContains(t, fullEvents, "\"stop_reason\":\"tool_use\"", "Expected tool_use stop reason")
+}
+
+func TestOllamaToolCallIDsAreUnique(t *testing.T) {
+	ollamaResp := []byte(`{"model":"qwen3:8b","message":{"role":"assistant","content":"","tool_calls":[
+		{"function":{"name":"get_weather","arguments":{"city":"Paris"}}},
+		{"function":{"name":"get_weather","arguments":{"city":"Rome"}}}]},"done":true}`)
+
+	seen := make(map[string]bool)
+	for turn := 0; turn < 2; turn++ {
+		claudeRespBytes, err := OllamaRespToClaude(ollamaResp)
+		if err != nil {
+			t.Fatalf("OllamaRespToClaude failed: %v", err)
+		}
+		var claudeResp struct {
+			ID      string `json:"id"`
+			Content []struct {
+				ID string `json:"id"`
+			} `json:"content"`
+		}
+		json.Unmarshal(claudeRespBytes, &claudeResp)
+		ids := []string{claudeResp.ID}
+		for _, block := range claudeResp.Content {
+			ids = append(ids, block.ID)
+		}
+		for _, id := range ids {
+			if id == "" || seen[id] {
+				t.Fatalf("Expected unique IDs across turns, got %q again", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestOllamaStreamToOpenAIToolCall(t *testing.T) {
+	ctx := transformer.NewStreamContext()
+
+	lines := []string{
+		`{"model":"qwen3:8b","message":{"role":"assistant","content":"Let me check"},"done":false}`,
+		`{"model":"qwen3:8b","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Paris"}}}]},"done":false}`,
+		`{"model":"qwen3:8b","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}`,
+	}
+
+	var allChunks []string
+	for _, line := range lines {
+		chunks, err := OllamaStreamToOpenAI([]byte(line), ctx, "qwen3:8b")
+		if err != nil {
+			t.Fatalf("OllamaStreamToOpenAI failed: %v", err)
+		}
+		allChunks = append(allChunks, string(chunks))
+	}
+
+	fullChunks := strings.Join(allChunks, "")
+	assertContains(t, fullChunks, "\"content\":\"Let me check\"", "Expected text chunk")
+	assertContains(t, fullChunks, "\"name\":\"get_weather\"", "Expected tool call chunk")
+	assertContains(t, fullChunks, "\"arguments\":\"{\\\"city\\\":\\\"Paris\\\"}\"", "Expected tool arguments")
+	assertContains(t, fullChunks, "\"finish_reason\":\"tool_calls\"", "Expected tool_calls finish reason")
+	assertContains(t, fullChunks, "data: [DONE]", "Expected stream end")
+}
+
+func TestOpenAI2ReqToOllama(t *testing.T) {
+	openai2Req := `{
+		"model": "qwen3:8b",
+		"instructions": "Be brief",
+		"input": [{"type": "message", "role": "user", "content": [{"type": "input_text", "text": "Hi"}]}],
+		"stream": true
+	}`
+
+	ollamaReqBytes, err := OpenAI2ReqToOllama([]byte(openai2Req), "qwen3:8b")
+	if err != nil {
+		t.Fatalf("OpenAI2ReqToOllama failed: %v", err)
+	}
+
+	var ollamaReq transformer.OllamaRequest
+	if err := json.Unmarshal(ollamaReqBytes, &ollamaReq); err != nil {
+		t.Fatalf("Failed to unmarshal Ollama request: %v", err)
+	}
+	if !ollamaReq.Stream || len(ollamaReq.Messages) != 2 {
+		t.Fatalf("Unexpected Ollama request: %s", ollamaReqBytes)
+	}
+	if ollamaReq.Messages[0].Role != "system" || ollamaReq.Messages[1].Content != "Hi" {
+		t.Fatalf("Unexpected messages: %+v", ollamaReq.Messages)
+	}
+}
@@ -261,6 +261,11 @@ func OpenAIReqToClaude(openaiReq []byte, model string) ([]byte, error) {
 		}
 	}
 
+	// Emulate JSON-schema output with a forced tool call
+	if spec := parseChatResponseFormat(req.ResponseFormat); spec != nil {
+		spec.applyToClaude(claudeReq)
+	}
+
 	return json.Marshal(claudeReq)
 }
 
@@ -286,6 +291,10 @@ func ClaudeRespToOpenAI(claudeResp []byte, model string) ([]byte, error) {
 			// Skip thinking blocks in response
 			continue
 		case "tool_use":
+			if isStructuredOutputTool(blockMap["name"]) {
+				textContent += structuredOutputText(blockMap["input"])
+				continue
+			}
 			args, _ := json.Marshal(blockMap["input"])
 			toolCalls = append(toolCalls, map[string]interface{}{
 				"id":   blockMap["id"],
@@ -304,7 +313,7 @@ func ClaudeRespToOpenAI(claudeResp []byte, model string) ([]byte, error) {
 	}
 
 	finishReason := "stop"
-	if resp.StopReason == "tool_use" {
+	if resp.StopReason == "tool_use" && len(toolCalls) > 0 {
 		finishReason = "tool_calls"
 	}
 
@@ -339,8 +348,11 @@ func OpenAIRespToClaude(openaiResp []byte) ([]byte, error) {
 			content = append(content, splitThinkTaggedText(choice.Message.Content)...)
 		}
 		for _, tc := range choice.Message.ToolCalls {
-			var args map[string]interface{}
-			json.Unmarshal([]byte(tc.Function.Arguments), &args)
+			// Malformed arguments are kept as sent so tool repair can still parse them
+			var args interface{}
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+				args = tc.Function.Arguments
+			}
 			content = append(content, map[string]interface{}{
 				"type":  "tool_use",
 				"id":    tc.ID,
@@ -392,6 +404,9 @@ func ClaudeStreamToOpenAI(event []byte, ctx *transformer.StreamContext, model st
 				ctx.ToolBlockStarted = true
 				ctx.CurrentToolID, _ = block["id"].(string)
 				ctx.CurrentToolName, _ = block["name"].(string)
+				if isStructuredOutputTool(ctx.CurrentToolName) {
+					ctx.StructuredOutput = true
+				}
 			}
 		}
 		return nil, nil
@@ -406,11 +421,24 @@ func ClaudeStreamToOpenAI(event []byte, ctx *transformer.StreamContext, model st
 			text, _ := delta["text"].(string)
 			return buildOpenAIChunk(ctx.MessageID, model, text, nil, "")
 		case "input_json_delta":
-			ctx.ToolArguments += delta["partial_json"].(string)
+			partial, _ := delta["partial_json"].(string)
+			if ctx.ToolBlockStarted && isStructuredOutputTool(ctx.CurrentToolName) {
+				// Structured output streams as plain content
+				if partial == "" {
+					return nil, nil
+				}
+				return buildOpenAIChunk(ctx.MessageID, model, partial, nil, "")
+			}
+			ctx.ToolArguments += partial
 		}
 		return nil, nil
 
 	case "content_block_stop":
+		if ctx.ToolBlockStarted && isStructuredOutputTool(ctx.CurrentToolName) {
+			ctx.ToolBlockStarted = false
+			ctx.CurrentToolName = ""
+			return nil, nil
+		}
 		if ctx.ToolBlockStarted {
 			chunk, _ := buildOpenAIChunk(ctx.MessageID, model, "", []map[string]interface{}{
 				{"index": ctx.ContentIndex, "id": ctx.CurrentToolID, "type": "function",
@@ -427,7 +455,7 @@ func ClaudeStreamToOpenAI(event []byte, ctx *transformer.StreamContext, model st
 		if delta, ok := data["delta"].(map[string]interface{}); ok {
 			stopReason, _ := delta["stop_reason"].(string)
 			finish := "stop"
-			if stopReason == "tool_use" {
+			if stopReason == "tool_use" && !(ctx.StructuredOutput && ctx.ContentIndex == 0) {
 				finish = "tool_calls"
 			}
 			return buildOpenAIChunk(ctx.MessageID, model, "", nil, finish)
@@ -459,7 +487,7 @@ func OpenAIStreamToClaude(event []byte, ctx *transformer.StreamContext) ([]byte,
 				ctx.ContentBlockStarted = false
 			}
 			if ctx.ToolBlockStarted {
-				result = append(result, buildClaudeEvent("content_block_stop", map[string]interface{}{"index": ctx.ToolIndex})...)
+				result = append(result, closeToolBlock(ctx)...)
 				ctx.ToolBlockStarted = false
 			}
 			// Send message_delta with stop_reason if not sent
@@ -588,7 +616,7 @@ func OpenAIStreamToClaude(event []byte, ctx *transformer.StreamContext) ([]byte,
 			}
 			// Close previous tool block if open
 			if ctx.ToolBlockStarted {
-				result = append(result, buildClaudeEvent("content_block_stop", map[string]interface{}{"index": ctx.ToolIndex})...)
+				result = append(result, closeToolBlock(ctx)...)
 				ctx.ContentIndex++
 			}
 			ctx.ToolBlockStarted = true
@@ -596,13 +624,19 @@ func OpenAIStreamToClaude(event []byte, ctx *transformer.StreamContext) ([]byte,
 			ctx.CurrentToolID = tc.ID
 			ctx.CurrentToolName = tc.Function.Name
 			ctx.ToolArguments = ""
-			result = append(result, buildClaudeEvent("content_block_start", map[string]interface{}{
-				"index": ctx.ToolIndex, "content_block": map[string]interface{}{"type": "tool_use", "id": tc.ID, "name": tc.Function.Name, "input": map[string]interface{}{}},
-			})...)
+			// With repair on, the block is held back until its arguments are complete
+			if !ctx.ToolRepair {
+				result = append(result, buildClaudeEvent("content_block_start", map[string]interface{}{
+					"index": ctx.ToolIndex, "content_block": map[string]interface{}{"type": "tool_use", "id": tc.ID, "name": tc.Function.Name, "input": map[string]interface{}{}},
+				})...)
+			}
 		}
 		// Accumulate arguments
 		if tc.Function.Arguments != "" {
 			ctx.ToolArguments += tc.Function.Arguments
+			if ctx.ToolRepair {
+				continue
+			}
 			result = append(result, buildClaudeEvent("content_block_delta", map[string]interface{}{
 				"index": ctx.ToolIndex, "delta": map[string]interface{}{"type": "input_json_delta", "partial_json": tc.Function.Arguments},
 			})...)
@@ -620,11 +654,11 @@ func OpenAIStreamToClaude(event []byte, ctx *transformer.StreamContext) ([]byte,
 			ctx.ContentBlockStarted = false
 		}
 		if ctx.ToolBlockStarted {
-			result = append(result, buildClaudeEvent("content_block_stop", map[string]interface{}{"index": ctx.ToolIndex})...)
+			result = append(result, closeToolBlock(ctx)...)
 			ctx.ToolBlockStarted = false
 		}
 		stopReason := "end_turn"
-		if *choice.FinishReason == "tool_calls" {
+		if *choice.FinishReason == "tool_calls" && (!ctx.ToolRepair || ctx.ToolUseEmitted) {
 			stopReason = "tool_use"
 		}
 		result = append(result, buildClaudeEvent("message_delta", map[string]interface{}{
@@ -637,6 +671,15 @@ func OpenAIStreamToClaude(event []byte, ctx *transformer.StreamContext) ([]byte,
 	return result, nil
 }
 
+// closeToolBlock ends the open tool_use block. With repair on, the held-back block is emitted
+// whole now that its arguments are complete.
+func closeToolBlock(ctx *transformer.StreamContext) []byte {
+	if !ctx.ToolRepair {
+		return buildClaudeEvent("content_block_stop", map[string]interface{}{"index": ctx.ToolIndex})
+	}
+	return toolBlockEvents(ctx, ctx.ToolIndex, ctx.CurrentToolID, ctx.CurrentToolName, ctx.ToolArguments)
+}
+
 // Helper functions
 
 func convertClaudeContentToOpenAI(content []interface{}) (interface{}, []transformer.OpenAIToolCall) {
@@ -125,6 +125,11 @@ func OpenAI2ReqToClaude(openai2Req []byte, model string) ([]byte, error) {
 		}
 	}
 
+	// Emulate JSON-schema output with a forced tool call
+	if spec := parseResponsesTextFormat(req.Text); spec != nil {
+		spec.applyToClaude(claudeReq)
+	}
+
 	return json.Marshal(claudeReq)
 }
 
@@ -153,6 +158,13 @@ func ClaudeRespToOpenAI2(claudeResp []byte) ([]byte, error) {
 			// Skip thinking blocks in response
 			continue
 		case "tool_use":
+			if isStructuredOutputTool(blockMap["name"]) {
+				outputContent = append(outputContent, map[string]interface{}{
+					"type": "output_text",
+					"text": structuredOutputText(blockMap["input"]),
+				})
+				continue
+			}
 			args, _ := json.Marshal(blockMap["input"])
 			functionCalls = append(functionCalls, map[string]interface{}{
 				"type":      "function_call",
@@ -287,7 +299,14 @@ func ClaudeStreamToOpenAI2(event []byte, ctx *transformer.StreamContext) ([]byte
 		idx, _ := data["index"].(float64)
 		blockIdx := int(idx)
 
-		switch block["type"] {
+		blockType := block["type"]
+		if blockType == "tool_use" && isStructuredOutputTool(block["name"]) {
+			// Structured output is streamed as a text message
+			blockType = "text"
+			ctx.StructuredOutput = true
+		}
+
+		switch blockType {
 		case "text":
 			ctx.ContentBlockStarted = true
 			ctx.ContentIndex = blockIdx
@@ -332,7 +351,17 @@ func ClaudeStreamToOpenAI2(event []byte, ctx *transformer.StreamContext) ([]byte
 				"content_index": 0, "delta": delta["text"],
 			})
 		case "input_json_delta":
-			partial := delta["partial_json"].(string)
+			partial, _ := delta["partial_json"].(string)
+			if ctx.ContentBlockStarted && !ctx.ToolBlockStarted {
+				// Structured output tool input, streamed as text
+				if partial != "" {
+					writeEvent(map[string]interface{}{
+						"type": "response.output_text.delta", "output_index": ctx.ContentIndex,
+						"content_index": 0, "delta": partial,
+					})
+				}
+				break
+			}
 			ctx.ToolArguments += partial
 			writeEvent(map[string]interface{}{
 				"type":         "response.function_call_arguments.delta",
@@ -390,13 +419,14 @@ func ClaudeStreamToOpenAI2(event []byte, ctx *transformer.StreamContext) ([]byte
 		}
 
 	case "message_stop":
+		inputTokens := ctx.EstimatedInputTokens()
 		writeEvent(map[string]interface{}{
 			"type": "response.completed",
 			"response": map[string]interface{}{
 				"id": ctx.MessageID, "object": "response", "status": "completed",
 				"usage": map[string]interface{}{
-					"input_tokens": ctx.InputTokens, "output_tokens": ctx.OutputTokens,
-					"total_tokens": ctx.InputTokens + ctx.OutputTokens,
+					"input_tokens": inputTokens, "output_tokens": ctx.OutputTokens,
+					"total_tokens": inputTokens + ctx.OutputTokens,
 				},
 			},
 		})
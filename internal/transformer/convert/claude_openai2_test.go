@@ -2,6 +2,7 @@ package convert
 
 import (
 	"encoding/json"
+	"fmt"
 	"strings"
 	"testing"
 
@@ -89,3 +90,35 @@ func TestOpenAI2StreamToClaudeWithThinking(t *testing.T) {
 		t.Fatalf("Unexpected think tags leaked into output")
 	}
 }
+
+func TestClaudeStreamToOpenAI2EstimatesInputTokensOnlyWhenMissing(t *testing.T) {
+	for _, reported := range []int{12, 0} {
+		estimates := 0
+		ctx := transformer.NewStreamContext()
+		ctx.InputTokensEstimate = func() int {
+			estimates++
+			return 40
+		}
+
+		var allEvents []string
+		for _, event := range []string{
+			fmt.Sprintf("event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"usage\":{\"input_tokens\":%d}}}\n\n", reported),
+			"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+		} {
+			events, err := ClaudeStreamToOpenAI2([]byte(event), ctx)
+			if err != nil {
+				t.Fatalf("ClaudeStreamToOpenAI2 failed: %v", err)
+			}
+			allEvents = append(allEvents, string(events))
+		}
+
+		want, wantEstimates := `"input_tokens":12`, 0
+		if reported == 0 {
+			want, wantEstimates = `"input_tokens":40`, 1
+		}
+		if estimates != wantEstimates {
+			t.Errorf("reported %d: estimated %d times, want %d", reported, estimates, wantEstimates)
+		}
+		assertContains(t, strings.Join(allEvents, ""), want, "Expected the reported or estimated input tokens")
+	}
+}
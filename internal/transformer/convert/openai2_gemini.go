@@ -39,6 +39,9 @@ func OpenAI2ReqToGemini(openai2Req []byte, model string) ([]byte, error) {
 	if len(genConfig) > 0 {
 		geminiReq["generationConfig"] = genConfig
 	}
+	if spec := parseResponsesTextFormat(req.Text); spec != nil {
+		spec.applyToGemini(geminiReq)
+	}
 
 	// Convert tools
 	if len(req.Tools) > 0 {
@@ -158,11 +161,12 @@ func GeminiStreamToOpenAI2(event []byte, ctx *transformer.StreamContext) ([]byte
 				writeEvent(map[string]interface{}{"type": "response.content_part.done", "output_index": 0, "content_index": 0, "part": map[string]interface{}{"type": "output_text"}})
 				writeEvent(map[string]interface{}{"type": "response.output_item.done", "output_index": 0, "item": map[string]interface{}{"type": "message", "role": "assistant", "status": "completed"}})
 			}
+			inputTokens := ctx.EstimatedInputTokens()
 			writeEvent(map[string]interface{}{
 				"type": "response.completed",
 				"response": map[string]interface{}{
 					"id": ctx.MessageID, "object": "response", "status": "completed",
-					"usage": map[string]interface{}{"input_tokens": ctx.InputTokens, "output_tokens": ctx.OutputTokens, "total_tokens": ctx.InputTokens + ctx.OutputTokens},
+					"usage": map[string]interface{}{"input_tokens": inputTokens, "output_tokens": ctx.OutputTokens, "total_tokens": inputTokens + ctx.OutputTokens},
 				},
 			})
 			result.WriteString("data: [DONE]\n\n")
@@ -247,11 +251,12 @@ func GeminiStreamToOpenAI2(event []byte, ctx *transformer.StreamContext) ([]byte
 			writeEvent(map[string]interface{}{"type": "response.output_item.done", "output_index": 0, "item": map[string]interface{}{"type": "message", "role": "assistant", "status": "completed"}})
 			ctx.ContentBlockStarted = false
 		}
+		inputTokens := ctx.EstimatedInputTokens()
 		writeEvent(map[string]interface{}{
 			"type": "response.completed",
 			"response": map[string]interface{}{
 				"id": ctx.MessageID, "object": "response", "status": "completed",
-				"usage": map[string]interface{}{"input_tokens": ctx.InputTokens, "output_tokens": ctx.OutputTokens, "total_tokens": ctx.InputTokens + ctx.OutputTokens},
+				"usage": map[string]interface{}{"input_tokens": inputTokens, "output_tokens": ctx.OutputTokens, "total_tokens": inputTokens + ctx.OutputTokens},
 			},
 		})
 		result.WriteString("data: [DONE]\n\n")
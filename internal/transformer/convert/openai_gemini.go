@@ -93,6 +93,9 @@ func OpenAIReqToGemini(openaiReq []byte, model string) ([]byte, error) {
 	if len(genConfig) > 0 {
 		geminiReq["generationConfig"] = genConfig
 	}
+	if spec := parseChatResponseFormat(req.ResponseFormat); spec != nil {
+		spec.applyToGemini(geminiReq)
+	}
 
 	// Convert tools
 	if len(req.Tools) > 0 {
@@ -0,0 +1,87 @@
+package convert
+
+import (
+	"bytes"
+
+	"github.com/lich0821/ccNexus/internal/transformer"
+)
+
+// Codex clients reach Ollama through the Claude format, so tool calls, images and thinking follow
+// the same mapping as for Claude Code clients.
+
+// OpenAIReqToOllama converts OpenAI Chat request to Ollama /api/chat request
+func OpenAIReqToOllama(openaiReq []byte, model string) ([]byte, error) {
+	claudeReq, err := OpenAIReqToClaude(openaiReq, model)
+	if err != nil {
+		return nil, err
+	}
+	return ClaudeReqToOllama(claudeReq, model)
+}
+
+// OllamaRespToOpenAI converts Ollama /api/chat response to OpenAI Chat response
+func OllamaRespToOpenAI(ollamaResp []byte, model string) ([]byte, error) {
+	claudeResp, err := OllamaRespToClaude(ollamaResp)
+	if err != nil {
+		return nil, err
+	}
+	return ClaudeRespToOpenAI(claudeResp, model)
+}
+
+// OllamaStreamToOpenAI converts one Ollama NDJSON stream line to OpenAI Chat stream chunks
+func OllamaStreamToOpenAI(event []byte, ctx *transformer.StreamContext, model string) ([]byte, error) {
+	return ollamaStreamThroughClaude(event, ctx, func(claudeEvent []byte) ([]byte, error) {
+		return ClaudeStreamToOpenAI(claudeEvent, ctx, model)
+	})
+}
+
+// OpenAI2ReqToOllama converts OpenAI Responses request to Ollama /api/chat request
+func OpenAI2ReqToOllama(openai2Req []byte, model string) ([]byte, error) {
+	claudeReq, err := OpenAI2ReqToClaude(openai2Req, model)
+	if err != nil {
+		return nil, err
+	}
+	return ClaudeReqToOllama(claudeReq, model)
+}
+
+// OllamaRespToOpenAI2 converts Ollama /api/chat response to OpenAI Responses response
+func OllamaRespToOpenAI2(ollamaResp []byte) ([]byte, error) {
+	claudeResp, err := OllamaRespToClaude(ollamaResp)
+	if err != nil {
+		return nil, err
+	}
+	return ClaudeRespToOpenAI2(claudeResp)
+}
+
+// OllamaStreamToOpenAI2 converts one Ollama NDJSON stream line to OpenAI Responses stream events
+func OllamaStreamToOpenAI2(event []byte, ctx *transformer.StreamContext) ([]byte, error) {
+	return ollamaStreamThroughClaude(event, ctx, func(claudeEvent []byte) ([]byte, error) {
+		return ClaudeStreamToOpenAI2(claudeEvent, ctx)
+	})
+}
+
+// ollamaStreamThroughClaude converts a stream line to Claude SSE events with its own context and
+// passes each event to the Claude to client converter
+func ollamaStreamThroughClaude(event []byte, ctx *transformer.StreamContext, toClient func([]byte) ([]byte, error)) ([]byte, error) {
+	if ctx.ClaudeStream == nil {
+		ctx.ClaudeStream = transformer.NewStreamContext()
+		ctx.ClaudeStream.ModelName = ctx.ModelName
+		ctx.ClaudeStream.EnableThinking = ctx.EnableThinking
+	}
+	claudeEvents, err := OllamaStreamToClaude(event, ctx.ClaudeStream)
+	if err != nil || len(claudeEvents) == 0 {
+		return nil, err
+	}
+
+	var result []byte
+	for _, claudeEvent := range bytes.SplitAfter(claudeEvents, []byte("\n\n")) {
+		if len(bytes.TrimSpace(claudeEvent)) == 0 {
+			continue
+		}
+		out, err := toClient(claudeEvent)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, out...)
+	}
+	return result, nil
+}
@@ -63,6 +63,10 @@ func OpenAIReqToOpenAI2(openaiReq []byte, model string) ([]byte, error) {
 		openai2Req["tools"] = tools
 	}
 
+	if spec := parseChatResponseFormat(req.ResponseFormat); spec != nil {
+		openai2Req["text"] = spec.responsesTextFormat()
+	}
+
 	return json.Marshal(openai2Req)
 }
 
@@ -143,6 +147,9 @@ func OpenAI2ReqToOpenAI(openai2Req []byte, model string) ([]byte, error) {
 	if req.MaxOutputTokens > 0 {
 		openaiReq.MaxCompletionTokens = req.MaxOutputTokens
 	}
+	if spec := parseResponsesTextFormat(req.Text); spec != nil {
+		openaiReq.ResponseFormat = spec.chatResponseFormat()
+	}
 
 	if len(req.Tools) > 0 {
 		for _, tool := range req.Tools {
@@ -297,11 +304,12 @@ func OpenAIStreamToOpenAI2(event []byte, ctx *transformer.StreamContext) ([]byte
 				writeEvent(map[string]interface{}{"type": "response.content_part.done", "output_index": 0, "content_index": 0, "part": map[string]interface{}{"type": "output_text"}})
 				writeEvent(map[string]interface{}{"type": "response.output_item.done", "output_index": 0, "item": map[string]interface{}{"type": "message", "role": "assistant", "status": "completed"}})
 			}
+			inputTokens := ctx.EstimatedInputTokens()
 			writeEvent(map[string]interface{}{
 				"type": "response.completed",
 				"response": map[string]interface{}{
 					"id": ctx.MessageID, "object": "response", "status": "completed",
-					"usage": map[string]interface{}{"input_tokens": ctx.InputTokens, "output_tokens": ctx.OutputTokens, "total_tokens": ctx.InputTokens + ctx.OutputTokens},
+					"usage": map[string]interface{}{"input_tokens": inputTokens, "output_tokens": ctx.OutputTokens, "total_tokens": inputTokens + ctx.OutputTokens},
 				},
 			})
 			result.WriteString("data: [DONE]\n\n")
@@ -402,11 +410,12 @@ func OpenAIStreamToOpenAI2(event []byte, ctx *transformer.StreamContext) ([]byte
 					"item": map[string]interface{}{"type": "function_call", "call_id": ctx.CurrentToolID, "name": ctx.CurrentToolName, "arguments": ctx.ToolArguments, "status": "completed"},
 				})
 			}
+			inputTokens := ctx.EstimatedInputTokens()
 			writeEvent(map[string]interface{}{
 				"type": "response.completed",
 				"response": map[string]interface{}{
 					"id": ctx.MessageID, "object": "response", "status": "completed",
-					"usage": map[string]interface{}{"input_tokens": ctx.InputTokens, "output_tokens": ctx.OutputTokens, "total_tokens": ctx.InputTokens + ctx.OutputTokens},
+					"usage": map[string]interface{}{"input_tokens": inputTokens, "output_tokens": ctx.OutputTokens, "total_tokens": inputTokens + ctx.OutputTokens},
 				},
 			})
 			result.WriteString("data: [DONE]\n\n")
@@ -0,0 +1,161 @@
+package convert
+
+import (
+	"encoding/json"
+)
+
+// structuredOutputToolName is the tool Claude is forced to call to emulate JSON-schema output.
+// Its input is unwrapped back into response text on the way out.
+const structuredOutputToolName = "structured_output"
+
+// structuredOutput is a JSON output request normalized from Chat response_format or Responses text.format
+type structuredOutput struct {
+	Name        string
+	Description string
+	Schema      map[string]interface{} // nil for plain JSON mode (json_object)
+	Strict      interface{}
+}
+
+// parseChatResponseFormat reads a Chat response_format, returning nil for plain text
+func parseChatResponseFormat(format interface{}) *structuredOutput {
+	m, ok := format.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	switch m["type"] {
+	case "json_object":
+		return &structuredOutput{}
+	case "json_schema":
+		spec := &structuredOutput{}
+		if js, ok := m["json_schema"].(map[string]interface{}); ok {
+			spec.Name, _ = js["name"].(string)
+			spec.Description, _ = js["description"].(string)
+			spec.Schema, _ = js["schema"].(map[string]interface{})
+			spec.Strict = js["strict"]
+		}
+		return spec
+	}
+	return nil
+}
+
+// parseResponsesTextFormat reads a Responses text config, returning nil for plain text
+func parseResponsesTextFormat(text interface{}) *structuredOutput {
+	m, ok := text.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	format, ok := m["format"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	switch format["type"] {
+	case "json_object":
+		return &structuredOutput{}
+	case "json_schema":
+		spec := &structuredOutput{}
+		spec.Name, _ = format["name"].(string)
+		spec.Description, _ = format["description"].(string)
+		spec.Schema, _ = format["schema"].(map[string]interface{})
+		spec.Strict = format["strict"]
+		return spec
+	}
+	return nil
+}
+
+// chatResponseFormat builds the Chat response_format for the spec
+func (s *structuredOutput) chatResponseFormat() map[string]interface{} {
+	if s.Schema == nil {
+		return map[string]interface{}{"type": "json_object"}
+	}
+	js := map[string]interface{}{"name": s.schemaName(), "schema": s.Schema}
+	if s.Description != "" {
+		js["description"] = s.Description
+	}
+	if s.Strict != nil {
+		js["strict"] = s.Strict
+	}
+	return map[string]interface{}{"type": "json_schema", "json_schema": js}
+}
+
+// responsesTextFormat builds the Responses text config for the spec
+func (s *structuredOutput) responsesTextFormat() map[string]interface{} {
+	if s.Schema == nil {
+		return map[string]interface{}{"format": map[string]interface{}{"type": "json_object"}}
+	}
+	format := map[string]interface{}{"type": "json_schema", "name": s.schemaName(), "schema": s.Schema}
+	if s.Description != "" {
+		format["description"] = s.Description
+	}
+	if s.Strict != nil {
+		format["strict"] = s.Strict
+	}
+	return map[string]interface{}{"format": format}
+}
+
+// schemaName returns the schema name, which OpenAI requires
+func (s *structuredOutput) schemaName() string {
+	if s.Name == "" {
+		return "response"
+	}
+	return s.Name
+}
+
+// applyToClaude adds a forced output tool to a Claude request. When the client also sent its own
+// tools, any tool may be called so the model can still use them before answering.
+// Forced tool use is incompatible with extended thinking, so thinking is dropped.
+func (s *structuredOutput) applyToClaude(claudeReq map[string]interface{}) {
+	schema := s.Schema
+	if schema == nil {
+		schema = map[string]interface{}{"type": "object"}
+	}
+	description := "Return the final response as structured output. Always call this tool to answer."
+	if s.Description != "" {
+		description = s.Description
+	}
+	tool := map[string]interface{}{
+		"name":         structuredOutputToolName,
+		"description":  description,
+		"input_schema": schema,
+	}
+
+	tools, _ := claudeReq["tools"].([]map[string]interface{})
+	if len(tools) > 0 {
+		if _, ok := claudeReq["tool_choice"]; !ok {
+			claudeReq["tool_choice"] = map[string]interface{}{"type": "any"}
+		}
+	} else {
+		claudeReq["tool_choice"] = map[string]interface{}{"type": "tool", "name": structuredOutputToolName}
+	}
+	claudeReq["tools"] = append(tools, tool)
+	delete(claudeReq, "thinking")
+}
+
+// applyToGemini sets JSON output on a Gemini request's generationConfig
+func (s *structuredOutput) applyToGemini(geminiReq map[string]interface{}) {
+	genConfig, ok := geminiReq["generationConfig"].(map[string]interface{})
+	if !ok {
+		genConfig = map[string]interface{}{}
+		geminiReq["generationConfig"] = genConfig
+	}
+	genConfig["responseMimeType"] = "application/json"
+	if s.Schema != nil {
+		genConfig["responseSchema"] = cleanSchemaForGemini(s.Schema)
+	}
+}
+
+// isStructuredOutputTool reports whether a Claude tool_use block name is the structured output tool
+func isStructuredOutputTool(name interface{}) bool {
+	return name == structuredOutputToolName
+}
+
+// structuredOutputText renders the input of the structured output tool as response text
+func structuredOutputText(input interface{}) string {
+	if input == nil {
+		return "{}"
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
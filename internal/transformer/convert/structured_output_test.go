@@ -0,0 +1,256 @@
+package convert
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/lich0821/ccNexus/internal/transformer"
+)
+
+const personSchema = `{
+	"type": "object",
+	"properties": {"name": {"type": "string"}, "age": {"type": "integer"}},
+	"required": ["name", "age"],
+	"additionalProperties": false
+}`
+
+func TestOpenAIReqToClaudeJSONSchema(t *testing.T) {
+	openaiReq := `{
+		"model": "gpt-4o",
+		"messages": [{"role": "user", "content": "Extract: Ann is 31"}],
+		"response_format": {"type": "json_schema", "json_schema": {"name": "person", "strict": true, "schema": ` + personSchema + `}}
+	}`
+
+	claudeReqBytes, err := OpenAIReqToClaude([]byte(openaiReq), "claude-sonnet-4")
+	if err != nil {
+		t.Fatalf("OpenAIReqToClaude failed: %v", err)
+	}
+
+	var claudeReq transformer.ClaudeRequest
+	if err := json.Unmarshal(claudeReqBytes, &claudeReq); err != nil {
+		t.Fatalf("Failed to unmarshal Claude request: %v", err)
+	}
+
+	if len(claudeReq.Tools) != 1 || claudeReq.Tools[0].Name != structuredOutputToolName {
+		t.Fatalf("Expected structured output tool, got %+v", claudeReq.Tools)
+	}
+	if claudeReq.Tools[0].InputSchema["required"] == nil {
+		t.Fatalf("Expected schema to be used as input_schema, got %v", claudeReq.Tools[0].InputSchema)
+	}
+	toolChoice, _ := claudeReq.ToolChoice.(map[string]interface{})
+	if toolChoice["type"] != "tool" || toolChoice["name"] != structuredOutputToolName {
+		t.Fatalf("Expected forced tool_choice, got %v", claudeReq.ToolChoice)
+	}
+}
+
+func TestOpenAI2ReqToClaudeJSONSchemaWithTools(t *testing.T) {
+	openai2Req := `{
+		"model": "gpt-4o",
+		"input": "Look up Ann and return her details",
+		"tools": [{"type": "function", "name": "lookup", "parameters": {"type": "object"}}],
+		"text": {"format": {"type": "json_schema", "name": "person", "schema": ` + personSchema + `}}
+	}`
+
+	claudeReqBytes, err := OpenAI2ReqToClaude([]byte(openai2Req), "claude-sonnet-4")
+	if err != nil {
+		t.Fatalf("OpenAI2ReqToClaude failed: %v", err)
+	}
+
+	var claudeReq transformer.ClaudeRequest
+	if err := json.Unmarshal(claudeReqBytes, &claudeReq); err != nil {
+		t.Fatalf("Failed to unmarshal Claude request: %v", err)
+	}
+
+	if len(claudeReq.Tools) != 2 || claudeReq.Tools[1].Name != structuredOutputToolName {
+		t.Fatalf("Expected client tool plus structured output tool, got %+v", claudeReq.Tools)
+	}
+	// Client tools stay callable, so any tool may be chosen
+	toolChoice, _ := claudeReq.ToolChoice.(map[string]interface{})
+	if toolChoice["type"] != "any" {
+		t.Fatalf("Expected tool_choice any, got %v", claudeReq.ToolChoice)
+	}
+}
+
+func TestClaudeRespToOpenAIStructuredOutput(t *testing.T) {
+	claudeResp := `{
+		"id": "msg_1",
+		"type": "message",
+		"role": "assistant",
+		"content": [{"type": "tool_use", "id": "toolu_1", "name": "structured_output", "input": {"name": "Ann", "age": 31}}],
+		"model": "claude-sonnet-4",
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 10, "output_tokens": 5}
+	}`
+
+	openaiRespBytes, err := ClaudeRespToOpenAI([]byte(claudeResp), "gpt-4o")
+	if err != nil {
+		t.Fatalf("ClaudeRespToOpenAI failed: %v", err)
+	}
+
+	var openaiResp transformer.OpenAIResponse
+	if err := json.Unmarshal(openaiRespBytes, &openaiResp); err != nil {
+		t.Fatalf("Failed to unmarshal OpenAI response: %v", err)
+	}
+
+	choice := openaiResp.Choices[0]
+	if choice.FinishReason != "stop" {
+		t.Fatalf("Expected finish_reason stop, got %s", choice.FinishReason)
+	}
+	if len(choice.Message.ToolCalls) != 0 {
+		t.Fatalf("Expected no tool calls, got %+v", choice.Message.ToolCalls)
+	}
+	var person map[string]interface{}
+	if err := json.Unmarshal([]byte(choice.Message.Content), &person); err != nil || person["name"] != "Ann" {
+		t.Fatalf("Expected JSON content, got %q", choice.Message.Content)
+	}
+}
+
+func TestClaudeRespToOpenAI2StructuredOutput(t *testing.T) {
+	claudeResp := `{
+		"id": "msg_1",
+		"type": "message",
+		"role": "assistant",
+		"content": [{"type": "tool_use", "id": "toolu_1", "name": "structured_output", "input": {"name": "Ann", "age": 31}}],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 10, "output_tokens": 5}
+	}`
+
+	openai2RespBytes, err := ClaudeRespToOpenAI2([]byte(claudeResp))
+	if err != nil {
+		t.Fatalf("ClaudeRespToOpenAI2 failed: %v", err)
+	}
+
+	body := string(openai2RespBytes)
+	assertContains(t, body, `"type":"output_text"`, "Expected output_text content")
+	assertContains(t, body, `{\"age\":31,\"name\":\"Ann\"}`, "Expected unwrapped JSON text")
+	assertNotContains(t, body, `"function_call"`, "Expected no function_call output")
+}
+
+// structuredOutputStream is a Claude stream answering through the structured output tool
+var structuredOutputStream = []string{
+	"event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"usage\":{\"input_tokens\":10}}}\n\n",
+	"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"structured_output\",\"input\":{}}}\n\n",
+	"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"name\\\": \\\"Ann\\\",\"}}\n\n",
+	"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\" \\\"age\\\": 31}\"}}\n\n",
+	"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n",
+	"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"},\"usage\":{\"output_tokens\":5}}\n\n",
+	"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+}
+
+func TestClaudeStreamToOpenAIStructuredOutput(t *testing.T) {
+	ctx := transformer.NewStreamContext()
+
+	var allChunks []string
+	for _, event := range structuredOutputStream {
+		chunk, err := ClaudeStreamToOpenAI([]byte(event), ctx, "gpt-4o")
+		if err != nil {
+			t.Fatalf("ClaudeStreamToOpenAI failed: %v", err)
+		}
+		allChunks = append(allChunks, string(chunk))
+	}
+
+	fullChunks := strings.Join(allChunks, "")
+	assertContains(t, fullChunks, `"content":"{\"name\": \"Ann\","`, "Expected first JSON fragment as content")
+	assertContains(t, fullChunks, `"content":" \"age\": 31}"`, "Expected second JSON fragment as content")
+	assertContains(t, fullChunks, `"finish_reason":"stop"`, "Expected finish_reason stop")
+	assertNotContains(t, fullChunks, `"tool_calls"`, "Expected no tool_calls chunks")
+}
+
+func TestClaudeStreamToOpenAI2StructuredOutput(t *testing.T) {
+	ctx := transformer.NewStreamContext()
+
+	var allEvents []string
+	for _, event := range structuredOutputStream {
+		events, err := ClaudeStreamToOpenAI2([]byte(event), ctx)
+		if err != nil {
+			t.Fatalf("ClaudeStreamToOpenAI2 failed: %v", err)
+		}
+		allEvents = append(allEvents, string(events))
+	}
+
+	fullEvents := strings.Join(allEvents, "")
+	assertContains(t, fullEvents, `"type":"message"`, "Expected message output item")
+	assertContains(t, fullEvents, `"delta":"{\"name\": \"Ann\","`, "Expected JSON fragment as output_text delta")
+	assertContains(t, fullEvents, `"type":"response.output_text.done"`, "Expected output_text.done")
+	assertNotContains(t, fullEvents, `function_call`, "Expected no function_call events")
+}
+
+func TestOpenAIReqToGeminiJSONSchema(t *testing.T) {
+	openaiReq := `{
+		"model": "gpt-4o",
+		"max_tokens": 100,
+		"messages": [{"role": "user", "content": "Extract: Ann is 31"}],
+		"response_format": {"type": "json_schema", "json_schema": {"name": "person", "schema": ` + personSchema + `}}
+	}`
+
+	geminiReqBytes, err := OpenAIReqToGemini([]byte(openaiReq), "gemini-2.5-flash")
+	if err != nil {
+		t.Fatalf("OpenAIReqToGemini failed: %v", err)
+	}
+
+	var geminiReq map[string]interface{}
+	if err := json.Unmarshal(geminiReqBytes, &geminiReq); err != nil {
+		t.Fatalf("Failed to unmarshal Gemini request: %v", err)
+	}
+
+	genConfig := geminiReq["generationConfig"].(map[string]interface{})
+	if genConfig["responseMimeType"] != "application/json" {
+		t.Fatalf("Expected JSON mime type, got %v", genConfig["responseMimeType"])
+	}
+	if genConfig["maxOutputTokens"] != float64(100) {
+		t.Fatalf("Expected maxOutputTokens to be kept, got %v", genConfig["maxOutputTokens"])
+	}
+	schema, ok := genConfig["responseSchema"].(map[string]interface{})
+	if !ok || schema["properties"] == nil {
+		t.Fatalf("Expected responseSchema, got %v", genConfig["responseSchema"])
+	}
+	if _, ok := schema["additionalProperties"]; ok {
+		t.Fatalf("Expected additionalProperties to be removed for Gemini")
+	}
+}
+
+func TestOpenAI2ReqToGeminiJSONObject(t *testing.T) {
+	openai2Req := `{"model": "gpt-4o", "input": "Give me JSON", "text": {"format": {"type": "json_object"}}}`
+
+	geminiReqBytes, err := OpenAI2ReqToGemini([]byte(openai2Req), "gemini-2.5-flash")
+	if err != nil {
+		t.Fatalf("OpenAI2ReqToGemini failed: %v", err)
+	}
+
+	body := string(geminiReqBytes)
+	assertContains(t, body, `"responseMimeType":"application/json"`, "Expected JSON mime type")
+	assertNotContains(t, body, `"responseSchema"`, "Expected no responseSchema for json_object")
+}
+
+func TestResponseFormatChatResponsesRoundTrip(t *testing.T) {
+	openaiReq := `{
+		"model": "gpt-4o",
+		"messages": [{"role": "user", "content": "Extract: Ann is 31"}],
+		"response_format": {"type": "json_schema", "json_schema": {"name": "person", "strict": true, "schema": ` + personSchema + `}}
+	}`
+
+	openai2ReqBytes, err := OpenAIReqToOpenAI2([]byte(openaiReq), "gpt-4o")
+	if err != nil {
+		t.Fatalf("OpenAIReqToOpenAI2 failed: %v", err)
+	}
+	body := string(openai2ReqBytes)
+	assertContains(t, body, `"text":{"format":{`, "Expected text.format")
+	assertContains(t, body, `"name":"person"`, "Expected schema name")
+	assertContains(t, body, `"strict":true`, "Expected strict flag")
+
+	openaiReqBytes, err := OpenAI2ReqToOpenAI(openai2ReqBytes, "gpt-4o")
+	if err != nil {
+		t.Fatalf("OpenAI2ReqToOpenAI failed: %v", err)
+	}
+
+	var roundTrip transformer.OpenAIRequest
+	if err := json.Unmarshal(openaiReqBytes, &roundTrip); err != nil {
+		t.Fatalf("Failed to unmarshal OpenAI request: %v", err)
+	}
+	format, _ := roundTrip.ResponseFormat.(map[string]interface{})
+	jsonSchema, _ := format["json_schema"].(map[string]interface{})
+	if format["type"] != "json_schema" || jsonSchema["name"] != "person" || jsonSchema["schema"] == nil {
+		t.Fatalf("Expected json_schema response_format after round trip, got %v", roundTrip.ResponseFormat)
+	}
+}
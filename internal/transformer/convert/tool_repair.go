@@ -0,0 +1,645 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lich0821/ccNexus/internal/logger"
+	"github.com/lich0821/ccNexus/internal/transformer"
+)
+
+// ToolInputSchemas returns the input_schema of each tool in a Claude request, by tool name.
+// Tools without a schema map to nil, so unknown tool names can still be told apart.
+func ToolInputSchemas(claudeReq []byte) map[string]interface{} {
+	var req struct {
+		Tools []struct {
+			Name        string      `json:"name"`
+			InputSchema interface{} `json:"input_schema"`
+		} `json:"tools"`
+	}
+	schemas := make(map[string]interface{})
+	if err := json.Unmarshal(claudeReq, &req); err != nil {
+		return schemas
+	}
+	for _, tool := range req.Tools {
+		if tool.Name != "" {
+			schemas[tool.Name] = tool.InputSchema
+		}
+	}
+	return schemas
+}
+
+// checkToolInput parses tool arguments leniently and repairs them against the tool's schema.
+// It returns the repaired input and the problems found in the arguments as the model sent them;
+// no problems means the arguments were valid.
+func checkToolInput(name string, args interface{}, schemas map[string]interface{}) (map[string]interface{}, []string) {
+	var problems []string
+	var value interface{}
+	switch a := args.(type) {
+	case string:
+		if strings.TrimSpace(a) == "" {
+			value = map[string]interface{}{}
+		} else if err := json.Unmarshal([]byte(a), &value); err != nil {
+			problems = append(problems, "arguments are not valid JSON")
+			value = parseLenientJSON(a)
+		}
+	case map[string]interface{}:
+		value = a
+		if a == nil {
+			value = map[string]interface{}{}
+		}
+	case nil:
+		value = map[string]interface{}{}
+	default:
+		value = a
+	}
+
+	// Arguments encoded twice arrive as a JSON string holding the object
+	if s, ok := value.(string); ok {
+		var inner interface{}
+		if json.Unmarshal([]byte(s), &inner) == nil {
+			value = inner
+		} else {
+			value = parseLenientJSON(s)
+		}
+		problems = append(problems, "arguments are a string instead of an object")
+	}
+	input, ok := value.(map[string]interface{})
+	if !ok {
+		problems = append(problems, "arguments are not an object")
+		input = map[string]interface{}{}
+	}
+
+	schema, known := schemas[name]
+	if !known {
+		return input, append(problems, fmt.Sprintf("unknown tool %q", name))
+	}
+	repaired, violations := repairSchemaValue(input, schema, "")
+	if m, ok := repaired.(map[string]interface{}); ok {
+		input = m
+	}
+	return input, append(problems, violations...)
+}
+
+// toolBlockEvents emits a complete tool_use block with repaired arguments. An invalid call is
+// sent as a text block instead when the context asks for it.
+func toolBlockEvents(ctx *transformer.StreamContext, index int, id, name string, args interface{}) []byte {
+	input, problems := checkToolInput(name, args, ctx.ToolSchemas)
+	var result []byte
+	if len(problems) > 0 {
+		ctx.ToolRepairFailed = true
+		logger.Warn("Tool call %s has invalid arguments: %s", name, strings.Join(problems, "; "))
+
+		if ctx.ToolRepairAsText {
+			result = append(result, buildClaudeEvent("content_block_start", map[string]interface{}{
+				"index": index, "content_block": map[string]interface{}{"type": "text", "text": ""},
+			})...)
+			result = append(result, buildClaudeEvent("content_block_delta", map[string]interface{}{
+				"index": index, "delta": map[string]interface{}{"type": "text_delta", "text": invalidToolCallText(name, args, problems)},
+			})...)
+			return append(result, buildClaudeEvent("content_block_stop", map[string]interface{}{"index": index})...)
+		}
+	}
+
+	ctx.ToolUseEmitted = true
+	data, _ := json.Marshal(input)
+	result = append(result, buildClaudeEvent("content_block_start", map[string]interface{}{
+		"index": index, "content_block": map[string]interface{}{"type": "tool_use", "id": id, "name": name, "input": map[string]interface{}{}},
+	})...)
+	result = append(result, buildClaudeEvent("content_block_delta", map[string]interface{}{
+		"index": index, "delta": map[string]interface{}{"type": "input_json_delta", "partial_json": string(data)},
+	})...)
+	return append(result, buildClaudeEvent("content_block_stop", map[string]interface{}{"index": index})...)
+}
+
+// RepairToolUses checks the tool_use blocks of a non-streaming Claude response against the tool
+// schemas. Invalid inputs are repaired, or replaced with text blocks when asText is set, in which
+// case a response left without tool calls ends its turn normally. failed reports whether any
+// tool call was invalid.
+func RepairToolUses(claudeResp []byte, schemas map[string]interface{}, asText bool) (result []byte, failed bool, err error) {
+	var resp map[string]interface{}
+	if err := json.Unmarshal(claudeResp, &resp); err != nil {
+		return claudeResp, false, err
+	}
+	content, _ := resp["content"].([]interface{})
+
+	toolUses := 0
+	for i, item := range content {
+		block, ok := item.(map[string]interface{})
+		if !ok || block["type"] != "tool_use" {
+			continue
+		}
+		name, _ := block["name"].(string)
+		input, problems := checkToolInput(name, block["input"], schemas)
+		if len(problems) == 0 {
+			toolUses++
+			continue
+		}
+
+		failed = true
+		logger.Warn("Tool call %s has invalid arguments: %s", name, strings.Join(problems, "; "))
+		if asText {
+			content[i] = map[string]interface{}{"type": "text", "text": invalidToolCallText(name, block["input"], problems)}
+			continue
+		}
+		block["input"] = input
+		toolUses++
+	}
+	if !failed {
+		return claudeResp, false, nil
+	}
+
+	if toolUses == 0 && resp["stop_reason"] == "tool_use" {
+		resp["stop_reason"] = "end_turn"
+	}
+	result, err = json.Marshal(resp)
+	return result, true, err
+}
+
+// invalidToolCallText describes a tool call that was turned into text
+func invalidToolCallText(name string, args interface{}, problems []string) string {
+	raw, ok := args.(string)
+	if !ok {
+		data, _ := json.Marshal(args)
+		raw = string(data)
+	}
+	return fmt.Sprintf("[Invalid call to tool %s: %s]\n%s", name, strings.Join(problems, "; "), raw)
+}
+
+// repairSchemaValue checks a value against a JSON schema and coerces it where the intent is
+// clear: numbers and booleans sent as strings, single values for arrays, objects sent as JSON
+// strings, enum values in the wrong case, unknown properties where none are allowed, and missing
+// required properties that have a default. It returns the repaired value and the violations
+// found in the original.
+func repairSchemaValue(value interface{}, schema interface{}, path string) (interface{}, []string) {
+	s, ok := schema.(map[string]interface{})
+	if !ok || len(s) == 0 {
+		return value, nil
+	}
+	where := path
+	if where == "" {
+		where = "input"
+	}
+
+	// anyOf/oneOf: keep the first alternative the value satisfies, or can be repaired into
+	for _, key := range []string{"anyOf", "oneOf"} {
+		alternatives, ok := s[key].([]interface{})
+		if !ok || len(alternatives) == 0 {
+			continue
+		}
+		// Repairs work in place, so alternatives are tried on copies
+		for _, alt := range alternatives {
+			if _, violations := repairSchemaValue(cloneJSON(value), alt, path); len(violations) == 0 {
+				return value, nil
+			}
+		}
+		for _, alt := range alternatives {
+			repaired, _ := repairSchemaValue(cloneJSON(value), alt, path)
+			if _, violations := repairSchemaValue(cloneJSON(repaired), alt, path); len(violations) == 0 {
+				return repaired, []string{fmt.Sprintf("%s matches none of the allowed schemas", where)}
+			}
+		}
+		return value, []string{fmt.Sprintf("%s matches none of the allowed schemas", where)}
+	}
+
+	var violations []string
+	if types := schemaTypes(s["type"]); len(types) > 0 && !matchesAnyType(value, types) {
+		violations = append(violations, fmt.Sprintf("%s should be %s", where, strings.Join(types, " or ")))
+		for _, t := range types {
+			if coerced, ok := coerceToType(value, t); ok {
+				value = coerced
+				break
+			}
+		}
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok && len(enum) > 0 && !inEnum(value, enum) {
+		violations = append(violations, fmt.Sprintf("%s is not one of the allowed values", where))
+		if str, ok := value.(string); ok {
+			for _, option := range enum {
+				if o, ok := option.(string); ok && strings.EqualFold(strings.TrimSpace(str), o) {
+					value = o
+					break
+				}
+			}
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		violations = append(violations, repairSchemaObject(v, s, path)...)
+	case []interface{}:
+		if items, ok := s["items"]; ok {
+			for i, item := range v {
+				repaired, itemViolations := repairSchemaValue(item, items, fmt.Sprintf("%s[%d]", where, i))
+				v[i] = repaired
+				violations = append(violations, itemViolations...)
+			}
+		}
+	}
+	return value, violations
+}
+
+// repairSchemaObject checks the properties of an object in place
+func repairSchemaObject(obj map[string]interface{}, s map[string]interface{}, path string) []string {
+	var violations []string
+	properties, _ := s["properties"].(map[string]interface{})
+
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		propPath := key
+		if path != "" {
+			propPath = path + "." + key
+		}
+		if propSchema, ok := properties[key]; ok {
+			repaired, propViolations := repairSchemaValue(obj[key], propSchema, propPath)
+			obj[key] = repaired
+			violations = append(violations, propViolations...)
+			continue
+		}
+		switch additional := s["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				violations = append(violations, fmt.Sprintf("unknown property %s", propPath))
+				delete(obj, key)
+			}
+		case map[string]interface{}:
+			repaired, propViolations := repairSchemaValue(obj[key], additional, propPath)
+			obj[key] = repaired
+			violations = append(violations, propViolations...)
+		}
+	}
+
+	required, _ := s["required"].([]interface{})
+	for _, item := range required {
+		key, ok := item.(string)
+		if !ok {
+			continue
+		}
+		if _, present := obj[key]; present {
+			continue
+		}
+		propPath := key
+		if path != "" {
+			propPath = path + "." + key
+		}
+		violations = append(violations, fmt.Sprintf("missing required property %s", propPath))
+		if propSchema, ok := properties[key].(map[string]interface{}); ok {
+			if def, ok := propSchema["default"]; ok {
+				obj[key] = def
+			}
+		}
+	}
+	return violations
+}
+
+// schemaTypes returns the types a schema allows
+func schemaTypes(t interface{}) []string {
+	switch v := t.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var types []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func matchesAnyType(value interface{}, types []string) bool {
+	for _, t := range types {
+		if matchesType(value, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesType(value interface{}, t string) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true // unknown types are not checked
+}
+
+// coerceToType converts a value to a schema type when the conversion loses nothing
+func coerceToType(value interface{}, t string) (interface{}, bool) {
+	str, isString := value.(string)
+	if isString {
+		str = strings.TrimSpace(str)
+	}
+	switch t {
+	case "number", "integer":
+		if isString {
+			if f, err := strconv.ParseFloat(str, 64); err == nil && (t == "number" || f == math.Trunc(f)) {
+				return f, true
+			}
+		}
+	case "boolean":
+		if isString {
+			if b, err := strconv.ParseBool(str); err == nil {
+				return b, true
+			}
+		}
+	case "string":
+		switch v := value.(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+	case "object":
+		if isString {
+			if m, ok := parseLenientJSON(str).(map[string]interface{}); ok {
+				return m, true
+			}
+		}
+	case "array":
+		if isString && strings.HasPrefix(str, "[") {
+			if a, ok := parseLenientJSON(str).([]interface{}); ok {
+				return a, true
+			}
+		}
+		if value != nil {
+			return []interface{}{value}, true
+		}
+	case "null":
+		if isString && (str == "" || str == "null") {
+			return nil, true
+		}
+	}
+	return value, false
+}
+
+// cloneJSON deep-copies a decoded JSON value
+func cloneJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = cloneJSON(item)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, item := range v {
+			a[i] = cloneJSON(item)
+		}
+		return a
+	}
+	return value
+}
+
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, option := range enum {
+		if option == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLenientJSON parses JSON the way weaker models tend to write it: wrapped in code fences,
+// truncated, with single quotes, unquoted keys, trailing or missing commas, and Python literals.
+// Unparseable input yields nil.
+func parseLenientJSON(s string) interface{} {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "```") {
+		s = strings.TrimPrefix(s, "```")
+		if i := strings.IndexByte(s, '\n'); i >= 0 && !strings.ContainsAny(s[:i], "{[") {
+			s = s[i+1:] // language tag
+		}
+		s = strings.TrimSuffix(strings.TrimSpace(s), "```")
+	}
+	if start := strings.IndexAny(s, "{["); start > 0 {
+		s = s[start:]
+	}
+	p := &lenientParser{src: []rune(s)}
+	return p.value()
+}
+
+// lenientParser is a forgiving recursive descent JSON parser. It never fails: at the end of the
+// input every open string, array and object is closed.
+type lenientParser struct {
+	src []rune
+	pos int
+}
+
+func (p *lenientParser) skipSpace() {
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		if unicode.IsSpace(r) {
+			p.pos++
+			continue
+		}
+		// Comments
+		if r == '/' && p.pos+1 < len(p.src) {
+			switch p.src[p.pos+1] {
+			case '/':
+				for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+					p.pos++
+				}
+				continue
+			case '*':
+				p.pos += 2
+				for p.pos < len(p.src) && !(p.src[p.pos-1] == '*' && p.src[p.pos] == '/') {
+					p.pos++
+				}
+				p.pos++
+				continue
+			}
+		}
+		return
+	}
+}
+
+func (p *lenientParser) peek() rune {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *lenientParser) value() interface{} {
+	switch r := p.peek(); {
+	case r == 0:
+		return nil
+	case r == '{':
+		return p.object()
+	case r == '[':
+		return p.array()
+	case r == '"' || r == '\'':
+		return p.string(r)
+	default:
+		return p.literal()
+	}
+}
+
+func (p *lenientParser) object() map[string]interface{} {
+	obj := make(map[string]interface{})
+	p.pos++ // {
+	for {
+		r := p.peek()
+		switch r {
+		case 0:
+			return obj
+		case '}':
+			p.pos++
+			return obj
+		case ',':
+			p.pos++
+			continue
+		}
+
+		var key string
+		if r == '"' || r == '\'' {
+			key = p.string(r)
+		} else {
+			key = p.bareWord()
+			if key == "" {
+				p.pos++ // skip a stray character
+				continue
+			}
+		}
+		if p.peek() != ':' {
+			if p.peek() == 0 {
+				return obj // truncated after the key
+			}
+			continue
+		}
+		p.pos++ // :
+		if p.peek() == 0 {
+			return obj // truncated before the value
+		}
+		obj[key] = p.value()
+	}
+}
+
+func (p *lenientParser) array() []interface{} {
+	arr := make([]interface{}, 0)
+	p.pos++ // [
+	for {
+		switch p.peek() {
+		case 0:
+			return arr
+		case ']':
+			p.pos++
+			return arr
+		case ',':
+			p.pos++
+			continue
+		case '}':
+			p.pos++ // mismatched bracket
+			continue
+		}
+		arr = append(arr, p.value())
+	}
+}
+
+func (p *lenientParser) string(quote rune) string {
+	var b strings.Builder
+	p.pos++ // opening quote
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		p.pos++
+		switch {
+		case r == quote:
+			return b.String()
+		case r == '\\' && p.pos < len(p.src):
+			e := p.src[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				b.WriteRune('\n')
+			case 't':
+				b.WriteRune('\t')
+			case 'r':
+				b.WriteRune('\r')
+			case 'b':
+				b.WriteRune('\b')
+			case 'f':
+				b.WriteRune('\f')
+			case 'u':
+				if p.pos+4 <= len(p.src) {
+					if code, err := strconv.ParseUint(string(p.src[p.pos:p.pos+4]), 16, 32); err == nil {
+						b.WriteRune(rune(code))
+						p.pos += 4
+						continue
+					}
+				}
+				b.WriteRune(e)
+			default:
+				b.WriteRune(e)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String() // truncated
+}
+
+// bareWord reads an unquoted key or literal
+func (p *lenientParser) bareWord() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		if r == ',' || r == ':' || r == '}' || r == ']' || unicode.IsSpace(r) {
+			break
+		}
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+func (p *lenientParser) literal() interface{} {
+	word := p.bareWord()
+	if word == "" {
+		p.pos++ // skip a stray character
+		return nil
+	}
+	switch strings.ToLower(word) {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null", "none", "nil", "undefined":
+		return nil
+	}
+	if f, err := strconv.ParseFloat(word, 64); err == nil {
+		return f
+	}
+	return word
+}
@@ -0,0 +1,180 @@
+package convert
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lich0821/ccNexus/internal/transformer"
+)
+
+const repairTestRequest = `{
+	"model": "claude-sonnet-4",
+	"tools": [{
+		"name": "read_file",
+		"input_schema": {
+			"type": "object",
+			"properties": {
+				"path": {"type": "string"},
+				"limit": {"type": "integer"},
+				"recursive": {"type": "boolean", "default": false},
+				"mode": {"type": "string", "enum": ["text", "binary"]},
+				"tags": {"type": "array", "items": {"type": "string"}}
+			},
+			"required": ["path", "recursive"],
+			"additionalProperties": false
+		}
+	}]
+}`
+
+func TestParseLenientJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  interface{}
+	}{
+		{"trailing comma", `{"a": 1, "b": [1, 2,],}`, map[string]interface{}{"a": 1.0, "b": []interface{}{1.0, 2.0}}},
+		{"single quotes", `{'path': 'a.go'}`, map[string]interface{}{"path": "a.go"}},
+		{"unquoted keys", `{path: "a.go", limit: 10}`, map[string]interface{}{"path": "a.go", "limit": 10.0}},
+		{"python literals", `{"a": True, "b": None}`, map[string]interface{}{"a": true, "b": nil}},
+		{"truncated string", `{"path": "src/ma`, map[string]interface{}{"path": "src/ma"}},
+		{"truncated after key", `{"path": "a.go", "limit"`, map[string]interface{}{"path": "a.go"}},
+		{"code fence", "```json\n{\"a\": 1}\n```", map[string]interface{}{"a": 1.0}},
+		{"missing comma", `{"a": 1 "b": 2}`, map[string]interface{}{"a": 1.0, "b": 2.0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseLenientJSON(tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLenientJSON(%q) = %#v, want %#v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckToolInput(t *testing.T) {
+	schemas := ToolInputSchemas([]byte(repairTestRequest))
+
+	input, problems := checkToolInput("read_file", `{"path": "a.go", "recursive": false}`, schemas)
+	if len(problems) != 0 {
+		t.Fatalf("valid arguments reported problems: %v", problems)
+	}
+	if input["path"] != "a.go" {
+		t.Errorf("path = %v, want a.go", input["path"])
+	}
+
+	input, problems = checkToolInput("read_file", `{'path': 'a.go', 'limit': '20', 'mode': 'TEXT', 'tags': 'x', 'extra': 1,`, schemas)
+	if len(problems) == 0 {
+		t.Fatal("malformed arguments reported no problems")
+	}
+	want := map[string]interface{}{
+		"path":      "a.go",
+		"limit":     20.0,
+		"recursive": false,
+		"mode":      "text",
+		"tags":      []interface{}{"x"},
+	}
+	if !reflect.DeepEqual(input, want) {
+		t.Errorf("repaired input = %#v, want %#v", input, want)
+	}
+
+	if _, problems := checkToolInput("write_file", `{}`, schemas); len(problems) == 0 {
+		t.Error("unknown tool reported no problems")
+	}
+}
+
+// streamToolCall runs an OpenAI stream with a single tool call through the converter
+func streamToolCall(t *testing.T, ctx *transformer.StreamContext, arguments []string) string {
+	t.Helper()
+	chunks := []string{`data: {"id":"1","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"read_file","arguments":""}}]}}]}`}
+	for _, arg := range arguments {
+		data, _ := json.Marshal(arg)
+		chunks = append(chunks, `data: {"id":"1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":`+string(data)+`}}]}}]}`)
+	}
+	chunks = append(chunks, `data: {"id":"1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`, `data: [DONE]`)
+
+	var events strings.Builder
+	for _, chunk := range chunks {
+		result, err := OpenAIStreamToClaude([]byte(chunk), ctx)
+		if err != nil {
+			t.Fatalf("OpenAIStreamToClaude failed: %v", err)
+		}
+		events.Write(result)
+	}
+	return events.String()
+}
+
+// toolInputFromEvents joins the input_json_delta fragments of a stream
+func toolInputFromEvents(t *testing.T, events string) map[string]interface{} {
+	t.Helper()
+	var partial strings.Builder
+	for _, part := range strings.Split(events, "\n\n") {
+		_, jsonData := parseSSE([]byte(part))
+		var event map[string]interface{}
+		if json.Unmarshal([]byte(jsonData), &event) != nil {
+			continue
+		}
+		if delta, ok := event["delta"].(map[string]interface{}); ok && delta["type"] == "input_json_delta" {
+			partial.WriteString(delta["partial_json"].(string))
+		}
+	}
+	var input map[string]interface{}
+	if err := json.Unmarshal([]byte(partial.String()), &input); err != nil {
+		t.Fatalf("tool input %q is not valid JSON: %v", partial.String(), err)
+	}
+	return input
+}
+
+func TestOpenAIStreamToClaudeRepairsToolArguments(t *testing.T) {
+	ctx := transformer.NewStreamContext()
+	ctx.ToolRepair = true
+	ctx.ToolSchemas = ToolInputSchemas([]byte(repairTestRequest))
+
+	events := streamToolCall(t, ctx, []string{`{"path": "a.go", `, `"limit": "5",}`})
+	if !ctx.ToolRepairFailed {
+		t.Error("invalid arguments were not reported")
+	}
+	input := toolInputFromEvents(t, events)
+	if input["limit"] != 5.0 || input["recursive"] != false {
+		t.Errorf("repaired input = %#v", input)
+	}
+	assertContains(t, events, `"stop_reason":"tool_use"`, "Expected tool_use stop reason")
+}
+
+func TestOpenAIStreamToClaudeInvalidToolCallAsText(t *testing.T) {
+	ctx := transformer.NewStreamContext()
+	ctx.ToolRepair = true
+	ctx.ToolRepairAsText = true
+	ctx.ToolSchemas = ToolInputSchemas([]byte(repairTestRequest))
+
+	events := streamToolCall(t, ctx, []string{`{"limit": 5`})
+	if strings.Contains(events, `"type":"tool_use"`) {
+		t.Error("invalid tool call was sent as tool_use")
+	}
+	assertContains(t, events, `Invalid call to tool read_file`, "Expected the tool call as text")
+	assertContains(t, events, `"stop_reason":"end_turn"`, "Expected end_turn without tool calls")
+}
+
+func TestRepairToolUses(t *testing.T) {
+	schemas := ToolInputSchemas([]byte(repairTestRequest))
+	openaiResp := `{"id":"1","model":"gpt","choices":[{"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"read_file","arguments":"{'path': 'a.go', 'recursive': 'true'"}}]},"finish_reason":"tool_calls"}]}`
+	claudeResp, err := OpenAIRespToClaude([]byte(openaiResp))
+	if err != nil {
+		t.Fatalf("OpenAIRespToClaude failed: %v", err)
+	}
+
+	repaired, failed, err := RepairToolUses(claudeResp, schemas, false)
+	if err != nil || !failed {
+		t.Fatalf("RepairToolUses = failed %v, err %v; want failed", failed, err)
+	}
+	var resp struct {
+		Content []struct {
+			Type  string                 `json:"type"`
+			Input map[string]interface{} `json:"input"`
+		} `json:"content"`
+	}
+	json.Unmarshal(repaired, &resp)
+	if len(resp.Content) != 1 || resp.Content[0].Input["recursive"] != true || resp.Content[0].Input["path"] != "a.go" {
+		t.Errorf("repaired response = %s", repaired)
+	}
+}
@@ -0,0 +1,29 @@
+package chat
+
+// AzureTransformer is a passthrough transformer for Codex Chat → Azure OpenAI Chat
+type AzureTransformer struct {
+	*OpenAITransformer
+}
+
+// NewAzureTransformer creates a new passthrough transformer
+func NewAzureTransformer(deployment string) *AzureTransformer {
+	return &AzureTransformer{OpenAITransformer: NewOpenAITransformer(deployment)}
+}
+
+func (t *AzureTransformer) Name() string {
+	return "cx_chat_azure"
+}
+
+// Azure2Transformer transforms Codex Chat requests to Azure OpenAI Responses format
+type Azure2Transformer struct {
+	*OpenAI2Transformer
+}
+
+// NewAzure2Transformer creates a new transformer
+func NewAzure2Transformer(deployment string) *Azure2Transformer {
+	return &Azure2Transformer{OpenAI2Transformer: NewOpenAI2Transformer(deployment)}
+}
+
+func (t *Azure2Transformer) Name() string {
+	return "cx_chat_azure2"
+}
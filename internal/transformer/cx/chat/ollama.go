@@ -0,0 +1,38 @@
+package chat
+
+import (
+	"github.com/lich0821/ccNexus/internal/transformer"
+	"github.com/lich0821/ccNexus/internal/transformer/convert"
+)
+
+// OllamaTransformer transforms Codex Chat requests to Ollama native chat format
+type OllamaTransformer struct {
+	model string
+}
+
+// NewOllamaTransformer creates a new transformer
+func NewOllamaTransformer(model string) *OllamaTransformer {
+	return &OllamaTransformer{model: model}
+}
+
+func (t *OllamaTransformer) Name() string {
+	return "cx_chat_ollama"
+}
+
+func (t *OllamaTransformer) TransformRequest(req []byte) ([]byte, error) {
+	return convert.OpenAIReqToOllama(req, t.model)
+}
+
+func (t *OllamaTransformer) TransformResponse(resp []byte, isStreaming bool) ([]byte, error) {
+	if isStreaming {
+		return nil, nil
+	}
+	return convert.OllamaRespToOpenAI(resp, t.model)
+}
+
+func (t *OllamaTransformer) TransformResponseWithContext(resp []byte, isStreaming bool, ctx *transformer.StreamContext) ([]byte, error) {
+	if isStreaming {
+		return convert.OllamaStreamToOpenAI(resp, ctx, t.model)
+	}
+	return convert.OllamaRespToOpenAI(resp, t.model)
+}
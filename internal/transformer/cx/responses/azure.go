@@ -0,0 +1,43 @@
+package responses
+
+import (
+	"encoding/json"
+)
+
+// AzureTransformer transforms Codex Responses requests to Azure OpenAI Chat format
+type AzureTransformer struct {
+	*OpenAITransformer
+}
+
+// NewAzureTransformer creates a new transformer
+func NewAzureTransformer(deployment string) *AzureTransformer {
+	return &AzureTransformer{OpenAITransformer: NewOpenAITransformer(deployment)}
+}
+
+func (t *AzureTransformer) Name() string {
+	return "cx_resp_azure"
+}
+
+// Azure2Transformer forwards Codex Responses requests to Azure OpenAI Responses.
+// Azure routes Responses requests by the model field, so it is replaced with the deployment name.
+type Azure2Transformer struct {
+	*OpenAI2Transformer
+}
+
+// NewAzure2Transformer creates a new transformer
+func NewAzure2Transformer(deployment string) *Azure2Transformer {
+	return &Azure2Transformer{OpenAI2Transformer: NewOpenAI2Transformer(deployment)}
+}
+
+func (t *Azure2Transformer) Name() string {
+	return "cx_resp_azure2"
+}
+
+func (t *Azure2Transformer) TransformRequest(req []byte) ([]byte, error) {
+	var body map[string]interface{}
+	if err := json.Unmarshal(req, &body); err != nil {
+		return nil, err
+	}
+	body["model"] = t.model
+	return json.Marshal(body)
+}
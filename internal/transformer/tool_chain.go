@@ -44,6 +44,11 @@ func (h *ToolChainHandler) AddToolCall(toolID, toolName string, input map[string
 	})
 }
 
+// AddContent adds any other content block, such as text or thinking, to the assistant message
+func (h *ToolChainHandler) AddContent(block map[string]interface{}) {
+	h.assistantMsgs = append(h.assistantMsgs, block)
+}
+
 // AddToolResult adds a tool result to the user message
 func (h *ToolChainHandler) AddToolResult(toolID string, result interface{}) {
 	h.toolMessages = append(h.toolMessages, map[string]interface{}{
@@ -53,6 +58,16 @@ func (h *ToolChainHandler) AddToolResult(toolID string, result interface{}) {
 	})
 }
 
+// AddToolError adds a failed tool result to the user message
+func (h *ToolChainHandler) AddToolError(toolID string, message string) {
+	h.toolMessages = append(h.toolMessages, map[string]interface{}{
+		"type":        "tool_result",
+		"tool_use_id": toolID,
+		"content":     message,
+		"is_error":    true,
+	})
+}
+
 // HasToolCalls checks if there are any tool calls
 func (h *ToolChainHandler) HasToolCalls() bool {
 	return len(h.assistantMsgs) > 0
@@ -69,6 +84,41 @@ func (h *ToolChainHandler) ExecuteChain() (io.Reader, error) {
 		return nil, fmt.Errorf("maximum tool chain depth (%d) exceeded", h.maxDepth)
 	}
 
+	newReqBody, err := h.BuildRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug("[ToolChain] Making recursive API call (depth: %d)", h.currentDepth+1)
+
+	// Make recursive API call
+	httpReq, err := http.NewRequest("POST", h.apiURL, bytes.NewReader(newReqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("x-api-key", h.apiKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("recursive API call failed: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("recursive API call failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return resp.Body, nil
+}
+
+// BuildRequest returns the original request continued with the assistant tool calls and
+// the user tool results
+func (h *ToolChainHandler) BuildRequest() ([]byte, error) {
 	// Parse original request
 	var req map[string]interface{}
 	if err := json.Unmarshal(h.originalReq, &req); err != nil {
@@ -104,32 +154,24 @@ func (h *ToolChainHandler) ExecuteChain() (io.Reader, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
+	return newReqBody, nil
+}
 
-	logger.Debug("[ToolChain] Making recursive API call (depth: %d)", h.currentDepth+1)
-
-	// Make recursive API call
-	httpReq, err := http.NewRequest("POST", h.apiURL, bytes.NewReader(newReqBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// Advance moves the chain to its next round: the continued request becomes the original
+// request, the collected calls and results are cleared, and the depth grows by one
+func (h *ToolChainHandler) Advance() ([]byte, error) {
+	if h.currentDepth >= h.maxDepth {
+		return nil, fmt.Errorf("maximum tool chain depth (%d) exceeded", h.maxDepth)
 	}
-
-	httpReq.Header.Set("x-api-key", h.apiKey)
-	httpReq.Header.Set("anthropic-version", "2023-06-01")
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
+	newReqBody, err := h.BuildRequest()
 	if err != nil {
-		return nil, fmt.Errorf("recursive API call failed: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		return nil, fmt.Errorf("recursive API call failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, err
 	}
-
-	return resp.Body, nil
+	h.originalReq = newReqBody
+	h.toolMessages = make([]map[string]interface{}, 0)
+	h.assistantMsgs = make([]map[string]interface{}, 0)
+	h.currentDepth++
+	return newReqBody, nil
 }
 
 // Reset resets the tool chain handler for reuse
@@ -43,6 +43,7 @@ type OpenAIRequest struct {
 	EnableThinking      bool            `json:"enable_thinking,omitempty"` // For models that support reasoning/thinking
 	Tools               []OpenAITool    `json:"tools,omitempty"`
 	ToolChoice          interface{}     `json:"tool_choice,omitempty"`
+	ResponseFormat      interface{}     `json:"response_format,omitempty"` // text, json_object or json_schema
 }
 
 // StreamOptions represents OpenAI stream options
@@ -202,6 +203,18 @@ type StreamContext struct {
 	InThinkingTag       bool   // Track if we are inside a <think> tag
 	ThinkingBuffer      string // Buffer for trailing partial tag detection
 	PendingThinkingText string // Buffered thinking text until closing tag arrives
+	// Structured output emulated with a forced Claude tool call
+	StructuredOutput bool // Structured output tool call was unwrapped into text
+	// Tool argument repair for weaker upstream models
+	ToolRepair       bool                   // Hold back tool arguments and check them when the block ends
+	ToolRepairAsText bool                   // Send invalid tool calls as text blocks instead of repairing them
+	ToolSchemas      map[string]interface{} // Tool input schemas from the request, by tool name
+	ToolRepairFailed bool                   // A tool call had invalid arguments
+	ToolUseEmitted   bool                   // A tool_use block was sent
+	// Upstreams converted through the Claude format
+	ClaudeStream *StreamContext // Context of the upstream to Claude step
+	// Fallback for upstreams that report no input tokens
+	InputTokensEstimate func() int // Estimates the input tokens from the request, only called when needed
 }
 
 // NewStreamContext creates a new stream context with default values
@@ -232,6 +245,15 @@ func NewStreamContext() *StreamContext {
 	}
 }
 
+// EstimatedInputTokens returns the input tokens reported so far, or the estimate when there are none
+func (ctx *StreamContext) EstimatedInputTokens() int {
+	if ctx.InputTokens == 0 && ctx.InputTokensEstimate != nil {
+		ctx.InputTokens = ctx.InputTokensEstimate()
+		ctx.InputTokensEstimate = nil
+	}
+	return ctx.InputTokens
+}
+
 // Gemini API structures
 
 // GeminiPart represents a part in Gemini format
@@ -361,6 +383,7 @@ type OpenAI2Request struct {
 	Stream          bool          `json:"stream,omitempty"`
 	MaxOutputTokens int           `json:"max_output_tokens,omitempty"`
 	Temperature     *float64      `json:"temperature,omitempty"`
+	Text            interface{}   `json:"text,omitempty"` // Output config, e.g. {"format": {"type": "json_schema", ...}}
 }
 
 // OpenAI2OutputItem represents an output item in Responses API response
@@ -398,3 +421,45 @@ type OpenAI2StreamEvent struct {
 	Part         *OpenAI2ContentPart `json:"part,omitempty"`
 	Delta        string              `json:"delta,omitempty"` // Direct string for text delta
 }
+
+// Ollama native API structures (/api/chat)
+
+// OllamaToolCall represents a tool call in Ollama format
+type OllamaToolCall struct {
+	Function struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	} `json:"function"`
+}
+
+// OllamaMessage represents a message in Ollama format
+type OllamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    []string         `json:"images,omitempty"` // Base64-encoded images
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"` // Tool name for role "tool"
+}
+
+// OllamaRequest represents an Ollama /api/chat request
+type OllamaRequest struct {
+	Model    string                 `json:"model"`
+	Messages []OllamaMessage        `json:"messages"`
+	Tools    []OpenAITool           `json:"tools,omitempty"` // Same shape as OpenAI function tools
+	Stream   bool                   `json:"stream"`
+	Think    *bool                  `json:"think,omitempty"`
+	Format   interface{}            `json:"format,omitempty"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+}
+
+// OllamaResponse represents an Ollama /api/chat response or NDJSON stream chunk
+type OllamaResponse struct {
+	Model           string        `json:"model"`
+	CreatedAt       string        `json:"created_at"`
+	Message         OllamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+	EvalCount       int           `json:"eval_count,omitempty"`
+}
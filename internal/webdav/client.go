@@ -306,3 +306,39 @@ func (c *Client) DeleteBackups(filenames []string, isConfig bool) error {
 
 	return nil
 }
+
+// WriteFile 写入任意路径的文件，自动创建所在目录
+func (c *Client) WriteFile(remotePath string, data []byte) error {
+	if err := c.ensureDirectory(path.Dir(remotePath)); err != nil {
+		return err
+	}
+	if err := c.client.Write(remotePath, data, 0644); err != nil {
+		return fmt.Errorf("Failed to upload file: %v", err)
+	}
+	return nil
+}
+
+// ReadFile 读取任意路径的文件
+func (c *Client) ReadFile(remotePath string) ([]byte, error) {
+	data, err := c.client.Read(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download file: %v", err)
+	}
+	return data, nil
+}
+
+// ReadDirNames 列出目录下的条目名称，目录不存在时返回空列表
+func (c *Client) ReadDirNames(dirPath string) ([]string, error) {
+	infos, err := c.client.ReadDir(dirPath)
+	if err != nil {
+		if gowebdav.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Failed to list directory: %v", err)
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names, nil
+}
@@ -1,14 +1,15 @@
 package webdav
 
 import (
-	"encoding/json"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/lich0821/ccNexus/internal/logger"
 )
 
+// archiveExt 压缩备份归档的后缀
+const archiveExt = ".ccnx"
+
 // Manager WebDAV 同步管理器
 type Manager struct {
 	client *Client
@@ -21,8 +22,11 @@ func NewManager(client *Client) *Manager {
 	}
 }
 
-// ensureDBExtension 确保文件名有 .db 后缀
+// ensureDBExtension 确保文件名有备份后缀：压缩归档保持 .ccnx，其余使用旧版的 .db
 func ensureDBExtension(filename string) string {
+	if strings.HasSuffix(filename, archiveExt) {
+		return filename
+	}
 	if strings.HasSuffix(filename, ".json") {
 		return strings.TrimSuffix(filename, ".json") + ".db"
 	}
@@ -32,57 +36,22 @@ func ensureDBExtension(filename string) string {
 	return filename + ".db"
 }
 
-// DatabaseBackupData represents metadata for database backups
-type DatabaseBackupData struct {
-	BackupTime time.Time `json:"backupTime"` // 备份时间
-	Version    string    `json:"version"`    // ccNexus 版本
-}
-
-// BackupDatabase backs up the database file to WebDAV
-func (m *Manager) BackupDatabase(dbPath string, version string, filename string) error {
+// BackupDatabase uploads a backup archive to WebDAV; the archive carries its own manifest
+func (m *Manager) BackupDatabase(archivePath string, filename string) error {
 	logger.Info("[WebDAV] Starting database backup: %s", filename)
 
-	// Read database file
-	logger.Info("[WebDAV] Reading database file: %s", dbPath)
-	dbData, err := os.ReadFile(dbPath)
+	data, err := os.ReadFile(archivePath)
 	if err != nil {
-		logger.Error("[WebDAV] Failed to read database file: %v", err)
+		logger.Error("[WebDAV] Failed to read backup archive: %v", err)
 		return err
 	}
-	logger.Info("[WebDAV] Database file read successfully: %d bytes", len(dbData))
-
-	// Create metadata
-	metadata := &DatabaseBackupData{
-		BackupTime: time.Now(),
-		Version:    version,
-	}
 
-	// Serialize metadata
-	metadataJSON, err := json.MarshalIndent(metadata, "", "  ")
-	if err != nil {
-		logger.Error("[WebDAV] Failed to serialize metadata: %v", err)
-		return err
-	}
-
-	// Upload database file (use .db extension)
 	dbFilename := ensureDBExtension(filename)
-	logger.Info("[WebDAV] Uploading database file: %s (%d bytes)", dbFilename, len(dbData))
-
-	if err := m.client.UploadBackup(dbFilename, dbData, true); err != nil {
-		logger.Error("[WebDAV] Failed to upload database: %v", err)
+	logger.Info("[WebDAV] Uploading backup archive: %s (%d bytes)", dbFilename, len(data))
+	if err := m.client.UploadBackup(dbFilename, data, true); err != nil {
+		logger.Error("[WebDAV] Failed to upload backup archive: %v", err)
 		return err
 	}
-	logger.Info("[WebDAV] Database uploaded successfully")
-
-	// Upload metadata file (use .meta.json extension)
-	metaFilename := dbFilename + ".meta.json"
-	logger.Info("[WebDAV] Uploading metadata file: %s", metaFilename)
-	if err := m.client.UploadBackup(metaFilename, metadataJSON, true); err != nil {
-		// Non-fatal: metadata upload failed, but database is uploaded
-		logger.Warn("[WebDAV] Failed to upload metadata: %v", err)
-	} else {
-		logger.Info("[WebDAV] Metadata uploaded successfully")
-	}
 
 	logger.Info("[WebDAV] Backup completed successfully")
 	return nil
@@ -90,7 +59,7 @@ func (m *Manager) BackupDatabase(dbPath string, version string, filename string)
 
 // RestoreDatabase downloads and restores the database file from WebDAV
 func (m *Manager) RestoreDatabase(filename string, targetPath string) error {
-	// Ensure filename has .db extension
+	// Ensure filename has a backup extension
 	dbFilename := ensureDBExtension(filename)
 
 	// Download database file
@@ -115,15 +84,15 @@ func (m *Manager) ListConfigBackups() ([]BackupFile, error) {
 		return nil, err
 	}
 
-	// Filter to only include .db files (exclude .meta.json files)
+	// Filter to only include backups (exclude .meta.json files)
 	var dbBackups []BackupFile
 	for _, backup := range allBackups {
 		// Skip metadata files
 		if strings.HasSuffix(backup.Filename, ".meta.json") {
 			continue
 		}
-		// Include .db files only
-		if strings.HasSuffix(backup.Filename, ".db") {
+		// Include .db files and archives only
+		if strings.HasSuffix(backup.Filename, ".db") || strings.HasSuffix(backup.Filename, archiveExt) {
 			dbBackups = append(dbBackups, backup)
 		}
 	}